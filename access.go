@@ -86,3 +86,12 @@ func (roles Roles) Access(permission PermissionName) Access {
 	}
 	return access
 }
+
+func (roles Roles) Has(roleId RoleId) bool {
+	for _, role := range roles {
+		if role.Id == roleId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package buzza
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrAccountDeletionScheduled = errors.New("account deletion already scheduled")
+	ErrAccountDeletionNotFound  = errors.New("account deletion not scheduled")
+)
+
+const DefaultAccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+// Deletion requested by the user, the account is erased once DeleteAt passes.
+type AccountDeletion struct {
+	UserId      UserId
+	RequestedAt time.Time
+	DeleteAt    time.Time
+}
+
+type AccountDeletionStore interface {
+	// ErrAccountDeletionScheduled if the deletion of the user is already scheduled.
+	Schedule(ctx context.Context, deletion AccountDeletion) error
+
+	// ErrAccountDeletionNotFound if the deletion of the user is not scheduled.
+	ByUserId(ctx context.Context, userId UserId) (AccountDeletion, error)
+
+	// ErrAccountDeletionNotFound if the deletion of the user is not scheduled.
+	Cancel(ctx context.Context, userId UserId) error
+
+	// Deletions with DeleteAt not after the time, oldest first.
+	Due(ctx context.Context, now time.Time, limit int) ([]AccountDeletion, error)
+
+	// Remove personal data of the user together with the scheduled deletion. Purchase records are kept
+	// with the personal data removed, so the user id stays reserved.
+	// ErrAccountDeletionNotFound if the deletion has been cancelled or is not due at the time.
+	Erase(ctx context.Context, userId UserId, now time.Time) error
+}
+
+type AccountDeleter struct {
+	Store         AccountDeletionStore
+	Sessions      SessionStore
+	ActivityStore ActivityStore
+	// DefaultAccountDeletionGracePeriod if 0.
+	GracePeriod time.Duration
+	// Defaults to time.Now.
+	Now func() time.Time
+}
+
+func (d *AccountDeleter) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
+func (d *AccountDeleter) gracePeriod() time.Duration {
+	if d.GracePeriod == 0 {
+		return DefaultAccountDeletionGracePeriod
+	}
+	return d.GracePeriod
+}
+
+// Log the user out everywhere and schedule the deletion after the grace period.
+// The user can log in again and cancel it until then.
+func (d *AccountDeleter) Schedule(ctx context.Context, userId UserId) (AccountDeletion, error) {
+	// repeated request of the user logged in during the grace period keeps the sessions
+	_, err := d.Store.ByUserId(ctx, userId)
+	if err == nil {
+		return AccountDeletion{}, ErrAccountDeletionScheduled
+	}
+	if !errors.Is(err, ErrAccountDeletionNotFound) {
+		return AccountDeletion{}, fmt.Errorf("get account deletion: %w", err)
+	}
+	// sessions go first, so a failed request does not leave them alive with the deletion scheduled
+	if _, err := d.Sessions.InvalidateByUserId(ctx, userId); err != nil {
+		return AccountDeletion{}, fmt.Errorf("invalidate sessions: %w", err)
+	}
+	now := d.now()
+	deletion := AccountDeletion{UserId: userId, RequestedAt: now, DeleteAt: now.Add(d.gracePeriod())}
+	if err := d.Store.Schedule(ctx, deletion); err != nil {
+		return AccountDeletion{}, err
+	}
+	if err := d.ActivityStore.AddLog(ctx, userId, AccountDeletionScheduledActivity()); err != nil {
+		return AccountDeletion{}, fmt.Errorf("add log: %w", err)
+	}
+	return deletion, nil
+}
+
+// Restore the account scheduled for deletion.
+func (d *AccountDeleter) Cancel(ctx context.Context, userId UserId) error {
+	if err := d.Store.Cancel(ctx, userId); err != nil {
+		return err
+	}
+	if err := d.ActivityStore.AddLog(ctx, userId, AccountDeletionCancelledActivity()); err != nil {
+		return fmt.Errorf("add log: %w", err)
+	}
+	return nil
+}
+
+// Erase accounts whose grace period has passed, returns number of erased accounts.
+func (d *AccountDeleter) EraseDue(ctx context.Context, batchSize int) (int, error) {
+	erased := 0
+	for {
+		now := d.now()
+		due, err := d.Store.Due(ctx, now, batchSize)
+		if err != nil {
+			return erased, fmt.Errorf("get due deletions: %w", err)
+		}
+		if len(due) == 0 {
+			return erased, nil
+		}
+		for _, deletion := range due {
+			// sessions created during the grace period are removed as well, before the deletion is gone,
+			// so a failure is retried on the next run
+			if _, err := d.Sessions.InvalidateByUserId(ctx, deletion.UserId); err != nil {
+				return erased, fmt.Errorf("invalidate sessions of user %d: %w", deletion.UserId, err)
+			}
+			err := d.Store.Erase(ctx, deletion.UserId, now)
+			if errors.Is(err, ErrAccountDeletionNotFound) {
+				// cancelled in the meantime
+				continue
+			}
+			if err != nil {
+				return erased, fmt.Errorf("erase user %d: %w", deletion.UserId, err)
+			}
+			erased++
+		}
+		if len(due) < batchSize {
+			return erased, nil
+		}
+	}
+}
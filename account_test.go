@@ -0,0 +1,148 @@
+package buzza
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAccountDeletionStore struct {
+	deletions map[UserId]AccountDeletion
+	erased    []UserId
+}
+
+func (s *testAccountDeletionStore) Schedule(ctx context.Context, deletion AccountDeletion) error {
+	if _, ok := s.deletions[deletion.UserId]; ok {
+		return ErrAccountDeletionScheduled
+	}
+	s.deletions[deletion.UserId] = deletion
+	return nil
+}
+
+func (s *testAccountDeletionStore) ByUserId(ctx context.Context, userId UserId) (AccountDeletion, error) {
+	deletion, ok := s.deletions[userId]
+	if !ok {
+		return AccountDeletion{}, ErrAccountDeletionNotFound
+	}
+	return deletion, nil
+}
+
+func (s *testAccountDeletionStore) Cancel(ctx context.Context, userId UserId) error {
+	if _, ok := s.deletions[userId]; !ok {
+		return ErrAccountDeletionNotFound
+	}
+	delete(s.deletions, userId)
+	return nil
+}
+
+func (s *testAccountDeletionStore) Due(ctx context.Context, now time.Time, limit int) ([]AccountDeletion, error) {
+	due := make([]AccountDeletion, 0)
+	for _, deletion := range s.deletions {
+		if !deletion.DeleteAt.After(now) {
+			due = append(due, deletion)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].DeleteAt.Before(due[j].DeleteAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (s *testAccountDeletionStore) Erase(ctx context.Context, userId UserId, now time.Time) error {
+	deletion, ok := s.deletions[userId]
+	if !ok || deletion.DeleteAt.After(now) {
+		return ErrAccountDeletionNotFound
+	}
+	delete(s.deletions, userId)
+	s.erased = append(s.erased, userId)
+	return nil
+}
+
+type testSessionInvalidator struct {
+	SessionStore
+	invalidated []UserId
+	err         error
+}
+
+func (s *testSessionInvalidator) InvalidateByUserId(ctx context.Context, userId UserId) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.invalidated = append(s.invalidated, userId)
+	return 1, nil
+}
+
+func TestAccountDeleter(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &testAccountDeletionStore{deletions: map[UserId]AccountDeletion{}}
+	sessions := &testSessionInvalidator{}
+	activities := &testActivityStore{}
+	deleter := &AccountDeleter{
+		Store:         store,
+		Sessions:      sessions,
+		ActivityStore: activities,
+		GracePeriod:   7 * 24 * time.Hour,
+		Now:           func() time.Time { return now },
+	}
+
+	deletion, err := deleter.Schedule(ctx, 1)
+	if assert.NoError(err) {
+		assert.Equal(AccountDeletion{UserId: 1, RequestedAt: now, DeleteAt: now.Add(7 * 24 * time.Hour)}, deletion)
+	}
+	assert.Equal([]UserId{1}, sessions.invalidated, "user is logged out everywhere")
+	_, err = deleter.Schedule(ctx, 1)
+	assert.ErrorIs(err, ErrAccountDeletionScheduled)
+	assert.Equal([]UserId{1}, sessions.invalidated, "repeated request does not log the user out")
+	_, err = deleter.Schedule(ctx, 2)
+	assert.NoError(err)
+
+	assert.ErrorIs(deleter.Cancel(ctx, 3), ErrAccountDeletionNotFound)
+	assert.NoError(deleter.Cancel(ctx, 2))
+	if assert.Len(activities.logs, 3) {
+		assert.Equal(ActivityAccountDeletionScheduled, activities.logs[0].Name)
+		assert.Equal(ActivityAccountDeletionCancelled, activities.logs[2].Name)
+	}
+
+	erased, err := deleter.EraseDue(ctx, 1)
+	if assert.NoError(err) {
+		assert.Zero(erased, "grace period has not passed yet")
+	}
+
+	now = now.Add(7 * 24 * time.Hour)
+	_, err = deleter.Schedule(ctx, 3)
+	assert.NoError(err)
+	sessions.invalidated = nil
+	erased, err = deleter.EraseDue(ctx, 1)
+	if assert.NoError(err) {
+		assert.Equal(1, erased)
+	}
+	assert.Equal([]UserId{1}, store.erased, "cancelled and not due deletions are kept")
+	assert.Equal([]UserId{1}, sessions.invalidated, "sessions created during the grace period are removed")
+
+	_, err = deleter.Schedule(ctx, 5)
+	assert.NoError(err)
+	now = now.Add(7 * 24 * time.Hour)
+	sessions.err = errors.New("session store unavailable")
+	_, err = deleter.EraseDue(ctx, 10)
+	assert.Error(err)
+	_, err = store.ByUserId(ctx, 5)
+	assert.NoError(err, "deletion is kept until sessions are removed")
+	sessions.err = nil
+
+	sessions.err = errors.New("session store unavailable")
+	_, err = deleter.Schedule(ctx, 4)
+	assert.Error(err)
+	_, err = store.ByUserId(ctx, 4)
+	assert.ErrorIs(err, ErrAccountDeletionNotFound, "deletion is not scheduled while sessions are alive")
+	sessions.err = nil
+	_, err = deleter.Schedule(ctx, 4)
+	assert.NoError(err, "failed request can be retried")
+}
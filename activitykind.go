@@ -0,0 +1,576 @@
+package buzza
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	ActivitySessionCreated           = "session_created"
+	ActivitySessionChangedIp         = "session_changed_ip"
+	ActivitySessionChangedUserAgent  = "session_changed_user_agent"
+	ActivitySessionRevokedByAlert    = "session_revoked_by_alert"
+	ActivitySessionTrusted           = "session_trusted"
+	ActivitySessionUntrusted         = "session_untrusted"
+	ActivitySessionEvicted           = "session_evicted"
+	ActivityAuditLogQueried          = "audit_log_queried"
+	ActivityAuditLogExported         = "audit_log_exported"
+	ActivityTwoFactorEnabled         = "two_factor_enabled"
+	ActivityTwoFactorDisabled        = "two_factor_disabled"
+	ActivityRecoveryCodeUsed         = "two_factor_recovery_code_used"
+	ActivityPasskeyAdded             = "passkey_added"
+	ActivityPasskeyRemoved           = "passkey_removed"
+	ActivityIdentityLinked           = "identity_linked"
+	ActivityIdentityUnlinked         = "identity_unlinked"
+	ActivityAccountDeletionScheduled = "account_deletion_scheduled"
+	ActivityAccountDeletionCancelled = "account_deletion_cancelled"
+)
+
+var ErrUnknownActivity = errors.New("unknown activity")
+
+type ActivityFieldType string
+
+const (
+	ActivityFieldString ActivityFieldType = "string"
+	ActivityFieldNumber ActivityFieldType = "number"
+	ActivityFieldBool   ActivityFieldType = "bool"
+)
+
+type ActivityField struct {
+	Name     string
+	Type     ActivityFieldType
+	Optional bool
+}
+
+// Payload shape of the single activity kind version.
+type ActivitySchema struct {
+	Fields []ActivityField
+	// Converts payload of this version to the next one. Required for all schemas except the latest.
+	Upgrade func(data map[string]interface{}) map[string]interface{}
+}
+
+func (s ActivitySchema) Validate(data map[string]interface{}) error {
+	for _, field := range s.Fields {
+		value, ok := data[field.Name]
+		if !ok {
+			if field.Optional {
+				continue
+			}
+			return fmt.Errorf("missing field '%s'", field.Name)
+		}
+		if !field.Type.matches(value) {
+			return fmt.Errorf("field '%s' is not a %s", field.Name, field.Type)
+		}
+	}
+	for name := range data {
+		if !s.hasField(name) {
+			return fmt.Errorf("unknown field '%s'", name)
+		}
+	}
+	return nil
+}
+
+func (s ActivitySchema) hasField(name string) bool {
+	for _, field := range s.Fields {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t ActivityFieldType) matches(value interface{}) bool {
+	switch t {
+	case ActivityFieldString:
+		_, ok := value.(string)
+		return ok
+	case ActivityFieldBool:
+		_, ok := value.(bool)
+		return ok
+	case ActivityFieldNumber:
+		// numbers read back from json are always float64
+		switch value.(type) {
+		case int, int32, int64, uint, uint32, uint64, float32, float64:
+			return true
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+}
+
+type ActivityKind struct {
+	Name string
+	// Payload schemas by version, versions start at 1 and the last one is current.
+	Schemas []ActivitySchema
+	// Human-readable description templates by locale. "{field}" is replaced with the payload field.
+	Descriptions map[string]string
+}
+
+// Current payload version of the kind.
+func (k ActivityKind) Version() int {
+	return len(k.Schemas)
+}
+
+type ActivityCatalogue map[string]ActivityKind
+
+func NewActivityCatalogue(kinds ...ActivityKind) ActivityCatalogue {
+	catalogue := make(ActivityCatalogue, len(kinds))
+	for _, kind := range kinds {
+		if _, ok := catalogue[kind.Name]; ok {
+			panic("Duplicated activity kind: `" + kind.Name + "`!")
+		}
+		if len(kind.Schemas) == 0 {
+			panic("Activity kind `" + kind.Name + "` without schema!")
+		}
+		for i, schema := range kind.Schemas[:len(kind.Schemas)-1] {
+			if schema.Upgrade == nil {
+				panic(fmt.Sprintf("Activity kind `%s` version %d without upgrade!", kind.Name, i+1))
+			}
+		}
+		catalogue[kind.Name] = kind
+	}
+	return catalogue
+}
+
+// Validate activity payload against the current version of its kind.
+// Returns activity with the version set.
+func (c ActivityCatalogue) Validate(activity Activity) (Activity, error) {
+	kind, ok := c[activity.Name]
+	if !ok {
+		return Activity{}, fmt.Errorf("%w '%s'", ErrUnknownActivity, activity.Name)
+	}
+	if activity.Version == 0 {
+		activity.Version = kind.Version()
+	}
+	if activity.Version != kind.Version() {
+		return Activity{}, fmt.Errorf("activity '%s' version %d is outdated", activity.Name, activity.Version)
+	}
+	if err := kind.Schemas[activity.Version-1].Validate(activity.Data); err != nil {
+		return Activity{}, fmt.Errorf("invalid '%s' activity: %w", activity.Name, err)
+	}
+	return activity, nil
+}
+
+// Log payload upgraded to the current version of its kind.
+func (c ActivityCatalogue) Upgrade(log ActivityLog) ActivityLog {
+	kind, ok := c[log.Name]
+	if !ok || log.Version < 1 {
+		return log
+	}
+	for log.Version < kind.Version() {
+		log.Data = kind.Schemas[log.Version-1].Upgrade(log.Data)
+		log.Version++
+	}
+	return log
+}
+
+// Localized description of the log. Falls back to the default locale and
+// returns empty string if kind is unknown.
+func (c ActivityCatalogue) Describe(log ActivityLog, locale string) string {
+	kind, ok := c[log.Name]
+	if !ok {
+		return ""
+	}
+	template, ok := kind.Descriptions[locale]
+	if !ok {
+		template = kind.Descriptions[DefaultActivityLocale]
+	}
+	log = c.Upgrade(log)
+
+	// sorted for the deterministic replacement order
+	names := make([]string, 0, len(log.Data))
+	for name := range log.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	replacements := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		replacements = append(replacements, "{"+name+"}", fmt.Sprint(log.Data[name]))
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+const DefaultActivityLocale = "en"
+
+var ActivityLocales = []string{"en", "pl"}
+
+var DefaultActivityCatalogue = NewActivityCatalogue(
+	ActivityKind{
+		Name: ActivitySessionCreated,
+		Schemas: []ActivitySchema{
+			{
+				Fields: []ActivityField{
+					{Name: "session_id", Type: ActivityFieldString},
+					{Name: "ip", Type: ActivityFieldString},
+					{Name: "userAgent", Type: ActivityFieldString},
+					{Name: "country", Type: ActivityFieldString, Optional: true},
+					{Name: "city", Type: ActivityFieldString, Optional: true},
+					{Name: "asn", Type: ActivityFieldNumber, Optional: true},
+				},
+				Upgrade: func(data map[string]interface{}) map[string]interface{} {
+					data = copyActivityData(data)
+					data["device"] = describeUserAgent(fmt.Sprint(data["userAgent"]))
+					return data
+				},
+			},
+			{Fields: []ActivityField{
+				{Name: "session_id", Type: ActivityFieldString},
+				{Name: "ip", Type: ActivityFieldString},
+				{Name: "userAgent", Type: ActivityFieldString},
+				{Name: "device", Type: ActivityFieldString},
+				{Name: "country", Type: ActivityFieldString, Optional: true},
+				{Name: "city", Type: ActivityFieldString, Optional: true},
+				{Name: "asn", Type: ActivityFieldNumber, Optional: true},
+			}},
+		},
+		Descriptions: map[string]string{
+			"en": "Signed in from {ip} ({device}).",
+			"pl": "Zalogowano z {ip} ({device}).",
+		},
+	},
+	ActivityKind{
+		Name: ActivitySessionChangedIp,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "session_id", Type: ActivityFieldString},
+			{Name: "previous_ip", Type: ActivityFieldString},
+			{Name: "new_ip", Type: ActivityFieldString},
+			{Name: "country", Type: ActivityFieldString, Optional: true},
+			{Name: "city", Type: ActivityFieldString, Optional: true},
+			{Name: "asn", Type: ActivityFieldNumber, Optional: true},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Session IP address changed from {previous_ip} to {new_ip}.",
+			"pl": "Adres IP sesji zmienił się z {previous_ip} na {new_ip}.",
+		},
+	},
+	ActivityKind{
+		Name: ActivitySessionChangedUserAgent,
+		Schemas: []ActivitySchema{
+			{
+				Fields: []ActivityField{
+					{Name: "session_id", Type: ActivityFieldString},
+					{Name: "previous_user_agent", Type: ActivityFieldString},
+					{Name: "new_user_agent", Type: ActivityFieldString},
+				},
+				Upgrade: func(data map[string]interface{}) map[string]interface{} {
+					data = copyActivityData(data)
+					data["previous_device"] = describeUserAgent(fmt.Sprint(data["previous_user_agent"]))
+					data["new_device"] = describeUserAgent(fmt.Sprint(data["new_user_agent"]))
+					return data
+				},
+			},
+			{Fields: []ActivityField{
+				{Name: "session_id", Type: ActivityFieldString},
+				{Name: "previous_user_agent", Type: ActivityFieldString},
+				{Name: "new_user_agent", Type: ActivityFieldString},
+				{Name: "previous_device", Type: ActivityFieldString},
+				{Name: "new_device", Type: ActivityFieldString},
+			}},
+		},
+		Descriptions: map[string]string{
+			"en": "Session device changed from {previous_device} to {new_device}.",
+			"pl": "Urządzenie sesji zmieniło się z {previous_device} na {new_device}.",
+		},
+	},
+	ActivityKind{
+		Name: ActivitySessionRevokedByAlert,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "session_id", Type: ActivityFieldString},
+			{Name: "ip", Type: ActivityFieldString},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Session signed out from the login alert by {ip}.",
+			"pl": "Sesja wylogowana z powiadomienia o logowaniu przez {ip}.",
+		},
+	},
+	ActivityKind{
+		Name: ActivitySessionTrusted,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "session_id", Type: ActivityFieldString},
+			{Name: "device", Type: ActivityFieldString},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Marked the session ({device}) as trusted.",
+			"pl": "Oznaczono sesję ({device}) jako zaufaną.",
+		},
+	},
+	ActivityKind{
+		Name: ActivitySessionUntrusted,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "session_id", Type: ActivityFieldString},
+			{Name: "device", Type: ActivityFieldString},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Removed trust from the session ({device}).",
+			"pl": "Cofnięto zaufanie do sesji ({device}).",
+		},
+	},
+	ActivityKind{
+		Name: ActivitySessionEvicted,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "session_id", Type: ActivityFieldString},
+			{Name: "device", Type: ActivityFieldString},
+			{Name: "limit", Type: ActivityFieldNumber},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Signed out the oldest session ({device}) after reaching the limit of {limit} sessions.",
+			"pl": "Wylogowano najstarszą sesję ({device}) po osiągnięciu limitu {limit} sesji.",
+		},
+	},
+	ActivityKind{
+		Name: ActivityAuditLogQueried,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "query", Type: ActivityFieldString},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Searched the audit log ({query}).",
+			"pl": "Przeszukano dziennik audytu ({query}).",
+		},
+	},
+	ActivityKind{
+		Name: ActivityAuditLogExported,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "query", Type: ActivityFieldString},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Exported the audit log ({query}).",
+			"pl": "Wyeksportowano dziennik audytu ({query}).",
+		},
+	},
+	ActivityKind{
+		Name:    ActivityTwoFactorEnabled,
+		Schemas: []ActivitySchema{{}},
+		Descriptions: map[string]string{
+			"en": "Enabled two-factor authentication.",
+			"pl": "Włączono uwierzytelnianie dwuskładnikowe.",
+		},
+	},
+	ActivityKind{
+		Name:    ActivityTwoFactorDisabled,
+		Schemas: []ActivitySchema{{}},
+		Descriptions: map[string]string{
+			"en": "Disabled two-factor authentication.",
+			"pl": "Wyłączono uwierzytelnianie dwuskładnikowe.",
+		},
+	},
+	ActivityKind{
+		Name: ActivityRecoveryCodeUsed,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "codes_left", Type: ActivityFieldNumber},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Signed in with a recovery code, {codes_left} codes left.",
+			"pl": "Zalogowano kodem odzyskiwania, pozostało kodów: {codes_left}.",
+		},
+	},
+	ActivityKind{
+		Name: ActivityPasskeyAdded,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "name", Type: ActivityFieldString},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Added passkey {name}.",
+			"pl": "Dodano klucz dostępu {name}.",
+		},
+	},
+	ActivityKind{
+		Name: ActivityPasskeyRemoved,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "name", Type: ActivityFieldString},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Removed passkey {name}.",
+			"pl": "Usunięto klucz dostępu {name}.",
+		},
+	},
+	ActivityKind{
+		Name: ActivityIdentityLinked,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "provider", Type: ActivityFieldString},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Linked {provider} account.",
+			"pl": "Połączono konto {provider}.",
+		},
+	},
+	ActivityKind{
+		Name: ActivityIdentityUnlinked,
+		Schemas: []ActivitySchema{{Fields: []ActivityField{
+			{Name: "provider", Type: ActivityFieldString},
+		}}},
+		Descriptions: map[string]string{
+			"en": "Unlinked {provider} account.",
+			"pl": "Odłączono konto {provider}.",
+		},
+	},
+	ActivityKind{
+		Name:    ActivityAccountDeletionScheduled,
+		Schemas: []ActivitySchema{{}},
+		Descriptions: map[string]string{
+			"en": "Scheduled account deletion.",
+			"pl": "Zaplanowano usunięcie konta.",
+		},
+	},
+	ActivityKind{
+		Name:    ActivityAccountDeletionCancelled,
+		Schemas: []ActivitySchema{{}},
+		Descriptions: map[string]string{
+			"en": "Cancelled account deletion.",
+			"pl": "Anulowano usunięcie konta.",
+		},
+	},
+)
+
+func SessionCreatedActivity(sessionId string, ip string, userAgent string) Activity {
+	return Activity{Name: ActivitySessionCreated, Version: 2, Data: map[string]interface{}{
+		"session_id": sessionId,
+		"ip":         ip,
+		"userAgent":  userAgent,
+		"device":     describeUserAgent(userAgent),
+	}}
+}
+
+func SessionChangedIpActivity(sessionId string, previousIp string, newIp string) Activity {
+	return Activity{Name: ActivitySessionChangedIp, Version: 1, Data: map[string]interface{}{
+		"session_id":  sessionId,
+		"previous_ip": previousIp,
+		"new_ip":      newIp,
+	}}
+}
+
+func copyActivityData(data map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data)+3)
+	for k, v := range data {
+		copied[k] = v
+	}
+	return copied
+}
+
+// Activity with the location of its IP. Location fields are optional,
+// so the activity is unchanged if the location is unknown.
+func WithGeoLocation(activity Activity, location GeoLocation) Activity {
+	if location.IsZero() {
+		return activity
+	}
+	data := copyActivityData(activity.Data)
+	if location.CountryCode != "" {
+		data["country"] = location.CountryCode
+	}
+	if location.City != "" {
+		data["city"] = location.City
+	}
+	if location.Asn != 0 {
+		data["asn"] = location.Asn
+	}
+	activity.Data = data
+	return activity
+}
+
+func SessionChangedUserAgentActivity(sessionId string, previousUserAgent string, newUserAgent string) Activity {
+	return Activity{Name: ActivitySessionChangedUserAgent, Version: 2, Data: map[string]interface{}{
+		"session_id":          sessionId,
+		"previous_user_agent": previousUserAgent,
+		"new_user_agent":      newUserAgent,
+		"previous_device":     describeUserAgent(previousUserAgent),
+		"new_device":          describeUserAgent(newUserAgent),
+	}}
+}
+
+// Parsed description of the user agent e.g. "Chrome on Windows", raw user agent if it is not recognized.
+func describeUserAgent(userAgent string) string {
+	if description := ParseUserAgent(userAgent).String(); description != "" {
+		return description
+	}
+	return userAgent
+}
+
+// Ip is the address of the revoking request.
+func SessionRevokedByAlertActivity(sessionId string, ip string) Activity {
+	return Activity{Name: ActivitySessionRevokedByAlert, Version: 1, Data: map[string]interface{}{
+		"session_id": sessionId,
+		"ip":         ip,
+	}}
+}
+
+func SessionTrustChangedActivity(sessionId string, userAgent string, trusted bool) Activity {
+	name := ActivitySessionUntrusted
+	if trusted {
+		name = ActivitySessionTrusted
+	}
+	return Activity{Name: name, Version: 1, Data: map[string]interface{}{
+		"session_id": sessionId,
+		"device":     describeUserAgent(userAgent),
+	}}
+}
+
+// Session evicted because user has reached the concurrent sessions limit.
+func SessionEvictedActivity(sessionId string, userAgent string, limit int) Activity {
+	return Activity{Name: ActivitySessionEvicted, Version: 1, Data: map[string]interface{}{
+		"session_id": sessionId,
+		"device":     describeUserAgent(userAgent),
+		"limit":      limit,
+	}}
+}
+
+// Query is the raw query string of the audit request.
+func AuditLogQueriedActivity(query string) Activity {
+	return Activity{Name: ActivityAuditLogQueried, Version: 1, Data: map[string]interface{}{
+		"query": query,
+	}}
+}
+
+func AuditLogExportedActivity(query string) Activity {
+	return Activity{Name: ActivityAuditLogExported, Version: 1, Data: map[string]interface{}{
+		"query": query,
+	}}
+}
+
+func TwoFactorEnabledActivity() Activity {
+	return Activity{Name: ActivityTwoFactorEnabled, Version: 1, Data: map[string]interface{}{}}
+}
+
+func TwoFactorDisabledActivity() Activity {
+	return Activity{Name: ActivityTwoFactorDisabled, Version: 1, Data: map[string]interface{}{}}
+}
+
+func TwoFactorRecoveryCodeUsedActivity(codesLeft int) Activity {
+	return Activity{Name: ActivityRecoveryCodeUsed, Version: 1, Data: map[string]interface{}{
+		"codes_left": codesLeft,
+	}}
+}
+
+func PasskeyAddedActivity(name string) Activity {
+	return Activity{Name: ActivityPasskeyAdded, Version: 1, Data: map[string]interface{}{
+		"name": name,
+	}}
+}
+
+func PasskeyRemovedActivity(name string) Activity {
+	return Activity{Name: ActivityPasskeyRemoved, Version: 1, Data: map[string]interface{}{
+		"name": name,
+	}}
+}
+
+func IdentityLinkedActivity(provider string) Activity {
+	return Activity{Name: ActivityIdentityLinked, Version: 1, Data: map[string]interface{}{
+		"provider": provider,
+	}}
+}
+
+func IdentityUnlinkedActivity(provider string) Activity {
+	return Activity{Name: ActivityIdentityUnlinked, Version: 1, Data: map[string]interface{}{
+		"provider": provider,
+	}}
+}
+
+func AccountDeletionScheduledActivity() Activity {
+	return Activity{Name: ActivityAccountDeletionScheduled, Version: 1, Data: map[string]interface{}{}}
+}
+
+func AccountDeletionCancelledActivity() Activity {
+	return Activity{Name: ActivityAccountDeletionCancelled, Version: 1, Data: map[string]interface{}{}}
+}
@@ -0,0 +1,143 @@
+package buzza
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivityCatalogueValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	activity, err := DefaultActivityCatalogue.Validate(SessionCreatedActivity("id", "127.0.0.1", "Firefox"))
+	if assert.NoError(err) {
+		assert.Equal(2, activity.Version)
+	}
+	activity, err = DefaultActivityCatalogue.Validate(Activity{Name: ActivitySessionChangedIp,
+		Data: map[string]interface{}{"session_id": "id", "previous_ip": "1.1.1.1", "new_ip": "1.0.0.1"}})
+	if assert.NoError(err) {
+		assert.Equal(1, activity.Version, "missing version should be set to the current one")
+	}
+
+	_, err = DefaultActivityCatalogue.Validate(Activity{Name: "click_clack"})
+	assert.ErrorIs(err, ErrUnknownActivity)
+	_, err = DefaultActivityCatalogue.Validate(Activity{Name: ActivitySessionCreated,
+		Data: map[string]interface{}{"session_id": "id", "ip": "127.0.0.1"}})
+	assert.Error(err, "missing field")
+	_, err = DefaultActivityCatalogue.Validate(Activity{Name: ActivitySessionCreated,
+		Data: map[string]interface{}{"session_id": "id", "ip": 127, "userAgent": "Firefox", "device": "Firefox"}})
+	assert.Error(err, "invalid field type")
+	_, err = DefaultActivityCatalogue.Validate(Activity{Name: ActivitySessionCreated,
+		Data: map[string]interface{}{"session_id": "id", "ip": "127.0.0.1", "userAgent": "Firefox", "device": "Firefox", "x": "y"}})
+	assert.Error(err, "unknown field")
+	_, err = DefaultActivityCatalogue.Validate(Activity{Name: ActivitySessionCreated, Version: 3,
+		Data: map[string]interface{}{"session_id": "id", "ip": "127.0.0.1", "userAgent": "Firefox", "device": "Firefox"}})
+	assert.Error(err, "unknown version")
+}
+
+func TestActivityCatalogueVersioning(t *testing.T) {
+	assert := assert.New(t)
+
+	catalogue := NewActivityCatalogue(ActivityKind{
+		Name: "cps_record",
+		Schemas: []ActivitySchema{
+			{
+				Fields: []ActivityField{{Name: "cps", Type: ActivityFieldNumber}},
+				Upgrade: func(data map[string]interface{}) map[string]interface{} {
+					return map[string]interface{}{"cps": data["cps"], "mode": "left"}
+				},
+			},
+			{Fields: []ActivityField{
+				{Name: "cps", Type: ActivityFieldNumber},
+				{Name: "mode", Type: ActivityFieldString},
+				{Name: "ranked", Type: ActivityFieldBool, Optional: true},
+			}},
+		},
+		Descriptions: map[string]string{
+			"en": "New record: {cps} CPS ({mode}).",
+			"pl": "Nowy rekord: {cps} CPS ({mode}).",
+		},
+	})
+
+	_, err := catalogue.Validate(Activity{Name: "cps_record", Version: 1, Data: map[string]interface{}{"cps": 20}})
+	assert.Error(err, "outdated version should not be logged")
+	_, err = catalogue.Validate(Activity{Name: "cps_record", Data: map[string]interface{}{"cps": 20, "mode": "right"}})
+	assert.NoError(err)
+
+	oldLog := ActivityLog{Name: "cps_record", Version: 1, Data: map[string]interface{}{"cps": float64(18)}}
+	upgraded := catalogue.Upgrade(oldLog)
+	assert.Equal(2, upgraded.Version)
+	assert.Equal(map[string]interface{}{"cps": float64(18), "mode": "left"}, upgraded.Data)
+
+	assert.Equal("New record: 18 CPS (left).", catalogue.Describe(oldLog, "en"))
+	assert.Equal("Nowy rekord: 18 CPS (left).", catalogue.Describe(oldLog, "pl"))
+	assert.Equal("New record: 18 CPS (left).", catalogue.Describe(oldLog, "de"), "unknown locale should fall back")
+	assert.Equal("", catalogue.Describe(ActivityLog{Name: "unknown"}, "en"))
+}
+
+func TestActivityCatalogueValidation(t *testing.T) {
+	assert := assert.New(t)
+	field := ActivityField{Name: "cps", Type: ActivityFieldNumber}
+
+	assert.Panics(func() { NewActivityCatalogue(ActivityKind{Name: "cps_record"}) }, "kind without schema")
+	assert.Panics(func() {
+		NewActivityCatalogue(ActivityKind{Name: "cps_record", Schemas: []ActivitySchema{{Fields: []ActivityField{field}}}},
+			ActivityKind{Name: "cps_record", Schemas: []ActivitySchema{{Fields: []ActivityField{field}}}})
+	}, "duplicated kind")
+	assert.Panics(func() {
+		NewActivityCatalogue(ActivityKind{Name: "cps_record", Schemas: []ActivitySchema{
+			{Fields: []ActivityField{field}},
+			{Fields: []ActivityField{field}},
+		}})
+	}, "outdated schema without upgrade")
+}
+
+func TestDefaultActivityCatalogueDescriptions(t *testing.T) {
+	for _, kind := range DefaultActivityCatalogue {
+		for _, locale := range ActivityLocales {
+			assert.NotEmpty(t, kind.Descriptions[locale], "%s: missing %s description", kind.Name, locale)
+		}
+	}
+}
+
+func TestWithGeoLocation(t *testing.T) {
+	assert := assert.New(t)
+
+	activity := SessionCreatedActivity("id", "1.1.1.1", "Firefox")
+	assert.Equal(activity, WithGeoLocation(activity, GeoLocation{}), "unknown location should not change activity")
+
+	located := WithGeoLocation(activity, GeoLocation{CountryCode: "PL", Country: "Poland", City: "Warsaw", Asn: 5617})
+	assert.Equal("PL", located.Data["country"])
+	assert.Equal("Warsaw", located.Data["city"])
+	assert.Equal(uint32(5617), located.Data["asn"])
+	assert.NotContains(activity.Data, "country", "original payload should not be modified")
+	_, err := DefaultActivityCatalogue.Validate(located)
+	assert.NoError(err)
+
+	located = WithGeoLocation(SessionChangedIpActivity("id", "1.1.1.1", "2.2.2.2"), GeoLocation{Asn: 13335})
+	assert.NotContains(located.Data, "city")
+	_, err = DefaultActivityCatalogue.Validate(located)
+	assert.NoError(err)
+}
+
+func TestSessionActivityDevice(t *testing.T) {
+	assert := assert.New(t)
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+	created := SessionCreatedActivity("id", "1.1.1.1", chrome)
+	_, err := DefaultActivityCatalogue.Validate(created)
+	assert.NoError(err)
+	assert.Equal("Chrome on Windows", created.Data["device"])
+	assert.Equal("unknown-agent", SessionCreatedActivity("id", "1.1.1.1", "unknown-agent").Data["device"],
+		"unrecognized user agent should be kept raw")
+
+	oldLog := ActivityLog{Name: ActivitySessionCreated, Version: 1,
+		Data: map[string]interface{}{"session_id": "id", "ip": "1.1.1.1", "userAgent": chrome}}
+	assert.Equal("Signed in from 1.1.1.1 (Chrome on Windows).", DefaultActivityCatalogue.Describe(oldLog, "en"))
+
+	oldLog = ActivityLog{Name: ActivitySessionChangedUserAgent, Version: 1,
+		Data: map[string]interface{}{"session_id": "id", "previous_user_agent": chrome,
+			"new_user_agent": "BuzkaaClicker/1.4.2 (beta; windows; amd64)"}}
+	assert.Equal("Session device changed from Chrome on Windows to BuzkaaClicker 1.4.2 (beta) on Windows.",
+		DefaultActivityCatalogue.Describe(oldLog, "en"))
+}
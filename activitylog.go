@@ -7,7 +7,9 @@ import (
 
 type Activity struct {
 	Name string
-	Data map[string]interface{}
+	// Payload version of the activity kind, 0 for the current one.
+	Version int
+	Data    map[string]interface{}
 }
 
 type ActivityLog struct {
@@ -15,12 +17,95 @@ type ActivityLog struct {
 	CreatedAt time.Time
 	UserId    UserId
 	Name      string
+	Version   int
 	Data      map[string]interface{}
 }
 
+// Filters of the activity logs. Zero values disable the filter.
+type ActivityQuery struct {
+	// Get logs before log with given id. If 0 or lower then gets the most recent logs.
+	BeforeId int64
+	// Get logs after log with given id. Ignored if 0 or lower.
+	AfterId int64
+	// Logs with any of the names.
+	Names []string
+	// Inclusive lower bound of the log creation time.
+	From time.Time
+	// Exclusive upper bound of the log creation time.
+	To time.Time
+	// Logs of the session, matched by "session_id" in the log data.
+	SessionId string
+	// Logs involving the IP address, matched by any of ActivityIpFields in the log data.
+	Ip string
+	// Max number of the returned logs. Logs are not returned at all if lower than 1.
+	Limit int
+	// Return the oldest matching logs first instead of the newest ones.
+	OldestFirst bool
+}
+
+// Whether the log passes filters of the query. Limit is not taken into account.
+func (q ActivityQuery) Matches(log ActivityLog) bool {
+	if q.BeforeId > 0 && log.Id >= q.BeforeId {
+		return false
+	}
+	if q.AfterId > 0 && log.Id <= q.AfterId {
+		return false
+	}
+	if len(q.Names) > 0 && !containsString(q.Names, log.Name) {
+		return false
+	}
+	if !q.From.IsZero() && log.CreatedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !log.CreatedAt.Before(q.To) {
+		return false
+	}
+	if q.SessionId != "" {
+		sessionId, _ := log.Data["session_id"].(string)
+		if sessionId != q.SessionId {
+			return false
+		}
+	}
+	if q.Ip != "" && !activityInvolvesIp(log, q.Ip) {
+		return false
+	}
+	return true
+}
+
+// Log data fields holding IP addresses.
+var ActivityIpFields = []string{"ip", "previous_ip", "new_ip"}
+
+func activityInvolvesIp(log ActivityLog, ip string) bool {
+	for _, field := range ActivityIpFields {
+		if value, _ := log.Data[field].(string); value == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// Cross-user filters of the activity logs.
+type AuditQuery struct {
+	ActivityQuery
+	// Logs of the user, 0 for logs of all users.
+	UserId UserId
+}
+
 type ActivityStore interface {
 	AddLog(ctx context.Context, userId UserId, activity Activity) error
 
-	// "beforeId" - get logs before log with given id. If lower than 0 then gets recent logs up to "limit".
-	ByUserId(ctx context.Context, userId UserId, beforeId int64, limit int32) ([]ActivityLog, error)
+	// Logs of the user matching the query, newest first.
+	ByUserId(ctx context.Context, userId UserId, query ActivityQuery) ([]ActivityLog, error)
+
+	// Logs of all users matching the query, newest first.
+	Audit(ctx context.Context, query AuditQuery) ([]ActivityLog, error)
+}
+
+// Delivers new activity logs to the subscribers, possibly across the backend instances.
+type ActivityBroker interface {
+	Publish(ctx context.Context, log ActivityLog) error
+
+	// Subscribe to new logs of the user. Channel is closed if subscriber can not keep up
+	// with the logs or broker is closed. Returned function cancels the subscription.
+	Subscribe(userId UserId) (<-chan ActivityLog, func())
 }
@@ -0,0 +1,231 @@
+package buzza
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrLegalHoldNotFound = errors.New("legal hold not found")
+
+// How long activity logs are kept before pruning.
+type ActivityRetentionPolicy struct {
+	// Retention of the logs with names not listed in ByName. 0 keeps them forever.
+	Default time.Duration
+	// Retention by the activity name. 0 keeps logs with the name forever.
+	ByName map[string]time.Duration
+}
+
+// Security events are kept longer, so account takeovers can be investigated.
+var DefaultActivityRetentionPolicy = ActivityRetentionPolicy{
+	Default: 90 * 24 * time.Hour,
+	ByName: map[string]time.Duration{
+		ActivitySessionCreated:           365 * 24 * time.Hour,
+		ActivitySessionChangedIp:         365 * 24 * time.Hour,
+		ActivitySessionChangedUserAgent:  365 * 24 * time.Hour,
+		ActivitySessionTrusted:           365 * 24 * time.Hour,
+		ActivitySessionUntrusted:         365 * 24 * time.Hour,
+		ActivitySessionEvicted:           365 * 24 * time.Hour,
+		ActivityTwoFactorEnabled:         365 * 24 * time.Hour,
+		ActivityTwoFactorDisabled:        365 * 24 * time.Hour,
+		ActivityRecoveryCodeUsed:         365 * 24 * time.Hour,
+		ActivityPasskeyAdded:             365 * 24 * time.Hour,
+		ActivityPasskeyRemoved:           365 * 24 * time.Hour,
+		ActivityIdentityLinked:           365 * 24 * time.Hour,
+		ActivityIdentityUnlinked:         365 * 24 * time.Hour,
+		ActivityAccountDeletionScheduled: 365 * 24 * time.Hour,
+		ActivityAccountDeletionCancelled: 365 * 24 * time.Hour,
+	},
+}
+
+func (p ActivityRetentionPolicy) Retention(name string) time.Duration {
+	if retention, ok := p.ByName[name]; ok {
+		return retention
+	}
+	return p.Default
+}
+
+func (p ActivityRetentionPolicy) Validate() error {
+	if p.Default < 0 {
+		return errors.New("negative default retention")
+	}
+	for name, retention := range p.ByName {
+		if retention < 0 {
+			return fmt.Errorf("negative retention of '%s'", name)
+		}
+	}
+	return nil
+}
+
+// Logs selected for pruning. Users under legal hold are always excluded.
+type ActivityExpiryQuery struct {
+	// Logs with any of the names. All names if empty.
+	Names []string
+	// Logs with none of the names.
+	ExcludeNames []string
+	// Exclusive upper bound of the log creation time.
+	Before time.Time
+	Limit  int
+}
+
+// User exempted from the activity log pruning.
+type LegalHold struct {
+	UserId    UserId
+	Reason    string
+	CreatedAt time.Time
+}
+
+type ActivityRetentionStore interface {
+	// Oldest logs matching the query.
+	Expired(ctx context.Context, query ActivityExpiryQuery) ([]ActivityLog, error)
+
+	// Delete logs with given ids, returns number of the deleted logs.
+	DeleteLogs(ctx context.Context, ids []int64) (int64, error)
+
+	// Place user under legal hold or update reason of the existing hold.
+	SetLegalHold(ctx context.Context, userId UserId, reason string) error
+
+	ReleaseLegalHold(ctx context.Context, userId UserId) error
+
+	LegalHolds(ctx context.Context) ([]LegalHold, error)
+}
+
+// Keeps copies of the logs before they are pruned.
+type ActivityArchive interface {
+	Archive(ctx context.Context, logs []ActivityLog) error
+
+	// Remove archived logs of the user, used when the account is erased.
+	EraseUser(ctx context.Context, userId UserId) error
+}
+
+type ActivityPruneStats struct {
+	Deleted  int64
+	Archived int64
+	Batches  int64
+	Duration time.Duration
+}
+
+// Totals of all prune runs.
+type ActivityPruneMetrics struct {
+	Runs         int64
+	Failures     int64
+	Deleted      int64
+	Archived     int64
+	LastRunAt    time.Time
+	LastDuration time.Duration
+}
+
+// Deletes expired activity logs in batches.
+type ActivityPruner struct {
+	Store  ActivityRetentionStore
+	Policy ActivityRetentionPolicy
+	// Logs are archived before deletion if set.
+	Archive   ActivityArchive
+	BatchSize int
+
+	metrics ActivityPruneMetrics
+	mutex   sync.Mutex
+}
+
+const defaultActivityPruneBatchSize = 1000
+
+// Prune logs expired at the given time. Stats are returned also on failure,
+// logs deleted before the failure stay deleted.
+func (p *ActivityPruner) Prune(ctx context.Context, now time.Time) (ActivityPruneStats, error) {
+	start := time.Now()
+	stats, err := p.prune(ctx, now)
+	stats.Duration = time.Since(start)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.metrics.Runs++
+	if err != nil {
+		p.metrics.Failures++
+	}
+	p.metrics.Deleted += stats.Deleted
+	p.metrics.Archived += stats.Archived
+	p.metrics.LastRunAt = now
+	p.metrics.LastDuration = stats.Duration
+	return stats, err
+}
+
+func (p *ActivityPruner) Metrics() ActivityPruneMetrics {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.metrics
+}
+
+func (p *ActivityPruner) prune(ctx context.Context, now time.Time) (ActivityPruneStats, error) {
+	var stats ActivityPruneStats
+	if err := p.Policy.Validate(); err != nil {
+		return stats, fmt.Errorf("invalid policy: %w", err)
+	}
+
+	names := make([]string, 0, len(p.Policy.ByName))
+	for name := range p.Policy.ByName {
+		names = append(names, name)
+	}
+	// deterministic order makes runs easier to follow in logs
+	sort.Strings(names)
+	for _, name := range names {
+		retention := p.Policy.ByName[name]
+		if retention == 0 {
+			continue
+		}
+		query := ActivityExpiryQuery{Names: []string{name}, Before: now.Add(-retention)}
+		if err := p.pruneExpired(ctx, query, &stats); err != nil {
+			return stats, fmt.Errorf("prune '%s': %w", name, err)
+		}
+	}
+	if p.Policy.Default > 0 {
+		query := ActivityExpiryQuery{ExcludeNames: names, Before: now.Add(-p.Policy.Default)}
+		if err := p.pruneExpired(ctx, query, &stats); err != nil {
+			return stats, fmt.Errorf("prune default: %w", err)
+		}
+	}
+	return stats, nil
+}
+
+func (p *ActivityPruner) pruneExpired(ctx context.Context, query ActivityExpiryQuery, stats *ActivityPruneStats) error {
+	query.Limit = p.BatchSize
+	if query.Limit <= 0 {
+		query.Limit = defaultActivityPruneBatchSize
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		logs, err := p.Store.Expired(ctx, query)
+		if err != nil {
+			return fmt.Errorf("get expired logs: %w", err)
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		if p.Archive != nil {
+			// logs are never deleted without the archived copy
+			if err := p.Archive.Archive(ctx, logs); err != nil {
+				return fmt.Errorf("archive logs: %w", err)
+			}
+			stats.Archived += int64(len(logs))
+		}
+
+		ids := make([]int64, len(logs))
+		for i, log := range logs {
+			ids[i] = log.Id
+		}
+		deleted, err := p.Store.DeleteLogs(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("delete logs: %w", err)
+		}
+		stats.Deleted += deleted
+		stats.Batches++
+		// nothing deleted means the same batch would be selected again
+		if deleted == 0 || len(logs) < query.Limit {
+			return nil
+		}
+	}
+}
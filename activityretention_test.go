@@ -0,0 +1,167 @@
+package buzza
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRetentionStore struct {
+	logs    []ActivityLog
+	queries []ActivityExpiryQuery
+}
+
+func (s *fakeRetentionStore) Expired(ctx context.Context, query ActivityExpiryQuery) ([]ActivityLog, error) {
+	s.queries = append(s.queries, query)
+	expired := make([]ActivityLog, 0)
+	for _, log := range s.logs {
+		if len(expired) == query.Limit {
+			break
+		}
+		if !log.CreatedAt.Before(query.Before) ||
+			(len(query.Names) > 0 && !containsString(query.Names, log.Name)) ||
+			containsString(query.ExcludeNames, log.Name) {
+			continue
+		}
+		expired = append(expired, log)
+	}
+	return expired, nil
+}
+
+func (s *fakeRetentionStore) DeleteLogs(ctx context.Context, ids []int64) (int64, error) {
+	kept := s.logs[:0]
+	for _, log := range s.logs {
+		if !containsInt64(ids, log.Id) {
+			kept = append(kept, log)
+		}
+	}
+	deleted := int64(len(s.logs) - len(kept))
+	s.logs = kept
+	return deleted, nil
+}
+
+func (s *fakeRetentionStore) SetLegalHold(ctx context.Context, userId UserId, reason string) error {
+	return nil
+}
+
+func (s *fakeRetentionStore) ReleaseLegalHold(ctx context.Context, userId UserId) error {
+	return nil
+}
+
+func (s *fakeRetentionStore) LegalHolds(ctx context.Context) ([]LegalHold, error) {
+	return nil, nil
+}
+
+func containsInt64(values []int64, value int64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type fakeArchive struct {
+	archived []ActivityLog
+	err      error
+}
+
+func (a *fakeArchive) Archive(ctx context.Context, logs []ActivityLog) error {
+	if a.err != nil {
+		return a.err
+	}
+	a.archived = append(a.archived, logs...)
+	return nil
+}
+
+func (a *fakeArchive) EraseUser(ctx context.Context, userId UserId) error {
+	return nil
+}
+
+func TestActivityRetentionPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := ActivityRetentionPolicy{Default: time.Hour, ByName: map[string]time.Duration{"login": 0}}
+	assert.Equal(time.Hour, policy.Retention("click"))
+	assert.Equal(time.Duration(0), policy.Retention("login"))
+	assert.NoError(policy.Validate())
+	assert.NoError(DefaultActivityRetentionPolicy.Validate())
+	assert.Error(ActivityRetentionPolicy{Default: -1}.Validate())
+	assert.Error(ActivityRetentionPolicy{ByName: map[string]time.Duration{"login": -1}}.Validate())
+}
+
+func TestActivityPruner(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time {
+		return now.Add(-time.Duration(days) * 24 * time.Hour)
+	}
+	store := &fakeRetentionStore{logs: []ActivityLog{
+		{Id: 1, Name: "security", CreatedAt: daysAgo(400)},
+		{Id: 2, Name: "security", CreatedAt: daysAgo(200)},
+		{Id: 3, Name: "click", CreatedAt: daysAgo(100)},
+		{Id: 4, Name: "click", CreatedAt: daysAgo(95)},
+		{Id: 5, Name: "click", CreatedAt: daysAgo(91)},
+		{Id: 6, Name: "click", CreatedAt: daysAgo(10)},
+		{Id: 7, Name: "forever", CreatedAt: daysAgo(1000)},
+	}}
+	archive := &fakeArchive{}
+	pruner := &ActivityPruner{
+		Store: store,
+		Policy: ActivityRetentionPolicy{
+			Default: 90 * 24 * time.Hour,
+			ByName: map[string]time.Duration{
+				"security": 365 * 24 * time.Hour,
+				"forever":  0,
+			},
+		},
+		Archive:   archive,
+		BatchSize: 2,
+	}
+
+	stats, err := pruner.Prune(ctx, now)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(int64(4), stats.Deleted)
+	assert.Equal(int64(4), stats.Archived)
+	assert.Equal(int64(3), stats.Batches)
+	ids := make([]int64, len(store.logs))
+	for i, log := range store.logs {
+		ids[i] = log.Id
+	}
+	assert.Equal([]int64{2, 6, 7}, ids)
+	assert.Len(archive.archived, 4)
+	assert.Equal([]string{"forever", "security"}, store.queries[len(store.queries)-1].ExcludeNames)
+
+	metrics := pruner.Metrics()
+	assert.Equal(int64(1), metrics.Runs)
+	assert.Equal(int64(0), metrics.Failures)
+	assert.Equal(int64(4), metrics.Deleted)
+	assert.Equal(now, metrics.LastRunAt)
+}
+
+func TestActivityPrunerArchiveFailure(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeRetentionStore{logs: []ActivityLog{{Id: 1, Name: "click", CreatedAt: now.Add(-time.Hour)}}}
+	archiveErr := errors.New("disk full")
+	pruner := &ActivityPruner{
+		Store:   store,
+		Policy:  ActivityRetentionPolicy{Default: time.Minute},
+		Archive: &fakeArchive{err: archiveErr},
+	}
+
+	stats, err := pruner.Prune(ctx, now)
+	assert.ErrorIs(err, archiveErr)
+	assert.Equal(int64(0), stats.Deleted)
+	assert.Len(store.logs, 1, "logs should not be deleted without archived copy")
+	assert.Equal(int64(1), pruner.Metrics().Failures)
+}
@@ -0,0 +1,96 @@
+package buzza
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrAnnouncementNotFound = errors.New("announcement not found")
+
+const (
+	maxAnnouncementTitleLength = 120
+	maxAnnouncementBodyLength  = 20000
+)
+
+type AnnouncementSeverity string
+
+const (
+	AnnouncementSeverityInfo     AnnouncementSeverity = "info"
+	AnnouncementSeverityWarning  AnnouncementSeverity = "warning"
+	AnnouncementSeverityCritical AnnouncementSeverity = "critical"
+)
+
+func (s AnnouncementSeverity) Valid() bool {
+	switch s {
+	case AnnouncementSeverityInfo, AnnouncementSeverityWarning, AnnouncementSeverityCritical:
+		return true
+	default:
+		return false
+	}
+}
+
+// News post shown on the website and in the clicker.
+type Announcement struct {
+	Id       int64
+	AuthorId UserId
+	Title    string
+	// Markdown content.
+	Body      string
+	Severity  AnnouncementSeverity
+	Targeting Targeting
+	// Visibility window. Zero EndsAt means announcement never expires.
+	StartsAt  time.Time
+	EndsAt    time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (a Announcement) Validate() error {
+	titleLength := utf8.RuneCountInString(a.Title)
+	if titleLength < 3 || titleLength > maxAnnouncementTitleLength {
+		return errors.New("title must be between 3 and 120 characters long")
+	}
+	if a.Body == "" || utf8.RuneCountInString(a.Body) > maxAnnouncementBodyLength {
+		return errors.New("invalid body length")
+	}
+	if !a.Severity.Valid() {
+		return errors.New("invalid severity")
+	}
+	if !a.EndsAt.IsZero() && !a.EndsAt.After(a.StartsAt) {
+		return errors.New("announcement must end after its start")
+	}
+	return a.Targeting.Validate()
+}
+
+// Announcements targeted to the client, in unchanged order.
+func FilterAnnouncements(announcements []Announcement, client ClientContext) []Announcement {
+	filtered := make([]Announcement, 0, len(announcements))
+	for _, a := range announcements {
+		if a.Targeting.Matches(client) {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+type AnnouncementStore interface {
+	Create(ctx context.Context, announcement Announcement) (Announcement, error)
+
+	Update(ctx context.Context, announcement Announcement) (Announcement, error)
+
+	Delete(ctx context.Context, id int64) error
+
+	// All announcements including the scheduled and expired ones, newest first.
+	All(ctx context.Context, offset int, limit int) ([]Announcement, error)
+
+	// Announcements visible at the given time, newest first.
+	Visible(ctx context.Context, at time.Time) ([]Announcement, error)
+
+	// Time when the user has read the feed, zero if never. Announcements started after it are unread.
+	ReadMarker(ctx context.Context, userId UserId) (time.Time, error)
+
+	// Move read marker forward. Older time does not move marker back.
+	SetReadMarker(ctx context.Context, userId UserId, at time.Time) error
+}
@@ -0,0 +1,53 @@
+package buzza
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnouncementValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	valid := Announcement{Title: "Nowa wersja", Body: "**1.5** już jest", Severity: AnnouncementSeverityInfo,
+		StartsAt: start}
+	assert.NoError(valid.Validate())
+
+	invalid := valid
+	invalid.Title = strings.Repeat("a", 121)
+	assert.Error(invalid.Validate())
+	invalid = valid
+	invalid.Body = ""
+	assert.Error(invalid.Validate())
+	invalid = valid
+	invalid.Severity = "urgent"
+	assert.Error(invalid.Validate())
+	invalid = valid
+	invalid.EndsAt = start
+	assert.Error(invalid.Validate())
+	invalid = valid
+	invalid.Targeting.MinVersion = "new"
+	assert.Error(invalid.Validate())
+}
+
+func TestFilterAnnouncements(t *testing.T) {
+	assert := assert.New(t)
+
+	announcements := []Announcement{
+		{Id: 3, Targeting: Targeting{Roles: []RoleId{RoleIdPro}}},
+		{Id: 2, Targeting: Targeting{Branches: []string{"beta"}, MinVersion: "1.5"}},
+		{Id: 1},
+	}
+	filtered := FilterAnnouncements(announcements, ClientContext{Branch: "beta", Version: "1.5.1"})
+	if assert.Len(filtered, 2) {
+		assert.Equal(int64(2), filtered[0].Id)
+		assert.Equal(int64(1), filtered[1].Id)
+	}
+	filtered = FilterAnnouncements(announcements, ClientContext{Roles: Roles{AllRoles[RoleIdPro]}})
+	if assert.Len(filtered, 2) {
+		assert.Equal(int64(3), filtered[0].Id)
+	}
+}
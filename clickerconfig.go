@@ -0,0 +1,66 @@
+package buzza
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrClickerConfigNotFound         = errors.New("clicker config not found")
+	ErrClickerConfigRevisionNotFound = errors.New("clicker config revision not found")
+	ErrClickerConfigConflict         = errors.New("clicker config revision conflict")
+)
+
+const (
+	// Max size of serialized config document in bytes.
+	MaxClickerConfigSize = 64 * 1024
+	// Number of the latest revisions kept in the config history.
+	ClickerConfigHistoryLimit = 20
+)
+
+// Revision passed as "expectedRevision" to skip the conflict detection.
+const AnyClickerConfigRevision int64 = -1
+
+var clickerConfigNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9 _.\-]{1,64}$`)
+
+func ValidClickerConfigName(name string) bool {
+	return clickerConfigNameRegexp.MatchString(name)
+}
+
+// Named clicker configuration document synchronized between user devices.
+type ClickerConfig struct {
+	UserId    UserId
+	Name      string
+	Revision  int64
+	Data      json.RawMessage
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type ClickerConfigRevision struct {
+	Revision  int64
+	Data      json.RawMessage
+	CreatedAt time.Time
+}
+
+type ClickerConfigStore interface {
+	ByUserId(ctx context.Context, userId UserId) ([]ClickerConfig, error)
+
+	ByName(ctx context.Context, userId UserId, name string) (ClickerConfig, error)
+
+	// Store new config revision.
+	// "expectedRevision" - current config revision known by client, 0 if config should not exist yet.
+	// Returns ErrClickerConfigConflict if config has been changed in the meantime.
+	Save(ctx context.Context, userId UserId, name string, data json.RawMessage, expectedRevision int64) (ClickerConfig, error)
+
+	// Get latest revisions (up to ClickerConfigHistoryLimit), newest first.
+	History(ctx context.Context, userId UserId, name string) ([]ClickerConfigRevision, error)
+
+	// Store data of the old revision as a new revision.
+	Restore(ctx context.Context, userId UserId, name string, revision int64, expectedRevision int64) (ClickerConfig, error)
+
+	Delete(ctx context.Context, userId UserId, name string, expectedRevision int64) error
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/buzkaaclicker/buzza/persistent"
+	"github.com/sirupsen/logrus"
+)
+
+// Creates the account deletion table and adds the deletion time to the user table.
+// Run it once before starting the server version with account deletion.
+func main() {
+	pgDsn := os.Getenv("POSTGRES_DSN")
+	if pgDsn == "" {
+		logrus.Fatalln("Environment variable POSTGRES_DSN is not set!")
+	}
+
+	ctx := context.Background()
+	pg := persistent.PgOpen(ctx, pgDsn)
+	defer pg.Close()
+
+	if err := persistent.MigrateAccountDeletion(ctx, pg); err != nil {
+		logrus.WithError(err).Fatalln("Could not migrate account deletion.")
+	}
+	logrus.Infoln("Account deletion table created.")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/buzkaaclicker/buzza/persistent"
+	"github.com/sirupsen/logrus"
+)
+
+// Creates identity tables and moves discord accounts from the user table into user_identity.
+// Run it once before starting the server version with identity providers,
+// older versions can not run afterwards as the legacy columns are dropped.
+// Running it again only creates tables added later.
+func main() {
+	pgDsn := os.Getenv("POSTGRES_DSN")
+	if pgDsn == "" {
+		logrus.Fatalln("Environment variable POSTGRES_DSN is not set!")
+	}
+	// must be the same as the server one, otherwise sealed refresh tokens can not be opened
+	keyringPath := os.Getenv("KEYRING_PATH")
+	if keyringPath == "" {
+		logrus.Fatalln("Environment variable KEYRING_PATH is not set!")
+	}
+	keyring, err := persistent.OpenFileKeyring(keyringPath)
+	if err != nil {
+		logrus.WithError(err).Fatalln("Could not open keyring.")
+	}
+
+	ctx := context.Background()
+	pg := persistent.PgOpen(ctx, pgDsn)
+	defer pg.Close()
+
+	models := []interface{}{
+		(*persistent.UserIdentity)(nil),
+		(*persistent.UnlinkedIdentity)(nil),
+	}
+	for _, model := range models {
+		_, err = pg.NewCreateTable().IfNotExists().Model(model).Exec(ctx)
+		if err != nil {
+			logrus.WithError(err).Fatalln("Could not create identity tables.")
+		}
+	}
+
+	migrated, err := persistent.MigrateDiscordIdentities(ctx, pg, keyring)
+	if err != nil {
+		logrus.WithError(err).WithField("migrated", migrated).Fatalln("Could not migrate discord identities.")
+	}
+	logrus.WithField("migrated", migrated).Infoln("Discord identities migrated.")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/buzkaaclicker/buzza/persistent"
+	"github.com/sirupsen/logrus"
+)
+
+// Creates tables of the email magic links and adds the email index to the user table.
+// Run it once before starting the server version with magic links.
+func main() {
+	pgDsn := os.Getenv("POSTGRES_DSN")
+	if pgDsn == "" {
+		logrus.Fatalln("Environment variable POSTGRES_DSN is not set!")
+	}
+
+	ctx := context.Background()
+	pg := persistent.PgOpen(ctx, pgDsn)
+	defer pg.Close()
+
+	models := []interface{}{
+		(*persistent.MagicLink)(nil),
+		(*persistent.RateLimit)(nil),
+	}
+	for _, model := range models {
+		_, err := pg.NewCreateTable().IfNotExists().Model(model).Exec(ctx)
+		if err != nil {
+			logrus.WithError(err).Fatalln("Could not create magic link tables.")
+		}
+	}
+	if err := persistent.MigrateEmailIndex(ctx, pg); err != nil {
+		logrus.WithError(err).Fatalln("Could not migrate email index.")
+	}
+	logrus.Infoln("Magic link tables created.")
+}
@@ -6,9 +6,16 @@ import (
 	"log/syslog"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/buzkaaclicker/buzza"
 	"github.com/buzkaaclicker/buzza/discord"
+	"github.com/buzkaaclicker/buzza/geoip"
+	"github.com/buzkaaclicker/buzza/github"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/buzkaaclicker/buzza/mail"
 	"github.com/buzkaaclicker/buzza/persistent"
 	"github.com/buzkaaclicker/buzza/transport/rest"
 	"github.com/gofiber/fiber/v2"
@@ -27,28 +34,185 @@ func listenAndServe(
 	bdb *buntdb.DB,
 	db *bun.DB,
 	discordConfig discordConfig,
+	loginAlertConfig loginAlertConfig,
 	debug bool,
 ) func() error {
-	userStore := &persistent.UserStore{DB: db}
+	keyringPath := os.Getenv("KEYRING_PATH")
+	if keyringPath == "" {
+		logrus.Fatalln("Environment variable KEYRING_PATH is not set!")
+	}
+	keyring, err := persistent.OpenFileKeyring(keyringPath)
+	if err != nil {
+		logrus.WithError(err).Fatalln("Could not open keyring.")
+	}
+	// keys both the email index and the magic link rate limits
+	emailIndexSecret := emailIndexSecretFromEnv()
+	userStore := &persistent.UserStore{DB: db, Keyring: keyring, EmailIndexKey: emailIndexSecret}
+	// picks up plaintext values and values sealed with retired keys after rotation
+	go runUserReencryption(ctx, userStore)
+	go keyring.Watch(ctx, time.Minute, func() { runUserReencryption(ctx, userStore) })
 	profileStore := &persistent.ProfileStore{DB: db}
-	activityStore := &persistent.ActivityStore{DB: db}
-	sessionStore := &persistent.SessionStore{Buntdb: bdb, ActivityStore: activityStore}
-	sessionStore.CreateIndexes()
+	localActivityBroker := inmem.NewActivityBroker()
+	activityBroker := &persistent.PgActivityBroker{DB: db, Local: &localActivityBroker}
+	if err := activityBroker.Listen(ctx); err != nil {
+		logrus.WithError(err).Fatalln("Could not listen for activity logs.")
+	}
+	activityStore := &persistent.ActivityStore{DB: db, Catalogue: buzza.DefaultActivityCatalogue,
+		Broker: activityBroker}
+	activityPruner := &buzza.ActivityPruner{Store: activityStore, Policy: buzza.DefaultActivityRetentionPolicy}
+	if archiveDir := os.Getenv("ACTIVITY_ARCHIVE_DIR"); archiveDir != "" {
+		activityPruner.Archive = &persistent.FileActivityArchive{Dir: archiveDir}
+	}
+	go runActivityPruner(ctx, activityPruner, time.Hour)
+	notificationSettingsStore := &persistent.NotificationSettingsStore{DB: db}
+	loginAlerter := &buzza.LoginAlerter{
+		Sources:      &persistent.LoginSourceStore{DB: db},
+		Settings:     notificationSettingsStore,
+		Users:        userStore,
+		Discord:      &buzza.DiscordLoginNotifier{Send: discordConfig.directMessageSend},
+		RevokeSecret: loginAlertConfig.revokeSecret,
+		RevokeUrl:    loginAlertConfig.revokeUrl,
+	}
+	if loginAlertConfig.mailer != nil {
+		loginAlerter.Email = &buzza.EmailLoginNotifier{Mailer: loginAlertConfig.mailer}
+	}
+	var geo buzza.GeoLocator
+	if paths := os.Getenv("GEOIP_DB_PATHS"); paths != "" {
+		locator, err := geoip.Open(strings.Split(paths, ",")...)
+		if err != nil {
+			logrus.WithError(err).Fatalln("Could not open GeoIP databases.")
+		}
+		go locator.Watch(ctx, time.Minute)
+		geo = locator
+	} else {
+		logrus.Warnln("GEOIP_DB_PATHS not set, sessions will not be located.")
+	}
+	tokenSecret := sessionTokenSecretFromEnv()
+	var sessionStore buzza.SessionStore
+	switch backend := os.Getenv("SESSION_STORE"); backend {
+	case "", "buntdb":
+		store := &persistent.SessionStore{Buntdb: bdb, ActivityStore: activityStore, TokenSecret: tokenSecret,
+			Observer: loginAlerter, Geo: geo, Policy: sessionPolicyFromEnv(), Users: userStore}
+		store.CreateIndexes()
+		migrated, err := store.HashLegacyTokens()
+		if err != nil {
+			logrus.WithError(err).Fatalln("Could not hash legacy session tokens.")
+		}
+		if migrated > 0 {
+			logrus.WithField("migrated", migrated).Infoln("Legacy session tokens hashed.")
+		}
+		sessionStore = store
+	case "postgres":
+		store := &persistent.PgSessionStore{DB: db, ActivityStore: activityStore, TokenSecret: tokenSecret,
+			Observer: loginAlerter, Geo: geo, Policy: sessionPolicyFromEnv(), Users: userStore}
+		go runSessionCleaner(ctx, store, time.Hour)
+		sessionStore = store
+	default:
+		logrus.WithField("backend", backend).Fatalln("Unknown SESSION_STORE.")
+	}
+	referralStore := &persistent.ReferralStore{DB: db, RewardRule: buzza.DefaultReferralRewardRule}
+	twoFactorAuthenticator := &buzza.TwoFactorAuthenticator{
+		Store:         &persistent.TwoFactorStore{DB: db, Keyring: keyring},
+		ActivityStore: activityStore,
+		Issuer:        "BuzkaaClicker",
+	}
+	// ceremony states and challenges are signed with distinct domain separators, so the secret is shared
+	twoFactorSecret := twoFactorSecretFromEnv()
+	passkeyChallengeStore := &persistent.PasskeyChallengeStore{DB: db}
+	go runPasskeyChallengeCleaner(ctx, passkeyChallengeStore, time.Hour)
+	passkeyAuthenticator := &buzza.PasskeyAuthenticator{
+		RelyingParty:  webAuthnRelyingPartyFromEnv(debug),
+		Store:         &persistent.PasskeyStore{DB: db},
+		Challenges:    passkeyChallengeStore,
+		ActivityStore: activityStore,
+		Secret:        twoFactorSecret,
+	}
+
+	identityProviders := buzza.IdentityProviders{
+		buzza.ProviderDiscord: &buzza.DiscordIdentityProvider{
+			CreateOAuthUrl:      discordConfig.oauthUrlFactory,
+			ExchangeAccessToken: discordConfig.accessTokenExchanger,
+			UserMeProvider:      discord.RestUserMeProvider,
+			GuildMemberAdd:      discordConfig.guildMemberAdd,
+		},
+	}
+	if provider := gitHubIdentityProviderFromEnv(); provider != nil {
+		identityProviders[buzza.ProviderGitHub] = provider
+	}
 
 	authController := rest.AuthController{
-		CreateDiscordOAuthUrl: discordConfig.oauthUrlFactory,
-		ExchangeAccessToken:   discordConfig.accessTokenExchanger,
-		UserMeProvider:        discord.RestUserMeProvider,
-		GuildMemberAdd:        discordConfig.guildMemberAdd,
-		SessionStore:          sessionStore,
-		UserStore:             userStore,
+		Providers:     identityProviders,
+		SessionStore:  sessionStore,
+		UserStore:     userStore,
+		ReferralStore: referralStore,
+
+		TwoFactor:                twoFactorAuthenticator,
+		TwoFactorChallengeSecret: twoFactorSecret,
+		Passkeys:                 passkeyAuthenticator,
+	}
+	if loginAlertConfig.mailer != nil {
+		magicLinkStore := &persistent.MagicLinkStore{DB: db}
+		rateLimiter := &persistent.RateLimiter{DB: db}
+		go runMagicLinkCleaner(ctx, magicLinkStore, rateLimiter, time.Hour)
+		authController.MagicLinks = &buzza.MagicLinkAuthenticator{
+			Users:    userStore,
+			Store:    magicLinkStore,
+			Limiter:  rateLimiter,
+			Mailer:   loginAlertConfig.mailer,
+			LoginUrl: magicLinkUrlFromEnv(),
+			Secret:   emailIndexSecret,
+		}
+	} else {
+		logrus.Warnln("SMTP_ADDR not set, magic link login is disabled.")
 	}
 
 	programStore := &persistent.ProgramStore{DB: db}
 	programController := rest.ProgramController{Store: programStore}
 	profileController := rest.ProfileController{Store: profileStore}
-	activityController := rest.ActivityController{Store: activityStore}
+	activityController := rest.ActivityController{Store: activityStore, Catalogue: buzza.DefaultActivityCatalogue,
+		Broker: activityBroker, Sessions: sessionStore}
+	activityRetentionController := rest.ActivityRetentionController{Store: activityStore, Pruner: activityPruner}
+	auditController := rest.AuditController{Store: activityStore}
+	loginAlertController := rest.LoginAlertController{
+		SessionStore:  sessionStore,
+		SettingsStore: notificationSettingsStore,
+		ActivityStore: activityStore,
+		RevokeSecret:  loginAlertConfig.revokeSecret,
+	}
 	sessionController := rest.SessionController{Store: sessionStore}
+	twoFactorController := rest.TwoFactorController{Authenticator: twoFactorAuthenticator}
+	passkeyController := rest.PasskeyController{Authenticator: passkeyAuthenticator}
+	identityController := rest.IdentityController{Linker: &buzza.IdentityLinker{
+		Providers:     identityProviders,
+		Store:         userStore,
+		ActivityStore: activityStore,
+		// link states are signed with a distinct domain separator as well
+		Secret: twoFactorSecret,
+	}}
+	accountDeleter := &buzza.AccountDeleter{
+		Store:         &persistent.AccountDeletionStore{DB: db, Archive: activityPruner.Archive},
+		Sessions:      sessionStore,
+		ActivityStore: activityStore,
+	}
+	go runAccountEraser(ctx, accountDeleter, time.Hour)
+	accountController := rest.AccountController{Deleter: accountDeleter}
+	referralController := rest.ReferralController{Store: referralStore}
+	clickerConfigStore := &persistent.ClickerConfigStore{DB: db}
+	clickerConfigController := rest.ClickerConfigController{Store: clickerConfigStore}
+	sharedConfigController := rest.SharedConfigController{
+		Store:              &persistent.SharedConfigStore{DB: db},
+		ClickerConfigStore: clickerConfigStore,
+	}
+	featureFlagController := rest.FeatureFlagController{Store: &persistent.FeatureFlagStore{DB: db}}
+	announcementController := rest.AnnouncementController{Store: &persistent.AnnouncementStore{DB: db}}
+	attachmentsDir := os.Getenv("ATTACHMENTS_DIR")
+	if attachmentsDir == "" {
+		attachmentsDir = "./attachments/"
+	}
+	crashController := rest.CrashController{
+		Store:       &persistent.CrashStore{DB: db},
+		Attachments: &persistent.FileAttachmentStorage{Dir: attachmentsDir},
+	}
 
 	server := fiber.New()
 	server.Use(rest.LogHandler())
@@ -65,13 +229,29 @@ func listenAndServe(
 	}
 	api.Use(cors.New(cors.Config{AllowOrigins: allowOrigins}))
 
-	requestAuthorizer := rest.RequestAuthorizer(sessionStore, userStore)
+	requestAuthorizer := rest.RequestAuthorizer(sessionStore, userStore, &buzza.SecondFactorVerifier{
+		TwoFactor: twoFactorAuthenticator.Store,
+		Passkeys:  passkeyAuthenticator.Store,
+	})
 	api.Get("/status", monitor.New())
 	authController.InstallTo(api)
 	programController.InstallTo(api)
 	profileController.InstallTo(api)
 	activityController.InstallTo(requestAuthorizer, api)
+	activityRetentionController.InstallTo(requestAuthorizer, api)
+	auditController.InstallTo(requestAuthorizer, api)
+	loginAlertController.InstallTo(requestAuthorizer, api)
 	sessionController.InstallTo(requestAuthorizer, api)
+	twoFactorController.InstallTo(requestAuthorizer, api)
+	passkeyController.InstallTo(requestAuthorizer, api)
+	identityController.InstallTo(requestAuthorizer, api)
+	accountController.InstallTo(requestAuthorizer, api)
+	referralController.InstallTo(requestAuthorizer, api)
+	clickerConfigController.InstallTo(requestAuthorizer, api)
+	sharedConfigController.InstallTo(requestAuthorizer, api)
+	crashController.InstallTo(requestAuthorizer, api)
+	featureFlagController.InstallTo(requestAuthorizer, api)
+	announcementController.InstallTo(requestAuthorizer, api)
 
 	server.Mount("/api/", api)
 
@@ -91,11 +271,131 @@ func listenAndServe(
 	go server.Listen(addr)
 
 	return func() error {
-		return nil
+		return activityBroker.Close()
 		// return server.Shutdown()
 	}
 }
 
+func runActivityPruner(ctx context.Context, pruner *buzza.ActivityPruner, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		stats, err := pruner.Prune(ctx, time.Now().UTC())
+		log := logrus.WithFields(logrus.Fields{
+			"deleted":  stats.Deleted,
+			"archived": stats.Archived,
+			"batches":  stats.Batches,
+			"duration": stats.Duration,
+		})
+		if err != nil {
+			log.WithError(err).Errorln("Could not prune activity logs.")
+		} else {
+			log.Infoln("Activity logs pruned.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runUserReencryption(ctx context.Context, store *persistent.UserStore) {
+	reencrypted, err := store.ReencryptUsers(ctx, 500)
+	log := logrus.WithField("reencrypted", reencrypted)
+	if err != nil {
+		log.WithError(err).Errorln("Could not re-encrypt users.")
+		return
+	}
+	log.Infoln("Users re-encrypted.")
+}
+
+func runSessionCleaner(ctx context.Context, store *persistent.PgSessionStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := store.DeleteExpired(ctx)
+		if err != nil {
+			logrus.WithError(err).Errorln("Could not delete expired sessions.")
+		} else {
+			logrus.WithField("deleted", deleted).Debugln("Expired sessions deleted.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runAccountEraser(ctx context.Context, deleter *buzza.AccountDeleter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		erased, err := deleter.EraseDue(ctx, 100)
+		log := logrus.WithField("erased", erased)
+		if err != nil {
+			log.WithError(err).Errorln("Could not erase deleted accounts.")
+		} else if erased > 0 {
+			log.Infoln("Deleted accounts erased.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runPasskeyChallengeCleaner(ctx context.Context, store *persistent.PasskeyChallengeStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := store.DeleteExpired(ctx)
+		if err != nil {
+			logrus.WithError(err).Errorln("Could not delete expired passkey challenges.")
+		} else {
+			logrus.WithField("deleted", deleted).Debugln("Expired passkey challenges deleted.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runMagicLinkCleaner(ctx context.Context, store *persistent.MagicLinkStore, limiter *persistent.RateLimiter,
+	interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := store.DeleteExpired(ctx)
+		if err != nil {
+			logrus.WithError(err).Errorln("Could not delete expired magic links.")
+		} else {
+			logrus.WithField("deleted", deleted).Debugln("Expired magic links deleted.")
+		}
+		// the longest magic link window has passed
+		deleted, err = limiter.DeleteExpired(ctx, time.Now().Add(-2*buzza.MagicLinkEmailWindow))
+		if err != nil {
+			logrus.WithError(err).Errorln("Could not delete expired rate limits.")
+		} else {
+			logrus.WithField("deleted", deleted).Debugln("Expired rate limits deleted.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func setupLogger(verbose bool) {
 	logrus.SetFormatter(&logrus.TextFormatter{
 		TimestampFormat: time.Stamp,
@@ -120,6 +420,7 @@ type discordConfig struct {
 	oauthUrlFactory      discord.OAuthUrlFactory
 	accessTokenExchanger discord.AccessTokenExchanger
 	guildMemberAdd       discord.GuildMemberAdd
+	directMessageSend    discord.DirectMessageSend
 }
 
 func discordConfigFromEnv() discordConfig {
@@ -142,7 +443,140 @@ func discordConfigFromEnv() discordConfig {
 		discord.RestOAuthUrlFactory(clientId, redirectUri),
 		discord.RestAccessTokenExchanger(clientId, clientSecret, redirectUri),
 		discord.RestGuildMemberAdd(botToken, guildId),
+		discord.RestDirectMessageSend(botToken),
+	}
+}
+
+// GitHub login, optional. Nil if GITHUB_CLIENT_ID is not set.
+func gitHubIdentityProviderFromEnv() *buzza.GitHubIdentityProvider {
+	clientId := os.Getenv("GITHUB_CLIENT_ID")
+	if clientId == "" {
+		logrus.Warnln("GITHUB_CLIENT_ID not set, github login is disabled.")
+		return nil
+	}
+	clientSecret := os.Getenv("GITHUB_CLIENT_SECRET")
+	redirectUri := os.Getenv("GITHUB_AUTH_URI")
+	if clientSecret == "" || redirectUri == "" {
+		logrus.Fatalln("GITHUB_CLIENT_SECRET and GITHUB_AUTH_URI are required with GITHUB_CLIENT_ID!")
+	}
+	return &buzza.GitHubIdentityProvider{
+		CreateOAuthUrl:      github.RestOAuthUrlFactory(clientId, redirectUri),
+		ExchangeAccessToken: github.RestAccessTokenExchanger(clientId, clientSecret, redirectUri),
+		UserMe:              github.RestUserMe,
+		UserEmails:          github.RestUserEmails,
+	}
+}
+
+type loginAlertConfig struct {
+	revokeSecret []byte
+	revokeUrl    string
+	// nil if mails are not configured
+	mailer buzza.Mailer
+}
+
+func sessionTokenSecretFromEnv() []byte {
+	secret := os.Getenv("SESSION_TOKEN_SECRET")
+	if len(secret) < 32 {
+		logrus.Fatalln("SESSION_TOKEN_SECRET not set or shorter than 32 characters!")
+	}
+	return []byte(secret)
+}
+
+func twoFactorSecretFromEnv() []byte {
+	secret := os.Getenv("TWO_FACTOR_SECRET")
+	if len(secret) < 32 {
+		logrus.Fatalln("TWO_FACTOR_SECRET not set or shorter than 32 characters!")
+	}
+	return []byte(secret)
+}
+
+func emailIndexSecretFromEnv() []byte {
+	secret := os.Getenv("EMAIL_INDEX_SECRET")
+	if len(secret) < 32 {
+		logrus.Fatalln("EMAIL_INDEX_SECRET not set or shorter than 32 characters!")
+	}
+	return []byte(secret)
+}
+
+// Page of the website exchanging magic link tokens for sessions.
+func magicLinkUrlFromEnv() string {
+	if url := os.Getenv("MAGIC_LINK_URL"); url != "" {
+		return url
+	}
+	return "https://buzkaaclicker.pl/magic-link"
+}
+
+// Relying party of passkeys, WEBAUTHN_RP_ID and comma separated WEBAUTHN_ORIGINS override the production site.
+func webAuthnRelyingPartyFromEnv(debug bool) buzza.WebAuthnRelyingParty {
+	rp := buzza.WebAuthnRelyingParty{
+		Id:      "buzkaaclicker.pl",
+		Name:    "BuzkaaClicker",
+		Origins: []string{"https://buzkaaclicker.pl"},
+	}
+	if id := os.Getenv("WEBAUTHN_RP_ID"); id != "" {
+		rp.Id = id
+	}
+	if origins := os.Getenv("WEBAUTHN_ORIGINS"); origins != "" {
+		rp.Origins = strings.Split(origins, ",")
+	} else if debug {
+		rp.Origins = append(rp.Origins, "http://test.buzkaaclicker.pl:3000")
+	}
+	return rp
+}
+
+func loginAlertConfigFromEnv() loginAlertConfig {
+	secret := os.Getenv("LOGIN_ALERT_SECRET")
+	if len(secret) < 32 {
+		logrus.Fatalln("LOGIN_ALERT_SECRET not set or shorter than 32 characters!")
+	}
+	revokeUrl := os.Getenv("LOGIN_ALERT_REVOKE_URL")
+	if revokeUrl == "" {
+		revokeUrl = "https://buzkaaclicker.pl/revoke-session"
+	}
+	config := loginAlertConfig{revokeSecret: []byte(secret), revokeUrl: revokeUrl}
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		config.mailer = &mail.SmtpMailer{
+			Addr:     smtpAddr,
+			From:     os.Getenv("SMTP_FROM"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	} else {
+		logrus.Warnln("SMTP_ADDR not set, login alerts will not be mailed.")
+	}
+	return config
+}
+
+// Default session policy with durations overridden by the optional environment variables
+// e.g. SESSION_MAX_AGE=4320h.
+func sessionPolicyFromEnv() buzza.SessionPolicy {
+	policy := buzza.DefaultSessionPolicy
+	for key, target := range map[string]*time.Duration{
+		"SESSION_MAX_AGE":              &policy.MaxAge,
+		"SESSION_IDLE_TIMEOUT":         &policy.IdleTimeout,
+		"SESSION_TRUSTED_IDLE_TIMEOUT": &policy.TrustedIdleTimeout,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			logrus.WithError(err).Fatalln("Invalid " + key + "!")
+		}
+		*target = duration
+	}
+	if value := os.Getenv("SESSION_MAX_CONCURRENT"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			logrus.WithError(err).Fatalln("Invalid SESSION_MAX_CONCURRENT!")
+		}
+		policy.MaxSessions = limit
+	}
+	if err := policy.Validate(); err != nil {
+		logrus.WithError(err).Fatalln("Invalid session policy.")
 	}
+	return policy
 }
 
 func awaitInterruption() {
@@ -177,9 +611,10 @@ func main() {
 	defer pg.Close()
 
 	discordConfig := discordConfigFromEnv()
+	loginAlertConfig := loginAlertConfigFromEnv()
 
 	logrus.Infoln("Starting listening... To shut down use ^C")
-	shutdown := listenAndServe(context.Background(), bdb, pg, discordConfig, debug)
+	shutdown := listenAndServe(context.Background(), bdb, pg, discordConfig, loginAlertConfig, debug)
 
 	awaitInterruption()
 
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/buzkaaclicker/buzza/persistent"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+)
+
+// Copies sessions from buntdb file into postgres session table.
+// Run it once before switching server to SESSION_STORE=postgres and once
+// again afterwards to pick up sessions created in the meantime.
+func main() {
+	buntdbPath := flag.String("buntdb", "kv.db", "path to buntdb file with sessions")
+	flag.Parse()
+
+	pgDsn := os.Getenv("POSTGRES_DSN")
+	if pgDsn == "" {
+		logrus.Fatalln("Environment variable POSTGRES_DSN is not set!")
+	}
+	// must be the same as the server one, otherwise migrated sessions can not be found
+	tokenSecret := os.Getenv("SESSION_TOKEN_SECRET")
+	if len(tokenSecret) < 32 {
+		logrus.Fatalln("SESSION_TOKEN_SECRET not set or shorter than 32 characters!")
+	}
+
+	bdb, err := buntdb.Open(*buntdbPath)
+	if err != nil {
+		logrus.WithError(err).Fatalln("Could not open buntdb.")
+	}
+	defer bdb.Close()
+
+	ctx := context.Background()
+	pg := persistent.PgOpen(ctx, pgDsn)
+	defer pg.Close()
+
+	_, err = pg.NewCreateTable().IfNotExists().Model((*persistent.PgSession)(nil)).Exec(ctx)
+	if err != nil {
+		logrus.WithError(err).Fatalln("Could not create session table.")
+	}
+
+	migrated, err := persistent.MigrateBuntSessions(ctx, bdb, pg, []byte(tokenSecret))
+	if err != nil {
+		logrus.WithError(err).WithField("migrated", migrated).Fatalln("Could not migrate sessions.")
+	}
+	logrus.WithField("migrated", migrated).Infoln("Sessions migrated.")
+}
@@ -128,8 +128,34 @@ func createDbSchema(ctx context.Context, db *bun.DB) {
 	models := []interface{}{
 		(*persistent.User)(nil),
 		(*persistent.ActivityLog)(nil),
+		(*persistent.ActivityLegalHold)(nil),
 		(*persistent.Profile)(nil),
 		(*persistent.Program)(nil),
+		(*persistent.ReferralCode)(nil),
+		(*persistent.Referral)(nil),
+		(*persistent.ReferralReward)(nil),
+		(*persistent.ClickerConfig)(nil),
+		(*persistent.ClickerConfigRevision)(nil),
+		(*persistent.SharedConfig)(nil),
+		(*persistent.SharedConfigRating)(nil),
+		(*persistent.SharedConfigReport)(nil),
+		(*persistent.CrashGroup)(nil),
+		(*persistent.CrashReport)(nil),
+		(*persistent.FeatureFlag)(nil),
+		(*persistent.FeatureFlagChange)(nil),
+		(*persistent.Announcement)(nil),
+		(*persistent.AnnouncementReadMarker)(nil),
+		(*persistent.LoginSource)(nil),
+		(*persistent.NotificationSettings)(nil),
+		(*persistent.PgSession)(nil),
+		(*persistent.TwoFactor)(nil),
+		(*persistent.Passkey)(nil),
+		(*persistent.PasskeyChallenge)(nil),
+		(*persistent.UserIdentity)(nil),
+		(*persistent.UnlinkedIdentity)(nil),
+		(*persistent.MagicLink)(nil),
+		(*persistent.RateLimit)(nil),
+		(*persistent.AccountDeletion)(nil),
 	}
 	for _, model := range models {
 		modelType := reflect.TypeOf(model)
@@ -0,0 +1,109 @@
+package buzza
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCrashReportNotFound = errors.New("crash report not found")
+	ErrAttachmentNotFound  = errors.New("attachment not found")
+)
+
+// Number of the stack frames taken into account in crash signature.
+const crashSignatureFrames = 8
+
+type CrashReport struct {
+	Id        int64
+	Signature string
+	// 0 if report has been sent anonymously.
+	UserId UserId
+	// Crashed module e.g. clicker, buzkaaclickeragent.dll.
+	Module         string
+	ProgramVersion string
+	Branch         string
+	OS             string
+	Arch           string
+	StackTrace     string
+	// Key of the minidump in attachment storage. Empty if report has no minidump.
+	MinidumpKey string
+	CreatedAt   time.Time
+}
+
+// Crashes with the same signature in the single release.
+type CrashGroup struct {
+	Signature      string
+	Module         string
+	ProgramVersion string
+	Count          int64
+	FirstSeenAt    time.Time
+	LastSeenAt     time.Time
+	// Stack trace of the first report.
+	StackTrace string
+}
+
+type CrashGroupQuery struct {
+	// Release filter, empty to list all releases.
+	ProgramVersion string
+	Offset         int
+	Limit          int
+}
+
+type CrashStore interface {
+	// Count the report in its group. Reports are stored only as long as the group has
+	// not enough samples, "sampled" is false if report has been counted but not stored.
+	Add(ctx context.Context, report CrashReport) (stored CrashReport, sampled bool, err error)
+
+	// Crash groups ordered by crash count.
+	Groups(ctx context.Context, query CrashGroupQuery) ([]CrashGroup, error)
+
+	// Stored report samples of the group, newest first.
+	BySignature(ctx context.Context, signature string, offset int, limit int) ([]CrashReport, error)
+
+	ById(ctx context.Context, id int64) (CrashReport, error)
+
+	// Clear minidump key of the report, used when its minidump could not be stored.
+	DetachMinidump(ctx context.Context, id int64) error
+}
+
+// Blob storage for big report attachments e.g. minidumps.
+type AttachmentStorage interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+var (
+	crashAddressRegexp = regexp.MustCompile(`0x[0-9a-fA-F]+`)
+	crashOffsetRegexp  = regexp.MustCompile(`\+\s*[0-9a-fA-F]+\b`)
+	crashLineRegexp    = regexp.MustCompile(`:\d+\b`)
+)
+
+// Hash of the normalized top stack frames. Memory addresses, offsets and line numbers
+// differ between machines and builds, so they are stripped before hashing.
+func CrashSignature(module string, stackTrace string) string {
+	frames := make([]string, 0, crashSignatureFrames)
+	for _, line := range strings.Split(stackTrace, "\n") {
+		frame := strings.TrimSpace(line)
+		frame = crashAddressRegexp.ReplaceAllString(frame, "")
+		frame = crashOffsetRegexp.ReplaceAllString(frame, "")
+		frame = crashLineRegexp.ReplaceAllString(frame, "")
+		frame = strings.Join(strings.Fields(frame), " ")
+		if frame == "" {
+			continue
+		}
+		frames = append(frames, frame)
+		if len(frames) == crashSignatureFrames {
+			break
+		}
+	}
+
+	hash := sha256.Sum256([]byte(module + "\n" + strings.Join(frames, "\n")))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,32 @@
+package buzza
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrashSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := "at clicker.exe!Clicker::Tick+0x1f2 (clicker.cpp:120)\n" +
+		"at clicker.exe!Clicker::Run+0x44 (clicker.cpp:52)\n" +
+		"at 0x00007ff6a2c81000\n"
+	sameCrashOtherBuild := "  at clicker.exe!Clicker::Tick+0x2a0 (clicker.cpp:131)\r\n" +
+		"at clicker.exe!Clicker::Run+0x48 (clicker.cpp:60)\r\n" +
+		"at 0x00007ff6b1c20000\r\n"
+	otherCrash := "at clicker.exe!Clicker::Stop+0x10 (clicker.cpp:200)\n"
+
+	signature := CrashSignature("clicker", trace)
+	assert.Len(signature, 64)
+	assert.Equal(signature, CrashSignature("clicker", sameCrashOtherBuild))
+	assert.NotEqual(signature, CrashSignature("clicker", otherCrash))
+	assert.NotEqual(signature, CrashSignature("buzkaaclickeragent.dll", trace))
+
+	deepTrace := ""
+	for i := 0; i < crashSignatureFrames; i++ {
+		deepTrace += "at frame\n"
+	}
+	assert.Equal(CrashSignature("clicker", deepTrace), CrashSignature("clicker", deepTrace+"at other frame\n"),
+		"frames below the top ones should not change signature")
+}
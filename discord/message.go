@@ -0,0 +1,78 @@
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Send direct message from the bot to the user.
+type DirectMessageSend = func(userId string, content string) error
+
+func MockDirectMessageSend(userId string, content string) error {
+	return nil
+}
+
+// Impl of discord rest api /users/@me/channels and /channels/{channel.id}/messages
+func RestDirectMessageSend(botToken string) DirectMessageSend {
+	return func(userId string, content string) error {
+		type Channel struct {
+			Id string `json:"id"`
+		}
+		var channel Channel
+		err := botRequest(botToken, "https://discord.com/api/users/@me/channels",
+			map[string]string{"recipient_id": userId}, &channel)
+		if err != nil {
+			return fmt.Errorf("create dm channel: %w", err)
+		}
+
+		err = botRequest(botToken, fmt.Sprintf("https://discord.com/api/channels/%s/messages", url.PathEscape(channel.Id)),
+			map[string]string{"content": content}, nil)
+		if err != nil {
+			return fmt.Errorf("create message: %w", err)
+		}
+		return nil
+	}
+}
+
+func botRequest(botToken string, uri string, body interface{}, response interface{}) error {
+	agent := fiber.AcquireAgent()
+	defer fiber.ReleaseAgent(agent)
+
+	req := agent.Request()
+	req.Header.SetMethod(fiber.MethodPost)
+	req.Header.Set(fiber.HeaderAuthorization, "Bot "+botToken)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.SetRequestURI(uri)
+
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshal body: %w", err)
+	}
+	req.SetBody(reqBody)
+
+	err = agent.Parse()
+	if err != nil {
+		return fmt.Errorf("agent parse: %w", err)
+	}
+
+	statusCode, respBody, errs := agent.Bytes()
+	if errs != nil {
+		return fmt.Errorf("agent bytes: %v", errs)
+	}
+	if statusCode != fiber.StatusOK {
+		if statusCode == fiber.StatusUnauthorized {
+			return ErrUnauthorized
+		} else {
+			return fmt.Errorf("invalid status code %d: %s", statusCode, string(respBody))
+		}
+	}
+	if response != nil {
+		if err := json.Unmarshal(respBody, response); err != nil {
+			return fmt.Errorf("response unmarshal: %w", err)
+		}
+	}
+	return nil
+}
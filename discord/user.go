@@ -11,6 +11,7 @@ type User struct {
 	Id         string `json:"id"`
 	Username   string `json:"username"`
 	Email      string `json:"email"`
+	Verified   bool   `json:"verified"`
 	AvatarHash string `json:"avatar"`
 }
 
@@ -0,0 +1,128 @@
+package buzza
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// Source of key encryption keys. Keys are identified by ids, so values sealed
+// with retired keys can still be opened until they are re-encrypted.
+type Keyring interface {
+	// Id of the key new values are sealed with.
+	CurrentKeyId() string
+
+	// 32 bytes AES-256 key, ErrKeyNotFound if there is no such key.
+	Key(id string) ([]byte, error)
+}
+
+const sealedValuePrefix = "enc:v1:"
+
+// Encrypt value with a fresh data key wrapped by the current keyring key.
+// Label binds the value to its purpose (e.g. column name), the same label is required to open it.
+//
+// Format: enc:v1:<key id>:<wrapped data key>:<ciphertext>, base64 encoded nonces are prepended to ciphertexts.
+func SealValue(keyring Keyring, label string, plaintext string) (string, error) {
+	keyId := keyring.CurrentKeyId()
+	kek, err := keyring.Key(keyId)
+	if err != nil {
+		return "", fmt.Errorf("get key '%s': %w", keyId, err)
+	}
+	dek := make([]byte, 32)
+	if _, err := crand.Read(dek); err != nil {
+		return "", fmt.Errorf("generate data key: %w", err)
+	}
+	wrappedDek, err := gcmSeal(kek, dek, []byte(keyId))
+	if err != nil {
+		return "", fmt.Errorf("wrap data key: %w", err)
+	}
+	ciphertext, err := gcmSeal(dek, []byte(plaintext), []byte(label))
+	if err != nil {
+		return "", fmt.Errorf("encrypt value: %w", err)
+	}
+	return sealedValuePrefix + keyId + ":" +
+		base64.RawStdEncoding.EncodeToString(wrappedDek) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt value sealed by SealValue. Values stored before encryption was
+// introduced (without the sealed prefix) are returned unchanged.
+func OpenSealedValue(keyring Keyring, label string, value string) (string, error) {
+	if !strings.HasPrefix(value, sealedValuePrefix) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, sealedValuePrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed sealed value")
+	}
+	keyId := parts[0]
+	wrappedDek, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("decode wrapped data key: %w", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("decode ciphertext: %w", err)
+	}
+
+	kek, err := keyring.Key(keyId)
+	if err != nil {
+		return "", fmt.Errorf("get key '%s': %w", keyId, err)
+	}
+	dek, err := gcmOpen(kek, wrappedDek, []byte(keyId))
+	if err != nil {
+		return "", fmt.Errorf("unwrap data key: %w", err)
+	}
+	plaintext, err := gcmOpen(dek, ciphertext, []byte(label))
+	if err != nil {
+		return "", fmt.Errorf("decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Id of the key value is sealed with, empty if value is not sealed.
+func SealedValueKeyId(value string) string {
+	if !strings.HasPrefix(value, sealedValuePrefix) {
+		return ""
+	}
+	keyId, _, _ := cut(strings.TrimPrefix(value, sealedValuePrefix), ":")
+	return keyId
+}
+
+func gcmSeal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := crand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func gcmOpen(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("new cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,77 @@
+package buzza
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (k testKeyring) CurrentKeyId() string {
+	return k.current
+}
+
+func (k testKeyring) Key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+func TestSealValue(t *testing.T) {
+	assert := assert.New(t)
+	keyring := testKeyring{current: "k1", keys: map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}}
+
+	sealed, err := SealValue(keyring, "user.email", "user@example.com")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.True(strings.HasPrefix(sealed, "enc:v1:k1:"), sealed)
+	assert.NotContains(sealed, "user@example.com")
+	assert.Equal("k1", SealedValueKeyId(sealed))
+	again, err := SealValue(keyring, "user.email", "user@example.com")
+	if assert.NoError(err) {
+		assert.NotEqual(sealed, again, "every value should get a fresh data key and nonce")
+	}
+
+	opened, err := OpenSealedValue(keyring, "user.email", sealed)
+	if assert.NoError(err) {
+		assert.Equal("user@example.com", opened)
+	}
+	_, err = OpenSealedValue(keyring, "user.discord_refresh_token", sealed)
+	assert.Error(err, "value should be bound to its label")
+
+	// values sealed with retired keys open after rotation
+	keyring.current = "k2"
+	opened, err = OpenSealedValue(keyring, "user.email", sealed)
+	if assert.NoError(err) {
+		assert.Equal("user@example.com", opened)
+	}
+	delete(keyring.keys, "k1")
+	_, err = OpenSealedValue(keyring, "user.email", sealed)
+	assert.ErrorIs(err, ErrKeyNotFound)
+
+	// values stored before encryption
+	opened, err = OpenSealedValue(keyring, "user.email", "legacy@example.com")
+	if assert.NoError(err) {
+		assert.Equal("legacy@example.com", opened)
+	}
+	assert.Equal("", SealedValueKeyId("legacy@example.com"))
+
+	tampered := sealed[:len(sealed)-2] + "AA"
+	_, err = OpenSealedValue(testKeyring{current: "k1", keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}},
+		"user.email", tampered)
+	assert.Error(err)
+	_, err = OpenSealedValue(keyring, "user.email", "enc:v1:broken")
+	assert.Error(err)
+}
@@ -0,0 +1,110 @@
+package buzza
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var ErrFeatureFlagNotFound = errors.New("feature flag not found")
+
+var ValidFeatureFlagKey = regexp.MustCompile(`^[a-z0-9][a-z0-9_.\-]{0,63}$`)
+
+// Remotely configured client feature or default value.
+type FeatureFlag struct {
+	Key         string
+	Description string
+	// Disabled flag evaluates to DefaultValue for every client.
+	Enabled bool
+	// Value for the targeted clients.
+	Value json.RawMessage
+	// Value for the rest of clients.
+	DefaultValue json.RawMessage
+	Targeting    Targeting
+	// Percentage (0-100) of the targeted users receiving Value.
+	Percentage int
+	UpdatedAt  time.Time
+}
+
+func (f FeatureFlag) Validate() error {
+	if !ValidFeatureFlagKey.MatchString(f.Key) {
+		return errors.New("invalid key")
+	}
+	if len(f.Description) > 500 {
+		return errors.New("description too long")
+	}
+	if !json.Valid(f.Value) || !json.Valid(f.DefaultValue) {
+		return errors.New("value is not a valid json")
+	}
+	if f.Percentage < 0 || f.Percentage > 100 {
+		return errors.New("percentage must be between 0 and 100")
+	}
+	return f.Targeting.Validate()
+}
+
+// Value of the flag for the client.
+func (f FeatureFlag) Evaluate(client ClientContext) json.RawMessage {
+	if f.Enabled && f.Targeting.Matches(client) && f.inRollout(client) {
+		return f.Value
+	}
+	return f.DefaultValue
+}
+
+// Users are assigned to a stable bucket per flag, so raising percentage only adds users.
+// Anonymous clients receive only fully rolled out values.
+func (f FeatureFlag) inRollout(client ClientContext) bool {
+	if f.Percentage >= 100 {
+		return true
+	}
+	if client.UserId == 0 {
+		return false
+	}
+	hash := sha256.Sum256([]byte(f.Key + ":" + strconv.FormatInt(int64(client.UserId), 10)))
+	bucket := binary.BigEndian.Uint32(hash[:4]) % 100
+	return int(bucket) < f.Percentage
+}
+
+// Flag values for the client by flag key.
+func EvaluateFeatureFlags(flags []FeatureFlag, client ClientContext) map[string]json.RawMessage {
+	values := make(map[string]json.RawMessage, len(flags))
+	for _, flag := range flags {
+		values[flag.Key] = flag.Evaluate(client)
+	}
+	return values
+}
+
+type FeatureFlagAction string
+
+const (
+	FeatureFlagActionSave   FeatureFlagAction = "save"
+	FeatureFlagActionDelete FeatureFlagAction = "delete"
+)
+
+type FeatureFlagChange struct {
+	Id       int64
+	Key      string
+	AuthorId UserId
+	Action   FeatureFlagAction
+	// Flag state after the save or before the delete.
+	Flag      FeatureFlag
+	CreatedAt time.Time
+}
+
+type FeatureFlagStore interface {
+	All(ctx context.Context) ([]FeatureFlag, error)
+
+	ByKey(ctx context.Context, key string) (FeatureFlag, error)
+
+	// Create or update the flag and record the change.
+	Save(ctx context.Context, flag FeatureFlag, authorId UserId) (FeatureFlag, error)
+
+	Delete(ctx context.Context, key string, authorId UserId) error
+
+	// Changes of the flag, newest first.
+	History(ctx context.Context, key string, offset int, limit int) ([]FeatureFlagChange, error)
+}
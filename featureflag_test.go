@@ -0,0 +1,63 @@
+package buzza
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureFlagEvaluate(t *testing.T) {
+	assert := assert.New(t)
+
+	flag := FeatureFlag{
+		Key:          "new-ui",
+		Enabled:      true,
+		Value:        json.RawMessage("true"),
+		DefaultValue: json.RawMessage("false"),
+		Targeting:    Targeting{Branches: []string{"beta"}},
+		Percentage:   100,
+	}
+	assert.NoError(flag.Validate())
+	assert.Equal(json.RawMessage("true"), flag.Evaluate(ClientContext{Branch: "beta"}))
+	assert.Equal(json.RawMessage("false"), flag.Evaluate(ClientContext{Branch: "stable"}))
+
+	disabled := flag
+	disabled.Enabled = false
+	assert.Equal(json.RawMessage("false"), disabled.Evaluate(ClientContext{Branch: "beta"}))
+
+	values := EvaluateFeatureFlags([]FeatureFlag{flag, {Key: "max-cps", Enabled: true,
+		Value: json.RawMessage("20"), DefaultValue: json.RawMessage("16"), Percentage: 100}},
+		ClientContext{Branch: "beta"})
+	assert.Equal(map[string]json.RawMessage{"new-ui": json.RawMessage("true"), "max-cps": json.RawMessage("20")}, values)
+}
+
+func TestFeatureFlagRollout(t *testing.T) {
+	assert := assert.New(t)
+
+	flag := FeatureFlag{Key: "rollout", Enabled: true, Value: json.RawMessage("1"),
+		DefaultValue: json.RawMessage("0"), Percentage: 30}
+	enabled := make(map[UserId]bool)
+	for userId := UserId(1); userId <= 1000; userId++ {
+		enabled[userId] = string(flag.Evaluate(ClientContext{UserId: userId})) == "1"
+	}
+	count := 0
+	for _, on := range enabled {
+		if on {
+			count++
+		}
+	}
+	assert.InDelta(300, count, 60)
+
+	// raising percentage must keep already enabled users
+	flag.Percentage = 60
+	for userId, on := range enabled {
+		if on {
+			assert.Equal("1", string(flag.Evaluate(ClientContext{UserId: userId})))
+		}
+	}
+	assert.Equal("0", string(flag.Evaluate(ClientContext{})), "anonymous clients are not rolled out")
+
+	flag.Percentage = 101
+	assert.Error(flag.Validate())
+}
@@ -0,0 +1,23 @@
+package buzza
+
+// Approximate location of the IP address. Fields unknown to the database are empty.
+type GeoLocation struct {
+	// ISO 3166-1 alpha-2 country code.
+	CountryCode string
+	// English country name.
+	Country string
+	// English city name.
+	City string
+	// Autonomous system number.
+	Asn             uint32
+	AsnOrganization string
+}
+
+func (l GeoLocation) IsZero() bool {
+	return l == GeoLocation{}
+}
+
+type GeoLocator interface {
+	// Location of the IP, zero location if IP is unknown. Fails only on invalid IP.
+	Locate(ip string) (GeoLocation, error)
+}
@@ -0,0 +1,173 @@
+// Package geoip locates IP addresses using local MaxMind-format database files.
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sirupsen/logrus"
+)
+
+// Fields of the GeoLite2/GeoIP2 City, Country and ASN databases.
+type record struct {
+	Country struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	AutonomousSystemNumber       uint32 `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+type database struct {
+	reader  *maxminddb.Reader
+	modTime time.Time
+}
+
+// Locates IPs in the database files e.g. city and ASN databases, results are merged.
+// Files are reloaded by Reload, so they can be updated without restart.
+type MaxMindLocator struct {
+	Paths []string
+
+	databases []database
+	mutex     sync.RWMutex
+}
+
+var _ buzza.GeoLocator = (*MaxMindLocator)(nil)
+
+// Open databases of the locator paths.
+func Open(paths ...string) (*MaxMindLocator, error) {
+	locator := &MaxMindLocator{Paths: paths}
+	if err := locator.Reload(); err != nil {
+		return nil, err
+	}
+	return locator, nil
+}
+
+// Reopen all database files. Previously opened databases are kept if any file can not be opened.
+func (l *MaxMindLocator) Reload() error {
+	databases := make([]database, 0, len(l.Paths))
+	for _, path := range l.Paths {
+		db, err := openDatabase(path)
+		if err != nil {
+			closeDatabases(databases)
+			return err
+		}
+		databases = append(databases, db)
+	}
+
+	l.mutex.Lock()
+	previous := l.databases
+	l.databases = databases
+	l.mutex.Unlock()
+	closeDatabases(previous)
+	return nil
+}
+
+func openDatabase(path string) (database, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return database{}, fmt.Errorf("stat database: %w", err)
+	}
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return database{}, fmt.Errorf("open database '%s': %w", path, err)
+	}
+	return database{reader: reader, modTime: info.ModTime()}, nil
+}
+
+func closeDatabases(databases []database) {
+	for _, db := range databases {
+		_ = db.reader.Close()
+	}
+}
+
+// Whether any database file has been modified since it was opened.
+func (l *MaxMindLocator) modified() bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if len(l.databases) != len(l.Paths) {
+		return true
+	}
+	for i, path := range l.Paths {
+		info, err := os.Stat(path)
+		if err == nil && !info.ModTime().Equal(l.databases[i].modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload databases whenever their files change, until context is done.
+// Files must be replaced atomically e.g. by rename, as opened databases are memory-mapped.
+func (l *MaxMindLocator) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !l.modified() {
+			continue
+		}
+		if err := l.Reload(); err != nil {
+			logrus.WithError(err).Errorln("Could not reload GeoIP databases.")
+		} else {
+			logrus.Infoln("GeoIP databases reloaded.")
+		}
+	}
+}
+
+func (l *MaxMindLocator) Locate(ip string) (buzza.GeoLocation, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return buzza.GeoLocation{}, fmt.Errorf("invalid ip '%s'", ip)
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		parsed = v4
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	var location buzza.GeoLocation
+	for _, db := range l.databases {
+		// IPv6 lookups fail in IPv4-only databases
+		if len(parsed) == net.IPv6len && db.reader.Metadata.IPVersion == 4 {
+			continue
+		}
+		var r record
+		if err := db.reader.Lookup(parsed, &r); err != nil {
+			return buzza.GeoLocation{}, fmt.Errorf("lookup: %w", err)
+		}
+		if r.Country.IsoCode != "" {
+			location.CountryCode = r.Country.IsoCode
+			location.Country = r.Country.Names["en"]
+		}
+		if name := r.City.Names["en"]; name != "" {
+			location.City = name
+		}
+		if r.AutonomousSystemNumber != 0 {
+			location.Asn = r.AutonomousSystemNumber
+			location.AsnOrganization = r.AutonomousSystemOrganization
+		}
+	}
+	return location, nil
+}
+
+func (l *MaxMindLocator) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	closeDatabases(l.databases)
+	l.databases = nil
+	return nil
+}
@@ -0,0 +1,266 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+// Minimal MaxMind DB writer for IPv4-only databases with 32-bit records.
+// Supported values are maps, strings and unsigned integers.
+type testNetwork struct {
+	cidr string
+	data map[string]interface{}
+}
+
+const mmdbMetadataMarker = "\xAB\xCD\xEFMaxMind.com"
+
+func writeTestDatabase(t *testing.T, path string, networks ...testNetwork) {
+	type node struct{ records [2]int }
+	const (
+		emptyRecord = -1
+		dataRecord  = -2
+	)
+	// records point to nodes by index or to data by -(dataRecord + offset)
+	nodes := []node{{records: [2]int{emptyRecord, emptyRecord}}}
+	var data bytes.Buffer
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := ipNet.IP.To4()
+		prefix, _ := ipNet.Mask.Size()
+		offset := data.Len()
+		encodeMmdbValue(&data, network.data)
+
+		current := 0
+		for bit := 0; bit < prefix; bit++ {
+			direction := int(ip[bit/8]>>(7-bit%8)) & 1
+			if bit == prefix-1 {
+				nodes[current].records[direction] = dataRecord - offset
+				break
+			}
+			next := nodes[current].records[direction]
+			if next < 0 {
+				next = len(nodes)
+				nodes = append(nodes, node{records: [2]int{emptyRecord, emptyRecord}})
+				nodes[current].records[direction] = next
+			}
+			current = next
+		}
+	}
+
+	var file bytes.Buffer
+	nodeCount := len(nodes)
+	for _, n := range nodes {
+		for _, record := range n.records {
+			value := uint32(nodeCount)
+			switch {
+			case record >= 0:
+				value = uint32(record)
+			case record <= dataRecord:
+				// data pointers skip the 16-byte separator
+				value = uint32(nodeCount + 16 + dataRecord - record)
+			}
+			_ = binary.Write(&file, binary.BigEndian, value)
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+	file.WriteString(mmdbMetadataMarker)
+	encodeMmdbValue(&file, map[string]interface{}{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(32),
+		"ip_version":                  uint16(4),
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               "Test",
+	})
+	if err := os.WriteFile(path, file.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encodeMmdbValue(buf *bytes.Buffer, value interface{}) {
+	const (
+		typeString = 2
+		typeUint16 = 5
+		typeUint32 = 6
+		typeMap    = 7
+		typeUint64 = 9
+	)
+	control := func(dataType int, size int) {
+		if size >= 29+256 {
+			panic("test encoder supports only sizes below 285")
+		}
+		sizeBits := size
+		if size >= 29 {
+			sizeBits = 29
+		}
+		if dataType <= 7 {
+			buf.WriteByte(byte(dataType<<5 | sizeBits))
+		} else {
+			// extended type
+			buf.WriteByte(byte(sizeBits))
+			buf.WriteByte(byte(dataType - 7))
+		}
+		if size >= 29 {
+			buf.WriteByte(byte(size - 29))
+		}
+	}
+	writeUint := func(dataType int, v uint64, size int) {
+		raw := make([]byte, 8)
+		binary.BigEndian.PutUint64(raw, v)
+		raw = bytes.TrimLeft(raw[8-size:], "\x00")
+		control(dataType, len(raw))
+		buf.Write(raw)
+	}
+
+	switch v := value.(type) {
+	case string:
+		control(typeString, len(v))
+		buf.WriteString(v)
+	case uint16:
+		writeUint(typeUint16, uint64(v), 2)
+	case uint32:
+		writeUint(typeUint32, uint64(v), 4)
+	case uint64:
+		writeUint(typeUint64, v, 8)
+	case map[string]interface{}:
+		control(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			encodeMmdbValue(buf, key)
+			encodeMmdbValue(buf, v[key])
+		}
+	default:
+		panic("unsupported test value")
+	}
+}
+
+func cityRecord(isoCode string, country string, city string) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{
+			"iso_code": isoCode,
+			"names":    map[string]interface{}{"en": country},
+		},
+		"city": map[string]interface{}{
+			"names": map[string]interface{}{"en": city},
+		},
+	}
+}
+
+func asnRecord(asn uint32, organization string) map[string]interface{} {
+	return map[string]interface{}{
+		"autonomous_system_number":       asn,
+		"autonomous_system_organization": organization,
+	}
+}
+
+func TestMaxMindLocatorLocate(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	cityPath := filepath.Join(dir, "city.mmdb")
+	asnPath := filepath.Join(dir, "asn.mmdb")
+	writeTestDatabase(t, cityPath,
+		testNetwork{"31.0.0.0/16", cityRecord("PL", "Poland", "Warsaw")},
+		testNetwork{"81.2.69.0/24", cityRecord("GB", "United Kingdom", "London")})
+	writeTestDatabase(t, asnPath,
+		testNetwork{"31.0.0.0/8", asnRecord(5617, "Orange Polska")})
+
+	locator, err := Open(cityPath, asnPath)
+	if !assert.NoError(err) {
+		return
+	}
+	defer locator.Close()
+
+	location, err := locator.Locate("31.0.12.34")
+	if assert.NoError(err) {
+		assert.Equal(buzza.GeoLocation{CountryCode: "PL", Country: "Poland", City: "Warsaw",
+			Asn: 5617, AsnOrganization: "Orange Polska"}, location)
+	}
+	location, err = locator.Locate("81.2.69.160")
+	if assert.NoError(err) {
+		assert.Equal(buzza.GeoLocation{CountryCode: "GB", Country: "United Kingdom", City: "London"}, location)
+	}
+	location, err = locator.Locate("31.200.0.1")
+	if assert.NoError(err) {
+		assert.Equal(buzza.GeoLocation{Asn: 5617, AsnOrganization: "Orange Polska"}, location)
+	}
+	location, err = locator.Locate("10.0.0.1")
+	if assert.NoError(err) {
+		assert.True(location.IsZero(), "unknown ip")
+	}
+	location, err = locator.Locate("::ffff:31.0.0.1")
+	if assert.NoError(err) {
+		assert.Equal("Warsaw", location.City, "ipv4-mapped ipv6")
+	}
+	location, err = locator.Locate("2001:db8::1")
+	if assert.NoError(err) {
+		assert.True(location.IsZero(), "ipv6 in ipv4-only database")
+	}
+	_, err = locator.Locate("not an ip")
+	assert.Error(err)
+
+	_, err = Open(filepath.Join(dir, "missing.mmdb"))
+	assert.Error(err)
+}
+
+func TestMaxMindLocatorReload(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "city.mmdb")
+	writeTestDatabase(t, path, testNetwork{"31.0.0.0/16", cityRecord("PL", "Poland", "Warsaw")})
+
+	locator, err := Open(path)
+	if !assert.NoError(err) {
+		return
+	}
+	defer locator.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go locator.Watch(ctx, 10*time.Millisecond)
+
+	// replaced atomically like the database updaters do
+	updatedPath := filepath.Join(dir, "city.mmdb.tmp")
+	writeTestDatabase(t, updatedPath, testNetwork{"31.0.0.0/16", cityRecord("PL", "Poland", "Krakow")})
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(updatedPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(updatedPath, path); err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(func() bool {
+		location, err := locator.Locate("31.0.0.1")
+		return err == nil && location.City == "Krakow"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// broken update keeps the previous database
+	if err := os.WriteFile(updatedPath, []byte("corrupted"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(updatedPath, path); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(locator.Reload())
+	location, err := locator.Locate("31.0.0.1")
+	if assert.NoError(err) {
+		assert.Equal("Krakow", location.City)
+	}
+}
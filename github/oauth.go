@@ -0,0 +1,95 @@
+package github
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	ErrOAuthInvalidCode = errors.New("github: oauth invalid code")
+	ErrUnauthorized     = errors.New("github: unauthorized")
+)
+
+// Empty state is omitted.
+type OAuthUrlFactory = func(state string) string
+
+type AccessTokenExchanger = func(code string) (AccessTokenResponse, error)
+
+type AccessTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	// Issued only if the app has expiring user tokens enabled.
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
+}
+
+func RestOAuthUrlFactory(clientId string, redirectUri string) OAuthUrlFactory {
+	return func(state string) string {
+		query := url.Values{}
+		query.Set("client_id", clientId)
+		query.Set("redirect_uri", redirectUri)
+		query.Set("scope", "read:user user:email")
+		if state != "" {
+			query.Set("state", state)
+		}
+		return "https://github.com/login/oauth/authorize?" + query.Encode()
+	}
+}
+
+func RestAccessTokenExchanger(clientId string, clientSecret string, redirectUri string) AccessTokenExchanger {
+	return func(code string) (AccessTokenResponse, error) {
+		agent := fiber.AcquireAgent()
+		defer fiber.ReleaseAgent(agent)
+
+		req := agent.Request()
+		req.Header.SetMethod(fiber.MethodPost)
+		req.Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+		req.SetRequestURI("https://github.com/login/oauth/access_token")
+
+		args := fiber.AcquireArgs()
+		defer fiber.ReleaseArgs(args)
+
+		args.Add("client_id", clientId)
+		args.Add("client_secret", clientSecret)
+		args.Add("code", code)
+		args.Add("redirect_uri", redirectUri)
+
+		err := agent.Form(args).Parse()
+		if err != nil {
+			return AccessTokenResponse{}, fmt.Errorf("agent parse: %w", err)
+		}
+
+		statusCode, bodyBytes, errArr := agent.Bytes()
+		if len(errArr) != 0 {
+			return AccessTokenResponse{}, fmt.Errorf("agent bytes: %v", errArr)
+		}
+		if statusCode != fiber.StatusOK {
+			return AccessTokenResponse{}, fmt.Errorf("invalid status code '%d': %s", statusCode, string(bodyBytes))
+		}
+		return parseAccessTokenResponse(bodyBytes)
+	}
+}
+
+// GitHub reports errors of the token exchange with status 200 and the error field set.
+func parseAccessTokenResponse(bodyBytes []byte) (AccessTokenResponse, error) {
+	var response struct {
+		AccessTokenResponse
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return AccessTokenResponse{}, fmt.Errorf("response unmarshal: %w", err)
+	}
+	switch response.Error {
+	case "":
+		return response.AccessTokenResponse, nil
+	case "bad_verification_code":
+		return AccessTokenResponse{}, ErrOAuthInvalidCode
+	default:
+		return AccessTokenResponse{}, fmt.Errorf("token exchange error '%s': %s", response.Error, response.Description)
+	}
+}
@@ -0,0 +1,81 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type User struct {
+	Id        int64  `json:"id"`
+	Login     string `json:"login"`
+	AvatarUrl string `json:"avatar_url"`
+}
+
+type Email struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+type UserMe = func(accessToken string) (User, error)
+
+type UserEmails = func(accessToken string) ([]Email, error)
+
+// Impl of github rest api /user
+func RestUserMe(accessToken string) (User, error) {
+	var user User
+	err := getJson("https://api.github.com/user", accessToken, &user)
+	return user, err
+}
+
+// Impl of github rest api /user/emails, requires user:email scope.
+func RestUserEmails(accessToken string) ([]Email, error) {
+	var emails []Email
+	err := getJson("https://api.github.com/user/emails", accessToken, &emails)
+	return emails, err
+}
+
+// Primary email if it is verified, empty otherwise.
+func PrimaryVerifiedEmail(emails []Email) string {
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			return email.Email
+		}
+	}
+	return ""
+}
+
+func getJson(uri string, accessToken string, response interface{}) error {
+	agent := fiber.AcquireAgent()
+	defer fiber.ReleaseAgent(agent)
+
+	req := agent.Request()
+	req.Header.SetMethod(fiber.MethodGet)
+	req.SetRequestURI(uri)
+	req.Header.Set(fiber.HeaderAccept, "application/vnd.github+json")
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+accessToken)
+
+	err := agent.Parse()
+	if err != nil {
+		return fmt.Errorf("agent parse: %w", err)
+	}
+
+	statusCode, body, errs := agent.Bytes()
+	if errs != nil {
+		return fmt.Errorf("agent bytes: %v", errs)
+	}
+	if statusCode != fiber.StatusOK {
+		if statusCode == fiber.StatusUnauthorized {
+			return ErrUnauthorized
+		} else {
+			return fmt.Errorf("invalid status code %d: %s", statusCode, string(body))
+		}
+	}
+
+	if err = json.Unmarshal(body, response); err != nil {
+		return fmt.Errorf("unmarshal body: %w", err)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ go 1.17
 
 require (
 	github.com/gofiber/fiber/v2 v2.26.0
+	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.7.0
 	github.com/uptrace/bun v1.0.22
@@ -64,7 +65,7 @@ require (
 	github.com/stretchr/objx v0.1.1 // indirect
 	github.com/syndtr/gocapability v0.0.0-20200815063812-42c35b437635 // indirect
 	github.com/tidwall/btree v1.1.0 // indirect
-	github.com/tidwall/buntdb v1.2.9 // indirect
+	github.com/tidwall/buntdb v1.2.9
 	github.com/tidwall/gjson v1.14.0 // indirect
 	github.com/tidwall/grect v0.1.4 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
@@ -0,0 +1,183 @@
+package buzza
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderDiscord = "discord"
+	ProviderGitHub  = "github"
+)
+
+var (
+	ErrUnknownIdentityProvider = errors.New("unknown identity provider")
+	// Provider rejected the authorization code, it is invalid, expired or already used.
+	ErrInvalidAuthorizationCode = errors.New("invalid authorization code")
+	// Provider did not grant access required to finish the login.
+	ErrIdentityAccessDenied = errors.New("identity provider access denied")
+	ErrIdentityNotFound     = errors.New("identity not found")
+	// External account is already linked to another user.
+	ErrIdentityLinked = errors.New("identity linked to another user")
+	// User has already linked other account of the same provider.
+	ErrProviderLinked = errors.New("provider already linked")
+	// User would not be able to log in after unlinking the identity.
+	ErrLastIdentity             = errors.New("last identity can not be unlinked")
+	ErrInvalidIdentityLinkState = errors.New("invalid identity link state")
+)
+
+const DefaultIdentityLinkStateTTL = 10 * time.Minute
+
+// Account of the user at the external identity provider.
+type ExternalIdentity struct {
+	Provider string
+	// Stable id of the account at the provider.
+	Subject string
+	Email   Email
+	// Whether the provider has verified the email, only verified ones can receive magic links.
+	EmailVerified bool
+	Name          string
+	AvatarUrl     string
+	// Empty if the provider does not issue refresh tokens.
+	RefreshToken string
+}
+
+// External account linked to the user, one per provider.
+type UserIdentity struct {
+	UserId       UserId
+	Provider     string
+	Subject      string
+	Email        Email
+	RefreshToken string
+	LinkedAt     time.Time
+}
+
+// OAuth2 / OpenID Connect login provider.
+type IdentityProvider interface {
+	// Url of the provider consent page. State is passed back to the redirect uri
+	// along with the code, empty state is omitted.
+	AuthUrl(state string) string
+
+	// Exchange authorization code for the account of the user.
+	// ErrInvalidAuthorizationCode if the provider rejected the code.
+	Authenticate(ctx context.Context, code string) (ExternalIdentity, error)
+}
+
+// Enabled identity providers by their names, e.g. ProviderDiscord.
+type IdentityProviders map[string]IdentityProvider
+
+// ErrUnknownIdentityProvider if the provider is not enabled.
+func (p IdentityProviders) Get(name string) (IdentityProvider, error) {
+	provider, ok := p[name]
+	if !ok {
+		return nil, ErrUnknownIdentityProvider
+	}
+	return provider, nil
+}
+
+// Links external accounts to already logged in users.
+type IdentityLinker struct {
+	Providers     IdentityProviders
+	Store         UserStore
+	ActivityStore ActivityStore
+	// Signs link states, at least 32 bytes.
+	Secret []byte
+	// Defaults to time.Now.
+	Now func() time.Time
+}
+
+func (l *IdentityLinker) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// Consent page url of the provider with the state binding the callback to the user.
+func (l *IdentityLinker) BeginLink(userId UserId, provider string) (string, error) {
+	identityProvider, err := l.Providers.Get(provider)
+	if err != nil {
+		return "", err
+	}
+	state := signIdentityLinkState(l.Secret, userId, provider, l.now().Add(DefaultIdentityLinkStateTTL))
+	return identityProvider.AuthUrl(state), nil
+}
+
+// Link account authorized with the code, state must be issued by BeginLink for the same user,
+// otherwise anyone could link their account to the victim by tricking them into opening the callback.
+func (l *IdentityLinker) FinishLink(ctx context.Context, userId UserId, provider string, code string,
+	state string) (UserIdentity, error) {
+
+	identityProvider, err := l.Providers.Get(provider)
+	if err != nil {
+		return UserIdentity{}, err
+	}
+	if err := verifyIdentityLinkState(l.Secret, state, userId, provider, l.now()); err != nil {
+		return UserIdentity{}, err
+	}
+	external, err := identityProvider.Authenticate(ctx, code)
+	if err != nil {
+		return UserIdentity{}, err
+	}
+	identity, err := l.Store.LinkIdentity(ctx, userId, external)
+	if err != nil {
+		return UserIdentity{}, err
+	}
+	if err := l.ActivityStore.AddLog(ctx, userId, IdentityLinkedActivity(provider)); err != nil {
+		return UserIdentity{}, fmt.Errorf("log identity linked: %w", err)
+	}
+	return identity, nil
+}
+
+func (l *IdentityLinker) Unlink(ctx context.Context, userId UserId, provider string) error {
+	if _, err := l.Store.UnlinkIdentity(ctx, userId, provider); err != nil {
+		return err
+	}
+	if err := l.ActivityStore.AddLog(ctx, userId, IdentityUnlinkedActivity(provider)); err != nil {
+		return fmt.Errorf("log identity unlinked: %w", err)
+	}
+	return nil
+}
+
+func signIdentityLinkState(secret []byte, userId UserId, provider string, expiresAt time.Time) string {
+	payload := strconv.FormatInt(int64(userId), 10) + "." + provider + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(identityLinkStateMac(secret, encoded))
+}
+
+func verifyIdentityLinkState(secret []byte, state string, userId UserId, provider string, now time.Time) error {
+	encoded, encodedMac, ok := cut(state, ".")
+	if !ok {
+		return ErrInvalidIdentityLinkState
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil || !hmac.Equal(mac, identityLinkStateMac(secret, encoded)) {
+		return ErrInvalidIdentityLinkState
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidIdentityLinkState
+	}
+	parts := strings.Split(string(payload), ".")
+	if len(parts) != 3 || parts[0] != strconv.FormatInt(int64(userId), 10) || parts[1] != provider {
+		return ErrInvalidIdentityLinkState
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresAt, 0)) {
+		return ErrInvalidIdentityLinkState
+	}
+	return nil
+}
+
+func identityLinkStateMac(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("identity-link:" + payload))
+	return mac.Sum(nil)
+}
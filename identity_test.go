@@ -0,0 +1,219 @@
+package buzza
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza/discord"
+	"github.com/buzkaaclicker/buzza/github"
+	"github.com/stretchr/testify/assert"
+)
+
+type testIdentityProvider struct {
+	identities map[string]ExternalIdentity
+}
+
+func (p *testIdentityProvider) AuthUrl(state string) string {
+	return "https://provider.example.com/authorize?state=" + url.QueryEscape(state)
+}
+
+func (p *testIdentityProvider) Authenticate(ctx context.Context, code string) (ExternalIdentity, error) {
+	identity, ok := p.identities[code]
+	if !ok {
+		return ExternalIdentity{}, ErrInvalidAuthorizationCode
+	}
+	return identity, nil
+}
+
+type testIdentityStore struct {
+	fakeUsers
+	linked []UserIdentity
+}
+
+func (s *testIdentityStore) LinkIdentity(ctx context.Context, userId UserId, identity ExternalIdentity) (UserIdentity, error) {
+	for _, linked := range s.linked {
+		if linked.Provider == identity.Provider && linked.Subject == identity.Subject && linked.UserId != userId {
+			return UserIdentity{}, ErrIdentityLinked
+		}
+	}
+	linked := UserIdentity{UserId: userId, Provider: identity.Provider, Subject: identity.Subject}
+	s.linked = append(s.linked, linked)
+	return linked, nil
+}
+
+func (s *testIdentityStore) UnlinkIdentity(ctx context.Context, userId UserId, provider string) (UserIdentity, error) {
+	for i, linked := range s.linked {
+		if linked.UserId == userId && linked.Provider == provider {
+			s.linked = append(s.linked[:i], s.linked[i+1:]...)
+			return linked, nil
+		}
+	}
+	return UserIdentity{}, ErrIdentityNotFound
+}
+
+func TestIdentityLinker(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	activities := &testActivityStore{}
+	store := &testIdentityStore{}
+	linker := IdentityLinker{
+		Providers: IdentityProviders{ProviderGitHub: &testIdentityProvider{identities: map[string]ExternalIdentity{
+			"code": {Provider: ProviderGitHub, Subject: "583231"},
+		}}},
+		Store:         store,
+		ActivityStore: activities,
+		Secret:        []byte("0123456789abcdef0123456789abcdef"),
+	}
+	stateOf := func(authUrl string) string {
+		parsed, err := url.Parse(authUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Query().Get("state")
+	}
+
+	_, err := linker.BeginLink(7, "myspace")
+	assert.ErrorIs(err, ErrUnknownIdentityProvider)
+	authUrl, err := linker.BeginLink(7, ProviderGitHub)
+	if !assert.NoError(err) {
+		return
+	}
+	state := stateOf(authUrl)
+
+	_, err = linker.FinishLink(ctx, 8, ProviderGitHub, "code", state)
+	assert.ErrorIs(err, ErrInvalidIdentityLinkState, "state of other user")
+	_, err = linker.FinishLink(ctx, 7, ProviderGitHub, "code", "forged."+state)
+	assert.ErrorIs(err, ErrInvalidIdentityLinkState)
+	_, err = linker.FinishLink(ctx, 7, ProviderGitHub, "invalid", state)
+	assert.ErrorIs(err, ErrInvalidAuthorizationCode)
+	identity, err := linker.FinishLink(ctx, 7, ProviderGitHub, "code", state)
+	if assert.NoError(err) {
+		assert.Equal(UserIdentity{UserId: 7, Provider: ProviderGitHub, Subject: "583231"}, identity)
+	}
+
+	authUrl, err = linker.BeginLink(8, ProviderGitHub)
+	if assert.NoError(err) {
+		_, err = linker.FinishLink(ctx, 8, ProviderGitHub, "code", stateOf(authUrl))
+		assert.ErrorIs(err, ErrIdentityLinked)
+	}
+
+	expired := linker
+	expired.Now = func() time.Time { return time.Now().Add(-DefaultIdentityLinkStateTTL) }
+	authUrl, err = expired.BeginLink(7, ProviderGitHub)
+	if assert.NoError(err) {
+		_, err = linker.FinishLink(ctx, 7, ProviderGitHub, "code", stateOf(authUrl))
+		assert.ErrorIs(err, ErrInvalidIdentityLinkState, "expired state")
+	}
+
+	assert.NoError(linker.Unlink(ctx, 7, ProviderGitHub))
+	assert.ErrorIs(linker.Unlink(ctx, 7, ProviderGitHub), ErrIdentityNotFound)
+
+	names := make([]string, len(activities.logs))
+	for i, activity := range activities.logs {
+		names[i] = activity.Name
+	}
+	assert.Equal([]string{ActivityIdentityLinked, ActivityIdentityUnlinked}, names)
+	assert.Equal(ProviderGitHub, activities.logs[0].Data["provider"])
+}
+
+func TestDiscordIdentityProvider(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	discordUser := discord.User{Id: "2137", Username: "makin", Email: "makin@discord.com",
+		Verified: true, AvatarHash: "abc"}
+	guildMembers := 0
+	guildErr := error(nil)
+	provider := &DiscordIdentityProvider{
+		CreateOAuthUrl: func() string { return "https://discord.com/api/oauth2/authorize?client_id=1" },
+		ExchangeAccessToken: func(code string) (discord.AccessTokenResponse, error) {
+			if code != "code" {
+				return discord.AccessTokenResponse{}, discord.ErrOAuthInvalidCode
+			}
+			return discord.AccessTokenResponse{AccessToken: "access", RefreshToken: "refresh"}, nil
+		},
+		UserMeProvider: func() discord.UserMe {
+			return func(token discord.Token) (discord.User, error) {
+				return discordUser, nil
+			}
+		},
+		GuildMemberAdd: func(userAccessToken string, userId string) (discord.GuildAddStatus, error) {
+			guildMembers++
+			return discord.GuildAddStatusSuccess, guildErr
+		},
+	}
+
+	assert.Equal("https://discord.com/api/oauth2/authorize?client_id=1", provider.AuthUrl(""))
+	assert.Equal("https://discord.com/api/oauth2/authorize?client_id=1&state=a.b%2Bc", provider.AuthUrl("a.b+c"))
+
+	_, err := provider.Authenticate(ctx, "invalid")
+	assert.ErrorIs(err, ErrInvalidAuthorizationCode)
+	identity, err := provider.Authenticate(ctx, "code")
+	if assert.NoError(err) {
+		assert.Equal(ExternalIdentity{Provider: ProviderDiscord, Subject: "2137", Email: "makin@discord.com",
+			EmailVerified: true, Name: "makin", AvatarUrl: "https://cdn.discordapp.com/avatars/2137/abc.png", RefreshToken: "refresh"},
+			identity)
+	}
+	assert.Equal(1, guildMembers)
+
+	guildErr = discord.ErrUnauthorized
+	_, err = provider.Authenticate(ctx, "code")
+	assert.ErrorIs(err, ErrIdentityAccessDenied)
+
+	discordUser.Email = ""
+	identity, err = provider.Authenticate(ctx, "code")
+	if assert.NoError(err) {
+		assert.Empty(identity.Email)
+	}
+	assert.Equal(2, guildMembers, "users without email do not join the guild")
+}
+
+func TestGitHubIdentityProvider(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	emails := []github.Email{{Email: "octocat@github.com", Primary: true, Verified: true}}
+	provider := &GitHubIdentityProvider{
+		CreateOAuthUrl: func(state string) string { return "https://github.com/login/oauth/authorize?state=" + state },
+		ExchangeAccessToken: func(code string) (github.AccessTokenResponse, error) {
+			if code != "code" {
+				return github.AccessTokenResponse{}, github.ErrOAuthInvalidCode
+			}
+			return github.AccessTokenResponse{AccessToken: "access"}, nil
+		},
+		UserMe: func(accessToken string) (github.User, error) {
+			if accessToken != "access" {
+				return github.User{}, github.ErrUnauthorized
+			}
+			return github.User{Id: 583231, Login: "octocat", AvatarUrl: "https://github.com/images/octocat.png"}, nil
+		},
+		UserEmails: func(accessToken string) ([]github.Email, error) {
+			return emails, nil
+		},
+	}
+
+	_, err := provider.Authenticate(ctx, "invalid")
+	assert.ErrorIs(err, ErrInvalidAuthorizationCode)
+	identity, err := provider.Authenticate(ctx, "code")
+	if assert.NoError(err) {
+		assert.Equal(ExternalIdentity{Provider: ProviderGitHub, Subject: "583231", Email: "octocat@github.com",
+			EmailVerified: true, Name: "octocat", AvatarUrl: "https://github.com/images/octocat.png"}, identity)
+	}
+
+	emails[0].Verified = false
+	identity, err = provider.Authenticate(ctx, "code")
+	if assert.NoError(err) {
+		assert.Empty(identity.Email, "unverified email")
+		assert.False(identity.EmailVerified)
+	}
+
+	provider.UserEmails = func(accessToken string) ([]github.Email, error) {
+		return nil, errors.New("unexpected")
+	}
+	_, err = provider.Authenticate(ctx, "code")
+	assert.Error(err)
+}
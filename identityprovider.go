@@ -0,0 +1,109 @@
+package buzza
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/buzkaaclicker/buzza/discord"
+	"github.com/buzkaaclicker/buzza/github"
+)
+
+// Discord login, users are added to the community guild on every login.
+type DiscordIdentityProvider struct {
+	CreateOAuthUrl      discord.OAuthUrlFactory
+	ExchangeAccessToken discord.AccessTokenExchanger
+	UserMeProvider      discord.UserMeProvider
+	GuildMemberAdd      discord.GuildMemberAdd
+}
+
+var _ IdentityProvider = (*DiscordIdentityProvider)(nil)
+
+func (p *DiscordIdentityProvider) AuthUrl(state string) string {
+	authUrl := p.CreateOAuthUrl()
+	if state == "" {
+		return authUrl
+	}
+	return authUrl + "&state=" + url.QueryEscape(state)
+}
+
+func (p *DiscordIdentityProvider) Authenticate(ctx context.Context, code string) (ExternalIdentity, error) {
+	exchange, err := p.ExchangeAccessToken(code)
+	if err != nil {
+		if errors.Is(err, discord.ErrOAuthInvalidCode) {
+			return ExternalIdentity{}, ErrInvalidAuthorizationCode
+		}
+		return ExternalIdentity{}, fmt.Errorf("access token exchange: %w", err)
+	}
+	user, err := p.UserMeProvider()(exchange.Token())
+	if err != nil {
+		return ExternalIdentity{}, fmt.Errorf("discord user me: %w", err)
+	}
+	identity := ExternalIdentity{
+		Provider:      ProviderDiscord,
+		Subject:       user.Id,
+		Email:         Email(user.Email),
+		EmailVerified: user.Verified,
+		Name:          user.Username,
+		AvatarUrl:     user.AvatarUrl(),
+		RefreshToken:  exchange.RefreshToken,
+	}
+	// accounts without email are rejected anyway, so they do not join the guild
+	if identity.Email == "" {
+		return identity, nil
+	}
+	if _, err := p.GuildMemberAdd(exchange.AccessToken, user.Id); err != nil {
+		if errors.Is(err, discord.ErrUnauthorized) {
+			return ExternalIdentity{}, ErrIdentityAccessDenied
+		}
+		return ExternalIdentity{}, fmt.Errorf("discord guild member add: %w", err)
+	}
+	return identity, nil
+}
+
+// GitHub login, only primary verified emails are accepted.
+type GitHubIdentityProvider struct {
+	CreateOAuthUrl      github.OAuthUrlFactory
+	ExchangeAccessToken github.AccessTokenExchanger
+	UserMe              github.UserMe
+	UserEmails          github.UserEmails
+}
+
+var _ IdentityProvider = (*GitHubIdentityProvider)(nil)
+
+func (p *GitHubIdentityProvider) AuthUrl(state string) string {
+	return p.CreateOAuthUrl(state)
+}
+
+func (p *GitHubIdentityProvider) Authenticate(ctx context.Context, code string) (ExternalIdentity, error) {
+	exchange, err := p.ExchangeAccessToken(code)
+	if err != nil {
+		if errors.Is(err, github.ErrOAuthInvalidCode) {
+			return ExternalIdentity{}, ErrInvalidAuthorizationCode
+		}
+		return ExternalIdentity{}, fmt.Errorf("access token exchange: %w", err)
+	}
+	user, err := p.UserMe(exchange.AccessToken)
+	if err != nil {
+		return ExternalIdentity{}, fmt.Errorf("github user me: %w", err)
+	}
+	emails, err := p.UserEmails(exchange.AccessToken)
+	if err != nil {
+		if errors.Is(err, github.ErrUnauthorized) {
+			return ExternalIdentity{}, ErrIdentityAccessDenied
+		}
+		return ExternalIdentity{}, fmt.Errorf("github user emails: %w", err)
+	}
+	email := github.PrimaryVerifiedEmail(emails)
+	return ExternalIdentity{
+		Provider:      ProviderGitHub,
+		Subject:       strconv.FormatInt(user.Id, 10),
+		Email:         Email(email),
+		EmailVerified: email != "",
+		Name:          user.Login,
+		AvatarUrl:     user.AvatarUrl,
+		RefreshToken:  exchange.RefreshToken,
+	}, nil
+}
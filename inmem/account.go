@@ -0,0 +1,87 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type AccountDeletionStore struct {
+	// Erased users are removed from it if set.
+	Users     *UserStore
+	deletions map[buzza.UserId]buzza.AccountDeletion
+	mutex     sync.Mutex
+}
+
+var _ buzza.AccountDeletionStore = (*AccountDeletionStore)(nil)
+
+func (s *AccountDeletionStore) Schedule(ctx context.Context, deletion buzza.AccountDeletion) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.deletions == nil {
+		s.deletions = map[buzza.UserId]buzza.AccountDeletion{}
+	}
+	if _, ok := s.deletions[deletion.UserId]; ok {
+		return buzza.ErrAccountDeletionScheduled
+	}
+	s.deletions[deletion.UserId] = deletion
+	return nil
+}
+
+func (s *AccountDeletionStore) ByUserId(ctx context.Context, userId buzza.UserId) (buzza.AccountDeletion, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	deletion, ok := s.deletions[userId]
+	if !ok {
+		return buzza.AccountDeletion{}, buzza.ErrAccountDeletionNotFound
+	}
+	return deletion, nil
+}
+
+func (s *AccountDeletionStore) Cancel(ctx context.Context, userId buzza.UserId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.deletions[userId]; !ok {
+		return buzza.ErrAccountDeletionNotFound
+	}
+	delete(s.deletions, userId)
+	return nil
+}
+
+func (s *AccountDeletionStore) Due(ctx context.Context, now time.Time, limit int) ([]buzza.AccountDeletion, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	due := make([]buzza.AccountDeletion, 0)
+	for _, deletion := range s.deletions {
+		if !deletion.DeleteAt.After(now) {
+			due = append(due, deletion)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].DeleteAt.Equal(due[j].DeleteAt) {
+			return due[i].DeleteAt.Before(due[j].DeleteAt)
+		}
+		return due[i].UserId < due[j].UserId
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (s *AccountDeletionStore) Erase(ctx context.Context, userId buzza.UserId, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	deletion, ok := s.deletions[userId]
+	if !ok || deletion.DeleteAt.After(now) {
+		return buzza.ErrAccountDeletionNotFound
+	}
+	delete(s.deletions, userId)
+	if s.Users != nil {
+		s.Users.erase(userId)
+	}
+	return nil
+}
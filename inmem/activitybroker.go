@@ -0,0 +1,73 @@
+package inmem
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+// Buffer of the subscriber channel. Subscribers falling further behind are dropped.
+const activitySubscriberBuffer = 64
+
+// Fans out activity logs to the subscribers within the process.
+type ActivityBroker struct {
+	subscribers map[buzza.UserId]map[chan buzza.ActivityLog]struct{}
+	mutex       sync.Mutex
+}
+
+var _ buzza.ActivityBroker = (*ActivityBroker)(nil)
+
+func NewActivityBroker() ActivityBroker {
+	return ActivityBroker{
+		subscribers: make(map[buzza.UserId]map[chan buzza.ActivityLog]struct{}),
+		mutex:       sync.Mutex{},
+	}
+}
+
+func (b *ActivityBroker) Publish(ctx context.Context, log buzza.ActivityLog) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for subscriber := range b.subscribers[log.UserId] {
+		select {
+		case subscriber <- log:
+		default:
+			// never block publisher on a slow subscriber, it will resume from the last received log
+			b.unsubscribe(log.UserId, subscriber)
+		}
+	}
+	return nil
+}
+
+func (b *ActivityBroker) Subscribe(userId buzza.UserId) (<-chan buzza.ActivityLog, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subscriber := make(chan buzza.ActivityLog, activitySubscriberBuffer)
+	userSubscribers, ok := b.subscribers[userId]
+	if !ok {
+		userSubscribers = make(map[chan buzza.ActivityLog]struct{})
+		b.subscribers[userId] = userSubscribers
+	}
+	userSubscribers[subscriber] = struct{}{}
+
+	return subscriber, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		b.unsubscribe(userId, subscriber)
+	}
+}
+
+// Must be called with the mutex held.
+func (b *ActivityBroker) unsubscribe(userId buzza.UserId, subscriber chan buzza.ActivityLog) {
+	userSubscribers := b.subscribers[userId]
+	if _, ok := userSubscribers[subscriber]; !ok {
+		return
+	}
+	delete(userSubscribers, subscriber)
+	close(subscriber)
+	if len(userSubscribers) == 0 {
+		delete(b.subscribers, userId)
+	}
+}
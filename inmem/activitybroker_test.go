@@ -0,0 +1,58 @@
+package inmem
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivityBroker(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	broker := NewActivityBroker()
+	first, cancelFirst := broker.Subscribe(1)
+	second, cancelSecond := broker.Subscribe(1)
+	other, cancelOther := broker.Subscribe(2)
+	defer cancelOther()
+
+	assert.NoError(broker.Publish(ctx, buzza.ActivityLog{Id: 1, UserId: 1}))
+	assert.Equal(int64(1), (<-first).Id)
+	assert.Equal(int64(1), (<-second).Id)
+	assert.Len(other, 0)
+
+	cancelFirst()
+	_, ok := <-first
+	assert.False(ok, "channel should be closed after cancel")
+	cancelFirst()
+
+	// slow subscriber is dropped instead of blocking the publisher
+	for i := 0; i <= activitySubscriberBuffer; i++ {
+		assert.NoError(broker.Publish(ctx, buzza.ActivityLog{Id: int64(i + 2), UserId: 1}))
+	}
+	received := 0
+	for range second {
+		received++
+	}
+	assert.Equal(activitySubscriberBuffer, received)
+	cancelSecond()
+}
+
+func TestActivityStorePublish(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	broker := NewActivityBroker()
+	store := NewActivityStore()
+	store.Broker = &broker
+	logs, cancel := broker.Subscribe(3)
+	defer cancel()
+
+	assert.NoError(store.AddLog(ctx, 3, buzza.Activity{Name: "click_clack"}))
+	log := <-logs
+	assert.Equal("click_clack", log.Name)
+	assert.Equal(buzza.UserId(3), log.UserId)
+	assert.NotZero(log.Id)
+}
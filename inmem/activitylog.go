@@ -3,6 +3,7 @@ package inmem
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -10,22 +11,40 @@ import (
 )
 
 type ActivityStore struct {
+	// Validates logged activities if set.
+	Catalogue buzza.ActivityCatalogue
+	// Receives added logs if set.
+	Broker buzza.ActivityBroker
+
 	lastId int64
 	logs   map[buzza.UserId][]buzza.ActivityLog
+	holds  map[buzza.UserId]buzza.LegalHold
 	mutex  sync.RWMutex
 }
 
 var _ buzza.ActivityStore = (*ActivityStore)(nil)
+var _ buzza.ActivityRetentionStore = (*ActivityStore)(nil)
 
 func NewActivityStore() ActivityStore {
 	return ActivityStore{
 		lastId: 0,
 		logs:   make(map[buzza.UserId][]buzza.ActivityLog),
+		holds:  make(map[buzza.UserId]buzza.LegalHold),
 		mutex:  sync.RWMutex{},
 	}
 }
 
 func (s *ActivityStore) AddLog(ctx context.Context, userId buzza.UserId, activity buzza.Activity) error {
+	if s.Catalogue != nil {
+		var err error
+		activity, err = s.Catalogue.Validate(activity)
+		if err != nil {
+			return err
+		}
+	} else if activity.Version == 0 {
+		activity.Version = 1
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -34,53 +53,188 @@ func (s *ActivityStore) AddLog(ctx context.Context, userId buzza.UserId, activit
 		ulogs = make([]buzza.ActivityLog, 0, 10)
 	}
 	s.lastId++
-	ulogs = append(ulogs, buzza.ActivityLog{
+	log := buzza.ActivityLog{
 		Id:        s.lastId,
-		CreatedAt: time.Time{},
+		CreatedAt: time.Now().UTC(),
 		UserId:    userId,
 		Name:      activity.Name,
+		Version:   activity.Version,
 		Data:      activity.Data,
-	})
-	s.logs[userId] = ulogs
+	}
+	s.logs[userId] = append(ulogs, log)
+
+	if s.Broker != nil {
+		return s.Broker.Publish(ctx, log)
+	}
 	return nil
 }
 
-func (s *ActivityStore) ByUserId(ctx context.Context, userId buzza.UserId, beforeId int64, limit int32) ([]buzza.ActivityLog, error) {
-	const maxLimit = 10_000
-	if limit > maxLimit {
-		return nil, fmt.Errorf("too big limit %d/%d", limit, maxLimit)
+func (s *ActivityStore) ByUserId(ctx context.Context, userId buzza.UserId, query buzza.ActivityQuery) ([]buzza.ActivityLog, error) {
+	if err := validateActivityLimit(query.Limit); err != nil {
+		return nil, err
+	}
+	if query.Limit <= 0 {
+		return []buzza.ActivityLog{}, nil
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	logs := s.logs[userId]
+	filteredLogs := make([]buzza.ActivityLog, 0)
+	// logs are stored in ascending order, so iterate from the end to get newest first
+	for n := 0; n < len(logs) && len(filteredLogs) < query.Limit; n++ {
+		i := len(logs) - 1 - n
+		if query.OldestFirst {
+			i = n
+		}
+		if query.Matches(logs[i]) {
+			filteredLogs = append(filteredLogs, logs[i])
+		}
+	}
+	return filteredLogs, nil
+}
+
+func (s *ActivityStore) Audit(ctx context.Context, query buzza.AuditQuery) ([]buzza.ActivityLog, error) {
+	if err := validateActivityLimit(query.Limit); err != nil {
+		return nil, err
 	}
-	if limit <= 0 {
+	if query.Limit <= 0 {
 		return []buzza.ActivityLog{}, nil
 	}
 
 	s.mutex.RLock()
-	logs, ok := s.logs[userId]
-
-	lastBeforeIdIndex := len(logs)
-	if beforeId >= 0 {
-		for i, l := range logs {
-			if beforeId <= l.Id {
-				lastBeforeIdIndex = i
-				break
+	defer s.mutex.RUnlock()
+
+	filteredLogs := make([]buzza.ActivityLog, 0)
+	for userId, logs := range s.logs {
+		if query.UserId != 0 && userId != query.UserId {
+			continue
+		}
+		for _, log := range logs {
+			if query.Matches(log) {
+				filteredLogs = append(filteredLogs, log)
 			}
 		}
 	}
-	startIndex := lastBeforeIdIndex - int(limit)
-	if startIndex < 0 {
-		startIndex = 0
+	sort.Slice(filteredLogs, func(i, j int) bool {
+		if query.OldestFirst {
+			return filteredLogs[i].Id < filteredLogs[j].Id
+		}
+		return filteredLogs[i].Id > filteredLogs[j].Id
+	})
+	if len(filteredLogs) > query.Limit {
+		filteredLogs = filteredLogs[:query.Limit]
 	}
-	filteredLogs := make([]buzza.ActivityLog, lastBeforeIdIndex - int(startIndex))
-	// copy in a reversed order
-	for i := range filteredLogs {
-		filteredLogs[i] = logs[lastBeforeIdIndex - i - 1]
+	return filteredLogs, nil
+}
+
+func validateActivityLimit(limit int) error {
+	const maxLimit = 10_000
+	if limit > maxLimit {
+		return fmt.Errorf("too big limit %d/%d", limit, maxLimit)
 	}
-	
-	s.mutex.RUnlock()
+	return nil
+}
 
-	if ok {
-		return filteredLogs, nil
-	} else {
-		return []buzza.ActivityLog{}, nil
+func (s *ActivityStore) Expired(ctx context.Context, query buzza.ActivityExpiryQuery) ([]buzza.ActivityLog, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	expired := make([]buzza.ActivityLog, 0)
+	for userId, logs := range s.logs {
+		if _, held := s.holds[userId]; held {
+			continue
+		}
+		for _, log := range logs {
+			if !log.CreatedAt.Before(query.Before) {
+				continue
+			}
+			if len(query.Names) > 0 && !containsString(query.Names, log.Name) {
+				continue
+			}
+			if containsString(query.ExcludeNames, log.Name) {
+				continue
+			}
+			expired = append(expired, log)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].Id < expired[j].Id
+	})
+	if len(expired) > query.Limit {
+		expired = expired[:query.Limit]
+	}
+	return expired, nil
+}
+
+func (s *ActivityStore) DeleteLogs(ctx context.Context, ids []int64) (int64, error) {
+	toDelete := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		toDelete[id] = struct{}{}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var deleted int64
+	for userId, logs := range s.logs {
+		kept := logs[:0]
+		for _, log := range logs {
+			if _, ok := toDelete[log.Id]; ok {
+				deleted++
+			} else {
+				kept = append(kept, log)
+			}
+		}
+		s.logs[userId] = kept
+	}
+	return deleted, nil
+}
+
+func (s *ActivityStore) SetLegalHold(ctx context.Context, userId buzza.UserId, reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hold, ok := s.holds[userId]
+	if !ok {
+		hold = buzza.LegalHold{UserId: userId, CreatedAt: time.Now().UTC()}
+	}
+	hold.Reason = reason
+	s.holds[userId] = hold
+	return nil
+}
+
+func (s *ActivityStore) ReleaseLegalHold(ctx context.Context, userId buzza.UserId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.holds[userId]; !ok {
+		return buzza.ErrLegalHoldNotFound
+	}
+	delete(s.holds, userId)
+	return nil
+}
+
+func (s *ActivityStore) LegalHolds(ctx context.Context) ([]buzza.LegalHold, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	holds := make([]buzza.LegalHold, 0, len(s.holds))
+	for _, hold := range s.holds {
+		holds = append(holds, hold)
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		return holds[i].UserId < holds[j].UserId
+	})
+	return holds, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
+	return false
 }
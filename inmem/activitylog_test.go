@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/storetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,12 +19,12 @@ func TestActivityStore(t *testing.T) {
 
 	s := NewActivityStore()
 	{
-		logs, err := s.ByUserId(ctx, uid, -1, 100)
+		logs, err := s.ByUserId(ctx, uid, buzza.ActivityQuery{Limit: 100})
 		if assert.NoError(err) {
 			assert.Equal(0, len(logs))
 		}
 
-		logs, err = s.ByUserId(ctx, uid, 1000, 100)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: 1000, Limit: 100})
 		if assert.NoError(err) {
 			assert.Equal(0, len(logs))
 		}
@@ -36,7 +37,7 @@ func TestActivityStore(t *testing.T) {
 
 	var firstLog buzza.ActivityLog
 	{
-		logs, err := s.ByUserId(ctx, uid, -1, 100)
+		logs, err := s.ByUserId(ctx, uid, buzza.ActivityQuery{Limit: 100})
 		if !assert.NoError(err) || !assert.Equal(1, len(logs)) {
 			return
 		}
@@ -44,32 +45,32 @@ func TestActivityStore(t *testing.T) {
 		assert.Equal("gdzie_ta_muza", firstLog.Name)
 		assert.Equal(map[string]interface{}{"service": "sc"}, firstLog.Data)
 
-		logs, err = s.ByUserId(ctx, uid, firstLog.Id, 100)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: firstLog.Id, Limit: 100})
 		if assert.NoError(err) {
 			assert.Equal(0, len(logs))
 		}
 
-		logs, err = s.ByUserId(ctx, uid, firstLog.Id+1, 100)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: firstLog.Id + 1, Limit: 100})
 		if assert.NoError(err) && assert.Equal(1, len(logs)) {
 			assert.Equal(firstLog, logs[0])
 		}
 
-		logs, err = s.ByUserId(ctx, uid, math.MaxInt64, 100)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: math.MaxInt64, Limit: 100})
 		if assert.NoError(err) && assert.Equal(1, len(logs)) {
 			assert.Equal(firstLog, logs[0])
 		}
 
-		logs, err = s.ByUserId(ctx, uid, firstLog.Id-1, -5)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: firstLog.Id - 1, Limit: -5})
 		if assert.NoError(err) {
 			assert.Equal(0, len(logs))
 		}
 
-		logs, err = s.ByUserId(ctx, uid, firstLog.Id, -1)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: firstLog.Id, Limit: -1})
 		if assert.NoError(err) {
 			assert.Equal(0, len(logs))
 		}
 
-		logs, err = s.ByUserId(ctx, uid, firstLog.Id+1, 1)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: firstLog.Id + 1, Limit: 1})
 		if assert.NoError(err) && assert.Equal(1, len(logs)) {
 			assert.Equal(firstLog, logs[0])
 		}
@@ -77,7 +78,7 @@ func TestActivityStore(t *testing.T) {
 
 	{
 		// unknown user id
-		logs, err := s.ByUserId(ctx, buzza.UserId(34290), -1, 100)
+		logs, err := s.ByUserId(ctx, buzza.UserId(34290), buzza.ActivityQuery{Limit: 100})
 		if assert.NoError(err) {
 			assert.Equal(0, len(logs))
 		}
@@ -91,24 +92,24 @@ func TestActivityStore(t *testing.T) {
 	}
 
 	{
-		logs, err := s.ByUserId(ctx, uid, -1, 100)
+		logs, err := s.ByUserId(ctx, uid, buzza.ActivityQuery{Limit: 100})
 		if !assert.NoError(err) || !assert.Equal(100, len(logs)) {
 			return
 		}
 
-		logs, err = s.ByUserId(ctx, uid, firstLog.Id, 100)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: firstLog.Id, Limit: 100})
 		fmt.Println("logs", firstLog.Id, logs)
 		if assert.NoError(err) {
 			assert.Equal(0, len(logs))
 		}
 
-		logs, err = s.ByUserId(ctx, uid, firstLog.Id+1, 100)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: firstLog.Id + 1, Limit: 100})
 		if assert.NoError(err) {
 			assert.Equal(1, len(logs))
 			assert.Equal(firstLog, logs[0])
 		}
 
-		logs, err = s.ByUserId(ctx, uid, firstLog.Id+201, 200)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: firstLog.Id + 201, Limit: 200})
 		if assert.NoError(err) {
 			assert.Equal(200, len(logs))
 			for i := 0; i < 200; i++ {
@@ -116,7 +117,7 @@ func TestActivityStore(t *testing.T) {
 			}
 		}
 
-		logs, err = s.ByUserId(ctx, uid, firstLog.Id+200, 200)
+		logs, err = s.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: firstLog.Id + 200, Limit: 200})
 		if assert.NoError(err) {
 			assert.Equal(200, len(logs))
 			for i := 0; i < 199; i++ {
@@ -126,3 +127,29 @@ func TestActivityStore(t *testing.T) {
 		}
 	}
 }
+
+func TestActivityStoreConformance(t *testing.T) {
+	store := NewActivityStore()
+	storetest.RunActivityStoreTests(t, &store)
+}
+
+func TestActivityStoreCatalogue(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	s := NewActivityStore()
+	s.Catalogue = buzza.DefaultActivityCatalogue
+	assert.ErrorIs(s.AddLog(ctx, 1, buzza.Activity{Name: "gdzie_ta_muza"}), buzza.ErrUnknownActivity)
+	assert.NoError(s.AddLog(ctx, 1, buzza.SessionCreatedActivity("id", "127.0.0.1", "Firefox")))
+
+	logs, err := s.ByUserId(ctx, 1, buzza.ActivityQuery{Limit: 10})
+	if assert.NoError(err) && assert.Len(logs, 1) {
+		assert.Equal(buzza.ActivitySessionCreated, logs[0].Name)
+		assert.Equal(2, logs[0].Version)
+	}
+}
+
+func TestActivityRetentionStoreConformance(t *testing.T) {
+	store := NewActivityStore()
+	storetest.RunActivityRetentionStoreTests(t, &store)
+}
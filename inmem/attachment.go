@@ -0,0 +1,49 @@
+package inmem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type AttachmentStorage struct {
+	attachments map[string][]byte
+	mutex       sync.RWMutex
+}
+
+var _ buzza.AttachmentStorage = (*AttachmentStorage)(nil)
+
+func NewAttachmentStorage() AttachmentStorage {
+	return AttachmentStorage{
+		attachments: make(map[string][]byte),
+		mutex:       sync.RWMutex{},
+	}
+}
+
+func (s *AttachmentStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read attachment: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attachments[key] = content
+	return nil
+}
+
+func (s *AttachmentStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	content, ok := s.attachments[key]
+	if !ok {
+		return nil, buzza.ErrAttachmentNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
@@ -0,0 +1,29 @@
+package inmem
+
+import "sync"
+
+type DirectMessage struct {
+	UserId  string
+	Content string
+}
+
+// Keeps discord direct messages in memory instead of sending them.
+// Send matches discord.DirectMessageSend.
+type DirectMessages struct {
+	messages []DirectMessage
+	mutex    sync.Mutex
+}
+
+func (d *DirectMessages) Send(userId string, content string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.messages = append(d.messages, DirectMessage{UserId: userId, Content: content})
+	return nil
+}
+
+// Messages sent so far, oldest first.
+func (d *DirectMessages) Sent() []DirectMessage {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]DirectMessage(nil), d.messages...)
+}
@@ -0,0 +1,24 @@
+package inmem
+
+import (
+	"github.com/buzkaaclicker/buzza"
+)
+
+type Keyring struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+var _ buzza.Keyring = Keyring{}
+
+func (k Keyring) CurrentKeyId() string {
+	return k.Current
+}
+
+func (k Keyring) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, buzza.ErrKeyNotFound
+	}
+	return key, nil
+}
@@ -0,0 +1,40 @@
+package inmem
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type MagicLinkStore struct {
+	links map[string]buzza.MagicLink
+	mutex sync.Mutex
+}
+
+var _ buzza.MagicLinkStore = (*MagicLinkStore)(nil)
+
+func (s *MagicLinkStore) Add(ctx context.Context, link buzza.MagicLink) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.links == nil {
+		s.links = map[string]buzza.MagicLink{}
+	}
+	s.links[link.TokenHash] = link
+	return nil
+}
+
+func (s *MagicLinkStore) Use(ctx context.Context, tokenHash string, now time.Time) (buzza.UserId, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	link, ok := s.links[tokenHash]
+	if !ok {
+		return 0, buzza.ErrInvalidMagicLink
+	}
+	delete(s.links, tokenHash)
+	if !now.Before(link.ExpiresAt) {
+		return 0, buzza.ErrInvalidMagicLink
+	}
+	return link.UserId, nil
+}
@@ -0,0 +1,20 @@
+package inmem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza/storetest"
+)
+
+func TestRateLimiterConformance(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := &RateLimiter{Now: func() time.Time { return now }}
+	storetest.RunRateLimiterTests(t, limiter, func(d time.Duration) {
+		now = now.Add(d)
+	})
+}
+
+func TestMagicLinkStoreConformance(t *testing.T) {
+	storetest.RunMagicLinkStoreTests(t, &MagicLinkStore{}, 1)
+}
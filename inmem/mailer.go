@@ -0,0 +1,30 @@
+package inmem
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+// Keeps sent mails in memory instead of delivering them.
+type Mailer struct {
+	mails []buzza.Mail
+	mutex sync.Mutex
+}
+
+var _ buzza.Mailer = (*Mailer)(nil)
+
+func (m *Mailer) Send(ctx context.Context, mail buzza.Mail) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.mails = append(m.mails, mail)
+	return nil
+}
+
+// Mails sent so far, oldest first.
+func (m *Mailer) Sent() []buzza.Mail {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]buzza.Mail(nil), m.mails...)
+}
@@ -0,0 +1,122 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type PasskeyStore struct {
+	passkeys map[string]buzza.Passkey
+	mutex    sync.Mutex
+}
+
+var _ buzza.PasskeyStore = (*PasskeyStore)(nil)
+
+func NewPasskeyStore() PasskeyStore {
+	return PasskeyStore{
+		passkeys: map[string]buzza.Passkey{},
+		mutex:    sync.Mutex{},
+	}
+}
+
+func (s *PasskeyStore) ByUserId(ctx context.Context, userId buzza.UserId) ([]buzza.Passkey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	passkeys := make([]buzza.Passkey, 0)
+	for _, passkey := range s.passkeys {
+		if passkey.UserId == userId {
+			passkeys = append(passkeys, passkey)
+		}
+	}
+	sort.Slice(passkeys, func(i, j int) bool {
+		return passkeys[i].CreatedAt.Before(passkeys[j].CreatedAt)
+	})
+	return passkeys, nil
+}
+
+func (s *PasskeyStore) ById(ctx context.Context, id string) (buzza.Passkey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	passkey, ok := s.passkeys[id]
+	if !ok {
+		return buzza.Passkey{}, buzza.ErrPasskeyNotFound
+	}
+	return passkey, nil
+}
+
+func (s *PasskeyStore) Add(ctx context.Context, passkey buzza.Passkey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.passkeys[passkey.Id]; ok {
+		return buzza.ErrPasskeyAlreadyRegistered
+	}
+	s.passkeys[passkey.Id] = passkey
+	return nil
+}
+
+func (s *PasskeyStore) UseSignCount(ctx context.Context, id string, signCount uint32, usedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	passkey, ok := s.passkeys[id]
+	if !ok {
+		return buzza.ErrPasskeyNotFound
+	}
+	if signCount <= passkey.SignCount && (signCount != 0 || passkey.SignCount != 0) {
+		return buzza.ErrPasskeyReplayed
+	}
+	passkey.SignCount = signCount
+	passkey.LastUsedAt = usedAt
+	s.passkeys[id] = passkey
+	return nil
+}
+
+func (s *PasskeyStore) Delete(ctx context.Context, userId buzza.UserId, id string) (buzza.Passkey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	passkey, ok := s.passkeys[id]
+	if !ok || passkey.UserId != userId {
+		return buzza.Passkey{}, buzza.ErrPasskeyNotFound
+	}
+	delete(s.passkeys, id)
+	return passkey, nil
+}
+
+type PasskeyChallengeStore struct {
+	challenges map[string]time.Time
+	mutex      sync.Mutex
+}
+
+var _ buzza.PasskeyChallengeStore = (*PasskeyChallengeStore)(nil)
+
+func (s *PasskeyChallengeStore) Add(ctx context.Context, challengeHash string, expiresAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.challenges == nil {
+		s.challenges = map[string]time.Time{}
+	}
+	s.challenges[challengeHash] = expiresAt
+	return nil
+}
+
+func (s *PasskeyChallengeStore) Use(ctx context.Context, challengeHash string, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	expiresAt, ok := s.challenges[challengeHash]
+	if !ok {
+		return buzza.ErrInvalidPasskeyCeremony
+	}
+	delete(s.challenges, challengeHash)
+	if !now.Before(expiresAt) {
+		return buzza.ErrInvalidPasskeyCeremony
+	}
+	return nil
+}
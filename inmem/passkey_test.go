@@ -0,0 +1,11 @@
+package inmem
+
+import (
+	"testing"
+
+	"github.com/buzkaaclicker/buzza/storetest"
+)
+
+func TestPasskeyChallengeStoreConformance(t *testing.T) {
+	storetest.RunPasskeyChallengeStoreTests(t, &PasskeyChallengeStore{})
+}
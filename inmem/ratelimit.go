@@ -0,0 +1,47 @@
+package inmem
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type rateLimitWindow struct {
+	start time.Time
+	hits  int
+}
+
+type RateLimiter struct {
+	// Defaults to time.Now.
+	Now     func() time.Time
+	windows map[string]rateLimitWindow
+	mutex   sync.Mutex
+}
+
+var _ buzza.RateLimiter = (*RateLimiter)(nil)
+
+func (l *RateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	now := time.Now()
+	if l.Now != nil {
+		now = l.Now()
+	}
+	start := buzza.RateLimitWindowStart(now, window)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.windows == nil {
+		l.windows = map[string]rateLimitWindow{}
+	}
+	current := l.windows[key]
+	if !current.start.Equal(start) {
+		current = rateLimitWindow{start: start}
+	}
+	if current.hits >= limit {
+		return false, nil
+	}
+	current.hits++
+	l.windows[key] = current
+	return true, nil
+}
@@ -0,0 +1,327 @@
+package inmem
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/google/uuid"
+)
+
+// Session store for tests and local development. Sessions expire
+// according to the policy and the store clock.
+type SessionStore struct {
+	ActivityStore buzza.ActivityStore
+	// Key of the token hashes.
+	TokenSecret []byte
+	// DefaultSessionPolicy if IdleTimeout is 0.
+	Policy buzza.SessionPolicy
+	// Provides roles for the concurrent sessions limit if set, otherwise Policy.MaxSessions applies.
+	Users buzza.UserStore
+	// Current time, time.Now if nil.
+	Now func() time.Time
+
+	// by token hash, without tokens
+	sessions map[string]buzza.Session
+	mutex    sync.Mutex
+}
+
+func NewSessionStore(activityStore buzza.ActivityStore) SessionStore {
+	return SessionStore{
+		ActivityStore: activityStore,
+		sessions:      map[string]buzza.Session{},
+		mutex:         sync.Mutex{},
+	}
+}
+
+var _ buzza.SessionStore = (*SessionStore)(nil)
+
+func (s *SessionStore) now() time.Time {
+	if s.Now == nil {
+		return time.Now().UTC()
+	}
+	return s.Now().UTC()
+}
+
+func (s *SessionStore) hash(token string) string {
+	return buzza.HashSessionToken(s.TokenSecret, token)
+}
+
+func (s *SessionStore) policy() buzza.SessionPolicy {
+	if s.Policy.IdleTimeout == 0 {
+		return buzza.DefaultSessionPolicy
+	}
+	return s.Policy
+}
+
+// Session by the token hash if it has not expired yet. Requires mutex to be held.
+func (s *SessionStore) get(tokenHash string) (buzza.Session, bool) {
+	session, ok := s.sessions[tokenHash]
+	if !ok {
+		return buzza.Session{}, false
+	}
+	if !s.now().Before(session.ExpiresAt) {
+		delete(s.sessions, tokenHash)
+		return buzza.Session{}, false
+	}
+	return session, true
+}
+
+// Token hashes of unexpired user sessions, oldest first. Requires mutex to be held.
+func (s *SessionStore) userSessions(userId buzza.UserId) []string {
+	hashes := make([]string, 0)
+	for tokenHash, session := range s.sessions {
+		if session.UserId != userId {
+			continue
+		}
+		if _, ok := s.get(tokenHash); ok {
+			hashes = append(hashes, tokenHash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		a, b := s.sessions[hashes[i]], s.sessions[hashes[j]]
+		if a.CreatedAt.Equal(b.CreatedAt) {
+			return a.Id < b.Id
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+	return hashes
+}
+
+// Token hash of unexpired user session. Requires mutex to be held.
+func (s *SessionStore) byId(userId buzza.UserId, sessionId string) (string, bool) {
+	for _, tokenHash := range s.userSessions(userId) {
+		if s.sessions[tokenHash].Id == sessionId {
+			return tokenHash, true
+		}
+	}
+	return "", false
+}
+
+func (s *SessionStore) RegisterNew(ctx context.Context, userId buzza.UserId, ip string, userAgent string) (buzza.Session, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return buzza.Session{}, fmt.Errorf("generate token: %w", err)
+	}
+	policy := s.policy()
+	limit := policy.MaxSessions
+	if s.Users != nil {
+		user, err := s.Users.ById(ctx, userId)
+		if err != nil {
+			return buzza.Session{}, fmt.Errorf("get user: %w", err)
+		}
+		limit = policy.SessionLimit(user.Roles)
+	}
+
+	session := buzza.Session{
+		Id:        uuid.New().String(),
+		UserId:    userId,
+		Ip:        ip,
+		UserAgent: userAgent,
+		Agent:     buzza.ParseUserAgent(userAgent),
+	}
+	activity := buzza.SessionCreatedActivity(session.Id, ip, userAgent)
+	if err := s.ActivityStore.AddLog(ctx, userId, activity); err != nil {
+		return buzza.Session{}, fmt.Errorf("add session_created activity log: %w", err)
+	}
+
+	s.mutex.Lock()
+	now := s.now()
+	session.CreatedAt = now
+	session.LastAccessedAt = now
+	session.ExpiresAt = policy.ExpiresAt(session)
+	s.sessions[s.hash(token)] = session
+
+	var evicted []buzza.Session
+	if limit != 0 {
+		hashes := s.userSessions(userId)
+		for i := 0; i < len(hashes)-limit; i++ {
+			if s.sessions[hashes[i]].Id == session.Id {
+				continue
+			}
+			evicted = append(evicted, s.sessions[hashes[i]])
+			delete(s.sessions, hashes[i])
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, e := range evicted {
+		activity := buzza.SessionEvictedActivity(e.Id, e.UserAgent, limit)
+		if err := s.ActivityStore.AddLog(ctx, userId, activity); err != nil {
+			return buzza.Session{}, fmt.Errorf("log session eviction: %w", err)
+		}
+	}
+	session.Token = token
+	return session, nil
+}
+
+func (s *SessionStore) ByToken(token string) (buzza.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.get(s.hash(token))
+	if !ok {
+		return buzza.Session{}, buzza.ErrSessionNotFound
+	}
+	session.Token = token
+	return session, nil
+}
+
+func (s *SessionStore) Exists(token string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.get(s.hash(token))
+	return ok, nil
+}
+
+func (s *SessionStore) ActiveSessions(token string) ([]buzza.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.get(s.hash(token))
+	if !ok {
+		return nil, buzza.ErrSessionNotFound
+	}
+	hashes := s.userSessions(session.UserId)
+	sessions := make([]buzza.Session, len(hashes))
+	for i, tokenHash := range hashes {
+		sessions[i] = s.sessions[tokenHash]
+	}
+	return sessions, nil
+}
+
+func (s *SessionStore) AcquireAndRefresh(ctx context.Context, token string, ip string, userAgent string) (buzza.Session, error) {
+	tokenHash := s.hash(token)
+	s.mutex.Lock()
+	previous, ok := s.get(tokenHash)
+	if !ok {
+		s.mutex.Unlock()
+		return buzza.Session{}, buzza.ErrSessionNotFound
+	}
+	session := previous
+	session.Ip = ip
+	session.UserAgent = userAgent
+	if previous.UserAgent != userAgent {
+		session.Agent = buzza.ParseUserAgent(userAgent)
+	}
+	session.LastAccessedAt = s.now()
+	session.ExpiresAt = s.policy().ExpiresAt(session)
+	s.sessions[tokenHash] = session
+	s.mutex.Unlock()
+
+	if previous.Ip != ip {
+		activity := buzza.SessionChangedIpActivity(session.Id, previous.Ip, ip)
+		if err := s.ActivityStore.AddLog(ctx, session.UserId, activity); err != nil {
+			return buzza.Session{}, fmt.Errorf("log ip change: %w", err)
+		}
+	}
+	if previous.UserAgent != userAgent {
+		activity := buzza.SessionChangedUserAgentActivity(session.Id, previous.UserAgent, userAgent)
+		if err := s.ActivityStore.AddLog(ctx, session.UserId, activity); err != nil {
+			return buzza.Session{}, fmt.Errorf("log useragent change: %w", err)
+		}
+	}
+	session.Token = token
+	return session, nil
+}
+
+func (s *SessionStore) UpdateById(ctx context.Context, userId buzza.UserId, sessionId string,
+	update buzza.SessionUpdate) (buzza.Session, error) {
+	s.mutex.Lock()
+	tokenHash, ok := s.byId(userId, sessionId)
+	if !ok {
+		s.mutex.Unlock()
+		return buzza.Session{}, buzza.ErrSessionNotFound
+	}
+	session := s.sessions[tokenHash]
+	if update.Name != nil {
+		session.Name = *update.Name
+	}
+	if update.Pinned != nil {
+		session.Pinned = *update.Pinned
+	}
+	trustChanged := update.Trusted != nil && *update.Trusted != session.Trusted
+	if trustChanged {
+		session.Trusted = *update.Trusted
+		session.ExpiresAt = s.policy().ExpiresAt(session)
+	}
+	s.sessions[tokenHash] = session
+	s.mutex.Unlock()
+
+	if trustChanged {
+		activity := buzza.SessionTrustChangedActivity(session.Id, session.UserAgent, session.Trusted)
+		if err := s.ActivityStore.AddLog(ctx, userId, activity); err != nil {
+			return buzza.Session{}, fmt.Errorf("log trust change: %w", err)
+		}
+	}
+	return session, nil
+}
+
+func (s *SessionStore) InvalidateById(userId buzza.UserId, sessionId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tokenHash, ok := s.byId(userId, sessionId)
+	if !ok {
+		return buzza.ErrSessionNotFound
+	}
+	delete(s.sessions, tokenHash)
+	return nil
+}
+
+func (s *SessionStore) InvalidateByAuthToken(authToken string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tokenHash := s.hash(authToken)
+	if _, ok := s.get(tokenHash); !ok {
+		return buzza.ErrSessionNotFound
+	}
+	delete(s.sessions, tokenHash)
+	return nil
+}
+
+func (s *SessionStore) InvalidateAllExpect(expectToken string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expectHash := s.hash(expectToken)
+	session, ok := s.get(expectHash)
+	if !ok {
+		return buzza.ErrSessionNotFound
+	}
+	for _, tokenHash := range s.userSessions(session.UserId) {
+		if tokenHash != expectHash {
+			delete(s.sessions, tokenHash)
+		}
+	}
+	return nil
+}
+
+func (s *SessionStore) InvalidateByUserId(ctx context.Context, userId buzza.UserId) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	invalidated := len(s.userSessions(userId))
+	// expired sessions are removed as well
+	for tokenHash, session := range s.sessions {
+		if session.UserId == userId {
+			delete(s.sessions, tokenHash)
+		}
+	}
+	return invalidated, nil
+}
+
+func generateSessionToken() (string, error) {
+	rawToken := make([]byte, 60)
+	if _, err := crand.Read(rawToken); err != nil {
+		return "", fmt.Errorf("rand read: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(rawToken), nil
+}
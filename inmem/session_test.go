@@ -0,0 +1,18 @@
+package inmem
+
+import (
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/storetest"
+)
+
+func TestSessionStoreConformance(t *testing.T) {
+	clock, advance := storetest.NewClock()
+	storetest.RunSessionStoreTests(t, func(activityStore buzza.ActivityStore, policy buzza.SessionPolicy) buzza.SessionStore {
+		store := NewSessionStore(activityStore)
+		store.Policy = policy
+		store.Now = clock
+		return &store
+	}, advance)
+}
@@ -0,0 +1,104 @@
+package inmem
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type TwoFactorStore struct {
+	twoFactors map[buzza.UserId]buzza.TwoFactor
+	mutex      sync.Mutex
+}
+
+var _ buzza.TwoFactorStore = (*TwoFactorStore)(nil)
+
+func NewTwoFactorStore() TwoFactorStore {
+	return TwoFactorStore{
+		twoFactors: map[buzza.UserId]buzza.TwoFactor{},
+		mutex:      sync.Mutex{},
+	}
+}
+
+func (s *TwoFactorStore) ByUserId(ctx context.Context, userId buzza.UserId) (buzza.TwoFactor, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	twoFactor, ok := s.twoFactors[userId]
+	if !ok {
+		return buzza.TwoFactor{}, buzza.ErrTwoFactorNotFound
+	}
+	twoFactor.RecoveryCodeHashes = append([]string{}, twoFactor.RecoveryCodeHashes...)
+	return twoFactor, nil
+}
+
+func (s *TwoFactorStore) Save(ctx context.Context, twoFactor buzza.TwoFactor) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	twoFactor.RecoveryCodeHashes = append([]string{}, twoFactor.RecoveryCodeHashes...)
+	s.twoFactors[twoFactor.UserId] = twoFactor
+	return nil
+}
+
+func (s *TwoFactorStore) Delete(ctx context.Context, userId buzza.UserId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.twoFactors[userId]; !ok {
+		return buzza.ErrTwoFactorNotFound
+	}
+	delete(s.twoFactors, userId)
+	return nil
+}
+
+func (s *TwoFactorStore) UseStep(ctx context.Context, userId buzza.UserId, step int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	twoFactor, ok := s.twoFactors[userId]
+	if !ok || twoFactor.LastStep >= step {
+		return buzza.ErrInvalidTwoFactorCode
+	}
+	twoFactor.LastStep = step
+	twoFactor.FailedAttempts = 0
+	s.twoFactors[userId] = twoFactor
+	return nil
+}
+
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userId buzza.UserId, codeHash string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	twoFactor, ok := s.twoFactors[userId]
+	if !ok {
+		return 0, buzza.ErrInvalidTwoFactorCode
+	}
+	for i, hash := range twoFactor.RecoveryCodeHashes {
+		if hash == codeHash {
+			left := make([]string, 0, len(twoFactor.RecoveryCodeHashes)-1)
+			left = append(left, twoFactor.RecoveryCodeHashes[:i]...)
+			twoFactor.RecoveryCodeHashes = append(left, twoFactor.RecoveryCodeHashes[i+1:]...)
+			twoFactor.FailedAttempts = 0
+			s.twoFactors[userId] = twoFactor
+			return len(twoFactor.RecoveryCodeHashes), nil
+		}
+	}
+	return 0, buzza.ErrInvalidTwoFactorCode
+}
+
+func (s *TwoFactorStore) ReserveAttempt(ctx context.Context, userId buzza.UserId, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	twoFactor, ok := s.twoFactors[userId]
+	if !ok || twoFactor.Locked(at) {
+		return buzza.ErrTwoFactorLocked
+	}
+	twoFactor.FailedAttempts++
+	twoFactor.LastFailureAt = at
+	s.twoFactors[userId] = twoFactor
+	return nil
+}
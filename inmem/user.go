@@ -2,46 +2,112 @@ package inmem
 
 import (
 	"context"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/buzkaaclicker/buzza"
-	"github.com/buzkaaclicker/buzza/discord"
 )
 
+type identityKey struct {
+	provider string
+	subject  string
+}
+
 type UserStore struct {
-	lastId int64
-	users  map[buzza.UserId]buzza.User
-	mutex  sync.RWMutex
+	lastId     int64
+	users      map[buzza.UserId]buzza.User
+	identities map[identityKey]buzza.UserIdentity
+	// Users whose email has been verified on the last login.
+	verified map[buzza.UserId]bool
+	mutex    sync.RWMutex
 }
 
 func NewUserStore() UserStore {
 	return UserStore{
-		lastId: 0,
-		users:  map[buzza.UserId]buzza.User{},
-		mutex:  sync.RWMutex{},
+		lastId:     0,
+		users:      map[buzza.UserId]buzza.User{},
+		identities: map[identityKey]buzza.UserIdentity{},
+		verified:   map[buzza.UserId]bool{},
+		mutex:      sync.RWMutex{},
 	}
 }
 
-func (s *UserStore) RegisterDiscordUser(ctx context.Context, u discord.User, refreshToken string) (buzza.User, error) {
+func (s *UserStore) RegisterExternalUser(ctx context.Context, identity buzza.ExternalIdentity) (buzza.User, bool, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	key := identityKey{identity.Provider, identity.Subject}
+	if linked, ok := s.identities[key]; ok {
+		linked.Email = identity.Email
+		linked.RefreshToken = identity.RefreshToken
+		s.identities[key] = linked
+		user := s.users[linked.UserId]
+		if user.Email == "" || (identity.EmailVerified && s.primaryIdentity(user.Id) == key) {
+			if identity.EmailVerified && s.verifiedEmailUser(identity.Email, user.Id) != 0 {
+				return buzza.User{}, false, buzza.ErrEmailInUse
+			}
+			user.Email = identity.Email
+			s.users[user.Id] = user
+			s.verified[user.Id] = identity.EmailVerified
+		}
+		return s.withDiscord(user), false, nil
+	}
+
+	if identity.EmailVerified && s.verifiedEmailUser(identity.Email, 0) != 0 {
+		return buzza.User{}, false, buzza.ErrEmailInUse
+	}
 	s.lastId++
 	uid := buzza.UserId(s.lastId)
 	user := buzza.User{
 		Id:        uid,
 		CreatedAt: time.Now(),
 		Roles:     []buzza.Role{},
-		Discord: buzza.UserDiscord{
-			Id:           u.Id,
-			RefreshToken: refreshToken,
-		},
-		Email: buzza.Email(u.Email),
+		Email:     identity.Email,
 	}
 	s.users[uid] = user
+	s.verified[uid] = identity.EmailVerified
+	s.identities[key] = newUserIdentity(uid, identity)
+
+	return s.withDiscord(user), true, nil
+}
+
+func newUserIdentity(userId buzza.UserId, identity buzza.ExternalIdentity) buzza.UserIdentity {
+	return buzza.UserIdentity{
+		UserId:       userId,
+		Provider:     identity.Provider,
+		Subject:      identity.Subject,
+		Email:        identity.Email,
+		RefreshToken: identity.RefreshToken,
+		LinkedAt:     time.Now(),
+	}
+}
+
+// Key of the first linked identity of the user, must be called with the lock held.
+func (s *UserStore) primaryIdentity(userId buzza.UserId) identityKey {
+	var primary identityKey
+	var linkedAt time.Time
+	for key, identity := range s.identities {
+		if identity.UserId != userId {
+			continue
+		}
+		if linkedAt.IsZero() || identity.LinkedAt.Before(linkedAt) ||
+			(identity.LinkedAt.Equal(linkedAt) && key.provider < primary.provider) {
+			primary, linkedAt = key, identity.LinkedAt
+		}
+	}
+	return primary
+}
 
-	return user, nil
+// User with Discord filled from the discord identity, must be called with the lock held.
+func (s *UserStore) withDiscord(user buzza.User) buzza.User {
+	user.Discord = buzza.UserDiscord{}
+	for _, identity := range s.identities {
+		if identity.UserId == user.Id && identity.Provider == buzza.ProviderDiscord {
+			user.Discord = buzza.UserDiscord{Id: identity.Subject, RefreshToken: identity.RefreshToken}
+		}
+	}
+	return user
 }
 
 func (s *UserStore) ById(ctx context.Context, userId buzza.UserId) (buzza.User, error) {
@@ -52,25 +118,132 @@ func (s *UserStore) ById(ctx context.Context, userId buzza.UserId) (buzza.User,
 	if !ok {
 		return u, buzza.ErrUserNotFound
 	}
-	return u, nil
+	return s.withDiscord(u), nil
 }
 
-func (s *UserStore) ByDiscordId(ctx context.Context, discordId string) (buzza.User, error) {
+func (s *UserStore) ByVerifiedEmail(ctx context.Context, email buzza.Email) (buzza.User, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	for _, u := range s.users {
-		if u.Discord.Id == discordId {
-			return u, nil
+	id := s.verifiedEmailUser(email, 0)
+	if id == 0 {
+		return buzza.User{}, buzza.ErrUserNotFound
+	}
+	return s.withDiscord(s.users[id]), nil
+}
+
+// Id of the user other than except with the verified email, 0 if there is none. Must be called with the lock held.
+func (s *UserStore) verifiedEmailUser(email buzza.Email, except buzza.UserId) buzza.UserId {
+	for id, user := range s.users {
+		if id != except && s.verified[id] && buzza.NormalizeEmail(user.Email) == buzza.NormalizeEmail(email) {
+			return id
 		}
 	}
-	return buzza.User{}, buzza.ErrUserNotFound
+	return 0
+}
+
+func (s *UserStore) ByIdentity(ctx context.Context, provider string, subject string) (buzza.User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	identity, ok := s.identities[identityKey{provider, subject}]
+	if !ok {
+		return buzza.User{}, buzza.ErrUserNotFound
+	}
+	return s.withDiscord(s.users[identity.UserId]), nil
 }
 
 func (s *UserStore) Update(ctx context.Context, user buzza.User) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	user.Discord = buzza.UserDiscord{}
+	if buzza.NormalizeEmail(s.users[user.Id].Email) != buzza.NormalizeEmail(user.Email) {
+		s.verified[user.Id] = false
+	}
 	s.users[user.Id] = user
 	return nil
 }
+
+func (s *UserStore) Identities(ctx context.Context, userId buzza.UserId) ([]buzza.UserIdentity, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.identitiesOf(userId), nil
+}
+
+// Oldest first, must be called with the lock held.
+func (s *UserStore) identitiesOf(userId buzza.UserId) []buzza.UserIdentity {
+	identities := make([]buzza.UserIdentity, 0)
+	for _, identity := range s.identities {
+		if identity.UserId == userId {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		if !identities[i].LinkedAt.Equal(identities[j].LinkedAt) {
+			return identities[i].LinkedAt.Before(identities[j].LinkedAt)
+		}
+		return identities[i].Provider < identities[j].Provider
+	})
+	return identities
+}
+
+func (s *UserStore) LinkIdentity(ctx context.Context, userId buzza.UserId, identity buzza.ExternalIdentity) (buzza.UserIdentity, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.users[userId]; !ok {
+		return buzza.UserIdentity{}, buzza.ErrUserNotFound
+	}
+	key := identityKey{identity.Provider, identity.Subject}
+	if linked, ok := s.identities[key]; ok {
+		if linked.UserId != userId {
+			return buzza.UserIdentity{}, buzza.ErrIdentityLinked
+		}
+		linked.Email = identity.Email
+		linked.RefreshToken = identity.RefreshToken
+		s.identities[key] = linked
+		return linked, nil
+	}
+	for _, linked := range s.identitiesOf(userId) {
+		if linked.Provider == identity.Provider {
+			return buzza.UserIdentity{}, buzza.ErrProviderLinked
+		}
+	}
+	linked := newUserIdentity(userId, identity)
+	s.identities[key] = linked
+	return linked, nil
+}
+
+func (s *UserStore) UnlinkIdentity(ctx context.Context, userId buzza.UserId, provider string) (buzza.UserIdentity, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	identities := s.identitiesOf(userId)
+	for _, identity := range identities {
+		if identity.Provider != provider {
+			continue
+		}
+		if len(identities) == 1 {
+			return buzza.UserIdentity{}, buzza.ErrLastIdentity
+		}
+		delete(s.identities, identityKey{identity.Provider, identity.Subject})
+		return identity, nil
+	}
+	return buzza.UserIdentity{}, buzza.ErrIdentityNotFound
+}
+
+// Remove the user with its identities, so the accounts can register again.
+func (s *UserStore) erase(userId buzza.UserId) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.users, userId)
+	delete(s.verified, userId)
+	for key, identity := range s.identities {
+		if identity.UserId == userId {
+			delete(s.identities, key)
+		}
+	}
+}
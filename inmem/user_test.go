@@ -5,7 +5,6 @@ import (
 	"testing"
 
 	"github.com/buzkaaclicker/buzza"
-	"github.com/buzkaaclicker/buzza/discord"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,20 +16,120 @@ func TestUserStore(t *testing.T) {
 	_, err := s.ById(ctx, 1)
 	assert.Equal(buzza.ErrUserNotFound, err)
 
-	const refreshToken = "ZJKdfsjklAUIdsaioj"
-	u, err := s.RegisterDiscordUser(ctx, discord.User{
-		Id:         "20d93290snowflake",
-		Username:   "indecorum",
-		Email:      "aleja@rejwu.pl",
-		AvatarHash: "SLD",
-	}, refreshToken)
+	identity := buzza.ExternalIdentity{
+		Provider:     buzza.ProviderDiscord,
+		Subject:      "20d93290snowflake",
+		Name:         "indecorum",
+		Email:        "aleja@rejwu.pl",
+		RefreshToken: "ZJKdfsjklAUIdsaioj",
+	}
+	u, created, err := s.RegisterExternalUser(ctx, identity)
 	if !assert.NoError(err) {
 		return
 	}
+	assert.True(created)
+	assert.Equal(buzza.UserDiscord{Id: identity.Subject, RefreshToken: identity.RefreshToken}, u.Discord)
 
 	ufound, err := s.ById(ctx, u.Id)
 	if !assert.NoError(err) {
 		return
 	}
 	assert.Equal(u, ufound)
+
+	identity.Email = "aleja@rejwu.com"
+	uagain, created, err := s.RegisterExternalUser(ctx, identity)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.False(created)
+	assert.Equal(u.Id, uagain.Id)
+	assert.Equal(buzza.Email("aleja@rejwu.pl"), uagain.Email, "unverified email does not replace the existing one")
+
+	identity.EmailVerified = true
+	uagain, _, err = s.RegisterExternalUser(ctx, identity)
+	if assert.NoError(err) {
+		assert.Equal(buzza.Email("aleja@rejwu.com"), uagain.Email)
+	}
+}
+
+func TestUserIdentities(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+
+	s := NewUserStore()
+	discord := buzza.ExternalIdentity{Provider: buzza.ProviderDiscord, Subject: "2137", Email: "user@discord.com"}
+	github := buzza.ExternalIdentity{Provider: buzza.ProviderGitHub, Subject: "583231", Email: "user@github.com"}
+	user, _, err := s.RegisterExternalUser(ctx, discord)
+	if !assert.NoError(err) {
+		return
+	}
+	other, _, err := s.RegisterExternalUser(ctx, buzza.ExternalIdentity{Provider: buzza.ProviderGitHub,
+		Subject: "1", Email: "other@github.com"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = s.UnlinkIdentity(ctx, user.Id, buzza.ProviderDiscord)
+	assert.ErrorIs(err, buzza.ErrLastIdentity)
+	_, err = s.LinkIdentity(ctx, other.Id, discord)
+	assert.ErrorIs(err, buzza.ErrIdentityLinked)
+	_, err = s.LinkIdentity(ctx, other.Id, github)
+	assert.ErrorIs(err, buzza.ErrProviderLinked)
+	linked, err := s.LinkIdentity(ctx, user.Id, github)
+	if assert.NoError(err) {
+		assert.Equal(user.Id, linked.UserId)
+	}
+
+	github.EmailVerified = true
+	loggedIn, created, err := s.RegisterExternalUser(ctx, github)
+	if assert.NoError(err) {
+		assert.False(created)
+		assert.Equal(user.Id, loggedIn.Id)
+		assert.Equal("2137", loggedIn.Discord.Id)
+		assert.Equal(discord.Email, loggedIn.Email, "only the primary identity replaces the email")
+	}
+	identities, err := s.Identities(ctx, user.Id)
+	if assert.NoError(err) && assert.Len(identities, 2) {
+		assert.Equal(buzza.ProviderDiscord, identities[0].Provider)
+		assert.Equal(buzza.ProviderGitHub, identities[1].Provider)
+	}
+
+	_, err = s.UnlinkIdentity(ctx, user.Id, buzza.ProviderDiscord)
+	assert.NoError(err)
+	_, err = s.UnlinkIdentity(ctx, user.Id, buzza.ProviderDiscord)
+	assert.ErrorIs(err, buzza.ErrIdentityNotFound)
+	user, err = s.ById(ctx, user.Id)
+	if assert.NoError(err) {
+		assert.Empty(user.Discord.Id)
+	}
+}
+
+func TestUserVerifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+
+	s := NewUserStore()
+	user, _, err := s.RegisterExternalUser(ctx, buzza.ExternalIdentity{Provider: buzza.ProviderDiscord,
+		Subject: "2137", Email: "user@buzkaaclicker.pl", EmailVerified: true})
+	if !assert.NoError(err) {
+		return
+	}
+	other, _, err := s.RegisterExternalUser(ctx, buzza.ExternalIdentity{Provider: buzza.ProviderDiscord,
+		Subject: "42", Email: "User@buzkaaclicker.pl"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, _, err = s.RegisterExternalUser(ctx, buzza.ExternalIdentity{Provider: buzza.ProviderGitHub,
+		Subject: "1", Email: "user@buzkaaclicker.pl", EmailVerified: true})
+	assert.ErrorIs(err, buzza.ErrEmailInUse)
+	_, _, err = s.RegisterExternalUser(ctx, buzza.ExternalIdentity{Provider: buzza.ProviderDiscord,
+		Subject: "42", Email: "User@buzkaaclicker.pl", EmailVerified: true})
+	assert.ErrorIs(err, buzza.ErrEmailInUse)
+
+	found, err := s.ByVerifiedEmail(ctx, "USER@buzkaaclicker.pl")
+	if assert.NoError(err) {
+		assert.Equal(user.Id, found.Id)
+		assert.NotEqual(other.Id, found.Id)
+	}
 }
@@ -0,0 +1,229 @@
+package buzza
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buzkaaclicker/buzza/discord"
+)
+
+var ErrInvalidRevokeToken = errors.New("invalid revoke token")
+
+// How long the "this wasn't me" link stays valid.
+const DefaultRevokeTokenTTL = 7 * 24 * time.Hour
+
+// Notified about new sessions and sessions used from a different IP or device.
+// Observers may be slow e.g. send messages, so stores call them in the background.
+type SessionObserver interface {
+	SessionSeen(ctx context.Context, session Session) error
+}
+
+// Which login alert channels are enabled by the user. All channels are enabled by default.
+type NotificationSettings struct {
+	LoginEmail   bool
+	LoginDiscord bool
+}
+
+var DefaultNotificationSettings = NotificationSettings{LoginEmail: true, LoginDiscord: true}
+
+type NotificationSettingsStore interface {
+	// Settings of the user, DefaultNotificationSettings if user has not changed them.
+	ByUserId(ctx context.Context, userId UserId) (NotificationSettings, error)
+
+	Save(ctx context.Context, userId UserId, settings NotificationSettings) error
+}
+
+// Result of remembering the IP and device of the session.
+type LoginSourceCheck struct {
+	NewIp     bool
+	NewDevice bool
+	// User had no known login sources before, e.g. it is the first login ever.
+	First bool
+}
+
+type LoginSourceStore interface {
+	// Remember IP and device of the user and check whether they have been seen before.
+	Remember(ctx context.Context, userId UserId, ip string, device string) (LoginSourceCheck, error)
+}
+
+type LoginAlert struct {
+	SessionId string
+	Ip        string
+	UserAgent string
+	NewIp     bool
+	NewDevice bool
+	At        time.Time
+	// "This wasn't me" link revoking the session.
+	RevokeUrl string
+}
+
+// Delivers login alert to the user through a single channel.
+type LoginNotifier interface {
+	NotifyLogin(ctx context.Context, user User, alert LoginAlert) error
+}
+
+// Alerts users about logins from never seen IP addresses or devices.
+type LoginAlerter struct {
+	Sources  LoginSourceStore
+	Settings NotificationSettingsStore
+	Users    UserStore
+	// Optional channels.
+	Email   LoginNotifier
+	Discord LoginNotifier
+	// Signs revoke tokens.
+	RevokeSecret []byte
+	// Page revoking the session, token is passed in the "token" query param.
+	RevokeUrl string
+}
+
+var _ SessionObserver = (*LoginAlerter)(nil)
+
+func (a *LoginAlerter) SessionSeen(ctx context.Context, session Session) error {
+	check, err := a.Sources.Remember(ctx, session.UserId, session.Ip, session.UserAgent)
+	if err != nil {
+		return fmt.Errorf("remember login source: %w", err)
+	}
+	// nothing to compare against on the first login
+	if check.First || (!check.NewIp && !check.NewDevice) {
+		return nil
+	}
+	// user has already vouched for the device
+	if session.Trusted {
+		return nil
+	}
+
+	token := SignRevokeToken(a.RevokeSecret, session.UserId, session.Id, time.Now().Add(DefaultRevokeTokenTTL))
+	alert := LoginAlert{
+		SessionId: session.Id,
+		Ip:        session.Ip,
+		UserAgent: session.UserAgent,
+		NewIp:     check.NewIp,
+		NewDevice: check.NewDevice,
+		At:        time.Now().UTC(),
+		RevokeUrl: a.RevokeUrl + "?token=" + url.QueryEscape(token),
+	}
+	return a.notify(ctx, session.UserId, alert)
+}
+
+func (a *LoginAlerter) notify(ctx context.Context, userId UserId, alert LoginAlert) error {
+	settings, err := a.Settings.ByUserId(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("get notification settings: %w", err)
+	}
+	if !(settings.LoginEmail && a.Email != nil) && !(settings.LoginDiscord && a.Discord != nil) {
+		return nil
+	}
+	user, err := a.Users.ById(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+
+	// one failing channel should not stop the other one
+	var errs []string
+	if settings.LoginEmail && a.Email != nil && user.Email != "" {
+		if err := a.Email.NotifyLogin(ctx, user, alert); err != nil {
+			errs = append(errs, "email: "+err.Error())
+		}
+	}
+	if settings.LoginDiscord && a.Discord != nil && user.Discord.Id != "" {
+		if err := a.Discord.NotifyLogin(ctx, user, alert); err != nil {
+			errs = append(errs, "discord: "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Token authorizing revocation of the single session without logging in.
+func SignRevokeToken(secret []byte, userId UserId, sessionId string, expiresAt time.Time) string {
+	payload := strconv.FormatInt(int64(userId), 10) + "." + sessionId + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(revokeTokenMac(secret, encoded))
+}
+
+func VerifyRevokeToken(secret []byte, token string, now time.Time) (UserId, string, error) {
+	encoded, encodedMac, ok := cut(token, ".")
+	if !ok {
+		return 0, "", ErrInvalidRevokeToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil || !hmac.Equal(mac, revokeTokenMac(secret, encoded)) {
+		return 0, "", ErrInvalidRevokeToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, "", ErrInvalidRevokeToken
+	}
+	parts := strings.Split(string(payload), ".")
+	if len(parts) != 3 {
+		return 0, "", ErrInvalidRevokeToken
+	}
+	userId, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidRevokeToken
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresAt, 0)) {
+		return 0, "", ErrInvalidRevokeToken
+	}
+	return UserId(userId), parts[1], nil
+}
+
+func revokeTokenMac(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("session-revoke:" + payload))
+	return mac.Sum(nil)
+}
+
+// strings.Cut is not available in go 1.17.
+func cut(s string, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+func loginAlertText(alert LoginAlert) string {
+	var b strings.Builder
+	switch {
+	case alert.NewIp && alert.NewDevice:
+		b.WriteString("Your Buzkaa Clicker account has been used from a new IP address and device.\n\n")
+	case alert.NewDevice:
+		b.WriteString("Your Buzkaa Clicker account has been used from a new device.\n\n")
+	default:
+		b.WriteString("Your Buzkaa Clicker account has been used from a new IP address.\n\n")
+	}
+	fmt.Fprintf(&b, "IP: %s\nDevice: %s\nTime: %s\n\n", alert.Ip, alert.UserAgent, alert.At.Format(time.RFC1123))
+	fmt.Fprintf(&b, "If it wasn't you, sign the session out: %s\n", alert.RevokeUrl)
+	return b.String()
+}
+
+type EmailLoginNotifier struct {
+	Mailer Mailer
+}
+
+func (n *EmailLoginNotifier) NotifyLogin(ctx context.Context, user User, alert LoginAlert) error {
+	return n.Mailer.Send(ctx, Mail{
+		To:      user.Email,
+		Subject: "New login to your Buzkaa Clicker account",
+		Body:    loginAlertText(alert),
+	})
+}
+
+type DiscordLoginNotifier struct {
+	Send discord.DirectMessageSend
+}
+
+func (n *DiscordLoginNotifier) NotifyLogin(ctx context.Context, user User, alert LoginAlert) error {
+	return n.Send(user.Discord.Id, loginAlertText(alert))
+}
@@ -0,0 +1,183 @@
+package buzza
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevokeToken(t *testing.T) {
+	assert := assert.New(t)
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Unix(1641049200, 0)
+	token := SignRevokeToken(secret, 42, "5c8e1f0e-0b7a-4a53-9d0a-1f4f3b2c1d00", now.Add(time.Hour))
+
+	userId, sessionId, err := VerifyRevokeToken(secret, token, now)
+	if assert.NoError(err) {
+		assert.Equal(UserId(42), userId)
+		assert.Equal("5c8e1f0e-0b7a-4a53-9d0a-1f4f3b2c1d00", sessionId)
+	}
+
+	_, _, err = VerifyRevokeToken(secret, token, now.Add(time.Hour))
+	assert.ErrorIs(err, ErrInvalidRevokeToken, "expired token")
+	_, _, err = VerifyRevokeToken([]byte("other secret"), token, now)
+	assert.ErrorIs(err, ErrInvalidRevokeToken, "other secret")
+	other := SignRevokeToken(secret, 43, "5c8e1f0e-0b7a-4a53-9d0a-1f4f3b2c1d00", now.Add(time.Hour))
+	payload := strings.Split(other, ".")[0]
+	_, _, err = VerifyRevokeToken(secret, payload+"."+strings.Split(token, ".")[1], now)
+	assert.ErrorIs(err, ErrInvalidRevokeToken, "swapped payload")
+	for _, invalid := range []string{"", ".", "abc", "abc.def"} {
+		_, _, err = VerifyRevokeToken(secret, invalid, now)
+		assert.ErrorIs(err, ErrInvalidRevokeToken, invalid)
+	}
+}
+
+type fakeLoginSources map[UserId]map[string]bool
+
+func (s fakeLoginSources) Remember(ctx context.Context, userId UserId, ip string, device string) (LoginSourceCheck, error) {
+	known, ok := s[userId]
+	if !ok {
+		known = make(map[string]bool)
+		s[userId] = known
+	}
+	check := LoginSourceCheck{First: len(known) == 0, NewIp: !known["ip:"+ip], NewDevice: !known["device:"+device]}
+	known["ip:"+ip] = true
+	known["device:"+device] = true
+	return check, nil
+}
+
+type fakeNotificationSettings map[UserId]NotificationSettings
+
+func (s fakeNotificationSettings) ByUserId(ctx context.Context, userId UserId) (NotificationSettings, error) {
+	if settings, ok := s[userId]; ok {
+		return settings, nil
+	}
+	return DefaultNotificationSettings, nil
+}
+
+func (s fakeNotificationSettings) Save(ctx context.Context, userId UserId, settings NotificationSettings) error {
+	s[userId] = settings
+	return nil
+}
+
+type fakeUsers map[UserId]User
+
+func (s fakeUsers) RegisterExternalUser(ctx context.Context, identity ExternalIdentity) (User, bool, error) {
+	return User{}, false, errors.New("not implemented")
+}
+
+func (s fakeUsers) ById(ctx context.Context, userId UserId) (User, error) {
+	if user, ok := s[userId]; ok {
+		return user, nil
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (s fakeUsers) ByVerifiedEmail(ctx context.Context, email Email) (User, error) {
+	found := User{}
+	for _, user := range s {
+		if NormalizeEmail(user.Email) == NormalizeEmail(email) && (found.Id == 0 || user.Id < found.Id) {
+			found = user
+		}
+	}
+	if found.Id == 0 {
+		return User{}, ErrUserNotFound
+	}
+	return found, nil
+}
+
+func (s fakeUsers) Update(ctx context.Context, user User) error {
+	return errors.New("not implemented")
+}
+
+func (s fakeUsers) Identities(ctx context.Context, userId UserId) ([]UserIdentity, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s fakeUsers) LinkIdentity(ctx context.Context, userId UserId, identity ExternalIdentity) (UserIdentity, error) {
+	return UserIdentity{}, errors.New("not implemented")
+}
+
+func (s fakeUsers) UnlinkIdentity(ctx context.Context, userId UserId, provider string) (UserIdentity, error) {
+	return UserIdentity{}, errors.New("not implemented")
+}
+
+type fakeMailer struct {
+	mails []Mail
+}
+
+func (m *fakeMailer) Send(ctx context.Context, mail Mail) error {
+	m.mails = append(m.mails, mail)
+	return nil
+}
+
+func TestLoginAlerter(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	mailer := &fakeMailer{}
+	var directMessages []string
+	settings := fakeNotificationSettings{}
+	alerter := &LoginAlerter{
+		Sources:  fakeLoginSources{},
+		Settings: settings,
+		Users: fakeUsers{
+			1: {Id: 1, Email: "jan@example.com", Discord: UserDiscord{Id: "123"}},
+		},
+		Email: &EmailLoginNotifier{Mailer: mailer},
+		Discord: &DiscordLoginNotifier{Send: func(userId string, content string) error {
+			directMessages = append(directMessages, userId+": "+content)
+			return nil
+		}},
+		RevokeSecret: secret,
+		RevokeUrl:    "https://buzkaaclicker.pl/revoke-session",
+	}
+
+	sessions := []Session{
+		// first login ever is not alerted
+		{Id: "a", UserId: 1, Ip: "1.1.1.1", UserAgent: "Firefox"},
+		{Id: "b", UserId: 1, Ip: "1.1.1.1", UserAgent: "Firefox"},
+		{Id: "c", UserId: 1, Ip: "2.2.2.2", UserAgent: "Firefox"},
+	}
+	for _, session := range sessions {
+		assert.NoError(alerter.SessionSeen(ctx, session))
+	}
+	if !assert.Len(mailer.mails, 1) || !assert.Len(directMessages, 1) {
+		return
+	}
+	mail := mailer.mails[0]
+	assert.Equal(Email("jan@example.com"), mail.To)
+	assert.Contains(mail.Body, "new IP address.")
+	assert.Contains(mail.Body, "IP: 2.2.2.2")
+	assert.True(strings.HasPrefix(directMessages[0], "123: "))
+
+	// revoke link revokes the alerted session
+	revokeUrl := mail.Body[strings.Index(mail.Body, "https://"):]
+	parsed, err := url.Parse(strings.TrimSpace(revokeUrl))
+	if assert.NoError(err) {
+		userId, sessionId, err := VerifyRevokeToken(secret, parsed.Query().Get("token"), time.Now())
+		if assert.NoError(err) {
+			assert.Equal(UserId(1), userId)
+			assert.Equal("c", sessionId)
+		}
+	}
+
+	// opted out channels are skipped
+	settings[1] = NotificationSettings{LoginEmail: false, LoginDiscord: true}
+	assert.NoError(alerter.SessionSeen(ctx, Session{Id: "d", UserId: 1, Ip: "2.2.2.2", UserAgent: "Chrome"}))
+	assert.Len(mailer.mails, 1)
+	if assert.Len(directMessages, 2) {
+		assert.Contains(directMessages[1], "new device.")
+	}
+
+	// trusted sessions are not alerted
+	assert.NoError(alerter.SessionSeen(ctx, Session{Id: "d", UserId: 1, Ip: "3.3.3.3", UserAgent: "Chrome", Trusted: true}))
+	assert.Len(directMessages, 2)
+}
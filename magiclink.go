@@ -0,0 +1,156 @@
+package buzza
+
+import (
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// Link is unknown, expired or already used.
+	ErrInvalidMagicLink     = errors.New("invalid magic link")
+	ErrMagicLinkRateLimited = errors.New("too many magic link requests")
+)
+
+const (
+	DefaultMagicLinkTTL = 15 * time.Minute
+	// Links sent to a single email per window.
+	MagicLinkEmailLimit  = 3
+	MagicLinkEmailWindow = time.Hour
+	// Links requested from a single IP per window, whether the email exists or not.
+	MagicLinkIpLimit  = 10
+	MagicLinkIpWindow = time.Hour
+)
+
+// Single-use login link, only the token hash is stored.
+type MagicLink struct {
+	TokenHash string
+	UserId    UserId
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type MagicLinkStore interface {
+	Add(ctx context.Context, link MagicLink) error
+
+	// Mark the link used and return its user.
+	// ErrInvalidMagicLink if there is no such link, it has expired or has been used already.
+	Use(ctx context.Context, tokenHash string, now time.Time) (UserId, error)
+}
+
+// Passwordless login with links mailed to the verified email of the user.
+type MagicLinkAuthenticator struct {
+	Users   UserStore
+	Store   MagicLinkStore
+	Limiter RateLimiter
+	Mailer  Mailer
+	// Page exchanging the token for the session, the token is passed in the "token" query parameter.
+	LoginUrl string
+	// Keys rate limits of the emails, so they are not kept in plaintext.
+	Secret []byte
+	// Defaults to time.Now.
+	Now func() time.Time
+}
+
+func (a *MagicLinkAuthenticator) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+	return time.Now()
+}
+
+// ErrMagicLinkRateLimited if too many links have been requested for the email or from the IP.
+func (a *MagicLinkAuthenticator) Limit(ctx context.Context, email Email, ip string) error {
+	email = NormalizeEmail(email)
+	allowed, err := a.Limiter.Allow(ctx, "magic_link:ip:"+ip, MagicLinkIpLimit, MagicLinkIpWindow)
+	if err != nil {
+		return fmt.Errorf("rate limit ip: %w", err)
+	}
+	if !allowed {
+		return ErrMagicLinkRateLimited
+	}
+	allowed, err = a.Limiter.Allow(ctx, "magic_link:email:"+EmailBlindIndex(a.Secret, email),
+		MagicLinkEmailLimit, MagicLinkEmailWindow)
+	if err != nil {
+		return fmt.Errorf("rate limit email: %w", err)
+	}
+	if !allowed {
+		return ErrMagicLinkRateLimited
+	}
+	return nil
+}
+
+// Mail the login link to the user with the verified email, requests are limited by Limit first.
+// Unknown emails are ignored silently, but they are answered faster, so requesters must not wait for it
+// to find out who has an account.
+func (a *MagicLinkAuthenticator) Send(ctx context.Context, email Email, ip string) error {
+	user, err := a.Users.ByVerifiedEmail(ctx, NormalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil
+		}
+		return fmt.Errorf("get user: %w", err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := crand.Read(raw); err != nil {
+		return fmt.Errorf("generate token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	now := a.now()
+	err = a.Store.Add(ctx, MagicLink{
+		TokenHash: HashMagicLinkToken(token),
+		UserId:    user.Id,
+		CreatedAt: now,
+		ExpiresAt: now.Add(DefaultMagicLinkTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("add magic link: %w", err)
+	}
+	return a.Mailer.Send(ctx, Mail{
+		To:      user.Email,
+		Subject: "Sign in to Buzkaa Clicker",
+		Body:    magicLinkText(a.LoginUrl+"?token="+url.QueryEscape(token), ip),
+	})
+}
+
+// User of the link, the link can not be used again.
+func (a *MagicLinkAuthenticator) Login(ctx context.Context, token string) (UserId, error) {
+	if token == "" {
+		return 0, ErrInvalidMagicLink
+	}
+	return a.Store.Use(ctx, HashMagicLinkToken(token), a.now())
+}
+
+func magicLinkText(link string, ip string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Use the link below to sign in to your Buzkaa Clicker account. "+
+		"It can be used once and expires in %d minutes.\n\n", int(DefaultMagicLinkTTL.Minutes()))
+	fmt.Fprintf(&b, "%s\n\n", link)
+	fmt.Fprintf(&b, "The link has been requested from IP %s. If it wasn't you, ignore this mail.\n", ip)
+	return b.String()
+}
+
+func HashMagicLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func NormalizeEmail(email Email) Email {
+	return Email(strings.ToLower(strings.TrimSpace(string(email))))
+}
+
+// Deterministic keyed hash of the normalized email, lets encrypted emails be looked up.
+func EmailBlindIndex(key []byte, email Email) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("email-index:" + string(NormalizeEmail(email))))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,113 @@
+package buzza
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testMagicLinkStore map[string]MagicLink
+
+func (s testMagicLinkStore) Add(ctx context.Context, link MagicLink) error {
+	s[link.TokenHash] = link
+	return nil
+}
+
+func (s testMagicLinkStore) Use(ctx context.Context, tokenHash string, now time.Time) (UserId, error) {
+	link, ok := s[tokenHash]
+	if !ok || !now.Before(link.ExpiresAt) {
+		return 0, ErrInvalidMagicLink
+	}
+	delete(s, tokenHash)
+	return link.UserId, nil
+}
+
+type testRateLimiter map[string]int
+
+func (l testRateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	if l[key] >= limit {
+		return false, nil
+	}
+	l[key]++
+	return true, nil
+}
+
+func TestMagicLinkAuthenticator(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	mailer := &fakeMailer{}
+	limiter := testRateLimiter{}
+	authenticator := &MagicLinkAuthenticator{
+		Users:    fakeUsers{7: {Id: 7, Email: "Makin@Buzkaa.pl"}},
+		Store:    testMagicLinkStore{},
+		Limiter:  limiter,
+		Mailer:   mailer,
+		LoginUrl: "https://buzkaaclicker.pl/magic-link",
+		Secret:   []byte("secret"),
+		Now:      func() time.Time { return now },
+	}
+	send := func(email Email, ip string) error {
+		if err := authenticator.Limit(ctx, email, ip); err != nil {
+			return err
+		}
+		return authenticator.Send(ctx, email, ip)
+	}
+
+	assert.NoError(send("unknown@buzkaa.pl", "1.1.1.1"))
+	assert.Empty(mailer.mails, "unknown emails are ignored")
+
+	if !assert.NoError(send(" makin@buzkaa.pl", "1.1.1.1")) || !assert.Len(mailer.mails, 1) {
+		return
+	}
+	mail := mailer.mails[0]
+	assert.Equal(Email("Makin@Buzkaa.pl"), mail.To)
+	assert.Contains(mail.Body, "1.1.1.1")
+	link := regexp.MustCompile(`https://buzkaaclicker\.pl/magic-link\?token=\S+`).FindString(mail.Body)
+	if !assert.NotEmpty(link) {
+		return
+	}
+	parsed, err := url.Parse(link)
+	if !assert.NoError(err) {
+		return
+	}
+	token := parsed.Query().Get("token")
+
+	_, err = authenticator.Login(ctx, "")
+	assert.ErrorIs(err, ErrInvalidMagicLink)
+	_, err = authenticator.Login(ctx, "invalid")
+	assert.ErrorIs(err, ErrInvalidMagicLink)
+	userId, err := authenticator.Login(ctx, token)
+	if assert.NoError(err) {
+		assert.Equal(UserId(7), userId)
+	}
+	_, err = authenticator.Login(ctx, token)
+	assert.ErrorIs(err, ErrInvalidMagicLink, "link is single-use")
+
+	assert.NoError(send("makin@buzkaa.pl", "1.1.1.1"))
+	if assert.Len(mailer.mails, 2) {
+		match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(mailer.mails[1].Body)
+		if assert.Len(match, 2) {
+			now = now.Add(DefaultMagicLinkTTL)
+			token, _ := url.QueryUnescape(match[1])
+			_, err = authenticator.Login(ctx, token)
+			assert.ErrorIs(err, ErrInvalidMagicLink, "link has expired")
+		}
+	}
+
+	assert.NoError(send("makin@buzkaa.pl", "2.2.2.2"))
+	assert.ErrorIs(send("MAKIN@buzkaa.pl", "3.3.3.3"), ErrMagicLinkRateLimited,
+		"emails are limited regardless of IP and case")
+	assert.Len(mailer.mails, 3)
+
+	for i := 0; i < MagicLinkIpLimit-3; i++ {
+		assert.NoError(send(Email(fmt.Sprintf("unknown%d@buzkaa.pl", i)), "1.1.1.1"))
+	}
+	assert.ErrorIs(send("other@buzkaa.pl", "1.1.1.1"), ErrMagicLinkRateLimited)
+}
@@ -0,0 +1,14 @@
+package buzza
+
+import "context"
+
+type Mail struct {
+	To      Email
+	Subject string
+	// Plain text body.
+	Body string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
@@ -0,0 +1,73 @@
+// Package mail sends mails through SMTP.
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type SmtpMailer struct {
+	// Server address with port e.g. "smtp.example.com:587".
+	Addr string
+	From string
+	// Optional PLAIN auth credentials.
+	Username string
+	Password string
+}
+
+var _ buzza.Mailer = (*SmtpMailer)(nil)
+
+func (m *SmtpMailer) Send(ctx context.Context, mail buzza.Mail) error {
+	msg, err := m.message(mail)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	// net/smtp does not support contexts, so only the result waits for the cancellation
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.Addr, auth, m.From, []string{string(mail.To)}, msg)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("send mail: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *SmtpMailer) message(mail buzza.Mail) ([]byte, error) {
+	// line breaks in headers would allow injecting other headers
+	for _, header := range []string{m.From, string(mail.To), mail.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, errors.New("line break in mail header")
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mail.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(mail.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/smtptest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSmtpMailerMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	mailer := &SmtpMailer{Addr: "localhost:25", From: "noreply@buzkaaclicker.pl"}
+	msg, err := mailer.message(buzza.Mail{To: "jan@example.com", Subject: "Hi", Body: "line 1\nline 2"})
+	if assert.NoError(err) {
+		parts := strings.SplitN(string(msg), "\r\n\r\n", 2)
+		headers, body := parts[0], parts[1]
+		assert.Contains(headers, "From: noreply@buzkaaclicker.pl\r\n")
+		assert.Contains(headers, "To: jan@example.com\r\n")
+		assert.Contains(headers, "Subject: Hi\r\n")
+		assert.Equal("line 1\r\nline 2", body)
+	}
+
+	_, err = mailer.message(buzza.Mail{To: "jan@example.com", Subject: "Hi\r\nBcc: eve@example.com"})
+	assert.Error(err)
+	_, err = mailer.message(buzza.Mail{To: "jan@example.com\nBcc: eve@example.com", Subject: "Hi"})
+	assert.Error(err)
+}
+
+func TestSmtpMailerSend(t *testing.T) {
+	assert := assert.New(t)
+
+	sink, err := smtptest.NewServer()
+	if !assert.NoError(err) {
+		return
+	}
+	defer sink.Close()
+
+	mailer := &SmtpMailer{Addr: sink.Addr(), From: "noreply@buzkaaclicker.pl"}
+	err = mailer.Send(context.Background(), buzza.Mail{To: "jan@example.com", Subject: "Hi",
+		Body: "line 1\n.line 2"})
+	if !assert.NoError(err) {
+		return
+	}
+	messages := sink.Messages()
+	if assert.Len(messages, 1) {
+		assert.Equal("noreply@buzkaaclicker.pl", messages[0].From)
+		assert.Equal([]string{"jan@example.com"}, messages[0].To)
+		assert.Contains(messages[0].Data, "Subject: Hi\r\n")
+		assert.Equal("line 1\r\n.line 2", messages[0].Body())
+	}
+}
@@ -0,0 +1,21 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type ActivityBroker struct {
+	PublishFn func(ctx context.Context, log buzza.ActivityLog) error
+
+	SubscribeFn func(userId buzza.UserId) (<-chan buzza.ActivityLog, func())
+}
+
+func (b ActivityBroker) Publish(ctx context.Context, log buzza.ActivityLog) error {
+	return b.PublishFn(ctx, log)
+}
+
+func (b ActivityBroker) Subscribe(userId buzza.UserId) (<-chan buzza.ActivityLog, func()) {
+	return b.SubscribeFn(userId)
+}
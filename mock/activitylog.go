@@ -7,15 +7,21 @@ import (
 )
 
 type ActivityStore struct {
-	AddLogFn   func(ctx context.Context, userId buzza.UserId, activity buzza.Activity) error
+	AddLogFn func(ctx context.Context, userId buzza.UserId, activity buzza.Activity) error
 
-	ByUserIdFn func(ctx context.Context, userId buzza.UserId, beforeId int64, limit int32) ([]buzza.ActivityLog, error)
+	ByUserIdFn func(ctx context.Context, userId buzza.UserId, query buzza.ActivityQuery) ([]buzza.ActivityLog, error)
+
+	AuditFn func(ctx context.Context, query buzza.AuditQuery) ([]buzza.ActivityLog, error)
 }
 
 func (s ActivityStore) AddLog(ctx context.Context, userId buzza.UserId, activity buzza.Activity) error {
 	return s.AddLogFn(ctx, userId, activity)
 }
 
-func (s ActivityStore) ByUserId(ctx context.Context, userId buzza.UserId, beforeId int64, limit int32) ([]buzza.ActivityLog, error) {
-	return s.ByUserIdFn(ctx, userId, beforeId, limit)
+func (s ActivityStore) ByUserId(ctx context.Context, userId buzza.UserId, query buzza.ActivityQuery) ([]buzza.ActivityLog, error) {
+	return s.ByUserIdFn(ctx, userId, query)
+}
+
+func (s ActivityStore) Audit(ctx context.Context, query buzza.AuditQuery) ([]buzza.ActivityLog, error) {
+	return s.AuditFn(ctx, query)
 }
@@ -0,0 +1,52 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type ActivityRetentionStore struct {
+	ExpiredFn func(ctx context.Context, query buzza.ActivityExpiryQuery) ([]buzza.ActivityLog, error)
+
+	DeleteLogsFn func(ctx context.Context, ids []int64) (int64, error)
+
+	SetLegalHoldFn func(ctx context.Context, userId buzza.UserId, reason string) error
+
+	ReleaseLegalHoldFn func(ctx context.Context, userId buzza.UserId) error
+
+	LegalHoldsFn func(ctx context.Context) ([]buzza.LegalHold, error)
+}
+
+func (s ActivityRetentionStore) Expired(ctx context.Context, query buzza.ActivityExpiryQuery) ([]buzza.ActivityLog, error) {
+	return s.ExpiredFn(ctx, query)
+}
+
+func (s ActivityRetentionStore) DeleteLogs(ctx context.Context, ids []int64) (int64, error) {
+	return s.DeleteLogsFn(ctx, ids)
+}
+
+func (s ActivityRetentionStore) SetLegalHold(ctx context.Context, userId buzza.UserId, reason string) error {
+	return s.SetLegalHoldFn(ctx, userId, reason)
+}
+
+func (s ActivityRetentionStore) ReleaseLegalHold(ctx context.Context, userId buzza.UserId) error {
+	return s.ReleaseLegalHoldFn(ctx, userId)
+}
+
+func (s ActivityRetentionStore) LegalHolds(ctx context.Context) ([]buzza.LegalHold, error) {
+	return s.LegalHoldsFn(ctx)
+}
+
+type ActivityArchive struct {
+	ArchiveFn   func(ctx context.Context, logs []buzza.ActivityLog) error
+	EraseUserFn func(ctx context.Context, userId buzza.UserId) error
+}
+
+func (a ActivityArchive) Archive(ctx context.Context, logs []buzza.ActivityLog) error {
+	return a.ArchiveFn(ctx, logs)
+}
+
+func (a ActivityArchive) EraseUser(ctx context.Context, userId buzza.UserId) error {
+	return a.EraseUserFn(ctx, userId)
+}
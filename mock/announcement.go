@@ -0,0 +1,52 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type AnnouncementStore struct {
+	CreateFn func(ctx context.Context, announcement buzza.Announcement) (buzza.Announcement, error)
+
+	UpdateFn func(ctx context.Context, announcement buzza.Announcement) (buzza.Announcement, error)
+
+	DeleteFn func(ctx context.Context, id int64) error
+
+	AllFn func(ctx context.Context, offset int, limit int) ([]buzza.Announcement, error)
+
+	VisibleFn func(ctx context.Context, at time.Time) ([]buzza.Announcement, error)
+
+	ReadMarkerFn func(ctx context.Context, userId buzza.UserId) (time.Time, error)
+
+	SetReadMarkerFn func(ctx context.Context, userId buzza.UserId, at time.Time) error
+}
+
+func (s AnnouncementStore) Create(ctx context.Context, announcement buzza.Announcement) (buzza.Announcement, error) {
+	return s.CreateFn(ctx, announcement)
+}
+
+func (s AnnouncementStore) Update(ctx context.Context, announcement buzza.Announcement) (buzza.Announcement, error) {
+	return s.UpdateFn(ctx, announcement)
+}
+
+func (s AnnouncementStore) Delete(ctx context.Context, id int64) error {
+	return s.DeleteFn(ctx, id)
+}
+
+func (s AnnouncementStore) All(ctx context.Context, offset int, limit int) ([]buzza.Announcement, error) {
+	return s.AllFn(ctx, offset, limit)
+}
+
+func (s AnnouncementStore) Visible(ctx context.Context, at time.Time) ([]buzza.Announcement, error) {
+	return s.VisibleFn(ctx, at)
+}
+
+func (s AnnouncementStore) ReadMarker(ctx context.Context, userId buzza.UserId) (time.Time, error) {
+	return s.ReadMarkerFn(ctx, userId)
+}
+
+func (s AnnouncementStore) SetReadMarker(ctx context.Context, userId buzza.UserId, at time.Time) error {
+	return s.SetReadMarkerFn(ctx, userId, at)
+}
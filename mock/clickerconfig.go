@@ -0,0 +1,50 @@
+package mock
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type ClickerConfigStore struct {
+	ByUserIdFn func(ctx context.Context, userId buzza.UserId) ([]buzza.ClickerConfig, error)
+
+	ByNameFn func(ctx context.Context, userId buzza.UserId, name string) (buzza.ClickerConfig, error)
+
+	SaveFn func(ctx context.Context, userId buzza.UserId, name string,
+		data json.RawMessage, expectedRevision int64) (buzza.ClickerConfig, error)
+
+	HistoryFn func(ctx context.Context, userId buzza.UserId, name string) ([]buzza.ClickerConfigRevision, error)
+
+	RestoreFn func(ctx context.Context, userId buzza.UserId, name string,
+		revision int64, expectedRevision int64) (buzza.ClickerConfig, error)
+
+	DeleteFn func(ctx context.Context, userId buzza.UserId, name string, expectedRevision int64) error
+}
+
+func (s ClickerConfigStore) ByUserId(ctx context.Context, userId buzza.UserId) ([]buzza.ClickerConfig, error) {
+	return s.ByUserIdFn(ctx, userId)
+}
+
+func (s ClickerConfigStore) ByName(ctx context.Context, userId buzza.UserId, name string) (buzza.ClickerConfig, error) {
+	return s.ByNameFn(ctx, userId, name)
+}
+
+func (s ClickerConfigStore) Save(ctx context.Context, userId buzza.UserId, name string,
+	data json.RawMessage, expectedRevision int64) (buzza.ClickerConfig, error) {
+	return s.SaveFn(ctx, userId, name, data, expectedRevision)
+}
+
+func (s ClickerConfigStore) History(ctx context.Context, userId buzza.UserId, name string) ([]buzza.ClickerConfigRevision, error) {
+	return s.HistoryFn(ctx, userId, name)
+}
+
+func (s ClickerConfigStore) Restore(ctx context.Context, userId buzza.UserId, name string,
+	revision int64, expectedRevision int64) (buzza.ClickerConfig, error) {
+	return s.RestoreFn(ctx, userId, name, revision, expectedRevision)
+}
+
+func (s ClickerConfigStore) Delete(ctx context.Context, userId buzza.UserId, name string, expectedRevision int64) error {
+	return s.DeleteFn(ctx, userId, name, expectedRevision)
+}
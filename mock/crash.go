@@ -0,0 +1,54 @@
+package mock
+
+import (
+	"context"
+	"io"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type CrashStore struct {
+	AddFn func(ctx context.Context, report buzza.CrashReport) (buzza.CrashReport, bool, error)
+
+	GroupsFn func(ctx context.Context, query buzza.CrashGroupQuery) ([]buzza.CrashGroup, error)
+
+	BySignatureFn func(ctx context.Context, signature string, offset int, limit int) ([]buzza.CrashReport, error)
+
+	ByIdFn func(ctx context.Context, id int64) (buzza.CrashReport, error)
+
+	DetachMinidumpFn func(ctx context.Context, id int64) error
+}
+
+func (s CrashStore) Add(ctx context.Context, report buzza.CrashReport) (buzza.CrashReport, bool, error) {
+	return s.AddFn(ctx, report)
+}
+
+func (s CrashStore) Groups(ctx context.Context, query buzza.CrashGroupQuery) ([]buzza.CrashGroup, error) {
+	return s.GroupsFn(ctx, query)
+}
+
+func (s CrashStore) BySignature(ctx context.Context, signature string, offset int, limit int) ([]buzza.CrashReport, error) {
+	return s.BySignatureFn(ctx, signature, offset, limit)
+}
+
+func (s CrashStore) ById(ctx context.Context, id int64) (buzza.CrashReport, error) {
+	return s.ByIdFn(ctx, id)
+}
+
+func (s CrashStore) DetachMinidump(ctx context.Context, id int64) error {
+	return s.DetachMinidumpFn(ctx, id)
+}
+
+type AttachmentStorage struct {
+	PutFn func(ctx context.Context, key string, r io.Reader) error
+
+	OpenFn func(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+func (s AttachmentStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	return s.PutFn(ctx, key, r)
+}
+
+func (s AttachmentStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.OpenFn(ctx, key)
+}
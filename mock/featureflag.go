@@ -0,0 +1,39 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type FeatureFlagStore struct {
+	AllFn func(ctx context.Context) ([]buzza.FeatureFlag, error)
+
+	ByKeyFn func(ctx context.Context, key string) (buzza.FeatureFlag, error)
+
+	SaveFn func(ctx context.Context, flag buzza.FeatureFlag, authorId buzza.UserId) (buzza.FeatureFlag, error)
+
+	DeleteFn func(ctx context.Context, key string, authorId buzza.UserId) error
+
+	HistoryFn func(ctx context.Context, key string, offset int, limit int) ([]buzza.FeatureFlagChange, error)
+}
+
+func (s FeatureFlagStore) All(ctx context.Context) ([]buzza.FeatureFlag, error) {
+	return s.AllFn(ctx)
+}
+
+func (s FeatureFlagStore) ByKey(ctx context.Context, key string) (buzza.FeatureFlag, error) {
+	return s.ByKeyFn(ctx, key)
+}
+
+func (s FeatureFlagStore) Save(ctx context.Context, flag buzza.FeatureFlag, authorId buzza.UserId) (buzza.FeatureFlag, error) {
+	return s.SaveFn(ctx, flag, authorId)
+}
+
+func (s FeatureFlagStore) Delete(ctx context.Context, key string, authorId buzza.UserId) error {
+	return s.DeleteFn(ctx, key, authorId)
+}
+
+func (s FeatureFlagStore) History(ctx context.Context, key string, offset int, limit int) ([]buzza.FeatureFlagChange, error) {
+	return s.HistoryFn(ctx, key, offset, limit)
+}
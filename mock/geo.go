@@ -0,0 +1,11 @@
+package mock
+
+import "github.com/buzkaaclicker/buzza"
+
+type GeoLocator struct {
+	LocateFn func(ip string) (buzza.GeoLocation, error)
+}
+
+func (l GeoLocator) Locate(ip string) (buzza.GeoLocation, error) {
+	return l.LocateFn(ip)
+}
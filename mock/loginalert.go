@@ -0,0 +1,45 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type SessionObserver struct {
+	SessionSeenFn func(ctx context.Context, session buzza.Session) error
+}
+
+func (o SessionObserver) SessionSeen(ctx context.Context, session buzza.Session) error {
+	return o.SessionSeenFn(ctx, session)
+}
+
+type NotificationSettingsStore struct {
+	ByUserIdFn func(ctx context.Context, userId buzza.UserId) (buzza.NotificationSettings, error)
+
+	SaveFn func(ctx context.Context, userId buzza.UserId, settings buzza.NotificationSettings) error
+}
+
+func (s NotificationSettingsStore) ByUserId(ctx context.Context, userId buzza.UserId) (buzza.NotificationSettings, error) {
+	return s.ByUserIdFn(ctx, userId)
+}
+
+func (s NotificationSettingsStore) Save(ctx context.Context, userId buzza.UserId, settings buzza.NotificationSettings) error {
+	return s.SaveFn(ctx, userId, settings)
+}
+
+type LoginSourceStore struct {
+	RememberFn func(ctx context.Context, userId buzza.UserId, ip string, device string) (buzza.LoginSourceCheck, error)
+}
+
+func (s LoginSourceStore) Remember(ctx context.Context, userId buzza.UserId, ip string, device string) (buzza.LoginSourceCheck, error) {
+	return s.RememberFn(ctx, userId, ip, device)
+}
+
+type LoginNotifier struct {
+	NotifyLoginFn func(ctx context.Context, user buzza.User, alert buzza.LoginAlert) error
+}
+
+func (n LoginNotifier) NotifyLogin(ctx context.Context, user buzza.User, alert buzza.LoginAlert) error {
+	return n.NotifyLoginFn(ctx, user, alert)
+}
@@ -0,0 +1,39 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type ReferralStore struct {
+	CodeByUserIdFn func(ctx context.Context, userId buzza.UserId) (buzza.ReferralCode, error)
+
+	AttachFn func(ctx context.Context, code buzza.ReferralCode, referee buzza.User, ip string) (buzza.Referral, error)
+
+	ByReferrerIdFn func(ctx context.Context, referrerId buzza.UserId) ([]buzza.Referral, error)
+
+	RewardsByUserIdFn func(ctx context.Context, userId buzza.UserId) ([]buzza.ReferralReward, error)
+
+	RegisterPurchaseFn func(ctx context.Context, refereeId buzza.UserId) (buzza.ReferralReward, error)
+}
+
+func (s ReferralStore) CodeByUserId(ctx context.Context, userId buzza.UserId) (buzza.ReferralCode, error) {
+	return s.CodeByUserIdFn(ctx, userId)
+}
+
+func (s ReferralStore) Attach(ctx context.Context, code buzza.ReferralCode, referee buzza.User, ip string) (buzza.Referral, error) {
+	return s.AttachFn(ctx, code, referee, ip)
+}
+
+func (s ReferralStore) ByReferrerId(ctx context.Context, referrerId buzza.UserId) ([]buzza.Referral, error) {
+	return s.ByReferrerIdFn(ctx, referrerId)
+}
+
+func (s ReferralStore) RewardsByUserId(ctx context.Context, userId buzza.UserId) ([]buzza.ReferralReward, error) {
+	return s.RewardsByUserIdFn(ctx, userId)
+}
+
+func (s ReferralStore) RegisterPurchase(ctx context.Context, refereeId buzza.UserId) (buzza.ReferralReward, error) {
+	return s.RegisterPurchaseFn(ctx, refereeId)
+}
@@ -0,0 +1,60 @@
+package mock
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/buzkaaclicker/buzza"
+)
+
+type SharedConfigStore struct {
+	PublishFn func(ctx context.Context, authorId buzza.UserId,
+		meta buzza.SharedConfigMeta, data json.RawMessage) (buzza.SharedConfig, error)
+
+	ByIdFn func(ctx context.Context, id int64) (buzza.SharedConfig, error)
+
+	SearchFn func(ctx context.Context, query buzza.SharedConfigQuery) ([]buzza.SharedConfig, int, error)
+
+	DownloadFn func(ctx context.Context, id int64) (buzza.SharedConfig, error)
+
+	RateFn func(ctx context.Context, id int64, userId buzza.UserId, rating int) error
+
+	ReportFn func(ctx context.Context, id int64, reporterId buzza.UserId, reason string) error
+
+	ReportsFn func(ctx context.Context, offset int, limit int) ([]buzza.SharedConfigReport, error)
+
+	TakeDownFn func(ctx context.Context, id int64, reason string) error
+}
+
+func (s SharedConfigStore) Publish(ctx context.Context, authorId buzza.UserId,
+	meta buzza.SharedConfigMeta, data json.RawMessage) (buzza.SharedConfig, error) {
+	return s.PublishFn(ctx, authorId, meta, data)
+}
+
+func (s SharedConfigStore) ById(ctx context.Context, id int64) (buzza.SharedConfig, error) {
+	return s.ByIdFn(ctx, id)
+}
+
+func (s SharedConfigStore) Search(ctx context.Context, query buzza.SharedConfigQuery) ([]buzza.SharedConfig, int, error) {
+	return s.SearchFn(ctx, query)
+}
+
+func (s SharedConfigStore) Download(ctx context.Context, id int64) (buzza.SharedConfig, error) {
+	return s.DownloadFn(ctx, id)
+}
+
+func (s SharedConfigStore) Rate(ctx context.Context, id int64, userId buzza.UserId, rating int) error {
+	return s.RateFn(ctx, id, userId, rating)
+}
+
+func (s SharedConfigStore) Report(ctx context.Context, id int64, reporterId buzza.UserId, reason string) error {
+	return s.ReportFn(ctx, id, reporterId, reason)
+}
+
+func (s SharedConfigStore) Reports(ctx context.Context, offset int, limit int) ([]buzza.SharedConfigReport, error) {
+	return s.ReportsFn(ctx, offset, limit)
+}
+
+func (s SharedConfigStore) TakeDown(ctx context.Context, id int64, reason string) error {
+	return s.TakeDownFn(ctx, id, reason)
+}
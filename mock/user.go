@@ -4,25 +4,48 @@ import (
 	"context"
 
 	"github.com/buzkaaclicker/buzza"
-	"github.com/buzkaaclicker/buzza/discord"
 )
 
 type UserStore struct {
-	RegisterDiscordUserFn func(ctx context.Context, u discord.User, refreshToken string) (buzza.User, error)
+	RegisterExternalUserFn func(ctx context.Context, identity buzza.ExternalIdentity) (buzza.User, bool, error)
 
 	ByIdFn func(ctx context.Context, userId buzza.UserId) (buzza.User, error)
 
+	ByVerifiedEmailFn func(ctx context.Context, email buzza.Email) (buzza.User, error)
+
 	UpdateFn func(ctx context.Context, user buzza.User) error
+
+	IdentitiesFn func(ctx context.Context, userId buzza.UserId) ([]buzza.UserIdentity, error)
+
+	LinkIdentityFn func(ctx context.Context, userId buzza.UserId, identity buzza.ExternalIdentity) (buzza.UserIdentity, error)
+
+	UnlinkIdentityFn func(ctx context.Context, userId buzza.UserId, provider string) (buzza.UserIdentity, error)
 }
 
-func (s UserStore) RegisterDiscordUser(ctx context.Context, u discord.User, refreshToken string) (buzza.User, error) {
-	return s.RegisterDiscordUserFn(ctx, u, refreshToken)
+func (s UserStore) RegisterExternalUser(ctx context.Context, identity buzza.ExternalIdentity) (buzza.User, bool, error) {
+	return s.RegisterExternalUserFn(ctx, identity)
 }
 
 func (s UserStore) ById(ctx context.Context, userId buzza.UserId) (buzza.User, error) {
 	return s.ByIdFn(ctx, userId)
 }
 
+func (s UserStore) ByVerifiedEmail(ctx context.Context, email buzza.Email) (buzza.User, error) {
+	return s.ByVerifiedEmailFn(ctx, email)
+}
+
 func (s UserStore) Update(ctx context.Context, user buzza.User) error {
 	return s.UpdateFn(ctx, user)
 }
+
+func (s UserStore) Identities(ctx context.Context, userId buzza.UserId) ([]buzza.UserIdentity, error) {
+	return s.IdentitiesFn(ctx, userId)
+}
+
+func (s UserStore) LinkIdentity(ctx context.Context, userId buzza.UserId, identity buzza.ExternalIdentity) (buzza.UserIdentity, error) {
+	return s.LinkIdentityFn(ctx, userId, identity)
+}
+
+func (s UserStore) UnlinkIdentity(ctx context.Context, userId buzza.UserId, provider string) (buzza.UserIdentity, error) {
+	return s.UnlinkIdentityFn(ctx, userId, provider)
+}
@@ -0,0 +1,373 @@
+package buzza
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrPasskeyNotFound          = errors.New("passkey not found")
+	ErrPasskeyAlreadyRegistered = errors.New("passkey already registered")
+	ErrTooManyPasskeys          = errors.New("too many passkeys")
+	// Signature counter did not increase, the assertion is replayed or the authenticator is cloned.
+	ErrPasskeyReplayed        = errors.New("passkey signature counter did not increase")
+	ErrInvalidPasskeyCeremony = errors.New("invalid passkey ceremony state")
+)
+
+const (
+	MaxPasskeysPerUser        = 10
+	MaxPasskeyNameLength      = 64
+	DefaultPasskeyName        = "Passkey"
+	DefaultPasskeyCeremonyTTL = 5 * time.Minute
+)
+
+// WebAuthn credential of the user.
+type Passkey struct {
+	// Base64url encoded credential id.
+	Id     string
+	UserId UserId
+	Name   string
+	// COSE encoded public key.
+	PublicKey  []byte
+	SignCount  uint32
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+type PasskeyStore interface {
+	// Passkeys of the user, oldest first.
+	ByUserId(ctx context.Context, userId UserId) ([]Passkey, error)
+
+	// ErrPasskeyNotFound if there is no such credential.
+	ById(ctx context.Context, id string) (Passkey, error)
+
+	// ErrPasskeyAlreadyRegistered if credential id is already taken.
+	Add(ctx context.Context, passkey Passkey) error
+
+	// Store counter of the accepted assertion. ErrPasskeyReplayed if the counter did not increase,
+	// counters are ignored if both stored and new are zero (authenticator does not count signatures).
+	UseSignCount(ctx context.Context, id string, signCount uint32, usedAt time.Time) error
+
+	// ErrPasskeyNotFound if user has no such passkey.
+	Delete(ctx context.Context, userId UserId, id string) (Passkey, error)
+}
+
+// Challenges of started ceremonies, each can be used once.
+type PasskeyChallengeStore interface {
+	Add(ctx context.Context, challengeHash string, expiresAt time.Time) error
+
+	// Forget the challenge. ErrInvalidPasskeyCeremony if there is no such challenge,
+	// it has expired or has been used already.
+	Use(ctx context.Context, challengeHash string, now time.Time) error
+}
+
+// Options of navigator.credentials.create().
+type PasskeyRegistration struct {
+	Challenge  []byte
+	UserHandle []byte
+	UserName   string
+	// Credentials of the user, so authenticators do not register twice.
+	ExcludeCredentialIds []string
+	// Signed ceremony state, passed back by the client with the response.
+	State     string
+	ExpiresAt time.Time
+}
+
+// Options of navigator.credentials.get().
+type PasskeyLogin struct {
+	Challenge []byte
+	// Empty for discoverable credentials, the authenticator lets the user choose an account.
+	AllowCredentialIds []string
+	UserVerification   bool
+	// Signed ceremony state, passed back by the client with the response.
+	State     string
+	ExpiresAt time.Time
+}
+
+const (
+	passkeyCeremonyRegistration = "registration"
+	passkeyCeremonyLogin        = "login"
+	passkeyCeremonySecondFactor = "second_factor"
+)
+
+// Registers passkeys and verifies them as a passwordless login or as a second factor.
+//
+// Ceremony states are signed, so the client can not change the kind or the user of the ceremony,
+// and their challenges are stored and used up by the first valid response, so responses can not be
+// replayed even if the authenticator does not count signatures.
+type PasskeyAuthenticator struct {
+	RelyingParty  WebAuthnRelyingParty
+	Store         PasskeyStore
+	Challenges    PasskeyChallengeStore
+	ActivityStore ActivityStore
+	// Signs ceremony states.
+	Secret []byte
+	// Current time, time.Now if nil.
+	Now func() time.Time
+}
+
+func (a *PasskeyAuthenticator) now() time.Time {
+	if a.Now == nil {
+		return time.Now()
+	}
+	return a.Now()
+}
+
+func (a *PasskeyAuthenticator) BeginRegistration(ctx context.Context, user User) (PasskeyRegistration, error) {
+	passkeys, err := a.Store.ByUserId(ctx, user.Id)
+	if err != nil {
+		return PasskeyRegistration{}, fmt.Errorf("get passkeys: %w", err)
+	}
+	if len(passkeys) >= MaxPasskeysPerUser {
+		return PasskeyRegistration{}, ErrTooManyPasskeys
+	}
+	excluded := make([]string, len(passkeys))
+	for i, passkey := range passkeys {
+		excluded[i] = passkey.Id
+	}
+	userName := string(user.Email)
+	if userName == "" {
+		userName = strconv.FormatInt(int64(user.Id), 10)
+	}
+	registration := PasskeyRegistration{
+		UserHandle:           PasskeyUserHandle(user.Id),
+		UserName:             userName,
+		ExcludeCredentialIds: excluded,
+	}
+	registration.Challenge, registration.State, registration.ExpiresAt, err = a.newCeremony(ctx,
+		passkeyCeremonyRegistration, user.Id)
+	if err != nil {
+		return PasskeyRegistration{}, err
+	}
+	return registration, nil
+}
+
+func (a *PasskeyAuthenticator) FinishRegistration(ctx context.Context, user User, state string, name string,
+	creation CredentialCreation) (Passkey, error) {
+	challenge, err := a.verifyCeremony(state, passkeyCeremonyRegistration, user.Id)
+	if err != nil {
+		return Passkey{}, err
+	}
+	authData, err := a.RelyingParty.verifyCreation(creation, challenge, false)
+	if err != nil {
+		return Passkey{}, err
+	}
+	if err := a.useChallenge(ctx, challenge); err != nil {
+		return Passkey{}, err
+	}
+	passkeys, err := a.Store.ByUserId(ctx, user.Id)
+	if err != nil {
+		return Passkey{}, fmt.Errorf("get passkeys: %w", err)
+	}
+	if len(passkeys) >= MaxPasskeysPerUser {
+		return Passkey{}, ErrTooManyPasskeys
+	}
+
+	passkey := Passkey{
+		Id:        EncodeWebAuthnBase64(authData.credentialId),
+		UserId:    user.Id,
+		Name:      normalizePasskeyName(name),
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+		CreatedAt: a.now().UTC(),
+	}
+	if err := a.Store.Add(ctx, passkey); err != nil {
+		return Passkey{}, err
+	}
+	if err := a.ActivityStore.AddLog(ctx, user.Id, PasskeyAddedActivity(passkey.Name)); err != nil {
+		return Passkey{}, fmt.Errorf("log passkey added: %w", err)
+	}
+	return passkey, nil
+}
+
+// Start passwordless login with a discoverable credential.
+func (a *PasskeyAuthenticator) BeginLogin(ctx context.Context) (PasskeyLogin, error) {
+	login := PasskeyLogin{UserVerification: true}
+	var err error
+	login.Challenge, login.State, login.ExpiresAt, err = a.newCeremony(ctx, passkeyCeremonyLogin, 0)
+	if err != nil {
+		return PasskeyLogin{}, err
+	}
+	return login, nil
+}
+
+// Verify passwordless login, the authenticator must have verified the user (PIN, biometrics),
+// so the passkey is enough to log in. Returns the owner of the passkey.
+func (a *PasskeyAuthenticator) FinishLogin(ctx context.Context, state string, assertion CredentialAssertion) (UserId, error) {
+	challenge, err := a.verifyCeremony(state, passkeyCeremonyLogin, 0)
+	if err != nil {
+		return 0, err
+	}
+	passkey, err := a.verifyAssertion(ctx, challenge, assertion, true, 0)
+	if err != nil {
+		return 0, err
+	}
+	return passkey.UserId, nil
+}
+
+// Start verification of the user passkey as a second factor. ErrPasskeyNotFound if user has no passkeys.
+func (a *PasskeyAuthenticator) BeginSecondFactor(ctx context.Context, userId UserId) (PasskeyLogin, error) {
+	passkeys, err := a.Store.ByUserId(ctx, userId)
+	if err != nil {
+		return PasskeyLogin{}, fmt.Errorf("get passkeys: %w", err)
+	}
+	if len(passkeys) == 0 {
+		return PasskeyLogin{}, ErrPasskeyNotFound
+	}
+	login := PasskeyLogin{AllowCredentialIds: make([]string, len(passkeys))}
+	for i, passkey := range passkeys {
+		login.AllowCredentialIds[i] = passkey.Id
+	}
+	login.Challenge, login.State, login.ExpiresAt, err = a.newCeremony(ctx, passkeyCeremonySecondFactor, userId)
+	if err != nil {
+		return PasskeyLogin{}, err
+	}
+	return login, nil
+}
+
+func (a *PasskeyAuthenticator) FinishSecondFactor(ctx context.Context, userId UserId, state string,
+	assertion CredentialAssertion) error {
+	challenge, err := a.verifyCeremony(state, passkeyCeremonySecondFactor, userId)
+	if err != nil {
+		return err
+	}
+	_, err = a.verifyAssertion(ctx, challenge, assertion, false, userId)
+	return err
+}
+
+// Verify assertion with the stored passkey and store its signature counter.
+// Passkey must belong to the owner, unless it is zero.
+func (a *PasskeyAuthenticator) verifyAssertion(ctx context.Context, challenge []byte, assertion CredentialAssertion,
+	userVerification bool, owner UserId) (Passkey, error) {
+	passkey, err := a.Store.ById(ctx, EncodeWebAuthnBase64(assertion.CredentialId))
+	if err != nil {
+		return Passkey{}, err
+	}
+	if owner != 0 && passkey.UserId != owner {
+		return Passkey{}, ErrPasskeyNotFound
+	}
+	if len(assertion.UserHandle) != 0 && !bytes.Equal(assertion.UserHandle, PasskeyUserHandle(passkey.UserId)) {
+		return Passkey{}, fmt.Errorf("%w: user handle mismatch", ErrInvalidWebAuthnResponse)
+	}
+	authData, err := a.RelyingParty.verifyAssertion(assertion, challenge, passkey.PublicKey, userVerification)
+	if err != nil {
+		return Passkey{}, err
+	}
+	if err := a.useChallenge(ctx, challenge); err != nil {
+		return Passkey{}, err
+	}
+	if err := a.Store.UseSignCount(ctx, passkey.Id, authData.signCount, a.now().UTC()); err != nil {
+		return Passkey{}, err
+	}
+	return passkey, nil
+}
+
+func (a *PasskeyAuthenticator) Remove(ctx context.Context, userId UserId, id string) error {
+	passkey, err := a.Store.Delete(ctx, userId, id)
+	if err != nil {
+		return err
+	}
+	if err := a.ActivityStore.AddLog(ctx, userId, PasskeyRemovedActivity(passkey.Name)); err != nil {
+		return fmt.Errorf("log passkey removed: %w", err)
+	}
+	return nil
+}
+
+// Opaque WebAuthn user handle of the user.
+func PasskeyUserHandle(userId UserId) []byte {
+	return []byte(strconv.FormatInt(int64(userId), 10))
+}
+
+func normalizePasskeyName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return DefaultPasskeyName
+	}
+	if utf8.RuneCountInString(name) > MaxPasskeyNameLength {
+		name = string([]rune(name)[:MaxPasskeyNameLength])
+	}
+	return name
+}
+
+type passkeyCeremony struct {
+	Kind      string `json:"k"`
+	UserId    UserId `json:"u,omitempty"`
+	Challenge []byte `json:"c"`
+	ExpiresAt int64  `json:"e"`
+}
+
+func (a *PasskeyAuthenticator) newCeremony(ctx context.Context, kind string,
+	userId UserId) ([]byte, string, time.Time, error) {
+	challenge := make([]byte, 32)
+	if _, err := crand.Read(challenge); err != nil {
+		return nil, "", time.Time{}, fmt.Errorf("generate challenge: %w", err)
+	}
+	expiresAt := a.now().Add(DefaultPasskeyCeremonyTTL)
+	if err := a.Challenges.Add(ctx, passkeyChallengeHash(challenge), expiresAt.UTC()); err != nil {
+		return nil, "", time.Time{}, fmt.Errorf("store challenge: %w", err)
+	}
+	payload, err := json.Marshal(passkeyCeremony{Kind: kind, UserId: userId, Challenge: challenge,
+		ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return nil, "", time.Time{}, fmt.Errorf("marshal ceremony: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	state := encoded + "." + base64.RawURLEncoding.EncodeToString(passkeyCeremonyMac(a.Secret, encoded))
+	return challenge, state, expiresAt, nil
+}
+
+// Challenge of the ceremony of the kind started for the user.
+func (a *PasskeyAuthenticator) verifyCeremony(state string, kind string, userId UserId) ([]byte, error) {
+	encoded, encodedMac, ok := cut(state, ".")
+	if !ok {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil || !hmac.Equal(mac, passkeyCeremonyMac(a.Secret, encoded)) {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	var ceremony passkeyCeremony
+	if err := json.Unmarshal(payload, &ceremony); err != nil {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	if ceremony.Kind != kind || ceremony.UserId != userId || !a.now().Before(time.Unix(ceremony.ExpiresAt, 0)) {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	return ceremony.Challenge, nil
+}
+
+// Use up the challenge of the verified response, so the response is accepted once.
+func (a *PasskeyAuthenticator) useChallenge(ctx context.Context, challenge []byte) error {
+	err := a.Challenges.Use(ctx, passkeyChallengeHash(challenge), a.now().UTC())
+	if err != nil && !errors.Is(err, ErrInvalidPasskeyCeremony) {
+		return fmt.Errorf("use challenge: %w", err)
+	}
+	return err
+}
+
+func passkeyChallengeHash(challenge []byte) string {
+	hash := sha256.Sum256(challenge)
+	return hex.EncodeToString(hash[:])
+}
+
+func passkeyCeremonyMac(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("passkey-ceremony:" + payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,312 @@
+package buzza
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza/webauthntest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCbor(t *testing.T) {
+	assert := assert.New(t)
+
+	// {1: 2, "a": [-1, h'0102', true, null], -300: "xyz"}
+	data := []byte{0xa3, 0x01, 0x02, 0x61, 'a', 0x84, 0x20, 0x42, 0x01, 0x02, 0xf5, 0xf6,
+		0x39, 0x01, 0x2b, 0x63, 'x', 'y', 'z', 0xff}
+	value, rest, err := decodeCbor(data)
+	if assert.NoError(err) {
+		assert.Equal(map[interface{}]interface{}{
+			int64(1):    int64(2),
+			"a":         []interface{}{int64(-1), []byte{1, 2}, true, nil},
+			int64(-300): "xyz",
+		}, value)
+		assert.Equal([]byte{0xff}, rest)
+	}
+
+	for _, invalid := range [][]byte{
+		{},
+		{0x18},       // missing argument
+		{0x43, 0x01}, // truncated bytes
+		{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // huge array
+		{0xa1, 0x80, 0x01}, // array map key
+		{0xf9, 0x00, 0x00}, // float
+	} {
+		_, _, err := decodeCbor(invalid)
+		assert.Error(err, invalid)
+	}
+}
+
+type testPasskeyStore struct {
+	passkeys map[string]Passkey
+}
+
+func (s *testPasskeyStore) ByUserId(ctx context.Context, userId UserId) ([]Passkey, error) {
+	var passkeys []Passkey
+	for _, passkey := range s.passkeys {
+		if passkey.UserId == userId {
+			passkeys = append(passkeys, passkey)
+		}
+	}
+	return passkeys, nil
+}
+
+func (s *testPasskeyStore) ById(ctx context.Context, id string) (Passkey, error) {
+	passkey, ok := s.passkeys[id]
+	if !ok {
+		return Passkey{}, ErrPasskeyNotFound
+	}
+	return passkey, nil
+}
+
+func (s *testPasskeyStore) Add(ctx context.Context, passkey Passkey) error {
+	if _, ok := s.passkeys[passkey.Id]; ok {
+		return ErrPasskeyAlreadyRegistered
+	}
+	s.passkeys[passkey.Id] = passkey
+	return nil
+}
+
+func (s *testPasskeyStore) UseSignCount(ctx context.Context, id string, signCount uint32, usedAt time.Time) error {
+	passkey := s.passkeys[id]
+	if signCount <= passkey.SignCount && (signCount != 0 || passkey.SignCount != 0) {
+		return ErrPasskeyReplayed
+	}
+	passkey.SignCount = signCount
+	passkey.LastUsedAt = usedAt
+	s.passkeys[id] = passkey
+	return nil
+}
+
+func (s *testPasskeyStore) Delete(ctx context.Context, userId UserId, id string) (Passkey, error) {
+	passkey, ok := s.passkeys[id]
+	if !ok || passkey.UserId != userId {
+		return Passkey{}, ErrPasskeyNotFound
+	}
+	delete(s.passkeys, id)
+	return passkey, nil
+}
+
+type testPasskeyChallengeStore struct {
+	challenges map[string]time.Time
+}
+
+func (s *testPasskeyChallengeStore) Add(ctx context.Context, challengeHash string, expiresAt time.Time) error {
+	if s.challenges == nil {
+		s.challenges = map[string]time.Time{}
+	}
+	s.challenges[challengeHash] = expiresAt
+	return nil
+}
+
+func (s *testPasskeyChallengeStore) Use(ctx context.Context, challengeHash string, now time.Time) error {
+	expiresAt, ok := s.challenges[challengeHash]
+	delete(s.challenges, challengeHash)
+	if !ok || !now.Before(expiresAt) {
+		return ErrInvalidPasskeyCeremony
+	}
+	return nil
+}
+
+func allowedIds(t *testing.T, ids []string) [][]byte {
+	decoded := make([][]byte, len(ids))
+	for i, id := range ids {
+		var err error
+		decoded[i], err = DecodeWebAuthnBase64(id)
+		assert.NoError(t, err)
+	}
+	return decoded
+}
+
+func TestPasskeyAuthenticator(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	const origin = "https://buzkaaclicker.pl"
+	activities := &testActivityStore{}
+	authenticator := PasskeyAuthenticator{
+		RelyingParty: WebAuthnRelyingParty{Id: "buzkaaclicker.pl", Name: "BuzkaaClicker",
+			Origins: []string{origin}},
+		Store:         &testPasskeyStore{passkeys: map[string]Passkey{}},
+		Challenges:    &testPasskeyChallengeStore{},
+		ActivityStore: activities,
+		Secret:        []byte("0123456789abcdef0123456789abcdef"),
+	}
+	user := User{Id: 7, Email: "user@example.com"}
+	device := webauthntest.NewAuthenticator(origin)
+
+	registration, err := authenticator.BeginRegistration(ctx, user)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("user@example.com", registration.UserName)
+	assert.Equal([]byte("7"), registration.UserHandle)
+	attestation, err := device.Create("buzkaaclicker.pl", registration.Challenge, registration.UserHandle)
+	if !assert.NoError(err) {
+		return
+	}
+	creation := CredentialCreation{ClientDataJson: attestation.ClientDataJson,
+		AttestationObject: attestation.AttestationObject}
+	_, err = authenticator.FinishRegistration(ctx, User{Id: 8}, registration.State, "", creation)
+	assert.ErrorIs(err, ErrInvalidPasskeyCeremony, "state of other user")
+	passkey, err := authenticator.FinishRegistration(ctx, user, registration.State, "  YubiKey  ", creation)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(EncodeWebAuthnBase64(attestation.CredentialId), passkey.Id)
+	assert.Equal("YubiKey", passkey.Name)
+	_, err = authenticator.FinishRegistration(ctx, user, registration.State, "", creation)
+	assert.ErrorIs(err, ErrInvalidPasskeyCeremony, "state can be used once")
+
+	registration, err = authenticator.BeginRegistration(ctx, user)
+	if assert.NoError(err) {
+		assert.Equal([]string{passkey.Id}, registration.ExcludeCredentialIds)
+	}
+
+	// passwordless login
+	login, err := authenticator.BeginLogin(ctx)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.True(login.UserVerification)
+	assert.Empty(login.AllowCredentialIds)
+	signed, err := device.Get("buzkaaclicker.pl", login.Challenge, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	assertion := CredentialAssertion{CredentialId: signed.CredentialId, ClientDataJson: signed.ClientDataJson,
+		AuthenticatorData: signed.AuthenticatorData, Signature: signed.Signature, UserHandle: signed.UserHandle}
+	userId, err := authenticator.FinishLogin(ctx, login.State, assertion)
+	if assert.NoError(err) {
+		assert.Equal(user.Id, userId)
+	}
+	_, err = authenticator.FinishLogin(ctx, login.State, assertion)
+	assert.ErrorIs(err, ErrInvalidPasskeyCeremony, "state can be used once")
+
+	// second factor
+	secondFactor, err := authenticator.BeginSecondFactor(ctx, user.Id)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{passkey.Id}, secondFactor.AllowCredentialIds)
+	_, err = authenticator.BeginSecondFactor(ctx, 8)
+	assert.ErrorIs(err, ErrPasskeyNotFound)
+	signed, err = device.Get("buzkaaclicker.pl", secondFactor.Challenge, allowedIds(t, secondFactor.AllowCredentialIds))
+	if !assert.NoError(err) {
+		return
+	}
+	assertion = CredentialAssertion{CredentialId: signed.CredentialId, ClientDataJson: signed.ClientDataJson,
+		AuthenticatorData: signed.AuthenticatorData, Signature: signed.Signature}
+	_, err = authenticator.FinishLogin(ctx, secondFactor.State, assertion)
+	assert.ErrorIs(err, ErrInvalidPasskeyCeremony, "second factor state is not a login")
+	assert.ErrorIs(authenticator.FinishSecondFactor(ctx, 8, secondFactor.State, assertion), ErrInvalidPasskeyCeremony)
+	assert.NoError(authenticator.FinishSecondFactor(ctx, user.Id, secondFactor.State, assertion))
+
+	assert.NoError(authenticator.Remove(ctx, user.Id, passkey.Id))
+	assert.ErrorIs(authenticator.Remove(ctx, user.Id, passkey.Id), ErrPasskeyNotFound)
+
+	names := make([]string, len(activities.logs))
+	for i, activity := range activities.logs {
+		names[i] = activity.Name
+	}
+	assert.Equal([]string{ActivityPasskeyAdded, ActivityPasskeyRemoved}, names)
+	assert.Equal("YubiKey", activities.logs[0].Data["name"])
+}
+
+func TestPasskeyVerification(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	const origin = "https://buzkaaclicker.pl"
+	store := &testPasskeyStore{passkeys: map[string]Passkey{}}
+	authenticator := PasskeyAuthenticator{
+		RelyingParty:  WebAuthnRelyingParty{Id: "buzkaaclicker.pl", Origins: []string{origin}},
+		Store:         store,
+		Challenges:    &testPasskeyChallengeStore{},
+		ActivityStore: &testActivityStore{},
+		Secret:        []byte("0123456789abcdef0123456789abcdef"),
+	}
+	user := User{Id: 7}
+
+	register := func(device *webauthntest.Authenticator, rpId string) error {
+		registration, err := authenticator.BeginRegistration(ctx, user)
+		if err != nil {
+			return err
+		}
+		attestation, err := device.Create(rpId, registration.Challenge, registration.UserHandle)
+		if err != nil {
+			return err
+		}
+		_, err = authenticator.FinishRegistration(ctx, user, registration.State, "", CredentialCreation{
+			ClientDataJson: attestation.ClientDataJson, AttestationObject: attestation.AttestationObject})
+		return err
+	}
+	login := func(device *webauthntest.Authenticator, tamper func(assertion *CredentialAssertion)) error {
+		login, err := authenticator.BeginLogin(ctx)
+		if err != nil {
+			return err
+		}
+		signed, err := device.Get("buzkaaclicker.pl", login.Challenge, nil)
+		if err != nil {
+			return err
+		}
+		assertion := CredentialAssertion{CredentialId: signed.CredentialId, ClientDataJson: signed.ClientDataJson,
+			AuthenticatorData: signed.AuthenticatorData, Signature: signed.Signature, UserHandle: signed.UserHandle}
+		if tamper != nil {
+			tamper(&assertion)
+		}
+		_, err = authenticator.FinishLogin(ctx, login.State, assertion)
+		return err
+	}
+
+	phishing := webauthntest.NewAuthenticator("https://buzkaaclicker.pl.evil.com")
+	assert.ErrorIs(register(phishing, "buzkaaclicker.pl"), ErrInvalidWebAuthnResponse, "foreign origin")
+	assert.ErrorIs(register(webauthntest.NewAuthenticator(origin), "evil.com"), ErrInvalidWebAuthnResponse,
+		"foreign relying party")
+
+	device := webauthntest.NewAuthenticator(origin)
+	if !assert.NoError(register(device, "buzkaaclicker.pl")) {
+		return
+	}
+	assert.NoError(login(device, nil))
+	assert.ErrorIs(login(device, func(assertion *CredentialAssertion) {
+		assertion.Signature[len(assertion.Signature)-1] ^= 1
+	}), ErrInvalidWebAuthnResponse, "tampered signature")
+	assert.ErrorIs(login(device, func(assertion *CredentialAssertion) {
+		assertion.UserHandle = PasskeyUserHandle(8)
+	}), ErrInvalidWebAuthnResponse, "other user handle")
+
+	device.UserVerification = false
+	assert.ErrorIs(login(device, nil), ErrInvalidWebAuthnResponse, "passwordless login requires user verification")
+
+	// authenticators not counting signatures
+	counterless := webauthntest.NewAuthenticator(origin)
+	counterless.NoSignCount = true
+	store.passkeys = map[string]Passkey{}
+	if !assert.NoError(register(counterless, "buzkaaclicker.pl")) {
+		return
+	}
+	assert.NoError(login(counterless, nil))
+	assert.NoError(login(counterless, nil))
+	replayed, err := authenticator.BeginLogin(ctx)
+	if assert.NoError(err) {
+		signed, err := counterless.Get("buzkaaclicker.pl", replayed.Challenge, nil)
+		if assert.NoError(err) {
+			assertion := CredentialAssertion{CredentialId: signed.CredentialId, ClientDataJson: signed.ClientDataJson,
+				AuthenticatorData: signed.AuthenticatorData, Signature: signed.Signature, UserHandle: signed.UserHandle}
+			_, err = authenticator.FinishLogin(ctx, replayed.State, assertion)
+			assert.NoError(err)
+			_, err = authenticator.FinishLogin(ctx, replayed.State, assertion)
+			assert.ErrorIs(err, ErrInvalidPasskeyCeremony, "replay of the assertion without a signature counter")
+		}
+	}
+
+	expired := authenticator
+	expired.Now = func() time.Time { return time.Now().Add(-DefaultPasskeyCeremonyTTL) }
+	state, err := expired.BeginLogin(ctx)
+	if assert.NoError(err) {
+		_, err = authenticator.FinishLogin(ctx, state.State, CredentialAssertion{})
+		assert.ErrorIs(err, ErrInvalidPasskeyCeremony, "expired state")
+	}
+}
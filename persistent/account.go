@@ -0,0 +1,286 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+type AccountDeletion struct {
+	bun.BaseModel `bun:"table:account_deletion"`
+
+	UserId      int64     `bun:",pk"`
+	RequestedAt time.Time `bun:",notnull"`
+	DeleteAt    time.Time `bun:",notnull"`
+}
+
+func (d AccountDeletion) ToDomain() buzza.AccountDeletion {
+	return buzza.AccountDeletion{
+		UserId:      buzza.UserId(d.UserId),
+		RequestedAt: d.RequestedAt,
+		DeleteAt:    d.DeleteAt,
+	}
+}
+
+type AccountDeletionStore struct {
+	DB *bun.DB
+	// Archived activity logs of the user are erased as well if set.
+	Archive buzza.ActivityArchive
+}
+
+var _ buzza.AccountDeletionStore = (*AccountDeletionStore)(nil)
+
+func (s *AccountDeletionStore) Schedule(ctx context.Context, deletion buzza.AccountDeletion) error {
+	res, err := s.DB.NewInsert().
+		Model(&AccountDeletion{
+			UserId:      int64(deletion.UserId),
+			RequestedAt: deletion.RequestedAt.UTC(),
+			DeleteAt:    deletion.DeleteAt.UTC(),
+		}).
+		On("CONFLICT DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("insert account deletion: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return buzza.ErrAccountDeletionScheduled
+	}
+	return nil
+}
+
+func (s *AccountDeletionStore) ByUserId(ctx context.Context, userId buzza.UserId) (buzza.AccountDeletion, error) {
+	deletion := new(AccountDeletion)
+	err := s.DB.NewSelect().
+		Model(deletion).
+		Where("user_id=?", userId).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.AccountDeletion{}, buzza.ErrAccountDeletionNotFound
+		}
+		return buzza.AccountDeletion{}, fmt.Errorf("select account deletion: %w", err)
+	}
+	return deletion.ToDomain(), nil
+}
+
+func (s *AccountDeletionStore) Cancel(ctx context.Context, userId buzza.UserId) error {
+	res, err := s.DB.NewDelete().
+		Model((*AccountDeletion)(nil)).
+		Where("user_id=?", userId).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("delete account deletion: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return buzza.ErrAccountDeletionNotFound
+	}
+	return nil
+}
+
+func (s *AccountDeletionStore) Due(ctx context.Context, now time.Time, limit int) ([]buzza.AccountDeletion, error) {
+	var rows []AccountDeletion
+	err := s.DB.NewSelect().
+		Model(&rows).
+		Where("delete_at<=?", now.UTC()).
+		Order("delete_at ASC", "user_id ASC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("select due account deletions: %w", err)
+	}
+	deletions := make([]buzza.AccountDeletion, len(rows))
+	for i, row := range rows {
+		deletions[i] = row.ToDomain()
+	}
+	return deletions, nil
+}
+
+// Tables with personal data of the user removed on erasure, all keyed by user_id.
+var erasedUserTables = []interface{}{
+	(*Profile)(nil),
+	(*UserIdentity)(nil),
+	(*UnlinkedIdentity)(nil),
+	(*TwoFactor)(nil),
+	(*Passkey)(nil),
+	(*LoginSource)(nil),
+	(*NotificationSettings)(nil),
+	(*AnnouncementReadMarker)(nil),
+	(*ReferralCode)(nil),
+	(*MagicLink)(nil),
+	(*PgSession)(nil),
+	(*ClickerConfig)(nil),
+}
+
+// The user row is kept with the personal data cleared, so referrals and rewards
+// (records of the purchases) still point to it. Activity logs of users under legal hold are kept.
+// Clicker configs with their history and shared configs with their ratings and reports are deleted,
+// ratings and reports of the user on configs of others are deleted as well. Crash reports are kept
+// for the crash statistics, but made anonymous. Archived activity logs are removed from the Archive
+// in the same transaction, so a failure is retried with the next erasure run.
+func (s *AccountDeletionStore) Erase(ctx context.Context, userId buzza.UserId, now time.Time) error {
+	return s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		// cancellation deletes the same row, so erasure and cancellation can not both succeed
+		res, err := tx.NewDelete().
+			Model((*AccountDeletion)(nil)).
+			Where("user_id=?", userId).
+			Where("delete_at<=?", now.UTC()).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete account deletion: %w", err)
+		}
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			return buzza.ErrAccountDeletionNotFound
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*User)(nil)).
+			Set("email=''").
+			Set("email_index=NULL").
+			Set("roles_names='{}'").
+			Set("deleted_at=?", now.UTC()).
+			Where("id=?", userId).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("anonymize user: %w", err)
+		}
+		_, err = tx.NewDelete().
+			Model((*ClickerConfigRevision)(nil)).
+			Where("config_id IN (SELECT id FROM clicker_config WHERE user_id=?)", userId).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete clicker config revisions: %w", err)
+		}
+		if err := eraseSharedConfigs(ctx, tx, userId); err != nil {
+			return err
+		}
+		for _, model := range erasedUserTables {
+			_, err := tx.NewDelete().
+				Model(model).
+				Where("user_id=?", userId).
+				Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("delete %T: %w", model, err)
+			}
+		}
+		_, err = tx.NewDelete().
+			Model((*ActivityLog)(nil)).
+			Where("user_id=?", userId).
+			Where("user_id NOT IN (SELECT user_id FROM activity_legal_hold)").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete activity logs: %w", err)
+		}
+		if s.Archive != nil {
+			held, err := tx.NewSelect().
+				Model((*ActivityLegalHold)(nil)).
+				Where("user_id=?", userId).
+				Exists(ctx)
+			if err != nil {
+				return fmt.Errorf("check legal hold: %w", err)
+			}
+			if !held {
+				if err := s.Archive.EraseUser(ctx, userId); err != nil {
+					return fmt.Errorf("erase archived activity logs: %w", err)
+				}
+			}
+		}
+		_, err = tx.NewUpdate().
+			Model((*Referral)(nil)).
+			Set("ip=''").
+			Where("referee_id=?", userId).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("anonymize referral: %w", err)
+		}
+		_, err = tx.NewUpdate().
+			Model((*CrashReport)(nil)).
+			Set("user_id=NULL").
+			Where("user_id=?", userId).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("anonymize crash reports: %w", err)
+		}
+		return nil
+	})
+}
+
+// Delete shared configs of the user and ratings and reports the user has made.
+func eraseSharedConfigs(ctx context.Context, tx bun.Tx, userId buzza.UserId) error {
+	authored := tx.NewSelect().
+		Model((*SharedConfig)(nil)).
+		Column("id").
+		Where("author_id=?", userId)
+	_, err := tx.NewDelete().
+		Model((*SharedConfigRating)(nil)).
+		Where("shared_config_id IN (?)", authored).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("delete ratings of shared configs: %w", err)
+	}
+	_, err = tx.NewDelete().
+		Model((*SharedConfigReport)(nil)).
+		Where("shared_config_id IN (?) OR reporter_id=?", authored, userId).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("delete shared config reports: %w", err)
+	}
+	_, err = tx.NewDelete().
+		Model((*SharedConfig)(nil)).
+		Where("author_id=?", userId).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("delete shared configs: %w", err)
+	}
+
+	var rated []int64
+	_, err = tx.NewDelete().
+		Model((*SharedConfigRating)(nil)).
+		Where("user_id=?", userId).
+		Returning("shared_config_id").
+		Exec(ctx, &rated)
+	if err != nil {
+		return fmt.Errorf("delete ratings: %w", err)
+	}
+	if len(rated) == 0 {
+		return nil
+	}
+	// same denormalized summary as in SharedConfigStore.Rate
+	_, err = tx.NewUpdate().
+		Model((*SharedConfig)(nil)).
+		Set("rating_count=(SELECT count(*) FROM shared_config_rating WHERE shared_config_id=shared_config.id)").
+		Set("rating_sum=(SELECT coalesce(sum(rating), 0) FROM shared_config_rating "+
+			"WHERE shared_config_id=shared_config.id)").
+		Where("id IN (?)", bun.In(rated)).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("update rating summary: %w", err)
+	}
+	return nil
+}
+
+// Create the account deletion table and add the deletion time to the user table
+// created before account deletion was introduced.
+func MigrateAccountDeletion(ctx context.Context, db *bun.DB) error {
+	_, err := db.NewCreateTable().IfNotExists().Model((*AccountDeletion)(nil)).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("create account deletion table: %w", err)
+	}
+	_, err = db.ExecContext(ctx, `ALTER TABLE "user" ADD COLUMN IF NOT EXISTS deleted_at timestamptz`)
+	if err != nil {
+		return fmt.Errorf("add deleted at column: %w", err)
+	}
+	return nil
+}
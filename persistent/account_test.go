@@ -0,0 +1,196 @@
+package persistent
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountDeletionStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+	userStore := &UserStore{DB: db}
+	activityStore := &ActivityStore{DB: db}
+	referralStore := &ReferralStore{DB: db, RewardRule: buzza.DefaultReferralRewardRule}
+	archive := &FileActivityArchive{Dir: t.TempDir()}
+	store := &AccountDeletionStore{DB: db, Archive: archive}
+
+	register := func(subject string) buzza.User {
+		user, _, err := userStore.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+			Provider: buzza.ProviderDiscord, Subject: subject, Email: buzza.Email(subject + "@delete.me"),
+			Name: subject})
+		if err != nil {
+			panic(err)
+		}
+		return user
+	}
+	referrer := register("deletion-referrer")
+	user := register("deletion-user")
+	held := register("deletion-held")
+	for _, u := range []buzza.User{user, held} {
+		if !assert.NoError(activityStore.AddLog(ctx, u.Id, buzza.TwoFactorEnabledActivity())) {
+			return
+		}
+	}
+	if !assert.NoError(activityStore.SetLegalHold(ctx, held.Id, "investigation")) {
+		return
+	}
+	err := archive.Archive(ctx, []buzza.ActivityLog{
+		{Id: 1, UserId: user.Id, Name: buzza.ActivityTwoFactorEnabled, Version: 1},
+		{Id: 2, UserId: held.Id, Name: buzza.ActivityTwoFactorEnabled, Version: 1},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	code, err := referralStore.CodeByUserId(ctx, referrer.Id)
+	if !assert.NoError(err) {
+		return
+	}
+	if _, err := referralStore.Attach(ctx, code, user, "10.0.0.2"); !assert.NoError(err) {
+		return
+	}
+	if _, err := referralStore.RegisterPurchase(ctx, user.Id); !assert.NoError(err) {
+		return
+	}
+	clickerConfigStore := &ClickerConfigStore{DB: db}
+	if _, err := clickerConfigStore.Save(ctx, user.Id, "pvp", json.RawMessage(`{"cps":1}`), 0); !assert.NoError(err) {
+		return
+	}
+	sharedConfigStore := &SharedConfigStore{DB: db}
+	publish := func(author buzza.User) buzza.SharedConfig {
+		config, err := sharedConfigStore.Publish(ctx, author.Id, buzza.SharedConfigMeta{Title: "Butterfly"},
+			json.RawMessage(`{"cps":20}`))
+		if err != nil {
+			panic(err)
+		}
+		return config
+	}
+	shared := publish(user)
+	otherShared := publish(referrer)
+	assert.NoError(sharedConfigStore.Rate(ctx, shared.Id, referrer.Id, 5))
+	assert.NoError(sharedConfigStore.Report(ctx, shared.Id, referrer.Id, "spam"))
+	assert.NoError(sharedConfigStore.Rate(ctx, otherShared.Id, user.Id, 4))
+	assert.NoError(sharedConfigStore.Report(ctx, otherShared.Id, user.Id, "spam"))
+	crashStore := &CrashStore{DB: db}
+	crash, _, err := crashStore.Add(ctx, buzza.CrashReport{Signature: buzza.CrashSignature("clicker", "erased"),
+		UserId: user.Id, Module: "clicker", ProgramVersion: "1.2.0", StackTrace: "erased"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	deletion := buzza.AccountDeletion{UserId: user.Id, RequestedAt: now, DeleteAt: now.Add(time.Hour)}
+	_, err = store.ByUserId(ctx, user.Id)
+	assert.ErrorIs(err, buzza.ErrAccountDeletionNotFound)
+	if !assert.NoError(store.Schedule(ctx, deletion)) {
+		return
+	}
+	assert.ErrorIs(store.Schedule(ctx, deletion), buzza.ErrAccountDeletionScheduled)
+	scheduled, err := store.ByUserId(ctx, user.Id)
+	if assert.NoError(err) {
+		assert.Equal(deletion.DeleteAt, scheduled.DeleteAt.UTC())
+	}
+	due, err := store.Due(ctx, now, 10)
+	if assert.NoError(err) {
+		assert.Empty(due)
+	}
+	assert.ErrorIs(store.Erase(ctx, user.Id, now), buzza.ErrAccountDeletionNotFound, "not due yet")
+
+	assert.NoError(store.Cancel(ctx, user.Id))
+	assert.ErrorIs(store.Cancel(ctx, user.Id), buzza.ErrAccountDeletionNotFound)
+	assert.ErrorIs(store.Erase(ctx, user.Id, now.Add(time.Hour)), buzza.ErrAccountDeletionNotFound,
+		"cancelled deletion")
+	if _, err := userStore.ById(ctx, user.Id); !assert.NoError(err, "cancelled account is kept") {
+		return
+	}
+
+	for _, u := range []buzza.User{user, held} {
+		err := store.Schedule(ctx, buzza.AccountDeletion{UserId: u.Id, RequestedAt: now, DeleteAt: now.Add(time.Hour)})
+		if !assert.NoError(err) {
+			return
+		}
+	}
+	due, err = store.Due(ctx, now.Add(time.Hour), 10)
+	if assert.NoError(err) {
+		assert.Len(due, 2)
+	}
+	for _, u := range []buzza.User{user, held} {
+		if !assert.NoError(store.Erase(ctx, u.Id, now.Add(time.Hour))) {
+			return
+		}
+	}
+
+	_, err = userStore.ById(ctx, user.Id)
+	assert.ErrorIs(err, buzza.ErrUserNotFound)
+	_, err = (&ProfileStore{DB: db}).ByUserId(ctx, user.Id)
+	assert.Error(err)
+	identities, err := userStore.Identities(ctx, user.Id)
+	if assert.NoError(err) {
+		assert.Empty(identities)
+	}
+	erased := new(User)
+	err = db.NewSelect().Model(erased).Where("id=?", user.Id).Scan(ctx)
+	if assert.NoError(err) {
+		assert.Empty(erased.Email)
+		assert.Empty(erased.RolesNames)
+		assert.False(erased.DeletedAt.IsZero())
+	}
+
+	logs, err := activityStore.ByUserId(ctx, user.Id, buzza.ActivityQuery{Limit: 100})
+	if assert.NoError(err) {
+		assert.Empty(logs)
+	}
+	logs, err = activityStore.ByUserId(ctx, held.Id, buzza.ActivityQuery{Limit: 100})
+	if assert.NoError(err) {
+		assert.NotEmpty(logs, "logs under legal hold are kept")
+	}
+	archived, err := readArchivedLogs(filepath.Join(archive.Dir, "activity-1-2.jsonl.gz"))
+	if assert.NoError(err) && assert.Len(archived, 1, "archived logs are erased") {
+		assert.Equal(held.Id, archived[0].UserId, "archived logs under legal hold are kept")
+	}
+
+	referrals, err := referralStore.ByReferrerId(ctx, referrer.Id)
+	if assert.NoError(err) && assert.Len(referrals, 1) {
+		assert.Equal(user.Id, referrals[0].RefereeId, "purchase record is kept")
+		assert.False(referrals[0].PurchasedAt.IsZero())
+	}
+	var ip string
+	err = db.NewSelect().Model((*Referral)(nil)).Column("ip").Where("referee_id=?", user.Id).Scan(ctx, &ip)
+	if assert.NoError(err) {
+		assert.Empty(ip)
+	}
+
+	configs, err := clickerConfigStore.ByUserId(ctx, user.Id)
+	if assert.NoError(err) {
+		assert.Empty(configs)
+	}
+	_, err = sharedConfigStore.ById(ctx, shared.Id)
+	assert.ErrorIs(err, buzza.ErrSharedConfigNotFound)
+	rated, err := sharedConfigStore.ById(ctx, otherShared.Id)
+	if assert.NoError(err) {
+		assert.Zero(rated.RatingCount, "rating of the user is removed from the summary")
+	}
+	reports, err := db.NewSelect().
+		Model((*SharedConfigReport)(nil)).
+		Where("shared_config_id IN (?, ?)", shared.Id, otherShared.Id).
+		Count(ctx)
+	if assert.NoError(err) {
+		assert.Zero(reports)
+	}
+	crash, err = crashStore.ById(ctx, crash.Id)
+	if assert.NoError(err) {
+		assert.Zero(crash.UserId, "crash report is kept anonymous")
+	}
+}
@@ -0,0 +1,82 @@
+package persistent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+const activityLogChannel = "activity_log"
+
+// Shares activity logs between backend instances through pg LISTEN/NOTIFY.
+// Received logs are fanned out to the subscribers by the local broker.
+type PgActivityBroker struct {
+	DB       *bun.DB
+	Local    buzza.ActivityBroker
+	listener *pgdriver.Listener
+}
+
+var _ buzza.ActivityBroker = (*PgActivityBroker)(nil)
+
+type activityNotification struct {
+	Id        int64                  `json:"id"`
+	CreatedAt time.Time              `json:"createdAt"`
+	UserId    buzza.UserId           `json:"userId"`
+	Name      string                 `json:"name"`
+	Version   int                    `json:"version"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// Start listening for the logs published by all instances. Broker must be closed after use.
+func (b *PgActivityBroker) Listen(ctx context.Context) error {
+	b.listener = pgdriver.NewListener(b.DB)
+	if err := b.listener.Listen(ctx, activityLogChannel); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	go func() {
+		for notification := range b.listener.Channel() {
+			var n activityNotification
+			if err := json.Unmarshal([]byte(notification.Payload), &n); err != nil {
+				logrus.WithError(err).Warnln("Could not decode activity log notification.")
+				continue
+			}
+			log := buzza.ActivityLog{Id: n.Id, CreatedAt: n.CreatedAt, UserId: n.UserId,
+				Name: n.Name, Version: n.Version, Data: n.Data}
+			if err := b.Local.Publish(context.Background(), log); err != nil {
+				logrus.WithError(err).Warnln("Could not publish activity log locally.")
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *PgActivityBroker) Close() error {
+	if b.listener == nil {
+		return nil
+	}
+	return b.listener.Close()
+}
+
+// Notify all instances, including this one, about the log.
+func (b *PgActivityBroker) Publish(ctx context.Context, log buzza.ActivityLog) error {
+	payload, err := json.Marshal(activityNotification{Id: log.Id, CreatedAt: log.CreatedAt, UserId: log.UserId,
+		Name: log.Name, Version: log.Version, Data: log.Data})
+	if err != nil {
+		return fmt.Errorf("marshal notification: %w", err)
+	}
+	if err := pgdriver.Notify(ctx, b.DB, activityLogChannel, string(payload)); err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	return nil
+}
+
+func (b *PgActivityBroker) Subscribe(userId buzza.UserId) (<-chan buzza.ActivityLog, func()) {
+	return b.Local.Subscribe(userId)
+}
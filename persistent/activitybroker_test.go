@@ -0,0 +1,45 @@
+package persistent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgActivityBroker(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	local := inmem.NewActivityBroker()
+	broker := &PgActivityBroker{DB: db, Local: &local}
+	if !assert.NoError(broker.Listen(ctx)) {
+		return
+	}
+	defer broker.Close()
+
+	logs, cancel := broker.Subscribe(5)
+	defer cancel()
+	store := &ActivityStore{DB: db, Broker: broker}
+	assert.NoError(store.AddLog(ctx, 5, buzza.Activity{Name: "logged in",
+		Data: map[string]interface{}{"ip": "1.1.1.1"}}))
+
+	select {
+	case log := <-logs:
+		assert.Equal(buzza.UserId(5), log.UserId)
+		assert.Equal("logged in", log.Name)
+		assert.Equal(map[string]interface{}{"ip": "1.1.1.1"}, log.Data)
+		assert.NotZero(log.Id)
+	case <-time.After(5 * time.Second):
+		assert.Fail("log not received")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/buzkaaclicker/buzza"
+	"github.com/sirupsen/logrus"
 	"github.com/uptrace/bun"
 )
 
@@ -16,6 +17,7 @@ type ActivityLog struct {
 	CreatedAt time.Time              `bun:",nullzero,notnull,default:current_timestamp"`
 	UserId    int64                  `bun:",notnull"`
 	Name      string                 `bun:",notnull"`
+	Version   int                    `bun:",notnull,default:1"`
 	Data      map[string]interface{} `bun:",notnull"`
 }
 
@@ -25,52 +27,107 @@ func (l *ActivityLog) ToDomain() buzza.ActivityLog {
 		CreatedAt: l.CreatedAt,
 		UserId:    buzza.UserId(l.UserId),
 		Name:      l.Name,
+		Version:   l.Version,
 		Data:      l.Data,
 	}
 }
 
 type ActivityStore struct {
 	DB *bun.DB
+	// Validates logged activities if set.
+	Catalogue buzza.ActivityCatalogue
+	// Receives added logs if set.
+	Broker buzza.ActivityBroker
 }
 
 var _ buzza.ActivityStore = (*ActivityStore)(nil)
 
 func (s *ActivityStore) AddLog(ctx context.Context, userId buzza.UserId, activity buzza.Activity) error {
-	_, err := s.DB.NewInsert().
-		Model(&ActivityLog{
-			UserId: int64(userId),
-			Name:   activity.Name,
-			Data:   activity.Data,
-		}).
+	activity, err := validateActivity(s.Catalogue, activity)
+	if err != nil {
+		return err
+	}
+	log := &ActivityLog{
+		UserId:  int64(userId),
+		Name:    activity.Name,
+		Version: activity.Version,
+		Data:    activity.Data,
+	}
+	_, err = s.DB.NewInsert().
+		Model(log).
+		Returning("*").
 		Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("insert log entry: %w", err)
 	}
+
+	if s.Broker != nil {
+		// log is already stored, subscribers can still fetch it later
+		if err := s.Broker.Publish(ctx, log.ToDomain()); err != nil {
+			logrus.WithError(err).WithField("activity_log_id", log.Id).Warnln("Could not publish activity log.")
+		}
+	}
 	return nil
 }
 
-func (s *ActivityStore) ByUserId(ctx context.Context, userId buzza.UserId, beforeId int64, limit int32) ([]buzza.ActivityLog, error) {
-	if limit <= 0 {
+func (s *ActivityStore) ByUserId(ctx context.Context, userId buzza.UserId, query buzza.ActivityQuery) ([]buzza.ActivityLog, error) {
+	return s.query(ctx, query, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("user_id=?", userId)
+	})
+}
+
+func (s *ActivityStore) Audit(ctx context.Context, query buzza.AuditQuery) ([]buzza.ActivityLog, error) {
+	return s.query(ctx, query.ActivityQuery, func(q *bun.SelectQuery) *bun.SelectQuery {
+		if query.UserId != 0 {
+			q = q.Where("user_id=?", query.UserId)
+		}
+		return q
+	})
+}
+
+func (s *ActivityStore) query(ctx context.Context, query buzza.ActivityQuery,
+	scope func(q *bun.SelectQuery) *bun.SelectQuery) ([]buzza.ActivityLog, error) {
+	if query.Limit <= 0 {
 		return []buzza.ActivityLog{}, nil
 	}
 
 	var logs []ActivityLog
-	var err error
-	if beforeId >= 0 {
-		err = s.DB.NewSelect().
-			Model((*ActivityLog)(nil)).
-			Where("user_id=? AND id < ?", userId, beforeId).
-			Order("id DESC").
-			Limit(int(limit)).
-			Scan(ctx, &logs)
-	} else {
-		err = s.DB.NewSelect().
-			Model((*ActivityLog)(nil)).
-			Where("user_id=?", userId).
-			Order("id DESC").
-			Limit(int(limit)).
-			Scan(ctx, &logs)
+	q := scope(s.DB.NewSelect().
+		Model((*ActivityLog)(nil)))
+	if query.BeforeId > 0 {
+		q = q.Where("id < ?", query.BeforeId)
+	}
+	if query.AfterId > 0 {
+		q = q.Where("id > ?", query.AfterId)
 	}
+	if len(query.Names) > 0 {
+		q = q.Where("name IN (?)", bun.In(query.Names))
+	}
+	if !query.From.IsZero() {
+		q = q.Where("created_at >= ?", query.From)
+	}
+	if !query.To.IsZero() {
+		q = q.Where("created_at < ?", query.To)
+	}
+	if query.SessionId != "" {
+		q = q.Where("data->>'session_id' = ?", query.SessionId)
+	}
+	if query.Ip != "" {
+		q = q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			for _, field := range buzza.ActivityIpFields {
+				q = q.WhereOr("data->>? = ?", field, query.Ip)
+			}
+			return q
+		})
+	}
+	order := "id DESC"
+	if query.OldestFirst {
+		order = "id ASC"
+	}
+	err := q.
+		Order(order).
+		Limit(query.Limit).
+		Scan(ctx, &logs)
 	if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
 	}
@@ -81,3 +138,13 @@ func (s *ActivityStore) ByUserId(ctx context.Context, userId buzza.UserId, befor
 	}
 	return ml, nil
 }
+
+func validateActivity(catalogue buzza.ActivityCatalogue, activity buzza.Activity) (buzza.Activity, error) {
+	if catalogue == nil {
+		if activity.Version == 0 {
+			activity.Version = 1
+		}
+		return activity, nil
+	}
+	return catalogue.Validate(activity)
+}
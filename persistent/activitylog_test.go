@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/storetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,7 +38,7 @@ func TestActivityStore(t *testing.T) {
 
 	var lastLog buzza.ActivityLog
 	{
-		logs, err := store.ByUserId(ctx, uid, -1, 100)
+		logs, err := store.ByUserId(ctx, uid, buzza.ActivityQuery{Limit: 100})
 		if !assert.NoError(err) {
 			return
 		}
@@ -51,29 +52,48 @@ func TestActivityStore(t *testing.T) {
 	}
 
 	{
-		logs, err := store.ByUserId(ctx, uid, lastLog.Id, 100)
+		logs, err := store.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: lastLog.Id, Limit: 100})
 		if !assert.NoError(err) {
 			assert.Equal(1, len(logs))
 		}
 
-		logs, err = store.ByUserId(ctx, uid, lastLog.Id+1, 100)
+		logs, err = store.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: lastLog.Id + 1, Limit: 100})
 		if !assert.NoError(err) {
 			assert.Equal(2, len(logs))
 		}
 
-		logs, err = store.ByUserId(ctx, uid, math.MaxInt64, 100)
+		logs, err = store.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: math.MaxInt64, Limit: 100})
 		if !assert.NoError(err) {
 			assert.Equal(2, len(logs))
 		}
 
-		logs, err = store.ByUserId(ctx, uid, lastLog.Id-2, -5)
+		logs, err = store.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: lastLog.Id - 2, Limit: -5})
 		if !assert.NoError(err) {
 			assert.Equal(0, len(logs))
 		}
 
-		logs, err = store.ByUserId(ctx, uid, lastLog.Id+2, -6)
+		logs, err = store.ByUserId(ctx, uid, buzza.ActivityQuery{BeforeId: lastLog.Id + 2, Limit: -6})
 		if !assert.NoError(err) {
 			assert.Equal(0, len(logs))
 		}
 	}
 }
+
+func TestActivityStoreConformance(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	ctx := context.Background()
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	_, err := db.NewDelete().
+		Model((*ActivityLog)(nil)).
+		Where("1=1").
+		Exec(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	storetest.RunActivityStoreTests(t, &ActivityStore{DB: db})
+}
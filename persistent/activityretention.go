@@ -0,0 +1,278 @@
+package persistent
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+type ActivityLegalHold struct {
+	bun.BaseModel `bun:"table:activity_legal_hold"`
+
+	UserId    int64     `bun:",pk"`
+	Reason    string    `bun:",notnull"`
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+func (h ActivityLegalHold) ToDomain() buzza.LegalHold {
+	return buzza.LegalHold{
+		UserId:    buzza.UserId(h.UserId),
+		Reason:    h.Reason,
+		CreatedAt: h.CreatedAt,
+	}
+}
+
+var _ buzza.ActivityRetentionStore = (*ActivityStore)(nil)
+
+func (s *ActivityStore) Expired(ctx context.Context, query buzza.ActivityExpiryQuery) ([]buzza.ActivityLog, error) {
+	var logs []ActivityLog
+	q := s.DB.NewSelect().
+		Model(&logs).
+		Where("created_at < ?", query.Before).
+		Where("user_id NOT IN (SELECT user_id FROM activity_legal_hold)")
+	if len(query.Names) > 0 {
+		q = q.Where("name IN (?)", bun.In(query.Names))
+	}
+	if len(query.ExcludeNames) > 0 {
+		q = q.Where("name NOT IN (?)", bun.In(query.ExcludeNames))
+	}
+	err := q.
+		Order("id ASC").
+		Limit(query.Limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.ActivityLog, len(logs))
+	for i, l := range logs {
+		mapped[i] = l.ToDomain()
+	}
+	return mapped, nil
+}
+
+func (s *ActivityStore) DeleteLogs(ctx context.Context, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	res, err := s.DB.NewDelete().
+		Model((*ActivityLog)(nil)).
+		Where("id IN (?)", bun.In(ids)).
+		// hold might have been placed after the logs were selected
+		Where("user_id NOT IN (SELECT user_id FROM activity_legal_hold)").
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("delete logs: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return deleted, nil
+}
+
+func (s *ActivityStore) SetLegalHold(ctx context.Context, userId buzza.UserId, reason string) error {
+	_, err := s.DB.NewInsert().
+		Model(&ActivityLegalHold{UserId: int64(userId), Reason: reason}).
+		On("CONFLICT (user_id) DO UPDATE SET reason=EXCLUDED.reason").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("upsert legal hold: %w", err)
+	}
+	return nil
+}
+
+func (s *ActivityStore) ReleaseLegalHold(ctx context.Context, userId buzza.UserId) error {
+	res, err := s.DB.NewDelete().
+		Model((*ActivityLegalHold)(nil)).
+		Where("user_id=?", userId).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("delete legal hold: %w", err)
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return buzza.ErrLegalHoldNotFound
+	}
+	return nil
+}
+
+func (s *ActivityStore) LegalHolds(ctx context.Context) ([]buzza.LegalHold, error) {
+	var holds []ActivityLegalHold
+	err := s.DB.NewSelect().
+		Model(&holds).
+		Order("user_id ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.LegalHold, len(holds))
+	for i, h := range holds {
+		mapped[i] = h.ToDomain()
+	}
+	return mapped, nil
+}
+
+// Archives each batch of the pruned logs into gzip compressed JSON Lines file in the directory.
+type FileActivityArchive struct {
+	Dir string
+}
+
+var _ buzza.ActivityArchive = (*FileActivityArchive)(nil)
+
+type archivedActivityLog struct {
+	Id        int64                  `json:"id"`
+	CreatedAt time.Time              `json:"createdAt"`
+	UserId    buzza.UserId           `json:"userId"`
+	Name      string                 `json:"name"`
+	Version   int                    `json:"version"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+func (a *FileActivityArchive) Archive(ctx context.Context, logs []buzza.ActivityLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(a.Dir, 0o750); err != nil {
+		return fmt.Errorf("create archive dir: %w", err)
+	}
+	name := fmt.Sprintf("activity-%d-%d.jsonl.gz", logs[0].Id, logs[len(logs)-1].Id)
+
+	// write to temp file first, so partially written archive is never taken as complete
+	tmp, err := os.CreateTemp(a.Dir, ".activity-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeArchivedLogs(tmp, logs); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(a.Dir, name)); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
+
+// Rewrite archive files with logs of the user left out, files left empty are removed.
+func (a *FileActivityArchive) EraseUser(ctx context.Context, userId buzza.UserId) error {
+	entries, err := os.ReadDir(a.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read archive dir: %w", err)
+	}
+	for _, entry := range entries {
+		// temp files start with a dot
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "activity-") {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := a.eraseUserFromFile(filepath.Join(a.Dir, entry.Name()), userId); err != nil {
+			return fmt.Errorf("erase user from %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (a *FileActivityArchive) eraseUserFromFile(path string, userId buzza.UserId) error {
+	archived, err := readArchivedLogs(path)
+	if err != nil {
+		return err
+	}
+	kept := make([]buzza.ActivityLog, 0, len(archived))
+	for _, log := range archived {
+		if log.UserId != userId {
+			kept = append(kept, buzza.ActivityLog{Id: log.Id, CreatedAt: log.CreatedAt, UserId: log.UserId,
+				Name: log.Name, Version: log.Version, Data: log.Data})
+		}
+	}
+	if len(kept) == len(archived) {
+		return nil
+	}
+	if len(kept) == 0 {
+		return os.Remove(path)
+	}
+
+	tmp, err := os.CreateTemp(a.Dir, ".activity-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeArchivedLogs(tmp, kept); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
+
+func readArchivedLogs(path string) ([]archivedActivityLog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+	defer f.Close()
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("open gzip reader: %w", err)
+	}
+	var archived []archivedActivityLog
+	decoder := json.NewDecoder(gzipReader)
+	for decoder.More() {
+		var log archivedActivityLog
+		if err := decoder.Decode(&log); err != nil {
+			return nil, fmt.Errorf("decode log: %w", err)
+		}
+		archived = append(archived, log)
+	}
+	return archived, nil
+}
+
+func writeArchivedLogs(f *os.File, logs []buzza.ActivityLog) error {
+	gzipWriter := gzip.NewWriter(f)
+	w := bufio.NewWriter(gzipWriter)
+	encoder := json.NewEncoder(w)
+	for _, log := range logs {
+		err := encoder.Encode(archivedActivityLog{Id: log.Id, CreatedAt: log.CreatedAt, UserId: log.UserId,
+			Name: log.Name, Version: log.Version, Data: log.Data})
+		if err != nil {
+			return fmt.Errorf("encode log: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return fmt.Errorf("close gzip writer: %w", err)
+	}
+	return nil
+}
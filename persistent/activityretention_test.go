@@ -0,0 +1,104 @@
+package persistent
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivityRetentionStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	for _, model := range []interface{}{(*ActivityLog)(nil), (*ActivityLegalHold)(nil)} {
+		_, err := db.NewDelete().
+			Model(model).
+			Where("1=1").
+			Exec(ctx)
+		if !assert.NoError(err) {
+			return
+		}
+	}
+
+	storetest.RunActivityRetentionStoreTests(t, &ActivityStore{DB: db})
+}
+
+func TestFileActivityArchive(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	archive := &FileActivityArchive{Dir: filepath.Join(t.TempDir(), "archive")}
+	createdAt := time.Date(2022, 1, 1, 15, 0, 0, 0, time.UTC)
+	logs := []buzza.ActivityLog{
+		{Id: 3, CreatedAt: createdAt, UserId: 1, Name: "session_created", Version: 1,
+			Data: map[string]interface{}{"ip": "1.1.1.1"}},
+		{Id: 8, CreatedAt: createdAt, UserId: 2, Name: "click", Version: 1},
+	}
+	if !assert.NoError(archive.Archive(ctx, logs)) {
+		return
+	}
+
+	entries, err := os.ReadDir(archive.Dir)
+	if !assert.NoError(err) || !assert.Len(entries, 1, "temp file should be removed") {
+		return
+	}
+	assert.Equal("activity-3-8.jsonl.gz", entries[0].Name())
+
+	f, err := os.Open(filepath.Join(archive.Dir, entries[0].Name()))
+	if !assert.NoError(err) {
+		return
+	}
+	defer f.Close()
+	gzipReader, err := gzip.NewReader(f)
+	if !assert.NoError(err) {
+		return
+	}
+	scanner := bufio.NewScanner(gzipReader)
+	var archived []archivedActivityLog
+	for scanner.Scan() {
+		var log archivedActivityLog
+		if assert.NoError(json.Unmarshal(scanner.Bytes(), &log)) {
+			archived = append(archived, log)
+		}
+	}
+	assert.NoError(scanner.Err())
+	assert.Equal([]archivedActivityLog{
+		{Id: 3, CreatedAt: createdAt, UserId: 1, Name: "session_created", Version: 1,
+			Data: map[string]interface{}{"ip": "1.1.1.1"}},
+		{Id: 8, CreatedAt: createdAt, UserId: 2, Name: "click", Version: 1},
+	}, archived)
+
+	if !assert.NoError(archive.Archive(ctx, []buzza.ActivityLog{{Id: 9, CreatedAt: createdAt, UserId: 1,
+		Name: "click", Version: 1}})) {
+		return
+	}
+	if !assert.NoError(archive.EraseUser(ctx, 1)) {
+		return
+	}
+	entries, err = os.ReadDir(archive.Dir)
+	if !assert.NoError(err) || !assert.Len(entries, 1, "file with logs of the user only is removed") {
+		return
+	}
+	archived, err = readArchivedLogs(filepath.Join(archive.Dir, "activity-3-8.jsonl.gz"))
+	if assert.NoError(err) {
+		assert.Equal([]archivedActivityLog{
+			{Id: 8, CreatedAt: createdAt, UserId: 2, Name: "click", Version: 1},
+		}, archived)
+	}
+	assert.NoError((&FileActivityArchive{Dir: filepath.Join(t.TempDir(), "missing")}).EraseUser(ctx, 1))
+}
@@ -0,0 +1,176 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+type Announcement struct {
+	bun.BaseModel `bun:"table:announcement"`
+
+	Id        int64     `bun:",pk,autoincrement"`
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	AuthorId  int64     `bun:",notnull"`
+	Title     string    `bun:",notnull"`
+	Body      string    `bun:",notnull"`
+	Severity  string    `bun:",notnull"`
+	Targeting Targeting `bun:",notnull,type:jsonb"`
+	StartsAt  time.Time `bun:",notnull"`
+	EndsAt    time.Time `bun:",nullzero"`
+}
+
+func newAnnouncement(a buzza.Announcement) *Announcement {
+	return &Announcement{
+		Id:        a.Id,
+		AuthorId:  int64(a.AuthorId),
+		Title:     a.Title,
+		Body:      a.Body,
+		Severity:  string(a.Severity),
+		Targeting: newTargeting(a.Targeting),
+		StartsAt:  a.StartsAt,
+		EndsAt:    a.EndsAt,
+	}
+}
+
+func (a Announcement) ToDomain() buzza.Announcement {
+	return buzza.Announcement{
+		Id:        a.Id,
+		AuthorId:  buzza.UserId(a.AuthorId),
+		Title:     a.Title,
+		Body:      a.Body,
+		Severity:  buzza.AnnouncementSeverity(a.Severity),
+		Targeting: a.Targeting.ToDomain(),
+		StartsAt:  a.StartsAt,
+		EndsAt:    a.EndsAt,
+		CreatedAt: a.CreatedAt,
+		UpdatedAt: a.UpdatedAt,
+	}
+}
+
+type AnnouncementReadMarker struct {
+	bun.BaseModel `bun:"table:announcement_read_marker"`
+
+	UserId int64     `bun:",pk"`
+	ReadAt time.Time `bun:",notnull"`
+}
+
+type AnnouncementStore struct {
+	DB *bun.DB
+}
+
+var _ buzza.AnnouncementStore = (*AnnouncementStore)(nil)
+
+func (s *AnnouncementStore) Create(ctx context.Context, announcement buzza.Announcement) (buzza.Announcement, error) {
+	stored := newAnnouncement(announcement)
+	stored.Id = 0
+	_, err := s.DB.NewInsert().
+		Model(stored).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return buzza.Announcement{}, fmt.Errorf("insert announcement: %w", err)
+	}
+	return stored.ToDomain(), nil
+}
+
+func (s *AnnouncementStore) Update(ctx context.Context, announcement buzza.Announcement) (buzza.Announcement, error) {
+	stored := newAnnouncement(announcement)
+	stored.UpdatedAt = time.Now().UTC()
+	res, err := s.DB.NewUpdate().
+		Model(stored).
+		Column("updated_at", "title", "body", "severity", "targeting", "starts_at", "ends_at").
+		WherePK().
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return buzza.Announcement{}, fmt.Errorf("update announcement: %w", err)
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return buzza.Announcement{}, buzza.ErrAnnouncementNotFound
+	}
+	return stored.ToDomain(), nil
+}
+
+func (s *AnnouncementStore) Delete(ctx context.Context, id int64) error {
+	res, err := s.DB.NewDelete().
+		Model((*Announcement)(nil)).
+		Where("id=?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("delete announcement: %w", err)
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return buzza.ErrAnnouncementNotFound
+	}
+	return nil
+}
+
+func (s *AnnouncementStore) All(ctx context.Context, offset int, limit int) ([]buzza.Announcement, error) {
+	var announcements []Announcement
+	err := s.DB.NewSelect().
+		Model(&announcements).
+		OrderExpr("starts_at DESC, id DESC").
+		Offset(offset).
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return mapAnnouncements(announcements), nil
+}
+
+func (s *AnnouncementStore) Visible(ctx context.Context, at time.Time) ([]buzza.Announcement, error) {
+	var announcements []Announcement
+	err := s.DB.NewSelect().
+		Model(&announcements).
+		Where("starts_at <= ?", at).
+		Where("ends_at IS NULL OR ends_at > ?", at).
+		OrderExpr("starts_at DESC, id DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return mapAnnouncements(announcements), nil
+}
+
+func (s *AnnouncementStore) ReadMarker(ctx context.Context, userId buzza.UserId) (time.Time, error) {
+	marker := new(AnnouncementReadMarker)
+	err := s.DB.NewSelect().
+		Model(marker).
+		Where("user_id=?", userId).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("select read marker: %w", err)
+	}
+	return marker.ReadAt, nil
+}
+
+func (s *AnnouncementStore) SetReadMarker(ctx context.Context, userId buzza.UserId, at time.Time) error {
+	_, err := s.DB.NewInsert().
+		Model(&AnnouncementReadMarker{UserId: int64(userId), ReadAt: at.UTC()}).
+		On("CONFLICT (user_id) DO UPDATE").
+		Set("read_at=GREATEST(announcement_read_marker.read_at, EXCLUDED.read_at)").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("upsert read marker: %w", err)
+	}
+	return nil
+}
+
+func mapAnnouncements(announcements []Announcement) []buzza.Announcement {
+	mapped := make([]buzza.Announcement, len(announcements))
+	for i, a := range announcements {
+		mapped[i] = a.ToDomain()
+	}
+	return mapped
+}
@@ -0,0 +1,75 @@
+package persistent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnouncementStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	store := &AnnouncementStore{DB: db}
+	now := time.Now().UTC().Truncate(time.Second)
+	current, err := store.Create(ctx, buzza.Announcement{AuthorId: 1, Title: "Current", Body: "x",
+		Severity: buzza.AnnouncementSeverityInfo, Targeting: buzza.Targeting{Branches: []string{"beta"}},
+		StartsAt: now.Add(-time.Hour)})
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = store.Create(ctx, buzza.Announcement{AuthorId: 1, Title: "Expired", Body: "x",
+		Severity: buzza.AnnouncementSeverityInfo, StartsAt: now.Add(-2 * time.Hour), EndsAt: now.Add(-time.Minute)})
+	assert.NoError(err)
+	scheduled, err := store.Create(ctx, buzza.Announcement{AuthorId: 1, Title: "Scheduled", Body: "x",
+		Severity: buzza.AnnouncementSeverityInfo, StartsAt: now.Add(time.Hour)})
+	assert.NoError(err)
+
+	visible, err := store.Visible(ctx, now)
+	if assert.NoError(err) && assert.Len(visible, 1) {
+		assert.Equal(current.Id, visible[0].Id)
+		assert.Equal([]string{"beta"}, visible[0].Targeting.Branches)
+	}
+	all, err := store.All(ctx, 0, 10)
+	if assert.NoError(err) {
+		assert.Len(all, 3)
+	}
+
+	scheduled.Title = "Rescheduled"
+	scheduled.StartsAt = now.Add(-time.Minute)
+	updated, err := store.Update(ctx, scheduled)
+	if assert.NoError(err) {
+		assert.Equal("Rescheduled", updated.Title)
+		assert.Equal(buzza.UserId(1), updated.AuthorId)
+	}
+	visible, err = store.Visible(ctx, now)
+	if assert.NoError(err) {
+		assert.Len(visible, 2)
+	}
+
+	assert.NoError(store.Delete(ctx, current.Id))
+	assert.ErrorIs(store.Delete(ctx, current.Id), buzza.ErrAnnouncementNotFound)
+	_, err = store.Update(ctx, current)
+	assert.ErrorIs(err, buzza.ErrAnnouncementNotFound)
+
+	readAt, err := store.ReadMarker(ctx, 1)
+	if assert.NoError(err) {
+		assert.True(readAt.IsZero())
+	}
+	assert.NoError(store.SetReadMarker(ctx, 1, now))
+	assert.NoError(store.SetReadMarker(ctx, 1, now.Add(-time.Hour)))
+	readAt, err = store.ReadMarker(ctx, 1)
+	if assert.NoError(err) {
+		assert.True(now.Equal(readAt), "read marker should not move back")
+	}
+}
@@ -0,0 +1,256 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+type ClickerConfig struct {
+	bun.BaseModel `bun:"table:clicker_config"`
+
+	Id        int64     `bun:",pk,autoincrement"`
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	UserId    int64     `bun:",notnull,unique:user_config_name"`
+	Name      string    `bun:",notnull,unique:user_config_name,type:varchar(64)"`
+	Revision  int64     `bun:",notnull"`
+	Data      string    `bun:",notnull,type:jsonb"`
+}
+
+func (c ClickerConfig) ToDomain() buzza.ClickerConfig {
+	return buzza.ClickerConfig{
+		UserId:    buzza.UserId(c.UserId),
+		Name:      c.Name,
+		Revision:  c.Revision,
+		Data:      json.RawMessage(c.Data),
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+type ClickerConfigRevision struct {
+	bun.BaseModel `bun:"table:clicker_config_revision"`
+
+	Id        int64     `bun:",pk,autoincrement"`
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	ConfigId  int64     `bun:",notnull,unique:config_revision"`
+	Revision  int64     `bun:",notnull,unique:config_revision"`
+	Data      string    `bun:",notnull,type:jsonb"`
+}
+
+func (r ClickerConfigRevision) ToDomain() buzza.ClickerConfigRevision {
+	return buzza.ClickerConfigRevision{
+		Revision:  r.Revision,
+		Data:      json.RawMessage(r.Data),
+		CreatedAt: r.CreatedAt,
+	}
+}
+
+type ClickerConfigStore struct {
+	DB *bun.DB
+}
+
+var _ buzza.ClickerConfigStore = (*ClickerConfigStore)(nil)
+
+func (s *ClickerConfigStore) ByUserId(ctx context.Context, userId buzza.UserId) ([]buzza.ClickerConfig, error) {
+	var configs []ClickerConfig
+	err := s.DB.NewSelect().
+		Model((*ClickerConfig)(nil)).
+		Where("user_id=?", userId).
+		Order("name ASC").
+		Scan(ctx, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.ClickerConfig, len(configs))
+	for i, c := range configs {
+		mapped[i] = c.ToDomain()
+	}
+	return mapped, nil
+}
+
+func (s *ClickerConfigStore) ByName(ctx context.Context, userId buzza.UserId, name string) (buzza.ClickerConfig, error) {
+	config, err := selectClickerConfig(ctx, s.DB, userId, name, false)
+	if err != nil {
+		return buzza.ClickerConfig{}, err
+	}
+	return config.ToDomain(), nil
+}
+
+func (s *ClickerConfigStore) Save(ctx context.Context, userId buzza.UserId, name string,
+	data json.RawMessage, expectedRevision int64) (buzza.ClickerConfig, error) {
+	var saved *ClickerConfig
+	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		saved, err = saveClickerConfigRevision(ctx, tx, userId, name, string(data), expectedRevision)
+		return err
+	})
+	if err != nil {
+		return buzza.ClickerConfig{}, err
+	}
+	return saved.ToDomain(), nil
+}
+
+func (s *ClickerConfigStore) History(ctx context.Context, userId buzza.UserId, name string) ([]buzza.ClickerConfigRevision, error) {
+	config, err := selectClickerConfig(ctx, s.DB, userId, name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []ClickerConfigRevision
+	err = s.DB.NewSelect().
+		Model((*ClickerConfigRevision)(nil)).
+		Where("config_id=?", config.Id).
+		Order("revision DESC").
+		Limit(buzza.ClickerConfigHistoryLimit).
+		Scan(ctx, &revisions)
+	if err != nil {
+		return nil, fmt.Errorf("query revisions: %w", err)
+	}
+
+	mapped := make([]buzza.ClickerConfigRevision, len(revisions))
+	for i, r := range revisions {
+		mapped[i] = r.ToDomain()
+	}
+	return mapped, nil
+}
+
+func (s *ClickerConfigStore) Restore(ctx context.Context, userId buzza.UserId, name string,
+	revision int64, expectedRevision int64) (buzza.ClickerConfig, error) {
+	var saved *ClickerConfig
+	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		config, err := selectClickerConfig(ctx, tx, userId, name, false)
+		if err != nil {
+			return err
+		}
+
+		restored := new(ClickerConfigRevision)
+		err = tx.NewSelect().
+			Model(restored).
+			Where("config_id=? AND revision=?", config.Id, revision).
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return buzza.ErrClickerConfigRevisionNotFound
+			}
+			return fmt.Errorf("select revision: %w", err)
+		}
+
+		saved, err = saveClickerConfigRevision(ctx, tx, userId, name, restored.Data, expectedRevision)
+		return err
+	})
+	if err != nil {
+		return buzza.ClickerConfig{}, err
+	}
+	return saved.ToDomain(), nil
+}
+
+func (s *ClickerConfigStore) Delete(ctx context.Context, userId buzza.UserId, name string, expectedRevision int64) error {
+	return s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		config, err := selectClickerConfig(ctx, tx, userId, name, true)
+		if err != nil {
+			return err
+		}
+		if expectedRevision != buzza.AnyClickerConfigRevision && expectedRevision != config.Revision {
+			return buzza.ErrClickerConfigConflict
+		}
+
+		_, err = tx.NewDelete().
+			Model((*ClickerConfigRevision)(nil)).
+			Where("config_id=?", config.Id).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete revisions: %w", err)
+		}
+		_, err = tx.NewDelete().
+			Model(config).
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete config: %w", err)
+		}
+		return nil
+	})
+}
+
+func selectClickerConfig(ctx context.Context, db bun.IDB, userId buzza.UserId, name string, forUpdate bool) (*ClickerConfig, error) {
+	config := new(ClickerConfig)
+	q := db.NewSelect().
+		Model(config).
+		Where("user_id=? AND name=?", userId, name)
+	if forUpdate {
+		q = q.For("UPDATE")
+	}
+	if err := q.Scan(ctx); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, buzza.ErrClickerConfigNotFound
+		}
+		return nil, fmt.Errorf("select config: %w", err)
+	}
+	return config, nil
+}
+
+// Must be called in transaction.
+func saveClickerConfigRevision(ctx context.Context, tx bun.Tx, userId buzza.UserId, name string,
+	data string, expectedRevision int64) (*ClickerConfig, error) {
+	config, err := selectClickerConfig(ctx, tx, userId, name, true)
+	switch {
+	case errors.Is(err, buzza.ErrClickerConfigNotFound):
+		if expectedRevision > 0 {
+			return nil, buzza.ErrClickerConfigConflict
+		}
+		config = &ClickerConfig{UserId: int64(userId), Name: name, Revision: 1, Data: data}
+		res, err := tx.NewInsert().
+			Model(config).
+			On("CONFLICT (user_id, name) DO NOTHING").
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("insert config: %w", err)
+		}
+		// created concurrently by other device
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			return nil, buzza.ErrClickerConfigConflict
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if expectedRevision != buzza.AnyClickerConfigRevision && expectedRevision != config.Revision {
+			return nil, buzza.ErrClickerConfigConflict
+		}
+		config.Revision++
+		config.Data = data
+		config.UpdatedAt = time.Now().UTC()
+		_, err = tx.NewUpdate().
+			Model(config).
+			Column("revision", "data", "updated_at").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("update config: %w", err)
+		}
+	}
+
+	_, err = tx.NewInsert().
+		Model(&ClickerConfigRevision{ConfigId: config.Id, Revision: config.Revision, Data: data}).
+		Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("insert revision: %w", err)
+	}
+	_, err = tx.NewDelete().
+		Model((*ClickerConfigRevision)(nil)).
+		Where("config_id=? AND revision<=?", config.Id, config.Revision-buzza.ClickerConfigHistoryLimit).
+		Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("prune history: %w", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,72 @@
+package persistent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClickerConfigStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	store := &ClickerConfigStore{DB: db}
+	const uid = buzza.UserId(4012)
+
+	_, err := store.ByName(ctx, uid, "pvp")
+	assert.ErrorIs(err, buzza.ErrClickerConfigNotFound)
+	_, err = store.Save(ctx, uid, "pvp", json.RawMessage(`{"cps":1}`), 3)
+	assert.ErrorIs(err, buzza.ErrClickerConfigConflict)
+
+	config, err := store.Save(ctx, uid, "pvp", json.RawMessage(`{"cps":1}`), 0)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(int64(1), config.Revision)
+	_, err = store.Save(ctx, uid, "pvp", json.RawMessage(`{"cps":2}`), 0)
+	assert.ErrorIs(err, buzza.ErrClickerConfigConflict)
+
+	for i := 2; i <= buzza.ClickerConfigHistoryLimit+5; i++ {
+		config, err = store.Save(ctx, uid, "pvp", json.RawMessage(fmt.Sprintf(`{"cps": %d}`, i)), config.Revision)
+		if !assert.NoError(err) {
+			return
+		}
+	}
+	assert.Equal(int64(buzza.ClickerConfigHistoryLimit+5), config.Revision)
+
+	history, err := store.History(ctx, uid, "pvp")
+	if !assert.NoError(err) || !assert.Len(history, buzza.ClickerConfigHistoryLimit) {
+		return
+	}
+	assert.Equal(config.Revision, history[0].Revision)
+	oldest := history[len(history)-1]
+
+	restored, err := store.Restore(ctx, uid, "pvp", oldest.Revision, config.Revision)
+	if assert.NoError(err) {
+		assert.Equal(config.Revision+1, restored.Revision)
+		assert.JSONEq(string(oldest.Data), string(restored.Data))
+	}
+	_, err = store.Restore(ctx, uid, "pvp", 1, restored.Revision)
+	assert.ErrorIs(err, buzza.ErrClickerConfigRevisionNotFound)
+
+	configs, err := store.ByUserId(ctx, uid)
+	if assert.NoError(err) && assert.Len(configs, 1) {
+		assert.Equal("pvp", configs[0].Name)
+	}
+
+	assert.ErrorIs(store.Delete(ctx, uid, "pvp", 1), buzza.ErrClickerConfigConflict)
+	assert.NoError(store.Delete(ctx, uid, "pvp", restored.Revision))
+	_, err = store.ByName(ctx, uid, "pvp")
+	assert.ErrorIs(err, buzza.ErrClickerConfigNotFound)
+}
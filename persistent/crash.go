@@ -0,0 +1,269 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+// Max number of stored report samples per crash group.
+const crashSamplesPerGroup = 10
+
+type CrashGroup struct {
+	bun.BaseModel `bun:"table:crash_group"`
+
+	Id             int64     `bun:",pk,autoincrement"`
+	Signature      string    `bun:",notnull,unique:signature_version,type:char(64)"`
+	ProgramVersion string    `bun:",notnull,unique:signature_version,type:varchar(64)"`
+	Module         string    `bun:",notnull"`
+	Count          int64     `bun:",notnull"`
+	FirstSeenAt    time.Time `bun:",notnull"`
+	LastSeenAt     time.Time `bun:",notnull"`
+	StackTrace     string    `bun:",notnull"`
+}
+
+func (g CrashGroup) ToDomain() buzza.CrashGroup {
+	return buzza.CrashGroup{
+		Signature:      g.Signature,
+		Module:         g.Module,
+		ProgramVersion: g.ProgramVersion,
+		Count:          g.Count,
+		FirstSeenAt:    g.FirstSeenAt,
+		LastSeenAt:     g.LastSeenAt,
+		StackTrace:     g.StackTrace,
+	}
+}
+
+type CrashReport struct {
+	bun.BaseModel `bun:"table:crash_report"`
+
+	Id             int64     `bun:",pk,autoincrement"`
+	CreatedAt      time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	GroupId        int64     `bun:",notnull"`
+	Signature      string    `bun:",notnull,type:char(64)"`
+	UserId         int64     `bun:",nullzero"`
+	Module         string    `bun:",notnull"`
+	ProgramVersion string    `bun:",notnull"`
+	Branch         string    `bun:",notnull"`
+	OS             string    `bun:"os,notnull"`
+	Arch           string    `bun:",notnull"`
+	StackTrace     string    `bun:",notnull"`
+	MinidumpKey    string    `bun:",notnull"`
+}
+
+func (r CrashReport) ToDomain() buzza.CrashReport {
+	return buzza.CrashReport{
+		Id:             r.Id,
+		Signature:      r.Signature,
+		UserId:         buzza.UserId(r.UserId),
+		Module:         r.Module,
+		ProgramVersion: r.ProgramVersion,
+		Branch:         r.Branch,
+		OS:             r.OS,
+		Arch:           r.Arch,
+		StackTrace:     r.StackTrace,
+		MinidumpKey:    r.MinidumpKey,
+		CreatedAt:      r.CreatedAt,
+	}
+}
+
+type CrashStore struct {
+	DB *bun.DB
+}
+
+var _ buzza.CrashStore = (*CrashStore)(nil)
+
+func (s *CrashStore) Add(ctx context.Context, report buzza.CrashReport) (buzza.CrashReport, bool, error) {
+	stored := &CrashReport{
+		Signature:      report.Signature,
+		UserId:         int64(report.UserId),
+		Module:         report.Module,
+		ProgramVersion: report.ProgramVersion,
+		Branch:         report.Branch,
+		OS:             report.OS,
+		Arch:           report.Arch,
+		StackTrace:     report.StackTrace,
+		MinidumpKey:    report.MinidumpKey,
+	}
+	sampled := false
+	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		now := time.Now().UTC()
+		group := &CrashGroup{
+			Signature:      report.Signature,
+			ProgramVersion: report.ProgramVersion,
+			Module:         report.Module,
+			Count:          1,
+			FirstSeenAt:    now,
+			LastSeenAt:     now,
+			StackTrace:     report.StackTrace,
+		}
+		_, err := tx.NewInsert().
+			Model(group).
+			On("CONFLICT (signature, program_version) DO UPDATE").
+			Set("count=crash_group.count+1").
+			Set("last_seen_at=EXCLUDED.last_seen_at").
+			Returning("id, count").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("upsert crash group: %w", err)
+		}
+		if group.Count > crashSamplesPerGroup {
+			return nil
+		}
+
+		sampled = true
+		stored.GroupId = group.Id
+		_, err = tx.NewInsert().
+			Model(stored).
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("insert crash report: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return buzza.CrashReport{}, false, err
+	}
+	if !sampled {
+		return report, false, nil
+	}
+	return stored.ToDomain(), true, nil
+}
+
+func (s *CrashStore) Groups(ctx context.Context, query buzza.CrashGroupQuery) ([]buzza.CrashGroup, error) {
+	var groups []CrashGroup
+	q := s.DB.NewSelect().
+		Model(&groups)
+	if query.ProgramVersion != "" {
+		q = q.Where("program_version=?", query.ProgramVersion)
+	}
+	err := q.
+		OrderExpr("count DESC, id DESC").
+		Offset(query.Offset).
+		Limit(query.Limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.CrashGroup, len(groups))
+	for i, g := range groups {
+		mapped[i] = g.ToDomain()
+	}
+	return mapped, nil
+}
+
+func (s *CrashStore) BySignature(ctx context.Context, signature string, offset int, limit int) ([]buzza.CrashReport, error) {
+	var reports []CrashReport
+	err := s.DB.NewSelect().
+		Model(&reports).
+		Where("signature=?", signature).
+		OrderExpr("id DESC").
+		Offset(offset).
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.CrashReport, len(reports))
+	for i, r := range reports {
+		mapped[i] = r.ToDomain()
+	}
+	return mapped, nil
+}
+
+func (s *CrashStore) ById(ctx context.Context, id int64) (buzza.CrashReport, error) {
+	report := new(CrashReport)
+	err := s.DB.NewSelect().
+		Model(report).
+		Where("id=?", id).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.CrashReport{}, buzza.ErrCrashReportNotFound
+		}
+		return buzza.CrashReport{}, fmt.Errorf("select crash report: %w", err)
+	}
+	return report.ToDomain(), nil
+}
+
+func (s *CrashStore) DetachMinidump(ctx context.Context, id int64) error {
+	_, err := s.DB.NewUpdate().
+		Model((*CrashReport)(nil)).
+		Set("minidump_key=''").
+		Where("id=?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("update crash report: %w", err)
+	}
+	return nil
+}
+
+// Stores attachments as files in the directory.
+type FileAttachmentStorage struct {
+	Dir string
+}
+
+var _ buzza.AttachmentStorage = (*FileAttachmentStorage)(nil)
+
+func (s *FileAttachmentStorage) Put(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("create attachment dir: %w", err)
+	}
+
+	// write to temp file first, so readers never see partially written attachment
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".attachment-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write attachment: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileAttachmentStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, buzza.ErrAttachmentNotFound
+		}
+		return nil, fmt.Errorf("open attachment: %w", err)
+	}
+	return f, nil
+}
+
+func (s *FileAttachmentStorage) path(key string) (string, error) {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(s.Dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid attachment key '%s'", key)
+	}
+	return path, nil
+}
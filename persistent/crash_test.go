@@ -0,0 +1,101 @@
+package persistent
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrashStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	store := &CrashStore{DB: db}
+	report := buzza.CrashReport{
+		Signature:      buzza.CrashSignature("clicker", "at Clicker::Tick"),
+		UserId:         5,
+		Module:         "clicker",
+		ProgramVersion: "1.2.0",
+		OS:             "windows",
+		Arch:           "amd64",
+		StackTrace:     "at Clicker::Tick",
+		MinidumpKey:    "minidumps/a.dmp",
+	}
+	for i := 0; i < crashSamplesPerGroup+2; i++ {
+		stored, sampled, err := store.Add(ctx, report)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(i < crashSamplesPerGroup, sampled, i)
+		if sampled {
+			assert.NotZero(stored.Id)
+		}
+	}
+	other := report
+	other.ProgramVersion = "1.3.0"
+	if _, sampled, err := store.Add(ctx, other); assert.NoError(err) {
+		assert.True(sampled, "new release should be a separate group")
+	}
+
+	groups, err := store.Groups(ctx, buzza.CrashGroupQuery{Limit: 10})
+	if assert.NoError(err) && assert.Len(groups, 2) {
+		assert.Equal("1.2.0", groups[0].ProgramVersion)
+		assert.Equal(int64(crashSamplesPerGroup+2), groups[0].Count)
+		assert.Equal(int64(1), groups[1].Count)
+	}
+	groups, err = store.Groups(ctx, buzza.CrashGroupQuery{ProgramVersion: "1.3.0", Limit: 10})
+	if assert.NoError(err) {
+		assert.Len(groups, 1)
+	}
+
+	reports, err := store.BySignature(ctx, report.Signature, 0, 100)
+	if assert.NoError(err) && assert.Len(reports, crashSamplesPerGroup+1) {
+		byId, err := store.ById(ctx, reports[0].Id)
+		if assert.NoError(err) {
+			assert.Equal(reports[0], byId)
+			assert.Equal(buzza.UserId(5), byId.UserId)
+		}
+
+		err = store.DetachMinidump(ctx, reports[0].Id)
+		if assert.NoError(err) {
+			byId, err := store.ById(ctx, reports[0].Id)
+			if assert.NoError(err) {
+				assert.Equal("", byId.MinidumpKey)
+			}
+		}
+	}
+	_, err = store.ById(ctx, -1)
+	assert.ErrorIs(err, buzza.ErrCrashReportNotFound)
+}
+
+func TestFileAttachmentStorage(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	storage := &FileAttachmentStorage{Dir: t.TempDir()}
+	if !assert.NoError(storage.Put(ctx, "minidumps/abc/1.dmp", strings.NewReader("MDMP"))) {
+		return
+	}
+	f, err := storage.Open(ctx, "minidumps/abc/1.dmp")
+	if assert.NoError(err) {
+		content, err := ioutil.ReadAll(f)
+		f.Close()
+		assert.NoError(err)
+		assert.Equal("MDMP", string(content))
+	}
+
+	_, err = storage.Open(ctx, "minidumps/abc/2.dmp")
+	assert.ErrorIs(err, buzza.ErrAttachmentNotFound)
+	assert.Error(storage.Put(ctx, "../escape.dmp", strings.NewReader("MDMP")))
+}
@@ -0,0 +1,225 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+type FeatureFlag struct {
+	bun.BaseModel `bun:"table:feature_flag"`
+
+	Key          string    `bun:",pk,type:varchar(64)"`
+	Description  string    `bun:",notnull"`
+	Enabled      bool      `bun:",notnull"`
+	Value        string    `bun:",notnull,type:jsonb"`
+	DefaultValue string    `bun:",notnull,type:jsonb"`
+	Targeting    Targeting `bun:",notnull,type:jsonb"`
+	Percentage   int       `bun:",notnull"`
+	UpdatedAt    time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+func newFeatureFlag(flag buzza.FeatureFlag) *FeatureFlag {
+	return &FeatureFlag{
+		Key:          flag.Key,
+		Description:  flag.Description,
+		Enabled:      flag.Enabled,
+		Value:        string(flag.Value),
+		DefaultValue: string(flag.DefaultValue),
+		Targeting:    newTargeting(flag.Targeting),
+		Percentage:   flag.Percentage,
+		UpdatedAt:    flag.UpdatedAt,
+	}
+}
+
+func (f FeatureFlag) ToDomain() buzza.FeatureFlag {
+	return buzza.FeatureFlag{
+		Key:          f.Key,
+		Description:  f.Description,
+		Enabled:      f.Enabled,
+		Value:        json.RawMessage(f.Value),
+		DefaultValue: json.RawMessage(f.DefaultValue),
+		Targeting:    f.Targeting.ToDomain(),
+		Percentage:   f.Percentage,
+		UpdatedAt:    f.UpdatedAt,
+	}
+}
+
+// Flag state stored in the change history.
+type featureFlagSnapshot struct {
+	Description  string          `json:"description"`
+	Enabled      bool            `json:"enabled"`
+	Value        json.RawMessage `json:"value"`
+	DefaultValue json.RawMessage `json:"defaultValue"`
+	Targeting    Targeting       `json:"targeting"`
+	Percentage   int             `json:"percentage"`
+}
+
+type FeatureFlagChange struct {
+	bun.BaseModel `bun:"table:feature_flag_change"`
+
+	Id        int64               `bun:",pk,autoincrement"`
+	CreatedAt time.Time           `bun:",nullzero,notnull,default:current_timestamp"`
+	Key       string              `bun:",notnull,type:varchar(64)"`
+	AuthorId  int64               `bun:",notnull"`
+	Action    string              `bun:",notnull"`
+	Flag      featureFlagSnapshot `bun:",notnull,type:jsonb"`
+}
+
+func newFeatureFlagChange(flag *FeatureFlag, authorId buzza.UserId, action buzza.FeatureFlagAction) *FeatureFlagChange {
+	return &FeatureFlagChange{
+		Key:      flag.Key,
+		AuthorId: int64(authorId),
+		Action:   string(action),
+		Flag: featureFlagSnapshot{
+			Description:  flag.Description,
+			Enabled:      flag.Enabled,
+			Value:        json.RawMessage(flag.Value),
+			DefaultValue: json.RawMessage(flag.DefaultValue),
+			Targeting:    flag.Targeting,
+			Percentage:   flag.Percentage,
+		},
+	}
+}
+
+func (c FeatureFlagChange) ToDomain() buzza.FeatureFlagChange {
+	return buzza.FeatureFlagChange{
+		Id:       c.Id,
+		Key:      c.Key,
+		AuthorId: buzza.UserId(c.AuthorId),
+		Action:   buzza.FeatureFlagAction(c.Action),
+		Flag: buzza.FeatureFlag{
+			Key:          c.Key,
+			Description:  c.Flag.Description,
+			Enabled:      c.Flag.Enabled,
+			Value:        c.Flag.Value,
+			DefaultValue: c.Flag.DefaultValue,
+			Targeting:    c.Flag.Targeting.ToDomain(),
+			Percentage:   c.Flag.Percentage,
+			UpdatedAt:    c.CreatedAt,
+		},
+		CreatedAt: c.CreatedAt,
+	}
+}
+
+type FeatureFlagStore struct {
+	DB *bun.DB
+}
+
+var _ buzza.FeatureFlagStore = (*FeatureFlagStore)(nil)
+
+func (s *FeatureFlagStore) All(ctx context.Context) ([]buzza.FeatureFlag, error) {
+	var flags []FeatureFlag
+	err := s.DB.NewSelect().
+		Model(&flags).
+		Order("key ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.FeatureFlag, len(flags))
+	for i, f := range flags {
+		mapped[i] = f.ToDomain()
+	}
+	return mapped, nil
+}
+
+func (s *FeatureFlagStore) ByKey(ctx context.Context, key string) (buzza.FeatureFlag, error) {
+	flag := new(FeatureFlag)
+	err := s.DB.NewSelect().
+		Model(flag).
+		Where("key=?", key).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.FeatureFlag{}, buzza.ErrFeatureFlagNotFound
+		}
+		return buzza.FeatureFlag{}, fmt.Errorf("select feature flag: %w", err)
+	}
+	return flag.ToDomain(), nil
+}
+
+func (s *FeatureFlagStore) Save(ctx context.Context, flag buzza.FeatureFlag, authorId buzza.UserId) (buzza.FeatureFlag, error) {
+	stored := newFeatureFlag(flag)
+	stored.UpdatedAt = time.Now().UTC()
+	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewInsert().
+			Model(stored).
+			On("CONFLICT (key) DO UPDATE").
+			Set("description=EXCLUDED.description").
+			Set("enabled=EXCLUDED.enabled").
+			Set("value=EXCLUDED.value").
+			Set("default_value=EXCLUDED.default_value").
+			Set("targeting=EXCLUDED.targeting").
+			Set("percentage=EXCLUDED.percentage").
+			Set("updated_at=EXCLUDED.updated_at").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("upsert feature flag: %w", err)
+		}
+
+		_, err = tx.NewInsert().
+			Model(newFeatureFlagChange(stored, authorId, buzza.FeatureFlagActionSave)).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("insert feature flag change: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return buzza.FeatureFlag{}, err
+	}
+	return stored.ToDomain(), nil
+}
+
+func (s *FeatureFlagStore) Delete(ctx context.Context, key string, authorId buzza.UserId) error {
+	return s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		deleted := new(FeatureFlag)
+		res, err := tx.NewDelete().
+			Model(deleted).
+			Where("key=?", key).
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete feature flag: %w", err)
+		}
+		if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+			return buzza.ErrFeatureFlagNotFound
+		}
+
+		_, err = tx.NewInsert().
+			Model(newFeatureFlagChange(deleted, authorId, buzza.FeatureFlagActionDelete)).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("insert feature flag change: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *FeatureFlagStore) History(ctx context.Context, key string, offset int, limit int) ([]buzza.FeatureFlagChange, error) {
+	var changes []FeatureFlagChange
+	err := s.DB.NewSelect().
+		Model(&changes).
+		Where("key=?", key).
+		OrderExpr("id DESC").
+		Offset(offset).
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.FeatureFlagChange, len(changes))
+	for i, c := range changes {
+		mapped[i] = c.ToDomain()
+	}
+	return mapped, nil
+}
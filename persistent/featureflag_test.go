@@ -0,0 +1,63 @@
+package persistent
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureFlagStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	store := &FeatureFlagStore{DB: db}
+	flag := buzza.FeatureFlag{
+		Key:          "max-cps",
+		Enabled:      true,
+		Value:        json.RawMessage(`20`),
+		DefaultValue: json.RawMessage(`16`),
+		Targeting:    buzza.Targeting{Roles: []buzza.RoleId{buzza.RoleIdPro}, MinVersion: "1.2"},
+		Percentage:   100,
+	}
+	if _, err := store.Save(ctx, flag, 1); !assert.NoError(err) {
+		return
+	}
+	flag.Percentage = 50
+	if _, err := store.Save(ctx, flag, 2); !assert.NoError(err) {
+		return
+	}
+
+	stored, err := store.ByKey(ctx, "max-cps")
+	if assert.NoError(err) {
+		assert.Equal(50, stored.Percentage)
+		assert.Equal(flag.Targeting, stored.Targeting)
+		assert.JSONEq("20", string(stored.Value))
+	}
+	flags, err := store.All(ctx)
+	if assert.NoError(err) {
+		assert.Len(flags, 1)
+	}
+
+	assert.NoError(store.Delete(ctx, "max-cps", 1))
+	assert.ErrorIs(store.Delete(ctx, "max-cps", 1), buzza.ErrFeatureFlagNotFound)
+	_, err = store.ByKey(ctx, "max-cps")
+	assert.ErrorIs(err, buzza.ErrFeatureFlagNotFound)
+
+	history, err := store.History(ctx, "max-cps", 0, 10)
+	if assert.NoError(err) && assert.Len(history, 3) {
+		assert.Equal(buzza.FeatureFlagActionDelete, history[0].Action)
+		assert.Equal(50, history[0].Flag.Percentage)
+		assert.Equal(buzza.UserId(2), history[1].AuthorId)
+		assert.Equal(100, history[2].Flag.Percentage)
+	}
+}
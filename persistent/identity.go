@@ -0,0 +1,354 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+type UserIdentity struct {
+	bun.BaseModel `bun:"table:user_identity"`
+
+	Provider     string    `bun:",pk,type:varchar(16),unique:user_identity_user_provider"`
+	Subject      string    `bun:",pk"`
+	UserId       int64     `bun:",notnull,unique:user_identity_user_provider"`
+	Email        string    `bun:",notnull"`
+	RefreshToken string    `bun:",notnull"`
+	LinkedAt     time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+// External account once linked to the user, kept so the account can not refer the user after unlinking.
+type UnlinkedIdentity struct {
+	bun.BaseModel `bun:"table:unlinked_identity"`
+
+	Provider   string    `bun:",pk,type:varchar(16)"`
+	Subject    string    `bun:",pk"`
+	UserId     int64     `bun:",pk"`
+	UnlinkedAt time.Time `bun:",notnull"`
+}
+
+func (i UserIdentity) ToDomain() buzza.UserIdentity {
+	return buzza.UserIdentity{
+		UserId:       buzza.UserId(i.UserId),
+		Provider:     i.Provider,
+		Subject:      i.Subject,
+		Email:        buzza.Email(i.Email),
+		RefreshToken: i.RefreshToken,
+		LinkedAt:     i.LinkedAt,
+	}
+}
+
+const (
+	identityEmailLabel        = "user_identity.email"
+	identityRefreshTokenLabel = "user_identity.refresh_token"
+)
+
+// Copy of the identity with sensitive columns sealed.
+func (s *UserStore) sealedIdentity(identity UserIdentity) (*UserIdentity, error) {
+	var err error
+	identity.Email, err = s.seal(identityEmailLabel, identity.Email)
+	if err != nil {
+		return nil, fmt.Errorf("seal identity email: %w", err)
+	}
+	identity.RefreshToken, err = s.seal(identityRefreshTokenLabel, identity.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("seal identity refresh token: %w", err)
+	}
+	return &identity, nil
+}
+
+// Decrypt sensitive columns in place.
+func (s *UserStore) openIdentity(identity *UserIdentity) error {
+	var err error
+	identity.Email, err = s.unseal(identityEmailLabel, identity.Email)
+	if err != nil {
+		return fmt.Errorf("open identity email: %w", err)
+	}
+	identity.RefreshToken, err = s.unseal(identityRefreshTokenLabel, identity.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("open identity refresh token: %w", err)
+	}
+	return nil
+}
+
+func (s *UserStore) Identities(ctx context.Context, userId buzza.UserId) ([]buzza.UserIdentity, error) {
+	var rows []UserIdentity
+	err := s.DB.NewSelect().
+		Model(&rows).
+		Where("user_id=?", userId).
+		Order("linked_at ASC", "provider ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("select identities: %w", err)
+	}
+	identities := make([]buzza.UserIdentity, len(rows))
+	for i := range rows {
+		if err := s.openIdentity(&rows[i]); err != nil {
+			return nil, err
+		}
+		identities[i] = rows[i].ToDomain()
+	}
+	return identities, nil
+}
+
+func (s *UserStore) LinkIdentity(ctx context.Context, userId buzza.UserId, identity buzza.ExternalIdentity) (buzza.UserIdentity, error) {
+	plain := UserIdentity{
+		Provider:     identity.Provider,
+		Subject:      identity.Subject,
+		UserId:       int64(userId),
+		Email:        string(identity.Email),
+		RefreshToken: identity.RefreshToken,
+	}
+	sealed, err := s.sealedIdentity(plain)
+	if err != nil {
+		return buzza.UserIdentity{}, err
+	}
+	err = s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		linked := new(UserIdentity)
+		err := tx.NewSelect().
+			Model(linked).
+			Where("provider=? AND subject=?", identity.Provider, identity.Subject).
+			For("UPDATE").
+			Scan(ctx)
+		if err == nil {
+			if linked.UserId != int64(userId) {
+				return buzza.ErrIdentityLinked
+			}
+			_, err = tx.NewUpdate().
+				Model(sealed).
+				Column("email", "refresh_token").
+				WherePK().
+				Returning("linked_at").
+				Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("update identity: %w", err)
+			}
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("select identity: %w", err)
+		}
+
+		res, err := tx.NewInsert().
+			Model(sealed).
+			On("CONFLICT DO NOTHING").
+			Returning("linked_at").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("insert identity: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("rows affected: %w", err)
+		}
+		if affected == 0 {
+			// either the user has another account of the provider or this one has just been linked
+			providerLinked, err := tx.NewSelect().
+				Model((*UserIdentity)(nil)).
+				Where("user_id=? AND provider=?", userId, identity.Provider).
+				Exists(ctx)
+			if err != nil {
+				return fmt.Errorf("check provider linked: %w", err)
+			}
+			if providerLinked {
+				return buzza.ErrProviderLinked
+			}
+			return buzza.ErrIdentityLinked
+		}
+		return nil
+	})
+	if err != nil {
+		return buzza.UserIdentity{}, err
+	}
+	plain.LinkedAt = sealed.LinkedAt
+	return plain.ToDomain(), nil
+}
+
+func (s *UserStore) UnlinkIdentity(ctx context.Context, userId buzza.UserId, provider string) (buzza.UserIdentity, error) {
+	var unlinked UserIdentity
+	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		// locks all identities of the user, so concurrent unlinks can not remove the last one
+		var identities []UserIdentity
+		err := tx.NewSelect().
+			Model(&identities).
+			Where("user_id=?", userId).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("select identities: %w", err)
+		}
+		found := false
+		for _, identity := range identities {
+			if identity.Provider == provider {
+				unlinked = identity
+				found = true
+			}
+		}
+		if !found {
+			return buzza.ErrIdentityNotFound
+		}
+		if len(identities) == 1 {
+			return buzza.ErrLastIdentity
+		}
+		_, err = tx.NewDelete().
+			Model(&unlinked).
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("delete identity: %w", err)
+		}
+		_, err = tx.NewInsert().
+			Model(&UnlinkedIdentity{Provider: unlinked.Provider, Subject: unlinked.Subject,
+				UserId: unlinked.UserId, UnlinkedAt: time.Now().UTC()}).
+			On("CONFLICT (provider, subject, user_id) DO UPDATE").
+			Set("unlinked_at=EXCLUDED.unlinked_at").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("insert unlinked identity: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return buzza.UserIdentity{}, err
+	}
+	if err := s.openIdentity(&unlinked); err != nil {
+		return buzza.UserIdentity{}, err
+	}
+	return unlinked.ToDomain(), nil
+}
+
+func (s *UserStore) reencryptIdentities(ctx context.Context, batchSize int) (int, error) {
+	currentKeyId := s.Keyring.CurrentKeyId()
+	reencrypted := 0
+	lastProvider, lastSubject := "", ""
+	for {
+		var identities []UserIdentity
+		err := s.DB.NewSelect().
+			Model(&identities).
+			Column("provider", "subject", "email", "refresh_token").
+			Where("(provider, subject) > (?, ?)", lastProvider, lastSubject).
+			Order("provider ASC", "subject ASC").
+			Limit(batchSize).
+			Scan(ctx)
+		if err != nil {
+			return reencrypted, fmt.Errorf("select identities: %w", err)
+		}
+		if len(identities) == 0 {
+			return reencrypted, nil
+		}
+		last := identities[len(identities)-1]
+		lastProvider, lastSubject = last.Provider, last.Subject
+
+		for _, stored := range identities {
+			if buzza.SealedValueKeyId(stored.Email) == currentKeyId &&
+				buzza.SealedValueKeyId(stored.RefreshToken) == currentKeyId {
+				continue
+			}
+			opened := stored
+			if err := s.openIdentity(&opened); err != nil {
+				return reencrypted, fmt.Errorf("identity %s/%s: %w", stored.Provider, stored.Subject, err)
+			}
+			sealed, err := s.sealedIdentity(opened)
+			if err != nil {
+				return reencrypted, fmt.Errorf("identity %s/%s: %w", stored.Provider, stored.Subject, err)
+			}
+			res, err := s.DB.NewUpdate().
+				Model(sealed).
+				Column("email", "refresh_token").
+				WherePK().
+				Where("email=?", stored.Email).
+				Where("refresh_token=?", stored.RefreshToken).
+				Exec(ctx)
+			if err != nil {
+				return reencrypted, fmt.Errorf("update identity %s/%s: %w", stored.Provider, stored.Subject, err)
+			}
+			if affected, err := res.RowsAffected(); err == nil && affected > 0 {
+				reencrypted++
+			}
+		}
+	}
+}
+
+// Discord account columns of users created before identities were introduced.
+type legacyDiscordUser struct {
+	bun.BaseModel `bun:"table:user"`
+
+	Id                  int64
+	CreatedAt           time.Time
+	Email               string
+	DiscordId           string
+	DiscordRefreshToken string
+}
+
+// Move discord accounts from the legacy user columns into user_identity and drop the columns.
+// Does nothing if the columns are already gone. Returns number of migrated users.
+func MigrateDiscordIdentities(ctx context.Context, db *bun.DB, keyring buzza.Keyring) (int, error) {
+	store := &UserStore{DB: db, Keyring: keyring}
+	migrated := 0
+	err := db.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		legacyColumns, err := tx.NewSelect().
+			TableExpr("information_schema.columns").
+			Where("table_schema=current_schema() AND table_name='user' AND column_name='discord_id'").
+			Exists(ctx)
+		if err != nil {
+			return fmt.Errorf("check legacy columns: %w", err)
+		}
+		if !legacyColumns {
+			return nil
+		}
+
+		var users []legacyDiscordUser
+		err = tx.NewSelect().
+			Model(&users).
+			Where("discord_id IS NOT NULL").
+			Order("id ASC").
+			Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("select users: %w", err)
+		}
+		for _, user := range users {
+			email, err := store.unseal(userEmailLabel, user.Email)
+			if err != nil {
+				return fmt.Errorf("user %d: open email: %w", user.Id, err)
+			}
+			refreshToken, err := store.unseal(userDiscordRefreshTokenLabel, user.DiscordRefreshToken)
+			if err != nil {
+				return fmt.Errorf("user %d: open discord refresh token: %w", user.Id, err)
+			}
+			sealed, err := store.sealedIdentity(UserIdentity{
+				Provider:     buzza.ProviderDiscord,
+				Subject:      user.DiscordId,
+				UserId:       user.Id,
+				Email:        email,
+				RefreshToken: refreshToken,
+				LinkedAt:     user.CreatedAt,
+			})
+			if err != nil {
+				return fmt.Errorf("user %d: %w", user.Id, err)
+			}
+			res, err := tx.NewInsert().
+				Model(sealed).
+				On("CONFLICT DO NOTHING").
+				Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("user %d: insert identity: %w", user.Id, err)
+			}
+			if affected, err := res.RowsAffected(); err == nil && affected > 0 {
+				migrated++
+			}
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`ALTER TABLE "user" DROP COLUMN discord_id, DROP COLUMN discord_refresh_token`)
+		if err != nil {
+			return fmt.Errorf("drop legacy columns: %w", err)
+		}
+		return nil
+	})
+	return migrated, err
+}
@@ -0,0 +1,135 @@
+package persistent
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/sirupsen/logrus"
+)
+
+// Keyring stored in a local JSON file:
+//
+//	{"current": "2022-03", "keys": {"2022-01": "<base64>", "2022-03": "<base64>"}}
+//
+// To rotate keys add a new key and make it current. The keyring is reloaded by Watch, or by
+// Reload, and values are re-encrypted afterwards. Retired keys can be removed once nothing is sealed with them.
+type FileKeyring struct {
+	Path string
+
+	current string
+	keys    map[string][]byte
+	modTime time.Time
+	mutex   sync.RWMutex
+}
+
+var _ buzza.Keyring = (*FileKeyring)(nil)
+
+func OpenFileKeyring(path string) (*FileKeyring, error) {
+	keyring := &FileKeyring{Path: path}
+	if err := keyring.Reload(); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// Read keys from the file again. Previous keys are kept on error.
+func (k *FileKeyring) Reload() error {
+	info, err := os.Stat(k.Path)
+	if err != nil {
+		return fmt.Errorf("stat keyring: %w", err)
+	}
+	data, err := os.ReadFile(k.Path)
+	if err != nil {
+		return fmt.Errorf("read keyring: %w", err)
+	}
+	var file struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parse keyring: %w", err)
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		if id == "" || strings.Contains(id, ":") {
+			return fmt.Errorf("invalid key id '%s'", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("decode key '%s': %w", id, err)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("key '%s' has %d bytes, 32 required", id, len(key))
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[file.Current]; !ok {
+		return errors.New("current key is not in the keyring")
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.current = file.Current
+	k.keys = keys
+	k.modTime = info.ModTime()
+	return nil
+}
+
+func (k *FileKeyring) modified() bool {
+	info, err := os.Stat(k.Path)
+	if err != nil {
+		return false
+	}
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return !info.ModTime().Equal(k.modTime)
+}
+
+// Reload the keyring whenever its file changes and call optional reloaded, until context is done.
+func (k *FileKeyring) Watch(ctx context.Context, interval time.Duration, reloaded func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !k.modified() {
+			continue
+		}
+		if err := k.Reload(); err != nil {
+			logrus.WithError(err).Errorln("Could not reload keyring.")
+			continue
+		}
+		logrus.WithField("current", k.CurrentKeyId()).Infoln("Keyring reloaded.")
+		if reloaded != nil {
+			reloaded()
+		}
+	}
+}
+
+func (k *FileKeyring) CurrentKeyId() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.current
+}
+
+func (k *FileKeyring) Key(id string) ([]byte, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, buzza.ErrKeyNotFound
+	}
+	return key, nil
+}
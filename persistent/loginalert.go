@@ -0,0 +1,124 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+const (
+	loginSourceIp     = "ip"
+	loginSourceDevice = "device"
+)
+
+// IP address or device the user has logged in from.
+type LoginSource struct {
+	bun.BaseModel `bun:"table:login_source"`
+
+	UserId      int64     `bun:",pk"`
+	Kind        string    `bun:",pk,type:varchar(16)"`
+	Value       string    `bun:",pk"`
+	FirstSeenAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+type LoginSourceStore struct {
+	DB *bun.DB
+}
+
+var _ buzza.LoginSourceStore = (*LoginSourceStore)(nil)
+
+func (s *LoginSourceStore) Remember(ctx context.Context, userId buzza.UserId, ip string, device string) (buzza.LoginSourceCheck, error) {
+	var check buzza.LoginSourceCheck
+	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		known, err := tx.NewSelect().
+			Model((*LoginSource)(nil)).
+			Where("user_id=?", userId).
+			Exists(ctx)
+		if err != nil {
+			return fmt.Errorf("select known sources: %w", err)
+		}
+		check.First = !known
+
+		for _, source := range []struct {
+			kind   string
+			value  string
+			target *bool
+		}{{loginSourceIp, ip, &check.NewIp}, {loginSourceDevice, device, &check.NewDevice}} {
+			res, err := tx.NewInsert().
+				Model(&LoginSource{UserId: int64(userId), Kind: source.kind, Value: source.value}).
+				On("CONFLICT DO NOTHING").
+				Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("insert %s source: %w", source.kind, err)
+			}
+			affected, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("rows affected: %w", err)
+			}
+			*source.target = affected > 0
+		}
+		return nil
+	})
+	if err != nil {
+		return buzza.LoginSourceCheck{}, err
+	}
+	return check, nil
+}
+
+type NotificationSettings struct {
+	bun.BaseModel `bun:"table:notification_settings"`
+
+	UserId       int64 `bun:",pk"`
+	LoginEmail   bool  `bun:",notnull"`
+	LoginDiscord bool  `bun:",notnull"`
+}
+
+func (s NotificationSettings) ToDomain() buzza.NotificationSettings {
+	return buzza.NotificationSettings{
+		LoginEmail:   s.LoginEmail,
+		LoginDiscord: s.LoginDiscord,
+	}
+}
+
+type NotificationSettingsStore struct {
+	DB *bun.DB
+}
+
+var _ buzza.NotificationSettingsStore = (*NotificationSettingsStore)(nil)
+
+func (s *NotificationSettingsStore) ByUserId(ctx context.Context, userId buzza.UserId) (buzza.NotificationSettings, error) {
+	settings := new(NotificationSettings)
+	err := s.DB.NewSelect().
+		Model(settings).
+		Where("user_id=?", userId).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.DefaultNotificationSettings, nil
+		}
+		return buzza.NotificationSettings{}, fmt.Errorf("select notification settings: %w", err)
+	}
+	return settings.ToDomain(), nil
+}
+
+func (s *NotificationSettingsStore) Save(ctx context.Context, userId buzza.UserId, settings buzza.NotificationSettings) error {
+	_, err := s.DB.NewInsert().
+		Model(&NotificationSettings{
+			UserId:       int64(userId),
+			LoginEmail:   settings.LoginEmail,
+			LoginDiscord: settings.LoginDiscord,
+		}).
+		On("CONFLICT (user_id) DO UPDATE").
+		Set("login_email=EXCLUDED.login_email").
+		Set("login_discord=EXCLUDED.login_discord").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("upsert notification settings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package persistent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginSourceStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	store := &LoginSourceStore{DB: db}
+	const uid = buzza.UserId(731)
+	_, err := db.NewDelete().Model((*LoginSource)(nil)).Where("user_id=?", uid).Exec(ctx)
+	if !assert.NoError(err) {
+		return
+	}
+
+	cases := []struct {
+		ip     string
+		device string
+		check  buzza.LoginSourceCheck
+	}{
+		{"1.1.1.1", "Firefox", buzza.LoginSourceCheck{NewIp: true, NewDevice: true, First: true}},
+		{"1.1.1.1", "Firefox", buzza.LoginSourceCheck{}},
+		{"2.2.2.2", "Firefox", buzza.LoginSourceCheck{NewIp: true}},
+		{"2.2.2.2", "Chrome", buzza.LoginSourceCheck{NewDevice: true}},
+	}
+	for i, tc := range cases {
+		check, err := store.Remember(ctx, uid, tc.ip, tc.device)
+		if assert.NoError(err, i) {
+			assert.Equal(tc.check, check, i)
+		}
+	}
+}
+
+func TestNotificationSettingsStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	store := &NotificationSettingsStore{DB: db}
+	const uid = buzza.UserId(732)
+	_, err := db.NewDelete().Model((*NotificationSettings)(nil)).Where("user_id=?", uid).Exec(ctx)
+	if !assert.NoError(err) {
+		return
+	}
+
+	settings, err := store.ByUserId(ctx, uid)
+	if assert.NoError(err) {
+		assert.Equal(buzza.DefaultNotificationSettings, settings)
+	}
+	for _, expected := range []buzza.NotificationSettings{
+		{LoginEmail: false, LoginDiscord: true},
+		{LoginEmail: true, LoginDiscord: false},
+	} {
+		assert.NoError(store.Save(ctx, uid, expected))
+		settings, err := store.ByUserId(ctx, uid)
+		if assert.NoError(err) {
+			assert.Equal(expected, settings)
+		}
+	}
+}
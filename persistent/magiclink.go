@@ -0,0 +1,80 @@
+package persistent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+type MagicLink struct {
+	bun.BaseModel `bun:"table:magic_link"`
+
+	TokenHash string    `bun:",pk"`
+	UserId    int64     `bun:",notnull"`
+	CreatedAt time.Time `bun:",notnull"`
+	ExpiresAt time.Time `bun:",notnull"`
+	UsedAt    time.Time `bun:",nullzero"`
+}
+
+type MagicLinkStore struct {
+	DB *bun.DB
+}
+
+var _ buzza.MagicLinkStore = (*MagicLinkStore)(nil)
+
+func (s *MagicLinkStore) Add(ctx context.Context, link buzza.MagicLink) error {
+	_, err := s.DB.NewInsert().
+		Model(&MagicLink{
+			TokenHash: link.TokenHash,
+			UserId:    int64(link.UserId),
+			CreatedAt: link.CreatedAt.UTC(),
+			ExpiresAt: link.ExpiresAt.UTC(),
+		}).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("insert magic link: %w", err)
+	}
+	return nil
+}
+
+func (s *MagicLinkStore) Use(ctx context.Context, tokenHash string, now time.Time) (buzza.UserId, error) {
+	link := new(MagicLink)
+	res, err := s.DB.NewUpdate().
+		Model(link).
+		Set("used_at=?", now.UTC()).
+		Where("token_hash=?", tokenHash).
+		Where("used_at IS NULL").
+		Where("expires_at>?", now.UTC()).
+		Returning("user_id").
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("use magic link: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return 0, buzza.ErrInvalidMagicLink
+	}
+	return buzza.UserId(link.UserId), nil
+}
+
+// Remove expired links, returns number of removed rows.
+func (s *MagicLinkStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := s.DB.NewDelete().
+		Model((*MagicLink)(nil)).
+		Where("expires_at<=?", time.Now().UTC()).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired magic links: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,63 @@
+package persistent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMagicLinkStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	ctx := context.Background()
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	_, err := db.NewDelete().
+		Model((*MagicLink)(nil)).
+		Where("1=1").
+		Exec(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	store := &MagicLinkStore{DB: db}
+	storetest.RunMagicLinkStoreTests(t, store, 1)
+
+	deleted, err := store.DeleteExpired(ctx)
+	if assert.NoError(t, err) {
+		assert.EqualValues(t, 2, deleted, "test links are already expired")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	ctx := context.Background()
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	_, err := db.NewDelete().
+		Model((*RateLimit)(nil)).
+		Where("1=1").
+		Exec(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := &RateLimiter{DB: db, Now: func() time.Time { return now }}
+	storetest.RunRateLimiterTests(t, limiter, func(d time.Duration) {
+		now = now.Add(d)
+	})
+
+	deleted, err := limiter.DeleteExpired(ctx, now)
+	if assert.NoError(t, err) {
+		assert.EqualValues(t, 1, deleted, "only the key not hit in the current window")
+	}
+}
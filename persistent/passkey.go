@@ -0,0 +1,207 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+type Passkey struct {
+	bun.BaseModel `bun:"table:passkey"`
+
+	Id         string    `bun:",pk"`
+	UserId     int64     `bun:",notnull"`
+	Name       string    `bun:",notnull,type:varchar(64)"`
+	PublicKey  []byte    `bun:",notnull"`
+	SignCount  int64     `bun:",notnull"`
+	CreatedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	LastUsedAt time.Time `bun:",nullzero"`
+}
+
+func (p Passkey) ToDomain() buzza.Passkey {
+	return buzza.Passkey{
+		Id:         p.Id,
+		UserId:     buzza.UserId(p.UserId),
+		Name:       p.Name,
+		PublicKey:  p.PublicKey,
+		SignCount:  uint32(p.SignCount),
+		CreatedAt:  p.CreatedAt,
+		LastUsedAt: p.LastUsedAt,
+	}
+}
+
+type PasskeyStore struct {
+	DB *bun.DB
+}
+
+var _ buzza.PasskeyStore = (*PasskeyStore)(nil)
+
+func (s *PasskeyStore) ByUserId(ctx context.Context, userId buzza.UserId) ([]buzza.Passkey, error) {
+	var rows []Passkey
+	err := s.DB.NewSelect().
+		Model(&rows).
+		Where("user_id=?", userId).
+		Order("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("select passkeys: %w", err)
+	}
+	passkeys := make([]buzza.Passkey, len(rows))
+	for i, row := range rows {
+		passkeys[i] = row.ToDomain()
+	}
+	return passkeys, nil
+}
+
+func (s *PasskeyStore) ById(ctx context.Context, id string) (buzza.Passkey, error) {
+	passkey := new(Passkey)
+	err := s.DB.NewSelect().
+		Model(passkey).
+		Where("id=?", id).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.Passkey{}, buzza.ErrPasskeyNotFound
+		}
+		return buzza.Passkey{}, fmt.Errorf("select passkey: %w", err)
+	}
+	return passkey.ToDomain(), nil
+}
+
+func (s *PasskeyStore) Add(ctx context.Context, passkey buzza.Passkey) error {
+	res, err := s.DB.NewInsert().
+		Model(&Passkey{
+			Id:        passkey.Id,
+			UserId:    int64(passkey.UserId),
+			Name:      passkey.Name,
+			PublicKey: passkey.PublicKey,
+			SignCount: int64(passkey.SignCount),
+			CreatedAt: passkey.CreatedAt,
+		}).
+		On("CONFLICT DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("insert passkey: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return buzza.ErrPasskeyAlreadyRegistered
+	}
+	return nil
+}
+
+func (s *PasskeyStore) UseSignCount(ctx context.Context, id string, signCount uint32, usedAt time.Time) error {
+	// the counter condition makes concurrent uses of the same assertion fail
+	res, err := s.DB.NewUpdate().
+		Model((*Passkey)(nil)).
+		Set("sign_count=?", signCount).
+		Set("last_used_at=?", usedAt).
+		Where("id=?", id).
+		Where("(sign_count < ? OR (sign_count = 0 AND ? = 0))", signCount, signCount).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("update sign count: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		exists, err := s.DB.NewSelect().Model((*Passkey)(nil)).Where("id=?", id).Exists(ctx)
+		if err != nil {
+			return fmt.Errorf("check passkey existence: %w", err)
+		}
+		if !exists {
+			return buzza.ErrPasskeyNotFound
+		}
+		return buzza.ErrPasskeyReplayed
+	}
+	return nil
+}
+
+func (s *PasskeyStore) Delete(ctx context.Context, userId buzza.UserId, id string) (buzza.Passkey, error) {
+	passkey := new(Passkey)
+	res, err := s.DB.NewDelete().
+		Model(passkey).
+		Where("id=?", id).
+		Where("user_id=?", userId).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return buzza.Passkey{}, fmt.Errorf("delete passkey: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return buzza.Passkey{}, fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return buzza.Passkey{}, buzza.ErrPasskeyNotFound
+	}
+	return passkey.ToDomain(), nil
+}
+
+type PasskeyChallenge struct {
+	bun.BaseModel `bun:"table:passkey_challenge"`
+
+	ChallengeHash string    `bun:",pk"`
+	ExpiresAt     time.Time `bun:",notnull"`
+}
+
+type PasskeyChallengeStore struct {
+	DB *bun.DB
+}
+
+var _ buzza.PasskeyChallengeStore = (*PasskeyChallengeStore)(nil)
+
+func (s *PasskeyChallengeStore) Add(ctx context.Context, challengeHash string, expiresAt time.Time) error {
+	_, err := s.DB.NewInsert().
+		Model(&PasskeyChallenge{ChallengeHash: challengeHash, ExpiresAt: expiresAt.UTC()}).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("insert passkey challenge: %w", err)
+	}
+	return nil
+}
+
+func (s *PasskeyChallengeStore) Use(ctx context.Context, challengeHash string, now time.Time) error {
+	res, err := s.DB.NewDelete().
+		Model((*PasskeyChallenge)(nil)).
+		Where("challenge_hash=?", challengeHash).
+		Where("expires_at>?", now.UTC()).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("use passkey challenge: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return buzza.ErrInvalidPasskeyCeremony
+	}
+	return nil
+}
+
+// Remove expired challenges, returns number of removed rows.
+func (s *PasskeyChallengeStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := s.DB.NewDelete().
+		Model((*PasskeyChallenge)(nil)).
+		Where("expires_at<=?", time.Now().UTC()).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired passkey challenges: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return deleted, nil
+}
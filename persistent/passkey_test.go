@@ -0,0 +1,96 @@
+package persistent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasskeyStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	store := &PasskeyStore{DB: db}
+	const uid = buzza.UserId(751)
+	_, err := db.NewDelete().Model((*Passkey)(nil)).Where("user_id IN (?, ?)", uid, uid+1).Exec(ctx)
+	if !assert.NoError(err) {
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	first := buzza.Passkey{Id: "cred-1", UserId: uid, Name: "YubiKey", PublicKey: []byte{1, 2, 3},
+		SignCount: 5, CreatedAt: now.Add(-time.Hour)}
+	second := buzza.Passkey{Id: "cred-2", UserId: uid, Name: "Phone", PublicKey: []byte{4, 5, 6},
+		CreatedAt: now}
+	assert.NoError(store.Add(ctx, second))
+	assert.NoError(store.Add(ctx, first))
+	assert.ErrorIs(store.Add(ctx, buzza.Passkey{Id: "cred-1", UserId: uid + 1, PublicKey: []byte{1}}),
+		buzza.ErrPasskeyAlreadyRegistered)
+
+	passkeys, err := store.ByUserId(ctx, uid)
+	if assert.NoError(err) && assert.Len(passkeys, 2) {
+		assert.Equal("cred-1", passkeys[0].Id, "oldest first")
+		assert.Equal([]byte{1, 2, 3}, passkeys[0].PublicKey)
+		assert.Equal(uint32(5), passkeys[0].SignCount)
+		assert.Equal("cred-2", passkeys[1].Id)
+	}
+	_, err = store.ById(ctx, "missing")
+	assert.ErrorIs(err, buzza.ErrPasskeyNotFound)
+
+	assert.ErrorIs(store.UseSignCount(ctx, "cred-1", 5, now), buzza.ErrPasskeyReplayed)
+	assert.NoError(store.UseSignCount(ctx, "cred-1", 6, now))
+	assert.ErrorIs(store.UseSignCount(ctx, "cred-1", 0, now), buzza.ErrPasskeyReplayed)
+	// authenticator without counter
+	assert.NoError(store.UseSignCount(ctx, "cred-2", 0, now))
+	assert.NoError(store.UseSignCount(ctx, "cred-2", 0, now))
+	assert.ErrorIs(store.UseSignCount(ctx, "missing", 1, now), buzza.ErrPasskeyNotFound)
+	passkey, err := store.ById(ctx, "cred-1")
+	if assert.NoError(err) {
+		assert.Equal(uint32(6), passkey.SignCount)
+		assert.True(now.Equal(passkey.LastUsedAt))
+	}
+
+	_, err = store.Delete(ctx, uid+1, "cred-1")
+	assert.ErrorIs(err, buzza.ErrPasskeyNotFound, "passkey of other user")
+	deleted, err := store.Delete(ctx, uid, "cred-1")
+	if assert.NoError(err) {
+		assert.Equal("YubiKey", deleted.Name)
+	}
+	_, err = store.ById(ctx, "cred-1")
+	assert.ErrorIs(err, buzza.ErrPasskeyNotFound)
+}
+
+func TestPasskeyChallengeStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	ctx := context.Background()
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	_, err := db.NewDelete().
+		Model((*PasskeyChallenge)(nil)).
+		Where("1=1").
+		Exec(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	store := &PasskeyChallengeStore{DB: db}
+	storetest.RunPasskeyChallengeStoreTests(t, store)
+
+	deleted, err := store.DeleteExpired(ctx)
+	if assert.NoError(t, err) {
+		assert.EqualValues(t, 1, deleted, "test challenge is already expired")
+	}
+}
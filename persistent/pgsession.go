@@ -0,0 +1,529 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/google/uuid"
+	"github.com/tidwall/buntdb"
+	"github.com/uptrace/bun"
+)
+
+type PgSession struct {
+	bun.BaseModel `bun:"table:session"`
+
+	Id             string    `bun:",pk,type:uuid"`
+	UserId         int64     `bun:",notnull"`
+	TokenHash      string    `bun:",notnull,unique"`
+	Name           string    `bun:",notnull"`
+	Pinned         bool      `bun:",notnull"`
+	Trusted        bool      `bun:",notnull"`
+	Ip             string    `bun:",notnull"`
+	UserAgent      string    `bun:",notnull"`
+	Agent          *Agent    `bun:",type:jsonb"`
+	Location       *Location `bun:",type:jsonb"`
+	CreatedAt      time.Time `bun:",notnull"`
+	LastAccessedAt time.Time `bun:",notnull"`
+	ExpiresAt      time.Time `bun:",notnull"`
+}
+
+func (s PgSession) ToDomain() buzza.Session {
+	return s.toBunt().ToDomain()
+}
+
+func (s PgSession) toBunt() Session {
+	return Session{
+		Id:             s.Id,
+		UserId:         s.UserId,
+		TokenHash:      s.TokenHash,
+		Name:           s.Name,
+		Pinned:         s.Pinned,
+		Trusted:        s.Trusted,
+		Ip:             s.Ip,
+		UserAgent:      s.UserAgent,
+		Agent:          s.Agent,
+		Location:       s.Location,
+		CreatedAt:      s.CreatedAt,
+		LastAccessedAt: s.LastAccessedAt,
+		ExpiresAt:      s.ExpiresAt,
+	}
+}
+
+func newPgSession(s Session) *PgSession {
+	return &PgSession{
+		Id:             s.Id,
+		UserId:         s.UserId,
+		TokenHash:      s.TokenHash,
+		Name:           s.Name,
+		Pinned:         s.Pinned,
+		Trusted:        s.Trusted,
+		Ip:             s.Ip,
+		UserAgent:      s.UserAgent,
+		Agent:          s.Agent,
+		Location:       s.Location,
+		CreatedAt:      s.CreatedAt,
+		LastAccessedAt: s.LastAccessedAt,
+		ExpiresAt:      s.ExpiresAt,
+	}
+}
+
+// Session store shared by all backend instances. Expired rows are skipped by queries
+// and removed by DeleteExpired.
+type PgSessionStore struct {
+	DB            *bun.DB
+	ActivityStore buzza.ActivityStore
+	// Key of the token hashes. Changing it invalidates all sessions.
+	TokenSecret []byte
+	// Notified in the background about new sessions and IP or device changes if set.
+	Observer buzza.SessionObserver
+	// Locates session IPs if set.
+	Geo buzza.GeoLocator
+	// DefaultSessionPolicy if IdleTimeout is 0.
+	Policy buzza.SessionPolicy
+	// Provides roles for the concurrent sessions limit if set, otherwise Policy.MaxSessions applies.
+	Users buzza.UserStore
+	// Current time, time.Now if nil.
+	Now func() time.Time
+}
+
+var _ buzza.SessionStore = (*PgSessionStore)(nil)
+
+func (s *PgSessionStore) now() time.Time {
+	if s.Now == nil {
+		return time.Now().UTC()
+	}
+	return s.Now().UTC()
+}
+
+func (s *PgSessionStore) hash(token string) string {
+	return buzza.HashSessionToken(s.TokenSecret, token)
+}
+
+func (s *PgSessionStore) RegisterNew(ctx context.Context, userId buzza.UserId, ip string, userAgent string) (buzza.Session, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return buzza.Session{}, fmt.Errorf("generate token: %w", err)
+	}
+	policy := sessionPolicy(s.Policy)
+	location := locateSessionIp(s.Geo, ip)
+	limit, err := sessionLimit(ctx, policy, s.Users, userId)
+	if err != nil {
+		return buzza.Session{}, fmt.Errorf("session limit: %w", err)
+	}
+
+	now := s.now()
+	session := &PgSession{
+		Id:             uuid.New().String(),
+		UserId:         int64(userId),
+		TokenHash:      s.hash(token),
+		Ip:             ip,
+		UserAgent:      userAgent,
+		Agent:          newAgent(userAgent),
+		Location:       newLocation(location),
+		CreatedAt:      now,
+		LastAccessedAt: now,
+	}
+	session.ExpiresAt = policy.ExpiresAt(session.ToDomain())
+
+	activity := buzza.WithGeoLocation(buzza.SessionCreatedActivity(session.Id, ip, userAgent), location)
+	if err := s.ActivityStore.AddLog(ctx, userId, activity); err != nil {
+		return buzza.Session{}, fmt.Errorf("add session_created activity log: %w", err)
+	}
+
+	var evicted []buzza.Session
+	err = s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		// serializes concurrent logins of the user, so the limit can not be exceeded
+		_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(?)", userId)
+		if err != nil {
+			return fmt.Errorf("lock user sessions: %w", err)
+		}
+		if _, err := tx.NewInsert().Model(session).Exec(ctx); err != nil {
+			return fmt.Errorf("insert session: %w", err)
+		}
+		if limit == 0 {
+			return nil
+		}
+
+		var excess []PgSession
+		err = tx.NewSelect().
+			Model(&excess).
+			Where("user_id=?", userId).
+			Where("expires_at>?", now).
+			Order("created_at DESC", "id DESC").
+			Offset(limit).
+			Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("select excess sessions: %w", err)
+		}
+		if len(excess) == 0 {
+			return nil
+		}
+		ids := make([]string, len(excess))
+		for i, e := range excess {
+			ids[i] = e.Id
+			evicted = append(evicted, e.ToDomain())
+		}
+		_, err = tx.NewDelete().
+			Model((*PgSession)(nil)).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("evict sessions: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return buzza.Session{}, err
+	}
+
+	if err := logSessionEvictions(ctx, s.ActivityStore, evicted, limit); err != nil {
+		return buzza.Session{}, err
+	}
+	observeSession(s.Observer, session.ToDomain())
+	created := session.ToDomain()
+	created.Token = token
+	return created, nil
+}
+
+func (s *PgSessionStore) ByToken(token string) (buzza.Session, error) {
+	session := new(PgSession)
+	err := s.DB.NewSelect().
+		Model(session).
+		Where("token_hash=?", s.hash(token)).
+		Where("expires_at>?", s.now()).
+		Scan(context.Background())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.Session{}, buzza.ErrSessionNotFound
+		}
+		return buzza.Session{}, fmt.Errorf("select session: %w", err)
+	}
+	found := session.ToDomain()
+	found.Token = token
+	return found, nil
+}
+
+func (s *PgSessionStore) Exists(token string) (bool, error) {
+	exists, err := s.DB.NewSelect().
+		Model((*PgSession)(nil)).
+		Where("token_hash=?", s.hash(token)).
+		Where("expires_at>?", s.now()).
+		Exists(context.Background())
+	if err != nil {
+		return false, fmt.Errorf("select session exists: %w", err)
+	}
+	return exists, nil
+}
+
+func (s *PgSessionStore) ActiveSessions(token string) ([]buzza.Session, error) {
+	ctx := context.Background()
+	session, err := s.ByToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []PgSession
+	err = s.DB.NewSelect().
+		Model(&sessions).
+		Where("user_id=?", session.UserId).
+		Where("expires_at>?", s.now()).
+		Order("created_at ASC", "id ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("select sessions: %w", err)
+	}
+	mapped := make([]buzza.Session, len(sessions))
+	for i, session := range sessions {
+		mapped[i] = session.ToDomain()
+	}
+	return mapped, nil
+}
+
+func (s *PgSessionStore) AcquireAndRefresh(ctx context.Context, token string, ip string, userAgent string) (buzza.Session, error) {
+	policy := sessionPolicy(s.Policy)
+	now := s.now()
+	var previous, session PgSession
+	var location buzza.GeoLocation
+	var expired bool
+	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&previous).
+			Where("token_hash=?", s.hash(token)).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("select session: %w", err)
+		}
+		if !now.Before(previous.ExpiresAt) || !now.Before(policy.ExpiresAt(previous.ToDomain())) {
+			_, err := tx.NewDelete().Model(&previous).WherePK().Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("delete expired session: %w", err)
+			}
+			expired = true
+			return nil
+		}
+
+		session = previous
+		session.Ip = ip
+		session.UserAgent = userAgent
+		if previous.UserAgent != userAgent {
+			session.Agent = newAgent(userAgent)
+		}
+		if previous.Ip != ip {
+			location = locateSessionIp(s.Geo, ip)
+			session.Location = newLocation(location)
+		}
+		session.LastAccessedAt = now
+		session.ExpiresAt = policy.ExpiresAt(session.ToDomain())
+		_, err = tx.NewUpdate().
+			Model(&session).
+			Column("ip", "user_agent", "agent", "location", "last_accessed_at", "expires_at").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("update session: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.Session{}, buzza.ErrSessionNotFound
+		}
+		return buzza.Session{}, err
+	}
+	if expired {
+		return buzza.Session{}, buzza.ErrSessionNotFound
+	}
+
+	userId := buzza.UserId(session.UserId)
+	if previous.Ip != session.Ip {
+		activity := buzza.WithGeoLocation(
+			buzza.SessionChangedIpActivity(session.Id, previous.Ip, session.Ip), location)
+		if err := s.ActivityStore.AddLog(ctx, userId, activity); err != nil {
+			return buzza.Session{}, fmt.Errorf("log ip change: %w", err)
+		}
+	}
+	if previous.UserAgent != session.UserAgent {
+		activity := buzza.SessionChangedUserAgentActivity(session.Id, previous.UserAgent, session.UserAgent)
+		if err := s.ActivityStore.AddLog(ctx, userId, activity); err != nil {
+			return buzza.Session{}, fmt.Errorf("log useragent change: %w", err)
+		}
+	}
+	if previous.Ip != session.Ip || previous.UserAgent != session.UserAgent {
+		observeSession(s.Observer, session.ToDomain())
+	}
+	refreshed := session.ToDomain()
+	refreshed.Token = token
+	return refreshed, nil
+}
+
+func (s *PgSessionStore) UpdateById(ctx context.Context, userId buzza.UserId, sessionId string,
+	update buzza.SessionUpdate) (buzza.Session, error) {
+	if !validSessionId(sessionId) {
+		return buzza.Session{}, buzza.ErrSessionNotFound
+	}
+	var session PgSession
+	var trustChanged bool
+	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&session).
+			Where("id=?", sessionId).
+			Where("user_id=?", userId).
+			Where("expires_at>?", s.now()).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("select session: %w", err)
+		}
+
+		if update.Name != nil {
+			session.Name = *update.Name
+		}
+		if update.Pinned != nil {
+			session.Pinned = *update.Pinned
+		}
+		if update.Trusted != nil && *update.Trusted != session.Trusted {
+			trustChanged = true
+			session.Trusted = *update.Trusted
+			session.ExpiresAt = sessionPolicy(s.Policy).ExpiresAt(session.ToDomain())
+		}
+		_, err = tx.NewUpdate().
+			Model(&session).
+			Column("name", "pinned", "trusted", "expires_at").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("update session: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.Session{}, buzza.ErrSessionNotFound
+		}
+		return buzza.Session{}, err
+	}
+
+	if trustChanged {
+		activity := buzza.SessionTrustChangedActivity(session.Id, session.UserAgent, session.Trusted)
+		if err := s.ActivityStore.AddLog(ctx, userId, activity); err != nil {
+			return buzza.Session{}, fmt.Errorf("log trust change: %w", err)
+		}
+	}
+	return session.ToDomain(), nil
+}
+
+func (s *PgSessionStore) InvalidateById(userId buzza.UserId, sessionId string) error {
+	if !validSessionId(sessionId) {
+		return buzza.ErrSessionNotFound
+	}
+	res, err := s.DB.NewDelete().
+		Model((*PgSession)(nil)).
+		Where("id=?", sessionId).
+		Where("user_id=?", userId).
+		Where("expires_at>?", s.now()).
+		Exec(context.Background())
+	return sessionDeleteResult(res, err)
+}
+
+func (s *PgSessionStore) InvalidateByAuthToken(authToken string) error {
+	res, err := s.DB.NewDelete().
+		Model((*PgSession)(nil)).
+		Where("token_hash=?", s.hash(authToken)).
+		Where("expires_at>?", s.now()).
+		Exec(context.Background())
+	return sessionDeleteResult(res, err)
+}
+
+// Ids are uuids, other values would fail the query.
+func validSessionId(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil
+}
+
+func sessionDeleteResult(res sql.Result, err error) error {
+	if err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return buzza.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *PgSessionStore) InvalidateAllExpect(expectToken string) error {
+	ctx := context.Background()
+	session, err := s.ByToken(expectToken)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.NewDelete().
+		Model((*PgSession)(nil)).
+		Where("user_id=?", session.UserId).
+		Where("token_hash<>?", s.hash(expectToken)).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("delete sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *PgSessionStore) InvalidateByUserId(ctx context.Context, userId buzza.UserId) (int, error) {
+	res, err := s.DB.NewDelete().
+		Model((*PgSession)(nil)).
+		Where("user_id=?", userId).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("delete sessions: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return int(deleted), nil
+}
+
+// Remove rows of the expired sessions, returns number of removed rows.
+func (s *PgSessionStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := s.DB.NewDelete().
+		Model((*PgSession)(nil)).
+		Where("expires_at<=?", s.now()).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired sessions: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return deleted, nil
+}
+
+// Copy sessions from buntdb to pg, so users stay logged in after switching the store.
+// Sessions already present in pg are skipped, so migration can be run many times.
+// Plaintext tokens of legacy sessions are hashed with the token secret of the pg store.
+// Returns number of copied sessions.
+func MigrateBuntSessions(ctx context.Context, bdb *buntdb.DB, db *bun.DB, tokenSecret []byte) (int, error) {
+	const batchSize = 500
+	var sessions []*PgSession
+	err := bdb.View(func(tx *buntdb.Tx) error {
+		var parseErr error
+		err := tx.AscendKeys("session:*", func(key, value string) bool {
+			var session Session
+			if err := json.Unmarshal([]byte(value), &session); err != nil {
+				parseErr = fmt.Errorf("deserialize session '%s': %w", key, err)
+				return false
+			}
+			if session.CreatedAt.IsZero() {
+				session.CreatedAt = session.LastAccessedAt
+			}
+			if session.TokenHash == "" {
+				session.TokenHash = buzza.HashSessionToken(tokenSecret, session.Token)
+				session.Token = ""
+			}
+			if session.Agent == nil {
+				session.Agent = newAgent(session.UserAgent)
+			}
+			sessions = append(sessions, newPgSession(session))
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return parseErr
+	})
+	if err != nil {
+		return 0, fmt.Errorf("read buntdb sessions: %w", err)
+	}
+
+	migrated := 0
+	for start := 0; start < len(sessions); start += batchSize {
+		end := start + batchSize
+		if end > len(sessions) {
+			end = len(sessions)
+		}
+		batch := sessions[start:end]
+		res, err := db.NewInsert().
+			Model(&batch).
+			On("CONFLICT DO NOTHING").
+			Exec(ctx)
+		if err != nil {
+			return migrated, fmt.Errorf("insert sessions: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return migrated, fmt.Errorf("rows affected: %w", err)
+		}
+		migrated += int(affected)
+	}
+	return migrated, nil
+}
@@ -0,0 +1,293 @@
+package persistent
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/buzkaaclicker/buzza/mock"
+	"github.com/buzkaaclicker/buzza/storetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/buntdb"
+	"github.com/uptrace/bun"
+)
+
+func clearPgSessions(ctx context.Context, db *bun.DB, userIds ...buzza.UserId) {
+	_, err := db.NewDelete().
+		Model((*PgSession)(nil)).
+		Where("user_id IN (?)", bun.In(userIds)).
+		Exec(ctx)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestPgSessionRegisterAndRefresh(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+	clearPgSessions(ctx, db, 7120)
+
+	activityStore := inmem.NewActivityStore()
+	sessionStore := &PgSessionStore{DB: db, ActivityStore: &activityStore}
+
+	session, err := sessionStore.RegisterNew(ctx, 7120, "192.168.0.101", "Chrome/openBased")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(buzza.UserId(7120), session.UserId)
+	assert.Equal("192.168.0.101", session.Ip)
+	assert.Equal("Chrome/openBased", session.UserAgent)
+
+	byToken, err := sessionStore.ByToken(session.Token)
+	if assert.NoError(err) {
+		assert.Equal(session.Id, byToken.Id)
+	}
+	_, err = sessionStore.ByToken("missing")
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+
+	logs, err := activityStore.ByUserId(ctx, session.UserId, buzza.ActivityQuery{Limit: 100})
+	if !assert.NoError(err) || !assert.Len(logs, 1) {
+		return
+	}
+	assert.Equal(buzza.ActivitySessionCreated, logs[0].Name)
+
+	// refresh without changes does not log anything
+	_, err = sessionStore.AcquireAndRefresh(ctx, session.Token, "192.168.0.101", "Chrome/openBased")
+	if !assert.NoError(err) {
+		return
+	}
+	refreshedLogs, err := activityStore.ByUserId(ctx, session.UserId, buzza.ActivityQuery{Limit: 100})
+	if assert.NoError(err) {
+		assert.Equal(logs, refreshedLogs)
+	}
+
+	refreshed, err := sessionStore.AcquireAndRefresh(ctx, session.Token, "192.168.0.102", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("192.168.0.102", refreshed.Ip)
+	assert.Equal("Firefox", refreshed.UserAgent)
+	assert.True(refreshed.LastAccessedAt.After(session.LastAccessedAt))
+	logs, err = activityStore.ByUserId(ctx, session.UserId, buzza.ActivityQuery{
+		Names: []string{buzza.ActivitySessionChangedIp, buzza.ActivitySessionChangedUserAgent},
+		Limit: 100,
+	})
+	if assert.NoError(err) && assert.Len(logs, 2) {
+		assert.ElementsMatch([]string{buzza.ActivitySessionChangedIp, buzza.ActivitySessionChangedUserAgent},
+			[]string{logs[0].Name, logs[1].Name})
+	}
+
+	name := "Laptop"
+	pinned := true
+	updated, err := sessionStore.UpdateById(ctx, 7120, session.Id, buzza.SessionUpdate{Name: &name, Pinned: &pinned})
+	if assert.NoError(err) {
+		assert.Equal("Laptop", updated.Name)
+		assert.True(updated.Pinned)
+	}
+	_, err = sessionStore.UpdateById(ctx, 7121, session.Id, buzza.SessionUpdate{Name: &name})
+	assert.ErrorIs(err, buzza.ErrSessionNotFound, "foreign session should not be updated")
+
+	assert.ErrorIs(sessionStore.InvalidateById(7121, session.Id), buzza.ErrSessionNotFound)
+	assert.ErrorIs(sessionStore.InvalidateById(7120, "not-an-uuid"), buzza.ErrSessionNotFound)
+	assert.NoError(sessionStore.InvalidateById(7120, session.Id))
+	exists, err := sessionStore.Exists(session.Token)
+	if assert.NoError(err) {
+		assert.False(exists)
+	}
+	_, err = sessionStore.AcquireAndRefresh(ctx, session.Token, "192.168.0.102", "Firefox")
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+	assert.ErrorIs(sessionStore.InvalidateByAuthToken(session.Token), buzza.ErrSessionNotFound)
+}
+
+func TestPgSessionLimits(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+	clearPgSessions(ctx, db, 7130, 7131)
+
+	activityStore := inmem.NewActivityStore()
+	activityStore.Catalogue = buzza.DefaultActivityCatalogue
+	policy := buzza.DefaultSessionPolicy
+	policy.MaxSessions = 2
+	sessionStore := &PgSessionStore{DB: db, ActivityStore: &activityStore, Policy: policy,
+		Users: mock.UserStore{ByIdFn: func(ctx context.Context, userId buzza.UserId) (buzza.User, error) {
+			return buzza.User{Id: userId}, nil
+		}}}
+
+	var sessions []buzza.Session
+	for _, userAgent := range []string{"Firefox", "Chrome", "Safari"} {
+		session, err := sessionStore.RegisterNew(ctx, 7130, "192.168.0.101", userAgent)
+		if !assert.NoError(err) {
+			return
+		}
+		sessions = append(sessions, session)
+	}
+	otherSession, err := sessionStore.RegisterNew(ctx, 7131, "192.168.0.102", "Chrome")
+	if !assert.NoError(err) {
+		return
+	}
+
+	active, err := sessionStore.ActiveSessions(sessions[2].Token)
+	if assert.NoError(err) && assert.Len(active, 2) {
+		assert.Equal(sessions[1].Id, active[0].Id, "oldest session should be evicted")
+		assert.Equal(sessions[2].Id, active[1].Id)
+	}
+	logs, err := activityStore.ByUserId(ctx, 7130, buzza.ActivityQuery{Names: []string{buzza.ActivitySessionEvicted}, Limit: 10})
+	if assert.NoError(err) && assert.Len(logs, 1) {
+		assert.Equal(sessions[0].Id, logs[0].Data["session_id"])
+	}
+
+	assert.NoError(sessionStore.InvalidateAllExpect(sessions[2].Token))
+	exists, err := sessionStore.Exists(otherSession.Token)
+	if assert.NoError(err) {
+		assert.True(exists, "other user sessions should not be invalidated")
+	}
+	active, err = sessionStore.ActiveSessions(sessions[2].Token)
+	if assert.NoError(err) && assert.Len(active, 1) {
+		assert.Equal(sessions[2].Id, active[0].Id)
+	}
+}
+
+func TestPgSessionExpiry(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+	clearPgSessions(ctx, db, 7140)
+
+	activityStore := inmem.NewActivityStore()
+	sessionStore := &PgSessionStore{DB: db, ActivityStore: &activityStore}
+	session, err := sessionStore.RegisterNew(ctx, 7140, "192.168.0.101", "Chrome")
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = db.NewUpdate().
+		Model((*PgSession)(nil)).
+		Set("expires_at=?", time.Now().Add(-time.Minute)).
+		Where("id=?", session.Id).
+		Exec(ctx)
+	if !assert.NoError(err) {
+		return
+	}
+
+	exists, err := sessionStore.Exists(session.Token)
+	if assert.NoError(err) {
+		assert.False(exists)
+	}
+	deleted, err := sessionStore.DeleteExpired(ctx)
+	if assert.NoError(err) {
+		assert.GreaterOrEqual(deleted, int64(1))
+	}
+	count, err := db.NewSelect().Model((*PgSession)(nil)).Where("id=?", session.Id).Count(ctx)
+	if assert.NoError(err) {
+		assert.Zero(count)
+	}
+}
+
+func TestMigrateBuntSessions(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+	clearPgSessions(ctx, db, 7150)
+
+	bdb, err := buntdb.Open(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	defer bdb.Close()
+
+	secret := []byte("test-secret")
+	activityStore := inmem.NewActivityStore()
+	buntStore := &SessionStore{Buntdb: bdb, ActivityStore: &activityStore, TokenSecret: secret}
+	buntStore.CreateIndexes()
+	session, err := buntStore.RegisterNew(ctx, 7150, "192.168.0.101", "Chrome")
+	if !assert.NoError(err) {
+		return
+	}
+	// sessions stored before created at tracking and token hashing
+	legacy := Session{
+		Id:             "6f0c1c53-2f77-4dbb-9e43-2ad1f6d5a1c0",
+		UserId:         7150,
+		Token:          "legacy-token-7150",
+		Ip:             "192.168.0.102",
+		UserAgent:      "Firefox",
+		LastAccessedAt: time.Now().UTC().Add(-time.Hour),
+		ExpiresAt:      time.Now().UTC().Add(time.Hour),
+	}
+	err = bdb.Update(func(tx *buntdb.Tx) error {
+		data, err := json.Marshal(legacy)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set("session:"+legacy.Token, string(data), nil)
+		return err
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	migrated, err := MigrateBuntSessions(ctx, bdb, db, secret)
+	if assert.NoError(err) {
+		assert.Equal(2, migrated)
+	}
+	migrated, err = MigrateBuntSessions(ctx, bdb, db, secret)
+	if assert.NoError(err) {
+		assert.Zero(migrated, "already migrated sessions should be skipped")
+	}
+
+	pgStore := &PgSessionStore{DB: db, ActivityStore: &activityStore, TokenSecret: secret}
+	migratedSession, err := pgStore.ByToken(session.Token)
+	if assert.NoError(err) {
+		assert.Equal(session.Id, migratedSession.Id)
+		assert.Equal(session.UserAgent, migratedSession.UserAgent)
+	}
+	legacySession, err := pgStore.ByToken(legacy.Token)
+	if assert.NoError(err) {
+		assert.WithinDuration(legacy.LastAccessedAt, legacySession.CreatedAt, time.Millisecond)
+		assert.Equal(buzza.ParseUserAgent("Firefox"), legacySession.Agent)
+	}
+}
+
+func TestPgSessionStoreConformance(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	clock, advance := storetest.NewClock()
+	storetest.RunSessionStoreTests(t, func(activityStore buzza.ActivityStore, policy buzza.SessionPolicy) buzza.SessionStore {
+		clearPgSessions(ctx, db, 1, 2)
+		return &PgSessionStore{DB: db, ActivityStore: activityStore, Policy: policy, Now: clock}
+	}, advance)
+}
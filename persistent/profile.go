@@ -23,9 +23,14 @@ type Profile struct {
 }
 
 func (p Profile) ToDomain() buzza.Profile {
+	// user relation is not always loaded
+	user := buzza.User{Id: buzza.UserId(p.UserId)}
+	if p.User != nil {
+		user = p.User.ToDomain()
+	}
 	return buzza.Profile{
 		Id:        p.Id,
-		User:      p.User.ToDomain(),
+		User:      user,
 		Name:      p.Name,
 		AvatarUrl: p.AvatarUrl,
 	}
@@ -39,9 +44,9 @@ var _ buzza.ProfileStore = (*ProfileStore)(nil)
 
 func (s *ProfileStore) ByUserId(ctx context.Context, userId buzza.UserId) (buzza.Profile, error) {
 	profile := new(Profile)
+	// user is not loaded, profiles are public and user emails are sealed
 	err := s.DB.NewSelect().
 		Model(profile).
-		Relation("User").
 		Where(`user_id=?`, userId).
 		Scan(ctx)
 	if err != nil {
@@ -23,7 +23,7 @@ func TestProfileServiceCreate(t *testing.T) {
 		DB: db,
 	}
 
-	user := &User{RolesNames: []buzza.RoleId{}, DiscordId: "23904321095490", DiscordRefreshToken: "missing"}
+	user := &User{RolesNames: []buzza.RoleId{}}
 	_, err := service.DB.NewInsert().
 		Model(user).
 		Ignore().
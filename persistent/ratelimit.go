@@ -0,0 +1,71 @@
+package persistent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+type RateLimit struct {
+	bun.BaseModel `bun:"table:rate_limit"`
+
+	Key         string    `bun:",pk"`
+	WindowStart time.Time `bun:",notnull"`
+	Hits        int       `bun:",notnull"`
+}
+
+// Rate limiter shared by all backend instances, rows of past windows are removed by DeleteExpired.
+type RateLimiter struct {
+	DB *bun.DB
+	// Defaults to time.Now.
+	Now func() time.Time
+}
+
+var _ buzza.RateLimiter = (*RateLimiter)(nil)
+
+func (l *RateLimiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+func (l *RateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	hit := &RateLimit{
+		Key:         key,
+		WindowStart: buzza.RateLimitWindowStart(l.now(), window),
+		Hits:        1,
+	}
+	// hits of the previous window are reset, hits over the limit are not counted
+	_, err := l.DB.NewInsert().
+		Model(hit).
+		On("CONFLICT (key) DO UPDATE").
+		Set(`hits=CASE WHEN rate_limit.window_start=EXCLUDED.window_start
+			THEN LEAST(rate_limit.hits+1, ?) ELSE 1 END`, limit+1).
+		Set("window_start=EXCLUDED.window_start").
+		Returning("hits").
+		Exec(ctx)
+	if err != nil {
+		return false, fmt.Errorf("upsert rate limit: %w", err)
+	}
+	return hit.Hits <= limit, nil
+}
+
+// Remove rows of the windows started before the time, returns number of removed rows.
+func (l *RateLimiter) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	res, err := l.DB.NewDelete().
+		Model((*RateLimit)(nil)).
+		Where("window_start<?", before.UTC()).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired rate limits: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,293 @@
+package persistent
+
+import (
+	"context"
+	crand "crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+type ReferralCode struct {
+	bun.BaseModel `bun:"table:referral_code"`
+
+	UserId int64  `bun:",pk"`
+	Code   string `bun:",notnull,unique,type:varchar(16)"`
+}
+
+type Referral struct {
+	bun.BaseModel `bun:"table:referral"`
+
+	Id          int64     `bun:",pk,autoincrement"`
+	CreatedAt   time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	ReferrerId  int64     `bun:",notnull"`
+	RefereeId   int64     `bun:",notnull,unique"`
+	Ip          string    `bun:",notnull"`
+	PurchasedAt time.Time `bun:",nullzero"`
+}
+
+func (r Referral) ToDomain() buzza.Referral {
+	return buzza.Referral{
+		Id:          r.Id,
+		ReferrerId:  buzza.UserId(r.ReferrerId),
+		RefereeId:   buzza.UserId(r.RefereeId),
+		CreatedAt:   r.CreatedAt,
+		PurchasedAt: r.PurchasedAt,
+	}
+}
+
+type ReferralReward struct {
+	bun.BaseModel `bun:"table:referral_reward"`
+
+	Id         int64        `bun:",pk,autoincrement"`
+	UserId     int64        `bun:",notnull"`
+	ReferralId int64        `bun:",notnull,unique"`
+	RoleId     buzza.RoleId `bun:",notnull"`
+	ExpiresAt  time.Time    `bun:",notnull"`
+}
+
+func (r ReferralReward) ToDomain() buzza.ReferralReward {
+	return buzza.ReferralReward{
+		Id:         r.Id,
+		UserId:     buzza.UserId(r.UserId),
+		ReferralId: r.ReferralId,
+		RoleId:     r.RoleId,
+		ExpiresAt:  r.ExpiresAt,
+	}
+}
+
+type ReferralStore struct {
+	DB         *bun.DB
+	RewardRule buzza.ReferralRewardRule
+}
+
+var _ buzza.ReferralStore = (*ReferralStore)(nil)
+
+func (s *ReferralStore) CodeByUserId(ctx context.Context, userId buzza.UserId) (buzza.ReferralCode, error) {
+	code := new(ReferralCode)
+	err := s.DB.NewSelect().
+		Model(code).
+		Where("user_id=?", userId).
+		Scan(ctx)
+	if err == nil {
+		return buzza.ReferralCode(code.Code), nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("select code: %w", err)
+	}
+
+	// code collisions are very unlikely, but retry few times just in case
+	const attempts = 5
+	for i := 0; i < attempts; i++ {
+		generated, err := generateReferralCode()
+		if err != nil {
+			return "", fmt.Errorf("generate code: %w", err)
+		}
+		code = &ReferralCode{UserId: int64(userId), Code: generated}
+		res, err := s.DB.NewInsert().
+			Model(code).
+			On("CONFLICT DO NOTHING").
+			Exec(ctx)
+		if err != nil {
+			return "", fmt.Errorf("insert code: %w", err)
+		}
+		if affected, err := res.RowsAffected(); err == nil && affected == 1 {
+			return buzza.ReferralCode(code.Code), nil
+		}
+
+		// concurrent request could have generated the code in the meantime
+		err = s.DB.NewSelect().
+			Model(code).
+			Where("user_id=?", userId).
+			Scan(ctx)
+		if err == nil {
+			return buzza.ReferralCode(code.Code), nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("select code: %w", err)
+		}
+	}
+	return "", fmt.Errorf("could not generate unique code in %d attempts", attempts)
+}
+
+func (s *ReferralStore) Attach(ctx context.Context, code buzza.ReferralCode, referee buzza.User, ip string) (buzza.Referral, error) {
+	referral := &Referral{RefereeId: int64(referee.Id), Ip: ip}
+	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		referrerCode := new(ReferralCode)
+		err := tx.NewSelect().
+			Model(referrerCode).
+			Where("code=?", code).
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return buzza.ErrReferralCodeNotFound
+			}
+			return fmt.Errorf("select code: %w", err)
+		}
+		if referrerCode.UserId == int64(referee.Id) {
+			return buzza.ErrSelfReferral
+		}
+		referral.ReferrerId = referrerCode.UserId
+
+		// referee logged in with an account the referrer has unlinked, e.g. discord
+		unlinkedByReferrer, err := tx.NewSelect().
+			Model((*UnlinkedIdentity)(nil)).
+			Join("JOIN user_identity AS i ON i.provider=unlinked_identity.provider AND i.subject=unlinked_identity.subject").
+			Where("unlinked_identity.user_id=?", referrerCode.UserId).
+			Where("i.user_id=?", referee.Id).
+			Exists(ctx)
+		if err != nil {
+			return fmt.Errorf("check unlinked identities of referrer: %w", err)
+		}
+		if unlinkedByReferrer {
+			return buzza.ErrSelfReferral
+		}
+
+		// referrer has ever logged in from the referee ip
+		sameIp, err := tx.NewSelect().
+			Model((*ActivityLog)(nil)).
+			Where("user_id=?", referrerCode.UserId).
+			Where("data->>'ip'=?", ip).
+			Exists(ctx)
+		if err != nil {
+			return fmt.Errorf("check referrer ip: %w", err)
+		}
+		if sameIp {
+			return buzza.ErrSelfReferral
+		}
+
+		res, err := tx.NewInsert().
+			Model(referral).
+			On("CONFLICT (referee_id) DO NOTHING").
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("insert referral: %w", err)
+		}
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			return buzza.ErrAlreadyReferred
+		}
+		return nil
+	})
+	if err != nil {
+		return buzza.Referral{}, err
+	}
+	return referral.ToDomain(), nil
+}
+
+func (s *ReferralStore) ByReferrerId(ctx context.Context, referrerId buzza.UserId) ([]buzza.Referral, error) {
+	var referrals []Referral
+	err := s.DB.NewSelect().
+		Model((*Referral)(nil)).
+		Where("referrer_id=?", referrerId).
+		Order("id DESC").
+		Scan(ctx, &referrals)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.Referral, len(referrals))
+	for i, r := range referrals {
+		mapped[i] = r.ToDomain()
+	}
+	return mapped, nil
+}
+
+func (s *ReferralStore) RewardsByUserId(ctx context.Context, userId buzza.UserId) ([]buzza.ReferralReward, error) {
+	var rewards []ReferralReward
+	err := s.DB.NewSelect().
+		Model((*ReferralReward)(nil)).
+		Where("user_id=?", userId).
+		Order("id DESC").
+		Scan(ctx, &rewards)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.ReferralReward, len(rewards))
+	for i, r := range rewards {
+		mapped[i] = r.ToDomain()
+	}
+	return mapped, nil
+}
+
+func (s *ReferralStore) RegisterPurchase(ctx context.Context, refereeId buzza.UserId) (buzza.ReferralReward, error) {
+	reward := new(ReferralReward)
+	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		referral := new(Referral)
+		err := tx.NewSelect().
+			Model(referral).
+			Where("referee_id=?", refereeId).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return buzza.ErrReferralNotFound
+			}
+			return fmt.Errorf("select referral: %w", err)
+		}
+		if !referral.PurchasedAt.IsZero() {
+			return buzza.ErrReferralAlreadyRewarded
+		}
+
+		now := time.Now().UTC()
+		_, err = tx.NewUpdate().
+			Model(referral).
+			Set("purchased_at=?", now).
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("update referral: %w", err)
+		}
+
+		// stack the reward on top of the still active one
+		rewardStart := now
+		var latestExpiresAt time.Time
+		err = tx.NewSelect().
+			Model((*ReferralReward)(nil)).
+			ColumnExpr("COALESCE(MAX(expires_at), ?)", now).
+			Where("user_id=? AND role_id=?", referral.ReferrerId, s.RewardRule.RoleId).
+			Scan(ctx, &latestExpiresAt)
+		if err != nil {
+			return fmt.Errorf("select latest reward: %w", err)
+		}
+		if latestExpiresAt.After(rewardStart) {
+			rewardStart = latestExpiresAt
+		}
+
+		reward.UserId = referral.ReferrerId
+		reward.ReferralId = referral.Id
+		reward.RoleId = s.RewardRule.RoleId
+		reward.ExpiresAt = rewardStart.Add(s.RewardRule.Duration)
+		_, err = tx.NewInsert().
+			Model(reward).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("insert reward: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return buzza.ReferralReward{}, err
+	}
+	return reward.ToDomain(), nil
+}
+
+// Readable code without ambiguous characters (0/O, 1/I/L).
+func generateReferralCode() (string, error) {
+	const alphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	const codeLength = 8
+	raw := make([]byte, codeLength)
+	if _, err := crand.Read(raw); err != nil {
+		return "", fmt.Errorf("rand read: %w", err)
+	}
+	code := make([]byte, codeLength)
+	for i, b := range raw {
+		code[i] = alphabet[int(b)%len(alphabet)]
+	}
+	return string(code), nil
+}
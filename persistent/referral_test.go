@@ -0,0 +1,170 @@
+package persistent
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferralStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	userStore := &UserStore{DB: db}
+	activityStore := &ActivityStore{DB: db}
+	store := &ReferralStore{DB: db, RewardRule: buzza.DefaultReferralRewardRule}
+
+	insertUser := func() buzza.User {
+		user := &User{RolesNames: []buzza.RoleId{}}
+		_, err := db.NewInsert().Model(user).Exec(ctx)
+		if err != nil {
+			panic(err)
+		}
+		return user.ToDomain()
+	}
+	referrer := insertUser()
+	referee := insertUser()
+	cheater := insertUser()
+
+	err := activityStore.AddLog(ctx, referrer.Id, buzza.Activity{Name: "session_created",
+		Data: map[string]interface{}{"ip": "10.0.0.1"}})
+	if !assert.NoError(err) {
+		return
+	}
+
+	code, err := store.CodeByUserId(ctx, referrer.Id)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(code, 8)
+	sameCode, err := store.CodeByUserId(ctx, referrer.Id)
+	if assert.NoError(err) {
+		assert.Equal(code, sameCode)
+	}
+
+	_, err = store.Attach(ctx, "NOTEXIST", referee, "10.0.0.2")
+	assert.ErrorIs(err, buzza.ErrReferralCodeNotFound)
+	_, err = store.Attach(ctx, code, referrer, "10.0.0.2")
+	assert.ErrorIs(err, buzza.ErrSelfReferral)
+	_, err = store.Attach(ctx, code, cheater, "10.0.0.1")
+	assert.ErrorIs(err, buzza.ErrSelfReferral)
+
+	referral, err := store.Attach(ctx, code, referee, "10.0.0.2")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(referrer.Id, referral.ReferrerId)
+	assert.Equal(referee.Id, referral.RefereeId)
+	_, err = store.Attach(ctx, code, referee, "10.0.0.2")
+	assert.ErrorIs(err, buzza.ErrAlreadyReferred)
+
+	referrals, err := store.ByReferrerId(ctx, referrer.Id)
+	if assert.NoError(err) && assert.Len(referrals, 1) {
+		assert.True(referrals[0].PurchasedAt.IsZero())
+	}
+
+	_, err = store.RegisterPurchase(ctx, cheater.Id)
+	assert.ErrorIs(err, buzza.ErrReferralNotFound)
+	reward, err := store.RegisterPurchase(ctx, referee.Id)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(referrer.Id, reward.UserId)
+	assert.Equal(buzza.RoleIdPro, reward.RoleId)
+	assert.WithinDuration(time.Now().Add(buzza.DefaultReferralRewardRule.Duration), reward.ExpiresAt, time.Minute)
+	_, err = store.RegisterPurchase(ctx, referee.Id)
+	assert.ErrorIs(err, buzza.ErrReferralAlreadyRewarded)
+
+	rewardedReferrer, err := userStore.ById(ctx, referrer.Id)
+	if assert.NoError(err) {
+		assert.Equal(buzza.AccessAllowed, rewardedReferrer.Roles.Access(buzza.PermissionDownloadPro))
+	}
+}
+
+func TestReferralUnlinkedIdentity(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+	userStore := &UserStore{DB: db}
+	store := &ReferralStore{DB: db, RewardRule: buzza.DefaultReferralRewardRule}
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	discordIdentity := buzza.ExternalIdentity{Provider: buzza.ProviderDiscord, Subject: "unlinked-" + suffix}
+	referrer, _, err := userStore.RegisterExternalUser(ctx, discordIdentity)
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = userStore.LinkIdentity(ctx, referrer.Id,
+		buzza.ExternalIdentity{Provider: buzza.ProviderGitHub, Subject: "unlinked-" + suffix})
+	if !assert.NoError(err) {
+		return
+	}
+	code, err := store.CodeByUserId(ctx, referrer.Id)
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = userStore.UnlinkIdentity(ctx, referrer.Id, buzza.ProviderDiscord)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// the unlinked discord account registers a new user
+	referee, created, err := userStore.RegisterExternalUser(ctx, discordIdentity)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.True(created)
+	_, err = store.Attach(ctx, code, referee, "10.0.9.1")
+	assert.ErrorIs(err, buzza.ErrSelfReferral, "account once linked to the referrer")
+
+	other, _, err := userStore.RegisterExternalUser(ctx,
+		buzza.ExternalIdentity{Provider: buzza.ProviderDiscord, Subject: "other-" + suffix})
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = store.Attach(ctx, code, other, "10.0.9.2")
+	assert.NoError(err)
+}
+
+func TestUserRolesWithRewards(t *testing.T) {
+	assert := assert.New(t)
+
+	user := User{
+		Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdPro]},
+		ReferralRewards: []*ReferralReward{
+			{RoleId: buzza.RoleIdPro},
+			{RoleId: buzza.RoleIdAdmin},
+			{RoleId: "UNDEFINED role"},
+		},
+	}
+	assert.Equal(buzza.Roles{buzza.AllRoles[buzza.RoleIdPro], buzza.AllRoles[buzza.RoleIdAdmin]}, user.ToDomain().Roles)
+	assert.Equal(buzza.Roles{buzza.AllRoles[buzza.RoleIdPro]}, user.Roles)
+}
+
+func Test_GenerateReferralCode(t *testing.T) {
+	assert := assert.New(t)
+
+	code, err := generateReferralCode()
+	if assert.NoError(err) {
+		assert.Len(code, 8)
+		assert.NotContains(code, "0")
+		assert.NotContains(code, "O")
+	}
+}
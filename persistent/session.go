@@ -7,45 +7,228 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/buzkaaclicker/buzza"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"github.com/tidwall/buntdb"
 )
 
-const sessionTTL = 30 * 24 * time.Hour // 30 days
-
 type Session struct {
-	Id             string    `json:"id"`
-	UserId         int64     `json:"userId"`
-	Token          string    `json:"token"`
+	Id     string `json:"id"`
+	UserId int64  `json:"userId"`
+	// Plaintext token of sessions stored before tokens were hashed, see HashLegacyTokens.
+	Token          string    `json:"token,omitempty"`
+	TokenHash      string    `json:"tokenHash,omitempty"`
+	Name           string    `json:"name,omitempty"`
+	Pinned         bool      `json:"pinned,omitempty"`
+	Trusted        bool      `json:"trusted,omitempty"`
 	Ip             string    `json:"ip"`
 	UserAgent      string    `json:"userAgent"`
+	Agent          *Agent    `json:"agent,omitempty"`
+	Location       *Location `json:"location,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
 	LastAccessedAt time.Time `json:"lastAccessedAt"`
 	ExpiresAt      time.Time `json:"expiresAt"`
 }
 
 func (s Session) ToDomain() buzza.Session {
+	var location buzza.GeoLocation
+	if s.Location != nil {
+		location = s.Location.ToDomain()
+	}
+	var agent buzza.UserAgentInfo
+	if s.Agent != nil {
+		agent = s.Agent.ToDomain()
+	} else {
+		// sessions created before user agents were parsed
+		agent = buzza.ParseUserAgent(s.UserAgent)
+	}
 	return buzza.Session{
 		Id:             s.Id,
 		UserId:         buzza.UserId(s.UserId),
-		Token:          s.Token,
+		Name:           s.Name,
+		Pinned:         s.Pinned,
+		Trusted:        s.Trusted,
 		Ip:             s.Ip,
 		UserAgent:      s.UserAgent,
+		Agent:          agent,
+		Location:       location,
+		CreatedAt:      s.CreatedAt,
 		LastAccessedAt: s.LastAccessedAt,
 		ExpiresAt:      s.ExpiresAt,
 	}
 }
 
+// Parsed user agent of the session.
+type Agent struct {
+	Client        string `json:"client,omitempty"`
+	ClientVersion string `json:"clientVersion,omitempty"`
+	Branch        string `json:"branch,omitempty"`
+	OS            string `json:"os,omitempty"`
+	OSVersion     string `json:"osVersion,omitempty"`
+	Device        string `json:"device,omitempty"`
+}
+
+func newAgent(userAgent string) *Agent {
+	info := buzza.ParseUserAgent(userAgent)
+	return &Agent{
+		Client:        info.Client,
+		ClientVersion: info.ClientVersion,
+		Branch:        info.Branch,
+		OS:            info.OS,
+		OSVersion:     info.OSVersion,
+		Device:        string(info.Device),
+	}
+}
+
+func (a Agent) ToDomain() buzza.UserAgentInfo {
+	return buzza.UserAgentInfo{
+		Client:        a.Client,
+		ClientVersion: a.ClientVersion,
+		Branch:        a.Branch,
+		OS:            a.OS,
+		OSVersion:     a.OSVersion,
+		Device:        buzza.DeviceType(a.Device),
+	}
+}
+
+type Location struct {
+	CountryCode     string `json:"countryCode,omitempty"`
+	Country         string `json:"country,omitempty"`
+	City            string `json:"city,omitempty"`
+	Asn             uint32 `json:"asn,omitempty"`
+	AsnOrganization string `json:"asnOrganization,omitempty"`
+}
+
+func newLocation(location buzza.GeoLocation) *Location {
+	if location.IsZero() {
+		return nil
+	}
+	return &Location{
+		CountryCode:     location.CountryCode,
+		Country:         location.Country,
+		City:            location.City,
+		Asn:             location.Asn,
+		AsnOrganization: location.AsnOrganization,
+	}
+}
+
+func (l Location) ToDomain() buzza.GeoLocation {
+	return buzza.GeoLocation{
+		CountryCode:     l.CountryCode,
+		Country:         l.Country,
+		City:            l.City,
+		Asn:             l.Asn,
+		AsnOrganization: l.AsnOrganization,
+	}
+}
+
 type SessionStore struct {
 	Buntdb        *buntdb.DB
 	ActivityStore buzza.ActivityStore
+	// Key of the token hashes. Changing it invalidates all sessions.
+	TokenSecret []byte
+	// Notified in the background about new sessions and IP or device changes if set.
+	Observer buzza.SessionObserver
+	// Locates session IPs if set.
+	Geo buzza.GeoLocator
+	// DefaultSessionPolicy if IdleTimeout is 0.
+	Policy buzza.SessionPolicy
+	// Provides roles for the concurrent sessions limit if set, otherwise Policy.MaxSessions applies.
+	Users buzza.UserStore
+	// Current time, time.Now if nil. Keys expire by the wall clock, so sessions expired by this clock
+	// are skipped as well.
+	Now func() time.Time
 }
 
 func (s *SessionStore) CreateIndexes() {
 	s.Buntdb.CreateIndex("sessions", "session:*", buntdb.IndexString)
+	s.Buntdb.CreateIndex("sessions_by_user", "session:*", buntdb.IndexJSON("userId"))
+}
+
+func (s *SessionStore) now() time.Time {
+	if s.Now == nil {
+		return time.Now().UTC()
+	}
+	return s.Now().UTC()
+}
+
+func (s *SessionStore) hash(token string) string {
+	return buzza.HashSessionToken(s.TokenSecret, token)
+}
+
+func (s *SessionStore) policy() buzza.SessionPolicy {
+	return sessionPolicy(s.Policy)
+}
+
+func (s *SessionStore) sessionLimit(ctx context.Context, userId buzza.UserId) (int, error) {
+	return sessionLimit(ctx, s.policy(), s.Users, userId)
+}
+
+// Helpers shared by the buntdb and pg session stores.
+
+func sessionPolicy(policy buzza.SessionPolicy) buzza.SessionPolicy {
+	if policy.IdleTimeout == 0 {
+		return buzza.DefaultSessionPolicy
+	}
+	return policy
+}
+
+func sessionLimit(ctx context.Context, policy buzza.SessionPolicy, users buzza.UserStore, userId buzza.UserId) (int, error) {
+	if users == nil {
+		return policy.MaxSessions, nil
+	}
+	user, err := users.ById(ctx, userId)
+	if err != nil {
+		return 0, fmt.Errorf("get user: %w", err)
+	}
+	return policy.SessionLimit(user.Roles), nil
+}
+
+// Location of the IP, zero if unknown or locator is not set.
+func locateSessionIp(geo buzza.GeoLocator, ip string) buzza.GeoLocation {
+	if geo == nil {
+		return buzza.GeoLocation{}
+	}
+	location, err := geo.Locate(ip)
+	if err != nil {
+		// sessions are usable without location
+		logrus.WithError(err).WithField("ip", ip).Warnln("Could not locate session IP.")
+		return buzza.GeoLocation{}
+	}
+	return location
+}
+
+func observeSession(observer buzza.SessionObserver, session buzza.Session) {
+	if observer == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := observer.SessionSeen(ctx, session); err != nil {
+			logrus.WithError(err).WithField("user_id", session.UserId).Errorln("Session observer failed.")
+		}
+	}()
+}
+
+func logSessionEvictions(ctx context.Context, activityStore buzza.ActivityStore, evicted []buzza.Session, limit int) error {
+	for _, session := range evicted {
+		logrus.WithFields(logrus.Fields{
+			"user_id":    session.UserId,
+			"session_id": session.Id,
+			"limit":      limit,
+		}).Infoln("Session evicted.")
+		activity := buzza.SessionEvictedActivity(session.Id, session.UserAgent, limit)
+		if err := activityStore.AddLog(ctx, session.UserId, activity); err != nil {
+			return fmt.Errorf("log session eviction: %w", err)
+		}
+	}
+	return nil
 }
 
 func (s *SessionStore) RegisterNew(ctx context.Context, userId buzza.UserId, ip string, userAgent string) (buzza.Session, error) {
@@ -54,64 +237,98 @@ func (s *SessionStore) RegisterNew(ctx context.Context, userId buzza.UserId, ip
 		return buzza.Session{}, fmt.Errorf("generate token: %s", err)
 	}
 	id := uuid.New().String()
+	location := s.locate(ip)
+	limit, err := s.sessionLimit(ctx, userId)
+	if err != nil {
+		return buzza.Session{}, fmt.Errorf("session limit: %w", err)
+	}
 
-	err = s.ActivityStore.AddLog(ctx, userId, buzza.Activity{Name: "session_created", Data: map[string]interface{}{
-		"ip":         ip,
-		"userAgent":  userAgent,
-		"session_id": id,
-	}})
+	activity := buzza.WithGeoLocation(buzza.SessionCreatedActivity(id, ip, userAgent), location)
+	err = s.ActivityStore.AddLog(ctx, userId, activity)
 	if err != nil {
 		return buzza.Session{}, fmt.Errorf("add session_created activity log: %s", err)
 	}
 
+	now := s.now()
 	session := Session{
 		Id:             id,
 		UserId:         int64(userId),
-		Token:          token,
+		TokenHash:      s.hash(token),
 		Ip:             ip,
 		UserAgent:      userAgent,
-		LastAccessedAt: time.Now().UTC(),
-		ExpiresAt:      time.Now().UTC().Add(sessionTTL),
-	}
-	serializedSession, err := json.Marshal(&session)
-	if err != nil {
-		return buzza.Session{}, fmt.Errorf("session serialize: %s", err)
+		Agent:          newAgent(userAgent),
+		Location:       newLocation(location),
+		CreatedAt:      now,
+		LastAccessedAt: now,
 	}
+	session.ExpiresAt = s.policy().ExpiresAt(session.ToDomain())
 
+	var evicted []buzza.Session
 	err = s.Buntdb.Update(func(tx *buntdb.Tx) error {
-		expireOptions := &buntdb.SetOptions{Expires: true, TTL: sessionTTL}
-
-		_, replaced, err := tx.Set("session_by_id:"+session.Id, session.Token, expireOptions)
-		if err != nil {
-			return fmt.Errorf("set map session id to auth token: %w", err)
-		}
-		if replaced {
+		if _, err := tx.Get("session_by_id:" + session.Id); err == nil {
 			return fmt.Errorf("rarest uuid collision '%s' (not possible)", session.Id)
 		}
+		if err := storeSession(tx, session, now); err != nil {
+			return err
+		}
+		if limit == 0 {
+			return nil
+		}
 
-		_, _, err = tx.Set("session:"+session.Token, string(serializedSession), expireOptions)
+		sessions, err := userSessions(tx, userId, now)
 		if err != nil {
-			return fmt.Errorf("set session: %w", err)
+			return fmt.Errorf("user sessions: %w", err)
+		}
+		// oldest first, new session is the youngest one
+		sort.SliceStable(sessions, func(i, j int) bool {
+			return sessions[i].createdAt().Before(sessions[j].createdAt())
+		})
+		for i := 0; i < len(sessions)-limit; i++ {
+			if sessions[i].Id == session.Id {
+				continue
+			}
+			if err := deleteSession(tx, sessions[i]); err != nil {
+				return fmt.Errorf("evict session: %w", err)
+			}
+			evicted = append(evicted, sessions[i].ToDomain())
 		}
 		return nil
 	})
 	if err != nil {
 		return buzza.Session{}, fmt.Errorf("bunt update: %s", err)
 	}
-	return session.ToDomain(), nil
+
+	if err := logSessionEvictions(ctx, s.ActivityStore, evicted, limit); err != nil {
+		return buzza.Session{}, err
+	}
+	s.observe(session.ToDomain())
+	created := session.ToDomain()
+	created.Token = token
+	return created, nil
+}
+
+// Creation time of the session, last access for sessions created before it was stored.
+func (s Session) createdAt() time.Time {
+	if s.CreatedAt.IsZero() {
+		return s.LastAccessedAt
+	}
+	return s.CreatedAt
+}
+
+func (s *SessionStore) locate(ip string) buzza.GeoLocation {
+	return locateSessionIp(s.Geo, ip)
+}
+
+func (s *SessionStore) observe(session buzza.Session) {
+	observeSession(s.Observer, session)
 }
 
 func (s *SessionStore) ByToken(token string) (buzza.Session, error) {
 	var session Session
 	err := s.Buntdb.View(func(tx *buntdb.Tx) error {
-		serializedSession, err := tx.Get("session:" + token)
-		if err != nil {
-			return fmt.Errorf("get serialized session: %w", err)
-		}
-		if err := json.Unmarshal([]byte(serializedSession), &session); err != nil {
-			return fmt.Errorf("deserialize session: %s", err)
-		}
-		return nil
+		var err error
+		session, err = getSession(tx, s.hash(token), s.now())
+		return err
 	})
 	if err != nil {
 		if errors.Is(err, buntdb.ErrNotFound) {
@@ -120,12 +337,14 @@ func (s *SessionStore) ByToken(token string) (buzza.Session, error) {
 			return buzza.Session{}, fmt.Errorf("buntdb view: %s", err)
 		}
 	}
-	return session.ToDomain(), err
+	found := session.ToDomain()
+	found.Token = token
+	return found, nil
 }
 
 func (s *SessionStore) Exists(token string) (bool, error) {
 	err := s.Buntdb.View(func(tx *buntdb.Tx) error {
-		_, err := tx.Get("session:" + token)
+		_, err := getSession(tx, s.hash(token), s.now())
 		return err
 	})
 	switch {
@@ -138,16 +357,50 @@ func (s *SessionStore) Exists(token string) (bool, error) {
 	}
 }
 
-func (s *SessionStore) activeSessions(tx *buntdb.Tx, token string) ([]buzza.Session, error) {
-	sessions := make([]buzza.Session, 0, 10)
+// Sessions of the user owning the token.
+func (s *SessionStore) activeSessions(tx *buntdb.Tx, token string) ([]Session, error) {
+	now := s.now()
+	session, err := getSession(tx, s.hash(token), now)
+	if err != nil {
+		return nil, fmt.Errorf("get session: %w", err)
+	}
+	return userSessions(tx, buzza.UserId(session.UserId), now)
+}
+
+// Session stored under the token hash, buntdb.ErrNotFound if there is none or it has expired at now.
+func getSession(tx *buntdb.Tx, tokenHash string, now time.Time) (Session, error) {
+	serializedSession, err := tx.Get("session:" + tokenHash)
+	if err != nil {
+		return Session{}, fmt.Errorf("get serialized session: %w", err)
+	}
+	var session Session
+	if err := json.Unmarshal([]byte(serializedSession), &session); err != nil {
+		return Session{}, fmt.Errorf("deserialize session: %w", err)
+	}
+	if session.expired(now) {
+		return Session{}, buntdb.ErrNotFound
+	}
+	return session, nil
+}
+
+// Sessions stored before expiry times were tracked expire only by their keys TTL.
+func (s Session) expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+func userSessions(tx *buntdb.Tx, userId buzza.UserId, now time.Time) ([]Session, error) {
+	sessions := make([]Session, 0, 10)
 	var listErr error
-	err := tx.Ascend("sessions", func(key, value string) bool {
+	pivot := fmt.Sprintf(`{"userId":%d}`, userId)
+	err := tx.AscendEqual("sessions_by_user", pivot, func(key, value string) bool {
 		var session Session
 		if err := json.Unmarshal([]byte(value), &session); err != nil {
 			listErr = fmt.Errorf("deserialize session: %s", err)
 			return false
 		}
-		sessions = append(sessions, session.ToDomain())
+		if !session.expired(now) {
+			sessions = append(sessions, session)
+		}
 		return true
 	})
 	if err != nil {
@@ -160,7 +413,7 @@ func (s *SessionStore) activeSessions(tx *buntdb.Tx, token string) ([]buzza.Sess
 }
 
 func (s *SessionStore) ActiveSessions(token string) ([]buzza.Session, error) {
-	sessions := make([]buzza.Session, 0, 10)
+	var sessions []Session
 	err := s.Buntdb.View(func(tx *buntdb.Tx) error {
 		var err error
 		sessions, err = s.activeSessions(tx, token)
@@ -176,22 +429,21 @@ func (s *SessionStore) ActiveSessions(token string) ([]buzza.Session, error) {
 			return nil, fmt.Errorf("buntdb view: %s", err)
 		}
 	}
-	return sessions, nil
+	mapped := make([]buzza.Session, len(sessions))
+	for i, session := range sessions {
+		mapped[i] = session.ToDomain()
+	}
+	return mapped, nil
 }
 
 func (s *SessionStore) AcquireAndRefresh(ctx context.Context, token string, ip string, userAgent string) (buzza.Session, error) {
 	var previousSession Session
 	var session Session
+	now := s.now()
 	err := s.Buntdb.View(func(tx *buntdb.Tx) error {
-		oldSerializedSession, err := tx.Get("session:" + token)
-		if err != nil {
-			return fmt.Errorf("get serialized session: %w", err)
-		}
-		err = json.Unmarshal([]byte(oldSerializedSession), &previousSession)
-		if err != nil {
-			return fmt.Errorf("deserialize session: %w", err)
-		}
-		return nil
+		var err error
+		previousSession, err = getSession(tx, s.hash(token), now)
+		return err
 	})
 	if err != nil {
 		if errors.Is(err, buntdb.ErrNotFound) {
@@ -201,42 +453,53 @@ func (s *SessionStore) AcquireAndRefresh(ctx context.Context, token string, ip s
 		}
 	}
 
+	policy := s.policy()
+	if !now.Before(policy.ExpiresAt(previousSession.ToDomain())) {
+		// policy is stricter than the TTL the session has been stored with
+		err := s.Buntdb.Update(func(tx *buntdb.Tx) error {
+			return deleteSession(tx, previousSession)
+		})
+		if err != nil && !errors.Is(err, buntdb.ErrNotFound) {
+			return buzza.Session{}, fmt.Errorf("delete expired session: %s", err)
+		}
+		return buzza.Session{}, buzza.ErrSessionNotFound
+	}
+
 	// copy session
 	session = previousSession
+	if session.CreatedAt.IsZero() {
+		// absolute lifetime of sessions created before it was stored starts now
+		session.CreatedAt = now
+	}
 	session.Ip = ip
 	session.UserAgent = userAgent
-	session.LastAccessedAt = time.Now().UTC()
-	session.ExpiresAt = time.Now().UTC().Add(sessionTTL)
-	serializedSession, err := json.Marshal(session)
-	if err != nil {
-		return buzza.Session{}, fmt.Errorf("serialize session: %s", err)
+	if previousSession.UserAgent != userAgent {
+		session.Agent = newAgent(userAgent)
+	}
+	var location buzza.GeoLocation
+	if previousSession.Ip != ip {
+		location = s.locate(ip)
+		session.Location = newLocation(location)
 	}
+	session.LastAccessedAt = now
+	session.ExpiresAt = policy.ExpiresAt(session.ToDomain())
 
 	err = s.Buntdb.Update(func(tx *buntdb.Tx) error {
-		serializedSessionStr := string(serializedSession)
-		_, _, err = tx.Set("session:"+token, serializedSessionStr, &buntdb.SetOptions{Expires: true, TTL: sessionTTL})
-		if err != nil {
+		if err := storeSession(tx, session, now); err != nil {
 			return fmt.Errorf("store session: %w", err)
 		}
 
 		// nwm nie podobaja mi sie te zapytania do db w tym locku buntowym
 		// todo moze jakas zmiana
 		if previousSession.Ip != session.Ip {
-			activity := buzza.Activity{Name: "session_changed_ip", Data: map[string]interface{}{
-				"session_id":  session.Id,
-				"previous_ip": previousSession.Ip,
-				"new_ip":      session.Ip,
-			}}
+			activity := buzza.WithGeoLocation(
+				buzza.SessionChangedIpActivity(session.Id, previousSession.Ip, session.Ip), location)
 			if err := s.ActivityStore.AddLog(ctx, buzza.UserId(session.UserId), activity); err != nil {
 				return fmt.Errorf("log ip change: %s", err)
 			}
 		}
 		if previousSession.UserAgent != session.UserAgent {
-			activity := buzza.Activity{Name: "session_changed_user_agent", Data: map[string]interface{}{
-				"session_id":          session.Id,
-				"previous_user_agent": previousSession.UserAgent,
-				"new_user_agent":      session.UserAgent,
-			}}
+			activity := buzza.SessionChangedUserAgentActivity(session.Id, previousSession.UserAgent, session.UserAgent)
 			if err := s.ActivityStore.AddLog(ctx, buzza.UserId(session.UserId), activity); err != nil {
 				return fmt.Errorf("log useragent change: %s", err)
 			}
@@ -246,60 +509,130 @@ func (s *SessionStore) AcquireAndRefresh(ctx context.Context, token string, ip s
 	if err != nil {
 		return buzza.Session{}, fmt.Errorf("refresh session in buntdb: %s", err)
 	}
-	return session.ToDomain(), nil
+	if previousSession.Ip != session.Ip || previousSession.UserAgent != session.UserAgent {
+		s.observe(session.ToDomain())
+	}
+	refreshed := session.ToDomain()
+	refreshed.Token = token
+	return refreshed, nil
 }
 
-func (s *SessionStore) InvalidateById(userId buzza.UserId, sessionId string) error {
+// Store the session and refresh expiration of its keys.
+func storeSession(tx *buntdb.Tx, session Session, now time.Time) error {
+	serializedSession, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("serialize session: %w", err)
+	}
+	expireOptions := &buntdb.SetOptions{Expires: true, TTL: session.ExpiresAt.Sub(now)}
+	_, _, err = tx.Set("session:"+session.TokenHash, string(serializedSession), expireOptions)
+	if err != nil {
+		return fmt.Errorf("set session: %w", err)
+	}
+	_, _, err = tx.Set("session_by_id:"+session.Id, session.TokenHash, expireOptions)
+	if err != nil {
+		return fmt.Errorf("set map session id to token hash: %w", err)
+	}
+	return nil
+}
+
+func deleteSession(tx *buntdb.Tx, session Session) error {
+	if _, err := tx.Delete("session:" + session.TokenHash); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	if _, err := tx.Delete("session_by_id:" + session.Id); err != nil {
+		return fmt.Errorf("delete session_by_id: %w", err)
+	}
+	return nil
+}
+
+func (s *SessionStore) UpdateById(ctx context.Context, userId buzza.UserId, sessionId string,
+	update buzza.SessionUpdate) (buzza.Session, error) {
+	var session Session
+	var trustChanged bool
+	now := s.now()
 	err := s.Buntdb.Update(func(tx *buntdb.Tx) error {
-		token, err := tx.Get("session_by_id:" + sessionId)
+		tokenHash, err := tx.Get("session_by_id:" + sessionId)
 		if err != nil {
 			return fmt.Errorf("get session by id: %w", err)
 		}
-		serializedSession, err := tx.Delete("session:" + token)
+		session, err = getSession(tx, tokenHash, now)
 		if err != nil {
-			return fmt.Errorf("delete session by auth token: %w", err)
+			return err
+		}
+		if buzza.UserId(session.UserId) != userId {
+			return buntdb.ErrNotFound
 		}
 
-		var session Session
-		err = json.Unmarshal([]byte(serializedSession), &session)
-		if err != nil {
-			return fmt.Errorf("deserialize session: %w", err)
+		if update.Name != nil {
+			session.Name = *update.Name
 		}
-		if userId != buzza.UserId(session.UserId) {
-			return fmt.Errorf("different user id (required: %d, found: %d)", userId, session.UserId)
+		if update.Pinned != nil {
+			session.Pinned = *update.Pinned
 		}
-
-		_, err = tx.Delete("session_by_id:" + sessionId)
-		if err != nil {
-			return fmt.Errorf("delete session by id: %w", err)
+		if update.Trusted != nil && *update.Trusted != session.Trusted {
+			trustChanged = true
+			session.Trusted = *update.Trusted
+			session.ExpiresAt = s.policy().ExpiresAt(session.ToDomain())
 		}
-		return err
+		return storeSession(tx, session, now)
 	})
 	if err != nil {
-		return fmt.Errorf("bunt update: %w", err)
+		if errors.Is(err, buntdb.ErrNotFound) {
+			return buzza.Session{}, buzza.ErrSessionNotFound
+		} else {
+			return buzza.Session{}, fmt.Errorf("bunt update: %w", err)
+		}
 	}
-	return nil
+
+	if trustChanged {
+		activity := buzza.SessionTrustChangedActivity(session.Id, session.UserAgent, session.Trusted)
+		if err := s.ActivityStore.AddLog(ctx, userId, activity); err != nil {
+			return buzza.Session{}, fmt.Errorf("log trust change: %w", err)
+		}
+	}
+	return session.ToDomain(), nil
 }
 
-func (s *SessionStore) InvalidateByAuthToken(authToken string) error {
+func (s *SessionStore) InvalidateById(userId buzza.UserId, sessionId string) error {
 	err := s.Buntdb.Update(func(tx *buntdb.Tx) error {
-		serializedSession, err := tx.Delete("session:" + authToken)
+		tokenHash, err := tx.Get("session_by_id:" + sessionId)
 		if err != nil {
-			return fmt.Errorf("delete session key: %w", err)
+			return fmt.Errorf("get session by id: %w", err)
 		}
-		var session Session
-		err = json.Unmarshal([]byte(serializedSession), &session)
+		session, err := getSession(tx, tokenHash, s.now())
 		if err != nil {
-			return fmt.Errorf("deserialize deleted session: %w", err)
+			return err
 		}
-		_, err = tx.Delete("session_by_id:" + session.Id)
+		if userId != buzza.UserId(session.UserId) {
+			// session of the other user is not revealed
+			return buntdb.ErrNotFound
+		}
+		return deleteSession(tx, session)
+	})
+	if err != nil {
+		if errors.Is(err, buntdb.ErrNotFound) {
+			return buzza.ErrSessionNotFound
+		} else {
+			return fmt.Errorf("bunt update: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SessionStore) InvalidateByAuthToken(authToken string) error {
+	err := s.Buntdb.Update(func(tx *buntdb.Tx) error {
+		session, err := getSession(tx, s.hash(authToken), s.now())
 		if err != nil {
-			return fmt.Errorf("delete session key: %w", err)
+			return err
 		}
-		return err
+		return deleteSession(tx, session)
 	})
 	if err != nil {
-		return fmt.Errorf("bunt update: %s", err)
+		if errors.Is(err, buntdb.ErrNotFound) {
+			return buzza.ErrSessionNotFound
+		} else {
+			return fmt.Errorf("bunt update: %s", err)
+		}
 	}
 	return nil
 }
@@ -310,26 +643,90 @@ func (s *SessionStore) InvalidateAllExpect(expectToken string) error {
 		if err != nil {
 			return fmt.Errorf("ascend sessions: %w", err)
 		}
+		expectHash := s.hash(expectToken)
 		for _, session := range sessions {
-			if session.Token == expectToken {
+			if session.TokenHash == expectHash {
 				continue
 			}
+			if err := deleteSession(tx, session); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, buntdb.ErrNotFound) {
+			return buzza.ErrSessionNotFound
+		} else {
+			return fmt.Errorf("bunt update: %s", err)
+		}
+	}
+	return nil
+}
+
+func (s *SessionStore) InvalidateByUserId(ctx context.Context, userId buzza.UserId) (int, error) {
+	invalidated := 0
+	err := s.Buntdb.Update(func(tx *buntdb.Tx) error {
+		sessions, err := userSessions(tx, userId, s.now())
+		if err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			if err := deleteSession(tx, session); err != nil {
+				return err
+			}
+		}
+		invalidated = len(sessions)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("bunt update: %w", err)
+	}
+	return invalidated, nil
+}
 
-			_, err = tx.Delete("session_by_id:" + session.Id)
-			if err != nil {
-				return fmt.Errorf("delete session_by_id: %w", err)
+// Move sessions stored before tokens were hashed under their token hashes.
+// Returns number of migrated sessions.
+func (s *SessionStore) HashLegacyTokens() (int, error) {
+	migrated := 0
+	err := s.Buntdb.Update(func(tx *buntdb.Tx) error {
+		var legacy []Session
+		var parseErr error
+		err := tx.AscendKeys("session:*", func(key, value string) bool {
+			var session Session
+			if err := json.Unmarshal([]byte(value), &session); err != nil {
+				parseErr = fmt.Errorf("deserialize session '%s': %w", key, err)
+				return false
 			}
-			_, err = tx.Delete("session:" + session.Token)
-			if err != nil {
-				return fmt.Errorf("delete session: %w", err)
+			if session.TokenHash == "" {
+				legacy = append(legacy, session)
 			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("ascend sessions: %w", err)
 		}
-		return err
+		if parseErr != nil {
+			return parseErr
+		}
+
+		for _, session := range legacy {
+			if _, err := tx.Delete("session:" + session.Token); err != nil {
+				return fmt.Errorf("delete plaintext token session: %w", err)
+			}
+			session.TokenHash = s.hash(session.Token)
+			session.Token = ""
+			if err := storeSession(tx, session, s.now()); err != nil {
+				return err
+			}
+			migrated++
+		}
+		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("bunt update: %s", err)
+		return 0, fmt.Errorf("bunt update: %w", err)
 	}
-	return nil
+	return migrated, nil
 }
 
 func generateSessionToken() (string, error) {
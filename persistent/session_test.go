@@ -2,10 +2,15 @@ package persistent
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/buzkaaclicker/buzza"
 	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/buzkaaclicker/buzza/mock"
+	"github.com/buzkaaclicker/buzza/storetest"
 	"github.com/stretchr/testify/assert"
 	"github.com/tidwall/buntdb"
 )
@@ -38,7 +43,7 @@ func TestSessionRegisterAndRefresh(t *testing.T) {
 	assert.Equal("192.168.0.101", session.Ip)
 	assert.Equal("Chrome/openBased", session.UserAgent)
 
-	logs, err := activityStore.ByUserId(ctx, session.UserId, -1, 100)
+	logs, err := activityStore.ByUserId(ctx, session.UserId, buzza.ActivityQuery{Limit: 100})
 	if !assert.NoError(err) {
 		return
 	}
@@ -53,7 +58,7 @@ func TestSessionRegisterAndRefresh(t *testing.T) {
 		if !assert.NoError(err) {
 			return
 		}
-		refreshedLogs, err := activityStore.ByUserId(ctx, session.UserId, -1, 100)
+		refreshedLogs, err := activityStore.ByUserId(ctx, session.UserId, buzza.ActivityQuery{Limit: 100})
 		if !assert.NoError(err) {
 			return
 		}
@@ -67,7 +72,7 @@ func TestSessionRegisterAndRefresh(t *testing.T) {
 		if !assert.NoError(err) {
 			return
 		}
-		refreshedLogs, err := activityStore.ByUserId(ctx, session.UserId, -1, 100)
+		refreshedLogs, err := activityStore.ByUserId(ctx, session.UserId, buzza.ActivityQuery{Limit: 100})
 		if !assert.NoError(err) {
 			return
 		}
@@ -87,7 +92,7 @@ func TestSessionRegisterAndRefresh(t *testing.T) {
 		if !assert.NoError(err) {
 			return
 		}
-		refreshedLogs, err := activityStore.ByUserId(ctx, session.UserId, -1, 100)
+		refreshedLogs, err := activityStore.ByUserId(ctx, session.UserId, buzza.ActivityQuery{Limit: 100})
 		if !assert.NoError(err) {
 			return
 		}
@@ -110,3 +115,355 @@ func Test_GenerateSessionTokenLength(t *testing.T) {
 		assert.True(len(token) > 20)
 	}
 }
+
+func TestSessionObserver(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	bdb, err := buntdb.Open(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	defer bdb.Close()
+
+	seen := make(chan buzza.Session, 10)
+	activityStore := inmem.NewActivityStore()
+	sessionStore := &SessionStore{Buntdb: bdb, ActivityStore: &activityStore, Observer: mock.SessionObserver{
+		SessionSeenFn: func(ctx context.Context, session buzza.Session) error {
+			seen <- session
+			return nil
+		},
+	}}
+	sessionStore.CreateIndexes()
+	awaitSeen := func() (buzza.Session, bool) {
+		select {
+		case session := <-seen:
+			return session, true
+		case <-time.After(5 * time.Second):
+			return buzza.Session{}, false
+		}
+	}
+
+	session, err := sessionStore.RegisterNew(ctx, 5, "192.168.0.101", "Chrome")
+	if !assert.NoError(err) {
+		return
+	}
+	if observed, ok := awaitSeen(); assert.True(ok) {
+		assert.Equal(session.Id, observed.Id)
+	}
+
+	_, err = sessionStore.AcquireAndRefresh(ctx, session.Token, "192.168.0.101", "Chrome")
+	assert.NoError(err)
+	_, err = sessionStore.AcquireAndRefresh(ctx, session.Token, "192.168.0.102", "Chrome")
+	assert.NoError(err)
+	// unchanged session refresh is not observed
+	if observed, ok := awaitSeen(); assert.True(ok) {
+		assert.Equal("192.168.0.102", observed.Ip)
+	}
+	assert.Len(seen, 0)
+}
+
+func TestSessionGeoLocation(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	bdb, err := buntdb.Open(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	defer bdb.Close()
+
+	activityStore := inmem.NewActivityStore()
+	sessionStore := &SessionStore{Buntdb: bdb, ActivityStore: &activityStore, Geo: mock.GeoLocator{
+		LocateFn: func(ip string) (buzza.GeoLocation, error) {
+			switch ip {
+			case "31.0.0.1":
+				return buzza.GeoLocation{CountryCode: "PL", Country: "Poland", City: "Warsaw", Asn: 5617}, nil
+			case "invalid":
+				return buzza.GeoLocation{}, errors.New("invalid ip")
+			default:
+				return buzza.GeoLocation{}, nil
+			}
+		},
+	}}
+	sessionStore.CreateIndexes()
+
+	session, err := sessionStore.RegisterNew(ctx, 5, "31.0.0.1", "Chrome")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("Warsaw", session.Location.City)
+	stored, err := sessionStore.ByToken(session.Token)
+	if assert.NoError(err) {
+		assert.Equal(session.Location, stored.Location)
+	}
+	logs, err := activityStore.ByUserId(ctx, 5, buzza.ActivityQuery{Limit: 10})
+	if assert.NoError(err) && assert.Len(logs, 1) {
+		assert.Equal("PL", logs[0].Data["country"])
+		assert.Equal("Warsaw", logs[0].Data["city"])
+	}
+
+	// unknown and failed locations are not stored
+	for _, ip := range []string{"10.0.0.1", "invalid"} {
+		refreshed, err := sessionStore.AcquireAndRefresh(ctx, session.Token, ip, "Chrome")
+		if assert.NoError(err) {
+			assert.True(refreshed.Location.IsZero())
+		}
+	}
+	logs, err = activityStore.ByUserId(ctx, 5, buzza.ActivityQuery{Limit: 10})
+	if assert.NoError(err) && assert.Len(logs, 3) {
+		for _, log := range logs[:2] {
+			assert.Equal(buzza.ActivitySessionChangedIp, log.Name)
+			assert.NotContains(log.Data, "country")
+		}
+	}
+}
+
+func TestSessionUserAgent(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	bdb, err := buntdb.Open(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	defer bdb.Close()
+
+	activityStore := inmem.NewActivityStore()
+	activityStore.Catalogue = buzza.DefaultActivityCatalogue
+	sessionStore := &SessionStore{Buntdb: bdb, ActivityStore: &activityStore}
+	sessionStore.CreateIndexes()
+
+	session, err := sessionStore.RegisterNew(ctx, 5, "192.168.0.101", "BuzkaaClicker/1.4.2 (beta; windows; amd64)")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(buzza.UserAgentInfo{Client: "BuzkaaClicker", ClientVersion: "1.4.2", Branch: "beta",
+		OS: "Windows", Device: buzza.DeviceDesktop}, session.Agent)
+
+	const firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	_, err = sessionStore.AcquireAndRefresh(ctx, session.Token, "192.168.0.101", firefox)
+	assert.NoError(err)
+	stored, err := sessionStore.ByToken(session.Token)
+	if assert.NoError(err) {
+		assert.Equal("Firefox on Linux", stored.Agent.String())
+	}
+
+	logs, err := activityStore.ByUserId(ctx, 5, buzza.ActivityQuery{Limit: 10})
+	if assert.NoError(err) && assert.Len(logs, 2) {
+		assert.Equal("BuzkaaClicker 1.4.2 (beta) on Windows", logs[0].Data["previous_device"])
+		assert.Equal("Firefox on Linux", logs[0].Data["new_device"])
+	}
+
+	// sessions stored before parsing was added
+	expiresAt := time.Now().UTC().Add(time.Hour).Format(time.RFC3339Nano)
+	err = bdb.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set("session:legacy", `{"id":"legacy","userId":5,"token":"legacy","userAgent":"`+firefox+
+			`","expiresAt":"`+expiresAt+`"}`, nil)
+		return err
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	if _, err := sessionStore.HashLegacyTokens(); !assert.NoError(err) {
+		return
+	}
+	legacy, err := sessionStore.ByToken("legacy")
+	if assert.NoError(err) {
+		assert.Equal("Firefox", legacy.Agent.Client)
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	bdb, err := buntdb.Open(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	defer bdb.Close()
+
+	activityStore := inmem.NewActivityStore()
+	activityStore.Catalogue = buzza.DefaultActivityCatalogue
+	policy := buzza.DefaultSessionPolicy
+	policy.MaxSessions = 2
+	sessionStore := &SessionStore{Buntdb: bdb, ActivityStore: &activityStore, Policy: policy,
+		Users: mock.UserStore{ByIdFn: func(ctx context.Context, userId buzza.UserId) (buzza.User, error) {
+			if userId == 6 {
+				return buzza.User{Id: userId, Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}}, nil
+			}
+			return buzza.User{Id: userId}, nil
+		}}}
+	sessionStore.CreateIndexes()
+
+	var sessions []buzza.Session
+	for _, userAgent := range []string{"Firefox", "Chrome", "Safari"} {
+		session, err := sessionStore.RegisterNew(ctx, 5, "192.168.0.101", userAgent)
+		if !assert.NoError(err) {
+			return
+		}
+		sessions = append(sessions, session)
+	}
+	// other user sessions are neither listed nor counted
+	otherSession, err := sessionStore.RegisterNew(ctx, 6, "192.168.0.102", "Chrome")
+	if !assert.NoError(err) {
+		return
+	}
+
+	active, err := sessionStore.ActiveSessions(sessions[2].Token)
+	if assert.NoError(err) && assert.Len(active, 2) {
+		ids := []string{active[0].Id, active[1].Id}
+		assert.ElementsMatch([]string{sessions[1].Id, sessions[2].Id}, ids, "oldest session should be evicted")
+	}
+	exists, err := sessionStore.Exists(sessions[0].Token)
+	if assert.NoError(err) {
+		assert.False(exists)
+	}
+	logs, err := activityStore.ByUserId(ctx, 5, buzza.ActivityQuery{Names: []string{buzza.ActivitySessionEvicted}, Limit: 10})
+	if assert.NoError(err) && assert.Len(logs, 1) {
+		assert.Equal(sessions[0].Id, logs[0].Data["session_id"])
+		assert.Equal("Firefox", logs[0].Data["device"])
+	}
+
+	assert.NoError(sessionStore.InvalidateAllExpect(sessions[2].Token))
+	exists, err = sessionStore.Exists(otherSession.Token)
+	if assert.NoError(err) {
+		assert.True(exists, "other user sessions should not be invalidated")
+	}
+	active, err = sessionStore.ActiveSessions(sessions[2].Token)
+	if assert.NoError(err) && assert.Len(active, 1) {
+		assert.Equal(sessions[2].Id, active[0].Id)
+	}
+
+	// admin limit is stricter than the default one
+	for i := 0; i < 3; i++ {
+		_, err := sessionStore.RegisterNew(ctx, 6, "192.168.0.102", "Chrome")
+		assert.NoError(err)
+	}
+	active, err = sessionStore.ActiveSessions(otherSession.Token)
+	assert.ErrorIs(err, buzza.ErrSessionNotFound, "oldest admin session should be evicted")
+}
+
+func TestSessionMaxAge(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	bdb, err := buntdb.Open(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	defer bdb.Close()
+
+	activityStore := inmem.NewActivityStore()
+	policy := buzza.DefaultSessionPolicy
+	policy.MaxAge = 50 * time.Millisecond
+	sessionStore := &SessionStore{Buntdb: bdb, ActivityStore: &activityStore, Policy: policy}
+	sessionStore.CreateIndexes()
+
+	session, err := sessionStore.RegisterNew(ctx, 5, "192.168.0.101", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.WithinDuration(session.CreatedAt.Add(policy.MaxAge), session.ExpiresAt, time.Millisecond)
+	refreshed, err := sessionStore.AcquireAndRefresh(ctx, session.Token, "192.168.0.101", "Firefox")
+	if assert.NoError(err) {
+		assert.Equal(session.ExpiresAt, refreshed.ExpiresAt, "refresh should not extend max age")
+	}
+
+	time.Sleep(policy.MaxAge)
+	_, err = sessionStore.AcquireAndRefresh(ctx, session.Token, "192.168.0.101", "Firefox")
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+}
+
+func TestSessionStoreConformance(t *testing.T) {
+	clock, advance := storetest.NewClock()
+	storetest.RunSessionStoreTests(t, func(activityStore buzza.ActivityStore, policy buzza.SessionPolicy) buzza.SessionStore {
+		bdb, err := buntdb.Open(":memory:")
+		if err != nil {
+			panic(err)
+		}
+		t.Cleanup(func() { bdb.Close() })
+		store := &SessionStore{Buntdb: bdb, ActivityStore: activityStore, Policy: policy, Now: clock}
+		store.CreateIndexes()
+		return store
+	}, advance)
+}
+
+func TestSessionTokenHashing(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	bdb, err := buntdb.Open(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	defer bdb.Close()
+
+	activityStore := inmem.NewActivityStore()
+	sessionStore := &SessionStore{Buntdb: bdb, ActivityStore: &activityStore, TokenSecret: []byte("secret")}
+	sessionStore.CreateIndexes()
+
+	session, err := sessionStore.RegisterNew(ctx, 5, "192.168.0.101", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NotEmpty(session.Token)
+
+	// sessions stored before tokens were hashed
+	legacy := Session{Id: "legacy-id", UserId: 5, Token: "legacy-token", UserAgent: "Chrome",
+		CreatedAt: time.Now().UTC(), LastAccessedAt: time.Now().UTC(), ExpiresAt: time.Now().UTC().Add(time.Hour)}
+	err = bdb.Update(func(tx *buntdb.Tx) error {
+		data, err := json.Marshal(legacy)
+		if err != nil {
+			return err
+		}
+		opts := &buntdb.SetOptions{Expires: true, TTL: time.Hour}
+		if _, _, err := tx.Set("session:"+legacy.Token, string(data), opts); err != nil {
+			return err
+		}
+		_, _, err = tx.Set("session_by_id:"+legacy.Id, legacy.Token, opts)
+		return err
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	migrated, err := sessionStore.HashLegacyTokens()
+	if assert.NoError(err) {
+		assert.Equal(1, migrated)
+	}
+	migrated, err = sessionStore.HashLegacyTokens()
+	if assert.NoError(err) {
+		assert.Zero(migrated)
+	}
+
+	// tokens are not readable from the database
+	err = bdb.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("", func(key, value string) bool {
+			for _, token := range []string{session.Token, legacy.Token} {
+				assert.NotContains(key, token)
+				assert.NotContains(value, token)
+			}
+			return true
+		})
+	})
+	assert.NoError(err)
+
+	byToken, err := sessionStore.ByToken(legacy.Token)
+	if assert.NoError(err) {
+		assert.Equal(legacy.Id, byToken.Id)
+	}
+	active, err := sessionStore.ActiveSessions(session.Token)
+	if assert.NoError(err) && assert.Len(active, 2) {
+		for _, s := range active {
+			assert.Empty(s.Token)
+		}
+	}
+	assert.NoError(sessionStore.InvalidateById(5, legacy.Id))
+
+	// other secret does not match stored hashes
+	otherStore := &SessionStore{Buntdb: bdb, ActivityStore: &activityStore, TokenSecret: []byte("other")}
+	_, err = otherStore.ByToken(session.Token)
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+}
@@ -0,0 +1,285 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+type SharedConfig struct {
+	bun.BaseModel `bun:"table:shared_config"`
+
+	Id             int64     `bun:",pk,autoincrement"`
+	CreatedAt      time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	AuthorId       int64     `bun:",notnull"`
+	Author         *Profile  `bun:"rel:belongs-to,join:author_id=user_id"`
+	Title          string    `bun:",notnull"`
+	Description    string    `bun:",notnull"`
+	Tags           []string  `bun:",notnull,array"`
+	Data           string    `bun:",notnull,type:jsonb"`
+	Downloads      int64     `bun:",notnull,default:0"`
+	RatingCount    int64     `bun:",notnull,default:0"`
+	RatingSum      int64     `bun:",notnull,default:0"`
+	TakenDownAt    time.Time `bun:",nullzero"`
+	TakedownReason string
+}
+
+func (c SharedConfig) ToDomain() buzza.SharedConfig {
+	author := buzza.Profile{User: buzza.User{Id: buzza.UserId(c.AuthorId)}}
+	if c.Author != nil {
+		author = c.Author.ToDomain()
+	}
+	var ratingAverage float64
+	if c.RatingCount > 0 {
+		ratingAverage = float64(c.RatingSum) / float64(c.RatingCount)
+	}
+	return buzza.SharedConfig{
+		Id:            c.Id,
+		Author:        author,
+		Title:         c.Title,
+		Description:   c.Description,
+		Tags:          c.Tags,
+		Data:          json.RawMessage(c.Data),
+		Downloads:     c.Downloads,
+		RatingCount:   c.RatingCount,
+		RatingAverage: ratingAverage,
+		CreatedAt:     c.CreatedAt,
+	}
+}
+
+type SharedConfigRating struct {
+	bun.BaseModel `bun:"table:shared_config_rating"`
+
+	SharedConfigId int64     `bun:",pk"`
+	UserId         int64     `bun:",pk"`
+	Rating         int       `bun:",notnull"`
+	CreatedAt      time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
+
+type SharedConfigReport struct {
+	bun.BaseModel `bun:"table:shared_config_report"`
+
+	Id             int64     `bun:",pk,autoincrement"`
+	CreatedAt      time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	SharedConfigId int64     `bun:",notnull,unique:config_reporter"`
+	ReporterId     int64     `bun:",notnull,unique:config_reporter"`
+	Reason         string    `bun:",notnull"`
+}
+
+func (r SharedConfigReport) ToDomain() buzza.SharedConfigReport {
+	return buzza.SharedConfigReport{
+		Id:             r.Id,
+		SharedConfigId: r.SharedConfigId,
+		ReporterId:     buzza.UserId(r.ReporterId),
+		Reason:         r.Reason,
+		CreatedAt:      r.CreatedAt,
+	}
+}
+
+type SharedConfigStore struct {
+	DB *bun.DB
+}
+
+var _ buzza.SharedConfigStore = (*SharedConfigStore)(nil)
+
+func (s *SharedConfigStore) Publish(ctx context.Context, authorId buzza.UserId,
+	meta buzza.SharedConfigMeta, data json.RawMessage) (buzza.SharedConfig, error) {
+	tags := meta.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	config := &SharedConfig{
+		AuthorId:    int64(authorId),
+		Title:       meta.Title,
+		Description: meta.Description,
+		Tags:        tags,
+		Data:        string(data),
+	}
+	_, err := s.DB.NewInsert().
+		Model(config).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return buzza.SharedConfig{}, fmt.Errorf("insert shared config: %w", err)
+	}
+	return s.ById(ctx, config.Id)
+}
+
+func (s *SharedConfigStore) ById(ctx context.Context, id int64) (buzza.SharedConfig, error) {
+	config := new(SharedConfig)
+	err := s.DB.NewSelect().
+		Model(config).
+		Relation("Author").
+		Where("shared_config.id=? AND shared_config.taken_down_at IS NULL", id).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.SharedConfig{}, buzza.ErrSharedConfigNotFound
+		}
+		return buzza.SharedConfig{}, fmt.Errorf("select shared config: %w", err)
+	}
+	return config.ToDomain(), nil
+}
+
+func (s *SharedConfigStore) Search(ctx context.Context, query buzza.SharedConfigQuery) ([]buzza.SharedConfig, int, error) {
+	var configs []SharedConfig
+	q := s.DB.NewSelect().
+		Model(&configs).
+		Relation("Author").
+		Where("shared_config.taken_down_at IS NULL")
+	if query.Search != "" {
+		pattern := "%" + escapeLikePattern(query.Search) + "%"
+		q = q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("shared_config.title ILIKE ?", pattern).
+				WhereOr("shared_config.description ILIKE ?", pattern)
+		})
+	}
+	if len(query.Tags) > 0 {
+		q = q.Where("shared_config.tags @> ?", pgdialect.Array(query.Tags))
+	}
+	switch query.Sort {
+	case buzza.SharedConfigSortDownloads:
+		q = q.OrderExpr("shared_config.downloads DESC")
+	case buzza.SharedConfigSortRating:
+		q = q.OrderExpr("shared_config.rating_sum::float / NULLIF(shared_config.rating_count, 0) DESC NULLS LAST").
+			OrderExpr("shared_config.rating_count DESC")
+	default:
+		q = q.OrderExpr("shared_config.id DESC")
+	}
+
+	total, err := q.
+		Offset(query.Offset).
+		Limit(query.Limit).
+		ScanAndCount(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.SharedConfig, len(configs))
+	for i, c := range configs {
+		mapped[i] = c.ToDomain()
+	}
+	return mapped, total, nil
+}
+
+func (s *SharedConfigStore) Download(ctx context.Context, id int64) (buzza.SharedConfig, error) {
+	res, err := s.DB.NewUpdate().
+		Model((*SharedConfig)(nil)).
+		Set("downloads=downloads+1").
+		Where("id=? AND taken_down_at IS NULL", id).
+		Exec(ctx)
+	if err != nil {
+		return buzza.SharedConfig{}, fmt.Errorf("increment downloads: %w", err)
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return buzza.SharedConfig{}, buzza.ErrSharedConfigNotFound
+	}
+	return s.ById(ctx, id)
+}
+
+func (s *SharedConfigStore) Rate(ctx context.Context, id int64, userId buzza.UserId, rating int) error {
+	if rating < buzza.MinSharedConfigRating || rating > buzza.MaxSharedConfigRating {
+		return fmt.Errorf("invalid rating %d", rating)
+	}
+	return s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		config := new(SharedConfig)
+		err := tx.NewSelect().
+			Model(config).
+			Column("id", "author_id").
+			Where("id=? AND taken_down_at IS NULL", id).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return buzza.ErrSharedConfigNotFound
+			}
+			return fmt.Errorf("select shared config: %w", err)
+		}
+		if config.AuthorId == int64(userId) {
+			return buzza.ErrSelfRating
+		}
+
+		_, err = tx.NewInsert().
+			Model(&SharedConfigRating{SharedConfigId: id, UserId: int64(userId), Rating: rating}).
+			On("CONFLICT (shared_config_id, user_id) DO UPDATE SET rating=EXCLUDED.rating").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("upsert rating: %w", err)
+		}
+
+		// ratings are denormalized to make sorting by rating cheap
+		_, err = tx.NewUpdate().
+			Model((*SharedConfig)(nil)).
+			Set("rating_count=(SELECT count(*) FROM shared_config_rating WHERE shared_config_id=?)", id).
+			Set("rating_sum=(SELECT coalesce(sum(rating), 0) FROM shared_config_rating WHERE shared_config_id=?)", id).
+			Where("id=?", id).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("update rating summary: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *SharedConfigStore) Report(ctx context.Context, id int64, reporterId buzza.UserId, reason string) error {
+	if _, err := s.ById(ctx, id); err != nil {
+		return err
+	}
+	_, err := s.DB.NewInsert().
+		Model(&SharedConfigReport{SharedConfigId: id, ReporterId: int64(reporterId), Reason: reason}).
+		On("CONFLICT (shared_config_id, reporter_id) DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("insert report: %w", err)
+	}
+	return nil
+}
+
+func (s *SharedConfigStore) Reports(ctx context.Context, offset int, limit int) ([]buzza.SharedConfigReport, error) {
+	var reports []SharedConfigReport
+	err := s.DB.NewSelect().
+		Model(&reports).
+		Join("JOIN shared_config AS sc ON sc.id = shared_config_report.shared_config_id").
+		Where("sc.taken_down_at IS NULL").
+		OrderExpr("shared_config_report.id DESC").
+		Offset(offset).
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	mapped := make([]buzza.SharedConfigReport, len(reports))
+	for i, r := range reports {
+		mapped[i] = r.ToDomain()
+	}
+	return mapped, nil
+}
+
+func (s *SharedConfigStore) TakeDown(ctx context.Context, id int64, reason string) error {
+	res, err := s.DB.NewUpdate().
+		Model((*SharedConfig)(nil)).
+		Set("taken_down_at=?", time.Now().UTC()).
+		Set("takedown_reason=?", reason).
+		Where("id=? AND taken_down_at IS NULL", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("update shared config: %w", err)
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return buzza.ErrSharedConfigNotFound
+	}
+	return nil
+}
+
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,93 @@
+package persistent
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedConfigStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	author := &User{RolesNames: []buzza.RoleId{}}
+	if _, err := db.NewInsert().Model(author).Exec(ctx); !assert.NoError(err) {
+		return
+	}
+	profile := &Profile{UserId: author.Id, Name: "autor"}
+	if _, err := db.NewInsert().Model(profile).Exec(ctx); !assert.NoError(err) {
+		return
+	}
+
+	store := &SharedConfigStore{DB: db}
+	butterfly, err := store.Publish(ctx, buzza.UserId(author.Id),
+		buzza.SharedConfigMeta{Title: "Butterfly 100%", Description: "fast", Tags: []string{"pvp", "butterfly"}},
+		json.RawMessage(`{"cps":20}`))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("autor", butterfly.Author.Name)
+	assert.Equal(buzza.UserId(author.Id), butterfly.Author.User.Id)
+	jitter, err := store.Publish(ctx, buzza.UserId(author.Id),
+		buzza.SharedConfigMeta{Title: "Jitter", Tags: []string{"pvp"}}, json.RawMessage(`{"cps":12}`))
+	if !assert.NoError(err) {
+		return
+	}
+
+	configs, total, err := store.Search(ctx, buzza.SharedConfigQuery{Search: "100%", Limit: 10})
+	if assert.NoError(err) && assert.Len(configs, 1) {
+		assert.Equal(1, total)
+		assert.Equal(butterfly.Id, configs[0].Id)
+	}
+	configs, total, err = store.Search(ctx, buzza.SharedConfigQuery{Tags: []string{"pvp"}, Limit: 1})
+	if assert.NoError(err) && assert.Len(configs, 1) {
+		assert.Equal(2, total)
+		assert.Equal(jitter.Id, configs[0].Id)
+	}
+
+	downloaded, err := store.Download(ctx, butterfly.Id)
+	if assert.NoError(err) {
+		assert.Equal(int64(1), downloaded.Downloads)
+		assert.JSONEq(`{"cps":20}`, string(downloaded.Data))
+	}
+	configs, _, err = store.Search(ctx, buzza.SharedConfigQuery{Sort: buzza.SharedConfigSortDownloads, Limit: 1})
+	if assert.NoError(err) && assert.Len(configs, 1) {
+		assert.Equal(butterfly.Id, configs[0].Id)
+	}
+
+	assert.ErrorIs(store.Rate(ctx, jitter.Id, buzza.UserId(author.Id), 5), buzza.ErrSelfRating)
+	assert.NoError(store.Rate(ctx, jitter.Id, 90001, 1))
+	assert.NoError(store.Rate(ctx, jitter.Id, 90001, 5))
+	assert.NoError(store.Rate(ctx, jitter.Id, 90002, 4))
+	rated, err := store.ById(ctx, jitter.Id)
+	if assert.NoError(err) {
+		assert.Equal(int64(2), rated.RatingCount)
+		assert.Equal(4.5, rated.RatingAverage)
+	}
+
+	assert.NoError(store.Report(ctx, jitter.Id, 90001, "stolen"))
+	assert.NoError(store.Report(ctx, jitter.Id, 90001, "stolen again"))
+	reports, err := store.Reports(ctx, 0, 100)
+	if assert.NoError(err) && assert.Len(reports, 1) {
+		assert.Equal("stolen", reports[0].Reason)
+	}
+
+	assert.NoError(store.TakeDown(ctx, jitter.Id, "stolen"))
+	assert.ErrorIs(store.TakeDown(ctx, jitter.Id, "stolen"), buzza.ErrSharedConfigNotFound)
+	_, err = store.ById(ctx, jitter.Id)
+	assert.ErrorIs(err, buzza.ErrSharedConfigNotFound)
+	reports, err = store.Reports(ctx, 0, 100)
+	if assert.NoError(err) {
+		assert.Len(reports, 0)
+	}
+}
@@ -0,0 +1,32 @@
+package persistent
+
+import "github.com/buzkaaclicker/buzza"
+
+// Targeting stored as jsonb column.
+type Targeting struct {
+	Branches   []string       `json:"branches,omitempty"`
+	OS         []string       `json:"os,omitempty"`
+	Roles      []buzza.RoleId `json:"roles,omitempty"`
+	MinVersion string         `json:"minVersion,omitempty"`
+	MaxVersion string         `json:"maxVersion,omitempty"`
+}
+
+func newTargeting(t buzza.Targeting) Targeting {
+	return Targeting{
+		Branches:   t.Branches,
+		OS:         t.OS,
+		Roles:      t.Roles,
+		MinVersion: t.MinVersion,
+		MaxVersion: t.MaxVersion,
+	}
+}
+
+func (t Targeting) ToDomain() buzza.Targeting {
+	return buzza.Targeting{
+		Branches:   t.Branches,
+		OS:         t.OS,
+		Roles:      t.Roles,
+		MinVersion: t.MinVersion,
+		MaxVersion: t.MaxVersion,
+	}
+}
@@ -0,0 +1,183 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/uptrace/bun"
+)
+
+// Label binding sealed TOTP secrets to their column.
+const twoFactorSecretLabel = "two_factor.secret"
+
+type TwoFactor struct {
+	bun.BaseModel `bun:"table:two_factor"`
+
+	UserId             int64     `bun:",pk"`
+	Secret             string    `bun:",notnull"`
+	Enabled            bool      `bun:",notnull"`
+	LastStep           int64     `bun:",notnull"`
+	RecoveryCodeHashes []string  `bun:",notnull,array"`
+	FailedAttempts     int       `bun:",notnull"`
+	LastFailureAt      time.Time `bun:",nullzero"`
+	EnabledAt          time.Time `bun:",nullzero"`
+}
+
+func (t TwoFactor) ToDomain() buzza.TwoFactor {
+	return buzza.TwoFactor{
+		UserId:             buzza.UserId(t.UserId),
+		Secret:             t.Secret,
+		Enabled:            t.Enabled,
+		LastStep:           t.LastStep,
+		RecoveryCodeHashes: t.RecoveryCodeHashes,
+		FailedAttempts:     t.FailedAttempts,
+		LastFailureAt:      t.LastFailureAt,
+		EnabledAt:          t.EnabledAt,
+	}
+}
+
+type TwoFactorStore struct {
+	DB *bun.DB
+	// Encrypts TOTP secrets at rest. Secrets are stored in plaintext if nil.
+	Keyring buzza.Keyring
+}
+
+var _ buzza.TwoFactorStore = (*TwoFactorStore)(nil)
+
+func (s *TwoFactorStore) ByUserId(ctx context.Context, userId buzza.UserId) (buzza.TwoFactor, error) {
+	twoFactor := new(TwoFactor)
+	err := s.DB.NewSelect().
+		Model(twoFactor).
+		Where("user_id=?", userId).
+		Scan(ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.TwoFactor{}, buzza.ErrTwoFactorNotFound
+		}
+		return buzza.TwoFactor{}, fmt.Errorf("select two factor: %w", err)
+	}
+	if s.Keyring != nil {
+		twoFactor.Secret, err = buzza.OpenSealedValue(s.Keyring, twoFactorSecretLabel, twoFactor.Secret)
+		if err != nil {
+			return buzza.TwoFactor{}, fmt.Errorf("open secret: %w", err)
+		}
+	} else if buzza.SealedValueKeyId(twoFactor.Secret) != "" {
+		return buzza.TwoFactor{}, errors.New("two factor secret is sealed but keyring is not set")
+	}
+	return twoFactor.ToDomain(), nil
+}
+
+func (s *TwoFactorStore) Save(ctx context.Context, twoFactor buzza.TwoFactor) error {
+	secret := twoFactor.Secret
+	if s.Keyring != nil {
+		var err error
+		secret, err = buzza.SealValue(s.Keyring, twoFactorSecretLabel, secret)
+		if err != nil {
+			return fmt.Errorf("seal secret: %w", err)
+		}
+	}
+	recoveryCodeHashes := twoFactor.RecoveryCodeHashes
+	if recoveryCodeHashes == nil {
+		recoveryCodeHashes = []string{}
+	}
+	_, err := s.DB.NewInsert().
+		Model(&TwoFactor{
+			UserId:             int64(twoFactor.UserId),
+			Secret:             secret,
+			Enabled:            twoFactor.Enabled,
+			LastStep:           twoFactor.LastStep,
+			RecoveryCodeHashes: recoveryCodeHashes,
+			FailedAttempts:     twoFactor.FailedAttempts,
+			LastFailureAt:      twoFactor.LastFailureAt,
+			EnabledAt:          twoFactor.EnabledAt,
+		}).
+		On("CONFLICT (user_id) DO UPDATE").
+		Set("secret=EXCLUDED.secret").
+		Set("enabled=EXCLUDED.enabled").
+		Set("last_step=EXCLUDED.last_step").
+		Set("recovery_code_hashes=EXCLUDED.recovery_code_hashes").
+		Set("failed_attempts=EXCLUDED.failed_attempts").
+		Set("last_failure_at=EXCLUDED.last_failure_at").
+		Set("enabled_at=EXCLUDED.enabled_at").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("upsert two factor: %w", err)
+	}
+	return nil
+}
+
+func (s *TwoFactorStore) Delete(ctx context.Context, userId buzza.UserId) error {
+	res, err := s.DB.NewDelete().
+		Model((*TwoFactor)(nil)).
+		Where("user_id=?", userId).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("delete two factor: %w", err)
+	}
+	return twoFactorAffected(res, buzza.ErrTwoFactorNotFound)
+}
+
+func (s *TwoFactorStore) UseStep(ctx context.Context, userId buzza.UserId, step int64) error {
+	// the step condition makes concurrent uses of the same code fail
+	res, err := s.DB.NewUpdate().
+		Model((*TwoFactor)(nil)).
+		Set("last_step=?", step).
+		Set("failed_attempts=0").
+		Where("user_id=?", userId).
+		Where("last_step < ?", step).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("update last step: %w", err)
+	}
+	return twoFactorAffected(res, buzza.ErrInvalidTwoFactorCode)
+}
+
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userId buzza.UserId, codeHash string) (int, error) {
+	twoFactor := new(TwoFactor)
+	res, err := s.DB.NewUpdate().
+		Model(twoFactor).
+		Set("recovery_code_hashes=array_remove(recovery_code_hashes, ?)", codeHash).
+		Set("failed_attempts=0").
+		Where("user_id=?", userId).
+		Where("? = ANY(recovery_code_hashes)", codeHash).
+		Returning("recovery_code_hashes").
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("remove recovery code: %w", err)
+	}
+	if err := twoFactorAffected(res, buzza.ErrInvalidTwoFactorCode); err != nil {
+		return 0, err
+	}
+	return len(twoFactor.RecoveryCodeHashes), nil
+}
+
+func (s *TwoFactorStore) ReserveAttempt(ctx context.Context, userId buzza.UserId, at time.Time) error {
+	// the lockout condition is checked by the same statement, so concurrent attempts are counted one by one
+	res, err := s.DB.NewUpdate().
+		Model((*TwoFactor)(nil)).
+		Set("failed_attempts=failed_attempts+1").
+		Set("last_failure_at=?", at).
+		Where("user_id=?", userId).
+		Where("NOT (failed_attempts >= ? AND coalesce(last_failure_at > ?, false))",
+			buzza.MaxTwoFactorFailures, at.Add(-buzza.TwoFactorLockout)).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("reserve attempt: %w", err)
+	}
+	return twoFactorAffected(res, buzza.ErrTwoFactorLocked)
+}
+
+func twoFactorAffected(res sql.Result, errNone error) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		return errNone
+	}
+	return nil
+}
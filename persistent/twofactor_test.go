@@ -0,0 +1,93 @@
+package persistent
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwoFactorStore(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	keyring := inmem.Keyring{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	store := &TwoFactorStore{DB: db, Keyring: keyring}
+	const uid = buzza.UserId(741)
+	_, err := db.NewDelete().Model((*TwoFactor)(nil)).Where("user_id=?", uid).Exec(ctx)
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = store.ByUserId(ctx, uid)
+	assert.ErrorIs(err, buzza.ErrTwoFactorNotFound)
+	assert.ErrorIs(store.Delete(ctx, uid), buzza.ErrTwoFactorNotFound)
+
+	enabledAt := time.Now().UTC().Truncate(time.Second)
+	saved := buzza.TwoFactor{
+		UserId:             uid,
+		Secret:             "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		Enabled:            true,
+		LastStep:           100,
+		RecoveryCodeHashes: []string{"a", "b", "c"},
+		EnabledAt:          enabledAt,
+	}
+	if !assert.NoError(store.Save(ctx, saved)) {
+		return
+	}
+	var raw TwoFactor
+	err = db.NewSelect().Model(&raw).Where("user_id=?", uid).Scan(ctx)
+	if assert.NoError(err) {
+		assert.Equal("k1", buzza.SealedValueKeyId(raw.Secret), "secret should be sealed at rest")
+	}
+	twoFactor, err := store.ByUserId(ctx, uid)
+	if assert.NoError(err) {
+		assert.Equal(saved.Secret, twoFactor.Secret)
+		assert.True(twoFactor.Enabled)
+		assert.Equal(saved.RecoveryCodeHashes, twoFactor.RecoveryCodeHashes)
+		assert.True(enabledAt.Equal(twoFactor.EnabledAt))
+	}
+
+	assert.ErrorIs(store.UseStep(ctx, uid, 100), buzza.ErrInvalidTwoFactorCode, "replayed step")
+	now := time.Now().UTC()
+	for i := 0; i < buzza.MaxTwoFactorFailures; i++ {
+		assert.NoError(store.ReserveAttempt(ctx, uid, now))
+	}
+	assert.ErrorIs(store.ReserveAttempt(ctx, uid, now), buzza.ErrTwoFactorLocked)
+	twoFactor, err = store.ByUserId(ctx, uid)
+	if assert.NoError(err) {
+		assert.Equal(buzza.MaxTwoFactorFailures, twoFactor.FailedAttempts, "locked attempts are not counted")
+	}
+	assert.NoError(store.ReserveAttempt(ctx, uid, now.Add(buzza.TwoFactorLockout)), "lockout has passed")
+	assert.NoError(store.UseStep(ctx, uid, 101))
+	twoFactor, err = store.ByUserId(ctx, uid)
+	if assert.NoError(err) {
+		assert.Equal(int64(101), twoFactor.LastStep)
+		assert.Equal(0, twoFactor.FailedAttempts)
+	}
+
+	left, err := store.UseRecoveryCode(ctx, uid, "b")
+	if assert.NoError(err) {
+		assert.Equal(2, left)
+	}
+	_, err = store.UseRecoveryCode(ctx, uid, "b")
+	assert.ErrorIs(err, buzza.ErrInvalidTwoFactorCode)
+	twoFactor, err = store.ByUserId(ctx, uid)
+	if assert.NoError(err) {
+		assert.Equal([]string{"a", "c"}, twoFactor.RecoveryCodeHashes)
+	}
+
+	assert.NoError(store.Delete(ctx, uid))
+	_, err = store.ByUserId(ctx, uid)
+	assert.ErrorIs(err, buzza.ErrTwoFactorNotFound)
+}
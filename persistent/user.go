@@ -3,24 +3,31 @@ package persistent
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/buzkaaclicker/buzza"
-	"github.com/buzkaaclicker/buzza/discord"
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
 )
 
 type User struct {
 	bun.BaseModel `bun:"table:user"`
 
-	Id                  int64          `bun:",pk,autoincrement"`
-	CreatedAt           time.Time      `bun:",nullzero,notnull,default:current_timestamp"`
-	RolesNames          []buzza.RoleId `bun:",notnull,array"`
-	DiscordId           string         `bun:",notnull,unique"`
-	DiscordRefreshToken string         `bun:",notnull"`
-	Email               string         `bun:"email,notnull"`
-	Profile             *Profile       `bun:"rel:has-one,join:id=user_id"`
+	Id         int64          `bun:",pk,autoincrement"`
+	CreatedAt  time.Time      `bun:",nullzero,notnull,default:current_timestamp"`
+	RolesNames []buzza.RoleId `bun:",notnull,array"`
+	Email      string         `bun:"email,notnull"`
+	// Blind index of the verified email, NULL if the email is not verified.
+	EmailIndex string `bun:",nullzero,unique"`
+	// Set when the account is erased, erased users are not found by the store.
+	DeletedAt time.Time `bun:",nullzero"`
+	Profile   *Profile  `bun:"rel:has-one,join:id=user_id"`
+	// Active (not expired) referral rewards. Loaded only by ById.
+	ReferralRewards []*ReferralReward `bun:"rel:has-many,join:id=user_id"`
+	// Loaded only by ById.
+	Identities []*UserIdentity `bun:"rel:has-many,join:id=user_id"`
 
 	// Mapped (in AfterScanRow hook) roles from RolesNames.
 	Roles buzza.Roles `bun:"-"`
@@ -28,14 +35,40 @@ type User struct {
 
 func (u User) ToDomain() buzza.User {
 	return buzza.User{
-		Id:                 buzza.UserId(u.Id),
-		CreatedAt:          u.CreatedAt,
-		Roles:              u.Roles,
-		Discord: buzza.UserDiscord{Id: u.DiscordId, RefreshToken: u.DiscordRefreshToken},
-		Email:              buzza.Email(u.Email),
+		Id:        buzza.UserId(u.Id),
+		CreatedAt: u.CreatedAt,
+		Roles:     u.rolesWithRewards(),
+		Discord:   u.discord(),
+		Email:     buzza.Email(u.Email),
 	}
 }
 
+func (u User) discord() buzza.UserDiscord {
+	for _, identity := range u.Identities {
+		if identity.Provider == buzza.ProviderDiscord {
+			return buzza.UserDiscord{Id: identity.Subject, RefreshToken: identity.RefreshToken}
+		}
+	}
+	return buzza.UserDiscord{}
+}
+
+// Roles merged with roles granted by referral rewards.
+func (u User) rolesWithRewards() buzza.Roles {
+	if len(u.ReferralRewards) == 0 {
+		return u.Roles
+	}
+	roles := make(buzza.Roles, len(u.Roles), len(u.Roles)+len(u.ReferralRewards))
+	copy(roles, u.Roles)
+	for _, reward := range u.ReferralRewards {
+		role, ok := buzza.AllRoles[reward.RoleId]
+		if !ok || roles.Has(role.Id) {
+			continue
+		}
+		roles = append(roles, role)
+	}
+	return roles
+}
+
 var _ bun.AfterScanRowHook = (*User)(nil)
 
 func (u *User) AfterScanRow(ctx context.Context) error {
@@ -49,34 +82,159 @@ func (u *User) AfterScanRow(ctx context.Context) error {
 	return nil
 }
 
+// Labels binding sealed values to their columns.
+const (
+	userEmailLabel = "user.email"
+	// Column moved to user_identity, still needed to migrate old rows.
+	userDiscordRefreshTokenLabel = "user.discord_refresh_token"
+)
+
 type UserStore struct {
 	DB *bun.DB
+	// Encrypts emails and identity refresh tokens at rest. Values are stored in plaintext if nil.
+	Keyring buzza.Keyring
+	// Keys blind indexes of verified emails. Users can not be found by email if nil.
+	EmailIndexKey []byte
 }
 
 var _ buzza.UserStore = (*UserStore)(nil)
 
-func (s *UserStore) RegisterDiscordUser(ctx context.Context, u discord.User, refreshToken string) (buzza.User, error) {
-	user := &User{
-		RolesNames:          []buzza.RoleId{},
-		DiscordId:           u.Id,
-		DiscordRefreshToken: refreshToken,
-		Email:               u.Email,
+func (s *UserStore) seal(label string, value string) (string, error) {
+	if s.Keyring == nil {
+		return value, nil
 	}
+	return buzza.SealValue(s.Keyring, label, value)
+}
 
-	err := s.DB.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
-		_, err := tx.NewInsert().
-			Model(user).
-			On(`CONFLICT (discord_id) DO UPDATE SET email=EXCLUDED.email, ` +
-				`discord_refresh_token=EXCLUDED.discord_refresh_token`).
-			Exec(ctx)
-		if err != nil {
-			return fmt.Errorf("insert user: %w", err)
+func (s *UserStore) unseal(label string, value string) (string, error) {
+	if s.Keyring == nil {
+		if buzza.SealedValueKeyId(value) != "" {
+			return "", errors.New("value is sealed but keyring is not set")
+		}
+		return value, nil
+	}
+	return buzza.OpenSealedValue(s.Keyring, label, value)
+}
+
+// Blind index of the email if it is verified, empty otherwise.
+func (s *UserStore) emailIndex(email buzza.Email, verified bool) string {
+	if s.EmailIndexKey == nil || !verified || email == "" {
+		return ""
+	}
+	return buzza.EmailBlindIndex(s.EmailIndexKey, email)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// Decrypt sensitive columns of the user and its loaded identities in place.
+func (s *UserStore) open(user *User) error {
+	var err error
+	user.Email, err = s.unseal(userEmailLabel, user.Email)
+	if err != nil {
+		return fmt.Errorf("open email: %w", err)
+	}
+	for _, identity := range user.Identities {
+		if err := s.openIdentity(identity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Identity refreshes might race with concurrent first logins of the same account.
+var errIdentityRegisteredConcurrently = errors.New("identity registered concurrently")
+
+func (s *UserStore) RegisterExternalUser(ctx context.Context, identity buzza.ExternalIdentity) (buzza.User, bool, error) {
+	email, err := s.seal(userEmailLabel, string(identity.Email))
+	if err != nil {
+		return buzza.User{}, false, fmt.Errorf("seal email: %w", err)
+	}
+	sealedIdentity, err := s.sealedIdentity(UserIdentity{
+		Provider:     identity.Provider,
+		Subject:      identity.Subject,
+		Email:        string(identity.Email),
+		RefreshToken: identity.RefreshToken,
+	})
+	if err != nil {
+		return buzza.User{}, false, err
+	}
+	emailIndex := s.emailIndex(identity.Email, identity.EmailVerified)
+
+	var userId int64
+	// set by the transaction inserting the identity, only the committed attempt counts
+	var insertedIdentity bool
+	register := func(ctx context.Context, tx bun.Tx) error {
+		insertedIdentity = false
+		linked := new(UserIdentity)
+		err := tx.NewSelect().
+			Model(linked).
+			Where("provider=? AND subject=?", identity.Provider, identity.Subject).
+			For("UPDATE").
+			Scan(ctx)
+		switch {
+		case err == nil:
+			userId = linked.UserId
+			_, err = tx.NewUpdate().
+				Model(sealedIdentity).
+				Column("email", "refresh_token").
+				WherePK().
+				Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("update identity: %w", err)
+			}
+			replace, err := s.replacesEmail(ctx, tx, linked, identity.EmailVerified)
+			if err != nil {
+				return err
+			}
+			if replace {
+				if err := emailIndexFree(ctx, tx, emailIndex, userId); err != nil {
+					return err
+				}
+				_, err = tx.NewUpdate().
+					Model((*User)(nil)).
+					Set("email=?", email).
+					Set("email_index=?", nullString(emailIndex)).
+					Where("id=?", userId).
+					Exec(ctx)
+				if err != nil {
+					return fmt.Errorf("update user: %w", err)
+				}
+			}
+		case errors.Is(err, sql.ErrNoRows):
+			if err := emailIndexFree(ctx, tx, emailIndex, 0); err != nil {
+				return err
+			}
+			user := &User{RolesNames: []buzza.RoleId{}, Email: email, EmailIndex: emailIndex}
+			if _, err := tx.NewInsert().Model(user).Exec(ctx); err != nil {
+				return fmt.Errorf("insert user: %w", err)
+			}
+			userId = user.Id
+			sealedIdentity.UserId = userId
+			res, err := tx.NewInsert().
+				Model(sealedIdentity).
+				On("CONFLICT DO NOTHING").
+				Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("insert identity: %w", err)
+			}
+			affected, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("rows affected: %w", err)
+			}
+			if affected == 0 {
+				return errIdentityRegisteredConcurrently
+			}
+			insertedIdentity = true
+		default:
+			return fmt.Errorf("select identity: %w", err)
 		}
 
 		profile := &Profile{
-			UserId:    user.Id,
-			Name:      u.Username,
-			AvatarUrl: u.AvatarUrl(),
+			UserId:    userId,
+			Name:      identity.Name,
+			AvatarUrl: identity.AvatarUrl,
 		}
 		_, err = tx.NewInsert().
 			Model(profile).
@@ -86,12 +244,76 @@ func (s *UserStore) RegisterDiscordUser(ctx context.Context, u discord.User, ref
 			return fmt.Errorf("insert profile: %w", err)
 		}
 		return nil
-	})
+	}
+	err = s.DB.RunInTx(ctx, &sql.TxOptions{}, register)
+	if errors.Is(err, errIdentityRegisteredConcurrently) {
+		// the other login has committed the identity, so this time it is found
+		err = s.DB.RunInTx(ctx, &sql.TxOptions{}, register)
+	}
 	if err != nil {
-		return buzza.User{}, err
+		return buzza.User{}, false, err
 	}
 
-	return user.ToDomain(), nil
+	user, err := s.ById(ctx, buzza.UserId(userId))
+	if err != nil {
+		return buzza.User{}, false, err
+	}
+	return user, insertedIdentity, nil
+}
+
+// Whether the login with the linked identity replaces email of the user. Users without email take any,
+// otherwise only the verified email of the primary identity, the first linked one, replaces it.
+func (s *UserStore) replacesEmail(ctx context.Context, tx bun.Tx, linked *UserIdentity, verified bool) (bool, error) {
+	user := new(User)
+	err := tx.NewSelect().
+		Model(user).
+		Column("email").
+		Where("id=?", linked.UserId).
+		Scan(ctx)
+	if err != nil {
+		return false, fmt.Errorf("select user email: %w", err)
+	}
+	email, err := s.unseal(userEmailLabel, user.Email)
+	if err != nil {
+		return false, fmt.Errorf("open email: %w", err)
+	}
+	if email == "" {
+		return true, nil
+	}
+	if !verified {
+		return false, nil
+	}
+	primary := new(UserIdentity)
+	err = tx.NewSelect().
+		Model(primary).
+		Column("provider", "subject").
+		Where("user_id=?", linked.UserId).
+		Order("linked_at ASC", "provider ASC").
+		Limit(1).
+		Scan(ctx)
+	if err != nil {
+		return false, fmt.Errorf("select primary identity: %w", err)
+	}
+	return primary.Provider == linked.Provider && primary.Subject == linked.Subject, nil
+}
+
+// ErrEmailInUse if the index belongs to a user other than except.
+func emailIndexFree(ctx context.Context, tx bun.Tx, emailIndex string, except int64) error {
+	if emailIndex == "" {
+		return nil
+	}
+	taken, err := tx.NewSelect().
+		Model((*User)(nil)).
+		Where("email_index=?", emailIndex).
+		Where("id<>?", except).
+		Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("select email index: %w", err)
+	}
+	if taken {
+		return buzza.ErrEmailInUse
+	}
+	return nil
 }
 
 func (s *UserStore) ById(ctx context.Context, userId buzza.UserId) (buzza.User, error) {
@@ -99,21 +321,153 @@ func (s *UserStore) ById(ctx context.Context, userId buzza.UserId) (buzza.User,
 	err := s.DB.NewSelect().
 		Model(user).
 		Where(`"user"."id"=?`, userId).
+		Where(`"user"."deleted_at" IS NULL`).
 		Relation("Profile").
+		Relation("ReferralRewards", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("expires_at > ?", time.Now().UTC())
+		}).
+		Relation("Identities").
 		Scan(ctx)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.User{}, buzza.ErrUserNotFound
+		}
 		return buzza.User{}, fmt.Errorf("select user: %w", err)
 	}
+	if err := s.open(user); err != nil {
+		return buzza.User{}, err
+	}
 	return user.ToDomain(), nil
 }
 
+func (s *UserStore) ByVerifiedEmail(ctx context.Context, email buzza.Email) (buzza.User, error) {
+	if s.EmailIndexKey == nil {
+		return buzza.User{}, errors.New("email index key is not set")
+	}
+	var userId int64
+	err := s.DB.NewSelect().
+		Model((*User)(nil)).
+		Column("id").
+		Where("email_index=?", buzza.EmailBlindIndex(s.EmailIndexKey, email)).
+		Scan(ctx, &userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return buzza.User{}, buzza.ErrUserNotFound
+		}
+		return buzza.User{}, fmt.Errorf("select user id: %w", err)
+	}
+	return s.ById(ctx, buzza.UserId(userId))
+}
+
 func (s *UserStore) Update(ctx context.Context, user buzza.User) error {
-	_, err := s.DB.NewUpdate().
-		Model(user).
-		Where(`id=?`, user.Id).
+	rolesNames := make([]buzza.RoleId, len(user.Roles))
+	for i, role := range user.Roles {
+		rolesNames[i] = role.Id
+	}
+	email, err := s.seal(userEmailLabel, string(user.Email))
+	if err != nil {
+		return fmt.Errorf("seal email: %w", err)
+	}
+	_, err = s.DB.NewUpdate().
+		Model((*User)(nil)).
+		Set("roles_names=?", pgdialect.Array(rolesNames)).
+		Set("email=?", email).
+		// index of the same email is kept, changed email is not verified
+		Set("email_index=CASE WHEN email_index=? THEN email_index END", s.emailIndex(user.Email, true)).
+		Where("id=?", user.Id).
 		Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("update query: %w", err)
 	}
 	return nil
 }
+
+// Seal emails and identity refresh tokens stored in plaintext or with a retired key
+// with the current key. Rows changed concurrently are skipped and picked up by the next run.
+// Returns number of re-encrypted users and identities.
+func (s *UserStore) ReencryptUsers(ctx context.Context, batchSize int) (int, error) {
+	if s.Keyring == nil {
+		return 0, errors.New("keyring is not set")
+	}
+	currentKeyId := s.Keyring.CurrentKeyId()
+	reencrypted := 0
+	lastId := int64(0)
+	for {
+		var users []User
+		err := s.DB.NewSelect().
+			Model(&users).
+			Column("id", "email").
+			Where("id>?", lastId).
+			Where("deleted_at IS NULL").
+			Order("id ASC").
+			Limit(batchSize).
+			Scan(ctx)
+		if err != nil {
+			return reencrypted, fmt.Errorf("select users: %w", err)
+		}
+		if len(users) == 0 {
+			break
+		}
+		lastId = users[len(users)-1].Id
+
+		for _, stored := range users {
+			if buzza.SealedValueKeyId(stored.Email) == currentKeyId {
+				continue
+			}
+			email, err := s.unseal(userEmailLabel, stored.Email)
+			if err != nil {
+				return reencrypted, fmt.Errorf("user %d: open email: %w", stored.Id, err)
+			}
+			email, err = s.seal(userEmailLabel, email)
+			if err != nil {
+				return reencrypted, fmt.Errorf("user %d: seal email: %w", stored.Id, err)
+			}
+			res, err := s.DB.NewUpdate().
+				Model((*User)(nil)).
+				Set("email=?", email).
+				Where("id=?", stored.Id).
+				Where("email=?", stored.Email).
+				Exec(ctx)
+			if err != nil {
+				return reencrypted, fmt.Errorf("update user %d: %w", stored.Id, err)
+			}
+			if affected, err := res.RowsAffected(); err == nil && affected > 0 {
+				reencrypted++
+			}
+		}
+	}
+
+	identities, err := s.reencryptIdentities(ctx, batchSize)
+	return reencrypted + identities, err
+}
+
+// Add the unique email index column to the user table created before magic links were introduced.
+// Emails are indexed on the next login of the user, as only then they are known to be verified.
+func MigrateEmailIndex(ctx context.Context, db *bun.DB) error {
+	_, err := db.ExecContext(ctx, `ALTER TABLE "user" ADD COLUMN IF NOT EXISTS email_index varchar`)
+	if err != nil {
+		return fmt.Errorf("add email index column: %w", err)
+	}
+	// emails verified by many users stay indexed only for the oldest one
+	_, err = db.ExecContext(ctx, `UPDATE "user" SET email_index=NULL WHERE email_index IS NOT NULL AND `+
+		`id<>(SELECT min(id) FROM "user" AS u WHERE u.email_index="user".email_index)`)
+	if err != nil {
+		return fmt.Errorf("clear duplicated email indexes: %w", err)
+	}
+	_, err = db.ExecContext(ctx, `DROP INDEX IF EXISTS user_email_index_idx`)
+	if err != nil {
+		return fmt.Errorf("drop email index: %w", err)
+	}
+	// name of the index created by the unique column constraint of new tables
+	_, err = db.NewCreateIndex().
+		IfNotExists().
+		Unique().
+		Model((*User)(nil)).
+		Index("user_email_index_key").
+		Column("email_index").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("create email index: %w", err)
+	}
+	return nil
+}
@@ -1,12 +1,20 @@
 package persistent
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/buzkaaclicker/buzza"
-	"github.com/buzkaaclicker/buzza/discord"
+	"github.com/buzkaaclicker/buzza/inmem"
 	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
 )
 
 func TestUserRoles(t *testing.T) {
@@ -22,10 +30,8 @@ func TestUserRoles(t *testing.T) {
 
 	_, err := db.NewInsert().
 		Model(&User{
-			DiscordId:           "1235",
-			DiscordRefreshToken: "123",
-			Email:               "user@rol.es",
-			RolesNames:          []buzza.RoleId{buzza.RoleIdPro, buzza.RoleId("UNDEFINED role")},
+			Email:      "user@rol.es",
+			RolesNames: []buzza.RoleId{buzza.RoleIdPro, buzza.RoleId("UNDEFINED role")},
 		}).
 		Exec(ctx)
 	if !assert.NoError(err) {
@@ -45,7 +51,7 @@ func TestUserRoles(t *testing.T) {
 	assert.Equal(buzza.AccessUndefined, roles.Access(buzza.PermissionAdminDashboard))
 }
 
-func TestRegisterDiscordUser(t *testing.T) {
+func TestRegisterExternalUser(t *testing.T) {
 	if testing.Short() {
 		t.SkipNow()
 		return
@@ -57,24 +63,489 @@ func TestRegisterDiscordUser(t *testing.T) {
 	defer db.Close()
 	store := UserStore{DB: db}
 
-	discordUser := discord.User{
-		Id:         "snowflake",
-		Username:   "www_makin_cc",
-		Email:      "clickacz@discord.makin.cc",
-		AvatarHash: "f2789ef0ddaee56d91a782fa530b0009",
+	identity := buzza.ExternalIdentity{
+		Provider:     buzza.ProviderDiscord,
+		Subject:      "snowflake",
+		Email:        "clickacz@discord.makin.cc",
+		Name:         "www_makin_cc",
+		AvatarUrl:    "https://cdn.discordapp.com/avatars/snowflake/f2789ef0ddaee56d91a782fa530b0009.png",
+		RefreshToken: "21gokpoasio57",
 	}
-	refreshToken := "21gokpoasio57"
-	user, err := store.RegisterDiscordUser(ctx, discordUser, refreshToken)
+	user, created, err := store.RegisterExternalUser(ctx, identity)
 	if !assert.NoError(err) {
 		return
 	}
-	assert.Equal(discordUser.Id, user.Discord.Id)
-	assert.Equal(refreshToken, user.Discord.RefreshToken)
-	assert.Equal(discordUser.Email, string(user.Email))
+	assert.True(created)
+	assert.Equal(identity.Subject, user.Discord.Id)
+	assert.Equal(identity.RefreshToken, user.Discord.RefreshToken)
+	assert.Equal(identity.Email, user.Email)
 
 	userSel, err := store.ById(ctx, user.Id)
 	if !assert.NoError(err) {
 		return
 	}
 	assert.Equal(user, userSel)
+
+	// login of already registered user
+	identity.RefreshToken = "new_refresh_token"
+	user, created, err = store.RegisterExternalUser(ctx, identity)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.False(created)
+	assert.Equal(userSel.Id, user.Id)
+	assert.Equal("new_refresh_token", user.Discord.RefreshToken)
+
+	identity.Email = "unverified@discord.makin.cc"
+	user, _, err = store.RegisterExternalUser(ctx, identity)
+	if assert.NoError(err) {
+		assert.Equal(buzza.Email("clickacz@discord.makin.cc"), user.Email, "unverified email does not replace the existing one")
+	}
+	identity.EmailVerified = true
+	user, _, err = store.RegisterExternalUser(ctx, identity)
+	if assert.NoError(err) {
+		assert.Equal(identity.Email, user.Email)
+	}
+
+	// same subject of other provider is other account
+	other, created, err := store.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderGitHub, Subject: "snowflake", Email: "octocat@github.com"})
+	if assert.NoError(err) {
+		assert.True(created)
+		assert.NotEqual(user.Id, other.Id)
+		assert.Empty(other.Discord.Id)
+	}
+
+	_, err = store.ById(ctx, 1<<40)
+	assert.ErrorIs(err, buzza.ErrUserNotFound)
+}
+
+func TestRegisterExternalUserConcurrently(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+	store := UserStore{DB: db}
+
+	identity := buzza.ExternalIdentity{Provider: buzza.ProviderDiscord,
+		Subject: "concurrent-" + strconv.FormatInt(time.Now().UnixNano(), 10)}
+	const logins = 8
+	type result struct {
+		userId  buzza.UserId
+		created bool
+		err     error
+	}
+	results := make(chan result, logins)
+	var wg sync.WaitGroup
+	for i := 0; i < logins; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, created, err := store.RegisterExternalUser(ctx, identity)
+			results <- result{userId: user.Id, created: created, err: err}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	userIds := map[buzza.UserId]bool{}
+	created := 0
+	for r := range results {
+		if assert.NoError(r.err) {
+			userIds[r.userId] = true
+			if r.created {
+				created++
+			}
+		}
+	}
+	assert.Len(userIds, 1, "concurrent first logins get the same user")
+	assert.Equal(1, created, "only the login inserting the identity has created the user")
+}
+
+func TestUserIdentities(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+	store := UserStore{DB: db}
+
+	_, err := db.NewDelete().Model((*UserIdentity)(nil)).
+		Where("subject IN (?)", bun.In([]string{"identities-discord", "identities-github", "identities-github-2",
+			"identities-other"})).
+		Exec(ctx)
+	if !assert.NoError(err) {
+		return
+	}
+
+	user, _, err := store.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderDiscord, Subject: "identities-discord", Email: "user@discord.com"})
+	if !assert.NoError(err) {
+		return
+	}
+	other, _, err := store.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderDiscord, Subject: "identities-other", Email: "other@discord.com"})
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = store.UnlinkIdentity(ctx, user.Id, buzza.ProviderDiscord)
+	assert.ErrorIs(err, buzza.ErrLastIdentity)
+
+	github := buzza.ExternalIdentity{Provider: buzza.ProviderGitHub, Subject: "identities-github",
+		Email: "user@github.com", RefreshToken: "gh"}
+	linked, err := store.LinkIdentity(ctx, user.Id, github)
+	if assert.NoError(err) {
+		assert.Equal(user.Id, linked.UserId)
+		assert.False(linked.LinkedAt.IsZero())
+	}
+	github.RefreshToken = "gh2"
+	_, err = store.LinkIdentity(ctx, user.Id, github)
+	assert.NoError(err, "relinking refreshes the tokens")
+	_, err = store.LinkIdentity(ctx, other.Id, github)
+	assert.ErrorIs(err, buzza.ErrIdentityLinked)
+	_, err = store.LinkIdentity(ctx, user.Id, buzza.ExternalIdentity{Provider: buzza.ProviderGitHub,
+		Subject: "identities-github-2"})
+	assert.ErrorIs(err, buzza.ErrProviderLinked)
+
+	identities, err := store.Identities(ctx, user.Id)
+	if assert.NoError(err) && assert.Len(identities, 2) {
+		assert.Equal(buzza.ProviderDiscord, identities[0].Provider)
+		assert.Equal(buzza.ProviderGitHub, identities[1].Provider)
+		assert.Equal("gh2", identities[1].RefreshToken)
+	}
+
+	// login with the linked account
+	github.EmailVerified = true
+	loggedIn, created, err := store.RegisterExternalUser(ctx, github)
+	if assert.NoError(err) {
+		assert.False(created)
+		assert.Equal(user.Id, loggedIn.Id)
+		assert.Equal("identities-discord", loggedIn.Discord.Id)
+		assert.Equal(buzza.Email("user@discord.com"), loggedIn.Email, "only the primary identity replaces the email")
+	}
+
+	_, err = store.UnlinkIdentity(ctx, other.Id, buzza.ProviderGitHub)
+	assert.ErrorIs(err, buzza.ErrIdentityNotFound)
+	unlinked, err := store.UnlinkIdentity(ctx, user.Id, buzza.ProviderDiscord)
+	if assert.NoError(err) {
+		assert.Equal("identities-discord", unlinked.Subject)
+	}
+	byId, err := store.ById(ctx, user.Id)
+	if assert.NoError(err) {
+		assert.Empty(byId.Discord.Id)
+	}
+	_, err = store.UnlinkIdentity(ctx, user.Id, buzza.ProviderGitHub)
+	assert.ErrorIs(err, buzza.ErrLastIdentity)
+}
+
+func TestUserByVerifiedEmail(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+	keyring := inmem.Keyring{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	store := UserStore{DB: db, Keyring: keyring, EmailIndexKey: []byte("email-index-key")}
+
+	_, err := store.ByVerifiedEmail(ctx, "verified@magic.link")
+	assert.ErrorIs(err, buzza.ErrUserNotFound)
+
+	unverified, _, err := store.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderDiscord, Subject: "verified-email-unverified", Email: "verified@magic.link"})
+	if !assert.NoError(err) {
+		return
+	}
+	_, err = store.ByVerifiedEmail(ctx, "verified@magic.link")
+	assert.ErrorIs(err, buzza.ErrUserNotFound, "unverified emails are not indexed")
+
+	verified, _, err := store.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderGitHub, Subject: "verified-email-verified", Email: "Verified@Magic.link",
+		EmailVerified: true})
+	if !assert.NoError(err) {
+		return
+	}
+	found, err := store.ByVerifiedEmail(ctx, " verified@magic.LINK")
+	if assert.NoError(err) {
+		assert.Equal(verified.Id, found.Id)
+		assert.Equal(buzza.Email("Verified@Magic.link"), found.Email)
+	}
+
+	// email verified on the next login is the verified email of the other user already
+	unverifiedLogin := buzza.ExternalIdentity{
+		Provider: buzza.ProviderDiscord, Subject: "verified-email-unverified", Email: "verified@magic.link",
+		EmailVerified: true}
+	_, _, err = store.RegisterExternalUser(ctx, unverifiedLogin)
+	assert.ErrorIs(err, buzza.ErrEmailInUse)
+	_, _, err = store.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderGitHub, Subject: "verified-email-new", Email: "VERIFIED@magic.link",
+		EmailVerified: true})
+	assert.ErrorIs(err, buzza.ErrEmailInUse, "new user can not take the verified email")
+
+	found.Roles = buzza.Roles{buzza.AllRoles[buzza.RoleIdPro]}
+	if !assert.NoError(store.Update(ctx, found)) {
+		return
+	}
+	found, err = store.ByVerifiedEmail(ctx, "verified@magic.link")
+	if assert.NoError(err) {
+		assert.Equal(verified.Id, found.Id, "same email stays verified")
+	}
+	found.Email = "changed@magic.link"
+	if !assert.NoError(store.Update(ctx, found)) {
+		return
+	}
+	_, err = store.ByVerifiedEmail(ctx, "changed@magic.link")
+	assert.ErrorIs(err, buzza.ErrUserNotFound, "changed email is not verified")
+
+	_, _, err = store.RegisterExternalUser(ctx, unverifiedLogin)
+	if !assert.NoError(err) {
+		return
+	}
+	found, err = store.ByVerifiedEmail(ctx, "verified@magic.link")
+	if assert.NoError(err) {
+		assert.Equal(unverified.Id, found.Id)
+	}
+}
+
+func TestUserEncryption(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	// stored before encryption was introduced
+	legacy := &User{RolesNames: []buzza.RoleId{}, Email: "legacy@encrypt.ed"}
+	if _, err := db.NewInsert().Model(legacy).Exec(ctx); !assert.NoError(err) {
+		return
+	}
+	_, err := db.NewInsert().
+		Model(&UserIdentity{Provider: buzza.ProviderDiscord, Subject: "encryption-legacy", UserId: legacy.Id,
+			Email: "legacy@encrypt.ed", RefreshToken: "legacy_refresh_token"}).
+		On("CONFLICT (provider, subject) DO UPDATE").
+		Set("user_id=EXCLUDED.user_id, email=EXCLUDED.email, refresh_token=EXCLUDED.refresh_token").
+		Exec(ctx)
+	if !assert.NoError(err) {
+		return
+	}
+
+	keyring := inmem.Keyring{Current: "k1", Keys: map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}}
+	store := &UserStore{DB: db, Keyring: keyring}
+	user, _, err := store.RegisterExternalUser(ctx, buzza.ExternalIdentity{Provider: buzza.ProviderDiscord,
+		Subject: "encryption", Email: "user@encrypt.ed", RefreshToken: "refresh_token"})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(buzza.Email("user@encrypt.ed"), user.Email)
+	assert.Equal("refresh_token", user.Discord.RefreshToken)
+
+	rawColumns := func(userId int64) (string, string) {
+		var raw User
+		err := db.NewSelect().Model(&raw).Column("email").Where("id=?", userId).Scan(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var identity UserIdentity
+		err = db.NewSelect().Model(&identity).Where("user_id=?", userId).Scan(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw.Email, identity.RefreshToken
+	}
+	email, refreshToken := rawColumns(int64(user.Id))
+	assert.Equal("k1", buzza.SealedValueKeyId(email))
+	assert.Equal("k1", buzza.SealedValueKeyId(refreshToken))
+
+	byId, err := store.ById(ctx, user.Id)
+	if assert.NoError(err) {
+		assert.Equal(user.Email, byId.Email)
+		assert.Equal(user.Discord.RefreshToken, byId.Discord.RefreshToken)
+	}
+	legacyUser, err := store.ById(ctx, buzza.UserId(legacy.Id))
+	if assert.NoError(err) {
+		assert.Equal(buzza.Email("legacy@encrypt.ed"), legacyUser.Email)
+	}
+
+	byId.Email = "updated@encrypt.ed"
+	if assert.NoError(store.Update(ctx, byId)) {
+		email, _ := rawColumns(int64(user.Id))
+		assert.Equal("k1", buzza.SealedValueKeyId(email))
+		updated, err := store.ById(ctx, user.Id)
+		if assert.NoError(err) {
+			assert.Equal(buzza.Email("updated@encrypt.ed"), updated.Email)
+		}
+	}
+
+	// rotation
+	keyring.Current = "k2"
+	store.Keyring = keyring
+	reencrypted, err := store.ReencryptUsers(ctx, 1)
+	if assert.NoError(err) {
+		assert.GreaterOrEqual(reencrypted, 4)
+	}
+	for _, userId := range []int64{int64(user.Id), legacy.Id} {
+		email, refreshToken := rawColumns(userId)
+		assert.Equal("k2", buzza.SealedValueKeyId(email))
+		assert.Equal("k2", buzza.SealedValueKeyId(refreshToken))
+	}
+	reencrypted, err = store.ReencryptUsers(ctx, 100)
+	if assert.NoError(err) {
+		assert.Zero(reencrypted)
+	}
+
+	// retired key is not needed anymore
+	delete(keyring.Keys, "k1")
+	rotated, err := store.ById(ctx, buzza.UserId(legacy.Id))
+	if assert.NoError(err) {
+		assert.Equal("legacy_refresh_token", rotated.Discord.RefreshToken)
+	}
+}
+
+func TestMigrateDiscordIdentities(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+		return
+	}
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db := PgOpenTest(ctx)
+	defer db.Close()
+
+	_, err := db.ExecContext(ctx, `ALTER TABLE "user" ADD COLUMN IF NOT EXISTS discord_id varchar UNIQUE, `+
+		`ADD COLUMN IF NOT EXISTS discord_refresh_token varchar`)
+	if !assert.NoError(err) {
+		return
+	}
+	keyring := inmem.Keyring{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	store := &UserStore{DB: db, Keyring: keyring}
+	email, err := store.seal(userEmailLabel, "legacy@migrat.ed")
+	if !assert.NoError(err) {
+		return
+	}
+	refreshToken, err := store.seal(userDiscordRefreshTokenLabel, "legacy_refresh_token")
+	if !assert.NoError(err) {
+		return
+	}
+	legacy := &legacyDiscordUser{Email: email, DiscordId: "migrated-snowflake", DiscordRefreshToken: refreshToken}
+	_, err = db.NewInsert().
+		Model(legacy).
+		Column("email", "discord_id", "discord_refresh_token").
+		Value("roles_names", "'{}'").
+		Returning("id").
+		Exec(ctx)
+	if !assert.NoError(err) {
+		return
+	}
+
+	migrated, err := MigrateDiscordIdentities(ctx, db, keyring)
+	if assert.NoError(err) {
+		assert.GreaterOrEqual(migrated, 1)
+	}
+	user, err := store.ById(ctx, buzza.UserId(legacy.Id))
+	if assert.NoError(err) {
+		assert.Equal(buzza.Email("legacy@migrat.ed"), user.Email)
+		assert.Equal(buzza.UserDiscord{Id: "migrated-snowflake", RefreshToken: "legacy_refresh_token"}, user.Discord)
+	}
+
+	migrated, err = MigrateDiscordIdentities(ctx, db, keyring)
+	if assert.NoError(err) {
+		assert.Zero(migrated, "columns are already dropped")
+	}
+}
+
+func TestFileKeyring(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	k1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	k2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"current":"k1","keys":{"k1":"` + k1 + `"}}`)
+	keyring, err := OpenFileKeyring(path)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("k1", keyring.CurrentKeyId())
+	key, err := keyring.Key("k1")
+	if assert.NoError(err) {
+		assert.Equal(bytes.Repeat([]byte{1}, 32), key)
+	}
+	_, err = keyring.Key("k2")
+	assert.ErrorIs(err, buzza.ErrKeyNotFound)
+
+	write(`{"current":"k2","keys":{"k1":"` + k1 + `","k2":"` + k2 + `"}}`)
+	if assert.NoError(keyring.Reload()) {
+		assert.Equal("k2", keyring.CurrentKeyId())
+	}
+
+	invalid := []string{
+		`{"current":"k3","keys":{"k1":"` + k1 + `"}}`,
+		`{"current":"k1","keys":{"k1":"c2hvcnQ="}}`,
+		`{"current":"a:b","keys":{"a:b":"` + k1 + `"}}`,
+		`not json`,
+	}
+	for _, content := range invalid {
+		write(content)
+		assert.Error(keyring.Reload(), content)
+	}
+	assert.Equal("k2", keyring.CurrentKeyId(), "previous keys should be kept on error")
+}
+
+func TestFileKeyringWatch(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	k1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	k2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+
+	if !assert.NoError(os.WriteFile(path, []byte(`{"current":"k1","keys":{"k1":"`+k1+`"}}`), 0o600)) {
+		return
+	}
+	keyring, err := OpenFileKeyring(path)
+	if !assert.NoError(err) {
+		return
+	}
+	reloaded := make(chan struct{}, 1)
+	go keyring.Watch(ctx, 10*time.Millisecond, func() { reloaded <- struct{}{} })
+
+	if !assert.NoError(os.WriteFile(path, []byte(`{"current":"k2","keys":{"k1":"`+k1+`","k2":"`+k2+`"}}`), 0o600)) {
+		return
+	}
+	// modification time resolution of some filesystems is coarse
+	modTime := time.Now().Add(time.Second)
+	if !assert.NoError(os.Chtimes(path, modTime, modTime)) {
+		return
+	}
+	select {
+	case <-reloaded:
+		assert.Equal("k2", keyring.CurrentKeyId())
+	case <-time.After(5 * time.Second):
+		assert.Fail("keyring not reloaded")
+	}
 }
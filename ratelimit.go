@@ -0,0 +1,17 @@
+package buzza
+
+import (
+	"context"
+	"time"
+)
+
+// Fixed window rate limiter shared by all backend instances.
+type RateLimiter interface {
+	// Count the hit of the key. False if the key has already reached the limit in the current window.
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error)
+}
+
+// Start of the window containing the time, the same for every instance.
+func RateLimitWindowStart(at time.Time, window time.Duration) time.Time {
+	return at.UTC().Truncate(window)
+}
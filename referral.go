@@ -0,0 +1,62 @@
+package buzza
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrReferralCodeNotFound    = errors.New("referral code not found")
+	ErrReferralNotFound        = errors.New("referral not found")
+	ErrSelfReferral            = errors.New("self referral")
+	ErrAlreadyReferred         = errors.New("user already referred")
+	ErrReferralAlreadyRewarded = errors.New("referral already rewarded")
+)
+
+type ReferralCode string
+
+type Referral struct {
+	Id         int64
+	ReferrerId UserId
+	RefereeId  UserId
+	CreatedAt  time.Time
+	// Time of the first referee purchase. Zero if referee didn't purchase anything yet.
+	PurchasedAt time.Time
+}
+
+// Role granted to the referrer for a limited time after referee purchase.
+type ReferralReward struct {
+	Id         int64
+	UserId     UserId
+	ReferralId int64
+	RoleId     RoleId
+	ExpiresAt  time.Time
+}
+
+type ReferralRewardRule struct {
+	RoleId   RoleId
+	Duration time.Duration
+}
+
+// 7 days of pro after referee purchase.
+var DefaultReferralRewardRule = ReferralRewardRule{
+	RoleId:   RoleIdPro,
+	Duration: 7 * 24 * time.Hour,
+}
+
+type ReferralStore interface {
+	// Get referral code of the user. Generates new code if user has no code yet.
+	CodeByUserId(ctx context.Context, userId UserId) (ReferralCode, error)
+
+	// Attach freshly registered referee to the owner of the code.
+	// "ip" - referee ip used to detect self referrals.
+	Attach(ctx context.Context, code ReferralCode, referee User, ip string) (Referral, error)
+
+	ByReferrerId(ctx context.Context, referrerId UserId) ([]Referral, error)
+
+	RewardsByUserId(ctx context.Context, userId UserId) ([]ReferralReward, error)
+
+	// Register referee purchase and reward the referrer. Only the first purchase is rewarded.
+	RegisterPurchase(ctx context.Context, refereeId UserId) (ReferralReward, error)
+}
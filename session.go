@@ -2,22 +2,127 @@ package buzza
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 )
 
 var ErrSessionNotFound = errors.New("session not found")
 
+const MaxSessionNameLength = 64
+
 type Session struct {
-	Id             string
-	UserId         UserId
-	Token          string
+	Id     string
+	UserId UserId
+	// Bearer token, stores keep only its hash. Set only by RegisterNew and by lookups with the token.
+	Token string
+	// Optional name given by the user.
+	Name string
+	// Pinned sessions are listed first.
+	Pinned bool
+	// Trusted sessions live longer and skip new-login alerts.
+	Trusted        bool
 	Ip             string
 	UserAgent      string
+	Agent          UserAgentInfo
+	Location       GeoLocation
+	CreatedAt      time.Time
 	LastAccessedAt time.Time
 	ExpiresAt      time.Time
 }
 
+// Keyed hash of the session token, safe to store and to look sessions up by.
+func HashSessionToken(secret []byte, token string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Limits of the session lifetime and of the concurrent sessions count.
+type SessionPolicy struct {
+	// Sessions expire this long after creation, even if they are used regularly. 0 disables the limit.
+	MaxAge time.Duration
+	// Sessions expire after being unused this long.
+	IdleTimeout        time.Duration
+	TrustedIdleTimeout time.Duration
+	// Concurrent sessions of users without a role listed in MaxSessionsByRole. 0 is unlimited.
+	MaxSessions int
+	// If user has many listed roles, the lowest limit applies.
+	MaxSessionsByRole map[RoleId]int
+}
+
+var DefaultSessionPolicy = SessionPolicy{
+	MaxAge:             180 * 24 * time.Hour,
+	IdleTimeout:        30 * 24 * time.Hour,
+	TrustedIdleTimeout: 90 * 24 * time.Hour,
+	MaxSessions:        10,
+	MaxSessionsByRole: map[RoleId]int{
+		// stolen admin tokens are the most harmful
+		RoleIdAdmin: 3,
+	},
+}
+
+func (p SessionPolicy) Validate() error {
+	if p.MaxAge < 0 {
+		return errors.New("negative max age")
+	}
+	if p.IdleTimeout <= 0 || p.TrustedIdleTimeout <= 0 {
+		return errors.New("idle timeout must be positive")
+	}
+	if p.MaxSessions < 0 {
+		return errors.New("negative max sessions")
+	}
+	for roleId, limit := range p.MaxSessionsByRole {
+		if limit < 0 {
+			return fmt.Errorf("negative max sessions of role '%s'", roleId)
+		}
+	}
+	return nil
+}
+
+// Time the session expires at if it is not used anymore.
+func (p SessionPolicy) ExpiresAt(session Session) time.Time {
+	idleTimeout := p.IdleTimeout
+	if session.Trusted {
+		idleTimeout = p.TrustedIdleTimeout
+	}
+	expiresAt := session.LastAccessedAt.Add(idleTimeout)
+	if p.MaxAge > 0 && !session.CreatedAt.IsZero() {
+		if maxAt := session.CreatedAt.Add(p.MaxAge); maxAt.Before(expiresAt) {
+			expiresAt = maxAt
+		}
+	}
+	return expiresAt
+}
+
+// Maximum concurrent sessions of the user with the roles, 0 if unlimited.
+func (p SessionPolicy) SessionLimit(roles Roles) int {
+	limit, found := 0, false
+	for _, role := range roles {
+		roleLimit, ok := p.MaxSessionsByRole[role.Id]
+		if !ok {
+			continue
+		}
+		if !found || (roleLimit != 0 && (limit == 0 || roleLimit < limit)) {
+			limit, found = roleLimit, true
+		}
+	}
+	if !found {
+		return p.MaxSessions
+	}
+	return limit
+}
+
+// Changes of the session made by its owner. Nil fields are left unchanged.
+type SessionUpdate struct {
+	Name    *string
+	Pinned  *bool
+	Trusted *bool
+}
+
 type SessionStore interface {
 	RegisterNew(ctx context.Context, userId UserId, ip string, userAgent string) (Session, error)
 
@@ -29,9 +134,18 @@ type SessionStore interface {
 
 	AcquireAndRefresh(ctx context.Context, token string, ip string, userAgent string) (Session, error)
 
+	// Update session of the user, ErrSessionNotFound if user has no such session.
+	UpdateById(ctx context.Context, userId UserId, sessionId string, update SessionUpdate) (Session, error)
+
+	// ErrSessionNotFound if user has no such session.
 	InvalidateById(userId UserId, sessionId string) error
 
+	// ErrSessionNotFound if there is no such session.
 	InvalidateByAuthToken(authToken string) error
 
+	// Invalidate other sessions of the token owner, ErrSessionNotFound if there is no such session.
 	InvalidateAllExpect(expectToken string) error
+
+	// Invalidate all sessions of the user, returns number of invalidated sessions.
+	InvalidateByUserId(ctx context.Context, userId UserId) (int, error)
 }
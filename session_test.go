@@ -0,0 +1,60 @@
+package buzza
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionPolicyExpiresAt(t *testing.T) {
+	assert := assert.New(t)
+	policy := SessionPolicy{MaxAge: 10 * time.Hour, IdleTimeout: 2 * time.Hour, TrustedIdleTimeout: 5 * time.Hour}
+	createdAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	session := Session{CreatedAt: createdAt, LastAccessedAt: createdAt.Add(time.Hour)}
+	assert.Equal(createdAt.Add(3*time.Hour), policy.ExpiresAt(session))
+	session.Trusted = true
+	assert.Equal(createdAt.Add(6*time.Hour), policy.ExpiresAt(session))
+
+	// regular use does not extend the session beyond its max age
+	session.LastAccessedAt = createdAt.Add(9 * time.Hour)
+	assert.Equal(createdAt.Add(10*time.Hour), policy.ExpiresAt(session))
+
+	policy.MaxAge = 0
+	assert.Equal(createdAt.Add(14*time.Hour), policy.ExpiresAt(session), "max age disabled")
+}
+
+func TestSessionPolicySessionLimit(t *testing.T) {
+	assert := assert.New(t)
+	policy := SessionPolicy{MaxSessions: 10, MaxSessionsByRole: map[RoleId]int{
+		RoleIdAdmin: 3,
+		RoleIdPro:   20,
+		"tester":    0,
+	}}
+	role := func(id RoleId) Role {
+		return Role{Id: id}
+	}
+
+	assert.Equal(10, policy.SessionLimit(nil))
+	assert.Equal(10, policy.SessionLimit(Roles{role("unlisted")}))
+	assert.Equal(20, policy.SessionLimit(Roles{role(RoleIdPro)}))
+	assert.Equal(3, policy.SessionLimit(Roles{role(RoleIdPro), role(RoleIdAdmin)}), "lowest limit should apply")
+	assert.Equal(0, policy.SessionLimit(Roles{role("tester")}))
+	assert.Equal(20, policy.SessionLimit(Roles{role("tester"), role(RoleIdPro)}), "unlimited role should not override limit")
+}
+
+func TestSessionPolicyValidate(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(DefaultSessionPolicy.Validate())
+
+	invalid := []SessionPolicy{
+		{MaxAge: -1, IdleTimeout: time.Hour, TrustedIdleTimeout: time.Hour},
+		{IdleTimeout: 0, TrustedIdleTimeout: time.Hour},
+		{IdleTimeout: time.Hour, TrustedIdleTimeout: time.Hour, MaxSessions: -1},
+		{IdleTimeout: time.Hour, TrustedIdleTimeout: time.Hour, MaxSessionsByRole: map[RoleId]int{RoleIdPro: -1}},
+	}
+	for _, policy := range invalid {
+		assert.Error(policy.Validate(), "%+v", policy)
+	}
+}
@@ -0,0 +1,125 @@
+package buzza
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrSharedConfigNotFound = errors.New("shared config not found")
+	ErrSelfRating           = errors.New("author can not rate own config")
+)
+
+const (
+	MinSharedConfigRating = 1
+	MaxSharedConfigRating = 5
+
+	maxSharedConfigTitleLength       = 80
+	maxSharedConfigDescriptionLength = 2000
+	maxSharedConfigTags              = 5
+)
+
+var sharedConfigTagRegexp = regexp.MustCompile(`^[a-z0-9\-]{1,24}$`)
+
+type SharedConfigSort string
+
+const (
+	SharedConfigSortNewest    SharedConfigSort = "newest"
+	SharedConfigSortDownloads SharedConfigSort = "downloads"
+	SharedConfigSortRating    SharedConfigSort = "rating"
+)
+
+func (s SharedConfigSort) Valid() bool {
+	switch s {
+	case SharedConfigSortNewest, SharedConfigSortDownloads, SharedConfigSortRating:
+		return true
+	default:
+		return false
+	}
+}
+
+// Clicker config published by its author for the community.
+type SharedConfig struct {
+	Id            int64
+	Author        Profile
+	Title         string
+	Description   string
+	Tags          []string
+	Data          json.RawMessage
+	Downloads     int64
+	RatingCount   int64
+	RatingAverage float64
+	CreatedAt     time.Time
+}
+
+type SharedConfigMeta struct {
+	Title       string
+	Description string
+	Tags        []string
+}
+
+func (m SharedConfigMeta) Validate() error {
+	titleLength := utf8.RuneCountInString(m.Title)
+	if titleLength < 3 || titleLength > maxSharedConfigTitleLength {
+		return errors.New("title must be between 3 and 80 characters long")
+	}
+	if utf8.RuneCountInString(m.Description) > maxSharedConfigDescriptionLength {
+		return errors.New("description too long")
+	}
+	if len(m.Tags) > maxSharedConfigTags {
+		return errors.New("too many tags")
+	}
+	for _, tag := range m.Tags {
+		if !sharedConfigTagRegexp.MatchString(tag) {
+			return errors.New("invalid tag")
+		}
+	}
+	return nil
+}
+
+type SharedConfigQuery struct {
+	// Phrase searched in title and description.
+	Search string
+	// Configs must have all of the tags.
+	Tags   []string
+	Sort   SharedConfigSort
+	Offset int
+	Limit  int
+}
+
+type SharedConfigReport struct {
+	Id             int64
+	SharedConfigId int64
+	ReporterId     UserId
+	Reason         string
+	CreatedAt      time.Time
+}
+
+type SharedConfigStore interface {
+	Publish(ctx context.Context, authorId UserId, meta SharedConfigMeta, data json.RawMessage) (SharedConfig, error)
+
+	// Get config that has not been taken down.
+	ById(ctx context.Context, id int64) (SharedConfig, error)
+
+	// Returns page of configs matching the query and total count of matching configs.
+	Search(ctx context.Context, query SharedConfigQuery) ([]SharedConfig, int, error)
+
+	// Get config and increase its downloads counter.
+	Download(ctx context.Context, id int64) (SharedConfig, error)
+
+	// Set user rating of the config. Every user has only one rating per config.
+	Rate(ctx context.Context, id int64, userId UserId, rating int) error
+
+	// Report config to administrators. Repeated reports of the same user are ignored.
+	Report(ctx context.Context, id int64, reporterId UserId, reason string) error
+
+	// Reports of configs that have not been taken down yet, newest first.
+	Reports(ctx context.Context, offset int, limit int) ([]SharedConfigReport, error)
+
+	// Hide config from everyone.
+	TakeDown(ctx context.Context, id int64, reason string) error
+}
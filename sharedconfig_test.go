@@ -0,0 +1,22 @@
+package buzza
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedConfigMetaValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(SharedConfigMeta{Title: "Butterfly 20cps", Tags: []string{"pvp", "butterfly-click"}}.Validate())
+	assert.NoError(SharedConfigMeta{Title: "Łąka", Description: strings.Repeat("ż", 2000)}.Validate())
+
+	assert.Error(SharedConfigMeta{Title: "ab"}.Validate())
+	assert.Error(SharedConfigMeta{Title: strings.Repeat("a", 81)}.Validate())
+	assert.Error(SharedConfigMeta{Title: "title", Description: strings.Repeat("a", 2001)}.Validate())
+	assert.Error(SharedConfigMeta{Title: "title", Tags: []string{"a", "b", "c", "d", "e", "f"}}.Validate())
+	assert.Error(SharedConfigMeta{Title: "title", Tags: []string{"PvP"}}.Validate())
+	assert.Error(SharedConfigMeta{Title: "title", Tags: []string{""}}.Validate())
+}
@@ -0,0 +1,162 @@
+// Package smtptest provides a local SMTP sink collecting mails in memory, so SMTP mailers can be
+// tested without delivering anything.
+package smtptest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+)
+
+type Message struct {
+	From string
+	To   []string
+	// Headers and body as sent in the DATA command, lines are separated with CRLF.
+	Data string
+}
+
+// Body of the message without headers.
+func (m Message) Body() string {
+	parts := strings.SplitN(m.Data, "\r\n\r\n", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// SMTP server accepting every mail without authentication and TLS.
+type Server struct {
+	listener net.Listener
+	messages []Message
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// Start the server on a random local port.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Address with port to dial e.g. "127.0.0.1:41234".
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Messages received so far, oldest first.
+func (s *Server) Messages() []Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	reply := func(line string) bool {
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err == nil
+	}
+	if !reply("220 localhost smtptest") {
+		return
+	}
+	var message Message
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(verb, "EHLO"), strings.HasPrefix(verb, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(verb, "MAIL FROM:"):
+			message = Message{From: address(line[len("MAIL FROM:"):])}
+			reply("250 OK")
+		case strings.HasPrefix(verb, "RCPT TO:"):
+			message.To = append(message.To, address(line[len("RCPT TO:"):]))
+			reply("250 OK")
+		case verb == "DATA":
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			data, ok := readData(reader)
+			if !ok {
+				return
+			}
+			message.Data = data
+			s.mutex.Lock()
+			s.messages = append(s.messages, message)
+			s.mutex.Unlock()
+			message = Message{}
+			reply("250 OK")
+		case verb == "RSET":
+			message = Message{}
+			reply("250 OK")
+		case verb == "NOOP":
+			reply("250 OK")
+		case verb == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// Lines until the terminating dot with the dot-stuffing removed.
+func readData(reader *bufio.Reader) (string, bool) {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "." {
+			return strings.Join(lines, "\r\n"), true
+		}
+		lines = append(lines, strings.TrimPrefix(line, "."))
+	}
+}
+
+// Address from "<user@example.com>" with optional parameters.
+func address(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if end := strings.Index(arg, ">"); strings.HasPrefix(arg, "<") && end > 0 {
+		return arg[1:end]
+	}
+	if fields := strings.Fields(arg); len(fields) != 0 {
+		return fields[0]
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+// Package storetest contains conformance tests shared by the store implementations.
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run ActivityStore conformance test. Store must be empty.
+func RunActivityStoreTests(t *testing.T, store buzza.ActivityStore) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	const uid, otherUid = buzza.UserId(1), buzza.UserId(2)
+	activities := []buzza.Activity{
+		{Name: "session_created", Data: map[string]interface{}{"session_id": "a", "ip": "127.0.0.1"}},
+		{Name: "session_changed_ip", Data: map[string]interface{}{"session_id": "a",
+			"previous_ip": "127.0.0.1", "new_ip": "127.0.0.2"}},
+		{Name: "session_created", Data: map[string]interface{}{"session_id": "b"}},
+		{Name: "logged_out"},
+	}
+	for _, activity := range activities {
+		if !assert.NoError(store.AddLog(ctx, uid, activity)) {
+			return
+		}
+	}
+	if !assert.NoError(store.AddLog(ctx, otherUid, activities[0])) {
+		return
+	}
+
+	all, err := store.ByUserId(ctx, uid, buzza.ActivityQuery{Limit: 100})
+	if !assert.NoError(err) || !assert.Len(all, len(activities)) {
+		return
+	}
+	for i, log := range all {
+		assert.Equal(uid, log.UserId)
+		assert.Equal(1, log.Version)
+		assert.Equal(activities[len(activities)-1-i].Name, log.Name, "logs should be ordered newest first")
+		if i > 0 {
+			assert.Less(log.Id, all[i-1].Id)
+		}
+	}
+
+	queries := []buzza.ActivityQuery{
+		{Limit: 2},
+		{Limit: 0},
+		{Limit: -1},
+		{BeforeId: all[1].Id, Limit: 100},
+		{AfterId: all[2].Id, Limit: 100},
+		{AfterId: all[3].Id, BeforeId: all[0].Id, Limit: 100},
+		{AfterId: all[0].Id, Limit: 100},
+		{Names: []string{"session_created"}, Limit: 100},
+		{Names: []string{"session_created", "logged_out"}, Limit: 100},
+		{Names: []string{"unknown"}, Limit: 100},
+		{SessionId: "a", Limit: 100},
+		{SessionId: "a", Names: []string{"session_changed_ip"}, Limit: 100},
+		{SessionId: "c", Limit: 100},
+		{From: all[2].CreatedAt, Limit: 100},
+		{To: all[2].CreatedAt, Limit: 100},
+		{From: all[3].CreatedAt, To: all[0].CreatedAt.Add(time.Microsecond), Limit: 100},
+		{From: all[0].CreatedAt.Add(time.Hour), Limit: 100},
+		{BeforeId: all[0].Id, Names: []string{"session_created"}, Limit: 1},
+		{Ip: "127.0.0.1", Limit: 100},
+		{Ip: "127.0.0.2", Limit: 100},
+		{Ip: "10.0.0.1", Limit: 100},
+		{OldestFirst: true, Limit: 2},
+		{AfterId: all[3].Id, OldestFirst: true, Limit: 2},
+	}
+	for i, query := range queries {
+		logs, err := store.ByUserId(ctx, uid, query)
+		if !assert.NoError(err, i) {
+			continue
+		}
+		expected := make([]buzza.ActivityLog, 0)
+		for _, log := range ordered(all, query.OldestFirst) {
+			if query.Matches(log) && len(expected) < query.Limit {
+				expected = append(expected, log)
+			}
+		}
+		assert.Equal(logIds(expected), logIds(logs), "query %d", i)
+	}
+
+	sessionLogs, err := store.ByUserId(ctx, uid, buzza.ActivityQuery{SessionId: "a", Limit: 100})
+	if assert.NoError(err) && assert.Len(sessionLogs, 2) {
+		assert.Equal("session_changed_ip", sessionLogs[0].Name)
+		assert.Equal("session_created", sessionLogs[1].Name)
+	}
+
+	otherLogs, err := store.ByUserId(ctx, otherUid, buzza.ActivityQuery{Limit: 100})
+	if !assert.NoError(err) || !assert.Len(otherLogs, 1) {
+		return
+	}
+
+	// other user log is the newest one
+	everyone := append([]buzza.ActivityLog{otherLogs[0]}, all...)
+	auditQueries := []buzza.AuditQuery{
+		{ActivityQuery: buzza.ActivityQuery{Limit: 100}},
+		{ActivityQuery: buzza.ActivityQuery{Limit: 2}},
+		{ActivityQuery: buzza.ActivityQuery{Limit: 0}},
+		{ActivityQuery: buzza.ActivityQuery{BeforeId: otherLogs[0].Id, Limit: 2}},
+		{ActivityQuery: buzza.ActivityQuery{Names: []string{"session_created"}, Limit: 100}},
+		{ActivityQuery: buzza.ActivityQuery{Ip: "127.0.0.1", Limit: 100}},
+		{ActivityQuery: buzza.ActivityQuery{Limit: 100}, UserId: otherUid},
+		{ActivityQuery: buzza.ActivityQuery{Names: []string{"logged_out"}, Limit: 100}, UserId: otherUid},
+		{ActivityQuery: buzza.ActivityQuery{OldestFirst: true, Limit: 2}},
+	}
+	for i, query := range auditQueries {
+		logs, err := store.Audit(ctx, query)
+		if !assert.NoError(err, i) {
+			continue
+		}
+		expected := make([]buzza.ActivityLog, 0)
+		for _, log := range ordered(everyone, query.OldestFirst) {
+			if (query.UserId == 0 || log.UserId == query.UserId) && query.Matches(log) &&
+				len(expected) < query.Limit {
+				expected = append(expected, log)
+			}
+		}
+		assert.Equal(logIds(expected), logIds(logs), "audit query %d", i)
+	}
+}
+
+// Logs ordered newest first, reversed if oldestFirst.
+func ordered(logs []buzza.ActivityLog, oldestFirst bool) []buzza.ActivityLog {
+	if !oldestFirst {
+		return logs
+	}
+	reversed := make([]buzza.ActivityLog, len(logs))
+	for i, log := range logs {
+		reversed[len(logs)-1-i] = log
+	}
+	return reversed
+}
+
+func logIds(logs []buzza.ActivityLog) []int64 {
+	ids := make([]int64, len(logs))
+	for i, log := range logs {
+		ids[i] = log.Id
+	}
+	return ids
+}
@@ -0,0 +1,96 @@
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+// Store implementing both activity store interfaces.
+type ActivityRetentionStore interface {
+	buzza.ActivityStore
+	buzza.ActivityRetentionStore
+}
+
+// Run ActivityRetentionStore conformance test. Store must be empty and have no legal holds.
+func RunActivityRetentionStoreTests(t *testing.T, store ActivityRetentionStore) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	const uid, heldUid = buzza.UserId(1), buzza.UserId(2)
+	for _, name := range []string{"session_created", "logged_out", "session_created", "click"} {
+		if !assert.NoError(store.AddLog(ctx, uid, buzza.Activity{Name: name})) {
+			return
+		}
+	}
+	if !assert.NoError(store.AddLog(ctx, heldUid, buzza.Activity{Name: "session_created"})) {
+		return
+	}
+	if !assert.NoError(store.SetLegalHold(ctx, heldUid, "investigation")) {
+		return
+	}
+	// all logs are created before the future bound
+	future := time.Now().Add(time.Hour)
+
+	expired, err := store.Expired(ctx, buzza.ActivityExpiryQuery{Before: time.Now().Add(-time.Hour), Limit: 10})
+	if assert.NoError(err) {
+		assert.Len(expired, 0)
+	}
+
+	expired, err = store.Expired(ctx, buzza.ActivityExpiryQuery{Before: future, Limit: 10})
+	if !assert.NoError(err) || !assert.Len(expired, 4, "held user logs should be excluded") {
+		return
+	}
+	for i, log := range expired {
+		assert.Equal(uid, log.UserId)
+		if i > 0 {
+			assert.Greater(log.Id, expired[i-1].Id, "logs should be ordered oldest first")
+		}
+	}
+
+	expired, err = store.Expired(ctx, buzza.ActivityExpiryQuery{Names: []string{"session_created"}, Before: future, Limit: 1})
+	if assert.NoError(err) && assert.Len(expired, 1) {
+		assert.Equal("session_created", expired[0].Name)
+	}
+	expired, err = store.Expired(ctx, buzza.ActivityExpiryQuery{ExcludeNames: []string{"session_created", "click"},
+		Before: future, Limit: 10})
+	if assert.NoError(err) && assert.Len(expired, 1) {
+		assert.Equal("logged_out", expired[0].Name)
+
+		deleted, err := store.DeleteLogs(ctx, []int64{expired[0].Id})
+		assert.NoError(err)
+		assert.Equal(int64(1), deleted)
+	}
+	deleted, err := store.DeleteLogs(ctx, []int64{})
+	assert.NoError(err)
+	assert.Equal(int64(0), deleted)
+
+	logs, err := store.ByUserId(ctx, uid, buzza.ActivityQuery{Limit: 10})
+	if assert.NoError(err) && assert.Len(logs, 3) {
+		for _, log := range logs {
+			assert.NotEqual("logged_out", log.Name)
+		}
+	}
+
+	assert.NoError(store.SetLegalHold(ctx, heldUid, "court order"))
+	holds, err := store.LegalHolds(ctx)
+	if assert.NoError(err) && assert.Len(holds, 1) {
+		assert.Equal(heldUid, holds[0].UserId)
+		assert.Equal("court order", holds[0].Reason)
+		assert.False(holds[0].CreatedAt.IsZero())
+	}
+
+	assert.NoError(store.ReleaseLegalHold(ctx, heldUid))
+	assert.ErrorIs(store.ReleaseLegalHold(ctx, heldUid), buzza.ErrLegalHoldNotFound)
+	holds, err = store.LegalHolds(ctx)
+	if assert.NoError(err) {
+		assert.Len(holds, 0)
+	}
+	expired, err = store.Expired(ctx, buzza.ActivityExpiryQuery{Before: future, Limit: 10})
+	if assert.NoError(err) {
+		assert.Len(expired, 4, "released user logs should be expired")
+	}
+}
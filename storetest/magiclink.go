@@ -0,0 +1,59 @@
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run MagicLinkStore conformance test. Links are owned by the given user.
+func RunMagicLinkStoreTests(t *testing.T, store buzza.MagicLinkStore, uid buzza.UserId) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	link := buzza.MagicLink{TokenHash: "valid", UserId: uid, CreatedAt: now, ExpiresAt: now.Add(time.Minute)}
+	expired := buzza.MagicLink{TokenHash: "expired", UserId: uid, CreatedAt: now.Add(-time.Hour), ExpiresAt: now}
+	for _, l := range []buzza.MagicLink{link, expired} {
+		if !assert.NoError(store.Add(ctx, l)) {
+			return
+		}
+	}
+
+	_, err := store.Use(ctx, "unknown", now)
+	assert.ErrorIs(err, buzza.ErrInvalidMagicLink)
+	_, err = store.Use(ctx, "expired", now)
+	assert.ErrorIs(err, buzza.ErrInvalidMagicLink)
+
+	userId, err := store.Use(ctx, "valid", now.Add(time.Second))
+	if assert.NoError(err) {
+		assert.Equal(uid, userId)
+	}
+	_, err = store.Use(ctx, "valid", now.Add(time.Second))
+	assert.ErrorIs(err, buzza.ErrInvalidMagicLink, "link can be used once")
+}
+
+// Run RateLimiter conformance test. Clock of the limiter is controlled by advance.
+func RunRateLimiterTests(t *testing.T, limiter buzza.RateLimiter, advance func(d time.Duration)) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	allow := func(key string) bool {
+		allowed, err := limiter.Allow(ctx, key, 2, time.Hour)
+		assert.NoError(err)
+		return allowed
+	}
+	assert.True(allow("a"))
+	assert.True(allow("a"))
+	assert.False(allow("a"))
+	assert.False(allow("a"), "denied hits are not counted, but the limit is still reached")
+	assert.True(allow("b"), "keys are limited separately")
+
+	advance(time.Hour)
+	assert.True(allow("a"), "limit is reset in the next window")
+	assert.True(allow("a"))
+	assert.False(allow("a"))
+}
@@ -0,0 +1,28 @@
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run PasskeyChallengeStore conformance test.
+func RunPasskeyChallengeStoreTests(t *testing.T, store buzza.PasskeyChallengeStore) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	if !assert.NoError(store.Add(ctx, "valid", now.Add(time.Minute))) ||
+		!assert.NoError(store.Add(ctx, "expired", now)) {
+		return
+	}
+
+	assert.ErrorIs(store.Use(ctx, "unknown", now), buzza.ErrInvalidPasskeyCeremony)
+	assert.ErrorIs(store.Use(ctx, "expired", now), buzza.ErrInvalidPasskeyCeremony)
+	assert.NoError(store.Use(ctx, "valid", now.Add(time.Second)))
+	assert.ErrorIs(store.Use(ctx, "valid", now.Add(time.Second)), buzza.ErrInvalidPasskeyCeremony,
+		"challenge can be used once")
+}
@@ -0,0 +1,400 @@
+package storetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Creates an empty session store logging activities to the given store.
+// Users 1 and 2 have no roles.
+type SessionStoreFactory func(activityStore buzza.ActivityStore, policy buzza.SessionPolicy) buzza.SessionStore
+
+// Fake clock of the stores under test, every reading moves it by a microsecond, so timestamps are distinct.
+func NewClock() (now func() time.Time, advance func(time.Duration)) {
+	var mutex sync.Mutex
+	current := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		current = current.Add(time.Microsecond)
+		return current
+	}
+	advance = func(d time.Duration) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		current = current.Add(d)
+	}
+	return now, advance
+}
+
+// Run SessionStore conformance test. Advance moves the clock of stores created by the factory, see NewClock.
+func RunSessionStoreTests(t *testing.T, newStore SessionStoreFactory, advance func(time.Duration)) {
+	t.Run("RegisterAndRefresh", func(t *testing.T) {
+		testSessionRegisterAndRefresh(t, newStore)
+	})
+	t.Run("Invalidate", func(t *testing.T) {
+		testSessionInvalidate(t, newStore)
+	})
+	t.Run("Update", func(t *testing.T) {
+		testSessionUpdate(t, newStore)
+	})
+	t.Run("Limit", func(t *testing.T) {
+		testSessionLimit(t, newStore)
+	})
+	t.Run("Expiry", func(t *testing.T) {
+		testSessionExpiry(t, newStore, advance)
+	})
+}
+
+// Records added activities.
+type activityRecorder struct {
+	logs  []buzza.ActivityLog
+	mutex sync.Mutex
+}
+
+func (r *activityRecorder) store() buzza.ActivityStore {
+	return mock.ActivityStore{
+		AddLogFn: func(ctx context.Context, userId buzza.UserId, activity buzza.Activity) error {
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			r.logs = append(r.logs, buzza.ActivityLog{UserId: userId, Name: activity.Name, Data: activity.Data})
+			return nil
+		},
+	}
+}
+
+func (r *activityRecorder) names() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	names := make([]string, len(r.logs))
+	for i, log := range r.logs {
+		names[i] = log.Name
+	}
+	return names
+}
+
+func (r *activityRecorder) named(name string) []buzza.ActivityLog {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var logs []buzza.ActivityLog
+	for _, log := range r.logs {
+		if log.Name == name {
+			logs = append(logs, log)
+		}
+	}
+	return logs
+}
+
+func (r *activityRecorder) last() buzza.ActivityLog {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.logs[len(r.logs)-1]
+}
+
+func testSessionPolicy() buzza.SessionPolicy {
+	return buzza.SessionPolicy{
+		MaxAge:             3 * time.Hour,
+		IdleTimeout:        time.Hour,
+		TrustedIdleTimeout: 2 * time.Hour,
+	}
+}
+
+func testSessionRegisterAndRefresh(t *testing.T, newStore SessionStoreFactory) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	var activities activityRecorder
+	store := newStore(activities.store(), testSessionPolicy())
+
+	session, err := store.RegisterNew(ctx, 1, "192.168.0.101", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NotEmpty(session.Id)
+	assert.NotEmpty(session.Token)
+	assert.Equal(buzza.UserId(1), session.UserId)
+	assert.Equal("192.168.0.101", session.Ip)
+	assert.Equal("Firefox", session.UserAgent)
+	assert.Equal(buzza.ParseUserAgent("Firefox"), session.Agent)
+	assert.False(session.CreatedAt.IsZero())
+	assert.Equal(session.CreatedAt, session.LastAccessedAt)
+	assert.True(session.ExpiresAt.After(session.LastAccessedAt))
+	assert.Equal([]string{buzza.ActivitySessionCreated}, activities.names())
+	assert.Equal(session.Id, activities.last().Data["session_id"])
+
+	byToken, err := store.ByToken(session.Token)
+	if assert.NoError(err) {
+		assert.Equal(session.Id, byToken.Id)
+		assert.Equal(session.UserId, byToken.UserId)
+	}
+	exists, err := store.Exists(session.Token)
+	if assert.NoError(err) {
+		assert.True(exists)
+	}
+
+	_, err = store.AcquireAndRefresh(ctx, session.Token, "192.168.0.101", "Firefox")
+	if assert.NoError(err) {
+		assert.Len(activities.names(), 1, "refresh without changes should not be logged")
+	}
+
+	refreshed, err := store.AcquireAndRefresh(ctx, session.Token, "192.168.0.102", "Firefox")
+	if assert.NoError(err) {
+		assert.Equal("192.168.0.102", refreshed.Ip)
+		assert.Equal(session.Id, refreshed.Id)
+		assert.Equal(session.Token, refreshed.Token)
+		assert.False(refreshed.LastAccessedAt.Before(session.LastAccessedAt))
+		last := activities.last()
+		assert.Equal(buzza.ActivitySessionChangedIp, last.Name)
+		assert.Equal("192.168.0.101", last.Data["previous_ip"])
+		assert.Equal("192.168.0.102", last.Data["new_ip"])
+	}
+
+	refreshed, err = store.AcquireAndRefresh(ctx, session.Token, "192.168.0.102", "Chrome")
+	if assert.NoError(err) {
+		assert.Equal("Chrome", refreshed.UserAgent)
+		assert.Equal(buzza.ParseUserAgent("Chrome"), refreshed.Agent)
+		assert.Equal(buzza.ActivitySessionChangedUserAgent, activities.last().Name)
+	}
+	assert.Len(activities.names(), 3)
+
+	byToken, err = store.ByToken(session.Token)
+	if assert.NoError(err) {
+		assert.Equal("192.168.0.102", byToken.Ip)
+		assert.Equal("Chrome", byToken.UserAgent)
+	}
+
+	_, err = store.ByToken("unknown")
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+	exists, err = store.Exists("unknown")
+	if assert.NoError(err) {
+		assert.False(exists)
+	}
+	_, err = store.AcquireAndRefresh(ctx, "unknown", "192.168.0.102", "Chrome")
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+	_, err = store.ActiveSessions("unknown")
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+}
+
+func testSessionInvalidate(t *testing.T, newStore SessionStoreFactory) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	var activities activityRecorder
+	store := newStore(activities.store(), testSessionPolicy())
+
+	var sessions []buzza.Session
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		session, err := store.RegisterNew(ctx, 1, ip, "Firefox")
+		if !assert.NoError(err) {
+			return
+		}
+		sessions = append(sessions, session)
+	}
+	foreign, err := store.RegisterNew(ctx, 2, "10.0.0.5", "Chrome")
+	if !assert.NoError(err) {
+		return
+	}
+	assertExists := func(session buzza.Session, expected bool) {
+		exists, err := store.Exists(session.Token)
+		if assert.NoError(err) {
+			assert.Equal(expected, exists, session.Ip)
+		}
+	}
+
+	active, err := store.ActiveSessions(sessions[0].Token)
+	if assert.NoError(err) {
+		assert.ElementsMatch(sessionIds(sessions), sessionIds(active), "foreign sessions should not be listed")
+		for _, session := range active {
+			assert.Empty(session.Token, "tokens should not be revealed after creation")
+		}
+	}
+
+	assert.ErrorIs(store.InvalidateById(2, sessions[0].Id), buzza.ErrSessionNotFound)
+	assertExists(sessions[0], true)
+	assert.NoError(store.InvalidateById(1, sessions[0].Id))
+	assertExists(sessions[0], false)
+	assert.ErrorIs(store.InvalidateById(1, sessions[0].Id), buzza.ErrSessionNotFound)
+	_, err = store.AcquireAndRefresh(ctx, sessions[0].Token, "10.0.0.1", "Firefox")
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+
+	assert.NoError(store.InvalidateByAuthToken(sessions[1].Token))
+	assertExists(sessions[1], false)
+	assert.ErrorIs(store.InvalidateByAuthToken(sessions[1].Token), buzza.ErrSessionNotFound)
+
+	assert.NoError(store.InvalidateAllExpect(sessions[3].Token))
+	assertExists(sessions[2], false)
+	assertExists(sessions[3], true)
+	assertExists(foreign, true)
+	active, err = store.ActiveSessions(sessions[3].Token)
+	if assert.NoError(err) {
+		assert.Equal([]string{sessions[3].Id}, sessionIds(active))
+	}
+	assert.ErrorIs(store.InvalidateAllExpect(sessions[0].Token), buzza.ErrSessionNotFound)
+
+	invalidated, err := store.InvalidateByUserId(ctx, 1)
+	if assert.NoError(err) {
+		assert.Equal(1, invalidated)
+	}
+	assertExists(sessions[3], false)
+	assertExists(foreign, true)
+	invalidated, err = store.InvalidateByUserId(ctx, 1)
+	if assert.NoError(err) {
+		assert.Zero(invalidated)
+	}
+}
+
+func testSessionUpdate(t *testing.T, newStore SessionStoreFactory) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	var activities activityRecorder
+	store := newStore(activities.store(), testSessionPolicy())
+
+	session, err := store.RegisterNew(ctx, 1, "10.0.0.1", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	name, pinned, trusted := "Laptop", true, true
+	updated, err := store.UpdateById(ctx, 1, session.Id, buzza.SessionUpdate{Name: &name, Pinned: &pinned})
+	if assert.NoError(err) {
+		assert.Equal("Laptop", updated.Name)
+		assert.True(updated.Pinned)
+		assert.Empty(updated.Token)
+		assert.False(updated.Trusted)
+		assert.WithinDuration(session.ExpiresAt, updated.ExpiresAt, time.Millisecond)
+	}
+	assert.Equal([]string{buzza.ActivitySessionCreated}, activities.names())
+
+	updated, err = store.UpdateById(ctx, 1, session.Id, buzza.SessionUpdate{Trusted: &trusted})
+	if assert.NoError(err) {
+		assert.Equal("Laptop", updated.Name, "nil fields should be left unchanged")
+		assert.True(updated.Trusted)
+		assert.True(updated.ExpiresAt.After(session.ExpiresAt), "trusted sessions should live longer")
+		assert.Equal(buzza.ActivitySessionTrusted, activities.last().Name)
+	}
+	_, err = store.UpdateById(ctx, 1, session.Id, buzza.SessionUpdate{Trusted: &trusted})
+	if assert.NoError(err) {
+		assert.Len(activities.names(), 2, "unchanged trust should not be logged")
+	}
+
+	byToken, err := store.ByToken(session.Token)
+	if assert.NoError(err) {
+		assert.Equal("Laptop", byToken.Name)
+		assert.True(byToken.Pinned)
+		assert.True(byToken.Trusted)
+	}
+
+	_, err = store.UpdateById(ctx, 2, session.Id, buzza.SessionUpdate{Name: &name})
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+	_, err = store.UpdateById(ctx, 1, "3f1f0f0e-7d4c-4a8e-9a51-000000000000", buzza.SessionUpdate{Name: &name})
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+}
+
+func testSessionLimit(t *testing.T, newStore SessionStoreFactory) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	var activities activityRecorder
+	policy := testSessionPolicy()
+	policy.MaxSessions = 2
+	store := newStore(activities.store(), policy)
+
+	var sessions []buzza.Session
+	for _, userAgent := range []string{"Firefox", "Chrome", "Safari"} {
+		session, err := store.RegisterNew(ctx, 1, "10.0.0.1", userAgent)
+		if !assert.NoError(err) {
+			return
+		}
+		sessions = append(sessions, session)
+		// keeps creation times distinct for stores with coarse clocks
+		time.Sleep(time.Millisecond)
+	}
+	foreign, err := store.RegisterNew(ctx, 2, "10.0.0.2", "Chrome")
+	if !assert.NoError(err) {
+		return
+	}
+
+	active, err := store.ActiveSessions(sessions[2].Token)
+	if assert.NoError(err) {
+		assert.ElementsMatch([]string{sessions[1].Id, sessions[2].Id}, sessionIds(active),
+			"oldest session should be evicted")
+	}
+	exists, err := store.Exists(foreign.Token)
+	if assert.NoError(err) {
+		assert.True(exists, "foreign sessions should not count towards the limit")
+	}
+	evicted := activities.named(buzza.ActivitySessionEvicted)
+	if assert.Len(evicted, 1) {
+		assert.Equal(buzza.UserId(1), evicted[0].UserId)
+		assert.Equal(sessions[0].Id, evicted[0].Data["session_id"])
+	}
+}
+
+func testSessionExpiry(t *testing.T, newStore SessionStoreFactory, advance func(time.Duration)) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	var activities activityRecorder
+	store := newStore(activities.store(), testSessionPolicy())
+
+	idle, err := store.RegisterNew(ctx, 1, "10.0.0.1", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	used, err := store.RegisterNew(ctx, 1, "10.0.0.2", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	trusted, err := store.RegisterNew(ctx, 1, "10.0.0.3", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	trust := true
+	if _, err := store.UpdateById(ctx, 1, trusted.Id, buzza.SessionUpdate{Trusted: &trust}); !assert.NoError(err) {
+		return
+	}
+
+	advance(40 * time.Minute)
+	if _, err := store.AcquireAndRefresh(ctx, used.Token, "10.0.0.2", "Firefox"); !assert.NoError(err) {
+		return
+	}
+	advance(40 * time.Minute)
+
+	// idle timeout passed
+	exists, err := store.Exists(idle.Token)
+	if assert.NoError(err) {
+		assert.False(exists)
+	}
+	_, err = store.ByToken(idle.Token)
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+	_, err = store.AcquireAndRefresh(ctx, idle.Token, "10.0.0.1", "Firefox")
+	assert.ErrorIs(err, buzza.ErrSessionNotFound)
+	assert.ErrorIs(store.InvalidateById(1, idle.Id), buzza.ErrSessionNotFound)
+
+	// refreshed and trusted sessions are still valid
+	active, err := store.ActiveSessions(used.Token)
+	if assert.NoError(err) {
+		assert.ElementsMatch([]string{used.Id, trusted.Id}, sessionIds(active))
+	}
+
+	// used regularly until max age
+	for i := 0; i < 3; i++ {
+		if _, err := store.AcquireAndRefresh(ctx, used.Token, "10.0.0.2", "Firefox"); !assert.NoError(err, i) {
+			return
+		}
+		advance(40 * time.Minute)
+	}
+	_, err = store.AcquireAndRefresh(ctx, used.Token, "10.0.0.2", "Firefox")
+	assert.ErrorIs(err, buzza.ErrSessionNotFound, "session should expire after max age")
+	_, err = store.ActiveSessions(trusted.Token)
+	assert.ErrorIs(err, buzza.ErrSessionNotFound, "trusted session should expire after its idle timeout")
+}
+
+func sessionIds(sessions []buzza.Session) []string {
+	ids := make([]string, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.Id
+	}
+	return ids
+}
@@ -0,0 +1,143 @@
+package buzza
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Client requesting targeted content e.g. feature flags.
+type ClientContext struct {
+	// 0 if client is not logged in.
+	UserId  UserId
+	Roles   Roles
+	Branch  string
+	Version string
+	OS      string
+}
+
+// Audience of the targeted content. Empty criteria match all clients.
+type Targeting struct {
+	Branches []string
+	OS       []string
+	// Client must have at least one of the roles.
+	Roles []RoleId
+	// Inclusive version range.
+	MinVersion string
+	MaxVersion string
+}
+
+func (t Targeting) Validate() error {
+	if t.MinVersion != "" && !ValidVersion(t.MinVersion) {
+		return errors.New("invalid min version")
+	}
+	if t.MaxVersion != "" && !ValidVersion(t.MaxVersion) {
+		return errors.New("invalid max version")
+	}
+	if t.MinVersion != "" && t.MaxVersion != "" && CompareVersions(t.MinVersion, t.MaxVersion) > 0 {
+		return errors.New("min version is greater than max version")
+	}
+	for _, roleId := range t.Roles {
+		if _, ok := AllRoles[roleId]; !ok {
+			return errors.New("unknown role '" + string(roleId) + "'")
+		}
+	}
+	return nil
+}
+
+func (t Targeting) Matches(client ClientContext) bool {
+	if len(t.Branches) > 0 && !containsString(t.Branches, client.Branch) {
+		return false
+	}
+	if len(t.OS) > 0 && !containsString(t.OS, client.OS) {
+		return false
+	}
+	if len(t.Roles) > 0 {
+		hasRole := false
+		for _, roleId := range t.Roles {
+			if client.Roles.Has(roleId) {
+				hasRole = true
+				break
+			}
+		}
+		if !hasRole {
+			return false
+		}
+	}
+	if t.MinVersion != "" || t.MaxVersion != "" {
+		// unknown version can not be placed in the range
+		if !ValidVersion(client.Version) {
+			return false
+		}
+		if t.MinVersion != "" && CompareVersions(client.Version, t.MinVersion) < 0 {
+			return false
+		}
+		if t.MaxVersion != "" && CompareVersions(client.Version, t.MaxVersion) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Version in the dotted numeric format e.g. 1.2.0, optionally prefixed with "v".
+func ValidVersion(version string) bool {
+	_, ok := parseVersion(version)
+	return ok
+}
+
+// Compare dotted numeric versions, missing parts are treated as zeros.
+// Returns -1 if a < b, 0 if a == b and 1 if a > b. Invalid versions are lower than valid ones.
+func CompareVersions(a string, b string) int {
+	aParts, aOk := parseVersion(a)
+	bParts, bOk := parseVersion(b)
+	switch {
+	case !aOk && !bOk:
+		return 0
+	case !aOk:
+		return -1
+	case !bOk:
+		return 1
+	}
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aPart, bPart int
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+		if aPart != bPart {
+			if aPart < bPart {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseVersion(version string) ([]int, bool) {
+	version = strings.TrimPrefix(version, "v")
+	if version == "" {
+		return nil, false
+	}
+	rawParts := strings.Split(version, ".")
+	parts := make([]int, len(rawParts))
+	for i, rawPart := range rawParts {
+		part, err := strconv.Atoi(rawPart)
+		if err != nil || part < 0 {
+			return nil, false
+		}
+		parts[i] = part
+	}
+	return parts, true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
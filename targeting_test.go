@@ -0,0 +1,42 @@
+package buzza
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(0, CompareVersions("1.2.0", "1.2"))
+	assert.Equal(0, CompareVersions("v1.2.0", "1.2.0"))
+	assert.Equal(-1, CompareVersions("1.2.0", "1.10.0"))
+	assert.Equal(1, CompareVersions("2.0", "1.99.99"))
+	assert.Equal(-1, CompareVersions("dev", "0.0.1"))
+	assert.False(ValidVersion("1.2.x"))
+	assert.False(ValidVersion(""))
+}
+
+func TestTargetingMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	pro := Roles{AllRoles[RoleIdPro]}
+	client := ClientContext{UserId: 1, Roles: pro, Branch: "beta", Version: "1.4.2", OS: "windows"}
+
+	assert.True(Targeting{}.Matches(client))
+	assert.True(Targeting{Branches: []string{"stable", "beta"}, OS: []string{"windows"}}.Matches(client))
+	assert.False(Targeting{Branches: []string{"stable"}}.Matches(client))
+	assert.False(Targeting{OS: []string{"linux"}}.Matches(client))
+	assert.True(Targeting{Roles: []RoleId{RoleIdAdmin, RoleIdPro}}.Matches(client))
+	assert.False(Targeting{Roles: []RoleId{RoleIdAdmin}}.Matches(client))
+	assert.True(Targeting{MinVersion: "1.4.0", MaxVersion: "1.4.2"}.Matches(client))
+	assert.False(Targeting{MinVersion: "1.5"}.Matches(client))
+	assert.False(Targeting{MaxVersion: "1.4.1"}.Matches(client))
+	assert.False(Targeting{MinVersion: "1.0"}.Matches(ClientContext{Version: "dev"}))
+
+	assert.NoError(Targeting{Roles: []RoleId{RoleIdPro}, MinVersion: "1.0", MaxVersion: "2.0"}.Validate())
+	assert.Error(Targeting{MinVersion: "2.0", MaxVersion: "1.0"}.Validate())
+	assert.Error(Targeting{MinVersion: "latest"}.Validate())
+	assert.Error(Targeting{Roles: []RoleId{"unknown"}}.Validate())
+}
@@ -14,6 +14,9 @@ func requirePermissions(permission buzza.PermissionName) fiber.Handler {
 		if user.Roles.Access(permission) != buzza.AccessAllowed {
 			return fiber.ErrUnauthorized
 		}
+		if buzza.PermissionRequiresTwoFactor(permission) && ctx.Locals(secondFactorMissingLocalsKey) == true {
+			return fiber.NewError(fiber.StatusForbidden, "two-factor authentication required, log in again")
+		}
 		return nil
 	}
 }
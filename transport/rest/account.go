@@ -0,0 +1,82 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Deletion of the logged in user account.
+type AccountController struct {
+	Deleter *buzza.AccountDeleter
+}
+
+func (c *AccountController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	app.Delete("/account", combineHandlers(requestAuthorizer, c.serveScheduleDeletion))
+	app.Get("/account/deletion", combineHandlers(requestAuthorizer, c.serveDeletion))
+	app.Delete("/account/deletion", combineHandlers(requestAuthorizer, c.serveCancelDeletion))
+}
+
+type accountDeletionBody struct {
+	RequestedAt int64 `json:"requestedAt"`
+	DeleteAt    int64 `json:"deleteAt"`
+}
+
+func newAccountDeletionBody(deletion buzza.AccountDeletion) accountDeletionBody {
+	return accountDeletionBody{
+		RequestedAt: deletion.RequestedAt.Unix(),
+		DeleteAt:    deletion.DeleteAt.Unix(),
+	}
+}
+
+// All sessions including the current one are revoked, the user has to log in again to cancel.
+func (c *AccountController) serveScheduleDeletion(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	deletion, err := c.Deleter.Schedule(ctx.Context(), user.Id)
+	if err != nil {
+		return accountDeletionError(err)
+	}
+	requestLog(ctx).WithField("user_id", user.Id).WithField("delete_at", deletion.DeleteAt).
+		Infoln("Account deletion scheduled.")
+	return ctx.Status(fiber.StatusAccepted).JSON(newAccountDeletionBody(deletion))
+}
+
+func (c *AccountController) serveDeletion(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	deletion, err := c.Deleter.Store.ByUserId(ctx.Context(), user.Id)
+	if err != nil {
+		return accountDeletionError(err)
+	}
+	return ctx.JSON(newAccountDeletionBody(deletion))
+}
+
+func (c *AccountController) serveCancelDeletion(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	if err := c.Deleter.Cancel(ctx.Context(), user.Id); err != nil {
+		return accountDeletionError(err)
+	}
+	requestLog(ctx).WithField("user_id", user.Id).Infoln("Account deletion cancelled.")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func accountDeletionError(err error) error {
+	switch {
+	case errors.Is(err, buzza.ErrAccountDeletionScheduled):
+		return fiber.NewError(fiber.StatusConflict, "deletion already scheduled")
+	case errors.Is(err, buzza.ErrAccountDeletionNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "deletion not scheduled")
+	default:
+		return fmt.Errorf("account deletion: %w", err)
+	}
+}
@@ -0,0 +1,85 @@
+package rest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/discord"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountDeletion(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	userStore := inmem.NewUserStore()
+	activityStore := inmem.NewActivityStore()
+	sessionStore := inmem.NewSessionStore(&activityStore)
+	now := time.Now()
+	deleter := &buzza.AccountDeleter{
+		Store:         &inmem.AccountDeletionStore{Users: &userStore},
+		Sessions:      &sessionStore,
+		ActivityStore: &activityStore,
+		Now:           func() time.Time { return now },
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	authController := AuthController{
+		Providers:    testDiscordProviders(discord.User{Id: "2137", Username: "user", Email: "user@buzkaaclicker.pl"}),
+		SessionStore: &sessionStore,
+		UserStore:    &userStore,
+	}
+	authController.InstallTo(app)
+	accountController := AccountController{Deleter: deleter}
+	accountController.InstallTo(RequestAuthorizer(&sessionStore, &userStore, nil), app)
+
+	request := func(method string, url string, accessToken string, statusCode int) map[string]interface{} {
+		var header map[string]string
+		if accessToken != "" {
+			header = map[string]string{fiber.HeaderAuthorization: "Bearer " + accessToken}
+		}
+		response := map[string]interface{}{}
+		testJsonRequest(t, app, method, url, `{"code":"21"}`, header, statusCode, &response)
+		return response
+	}
+	login := func() (string, buzza.UserId) {
+		session := request("POST", "/auth/discord", "", fiber.StatusCreated)
+		accessToken, _ := session["accessToken"].(string)
+		userId, _ := session["userId"].(float64)
+		return accessToken, buzza.UserId(userId)
+	}
+
+	accessToken, userId := login()
+	request("GET", "/account/deletion", accessToken, fiber.StatusNotFound)
+	request("DELETE", "/account/deletion", accessToken, fiber.StatusNotFound)
+	deletion := request("DELETE", "/account", accessToken, fiber.StatusAccepted)
+	assert.EqualValues(now.Add(buzza.DefaultAccountDeletionGracePeriod).Unix(), deletion["deleteAt"])
+	request("GET", "/account/deletion", accessToken, fiber.StatusUnauthorized)
+
+	// logging in again lets the user cancel the deletion
+	accessToken, sameUserId := login()
+	assert.Equal(userId, sameUserId)
+	request("DELETE", "/account", accessToken, fiber.StatusConflict)
+	accessToken, _ = login()
+	request("GET", "/account/deletion", accessToken, fiber.StatusOK)
+	request("DELETE", "/account/deletion", accessToken, fiber.StatusNoContent)
+	request("GET", "/account/deletion", accessToken, fiber.StatusNotFound)
+
+	request("DELETE", "/account", accessToken, fiber.StatusAccepted)
+	accessToken, _ = login()
+	now = now.Add(buzza.DefaultAccountDeletionGracePeriod)
+	erased, err := deleter.EraseDue(ctx, 10)
+	if assert.NoError(err) {
+		assert.Equal(1, erased)
+	}
+	request("GET", "/account/deletion", accessToken, fiber.StatusUnauthorized)
+	_, err = userStore.ById(ctx, userId)
+	assert.ErrorIs(err, buzza.ErrUserNotFound)
+
+	_, newUserId := login()
+	assert.NotEqual(userId, newUserId, "erased account registers as a new user")
+}
@@ -1,19 +1,44 @@
 package rest
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/buzkaaclicker/buzza"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Interval of the keep-alive comments sent over the idle activity stream.
+	activityStreamPingInterval = 15 * time.Second
+	maxActivityStreamsPerUser  = 5
 )
 
 type ActivityController struct {
 	Store buzza.ActivityStore
+	// Describes logs and upgrades their payloads if set.
+	Catalogue buzza.ActivityCatalogue
+	Broker    buzza.ActivityBroker
+	// Streams of revoked sessions are closed on the next ping if set.
+	Sessions buzza.SessionStore
+
+	// activityStreamPingInterval if 0.
+	pingInterval time.Duration
+	streams      map[buzza.UserId]int
+	streamsMutex sync.Mutex
 }
 
 func (c *ActivityController) InstallTo(authorizationHandler fiber.Handler, app *fiber.App) {
 	app.Get("/activities", c.lastActivityHandler(authorizationHandler))
+	app.Get("/activities/stream", combineHandlers(authorizationHandler, c.serveActivityStream))
 }
 
 func (c *ActivityController) lastActivityHandler(authorizationHandler fiber.Handler) fiber.Handler {
@@ -25,33 +50,244 @@ func (c *ActivityController) serveLastActivity(ctx *fiber.Ctx) error {
 	if !ok {
 		return fiber.ErrUnauthorized
 	}
-	beforeIdRaw := ctx.Query("before")
-	var beforeId int64
-	if beforeIdRaw != "" {
+	query, err := activityQuery(ctx)
+	if err != nil {
+		return err
+	}
+	logs, err := c.Store.ByUserId(ctx.Context(), user.Id, query)
+	if err != nil {
+		return fmt.Errorf("get logs by user id: %w", err)
+	}
+
+	locale := ctx.AcceptsLanguages(buzza.ActivityLocales...)
+	mapped := make([]activityResponse, len(logs))
+	for i, log := range logs {
+		mapped[i] = c.newActivityResponse(log, locale)
+	}
+	return ctx.JSON(mapped)
+}
+
+// Server-Sent Events stream of the new user logs. Logs missed since the "Last-Event-ID"
+// are replayed first, oldest first page by page until the stream is caught up.
+func (c *ActivityController) serveActivityStream(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	var lastId int64
+	if raw := ctx.Get("Last-Event-ID"); raw != "" {
 		var err error
-		beforeId, err = strconv.ParseInt(beforeIdRaw, 10, 64)
+		lastId, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || lastId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid Last-Event-ID")
+		}
+	}
+	var token string
+	if session, ok := ctx.Locals(sessionLocalsKey).(buzza.Session); ok {
+		token = utils.CopyString(session.Token)
+	}
+	if !c.acquireStream(user.Id) {
+		return fiber.NewError(fiber.StatusTooManyRequests, "too many activity streams")
+	}
+	locale := ctx.AcceptsLanguages(buzza.ActivityLocales...)
+	missedQuery := func(ctx context.Context, afterId int64) ([]buzza.ActivityLog, error) {
+		return c.Store.ByUserId(ctx, user.Id,
+			buzza.ActivityQuery{AfterId: afterId, Limit: maxActivityLimit, OldestFirst: true})
+	}
+
+	// subscribe before the replay, so logs added in the meantime are not lost
+	logs, cancel := c.Broker.Subscribe(user.Id)
+	var missed []buzza.ActivityLog
+	if lastId > 0 {
+		var err error
+		missed, err = missedQuery(ctx.Context(), lastId)
 		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "invalid before id")
+			cancel()
+			c.releaseStream(user.Id)
+			return fmt.Errorf("get missed logs: %w", err)
 		}
-	} else {
-		beforeId = -1
 	}
 
-	const activityLimit = 100
-	logs, err := c.Store.ByUserId(ctx.Context(), user.Id, beforeId, activityLimit)
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	ctx.Set(fiber.HeaderConnection, "keep-alive")
+	// disable proxy buffering
+	ctx.Set("X-Accel-Buffering", "no")
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer c.releaseStream(user.Id)
+
+		writeLog := func(log buzza.ActivityLog) error {
+			if log.Id <= lastId {
+				return nil
+			}
+			data, err := json.Marshal(c.newActivityResponse(log, locale))
+			if err != nil {
+				return err
+			}
+			lastId = log.Id
+			_, err = fmt.Fprintf(w, "id: %d\nevent: activity\ndata: %s\n\n", log.Id, data)
+			return err
+		}
+		for len(missed) != 0 {
+			for _, log := range missed {
+				if err := writeLog(log); err != nil {
+					return
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+			if len(missed) < maxActivityLimit {
+				break
+			}
+			dbCtx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+			var err error
+			missed, err = missedQuery(dbCtx, lastId)
+			cancelFunc()
+			if err != nil {
+				// client reconnects and resumes from the last id
+				logrus.WithError(err).WithField("user_id", user.Id).Errorln("Could not get missed logs.")
+				return
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		ping := time.NewTicker(c.streamPingInterval())
+		defer ping.Stop()
+		for {
+			select {
+			case log, ok := <-logs:
+				if !ok {
+					// dropped by the broker, client reconnects and resumes from the last id
+					return
+				}
+				if err := writeLog(log); err != nil {
+					return
+				}
+			case <-ping.C:
+				if !c.sessionAlive(token, user.Id) {
+					return
+				}
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			}
+			// flush fails when client disconnects
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (c *ActivityController) streamPingInterval() time.Duration {
+	if c.pingInterval == 0 {
+		return activityStreamPingInterval
+	}
+	return c.pingInterval
+}
+
+func (c *ActivityController) acquireStream(userId buzza.UserId) bool {
+	c.streamsMutex.Lock()
+	defer c.streamsMutex.Unlock()
+	if c.streams == nil {
+		c.streams = map[buzza.UserId]int{}
+	}
+	if c.streams[userId] >= maxActivityStreamsPerUser {
+		return false
+	}
+	c.streams[userId]++
+	return true
+}
+
+func (c *ActivityController) releaseStream(userId buzza.UserId) {
+	c.streamsMutex.Lock()
+	defer c.streamsMutex.Unlock()
+	c.streams[userId]--
+	if c.streams[userId] <= 0 {
+		delete(c.streams, userId)
+	}
+}
+
+// Whether the session the stream has been opened with has not been revoked (logout, alert, eviction, deletion).
+func (c *ActivityController) sessionAlive(token string, userId buzza.UserId) bool {
+	if c.Sessions == nil || token == "" {
+		return true
+	}
+	exists, err := c.Sessions.Exists(token)
 	if err != nil {
-		return fmt.Errorf("get logs by user id: %w", err)
+		// client reconnects and is authorized again
+		logrus.WithError(err).WithField("user_id", userId).Errorln("Could not check activity stream session.")
+		return false
+	}
+	return exists
+}
+
+type activityResponse struct {
+	Id          int64                  `json:"id"`
+	CreatedAt   int64                  `json:"createdAt"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+}
+
+func (c *ActivityController) newActivityResponse(log buzza.ActivityLog, locale string) activityResponse {
+	var description string
+	if c.Catalogue != nil {
+		// clients get the single, current payload shape
+		log = c.Catalogue.Upgrade(log)
+		description = c.Catalogue.Describe(log, locale)
 	}
+	return activityResponse{Id: log.Id, CreatedAt: log.CreatedAt.Unix(), Name: log.Name,
+		Description: description, Data: log.Data}
+}
+
+const (
+	defaultActivityLimit = 100
+	maxActivityLimit     = 500
+)
 
-	type Log struct {
-		Id        int64                  `json:"id"`
-		CreatedAt int64                  `json:"createdAt"`
-		Name      string                 `json:"name"`
-		Data      map[string]interface{} `json:"data,omitempty"`
+// Parse filters from "before", "limit", "name" (comma separated), "from", "to" (unix seconds),
+// "session" and "ip" query params.
+func activityQuery(ctx *fiber.Ctx) (buzza.ActivityQuery, error) {
+	query := buzza.ActivityQuery{Limit: defaultActivityLimit}
+	if raw := ctx.Query("before"); raw != "" {
+		beforeId, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || beforeId <= 0 {
+			return buzza.ActivityQuery{}, fiber.NewError(fiber.StatusBadRequest, "invalid before id")
+		}
+		query.BeforeId = beforeId
 	}
-	mapped := make([]Log, len(logs))
-	for i, log := range logs {
-		mapped[i] = Log{Id: log.Id, CreatedAt: log.CreatedAt.Unix(), Name: log.Name, Data: log.Data}
+	if raw := ctx.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxActivityLimit {
+			return buzza.ActivityQuery{}, fiber.NewError(fiber.StatusBadRequest, "invalid limit")
+		}
+		query.Limit = limit
 	}
-	return ctx.JSON(mapped)
+	if raw := ctx.Query("name"); raw != "" {
+		// query values are backed by the fasthttp buffer, so copy them
+		query.Names = strings.Split(utils.CopyString(raw), ",")
+	}
+	for _, bound := range []struct {
+		param  string
+		target *time.Time
+	}{{"from", &query.From}, {"to", &query.To}} {
+		raw := ctx.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		unix, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || unix < 0 {
+			return buzza.ActivityQuery{}, fiber.NewError(fiber.StatusBadRequest, "invalid "+bound.param+" time")
+		}
+		*bound.target = time.Unix(unix, 0).UTC()
+	}
+	query.SessionId = utils.CopyString(ctx.Query("session"))
+	query.Ip = utils.CopyString(ctx.Query("ip"))
+	return query, nil
 }
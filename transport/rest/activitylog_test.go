@@ -2,12 +2,15 @@ package rest
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/inmem"
 	"github.com/buzkaaclicker/buzza/mock"
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
@@ -15,7 +18,7 @@ import (
 
 func TestActivityController(t *testing.T) {
 	store := &mock.ActivityStore{
-		ByUserIdFn: func(ctx context.Context, userId buzza.UserId, beforeId int64, limit int32) ([]buzza.ActivityLog, error) {
+		ByUserIdFn: func(ctx context.Context, userId buzza.UserId, query buzza.ActivityQuery) ([]buzza.ActivityLog, error) {
 			allLogs := []buzza.ActivityLog{
 				{
 					Id:        3,
@@ -43,7 +46,7 @@ func TestActivityController(t *testing.T) {
 					},
 				},
 			}
-			if beforeId >= 0 && beforeId <= 2 {
+			if query.BeforeId > 0 && query.BeforeId <= 2 {
 				return allLogs[:2], nil
 			}
 			return allLogs, nil
@@ -87,3 +90,245 @@ func TestActivityController(t *testing.T) {
 		assert.Equal(t, tc.response, string(body))
 	}
 }
+
+func TestActivityControllerQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	var lastQuery buzza.ActivityQuery
+	store := &mock.ActivityStore{
+		ByUserIdFn: func(ctx context.Context, userId buzza.UserId, query buzza.ActivityQuery) ([]buzza.ActivityLog, error) {
+			lastQuery = query
+			return []buzza.ActivityLog{}, nil
+		},
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := ActivityController{Store: store}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, buzza.User{Id: 22})
+		return nil
+	}, app)
+
+	cases := []struct {
+		url        string
+		statusCode int
+		query      buzza.ActivityQuery
+	}{
+		{url: "/activities", statusCode: fiber.StatusOK, query: buzza.ActivityQuery{Limit: 100}},
+		{url: "/activities?before=10&limit=20&name=session_created,session_changed_ip&from=1640995200&to=1641081600&session=abc",
+			statusCode: fiber.StatusOK, query: buzza.ActivityQuery{
+				BeforeId:  10,
+				Names:     []string{"session_created", "session_changed_ip"},
+				From:      time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+				To:        time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC),
+				SessionId: "abc",
+				Limit:     20,
+			}},
+		{url: "/activities?limit=501", statusCode: fiber.StatusBadRequest},
+		{url: "/activities?before=0", statusCode: fiber.StatusBadRequest},
+		{url: "/activities?from=yesterday", statusCode: fiber.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		lastQuery = buzza.ActivityQuery{}
+		resp, err := app.Test(httptest.NewRequest("GET", tc.url, nil))
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, tc.url)
+		assert.Equal(tc.query, lastQuery, tc.url)
+	}
+}
+
+func TestActivityControllerDescriptions(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &mock.ActivityStore{
+		ByUserIdFn: func(ctx context.Context, userId buzza.UserId, query buzza.ActivityQuery) ([]buzza.ActivityLog, error) {
+			return []buzza.ActivityLog{{
+				Id:        1,
+				CreatedAt: time.Date(2022, 1, 1, 15, 0, 0, 0, time.UTC),
+				UserId:    22,
+				Name:      buzza.ActivitySessionChangedIp,
+				Version:   1,
+				Data:      map[string]interface{}{"session_id": "a", "previous_ip": "1.1.1.1", "new_ip": "1.0.0.1"},
+			}}, nil
+		},
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := ActivityController{Store: store, Catalogue: buzza.DefaultActivityCatalogue}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, buzza.User{Id: 22})
+		return nil
+	}, app)
+
+	cases := []struct {
+		acceptLanguage string
+		description    string
+	}{
+		{acceptLanguage: "", description: "Session IP address changed from 1.1.1.1 to 1.0.0.1."},
+		{acceptLanguage: "pl-PL,pl;q=0.9,en;q=0.8", description: "Adres IP sesji zmienił się z 1.1.1.1 na 1.0.0.1."},
+		{acceptLanguage: "de", description: "Session IP address changed from 1.1.1.1 to 1.0.0.1."},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/activities", nil)
+		if tc.acceptLanguage != "" {
+			req.Header.Set(fiber.HeaderAcceptLanguage, tc.acceptLanguage)
+		}
+		resp, err := app.Test(req)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(`[{"id":1,"createdAt":1641049200,"name":"session_changed_ip",`+
+			`"description":"`+tc.description+`",`+
+			`"data":{"new_ip":"1.0.0.1","previous_ip":"1.1.1.1","session_id":"a"}}]`, string(body), tc.acceptLanguage)
+	}
+}
+
+func TestActivityControllerStream(t *testing.T) {
+	assert := assert.New(t)
+
+	createdAt := time.Date(2022, 1, 1, 15, 0, 0, 0, time.UTC)
+	var storeQueries []buzza.ActivityQuery
+	store := &mock.ActivityStore{
+		ByUserIdFn: func(ctx context.Context, userId buzza.UserId, query buzza.ActivityQuery) ([]buzza.ActivityLog, error) {
+			storeQueries = append(storeQueries, query)
+			if query.AfterId >= 1000 {
+				// full page of logs, so the next one is requested
+				logs := make([]buzza.ActivityLog, 0, maxActivityLimit)
+				for id := query.AfterId + 1; id <= 1000+maxActivityLimit+1 && len(logs) < maxActivityLimit; id++ {
+					logs = append(logs, buzza.ActivityLog{Id: id, CreatedAt: createdAt, UserId: userId, Name: "d"})
+				}
+				return logs, nil
+			}
+			return []buzza.ActivityLog{
+				{Id: 5, CreatedAt: createdAt, UserId: userId, Name: "a"},
+				{Id: 6, CreatedAt: createdAt, UserId: userId, Name: "b"},
+			}, nil
+		},
+	}
+	var subscribedUserId buzza.UserId
+	cancelled := false
+	broker := &mock.ActivityBroker{
+		SubscribeFn: func(userId buzza.UserId) (<-chan buzza.ActivityLog, func()) {
+			subscribedUserId = userId
+			logs := make(chan buzza.ActivityLog, 2)
+			// log 6 has been published during the replay
+			logs <- buzza.ActivityLog{Id: 6, CreatedAt: createdAt, UserId: userId, Name: "b"}
+			logs <- buzza.ActivityLog{Id: 7, CreatedAt: createdAt, UserId: userId, Name: "c"}
+			close(logs)
+			return logs, func() { cancelled = true }
+		},
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := ActivityController{Store: store, Broker: broker}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, buzza.User{Id: 22})
+		return nil
+	}, app)
+
+	req := httptest.NewRequest("GET", "/activities/stream", nil)
+	req.Header.Set("Last-Event-ID", "4")
+	resp, err := app.Test(req)
+	if !assert.NoError(err) {
+		return
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(fiber.StatusOK, resp.StatusCode)
+	assert.Equal("text/event-stream", resp.Header.Get(fiber.HeaderContentType))
+	assert.Equal(buzza.UserId(22), subscribedUserId)
+	assert.Equal([]buzza.ActivityQuery{{AfterId: 4, Limit: maxActivityLimit, OldestFirst: true}}, storeQueries)
+	assert.True(cancelled)
+	assert.Equal("id: 5\nevent: activity\ndata: {\"id\":5,\"createdAt\":1641049200,\"name\":\"a\"}\n\n"+
+		"id: 6\nevent: activity\ndata: {\"id\":6,\"createdAt\":1641049200,\"name\":\"b\"}\n\n"+
+		"id: 7\nevent: activity\ndata: {\"id\":7,\"createdAt\":1641049200,\"name\":\"c\"}\n\n", string(body))
+
+	storeQueries = nil
+	req = httptest.NewRequest("GET", "/activities/stream", nil)
+	req.Header.Set("Last-Event-ID", "1000")
+	resp, err = app.Test(req)
+	if !assert.NoError(err) {
+		return
+	}
+	body, err = ioutil.ReadAll(resp.Body)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]buzza.ActivityQuery{
+		{AfterId: 1000, Limit: maxActivityLimit, OldestFirst: true},
+		{AfterId: 1000 + maxActivityLimit, Limit: maxActivityLimit, OldestFirst: true},
+	}, storeQueries)
+	assert.Equal(maxActivityLimit+1, strings.Count(string(body), "event: activity"))
+	assert.True(strings.HasPrefix(string(body), "id: 1001\n"))
+	assert.Contains(string(body), fmt.Sprintf("id: %d\n", 1000+maxActivityLimit+1))
+
+	req = httptest.NewRequest("GET", "/activities/stream", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	resp, err = app.Test(req)
+	if assert.NoError(err) {
+		assert.Equal(fiber.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestActivityStreamSessionRevoked(t *testing.T) {
+	assert := assert.New(t)
+
+	activityStore := inmem.NewActivityStore()
+	sessionStore := inmem.NewSessionStore(&activityStore)
+	session, err := sessionStore.RegisterNew(context.Background(), 22, "1.1.1.1", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	broker := &mock.ActivityBroker{
+		SubscribeFn: func(userId buzza.UserId) (<-chan buzza.ActivityLog, func()) {
+			return make(chan buzza.ActivityLog), func() {}
+		},
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := &ActivityController{Store: &activityStore, Broker: broker, Sessions: &sessionStore,
+		pingInterval: 10 * time.Millisecond}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, buzza.User{Id: 22})
+		ctx.Locals(sessionLocalsKey, session)
+		return nil
+	}, app)
+	openStreams := func() int {
+		controller.streamsMutex.Lock()
+		defer controller.streamsMutex.Unlock()
+		return controller.streams[22]
+	}
+
+	closed := make(chan int, maxActivityStreamsPerUser)
+	for i := 0; i < maxActivityStreamsPerUser; i++ {
+		go func() {
+			resp, err := app.Test(httptest.NewRequest("GET", "/activities/stream", nil), 5000)
+			if err != nil {
+				closed <- 0
+				return
+			}
+			closed <- resp.StatusCode
+		}()
+	}
+	assert.Eventually(func() bool { return openStreams() == maxActivityStreamsPerUser }, time.Second, time.Millisecond)
+	resp, err := app.Test(httptest.NewRequest("GET", "/activities/stream", nil))
+	if assert.NoError(err) {
+		assert.Equal(fiber.StatusTooManyRequests, resp.StatusCode, "streams per user are limited")
+	}
+
+	assert.NoError(sessionStore.InvalidateByAuthToken(session.Token))
+	for i := 0; i < maxActivityStreamsPerUser; i++ {
+		select {
+		case statusCode := <-closed:
+			assert.Equal(fiber.StatusOK, statusCode)
+		case <-time.After(time.Second):
+			assert.Fail("stream of the revoked session is not closed")
+			return
+		}
+	}
+	assert.Zero(openStreams())
+}
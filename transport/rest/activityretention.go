@@ -0,0 +1,126 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Admin view of the activity log pruning and management of the legal holds.
+type ActivityRetentionController struct {
+	Store  buzza.ActivityRetentionStore
+	Pruner *buzza.ActivityPruner
+}
+
+func (c *ActivityRetentionController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	requireAdmin := requirePermissions(buzza.PermissionAdminDashboard)
+	app.Get("/admin/activity-retention", combineHandlers(requestAuthorizer, requireAdmin, c.serveRetention))
+	app.Get("/admin/legal-holds", combineHandlers(requestAuthorizer, requireAdmin, c.serveLegalHolds))
+	app.Put("/admin/legal-holds/:userId", combineHandlers(requestAuthorizer, requireAdmin, c.serveSetLegalHold))
+	app.Delete("/admin/legal-holds/:userId", combineHandlers(requestAuthorizer, requireAdmin, c.serveReleaseLegalHold))
+}
+
+// Retention policy in days and metrics of the pruning job.
+func (c *ActivityRetentionController) serveRetention(ctx *fiber.Ctx) error {
+	const day = 24 * 60 * 60
+	policy := c.Pruner.Policy
+	type Retention struct {
+		Name string `json:"name"`
+		Days int64  `json:"days"`
+	}
+	byName := make([]Retention, 0, len(policy.ByName))
+	for name, retention := range policy.ByName {
+		byName = append(byName, Retention{Name: name, Days: int64(retention.Seconds()) / day})
+	}
+	sort.Slice(byName, func(i, j int) bool {
+		return byName[i].Name < byName[j].Name
+	})
+
+	metrics := c.Pruner.Metrics()
+	var lastRunAt int64
+	if !metrics.LastRunAt.IsZero() {
+		lastRunAt = metrics.LastRunAt.Unix()
+	}
+	return ctx.JSON(map[string]interface{}{
+		"policy": map[string]interface{}{
+			"defaultDays": int64(policy.Default.Seconds()) / day,
+			"byName":      byName,
+		},
+		"metrics": map[string]interface{}{
+			"runs":           metrics.Runs,
+			"failures":       metrics.Failures,
+			"deleted":        metrics.Deleted,
+			"archived":       metrics.Archived,
+			"lastRunAt":      lastRunAt,
+			"lastDurationMs": metrics.LastDuration.Milliseconds(),
+		},
+	})
+}
+
+func (c *ActivityRetentionController) serveLegalHolds(ctx *fiber.Ctx) error {
+	holds, err := c.Store.LegalHolds(ctx.Context())
+	if err != nil {
+		return fmt.Errorf("get legal holds: %w", err)
+	}
+
+	type Hold struct {
+		UserId    buzza.UserId `json:"userId"`
+		Reason    string       `json:"reason"`
+		CreatedAt int64        `json:"createdAt"`
+	}
+	mapped := make([]Hold, len(holds))
+	for i, h := range holds {
+		mapped[i] = Hold{UserId: h.UserId, Reason: h.Reason, CreatedAt: h.CreatedAt.Unix()}
+	}
+	return ctx.JSON(mapped)
+}
+
+func (c *ActivityRetentionController) serveSetLegalHold(ctx *fiber.Ctx) error {
+	userId, err := legalHoldUserIdParam(ctx)
+	if err != nil {
+		return err
+	}
+	body := struct {
+		Reason string `json:"reason"`
+	}{}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	if body.Reason == "" || len(body.Reason) > 1000 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid reason")
+	}
+
+	if err := c.Store.SetLegalHold(ctx.Context(), userId, body.Reason); err != nil {
+		return fmt.Errorf("set legal hold: %w", err)
+	}
+	requestLog(ctx).WithField("hold_user_id", userId).Infoln("Legal hold set.")
+	return nil
+}
+
+func (c *ActivityRetentionController) serveReleaseLegalHold(ctx *fiber.Ctx) error {
+	userId, err := legalHoldUserIdParam(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.Store.ReleaseLegalHold(ctx.Context(), userId); err != nil {
+		if errors.Is(err, buzza.ErrLegalHoldNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "legal hold not found")
+		} else {
+			return fmt.Errorf("release legal hold: %w", err)
+		}
+	}
+	requestLog(ctx).WithField("hold_user_id", userId).Infoln("Legal hold released.")
+	return nil
+}
+
+func legalHoldUserIdParam(ctx *fiber.Ctx) (buzza.UserId, error) {
+	id, err := strconv.ParseInt(ctx.Params("userId"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "invalid user id")
+	}
+	return buzza.UserId(id), nil
+}
@@ -0,0 +1,91 @@
+package rest
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivityRetentionController(t *testing.T) {
+	assert := assert.New(t)
+
+	store := inmem.NewActivityStore()
+	pruner := &buzza.ActivityPruner{
+		Store: &store,
+		Policy: buzza.ActivityRetentionPolicy{
+			Default: 90 * 24 * time.Hour,
+			ByName:  map[string]time.Duration{"session_created": 365 * 24 * time.Hour},
+		},
+	}
+	_, err := pruner.Prune(context.Background(), time.Unix(1641081600, 0))
+	if !assert.NoError(err) {
+		return
+	}
+
+	var currentUser buzza.User
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := ActivityRetentionController{Store: &store, Pruner: pruner}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, currentUser)
+		return nil
+	}, app)
+
+	admin := buzza.User{Id: 1, Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}}
+	cases := []struct {
+		user       buzza.User
+		method     string
+		url        string
+		body       string
+		statusCode int
+		response   string
+	}{
+		{user: buzza.User{Id: 7}, method: "GET", url: "/admin/legal-holds", statusCode: fiber.StatusUnauthorized,
+			response: JsonErrorMessageResponse(fiber.ErrUnauthorized.Message)},
+		{user: admin, method: "GET", url: "/admin/activity-retention", statusCode: fiber.StatusOK,
+			response: `{"metrics":{"archived":0,"deleted":0,"failures":0,"lastDurationMs":0,"lastRunAt":1641081600,"runs":1},` +
+				`"policy":{"byName":[{"name":"session_created","days":365}],"defaultDays":90}}`},
+		{user: admin, method: "PUT", url: "/admin/legal-holds/abc", body: `{"reason":"x"}`,
+			statusCode: fiber.StatusBadRequest, response: JsonErrorMessageResponse("invalid user id")},
+		{user: admin, method: "PUT", url: "/admin/legal-holds/5", body: `{"reason":""}`,
+			statusCode: fiber.StatusBadRequest, response: JsonErrorMessageResponse("invalid reason")},
+		{user: admin, method: "PUT", url: "/admin/legal-holds/5", body: `{"reason":"investigation"}`,
+			statusCode: fiber.StatusOK},
+		{user: admin, method: "DELETE", url: "/admin/legal-holds/6",
+			statusCode: fiber.StatusNotFound, response: JsonErrorMessageResponse("legal hold not found")},
+	}
+	for _, tc := range cases {
+		currentUser = tc.user
+		var reqBody io.Reader
+		if tc.body != "" {
+			reqBody = strings.NewReader(tc.body)
+		}
+		req := httptest.NewRequest(tc.method, tc.url, reqBody)
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, tc.url)
+		assert.Equal(tc.response, string(body), tc.url)
+	}
+
+	holds, err := store.LegalHolds(context.Background())
+	if assert.NoError(err) && assert.Len(holds, 1) {
+		assert.Equal(buzza.UserId(5), holds[0].UserId)
+		assert.Equal("investigation", holds[0].Reason)
+	}
+}
@@ -0,0 +1,223 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AnnouncementController struct {
+	Store buzza.AnnouncementStore
+}
+
+func (c *AnnouncementController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	// unread markers are available only for logged in users
+	app.Get("/announcements", combineHandlers(optionalAuthorization(requestAuthorizer), c.serveFeed))
+	app.Put("/announcements/read-marker", combineHandlers(requestAuthorizer, c.serveMarkRead))
+
+	requireAdmin := requirePermissions(buzza.PermissionAdminDashboard)
+	app.Get("/admin/announcements", combineHandlers(requestAuthorizer, requireAdmin, c.serveAll))
+	app.Post("/admin/announcements", combineHandlers(requestAuthorizer, requireAdmin, c.serveCreate))
+	app.Put("/admin/announcements/:id", combineHandlers(requestAuthorizer, requireAdmin, c.serveUpdate))
+	app.Delete("/admin/announcements/:id", combineHandlers(requestAuthorizer, requireAdmin, c.serveDelete))
+}
+
+type announcementResponse struct {
+	Id        int64          `json:"id"`
+	Title     string         `json:"title"`
+	Body      string         `json:"body"`
+	Severity  string         `json:"severity"`
+	StartsAt  int64          `json:"startsAt"`
+	EndsAt    int64          `json:"endsAt,omitempty"`
+	Unread    bool           `json:"unread"`
+	Targeting *targetingBody `json:"targeting,omitempty"`
+}
+
+func newAnnouncementResponse(a buzza.Announcement) announcementResponse {
+	response := announcementResponse{
+		Id:       a.Id,
+		Title:    a.Title,
+		Body:     a.Body,
+		Severity: string(a.Severity),
+		StartsAt: a.StartsAt.Unix(),
+	}
+	if !a.EndsAt.IsZero() {
+		response.EndsAt = a.EndsAt.Unix()
+	}
+	return response
+}
+
+func (c *AnnouncementController) serveFeed(ctx *fiber.Ctx) error {
+	offset, limit, err := paginationQuery(ctx, 10, 50)
+	if err != nil {
+		return err
+	}
+	client := clientContext(ctx)
+	visible, err := c.Store.Visible(ctx.Context(), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("get visible announcements: %w", err)
+	}
+	// targeting is evaluated in code, so the feed is paginated after filtering
+	announcements := buzza.FilterAnnouncements(visible, client)
+
+	var readAt time.Time
+	if client.UserId != 0 {
+		readAt, err = c.Store.ReadMarker(ctx.Context(), client.UserId)
+		if err != nil {
+			return fmt.Errorf("get read marker: %w", err)
+		}
+	}
+	unreadCount := 0
+	mapped := make([]announcementResponse, 0, limit)
+	for i, a := range announcements {
+		unread := client.UserId != 0 && a.StartsAt.After(readAt)
+		if unread {
+			unreadCount++
+		}
+		if i >= offset && i < offset+limit {
+			response := newAnnouncementResponse(a)
+			response.Unread = unread
+			mapped = append(mapped, response)
+		}
+	}
+	return ctx.JSON(map[string]interface{}{
+		"total":         len(announcements),
+		"unreadCount":   unreadCount,
+		"announcements": mapped,
+	})
+}
+
+func (c *AnnouncementController) serveMarkRead(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	if err := c.Store.SetReadMarker(ctx.Context(), user.Id, time.Now().UTC()); err != nil {
+		return fmt.Errorf("set read marker: %w", err)
+	}
+	return nil
+}
+
+func (c *AnnouncementController) serveAll(ctx *fiber.Ctx) error {
+	offset, limit, err := paginationQuery(ctx, 20, 100)
+	if err != nil {
+		return err
+	}
+	announcements, err := c.Store.All(ctx.Context(), offset, limit)
+	if err != nil {
+		return fmt.Errorf("get announcements: %w", err)
+	}
+	mapped := make([]announcementResponse, len(announcements))
+	for i, a := range announcements {
+		mapped[i] = newAnnouncementResponse(a)
+		targeting := newTargetingBody(a.Targeting)
+		mapped[i].Targeting = &targeting
+	}
+	return ctx.JSON(mapped)
+}
+
+func (c *AnnouncementController) serveCreate(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	announcement, err := announcementBody(ctx)
+	if err != nil {
+		return err
+	}
+	announcement.AuthorId = user.Id
+
+	created, err := c.Store.Create(ctx.Context(), announcement)
+	if err != nil {
+		return fmt.Errorf("create announcement: %w", err)
+	}
+	requestLog(ctx).
+		WithField("user_id", user.Id).
+		WithField("announcement_id", created.Id).
+		Infoln("Announcement created.")
+	return ctx.Status(fiber.StatusCreated).JSON(newAnnouncementResponse(created))
+}
+
+func (c *AnnouncementController) serveUpdate(ctx *fiber.Ctx) error {
+	id, err := announcementIdParam(ctx)
+	if err != nil {
+		return err
+	}
+	announcement, err := announcementBody(ctx)
+	if err != nil {
+		return err
+	}
+	announcement.Id = id
+
+	updated, err := c.Store.Update(ctx.Context(), announcement)
+	if err != nil {
+		return announcementError(err)
+	}
+	return ctx.JSON(newAnnouncementResponse(updated))
+}
+
+func (c *AnnouncementController) serveDelete(ctx *fiber.Ctx) error {
+	id, err := announcementIdParam(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.Store.Delete(ctx.Context(), id); err != nil {
+		return announcementError(err)
+	}
+	return nil
+}
+
+// Announcement from the admin request body. Missing start time means now.
+func announcementBody(ctx *fiber.Ctx) (buzza.Announcement, error) {
+	body := struct {
+		Title     string        `json:"title"`
+		Body      string        `json:"body"`
+		Severity  string        `json:"severity"`
+		Targeting targetingBody `json:"targeting"`
+		StartsAt  int64         `json:"startsAt"`
+		EndsAt    int64         `json:"endsAt"`
+	}{}
+	if err := ctx.BodyParser(&body); err != nil {
+		return buzza.Announcement{}, fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+
+	announcement := buzza.Announcement{
+		Title:     body.Title,
+		Body:      body.Body,
+		Severity:  buzza.AnnouncementSeverity(body.Severity),
+		Targeting: body.Targeting.toDomain(),
+		StartsAt:  time.Now().UTC(),
+	}
+	if announcement.Severity == "" {
+		announcement.Severity = buzza.AnnouncementSeverityInfo
+	}
+	if body.StartsAt != 0 {
+		announcement.StartsAt = time.Unix(body.StartsAt, 0).UTC()
+	}
+	if body.EndsAt != 0 {
+		announcement.EndsAt = time.Unix(body.EndsAt, 0).UTC()
+	}
+	if err := announcement.Validate(); err != nil {
+		return buzza.Announcement{}, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return announcement, nil
+}
+
+func announcementIdParam(ctx *fiber.Ctx) (int64, error) {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "invalid announcement id")
+	}
+	return id, nil
+}
+
+func announcementError(err error) error {
+	if errors.Is(err, buzza.ErrAnnouncementNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, "announcement not found")
+	}
+	return fmt.Errorf("announcement store: %w", err)
+}
@@ -0,0 +1,166 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnouncementControllerFeed(t *testing.T) {
+	assert := assert.New(t)
+
+	readAt := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+	var markedUser buzza.UserId
+	store := mock.AnnouncementStore{
+		VisibleFn: func(ctx context.Context, at time.Time) ([]buzza.Announcement, error) {
+			return []buzza.Announcement{
+				{Id: 4, Title: "Pro", Body: "pro only", Severity: buzza.AnnouncementSeverityInfo,
+					Targeting: buzza.Targeting{Roles: []buzza.RoleId{buzza.RoleIdPro}},
+					StartsAt:  time.Date(2022, 1, 4, 0, 0, 0, 0, time.UTC)},
+				{Id: 3, Title: "Beta", Body: "beta", Severity: buzza.AnnouncementSeverityWarning,
+					Targeting: buzza.Targeting{Branches: []string{"beta"}},
+					StartsAt:  time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC),
+					EndsAt:    time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+				{Id: 1, Title: "Hello", Body: "hello", Severity: buzza.AnnouncementSeverityInfo,
+					StartsAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+			}, nil
+		},
+		ReadMarkerFn: func(ctx context.Context, userId buzza.UserId) (time.Time, error) {
+			return readAt, nil
+		},
+		SetReadMarkerFn: func(ctx context.Context, userId buzza.UserId, at time.Time) error {
+			markedUser = userId
+			return nil
+		},
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := AnnouncementController{Store: store}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, buzza.User{Id: 5, Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdPro]}})
+		return nil
+	}, app)
+
+	cases := []struct {
+		method     string
+		url        string
+		authorized bool
+		statusCode int
+		response   string
+	}{
+		{method: "GET", url: "/announcements?branch=stable", statusCode: fiber.StatusOK,
+			response: `{"announcements":[{"id":1,"title":"Hello","body":"hello","severity":"info",` +
+				`"startsAt":1640995200,"unread":false}],"total":1,"unreadCount":0}`},
+		{method: "GET", url: "/announcements?branch=beta&limit=1&page=2", authorized: true, statusCode: fiber.StatusOK,
+			response: `{"announcements":[{"id":3,"title":"Beta","body":"beta","severity":"warning",` +
+				`"startsAt":1641168000,"endsAt":1643673600,"unread":true}],"total":3,"unreadCount":2}`},
+		{method: "PUT", url: "/announcements/read-marker", authorized: true, statusCode: fiber.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.url, nil)
+		if tc.authorized {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer token")
+		}
+		resp, err := app.Test(req)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, tc.url)
+		assert.Equal(tc.response, string(body), tc.url)
+	}
+	assert.Equal(buzza.UserId(5), markedUser)
+}
+
+func TestAnnouncementControllerAdmin(t *testing.T) {
+	assert := assert.New(t)
+
+	var created buzza.Announcement
+	store := mock.AnnouncementStore{
+		CreateFn: func(ctx context.Context, announcement buzza.Announcement) (buzza.Announcement, error) {
+			created = announcement
+			announcement.Id = 7
+			return announcement, nil
+		},
+		UpdateFn: func(ctx context.Context, announcement buzza.Announcement) (buzza.Announcement, error) {
+			if announcement.Id != 7 {
+				return buzza.Announcement{}, buzza.ErrAnnouncementNotFound
+			}
+			return announcement, nil
+		},
+		DeleteFn: func(ctx context.Context, id int64) error {
+			return buzza.ErrAnnouncementNotFound
+		},
+		AllFn: func(ctx context.Context, offset int, limit int) ([]buzza.Announcement, error) {
+			return []buzza.Announcement{{Id: 7, Title: "Hello", Body: "hello", Severity: buzza.AnnouncementSeverityInfo,
+				Targeting: buzza.Targeting{MinVersion: "1.5"},
+				StartsAt:  time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}}, nil
+		},
+	}
+
+	var currentUser buzza.User
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := AnnouncementController{Store: store}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, currentUser)
+		return nil
+	}, app)
+
+	admin := buzza.User{Id: 1, Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}}
+	cases := []struct {
+		user       buzza.User
+		method     string
+		url        string
+		body       string
+		statusCode int
+		response   string
+	}{
+		{user: buzza.User{Id: 5}, method: "POST", url: "/admin/announcements", body: `{}`,
+			statusCode: fiber.StatusUnauthorized, response: JsonErrorMessageResponse(fiber.ErrUnauthorized.Message)},
+		{user: admin, method: "POST", url: "/admin/announcements",
+			body: `{"title":"Przerwa techniczna","body":"*jutro*","severity":"critical",` +
+				`"startsAt":1641081600,"endsAt":1641168000,"targeting":{"branches":["beta"],"roles":["pro"]}}`,
+			statusCode: fiber.StatusCreated,
+			response: `{"id":7,"title":"Przerwa techniczna","body":"*jutro*","severity":"critical",` +
+				`"startsAt":1641081600,"endsAt":1641168000,"unread":false}`},
+		{user: admin, method: "POST", url: "/admin/announcements", body: `{"title":"abc","body":"x","severity":"loud"}`,
+			statusCode: fiber.StatusBadRequest, response: JsonErrorMessageResponse("invalid severity")},
+		{user: admin, method: "PUT", url: "/admin/announcements/8",
+			body:       `{"title":"abc","body":"x","startsAt":1641081600}`,
+			statusCode: fiber.StatusNotFound, response: JsonErrorMessageResponse("announcement not found")},
+		{user: admin, method: "DELETE", url: "/admin/announcements/8",
+			statusCode: fiber.StatusNotFound, response: JsonErrorMessageResponse("announcement not found")},
+		{user: admin, method: "GET", url: "/admin/announcements", statusCode: fiber.StatusOK,
+			response: `[{"id":7,"title":"Hello","body":"hello","severity":"info","startsAt":1640995200,"unread":false,` +
+				`"targeting":{"branches":null,"os":null,"roles":null,"minVersion":"1.5","maxVersion":""}}]`},
+	}
+	for _, tc := range cases {
+		currentUser = tc.user
+		req := httptest.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, tc.method+" "+tc.url)
+		assert.Equal(tc.response, string(body), tc.method+" "+tc.url)
+	}
+	assert.Equal(admin.Id, created.AuthorId)
+	assert.Equal([]string{"beta"}, created.Targeting.Branches)
+}
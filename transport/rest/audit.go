@@ -0,0 +1,198 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	auditExportBatchSize = 500
+	// Exports are capped to keep a single request from dumping the whole table.
+	maxAuditExportLogs = 100_000
+)
+
+// Cross-user activity log search for the incident response. Every query is itself
+// recorded as the activity of the admin who made it.
+type AuditController struct {
+	Store buzza.ActivityStore
+}
+
+func (c *AuditController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	requireAdmin := requirePermissions(buzza.PermissionAdminDashboard)
+	app.Get("/admin/audit-logs", combineHandlers(requestAuthorizer, requireAdmin, c.serveAuditLogs))
+	app.Get("/admin/audit-logs/export", combineHandlers(requestAuthorizer, requireAdmin, c.serveExport))
+}
+
+type auditLogResponse struct {
+	Id        int64                  `json:"id"`
+	CreatedAt int64                  `json:"createdAt"`
+	UserId    buzza.UserId           `json:"userId"`
+	Name      string                 `json:"name"`
+	Version   int                    `json:"version"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+func newAuditLogResponse(log buzza.ActivityLog) auditLogResponse {
+	return auditLogResponse{Id: log.Id, CreatedAt: log.CreatedAt.Unix(), UserId: log.UserId,
+		Name: log.Name, Version: log.Version, Data: log.Data}
+}
+
+// Page of the logs, newest first. "nextCursor" is passed as "before" to get the next page
+// and is omitted on the last page.
+func (c *AuditController) serveAuditLogs(ctx *fiber.Ctx) error {
+	query, err := auditQuery(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.recordQuery(ctx, buzza.AuditLogQueriedActivity); err != nil {
+		return err
+	}
+	logs, err := c.Store.Audit(ctx.Context(), query)
+	if err != nil {
+		return fmt.Errorf("audit logs: %w", err)
+	}
+
+	mapped := make([]auditLogResponse, len(logs))
+	for i, log := range logs {
+		mapped[i] = newAuditLogResponse(log)
+	}
+	response := map[string]interface{}{"logs": mapped}
+	if len(logs) == query.Limit {
+		response["nextCursor"] = logs[len(logs)-1].Id
+	}
+	return ctx.JSON(response)
+}
+
+// Stream all logs matching the filters as CSV or JSON Lines ("format" query param).
+// "limit" is ignored, export is capped at maxAuditExportLogs.
+func (c *AuditController) serveExport(ctx *fiber.Ctx) error {
+	query, err := auditQuery(ctx)
+	if err != nil {
+		return err
+	}
+	format := ctx.Query("format", "jsonl")
+	var writeLogs func(w *bufio.Writer, logs []buzza.ActivityLog, first bool) error
+	switch format {
+	case "csv":
+		ctx.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		writeLogs = writeAuditCsv
+	case "jsonl":
+		ctx.Set(fiber.HeaderContentType, "application/x-ndjson")
+		writeLogs = writeAuditJsonl
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "invalid format")
+	}
+	if err := c.recordQuery(ctx, buzza.AuditLogExportedActivity); err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="audit-%d.%s"`, time.Now().Unix(), format))
+	query.Limit = auditExportBatchSize
+	// request context must not be used once the handler returns
+	log := logrus.WithField("export_query", query)
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		exported := 0
+		for exported < maxAuditExportLogs {
+			logs, err := c.Store.Audit(context.Background(), query)
+			if err != nil {
+				// headers are already sent, so the export just ends early
+				log.WithError(err).Errorln("Could not export audit logs.")
+				return
+			}
+			if len(logs) > maxAuditExportLogs-exported {
+				logs = logs[:maxAuditExportLogs-exported]
+			}
+			if err := writeLogs(w, logs, exported == 0); err != nil {
+				return
+			}
+			// flush fails when client disconnects
+			if err := w.Flush(); err != nil {
+				return
+			}
+			exported += len(logs)
+			if len(logs) < query.Limit {
+				return
+			}
+			query.BeforeId = logs[len(logs)-1].Id
+		}
+	})
+	return nil
+}
+
+func (c *AuditController) recordQuery(ctx *fiber.Ctx, activity func(query string) buzza.Activity) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	// query is refused if it can not be audited
+	err := c.Store.AddLog(ctx.Context(), user.Id, activity(string(ctx.Request().URI().QueryString())))
+	if err != nil {
+		return fmt.Errorf("record audit query: %w", err)
+	}
+	return nil
+}
+
+// Activity query filters extended with "user" id.
+func auditQuery(ctx *fiber.Ctx) (buzza.AuditQuery, error) {
+	activityQuery, err := activityQuery(ctx)
+	if err != nil {
+		return buzza.AuditQuery{}, err
+	}
+	query := buzza.AuditQuery{ActivityQuery: activityQuery}
+	if raw := ctx.Query("user"); raw != "" {
+		userId, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || userId <= 0 {
+			return buzza.AuditQuery{}, fiber.NewError(fiber.StatusBadRequest, "invalid user id")
+		}
+		query.UserId = buzza.UserId(userId)
+	}
+	return query, nil
+}
+
+func writeAuditCsv(w *bufio.Writer, logs []buzza.ActivityLog, first bool) error {
+	csvWriter := csv.NewWriter(w)
+	if first {
+		if err := csvWriter.Write([]string{"id", "created_at", "user_id", "name", "version", "data"}); err != nil {
+			return err
+		}
+	}
+	for _, log := range logs {
+		data, err := json.Marshal(log.Data)
+		if err != nil {
+			return err
+		}
+		err = csvWriter.Write([]string{
+			strconv.FormatInt(log.Id, 10),
+			log.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatInt(int64(log.UserId), 10),
+			log.Name,
+			strconv.Itoa(log.Version),
+			string(data),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func writeAuditJsonl(w *bufio.Writer, logs []buzza.ActivityLog, first bool) error {
+	encoder := json.NewEncoder(w)
+	for _, log := range logs {
+		if err := encoder.Encode(newAuditLogResponse(log)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package rest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditController(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	store := inmem.NewActivityStore()
+	store.Catalogue = buzza.DefaultActivityCatalogue
+	_ = store.AddLog(ctx, 5, buzza.SessionCreatedActivity("a", "1.1.1.1", "Firefox"))
+	_ = store.AddLog(ctx, 6, buzza.SessionCreatedActivity("b", "2.2.2.2", "Chrome"))
+	_ = store.AddLog(ctx, 5, buzza.SessionChangedIpActivity("a", "1.1.1.1", "3.3.3.3"))
+
+	var currentUser buzza.User
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := AuditController{Store: &store}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, currentUser)
+		return nil
+	}, app)
+
+	request := func(url string) (int, string) {
+		resp, body := testRequest(t, app, "GET", url, "", nil)
+		return resp.StatusCode, string(body)
+	}
+
+	currentUser = buzza.User{Id: 5}
+	status, _ := request("/admin/audit-logs")
+	assert.Equal(fiber.StatusUnauthorized, status)
+
+	admin := buzza.User{Id: 1, Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}}
+	currentUser = admin
+	status, body := request("/admin/audit-logs?ip=1.1.1.1&limit=1")
+	assert.Equal(fiber.StatusOK, status)
+	assert.Contains(body, `"logs":[{"id":3,`)
+	assert.Contains(body, `"nextCursor":3`)
+
+	status, body = request("/admin/audit-logs?ip=1.1.1.1&before=3&limit=1")
+	assert.Equal(fiber.StatusOK, status)
+	assert.Contains(body, `"logs":[{"id":1,`)
+
+	status, body = request("/admin/audit-logs?user=6&name=session_created")
+	assert.Equal(fiber.StatusOK, status)
+	assert.Contains(body, `"logs":[{"id":2,`)
+	assert.NotContains(body, "nextCursor")
+
+	status, body = request("/admin/audit-logs?user=abc")
+	assert.Equal(fiber.StatusBadRequest, status)
+	assert.Equal(JsonErrorMessageResponse("invalid user id"), body)
+
+	status, body = request("/admin/audit-logs/export?format=xml")
+	assert.Equal(fiber.StatusBadRequest, status)
+	assert.Equal(JsonErrorMessageResponse("invalid format"), body)
+
+	status, body = request("/admin/audit-logs/export?format=csv&user=5")
+	assert.Equal(fiber.StatusOK, status)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if assert.Len(lines, 3) {
+		assert.Equal("id,created_at,user_id,name,version,data", lines[0])
+		assert.True(strings.HasPrefix(lines[1], "3,"))
+		assert.True(strings.HasSuffix(lines[1],
+			`,5,session_changed_ip,1,"{""new_ip"":""3.3.3.3"",""previous_ip"":""1.1.1.1"",""session_id"":""a""}"`))
+		assert.True(strings.HasPrefix(lines[2], "1,"))
+	}
+
+	status, body = request("/admin/audit-logs/export?name=session_created")
+	assert.Equal(fiber.StatusOK, status)
+	lines = strings.Split(strings.TrimSpace(body), "\n")
+	if assert.Len(lines, 2) {
+		assert.True(strings.HasPrefix(lines[0], `{"id":2,`))
+		assert.True(strings.HasPrefix(lines[1], `{"id":1,`))
+	}
+
+	// every valid query made by the admin is audited
+	adminLogs, err := store.ByUserId(ctx, admin.Id, buzza.ActivityQuery{Limit: 100})
+	if !assert.NoError(err) || !assert.Len(adminLogs, 5) {
+		return
+	}
+	assert.Equal(buzza.ActivityAuditLogExported, adminLogs[0].Name)
+	assert.Equal("name=session_created", adminLogs[0].Data["query"])
+	assert.Equal(buzza.ActivityAuditLogQueried, adminLogs[len(adminLogs)-1].Name)
+	assert.Equal("ip=1.1.1.1&limit=1", adminLogs[len(adminLogs)-1].Data["query"])
+}
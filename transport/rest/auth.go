@@ -4,38 +4,76 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/buzkaaclicker/buzza"
-	"github.com/buzkaaclicker/buzza/discord"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/sirupsen/logrus"
 )
 
 type AuthController struct {
-	CreateDiscordOAuthUrl discord.OAuthUrlFactory
-	ExchangeAccessToken   discord.AccessTokenExchanger
-	UserMeProvider        discord.UserMeProvider
-	GuildMemberAdd        discord.GuildMemberAdd
-	SessionStore          buzza.SessionStore
-	UserStore             buzza.UserStore
+	// Login providers served at /auth/:provider.
+	Providers    buzza.IdentityProviders
+	SessionStore buzza.SessionStore
+	UserStore    buzza.UserStore
+	// Optional. If set, referral codes passed on the first login are attached to the new user.
+	ReferralStore buzza.ReferralStore
+	// Optional. If set, users with enabled or required second factor get a challenge
+	// instead of the session and exchange it at /auth/2fa.
+	TwoFactor *buzza.TwoFactorAuthenticator
+	// Signs two-factor challenges, required with TwoFactor.
+	TwoFactorChallengeSecret []byte
+	// Optional. If set, passkeys can be used for passwordless login and, with TwoFactor, as a second factor.
+	Passkeys *buzza.PasskeyAuthenticator
+	// Optional. If set, users can log in with links mailed to their verified emails.
+	MagicLinks *buzza.MagicLinkAuthenticator
 }
 
 func (c *AuthController) InstallTo(app *fiber.App) {
-	app.Get("/auth/discord", c.serveCreateDiscordOAuthUrl)
-	app.Post("/auth/discord", c.serveAuthenticateDiscord)
 	app.Post("/auth/logout", c.logoutHandler())
+	if c.TwoFactor != nil {
+		app.Post("/auth/2fa", c.serveTwoFactor)
+		app.Post("/auth/2fa/enrol", c.serveTwoFactorEnrol)
+		app.Post("/auth/2fa/enrol/confirm", c.serveTwoFactorEnrolConfirm)
+	}
+	if c.Passkeys != nil {
+		app.Post("/auth/passkey/options", c.servePasskeyLoginOptions)
+		app.Post("/auth/passkey", c.servePasskeyLogin)
+	}
+	if c.TwoFactor != nil && c.Passkeys != nil {
+		app.Post("/auth/2fa/passkey/options", c.serveTwoFactorPasskeyOptions)
+		app.Post("/auth/2fa/passkey", c.serveTwoFactorPasskey)
+	}
+	if c.MagicLinks != nil {
+		app.Post("/auth/email", c.serveSendMagicLink)
+		app.Post("/auth/email/login", c.serveMagicLinkLogin)
+	}
+	// registered last, so the routes above take precedence
+	app.Get("/auth/:provider", c.serveCreateOAuthUrl)
+	app.Post("/auth/:provider", c.serveAuthenticate)
 }
 
-func (c *AuthController) serveCreateDiscordOAuthUrl(ctx *fiber.Ctx) error {
-	url := c.CreateDiscordOAuthUrl()
+func (c *AuthController) serveCreateOAuthUrl(ctx *fiber.Ctx) error {
+	provider, err := c.Providers.Get(ctx.Params("provider"))
+	if err != nil {
+		return identityError(err)
+	}
 	return ctx.JSON(map[string]string{
-		"url": url,
+		"url": provider.AuthUrl(""),
 	})
 }
 
-func (c *AuthController) serveAuthenticateDiscord(ctx *fiber.Ctx) error {
+func (c *AuthController) serveAuthenticate(ctx *fiber.Ctx) error {
+	provider, err := c.Providers.Get(ctx.Params("provider"))
+	if err != nil {
+		return identityError(err)
+	}
+
 	body := struct {
-		Code string `json:"code"`
+		Code         string `json:"code"`
+		ReferralCode string `json:"referralCode"`
 	}{}
 	if err := ctx.BodyParser(&body); err != nil {
 		requestLog(ctx).WithError(err).Infoln("Invalid body.")
@@ -46,55 +84,322 @@ func (c *AuthController) serveAuthenticateDiscord(ctx *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusUnauthorized, "invalid code")
 	}
 
-	exchange, err := c.ExchangeAccessToken(code)
+	identity, err := provider.Authenticate(ctx.Context(), code)
 	if err != nil {
-		if errors.Is(err, discord.ErrOAuthInvalidCode) {
-			return fiber.NewError(fiber.StatusUnauthorized, "invalid code")
-		} else {
-			return fmt.Errorf("access token exchange: %w", err)
+		return identityError(err)
+	}
+	if identity.Email == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "missing email")
+	}
+
+	dbCtx, cancelFunc := context.WithTimeout(context.Background(), time.Minute)
+	user, created, err := c.UserStore.RegisterExternalUser(dbCtx, identity)
+	cancelFunc()
+	if err != nil {
+		if errors.Is(err, buzza.ErrEmailInUse) {
+			return fiber.NewError(fiber.StatusConflict, "email is used by another account, log in to it and link this one")
+		}
+		return fmt.Errorf("user register: %w", err)
+	}
+	if created && body.ReferralCode != "" {
+		c.attachReferral(ctx, buzza.ReferralCode(body.ReferralCode), user)
+	}
+	return c.serveLogin(ctx, user)
+}
+
+// Respond with the two-factor challenge if the user needs one, otherwise with the new session.
+func (c *AuthController) serveLogin(ctx *fiber.Ctx, user buzza.User) error {
+	if c.TwoFactor != nil {
+		methods, err := c.secondFactorMethods(ctx, user.Id)
+		if err != nil {
+			return err
+		}
+		if len(methods) != 0 || buzza.TwoFactorRequired(user.Roles) {
+			challenge := buzza.TwoFactorChallenge{
+				UserId:           user.Id,
+				EnrolmentAllowed: len(methods) == 0,
+				ExpiresAt:        time.Now().Add(buzza.DefaultTwoFactorChallengeTTL),
+			}
+			return ctx.JSON(map[string]interface{}{
+				"challenge":         buzza.SignTwoFactorChallenge(c.TwoFactorChallengeSecret, challenge),
+				"methods":           methods,
+				"enrolmentRequired": challenge.EnrolmentAllowed,
+				"expiresAt":         challenge.ExpiresAt.Unix(),
+			})
 		}
 	}
 
-	dcUser, err := c.UserMeProvider()(exchange.Token())
+	session, err := c.issueSession(ctx, user.Id)
 	if err != nil {
-		return fmt.Errorf("discord user me: %w", err)
+		return err
 	}
-	if dcUser.Email == "" {
-		return fiber.NewError(fiber.StatusBadRequest, "missing email")
+	return ctx.Status(fiber.StatusCreated).JSON(newLoginResponse(session))
+}
+
+// Always accepted for emails without account and the link is sent in the background,
+// so neither the response nor its timing reveals who is registered.
+func (c *AuthController) serveSendMagicLink(ctx *fiber.Ctx) error {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	if !strings.Contains(body.Email, "@") {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid email")
+	}
+	// used after the handler returns
+	email, ip := buzza.Email(utils.CopyString(body.Email)), utils.CopyString(ctx.IP())
+	err := c.MagicLinks.Limit(ctx.Context(), email, ip)
+	if err != nil {
+		if errors.Is(err, buzza.ErrMagicLinkRateLimited) {
+			return fiber.NewError(fiber.StatusTooManyRequests, "too many requests")
+		}
+		return fmt.Errorf("limit magic links: %w", err)
 	}
+	go func() {
+		sendCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := c.MagicLinks.Send(sendCtx, email, ip); err != nil {
+			logrus.WithError(err).WithField("ip", ip).Errorln("Could not send magic link.")
+		}
+	}()
+	return ctx.SendStatus(fiber.StatusAccepted)
+}
 
-	guildAddStatus, err := c.GuildMemberAdd(exchange.AccessToken, dcUser.Id)
+// Magic links prove only the access to the email, so the second factor is still required.
+func (c *AuthController) serveMagicLinkLogin(ctx *fiber.Ctx) error {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	userId, err := c.MagicLinks.Login(ctx.Context(), body.Token)
 	if err != nil {
-		if errors.Is(err, discord.ErrUnauthorized) {
-			return fiber.NewError(fiber.StatusUnauthorized, "discord guild join unauthorized")
-		} else {
-			return err
+		if errors.Is(err, buzza.ErrInvalidMagicLink) {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid link")
 		}
+		return fmt.Errorf("magic link login: %w", err)
 	}
-	requestLog(ctx).Infof("Discord guild member add status: %d\n", guildAddStatus)
+	user, err := c.UserStore.ById(ctx.Context(), userId)
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+	requestLog(ctx).WithField("user_id", user.Id).Infoln("Magic link used.")
+	return c.serveLogin(ctx, user)
+}
 
-	dbCtx, cancelFunc := context.WithTimeout(context.Background(), time.Minute)
-	user, err := c.UserStore.RegisterDiscordUser(dbCtx, dcUser, exchange.RefreshToken)
-	cancelFunc()
+// Second factors enabled by the user: "totp" and "passkey".
+func (c *AuthController) secondFactorMethods(ctx *fiber.Ctx, userId buzza.UserId) ([]string, error) {
+	methods := make([]string, 0, 2)
+	enabled, err := c.TwoFactor.Enabled(ctx.Context(), userId)
 	if err != nil {
-		return fmt.Errorf("user register: %w", err)
+		return nil, fmt.Errorf("two factor enabled: %w", err)
+	}
+	if enabled {
+		methods = append(methods, "totp")
 	}
-	session, err := c.SessionStore.RegisterNew(ctx.Context(), user.Id, ctx.IP(), string(ctx.Request().Header.UserAgent()))
+	if c.Passkeys != nil {
+		passkeys, err := c.Passkeys.Store.ByUserId(ctx.Context(), userId)
+		if err != nil {
+			return nil, fmt.Errorf("get passkeys: %w", err)
+		}
+		if len(passkeys) != 0 {
+			methods = append(methods, "passkey")
+		}
+	}
+	return methods, nil
+}
+
+func (c *AuthController) issueSession(ctx *fiber.Ctx, userId buzza.UserId) (buzza.Session, error) {
+	session, err := c.SessionStore.RegisterNew(ctx.Context(), userId, ctx.IP(), string(ctx.Request().Header.UserAgent()))
 	if err != nil {
-		return fmt.Errorf("session register new: %w", err)
+		return buzza.Session{}, fmt.Errorf("session register new: %w", err)
 	}
+	return session, nil
+}
 
-	return ctx.Status(fiber.StatusCreated).JSON(map[string]interface{}{
+func newLoginResponse(session buzza.Session) map[string]interface{} {
+	return map[string]interface{}{
 		"id":          session.Id,
 		"userId":      session.UserId,
 		"accessToken": session.Token,
 		"expiresAt":   session.ExpiresAt.Unix(),
-	})
+	}
+}
+
+type twoFactorChallengeBody struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// Challenge from the request body, the error is ready to be returned by handlers.
+func (c *AuthController) parseTwoFactorChallenge(ctx *fiber.Ctx) (twoFactorChallengeBody, buzza.TwoFactorChallenge, error) {
+	var body twoFactorChallengeBody
+	if err := ctx.BodyParser(&body); err != nil {
+		return body, buzza.TwoFactorChallenge{}, fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	challenge, err := buzza.VerifyTwoFactorChallenge(c.TwoFactorChallengeSecret, body.Challenge, time.Now())
+	if err != nil {
+		return body, buzza.TwoFactorChallenge{}, twoFactorError(err)
+	}
+	return body, challenge, nil
+}
+
+// Challenge allowing the enrolment from the request body, the error is ready to be returned by handlers.
+func (c *AuthController) parseTwoFactorEnrolmentChallenge(ctx *fiber.Ctx) (twoFactorChallengeBody, buzza.UserId, error) {
+	body, challenge, err := c.parseTwoFactorChallenge(ctx)
+	if err != nil {
+		return body, 0, err
+	}
+	if !challenge.EnrolmentAllowed {
+		return body, 0, twoFactorError(buzza.ErrTwoFactorEnrolmentNotAllowed)
+	}
+	return body, challenge.UserId, nil
+}
+
+func (c *AuthController) serveTwoFactor(ctx *fiber.Ctx) error {
+	body, challenge, err := c.parseTwoFactorChallenge(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.TwoFactor.Verify(ctx.Context(), challenge.UserId, body.Code); err != nil {
+		return twoFactorError(err)
+	}
+	session, err := c.issueSession(ctx, challenge.UserId)
+	if err != nil {
+		return err
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(newLoginResponse(session))
+}
+
+// Enrolment of users whose roles require second factor before they have one.
+func (c *AuthController) serveTwoFactorEnrol(ctx *fiber.Ctx) error {
+	_, userId, err := c.parseTwoFactorEnrolmentChallenge(ctx)
+	if err != nil {
+		return err
+	}
+	user, err := c.UserStore.ById(ctx.Context(), userId)
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+	enrolment, err := c.TwoFactor.BeginEnrolment(ctx.Context(), user)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	return ctx.JSON(newTotpEnrolmentBody(enrolment))
+}
+
+func (c *AuthController) serveTwoFactorEnrolConfirm(ctx *fiber.Ctx) error {
+	body, userId, err := c.parseTwoFactorEnrolmentChallenge(ctx)
+	if err != nil {
+		return err
+	}
+	codes, err := c.TwoFactor.ConfirmEnrolment(ctx.Context(), userId, body.Code)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	session, err := c.issueSession(ctx, userId)
+	if err != nil {
+		return err
+	}
+	response := newLoginResponse(session)
+	response["recoveryCodes"] = codes
+	return ctx.Status(fiber.StatusCreated).JSON(response)
+}
+
+// Referral problems should never block the login, so they are only logged.
+func (c *AuthController) attachReferral(ctx *fiber.Ctx, code buzza.ReferralCode, user buzza.User) {
+	if c.ReferralStore == nil {
+		return
+	}
+	_, err := c.ReferralStore.Attach(ctx.Context(), code, user, ctx.IP())
+	switch {
+	case err == nil:
+		requestLog(ctx).WithField("user_id", user.Id).Infoln("Referral attached.")
+	case errors.Is(err, buzza.ErrReferralCodeNotFound),
+		errors.Is(err, buzza.ErrSelfReferral),
+		errors.Is(err, buzza.ErrAlreadyReferred):
+		requestLog(ctx).WithField("user_id", user.Id).WithError(err).Infoln("Referral rejected.")
+	default:
+		requestLog(ctx).WithField("user_id", user.Id).WithError(err).Errorln("Could not attach referral.")
+	}
 }
 
 func (c *AuthController) logoutHandler() fiber.Handler {
-	return combineHandlers(RequestAuthorizer(c.SessionStore, c.UserStore), func(ctx *fiber.Ctx) error {
+	return combineHandlers(RequestAuthorizer(c.SessionStore, c.UserStore, nil), func(ctx *fiber.Ctx) error {
 		session := ctx.Locals(sessionLocalsKey).(buzza.Session)
 		return c.SessionStore.InvalidateByAuthToken(session.Token)
 	})
 }
+
+type passkeyAssertionBody struct {
+	Challenge  string         `json:"challenge"`
+	State      string         `json:"state"`
+	Credential credentialBody `json:"credential"`
+}
+
+func (c *AuthController) servePasskeyLoginOptions(ctx *fiber.Ctx) error {
+	login, err := c.Passkeys.BeginLogin(ctx.Context())
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(newPasskeyLoginBody(c.Passkeys.RelyingParty, login))
+}
+
+// Passwordless login, passkeys verify the user themselves, so no second factor is asked for.
+func (c *AuthController) servePasskeyLogin(ctx *fiber.Ctx) error {
+	var body passkeyAssertionBody
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	assertion, err := body.Credential.assertion()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid credential")
+	}
+	userId, err := c.Passkeys.FinishLogin(ctx.Context(), body.State, assertion)
+	if err != nil {
+		return passkeyLoginError(err)
+	}
+	session, err := c.issueSession(ctx, userId)
+	if err != nil {
+		return err
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(newLoginResponse(session))
+}
+
+func (c *AuthController) serveTwoFactorPasskeyOptions(ctx *fiber.Ctx) error {
+	_, challenge, err := c.parseTwoFactorChallenge(ctx)
+	if err != nil {
+		return err
+	}
+	login, err := c.Passkeys.BeginSecondFactor(ctx.Context(), challenge.UserId)
+	if err != nil {
+		return passkeyError(err)
+	}
+	return ctx.JSON(newPasskeyLoginBody(c.Passkeys.RelyingParty, login))
+}
+
+func (c *AuthController) serveTwoFactorPasskey(ctx *fiber.Ctx) error {
+	var body passkeyAssertionBody
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	challenge, err := buzza.VerifyTwoFactorChallenge(c.TwoFactorChallengeSecret, body.Challenge, time.Now())
+	if err != nil {
+		return twoFactorError(err)
+	}
+	assertion, err := body.Credential.assertion()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid credential")
+	}
+	if err := c.Passkeys.FinishSecondFactor(ctx.Context(), challenge.UserId, body.State, assertion); err != nil {
+		return passkeyLoginError(err)
+	}
+	session, err := c.issueSession(ctx, challenge.UserId)
+	if err != nil {
+		return err
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(newLoginResponse(session))
+}
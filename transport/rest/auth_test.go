@@ -15,35 +15,39 @@ import (
 	"github.com/buzkaaclicker/buzza"
 	"github.com/buzkaaclicker/buzza/discord"
 	"github.com/buzkaaclicker/buzza/inmem"
-	"github.com/buzkaaclicker/buzza/persistent"
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
-	"github.com/tidwall/buntdb"
 )
 
+// Discord login always authenticating the user.
+func testDiscordProviders(user discord.User) buzza.IdentityProviders {
+	return buzza.IdentityProviders{buzza.ProviderDiscord: &buzza.DiscordIdentityProvider{
+		ExchangeAccessToken: func(code string) (discord.AccessTokenResponse, error) {
+			return discord.AccessTokenResponse{}, nil
+		},
+		UserMeProvider: func() discord.UserMe {
+			return func(token discord.Token) (discord.User, error) {
+				return user, nil
+			}
+		},
+		GuildMemberAdd: discord.MockGuildMemberAdd,
+	}}
+}
+
 func Test_AuthLoginLogoutFlow(t *testing.T) {
 	assert := assert.New(t)
 	ctx := context.Background()
 
 	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
 
-	bunt, err := buntdb.Open(":memory:")
-	if !assert.NoError(err) {
-		return
-	}
-	defer func () {
-		_= bunt.Close()
-	}()
-
 	userStore := inmem.NewUserStore()
 	activityStore := inmem.NewActivityStore()
+	sessionStore := inmem.NewSessionStore(&activityStore)
+	discordProvider := &buzza.DiscordIdentityProvider{GuildMemberAdd: discord.MockGuildMemberAdd}
 	authController := AuthController{
-		UserStore:      &userStore,
-		SessionStore:   &persistent.SessionStore{
-			Buntdb:        bunt,
-			ActivityStore: &activityStore,
-		},
-		GuildMemberAdd: discord.MockGuildMemberAdd,
+		Providers:    buzza.IdentityProviders{buzza.ProviderDiscord: discordProvider},
+		UserStore:    &userStore,
+		SessionStore: &sessionStore,
 	}
 	authController.InstallTo(app)
 
@@ -64,14 +68,14 @@ func Test_AuthLoginLogoutFlow(t *testing.T) {
 	validateCreated := func(resp *http.Response, body string) {
 		assert.Equal(resp.Header.Get("Content-Type"), fiber.MIMEApplicationJSON, "Invalid content type")
 
-		user, err := userStore.ByDiscordId(ctx, properUser.Id)
+		user, err := userStore.ByIdentity(ctx, buzza.ProviderDiscord, properUser.Id)
 		if !assert.NoError(err) {
 			return
 		}
 		assert.Equal(user.Discord.Id, properUser.Id)
 		assert.Equal(string(user.Email), properUser.Email)
 
-		logs, err := activityStore.ByUserId(ctx, user.Id, -1, 100)
+		logs, err := activityStore.ByUserId(ctx, user.Id, buzza.ActivityQuery{Limit: 100})
 		if !assert.NoError(err) || !assert.GreaterOrEqual(len(logs), 1) {
 			return
 		}
@@ -177,10 +181,10 @@ func Test_AuthLoginLogoutFlow(t *testing.T) {
 	}
 
 	caseTest := func(tc Case) {
-		authController.ExchangeAccessToken = func(code string) (discord.AccessTokenResponse, error) {
+		discordProvider.ExchangeAccessToken = func(code string) (discord.AccessTokenResponse, error) {
 			return discord.AccessTokenResponse{}, tc.AccessTokenExchangeErr
 		}
-		authController.UserMeProvider = func() discord.UserMe {
+		discordProvider.UserMeProvider = func() discord.UserMe {
 			return func(token discord.Token) (discord.User, error) {
 				return tc.User, tc.UserMeErr
 			}
@@ -211,27 +215,17 @@ func Test_SessionAuthorization(t *testing.T) {
 		return err
 	}
 
-	bdb, err := buntdb.Open(":memory:")
-	if err != nil {
-		panic(err)
-	}
 	userStore := inmem.NewUserStore()
 	activityStore := inmem.NewActivityStore()
-	sessionStore := &persistent.SessionStore{
-		Buntdb:        bdb,
-		ActivityStore: &activityStore,
-	}
-	controller := AuthController{
-		UserStore: &userStore,
-	}
+	sessionStore := inmem.NewSessionStore(&activityStore)
 
 	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
-	requestAuthorizer := RequestAuthorizer(sessionStore, &userStore)
+	requestAuthorizer := RequestAuthorizer(&sessionStore, &userStore, nil)
 	app.Get("/test/restricted", combineHandlers(requestAuthorizer, restrictedHandler))
 	app.Get("/test/dashboard", combineHandlers(requestAuthorizer, requirePermissions(buzza.PermissionAdminDashboard), restrictedHandler))
 
-	registerUser := func(discordUser discord.User) (buzza.User, buzza.Session, error) {
-		user, err := userStore.RegisterDiscordUser(context.Background(), discordUser, "refresh-token-mock")
+	registerUser := func(identity buzza.ExternalIdentity) (buzza.User, buzza.Session, error) {
+		user, _, err := userStore.RegisterExternalUser(context.Background(), identity)
 		if err != nil {
 			return buzza.User{}, buzza.Session{}, fmt.Errorf("register user: %w", err)
 		}
@@ -243,13 +237,13 @@ func Test_SessionAuthorization(t *testing.T) {
 	}
 
 	unprivilegedUser, unprivilegedSession, err := registerUser(
-		discord.User{Id: "makin", Username: "makin", Email: "makin"})
+		buzza.ExternalIdentity{Provider: buzza.ProviderDiscord, Subject: "makin", Name: "makin", Email: "makin"})
 	if !assert.NoError(err) {
 		return
 	}
 
 	privilegedUser, privilegedSession, err := registerUser(
-		discord.User{Id: "morton", Username: "morton", Email: "morton"})
+		buzza.ExternalIdentity{Provider: buzza.ProviderDiscord, Subject: "morton", Name: "morton", Email: "morton"})
 	if !assert.NoError(err) {
 		return
 	}
@@ -314,10 +308,6 @@ func Test_SessionAuthorization(t *testing.T) {
 		},
 	}
 
-	controller.ExchangeAccessToken = func(code string) (discord.AccessTokenResponse, error) {
-		return discord.AccessTokenResponse{RefreshToken: "mock_refresh_token"}, nil
-	}
-
 	caseTest := func(tc Case) {
 		req := httptest.NewRequest("GET", tc.path, nil)
 		if tc.token != "" {
@@ -0,0 +1,239 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Cloud sync of clicker configs. Revision of the config is exposed as an ETag,
+// clients should send it back in "If-Match" header to detect conflicting changes.
+type ClickerConfigController struct {
+	Store buzza.ClickerConfigStore
+}
+
+func (c *ClickerConfigController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	app.Get("/configs", combineHandlers(requestAuthorizer, c.serveConfigs))
+	app.Get("/configs/:name", combineHandlers(requestAuthorizer, c.serveConfig))
+	app.Put("/configs/:name", combineHandlers(requestAuthorizer, c.serveSaveConfig))
+	app.Delete("/configs/:name", combineHandlers(requestAuthorizer, c.serveDeleteConfig))
+	app.Get("/configs/:name/revisions", combineHandlers(requestAuthorizer, c.serveConfigRevisions))
+	app.Post("/configs/:name/revisions/:revision/restore", combineHandlers(requestAuthorizer, c.serveRestoreConfig))
+}
+
+type clickerConfigMeta struct {
+	Name      string `json:"name"`
+	Revision  int64  `json:"revision"`
+	Size      int    `json:"size"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+func newClickerConfigMeta(config buzza.ClickerConfig) clickerConfigMeta {
+	return clickerConfigMeta{
+		Name:      config.Name,
+		Revision:  config.Revision,
+		Size:      len(config.Data),
+		CreatedAt: config.CreatedAt.Unix(),
+		UpdatedAt: config.UpdatedAt.Unix(),
+	}
+}
+
+func (c *ClickerConfigController) serveConfigs(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	configs, err := c.Store.ByUserId(ctx.Context(), user.Id)
+	if err != nil {
+		return fmt.Errorf("get configs by user id: %w", err)
+	}
+
+	mapped := make([]clickerConfigMeta, len(configs))
+	for i, config := range configs {
+		mapped[i] = newClickerConfigMeta(config)
+	}
+	return ctx.JSON(mapped)
+}
+
+func (c *ClickerConfigController) serveConfig(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	name, err := configNameParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	config, err := c.Store.ByName(ctx.Context(), user.Id, name)
+	if err != nil {
+		return clickerConfigError(err)
+	}
+
+	etag := revisionETag(config.Revision)
+	ctx.Set(fiber.HeaderETag, etag)
+	if ctx.Get(fiber.HeaderIfNoneMatch) == etag {
+		return ctx.SendStatus(fiber.StatusNotModified)
+	}
+	return ctx.JSON(map[string]interface{}{
+		"name":      config.Name,
+		"revision":  config.Revision,
+		"createdAt": config.CreatedAt.Unix(),
+		"updatedAt": config.UpdatedAt.Unix(),
+		"data":      config.Data,
+	})
+}
+
+func (c *ClickerConfigController) serveSaveConfig(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	name, err := configNameParam(ctx)
+	if err != nil {
+		return err
+	}
+	expectedRevision, err := expectedRevisionHeader(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := ctx.Body()
+	if len(body) > buzza.MaxClickerConfigSize {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, "config too large")
+	}
+	if !json.Valid(body) || !strings.HasPrefix(strings.TrimSpace(string(body)), "{") {
+		return fiber.NewError(fiber.StatusBadRequest, "config must be a json object")
+	}
+	// fasthttp reuses request body buffer
+	data := make(json.RawMessage, len(body))
+	copy(data, body)
+
+	config, err := c.Store.Save(ctx.Context(), user.Id, name, data, expectedRevision)
+	if err != nil {
+		return clickerConfigError(err)
+	}
+	ctx.Set(fiber.HeaderETag, revisionETag(config.Revision))
+	return ctx.JSON(newClickerConfigMeta(config))
+}
+
+func (c *ClickerConfigController) serveDeleteConfig(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	name, err := configNameParam(ctx)
+	if err != nil {
+		return err
+	}
+	expectedRevision, err := expectedRevisionHeader(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Store.Delete(ctx.Context(), user.Id, name, expectedRevision); err != nil {
+		return clickerConfigError(err)
+	}
+	return nil
+}
+
+func (c *ClickerConfigController) serveConfigRevisions(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	name, err := configNameParam(ctx)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := c.Store.History(ctx.Context(), user.Id, name)
+	if err != nil {
+		return clickerConfigError(err)
+	}
+
+	type Revision struct {
+		Revision  int64           `json:"revision"`
+		CreatedAt int64           `json:"createdAt"`
+		Data      json.RawMessage `json:"data"`
+	}
+	mapped := make([]Revision, len(revisions))
+	for i, r := range revisions {
+		mapped[i] = Revision{Revision: r.Revision, CreatedAt: r.CreatedAt.Unix(), Data: r.Data}
+	}
+	return ctx.JSON(mapped)
+}
+
+func (c *ClickerConfigController) serveRestoreConfig(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	name, err := configNameParam(ctx)
+	if err != nil {
+		return err
+	}
+	revision, err := strconv.ParseInt(ctx.Params("revision"), 10, 64)
+	if err != nil || revision <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid revision")
+	}
+	expectedRevision, err := expectedRevisionHeader(ctx)
+	if err != nil {
+		return err
+	}
+
+	config, err := c.Store.Restore(ctx.Context(), user.Id, name, revision, expectedRevision)
+	if err != nil {
+		return clickerConfigError(err)
+	}
+	ctx.Set(fiber.HeaderETag, revisionETag(config.Revision))
+	return ctx.JSON(newClickerConfigMeta(config))
+}
+
+func configNameParam(ctx *fiber.Ctx) (string, error) {
+	name, err := url.PathUnescape(ctx.Params("name"))
+	if err != nil || !buzza.ValidClickerConfigName(name) {
+		return "", fiber.NewError(fiber.StatusBadRequest, "invalid config name")
+	}
+	return name, nil
+}
+
+func revisionETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// Revision expected by the client: "If-Match" with the revision ETag or
+// "If-None-Match: *" if config should not exist yet. Without headers the conflict detection is skipped.
+func expectedRevisionHeader(ctx *fiber.Ctx) (int64, error) {
+	if ifMatch := ctx.Get(fiber.HeaderIfMatch); ifMatch != "" {
+		revision, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+		if err != nil || revision <= 0 {
+			return 0, fiber.NewError(fiber.StatusBadRequest, "invalid If-Match header")
+		}
+		return revision, nil
+	}
+	if ctx.Get(fiber.HeaderIfNoneMatch) == "*" {
+		return 0, nil
+	}
+	return buzza.AnyClickerConfigRevision, nil
+}
+
+func clickerConfigError(err error) error {
+	switch {
+	case errors.Is(err, buzza.ErrClickerConfigNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "config not found")
+	case errors.Is(err, buzza.ErrClickerConfigRevisionNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "revision not found")
+	case errors.Is(err, buzza.ErrClickerConfigConflict):
+		return fiber.NewError(fiber.StatusPreconditionFailed, "config has been modified")
+	default:
+		return fmt.Errorf("clicker config store: %w", err)
+	}
+}
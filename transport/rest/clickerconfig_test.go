@@ -0,0 +1,123 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClickerConfigController(t *testing.T) {
+	assert := assert.New(t)
+
+	updatedAt := time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)
+	config := buzza.ClickerConfig{UserId: 22, Name: "pvp", Revision: 3,
+		Data: json.RawMessage(`{"cps":12}`), CreatedAt: updatedAt, UpdatedAt: updatedAt}
+	var savedExpectedRevision int64
+	store := mock.ClickerConfigStore{
+		ByNameFn: func(ctx context.Context, userId buzza.UserId, name string) (buzza.ClickerConfig, error) {
+			if name != config.Name {
+				return buzza.ClickerConfig{}, buzza.ErrClickerConfigNotFound
+			}
+			return config, nil
+		},
+		SaveFn: func(ctx context.Context, userId buzza.UserId, name string,
+			data json.RawMessage, expectedRevision int64) (buzza.ClickerConfig, error) {
+			savedExpectedRevision = expectedRevision
+			if expectedRevision != buzza.AnyClickerConfigRevision && expectedRevision != config.Revision {
+				return buzza.ClickerConfig{}, buzza.ErrClickerConfigConflict
+			}
+			return buzza.ClickerConfig{UserId: userId, Name: name, Revision: config.Revision + 1,
+				Data: data, CreatedAt: updatedAt, UpdatedAt: updatedAt}, nil
+		},
+		HistoryFn: func(ctx context.Context, userId buzza.UserId, name string) ([]buzza.ClickerConfigRevision, error) {
+			return []buzza.ClickerConfigRevision{
+				{Revision: 3, Data: json.RawMessage(`{"cps":12}`), CreatedAt: updatedAt},
+				{Revision: 2, Data: json.RawMessage(`{"cps":10}`), CreatedAt: updatedAt},
+			}, nil
+		},
+		RestoreFn: func(ctx context.Context, userId buzza.UserId, name string,
+			revision int64, expectedRevision int64) (buzza.ClickerConfig, error) {
+			if revision != 2 {
+				return buzza.ClickerConfig{}, buzza.ErrClickerConfigRevisionNotFound
+			}
+			return buzza.ClickerConfig{UserId: userId, Name: name, Revision: 4,
+				Data: json.RawMessage(`{"cps":10}`), CreatedAt: updatedAt, UpdatedAt: updatedAt}, nil
+		},
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := ClickerConfigController{Store: store}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, buzza.User{Id: 22})
+		return nil
+	}, app)
+
+	cases := []struct {
+		method     string
+		url        string
+		headers    map[string]string
+		body       string
+		statusCode int
+		response   string
+		etag       string
+	}{
+		{method: "GET", url: "/configs/pvp", statusCode: fiber.StatusOK, etag: `"3"`,
+			response: `{"createdAt":1643716800,"data":{"cps":12},"name":"pvp","revision":3,"updatedAt":1643716800}`},
+		{method: "GET", url: "/configs/pvp", headers: map[string]string{"If-None-Match": `"3"`},
+			statusCode: fiber.StatusNotModified, etag: `"3"`},
+		{method: "GET", url: "/configs/unknown", statusCode: fiber.StatusNotFound,
+			response: JsonErrorMessageResponse("config not found")},
+		{method: "GET", url: "/configs/%2F..%2F", statusCode: fiber.StatusBadRequest,
+			response: JsonErrorMessageResponse("invalid config name")},
+		{method: "PUT", url: "/configs/pvp", headers: map[string]string{"If-Match": `"3"`}, body: `{"cps":14}`,
+			statusCode: fiber.StatusOK, etag: `"4"`,
+			response: `{"name":"pvp","revision":4,"size":10,"createdAt":1643716800,"updatedAt":1643716800}`},
+		{method: "PUT", url: "/configs/pvp", headers: map[string]string{"If-Match": `"2"`}, body: `{"cps":14}`,
+			statusCode: fiber.StatusPreconditionFailed, response: JsonErrorMessageResponse("config has been modified")},
+		{method: "PUT", url: "/configs/pvp", body: `[1, 2]`,
+			statusCode: fiber.StatusBadRequest, response: JsonErrorMessageResponse("config must be a json object")},
+		{method: "PUT", url: "/configs/pvp", body: `{"a":"` + strings.Repeat("a", buzza.MaxClickerConfigSize) + `"}`,
+			statusCode: fiber.StatusRequestEntityTooLarge, response: JsonErrorMessageResponse("config too large")},
+		{method: "GET", url: "/configs/pvp/revisions", statusCode: fiber.StatusOK,
+			response: `[{"revision":3,"createdAt":1643716800,"data":{"cps":12}},` +
+				`{"revision":2,"createdAt":1643716800,"data":{"cps":10}}]`},
+		{method: "POST", url: "/configs/pvp/revisions/2/restore", statusCode: fiber.StatusOK, etag: `"4"`,
+			response: `{"name":"pvp","revision":4,"size":10,"createdAt":1643716800,"updatedAt":1643716800}`},
+		{method: "POST", url: "/configs/pvp/revisions/1/restore", statusCode: fiber.StatusNotFound,
+			response: JsonErrorMessageResponse("revision not found")},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := app.Test(req)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, tc.url)
+		assert.Equal(tc.response, string(body), tc.url)
+		assert.Equal(tc.etag, resp.Header.Get(fiber.HeaderETag), tc.url)
+	}
+
+	req := httptest.NewRequest("PUT", "/configs/new", bytes.NewBufferString(`{}`))
+	req.Header.Set(fiber.HeaderIfNoneMatch, "*")
+	if _, err := app.Test(req); assert.NoError(err) {
+		assert.Equal(int64(0), savedExpectedRevision)
+	}
+}
@@ -0,0 +1,243 @@
+package rest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	// Max size of the decompressed report.
+	maxCrashReportSize = 8 * 1024 * 1024
+	maxMinidumpSize    = 4 * 1024 * 1024
+	maxStackTraceSize  = 64 * 1024
+)
+
+type CrashController struct {
+	Store       buzza.CrashStore
+	Attachments buzza.AttachmentStorage
+}
+
+func (c *CrashController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	// reports can be sent anonymously e.g. if clicker crashed before login
+	app.Post("/crash-reports", combineHandlers(optionalAuthorization(requestAuthorizer), c.serveReport))
+
+	requireAdmin := requirePermissions(buzza.PermissionAdminDashboard)
+	app.Get("/admin/crash-groups", combineHandlers(requestAuthorizer, requireAdmin, c.serveGroups))
+	app.Get("/admin/crash-groups/:signature/reports", combineHandlers(requestAuthorizer, requireAdmin, c.serveGroupReports))
+	app.Get("/admin/crash-reports/:id/minidump", combineHandlers(requestAuthorizer, requireAdmin, c.serveMinidump))
+}
+
+// Report body optionally compressed with gzip (Content-Encoding: gzip).
+func (c *CrashController) serveReport(ctx *fiber.Ctx) error {
+	rawBody, err := crashReportBody(ctx)
+	if err != nil {
+		return err
+	}
+	body := struct {
+		Module         string `json:"module"`
+		ProgramVersion string `json:"programVersion"`
+		Branch         string `json:"branch"`
+		OS             string `json:"os"`
+		Arch           string `json:"arch"`
+		StackTrace     string `json:"stackTrace"`
+		// base64 encoded
+		Minidump []byte `json:"minidump"`
+	}{}
+	if err := json.Unmarshal(rawBody, &body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	for _, field := range []string{body.Module, body.ProgramVersion, body.OS, body.Arch} {
+		if field == "" || len(field) > 64 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid program info")
+		}
+	}
+	if len(body.Branch) > 64 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid program info")
+	}
+	if strings.TrimSpace(body.StackTrace) == "" || len(body.StackTrace) > maxStackTraceSize {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid stack trace")
+	}
+	if len(body.Minidump) > maxMinidumpSize {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, "minidump too large")
+	}
+
+	report := buzza.CrashReport{
+		Signature:      buzza.CrashSignature(body.Module, body.StackTrace),
+		Module:         body.Module,
+		ProgramVersion: body.ProgramVersion,
+		Branch:         body.Branch,
+		OS:             body.OS,
+		Arch:           body.Arch,
+		StackTrace:     body.StackTrace,
+	}
+	if user, ok := ctx.Locals(userLocalsKey).(buzza.User); ok {
+		report.UserId = user.Id
+	}
+	if len(body.Minidump) > 0 {
+		report.MinidumpKey = "minidumps/" + report.Signature + "/" + uuid.New().String() + ".dmp"
+	}
+
+	stored, sampled, err := c.Store.Add(ctx.Context(), report)
+	if err != nil {
+		return fmt.Errorf("add crash report: %w", err)
+	}
+	if sampled && stored.MinidumpKey != "" {
+		err := c.Attachments.Put(ctx.Context(), stored.MinidumpKey, bytes.NewReader(body.Minidump))
+		if err != nil {
+			// report itself is still valuable without the minidump
+			requestLog(ctx).WithError(err).WithField("crash_report_id", stored.Id).
+				Errorln("Could not store minidump.")
+			if err := c.Store.DetachMinidump(ctx.Context(), stored.Id); err != nil {
+				return fmt.Errorf("detach minidump: %w", err)
+			}
+		}
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(map[string]interface{}{
+		"signature": report.Signature,
+	})
+}
+
+func crashReportBody(ctx *fiber.Ctx) ([]byte, error) {
+	// raw body, because ctx.Body() decompresses without any size limit
+	body := ctx.Request().Body()
+	if !strings.EqualFold(ctx.Get(fiber.HeaderContentEncoding), "gzip") {
+		return body, nil
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "invalid gzip body")
+	}
+	defer gzipReader.Close()
+	// limit reader protects against decompression bombs
+	decompressed, err := ioutil.ReadAll(io.LimitReader(gzipReader, maxCrashReportSize+1))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "invalid gzip body")
+	}
+	if len(decompressed) > maxCrashReportSize {
+		return nil, fiber.NewError(fiber.StatusRequestEntityTooLarge, "report too large")
+	}
+	return decompressed, nil
+}
+
+func (c *CrashController) serveGroups(ctx *fiber.Ctx) error {
+	offset, limit, err := paginationQuery(ctx, 50, 100)
+	if err != nil {
+		return err
+	}
+	groups, err := c.Store.Groups(ctx.Context(), buzza.CrashGroupQuery{
+		ProgramVersion: utils.CopyString(ctx.Query("version")),
+		Offset:         offset,
+		Limit:          limit,
+	})
+	if err != nil {
+		return fmt.Errorf("get crash groups: %w", err)
+	}
+
+	type Group struct {
+		Signature      string `json:"signature"`
+		Module         string `json:"module"`
+		ProgramVersion string `json:"programVersion"`
+		Count          int64  `json:"count"`
+		FirstSeenAt    int64  `json:"firstSeenAt"`
+		LastSeenAt     int64  `json:"lastSeenAt"`
+		StackTrace     string `json:"stackTrace"`
+	}
+	mapped := make([]Group, len(groups))
+	for i, g := range groups {
+		mapped[i] = Group{
+			Signature:      g.Signature,
+			Module:         g.Module,
+			ProgramVersion: g.ProgramVersion,
+			Count:          g.Count,
+			FirstSeenAt:    g.FirstSeenAt.Unix(),
+			LastSeenAt:     g.LastSeenAt.Unix(),
+			StackTrace:     g.StackTrace,
+		}
+	}
+	return ctx.JSON(mapped)
+}
+
+func (c *CrashController) serveGroupReports(ctx *fiber.Ctx) error {
+	offset, limit, err := paginationQuery(ctx, 10, 100)
+	if err != nil {
+		return err
+	}
+	reports, err := c.Store.BySignature(ctx.Context(), utils.CopyString(ctx.Params("signature")), offset, limit)
+	if err != nil {
+		return fmt.Errorf("get crash reports by signature: %w", err)
+	}
+
+	type Report struct {
+		Id             int64        `json:"id"`
+		UserId         buzza.UserId `json:"userId,omitempty"`
+		Module         string       `json:"module"`
+		ProgramVersion string       `json:"programVersion"`
+		Branch         string       `json:"branch"`
+		OS             string       `json:"os"`
+		Arch           string       `json:"arch"`
+		StackTrace     string       `json:"stackTrace"`
+		HasMinidump    bool         `json:"hasMinidump"`
+		CreatedAt      int64        `json:"createdAt"`
+	}
+	mapped := make([]Report, len(reports))
+	for i, r := range reports {
+		mapped[i] = Report{
+			Id:             r.Id,
+			UserId:         r.UserId,
+			Module:         r.Module,
+			ProgramVersion: r.ProgramVersion,
+			Branch:         r.Branch,
+			OS:             r.OS,
+			Arch:           r.Arch,
+			StackTrace:     r.StackTrace,
+			HasMinidump:    r.MinidumpKey != "",
+			CreatedAt:      r.CreatedAt.Unix(),
+		}
+	}
+	return ctx.JSON(mapped)
+}
+
+func (c *CrashController) serveMinidump(ctx *fiber.Ctx) error {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid crash report id")
+	}
+	report, err := c.Store.ById(ctx.Context(), id)
+	if err != nil {
+		if errors.Is(err, buzza.ErrCrashReportNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "crash report not found")
+		} else {
+			return fmt.Errorf("get crash report: %w", err)
+		}
+	}
+	if report.MinidumpKey == "" {
+		return fiber.NewError(fiber.StatusNotFound, "minidump not found")
+	}
+
+	minidump, err := c.Attachments.Open(ctx.Context(), report.MinidumpKey)
+	if err != nil {
+		if errors.Is(err, buzza.ErrAttachmentNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "minidump not found")
+		} else {
+			return fmt.Errorf("open minidump: %w", err)
+		}
+	}
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	ctx.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="crash-%d.dmp"`, report.Id))
+	// fasthttp closes the stream after sending
+	return ctx.SendStream(minidump)
+}
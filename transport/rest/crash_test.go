@@ -0,0 +1,229 @@
+package rest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/buzkaaclicker/buzza/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrashControllerReport(t *testing.T) {
+	assert := assert.New(t)
+
+	var added []buzza.CrashReport
+	store := mock.CrashStore{
+		AddFn: func(ctx context.Context, report buzza.CrashReport) (buzza.CrashReport, bool, error) {
+			added = append(added, report)
+			report.Id = int64(len(added))
+			// only first report is sampled
+			return report, len(added) == 1, nil
+		},
+	}
+	attachments := inmem.NewAttachmentStorage()
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := CrashController{Store: store, Attachments: &attachments}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, buzza.User{Id: 7})
+		return nil
+	}, app)
+
+	minidump := []byte("MDMP minidump")
+	report := `{"module":"clicker","programVersion":"1.2.0","branch":"stable","os":"windows","arch":"amd64",` +
+		`"stackTrace":"at clicker.exe!Clicker::Tick+0x1f2","minidump":"` + base64.StdEncoding.EncodeToString(minidump) + `"}`
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, _ = gzipWriter.Write([]byte(report))
+	_ = gzipWriter.Close()
+	signature := buzza.CrashSignature("clicker", "at clicker.exe!Clicker::Tick+0x1f2")
+
+	cases := []struct {
+		body          []byte
+		gzip          bool
+		authorization string
+		statusCode    int
+		response      string
+	}{
+		{body: compressed.Bytes(), gzip: true, statusCode: fiber.StatusCreated,
+			response: `{"signature":"` + signature + `"}`},
+		{body: []byte(report), authorization: "Bearer token", statusCode: fiber.StatusCreated,
+			response: `{"signature":"` + signature + `"}`},
+		{body: []byte(report), gzip: true, statusCode: fiber.StatusBadRequest,
+			response: JsonErrorMessageResponse("invalid gzip body")},
+		{body: []byte(`{"module":"clicker","programVersion":"1.2.0","os":"windows","arch":"amd64","stackTrace":" "}`),
+			statusCode: fiber.StatusBadRequest, response: JsonErrorMessageResponse("invalid stack trace")},
+		{body: []byte(`{"programVersion":"1.2.0","os":"windows","arch":"amd64","stackTrace":"at x"}`),
+			statusCode: fiber.StatusBadRequest, response: JsonErrorMessageResponse("invalid program info")},
+	}
+	for i, tc := range cases {
+		req := httptest.NewRequest("POST", "/crash-reports", bytes.NewReader(tc.body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		if tc.gzip {
+			req.Header.Set(fiber.HeaderContentEncoding, "gzip")
+		}
+		if tc.authorization != "" {
+			req.Header.Set(fiber.HeaderAuthorization, tc.authorization)
+		}
+		resp, err := app.Test(req)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, i)
+		assert.Equal(tc.response, string(body), i)
+	}
+
+	if !assert.Len(added, 2) {
+		return
+	}
+	assert.Equal(buzza.UserId(0), added[0].UserId)
+	assert.Equal(buzza.UserId(7), added[1].UserId)
+	assert.Equal("1.2.0", added[0].ProgramVersion)
+	assert.True(strings.HasPrefix(added[0].MinidumpKey, "minidumps/"+signature+"/"))
+
+	stored, err := attachments.Open(context.Background(), added[0].MinidumpKey)
+	if assert.NoError(err) {
+		content, _ := ioutil.ReadAll(stored)
+		assert.Equal(minidump, content)
+	}
+	// not sampled report should not store its minidump
+	_, err = attachments.Open(context.Background(), added[1].MinidumpKey)
+	assert.ErrorIs(err, buzza.ErrAttachmentNotFound)
+}
+
+func TestCrashControllerMinidumpNotStored(t *testing.T) {
+	assert := assert.New(t)
+
+	var detached []int64
+	store := mock.CrashStore{
+		AddFn: func(ctx context.Context, report buzza.CrashReport) (buzza.CrashReport, bool, error) {
+			report.Id = 3
+			return report, true, nil
+		},
+		DetachMinidumpFn: func(ctx context.Context, id int64) error {
+			detached = append(detached, id)
+			return nil
+		},
+	}
+	attachments := mock.AttachmentStorage{
+		PutFn: func(ctx context.Context, key string, r io.Reader) error {
+			return errors.New("disk full")
+		},
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := CrashController{Store: store, Attachments: attachments}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		return nil
+	}, app)
+
+	report := `{"module":"clicker","programVersion":"1.2.0","os":"windows","arch":"amd64",` +
+		`"stackTrace":"at x","minidump":"` + base64.StdEncoding.EncodeToString([]byte("MDMP")) + `"}`
+	req := httptest.NewRequest("POST", "/crash-reports", strings.NewReader(report))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req)
+	if !assert.NoError(err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(fiber.StatusCreated, resp.StatusCode)
+	assert.Equal([]int64{3}, detached, "report should not point to missing minidump")
+}
+
+func TestCrashControllerAdmin(t *testing.T) {
+	assert := assert.New(t)
+
+	store := mock.CrashStore{
+		GroupsFn: func(ctx context.Context, query buzza.CrashGroupQuery) ([]buzza.CrashGroup, error) {
+			if query.ProgramVersion != "1.2.0" || query.Offset != 0 || query.Limit != 50 {
+				return nil, nil
+			}
+			return []buzza.CrashGroup{{
+				Signature: "abc", Module: "clicker", ProgramVersion: "1.2.0", Count: 42,
+				FirstSeenAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+				LastSeenAt:  time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC),
+				StackTrace:  "at x",
+			}}, nil
+		},
+		BySignatureFn: func(ctx context.Context, signature string, offset int, limit int) ([]buzza.CrashReport, error) {
+			return []buzza.CrashReport{{
+				Id: 3, Signature: signature, UserId: 7, Module: "clicker", ProgramVersion: "1.2.0",
+				OS: "windows", Arch: "amd64", StackTrace: "at x", MinidumpKey: "minidumps/abc/3.dmp",
+				CreatedAt: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC),
+			}}, nil
+		},
+		ByIdFn: func(ctx context.Context, id int64) (buzza.CrashReport, error) {
+			switch id {
+			case 3:
+				return buzza.CrashReport{Id: 3, MinidumpKey: "minidumps/abc/3.dmp"}, nil
+			case 4:
+				return buzza.CrashReport{Id: 4}, nil
+			default:
+				return buzza.CrashReport{}, buzza.ErrCrashReportNotFound
+			}
+		},
+	}
+	attachments := inmem.NewAttachmentStorage()
+	_ = attachments.Put(context.Background(), "minidumps/abc/3.dmp", strings.NewReader("MDMP"))
+
+	var currentUser buzza.User
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := CrashController{Store: store, Attachments: &attachments}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, currentUser)
+		return nil
+	}, app)
+
+	admin := buzza.User{Id: 1, Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}}
+	cases := []struct {
+		user       buzza.User
+		url        string
+		statusCode int
+		response   string
+	}{
+		{user: buzza.User{Id: 7}, url: "/admin/crash-groups", statusCode: fiber.StatusUnauthorized,
+			response: JsonErrorMessageResponse(fiber.ErrUnauthorized.Message)},
+		{user: admin, url: "/admin/crash-groups?version=1.2.0", statusCode: fiber.StatusOK,
+			response: `[{"signature":"abc","module":"clicker","programVersion":"1.2.0","count":42,` +
+				`"firstSeenAt":1640995200,"lastSeenAt":1641081600,"stackTrace":"at x"}]`},
+		{user: admin, url: "/admin/crash-groups/abc/reports", statusCode: fiber.StatusOK,
+			response: `[{"id":3,"userId":7,"module":"clicker","programVersion":"1.2.0","branch":"","os":"windows",` +
+				`"arch":"amd64","stackTrace":"at x","hasMinidump":true,"createdAt":1641081600}]`},
+		{user: admin, url: "/admin/crash-reports/3/minidump", statusCode: fiber.StatusOK, response: "MDMP"},
+		{user: admin, url: "/admin/crash-reports/4/minidump", statusCode: fiber.StatusNotFound,
+			response: JsonErrorMessageResponse("minidump not found")},
+		{user: admin, url: "/admin/crash-reports/5/minidump", statusCode: fiber.StatusNotFound,
+			response: JsonErrorMessageResponse("crash report not found")},
+	}
+	for _, tc := range cases {
+		currentUser = tc.user
+		resp, err := app.Test(httptest.NewRequest("GET", tc.url, nil))
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, tc.url)
+		assert.Equal(tc.response, string(body), tc.url)
+	}
+}
@@ -0,0 +1,232 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+type FeatureFlagController struct {
+	Store buzza.FeatureFlagStore
+}
+
+func (c *FeatureFlagController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	// logged out clients get flags without role and percentage targeting
+	app.Get("/client-config", combineHandlers(optionalAuthorization(requestAuthorizer), c.serveClientConfig))
+
+	requireAdmin := requirePermissions(buzza.PermissionAdminDashboard)
+	app.Get("/admin/feature-flags", combineHandlers(requestAuthorizer, requireAdmin, c.serveFlags))
+	app.Put("/admin/feature-flags/:key", combineHandlers(requestAuthorizer, requireAdmin, c.serveSaveFlag))
+	app.Delete("/admin/feature-flags/:key", combineHandlers(requestAuthorizer, requireAdmin, c.serveDeleteFlag))
+	app.Get("/admin/feature-flags/:key/history", combineHandlers(requestAuthorizer, requireAdmin, c.serveFlagHistory))
+}
+
+type targetingBody struct {
+	Branches   []string       `json:"branches"`
+	OS         []string       `json:"os"`
+	Roles      []buzza.RoleId `json:"roles"`
+	MinVersion string         `json:"minVersion"`
+	MaxVersion string         `json:"maxVersion"`
+}
+
+func newTargetingBody(t buzza.Targeting) targetingBody {
+	return targetingBody{
+		Branches:   t.Branches,
+		OS:         t.OS,
+		Roles:      t.Roles,
+		MinVersion: t.MinVersion,
+		MaxVersion: t.MaxVersion,
+	}
+}
+
+func (t targetingBody) toDomain() buzza.Targeting {
+	return buzza.Targeting{
+		Branches:   t.Branches,
+		OS:         t.OS,
+		Roles:      t.Roles,
+		MinVersion: t.MinVersion,
+		MaxVersion: t.MaxVersion,
+	}
+}
+
+type featureFlagResponse struct {
+	Key          string          `json:"key"`
+	Description  string          `json:"description"`
+	Enabled      bool            `json:"enabled"`
+	Value        json.RawMessage `json:"value"`
+	DefaultValue json.RawMessage `json:"defaultValue"`
+	Targeting    targetingBody   `json:"targeting"`
+	Percentage   int             `json:"percentage"`
+	UpdatedAt    int64           `json:"updatedAt"`
+}
+
+func newFeatureFlagResponse(flag buzza.FeatureFlag) featureFlagResponse {
+	return featureFlagResponse{
+		Key:          flag.Key,
+		Description:  flag.Description,
+		Enabled:      flag.Enabled,
+		Value:        flag.Value,
+		DefaultValue: flag.DefaultValue,
+		Targeting:    newTargetingBody(flag.Targeting),
+		Percentage:   flag.Percentage,
+		UpdatedAt:    flag.UpdatedAt.Unix(),
+	}
+}
+
+// Client info from the "branch", "version" and "os" query params and the logged in user.
+func clientContext(ctx *fiber.Ctx) buzza.ClientContext {
+	client := buzza.ClientContext{
+		Branch:  utils.CopyString(ctx.Query("branch")),
+		Version: utils.CopyString(ctx.Query("version")),
+		OS:      utils.CopyString(ctx.Query("os")),
+	}
+	if user, ok := ctx.Locals(userLocalsKey).(buzza.User); ok {
+		client.UserId = user.Id
+		client.Roles = user.Roles
+	}
+	return client
+}
+
+func (c *FeatureFlagController) serveClientConfig(ctx *fiber.Ctx) error {
+	flags, err := c.Store.All(ctx.Context())
+	if err != nil {
+		return fmt.Errorf("get feature flags: %w", err)
+	}
+	response, err := json.Marshal(map[string]interface{}{
+		"flags": buzza.EvaluateFeatureFlags(flags, clientContext(ctx)),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal client config: %w", err)
+	}
+
+	// clients poll the config, so let them skip the body if nothing changed for them
+	hash := sha256.Sum256(response)
+	etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+	ctx.Set(fiber.HeaderETag, etag)
+	ctx.Set(fiber.HeaderCacheControl, "private, no-cache")
+	if strings.TrimPrefix(ctx.Get(fiber.HeaderIfNoneMatch), "W/") == etag {
+		return ctx.SendStatus(fiber.StatusNotModified)
+	}
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return ctx.Send(response)
+}
+
+func (c *FeatureFlagController) serveFlags(ctx *fiber.Ctx) error {
+	flags, err := c.Store.All(ctx.Context())
+	if err != nil {
+		return fmt.Errorf("get feature flags: %w", err)
+	}
+	mapped := make([]featureFlagResponse, len(flags))
+	for i, flag := range flags {
+		mapped[i] = newFeatureFlagResponse(flag)
+	}
+	return ctx.JSON(mapped)
+}
+
+func (c *FeatureFlagController) serveSaveFlag(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	body := struct {
+		Description  string          `json:"description"`
+		Enabled      bool            `json:"enabled"`
+		Value        json.RawMessage `json:"value"`
+		DefaultValue json.RawMessage `json:"defaultValue"`
+		Targeting    targetingBody   `json:"targeting"`
+		Percentage   *int            `json:"percentage"`
+	}{}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+
+	// flags without values are simple on/off switches
+	flag := buzza.FeatureFlag{
+		Key:          utils.CopyString(ctx.Params("key")),
+		Description:  body.Description,
+		Enabled:      body.Enabled,
+		Value:        body.Value,
+		DefaultValue: body.DefaultValue,
+		Targeting:    body.Targeting.toDomain(),
+		Percentage:   100,
+	}
+	if flag.Value == nil {
+		flag.Value = json.RawMessage("true")
+	}
+	if flag.DefaultValue == nil {
+		flag.DefaultValue = json.RawMessage("false")
+	}
+	if body.Percentage != nil {
+		flag.Percentage = *body.Percentage
+	}
+	if err := flag.Validate(); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	saved, err := c.Store.Save(ctx.Context(), flag, user.Id)
+	if err != nil {
+		return fmt.Errorf("save feature flag: %w", err)
+	}
+	requestLog(ctx).
+		WithField("user_id", user.Id).
+		WithField("feature_flag", saved.Key).
+		Infoln("Feature flag saved.")
+	return ctx.JSON(newFeatureFlagResponse(saved))
+}
+
+func (c *FeatureFlagController) serveDeleteFlag(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	key := utils.CopyString(ctx.Params("key"))
+	if err := c.Store.Delete(ctx.Context(), key, user.Id); err != nil {
+		if errors.Is(err, buzza.ErrFeatureFlagNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "feature flag not found")
+		} else {
+			return fmt.Errorf("delete feature flag: %w", err)
+		}
+	}
+	requestLog(ctx).
+		WithField("user_id", user.Id).
+		WithField("feature_flag", key).
+		Infoln("Feature flag deleted.")
+	return nil
+}
+
+func (c *FeatureFlagController) serveFlagHistory(ctx *fiber.Ctx) error {
+	offset, limit, err := paginationQuery(ctx, 20, 100)
+	if err != nil {
+		return err
+	}
+	changes, err := c.Store.History(ctx.Context(), utils.CopyString(ctx.Params("key")), offset, limit)
+	if err != nil {
+		return fmt.Errorf("get feature flag history: %w", err)
+	}
+
+	type Change struct {
+		Id        int64               `json:"id"`
+		AuthorId  buzza.UserId        `json:"authorId"`
+		Action    string              `json:"action"`
+		Flag      featureFlagResponse `json:"flag"`
+		CreatedAt int64               `json:"createdAt"`
+	}
+	mapped := make([]Change, len(changes))
+	for i, change := range changes {
+		mapped[i] = Change{
+			Id:        change.Id,
+			AuthorId:  change.AuthorId,
+			Action:    string(change.Action),
+			Flag:      newFeatureFlagResponse(change.Flag),
+			CreatedAt: change.CreatedAt.Unix(),
+		}
+	}
+	return ctx.JSON(mapped)
+}
@@ -0,0 +1,158 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureFlagControllerClientConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	store := mock.FeatureFlagStore{
+		AllFn: func(ctx context.Context) ([]buzza.FeatureFlag, error) {
+			return []buzza.FeatureFlag{
+				{Key: "max-cps", Enabled: true, Value: json.RawMessage("20"), DefaultValue: json.RawMessage("16"),
+					Targeting: buzza.Targeting{Roles: []buzza.RoleId{buzza.RoleIdPro}}, Percentage: 100},
+				{Key: "new-ui", Enabled: true, Value: json.RawMessage("true"), DefaultValue: json.RawMessage("false"),
+					Targeting: buzza.Targeting{Branches: []string{"beta"}, MinVersion: "1.4"}, Percentage: 100},
+			}, nil
+		},
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := FeatureFlagController{Store: store}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, buzza.User{Id: 3, Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdPro]}})
+		return nil
+	}, app)
+
+	request := func(url string, authorized bool, ifNoneMatch string) (int, string, string) {
+		header := map[string]string{}
+		if authorized {
+			header[fiber.HeaderAuthorization] = "Bearer token"
+		}
+		if ifNoneMatch != "" {
+			header[fiber.HeaderIfNoneMatch] = ifNoneMatch
+		}
+		resp, body := testRequest(t, app, "GET", url, "", header)
+		return resp.StatusCode, string(body), resp.Header.Get(fiber.HeaderETag)
+	}
+
+	status, body, etag := request("/client-config?branch=beta&version=1.4.2", false, "")
+	assert.Equal(fiber.StatusOK, status)
+	assert.Equal(`{"flags":{"max-cps":16,"new-ui":true}}`, body)
+	assert.NotEmpty(etag)
+
+	status, body, _ = request("/client-config?branch=beta&version=1.4.2", false, etag)
+	assert.Equal(fiber.StatusNotModified, status)
+	assert.Empty(body)
+
+	status, body, proEtag := request("/client-config?branch=stable&version=1.4.2", true, etag)
+	assert.Equal(fiber.StatusOK, status)
+	assert.Equal(`{"flags":{"max-cps":20,"new-ui":false}}`, body)
+	assert.NotEqual(etag, proEtag)
+}
+
+func TestFeatureFlagControllerAdmin(t *testing.T) {
+	assert := assert.New(t)
+
+	var saved []buzza.FeatureFlag
+	store := mock.FeatureFlagStore{
+		AllFn: func(ctx context.Context) ([]buzza.FeatureFlag, error) {
+			return []buzza.FeatureFlag{{Key: "new-ui", Enabled: true, Value: json.RawMessage("true"),
+				DefaultValue: json.RawMessage("false"), Percentage: 50,
+				UpdatedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}}, nil
+		},
+		SaveFn: func(ctx context.Context, flag buzza.FeatureFlag, authorId buzza.UserId) (buzza.FeatureFlag, error) {
+			saved = append(saved, flag)
+			flag.UpdatedAt = time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+			return flag, nil
+		},
+		DeleteFn: func(ctx context.Context, key string, authorId buzza.UserId) error {
+			if key != "new-ui" {
+				return buzza.ErrFeatureFlagNotFound
+			}
+			return nil
+		},
+		HistoryFn: func(ctx context.Context, key string, offset int, limit int) ([]buzza.FeatureFlagChange, error) {
+			return []buzza.FeatureFlagChange{{Id: 2, Key: key, AuthorId: 1, Action: buzza.FeatureFlagActionDelete,
+				Flag: buzza.FeatureFlag{Key: key, Value: json.RawMessage("true"), DefaultValue: json.RawMessage("false"),
+					UpdatedAt: time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC)},
+				CreatedAt: time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC)}}, nil
+		},
+	}
+
+	var currentUser buzza.User
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := FeatureFlagController{Store: store}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, currentUser)
+		return nil
+	}, app)
+
+	admin := buzza.User{Id: 1, Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}}
+	cases := []struct {
+		user       buzza.User
+		method     string
+		url        string
+		body       string
+		statusCode int
+		response   string
+	}{
+		{user: buzza.User{Id: 3}, method: "GET", url: "/admin/feature-flags",
+			statusCode: fiber.StatusUnauthorized, response: JsonErrorMessageResponse(fiber.ErrUnauthorized.Message)},
+		{user: admin, method: "GET", url: "/admin/feature-flags", statusCode: fiber.StatusOK,
+			response: `[{"key":"new-ui","description":"","enabled":true,"value":true,"defaultValue":false,` +
+				`"targeting":{"branches":null,"os":null,"roles":null,"minVersion":"","maxVersion":""},` +
+				`"percentage":50,"updatedAt":1640995200}]`},
+		{user: admin, method: "PUT", url: "/admin/feature-flags/max-cps",
+			body:       `{"enabled":true,"value":20,"defaultValue":16,"targeting":{"roles":["pro"]}}`,
+			statusCode: fiber.StatusOK,
+			response: `{"key":"max-cps","description":"","enabled":true,"value":20,"defaultValue":16,` +
+				`"targeting":{"branches":null,"os":null,"roles":["pro"],"minVersion":"","maxVersion":""},` +
+				`"percentage":100,"updatedAt":1641081600}`},
+		{user: admin, method: "PUT", url: "/admin/feature-flags/new-ui", body: `{"enabled":true,"percentage":0}`,
+			statusCode: fiber.StatusOK,
+			response: `{"key":"new-ui","description":"","enabled":true,"value":true,"defaultValue":false,` +
+				`"targeting":{"branches":null,"os":null,"roles":null,"minVersion":"","maxVersion":""},` +
+				`"percentage":0,"updatedAt":1641081600}`},
+		{user: admin, method: "PUT", url: "/admin/feature-flags/Invalid", body: `{}`,
+			statusCode: fiber.StatusBadRequest, response: JsonErrorMessageResponse("invalid key")},
+		{user: admin, method: "PUT", url: "/admin/feature-flags/new-ui", body: `{"targeting":{"minVersion":"x"}}`,
+			statusCode: fiber.StatusBadRequest, response: JsonErrorMessageResponse("invalid min version")},
+		{user: admin, method: "DELETE", url: "/admin/feature-flags/new-ui", statusCode: fiber.StatusOK},
+		{user: admin, method: "DELETE", url: "/admin/feature-flags/old-ui",
+			statusCode: fiber.StatusNotFound, response: JsonErrorMessageResponse("feature flag not found")},
+		{user: admin, method: "GET", url: "/admin/feature-flags/new-ui/history", statusCode: fiber.StatusOK,
+			response: `[{"id":2,"authorId":1,"action":"delete","flag":{"key":"new-ui","description":"",` +
+				`"enabled":false,"value":true,"defaultValue":false,` +
+				`"targeting":{"branches":null,"os":null,"roles":null,"minVersion":"","maxVersion":""},` +
+				`"percentage":0,"updatedAt":1641168000},"createdAt":1641168000}]`},
+	}
+	for _, tc := range cases {
+		currentUser = tc.user
+		req := httptest.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, tc.method+" "+tc.url)
+		assert.Equal(tc.response, string(body), tc.method+" "+tc.url)
+	}
+	assert.Len(saved, 2)
+}
@@ -0,0 +1,128 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+)
+
+// External accounts linked to the logged in user.
+// Logins with them are served by AuthController.
+type IdentityController struct {
+	Linker *buzza.IdentityLinker
+}
+
+func (c *IdentityController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	app.Get("/identities", combineHandlers(requestAuthorizer, c.serveIdentities))
+	app.Get("/identities/:provider/url", combineHandlers(requestAuthorizer, c.serveLinkUrl))
+	app.Post("/identities/:provider", combineHandlers(requestAuthorizer, c.serveLink))
+	app.Delete("/identities/:provider", combineHandlers(requestAuthorizer, c.serveUnlink))
+}
+
+type identityMeta struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email"`
+	LinkedAt int64  `json:"linkedAt"`
+}
+
+func newIdentityMeta(identity buzza.UserIdentity) identityMeta {
+	return identityMeta{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    string(identity.Email),
+		LinkedAt: identity.LinkedAt.Unix(),
+	}
+}
+
+func (c *IdentityController) serveIdentities(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	identities, err := c.Linker.Store.Identities(ctx.Context(), user.Id)
+	if err != nil {
+		return fmt.Errorf("get identities: %w", err)
+	}
+	metas := make([]identityMeta, len(identities))
+	for i, identity := range identities {
+		metas[i] = newIdentityMeta(identity)
+	}
+	return ctx.JSON(metas)
+}
+
+func (c *IdentityController) serveLinkUrl(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	url, err := c.Linker.BeginLink(user.Id, ctx.Params("provider"))
+	if err != nil {
+		return identityError(err)
+	}
+	return ctx.JSON(map[string]string{
+		"url": url,
+	})
+}
+
+func (c *IdentityController) serveLink(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	var body struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	if body.Code == "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid code")
+	}
+	identity, err := c.Linker.FinishLink(ctx.Context(), user.Id, ctx.Params("provider"), body.Code, body.State)
+	if err != nil {
+		return identityError(err)
+	}
+	requestLog(ctx).WithField("user_id", user.Id).WithField("provider", identity.Provider).
+		Infoln("Identity linked.")
+	return ctx.Status(fiber.StatusCreated).JSON(newIdentityMeta(identity))
+}
+
+func (c *IdentityController) serveUnlink(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	provider := ctx.Params("provider")
+	if err := c.Linker.Unlink(ctx.Context(), user.Id, provider); err != nil {
+		return identityError(err)
+	}
+	requestLog(ctx).WithField("user_id", user.Id).WithField("provider", provider).Infoln("Identity unlinked.")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func identityError(err error) error {
+	switch {
+	case errors.Is(err, buzza.ErrUnknownIdentityProvider):
+		return fiber.NewError(fiber.StatusNotFound, "unknown provider")
+	case errors.Is(err, buzza.ErrInvalidAuthorizationCode):
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid code")
+	case errors.Is(err, buzza.ErrIdentityAccessDenied):
+		return fiber.NewError(fiber.StatusUnauthorized, "provider access denied")
+	case errors.Is(err, buzza.ErrInvalidIdentityLinkState):
+		return fiber.NewError(fiber.StatusBadRequest, "invalid state")
+	case errors.Is(err, buzza.ErrIdentityNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "identity not found")
+	case errors.Is(err, buzza.ErrIdentityLinked):
+		return fiber.NewError(fiber.StatusConflict, "identity linked to another user")
+	case errors.Is(err, buzza.ErrProviderLinked):
+		return fiber.NewError(fiber.StatusConflict, "provider already linked")
+	case errors.Is(err, buzza.ErrLastIdentity):
+		return fiber.NewError(fiber.StatusConflict, "last identity can not be unlinked")
+	default:
+		return err
+	}
+}
@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/discord"
+	"github.com/buzkaaclicker/buzza/github"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityLinking(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	userStore := inmem.NewUserStore()
+	activityStore := inmem.NewActivityStore()
+	sessionStore := inmem.NewSessionStore(&activityStore)
+
+	discordUser := discord.User{Id: "2137", Username: "user", Email: "user@buzkaaclicker.pl"}
+	user, _, err := userStore.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderDiscord, Subject: discordUser.Id, Email: buzza.Email(discordUser.Email)})
+	if !assert.NoError(err) {
+		return
+	}
+
+	providers := testDiscordProviders(discordUser)
+	providers[buzza.ProviderGitHub] = &buzza.GitHubIdentityProvider{
+		CreateOAuthUrl: github.RestOAuthUrlFactory("client", "https://buzkaaclicker.pl/github"),
+		ExchangeAccessToken: func(code string) (github.AccessTokenResponse, error) {
+			if code != "gh" {
+				return github.AccessTokenResponse{}, github.ErrOAuthInvalidCode
+			}
+			return github.AccessTokenResponse{AccessToken: "access"}, nil
+		},
+		UserMe: func(accessToken string) (github.User, error) {
+			return github.User{Id: 583231, Login: "octocat"}, nil
+		},
+		UserEmails: func(accessToken string) ([]github.Email, error) {
+			return []github.Email{{Email: "octocat@github.com", Primary: true, Verified: true}}, nil
+		},
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	authController := AuthController{
+		Providers:    providers,
+		SessionStore: &sessionStore,
+		UserStore:    &userStore,
+	}
+	authController.InstallTo(app)
+	identityController := IdentityController{Linker: &buzza.IdentityLinker{
+		Providers:     providers,
+		Store:         &userStore,
+		ActivityStore: &activityStore,
+		Secret:        []byte("0123456789abcdef0123456789abcdef"),
+	}}
+	identityController.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, user)
+		return nil
+	}, app)
+
+	request := func(method string, url string, body string, statusCode int, response interface{}) {
+		testJsonRequest(t, app, method, url, body, nil, statusCode, response)
+	}
+
+	var identities []identityMeta
+	request("GET", "/identities", "", fiber.StatusOK, &identities)
+	if assert.Len(identities, 1) {
+		assert.Equal(buzza.ProviderDiscord, identities[0].Provider)
+	}
+
+	var authUrl struct {
+		Url string `json:"url"`
+	}
+	request("GET", "/auth/github", "", fiber.StatusOK, &authUrl)
+	assert.NotContains(authUrl.Url, "state=")
+	request("GET", "/auth/myspace", "", fiber.StatusNotFound, nil)
+	request("GET", "/identities/myspace/url", "", fiber.StatusNotFound, nil)
+	request("GET", "/identities/github/url", "", fiber.StatusOK, &authUrl)
+	parsedUrl, err := url.Parse(authUrl.Url)
+	if !assert.NoError(err) {
+		return
+	}
+	state := parsedUrl.Query().Get("state")
+	assert.NotEmpty(state)
+
+	request("POST", "/identities/github", `{"code":"gh","state":"forged"}`, fiber.StatusBadRequest, nil)
+	request("POST", "/identities/github", `{"code":"invalid","state":"`+state+`"}`, fiber.StatusUnauthorized, nil)
+	var linked identityMeta
+	request("POST", "/identities/github", `{"code":"gh","state":"`+state+`"}`, fiber.StatusCreated, &linked)
+	assert.Equal(identityMeta{Provider: buzza.ProviderGitHub, Subject: "583231", Email: "octocat@github.com",
+		LinkedAt: linked.LinkedAt}, linked)
+
+	// login with the linked account
+	var session struct {
+		UserId buzza.UserId `json:"userId"`
+	}
+	request("POST", "/auth/github", `{"code":"gh"}`, fiber.StatusCreated, &session)
+	assert.Equal(user.Id, session.UserId)
+
+	request("DELETE", "/identities/discord", "", fiber.StatusNoContent, nil)
+	request("DELETE", "/identities/discord", "", fiber.StatusNotFound, nil)
+	request("DELETE", "/identities/github", "", fiber.StatusConflict, nil)
+	request("GET", "/identities", "", fiber.StatusOK, &identities)
+	if assert.Len(identities, 1) {
+		assert.Equal(buzza.ProviderGitHub, identities[0].Provider)
+	}
+
+	logs, err := activityStore.ByUserId(ctx, user.Id, buzza.ActivityQuery{Limit: 100})
+	if assert.NoError(err) {
+		names := map[string]bool{}
+		for _, log := range logs {
+			names[log.Name] = true
+		}
+		assert.True(names[buzza.ActivityIdentityLinked])
+		assert.True(names[buzza.ActivityIdentityUnlinked])
+	}
+}
@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Login alert settings and the "this wasn't me" session revocation.
+type LoginAlertController struct {
+	SessionStore  buzza.SessionStore
+	SettingsStore buzza.NotificationSettingsStore
+	ActivityStore buzza.ActivityStore
+	// Secret used to sign the revoke tokens by buzza.LoginAlerter.
+	RevokeSecret []byte
+}
+
+func (c *LoginAlertController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	app.Get("/notification-settings", combineHandlers(requestAuthorizer, c.serveSettings))
+	app.Put("/notification-settings", combineHandlers(requestAuthorizer, c.serveSaveSettings))
+	// token from the alert authorizes the request, user may not be logged in on the device
+	app.Post("/login-alerts/revoke", c.serveRevoke)
+}
+
+type notificationSettingsBody struct {
+	LoginEmail   bool `json:"loginEmail"`
+	LoginDiscord bool `json:"loginDiscord"`
+}
+
+func (c *LoginAlertController) serveSettings(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	settings, err := c.SettingsStore.ByUserId(ctx.Context(), user.Id)
+	if err != nil {
+		return fmt.Errorf("get notification settings: %w", err)
+	}
+	return ctx.JSON(notificationSettingsBody{LoginEmail: settings.LoginEmail, LoginDiscord: settings.LoginDiscord})
+}
+
+func (c *LoginAlertController) serveSaveSettings(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	var body notificationSettingsBody
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	settings := buzza.NotificationSettings{LoginEmail: body.LoginEmail, LoginDiscord: body.LoginDiscord}
+	if err := c.SettingsStore.Save(ctx.Context(), user.Id, settings); err != nil {
+		return fmt.Errorf("save notification settings: %w", err)
+	}
+	return ctx.JSON(body)
+}
+
+func (c *LoginAlertController) serveRevoke(ctx *fiber.Ctx) error {
+	body := struct {
+		Token string `json:"token"`
+	}{}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	userId, sessionId, err := buzza.VerifyRevokeToken(c.RevokeSecret, body.Token, time.Now())
+	if err != nil {
+		return fiber.NewError(fiber.StatusForbidden, "invalid token")
+	}
+
+	if err := c.SessionStore.InvalidateById(userId, sessionId); err != nil {
+		if errors.Is(err, buzza.ErrSessionNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "session not found")
+		} else {
+			return fmt.Errorf("session invalidate: %w", err)
+		}
+	}
+	err = c.ActivityStore.AddLog(ctx.Context(), userId, buzza.SessionRevokedByAlertActivity(sessionId, ctx.IP()))
+	if err != nil {
+		// session is already revoked, so the request succeeded anyway
+		requestLog(ctx).WithError(err).Errorln("Could not log session revocation.")
+	}
+	requestLog(ctx).WithField("user_id", userId).Infoln("Session revoked from login alert.")
+	return nil
+}
@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/buzkaaclicker/buzza/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginAlertController(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	activityStore := inmem.NewActivityStore()
+	sessionStore := inmem.NewSessionStore(&activityStore)
+	session, err := sessionStore.RegisterNew(ctx, 5, "1.1.1.1", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+
+	settings := map[buzza.UserId]buzza.NotificationSettings{}
+	settingsStore := mock.NotificationSettingsStore{
+		ByUserIdFn: func(ctx context.Context, userId buzza.UserId) (buzza.NotificationSettings, error) {
+			if s, ok := settings[userId]; ok {
+				return s, nil
+			}
+			return buzza.DefaultNotificationSettings, nil
+		},
+		SaveFn: func(ctx context.Context, userId buzza.UserId, s buzza.NotificationSettings) error {
+			settings[userId] = s
+			return nil
+		},
+	}
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := LoginAlertController{
+		SessionStore:  &sessionStore,
+		SettingsStore: settingsStore,
+		ActivityStore: &activityStore,
+		RevokeSecret:  secret,
+	}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, buzza.User{Id: 5})
+		return nil
+	}, app)
+
+	validToken := buzza.SignRevokeToken(secret, 5, session.Id, time.Now().Add(time.Hour))
+	cases := []struct {
+		method     string
+		url        string
+		body       string
+		statusCode int
+		response   string
+	}{
+		{method: "GET", url: "/notification-settings", statusCode: fiber.StatusOK,
+			response: `{"loginEmail":true,"loginDiscord":true}`},
+		{method: "PUT", url: "/notification-settings", body: `{"loginEmail":false,"loginDiscord":true}`,
+			statusCode: fiber.StatusOK, response: `{"loginEmail":false,"loginDiscord":true}`},
+		{method: "GET", url: "/notification-settings", statusCode: fiber.StatusOK,
+			response: `{"loginEmail":false,"loginDiscord":true}`},
+		{method: "POST", url: "/login-alerts/revoke", body: `{"token":"abc.def"}`,
+			statusCode: fiber.StatusForbidden, response: JsonErrorMessageResponse("invalid token")},
+		{method: "POST", url: "/login-alerts/revoke", body: `{"token":"` + validToken + `"}`,
+			statusCode: fiber.StatusOK},
+		{method: "POST", url: "/login-alerts/revoke", body: `{"token":"` + validToken + `"}`,
+			statusCode: fiber.StatusNotFound, response: JsonErrorMessageResponse("session not found")},
+	}
+	for _, tc := range cases {
+		var reqBody io.Reader
+		if tc.body != "" {
+			reqBody = strings.NewReader(tc.body)
+		}
+		req := httptest.NewRequest(tc.method, tc.url, reqBody)
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, tc.method+" "+tc.url)
+		assert.Equal(tc.response, string(body), tc.method+" "+tc.url)
+	}
+
+	exists, err := sessionStore.Exists(session.Token)
+	if assert.NoError(err) {
+		assert.False(exists)
+	}
+	logs, err := activityStore.ByUserId(ctx, 5, buzza.ActivityQuery{Names: []string{buzza.ActivitySessionRevokedByAlert}, Limit: 10})
+	if assert.NoError(err) && assert.Len(logs, 1) {
+		assert.Equal(session.Id, logs[0].Data["session_id"])
+	}
+}
@@ -0,0 +1,104 @@
+package rest
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/discord"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/buzkaaclicker/buzza/mail"
+	"github.com/buzkaaclicker/buzza/smtptest"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMagicLinkLogin(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	smtpServer, err := smtptest.NewServer()
+	if !assert.NoError(err) {
+		return
+	}
+	defer smtpServer.Close()
+
+	userStore := inmem.NewUserStore()
+	activityStore := inmem.NewActivityStore()
+	sessionStore := inmem.NewSessionStore(&activityStore)
+	user, _, err := userStore.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderDiscord, Subject: "2137", Email: "user@buzkaaclicker.pl", EmailVerified: true})
+	if !assert.NoError(err) {
+		return
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	authController := AuthController{
+		// other discord account with the same verified email
+		Providers:    testDiscordProviders(discord.User{Id: "42", Email: "user@buzkaaclicker.pl", Verified: true}),
+		SessionStore: &sessionStore,
+		UserStore:    &userStore,
+		MagicLinks: &buzza.MagicLinkAuthenticator{
+			Users:    &userStore,
+			Store:    &inmem.MagicLinkStore{},
+			Limiter:  &inmem.RateLimiter{},
+			Mailer:   &mail.SmtpMailer{Addr: smtpServer.Addr(), From: "noreply@buzkaaclicker.pl"},
+			LoginUrl: "https://buzkaaclicker.pl/magic-link",
+			Secret:   []byte("0123456789abcdef0123456789abcdef"),
+		},
+	}
+	authController.InstallTo(app)
+
+	request := func(url string, body string, statusCode int) map[string]interface{} {
+		response := map[string]interface{}{}
+		testJsonRequest(t, app, "POST", url, body, nil, statusCode, &response)
+		return response
+	}
+
+	request("/auth/email", `{"email":"not an email"}`, fiber.StatusBadRequest)
+	// links are sent in the background
+	mailed := func(count int) bool {
+		return assert.Eventually(func() bool { return len(smtpServer.Messages()) >= count }, 5*time.Second,
+			10*time.Millisecond)
+	}
+	request("/auth/email", `{"email":"unknown@buzkaaclicker.pl"}`, fiber.StatusAccepted)
+	request("/auth/email", `{"email":"user@buzkaaclicker.pl"}`, fiber.StatusAccepted)
+	if !mailed(1) {
+		return
+	}
+	messages := smtpServer.Messages()
+	if !assert.Len(messages, 1, "unknown emails get no mail") {
+		return
+	}
+	assert.Equal([]string{"user@buzkaaclicker.pl"}, messages[0].To)
+	match := regexp.MustCompile(`\?token=(\S+)`).FindStringSubmatch(messages[0].Body())
+	if !assert.Len(match, 2) {
+		return
+	}
+	token, err := url.QueryUnescape(match[1])
+	if !assert.NoError(err) {
+		return
+	}
+
+	request("/auth/email/login", `{"token":"invalid"}`, fiber.StatusUnauthorized)
+	session := request("/auth/email/login", `{"token":"`+token+`"}`, fiber.StatusCreated)
+	assert.EqualValues(user.Id, session["userId"])
+	accessToken, _ := session["accessToken"].(string)
+	exists, err := sessionStore.Exists(accessToken)
+	if assert.NoError(err) {
+		assert.True(exists)
+	}
+	request("/auth/email/login", `{"token":"`+token+`"}`, fiber.StatusUnauthorized)
+
+	for i := 1; i < buzza.MagicLinkEmailLimit; i++ {
+		request("/auth/email", `{"email":"user@buzkaaclicker.pl"}`, fiber.StatusAccepted)
+	}
+	request("/auth/email", `{"email":"user@buzkaaclicker.pl"}`, fiber.StatusTooManyRequests)
+	mailed(buzza.MagicLinkEmailLimit)
+	assert.Len(smtpServer.Messages(), buzza.MagicLinkEmailLimit)
+
+	request("/auth/discord", `{"code":"21"}`, fiber.StatusConflict)
+}
@@ -0,0 +1,237 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Passkey management of the logged in user.
+// Passkey login endpoints are installed by AuthController.
+type PasskeyController struct {
+	Authenticator *buzza.PasskeyAuthenticator
+}
+
+func (c *PasskeyController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	app.Get("/passkeys", combineHandlers(requestAuthorizer, c.servePasskeys))
+	app.Post("/passkeys/options", combineHandlers(requestAuthorizer, c.serveRegistrationOptions))
+	app.Post("/passkeys", combineHandlers(requestAuthorizer, c.serveRegister))
+	app.Delete("/passkeys/:passkey_id", combineHandlers(requestAuthorizer, c.serveDelete))
+}
+
+type passkeyMeta struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastUsedAt int64  `json:"lastUsedAt,omitempty"`
+}
+
+func newPasskeyMeta(passkey buzza.Passkey) passkeyMeta {
+	meta := passkeyMeta{Id: passkey.Id, Name: passkey.Name, CreatedAt: passkey.CreatedAt.Unix()}
+	if !passkey.LastUsedAt.IsZero() {
+		meta.LastUsedAt = passkey.LastUsedAt.Unix()
+	}
+	return meta
+}
+
+func (c *PasskeyController) servePasskeys(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	passkeys, err := c.Authenticator.Store.ByUserId(ctx.Context(), user.Id)
+	if err != nil {
+		return fmt.Errorf("get passkeys: %w", err)
+	}
+	metas := make([]passkeyMeta, len(passkeys))
+	for i, passkey := range passkeys {
+		metas[i] = newPasskeyMeta(passkey)
+	}
+	return ctx.JSON(metas)
+}
+
+func (c *PasskeyController) serveRegistrationOptions(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	registration, err := c.Authenticator.BeginRegistration(ctx.Context(), user)
+	if err != nil {
+		return passkeyError(err)
+	}
+	rp := c.Authenticator.RelyingParty
+	return ctx.JSON(map[string]interface{}{
+		"state": registration.State,
+		"publicKey": map[string]interface{}{
+			"challenge": buzza.EncodeWebAuthnBase64(registration.Challenge),
+			"rp":        map[string]string{"id": rp.Id, "name": rp.Name},
+			"user": map[string]string{
+				"id":          buzza.EncodeWebAuthnBase64(registration.UserHandle),
+				"name":        registration.UserName,
+				"displayName": registration.UserName,
+			},
+			"pubKeyCredParams":   credentialParameters(),
+			"timeout":            buzza.DefaultPasskeyCeremonyTTL.Milliseconds(),
+			"excludeCredentials": credentialDescriptors(registration.ExcludeCredentialIds),
+			"authenticatorSelection": map[string]string{
+				"residentKey":      "preferred",
+				"userVerification": "preferred",
+			},
+			"attestation": "none",
+		},
+	})
+}
+
+func (c *PasskeyController) serveRegister(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	var body struct {
+		State      string         `json:"state"`
+		Name       string         `json:"name"`
+		Credential credentialBody `json:"credential"`
+	}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	creation, err := body.Credential.creation()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid credential")
+	}
+	passkey, err := c.Authenticator.FinishRegistration(ctx.Context(), user, body.State, body.Name, creation)
+	if err != nil {
+		return passkeyError(err)
+	}
+	requestLog(ctx).WithField("user_id", user.Id).Infoln("Passkey registered.")
+	return ctx.Status(fiber.StatusCreated).JSON(newPasskeyMeta(passkey))
+}
+
+func (c *PasskeyController) serveDelete(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	if err := c.Authenticator.Remove(ctx.Context(), user.Id, ctx.Params("passkey_id")); err != nil {
+		return passkeyError(err)
+	}
+	requestLog(ctx).WithField("user_id", user.Id).Infoln("Passkey removed.")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// PublicKeyCredential serialized by toJSON(), binary fields are base64url encoded.
+type credentialBody struct {
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJson    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+func (b credentialBody) creation() (buzza.CredentialCreation, error) {
+	if b.Type != "public-key" {
+		return buzza.CredentialCreation{}, errors.New("unexpected credential type")
+	}
+	var creation buzza.CredentialCreation
+	var err error
+	if creation.ClientDataJson, err = buzza.DecodeWebAuthnBase64(b.Response.ClientDataJson); err != nil {
+		return buzza.CredentialCreation{}, err
+	}
+	if creation.AttestationObject, err = buzza.DecodeWebAuthnBase64(b.Response.AttestationObject); err != nil {
+		return buzza.CredentialCreation{}, err
+	}
+	return creation, nil
+}
+
+func (b credentialBody) assertion() (buzza.CredentialAssertion, error) {
+	if b.Type != "public-key" {
+		return buzza.CredentialAssertion{}, errors.New("unexpected credential type")
+	}
+	var assertion buzza.CredentialAssertion
+	var err error
+	for _, field := range []struct {
+		value  string
+		target *[]byte
+	}{
+		{b.Id, &assertion.CredentialId},
+		{b.Response.ClientDataJson, &assertion.ClientDataJson},
+		{b.Response.AuthenticatorData, &assertion.AuthenticatorData},
+		{b.Response.Signature, &assertion.Signature},
+		{b.Response.UserHandle, &assertion.UserHandle},
+	} {
+		if *field.target, err = buzza.DecodeWebAuthnBase64(field.value); err != nil {
+			return buzza.CredentialAssertion{}, err
+		}
+	}
+	return assertion, nil
+}
+
+func credentialParameters() []map[string]interface{} {
+	parameters := make([]map[string]interface{}, len(buzza.SupportedCoseAlgorithms))
+	for i, algorithm := range buzza.SupportedCoseAlgorithms {
+		parameters[i] = map[string]interface{}{"type": "public-key", "alg": algorithm}
+	}
+	return parameters
+}
+
+func credentialDescriptors(ids []string) []map[string]string {
+	descriptors := make([]map[string]string, len(ids))
+	for i, id := range ids {
+		descriptors[i] = map[string]string{"type": "public-key", "id": id}
+	}
+	return descriptors
+}
+
+// Options of navigator.credentials.get() with the ceremony state.
+func newPasskeyLoginBody(rp buzza.WebAuthnRelyingParty, login buzza.PasskeyLogin) map[string]interface{} {
+	userVerification := "discouraged"
+	if login.UserVerification {
+		userVerification = "required"
+	}
+	return map[string]interface{}{
+		"state": login.State,
+		"publicKey": map[string]interface{}{
+			"challenge":        buzza.EncodeWebAuthnBase64(login.Challenge),
+			"rpId":             rp.Id,
+			"timeout":          buzza.DefaultPasskeyCeremonyTTL.Milliseconds(),
+			"allowCredentials": credentialDescriptors(login.AllowCredentialIds),
+			"userVerification": userVerification,
+		},
+	}
+}
+
+func passkeyError(err error) error {
+	switch {
+	case errors.Is(err, buzza.ErrInvalidWebAuthnResponse):
+		return fiber.NewError(fiber.StatusBadRequest, "invalid passkey response")
+	case errors.Is(err, buzza.ErrInvalidPasskeyCeremony):
+		return fiber.NewError(fiber.StatusBadRequest, "invalid passkey state")
+	case errors.Is(err, buzza.ErrPasskeyNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "passkey not found")
+	case errors.Is(err, buzza.ErrPasskeyAlreadyRegistered):
+		return fiber.NewError(fiber.StatusConflict, "passkey already registered")
+	case errors.Is(err, buzza.ErrTooManyPasskeys):
+		return fiber.NewError(fiber.StatusConflict, "too many passkeys")
+	default:
+		return err
+	}
+}
+
+// Failed passkey logins do not tell why, so they can not be used to probe credentials.
+func passkeyLoginError(err error) error {
+	switch {
+	case errors.Is(err, buzza.ErrInvalidWebAuthnResponse),
+		errors.Is(err, buzza.ErrInvalidPasskeyCeremony),
+		errors.Is(err, buzza.ErrPasskeyNotFound),
+		errors.Is(err, buzza.ErrPasskeyReplayed):
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid passkey")
+	default:
+		return err
+	}
+}
@@ -0,0 +1,171 @@
+package rest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/discord"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/buzkaaclicker/buzza/webauthntest"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type passkeyOptionsResponse struct {
+	State     string `json:"state"`
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		RpId      string `json:"rpId"`
+		User      struct {
+			Id string `json:"id"`
+		} `json:"user"`
+		AllowCredentials []struct {
+			Id string `json:"id"`
+		} `json:"allowCredentials"`
+		ExcludeCredentials []struct {
+			Id string `json:"id"`
+		} `json:"excludeCredentials"`
+		UserVerification string `json:"userVerification"`
+	} `json:"publicKey"`
+}
+
+func TestPasskeyFlow(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	const origin = "https://buzkaaclicker.pl"
+	userStore := inmem.NewUserStore()
+	activityStore := inmem.NewActivityStore()
+	sessionStore := inmem.NewSessionStore(&activityStore)
+	twoFactorStore := inmem.NewTwoFactorStore()
+	passkeyStore := inmem.NewPasskeyStore()
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	passkeys := &buzza.PasskeyAuthenticator{
+		RelyingParty:  buzza.WebAuthnRelyingParty{Id: "buzkaaclicker.pl", Name: "BuzkaaClicker", Origins: []string{origin}},
+		Store:         &passkeyStore,
+		Challenges:    &inmem.PasskeyChallengeStore{},
+		ActivityStore: &activityStore,
+		Secret:        secret,
+	}
+
+	discordUser := discord.User{Id: "2137", Username: "user", Email: "user@buzkaaclicker.pl"}
+	user, _, err := userStore.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderDiscord, Subject: discordUser.Id, Email: buzza.Email(discordUser.Email)})
+	if !assert.NoError(err) {
+		return
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	authController := AuthController{
+		Providers:    testDiscordProviders(discordUser),
+		SessionStore: &sessionStore,
+		UserStore:    &userStore,
+		TwoFactor: &buzza.TwoFactorAuthenticator{
+			Store:         &twoFactorStore,
+			ActivityStore: &activityStore,
+			Issuer:        "BuzkaaClicker",
+		},
+		TwoFactorChallengeSecret: secret,
+		Passkeys:                 passkeys,
+	}
+	authController.InstallTo(app)
+	passkeyController := PasskeyController{Authenticator: passkeys}
+	passkeyController.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, user)
+		return nil
+	}, app)
+
+	request := func(method string, url string, body string, statusCode int, response interface{}) {
+		testJsonRequest(t, app, method, url, body, nil, statusCode, response)
+	}
+	decode := func(value string) []byte {
+		decoded, err := buzza.DecodeWebAuthnBase64(value)
+		assert.NoError(err)
+		return decoded
+	}
+
+	// registration
+	device := webauthntest.NewAuthenticator(origin)
+	var options passkeyOptionsResponse
+	request("POST", "/passkeys/options", "", fiber.StatusOK, &options)
+	attestation, err := device.Create("buzkaaclicker.pl", decode(options.PublicKey.Challenge),
+		decode(options.PublicKey.User.Id))
+	if !assert.NoError(err) {
+		return
+	}
+	request("POST", "/passkeys", `{"state":"`+options.State+`","name":"Phone","credential":{"type":"public-key"}}`,
+		fiber.StatusBadRequest, nil)
+	var registered passkeyMeta
+	request("POST", "/passkeys", `{"state":"`+options.State+`","name":"Phone","credential":`+attestation.Json()+`}`,
+		fiber.StatusCreated, &registered)
+	assert.Equal("Phone", registered.Name)
+	var listed []passkeyMeta
+	request("GET", "/passkeys", "", fiber.StatusOK, &listed)
+	assert.Equal([]passkeyMeta{registered}, listed)
+	request("POST", "/passkeys/options", "", fiber.StatusOK, &options)
+	if assert.Len(options.PublicKey.ExcludeCredentials, 1) {
+		assert.Equal(registered.Id, options.PublicKey.ExcludeCredentials[0].Id)
+	}
+
+	// passwordless login
+	request("POST", "/auth/passkey/options", "", fiber.StatusOK, &options)
+	assert.Equal("required", options.PublicKey.UserVerification)
+	assert.Equal("buzkaaclicker.pl", options.PublicKey.RpId)
+	assertion, err := device.Get("buzkaaclicker.pl", decode(options.PublicKey.Challenge), nil)
+	if !assert.NoError(err) {
+		return
+	}
+	var session struct {
+		AccessToken string       `json:"accessToken"`
+		UserId      buzza.UserId `json:"userId"`
+	}
+	request("POST", "/auth/passkey", `{"state":"`+options.State+`","credential":`+assertion.Json()+`}`,
+		fiber.StatusCreated, &session)
+	assert.Equal(user.Id, session.UserId)
+	exists, err := sessionStore.Exists(session.AccessToken)
+	if assert.NoError(err) {
+		assert.True(exists)
+	}
+	request("POST", "/auth/passkey", `{"state":"`+options.State+`","credential":`+assertion.Json()+`}`,
+		fiber.StatusUnauthorized, nil)
+
+	// discord login asks for the passkey as a second factor
+	var challenge struct {
+		Challenge string   `json:"challenge"`
+		Methods   []string `json:"methods"`
+	}
+	request("POST", "/auth/discord", `{"code":"21"}`, fiber.StatusOK, &challenge)
+	assert.Equal([]string{"passkey"}, challenge.Methods)
+	request("POST", "/auth/2fa/enrol", `{"challenge":"`+challenge.Challenge+`"}`, fiber.StatusForbidden, nil)
+	request("POST", "/auth/2fa/passkey/options", `{"challenge":"`+challenge.Challenge+`"}`, fiber.StatusOK, &options)
+	if !assert.Len(options.PublicKey.AllowCredentials, 1) {
+		return
+	}
+	assertion, err = device.Get("buzkaaclicker.pl", decode(options.PublicKey.Challenge),
+		[][]byte{decode(options.PublicKey.AllowCredentials[0].Id)})
+	if !assert.NoError(err) {
+		return
+	}
+	request("POST", "/auth/2fa/passkey", `{"challenge":"forged.challenge","state":"`+options.State+
+		`","credential":`+assertion.Json()+`}`, fiber.StatusUnauthorized, nil)
+	session.AccessToken = ""
+	request("POST", "/auth/2fa/passkey", `{"challenge":"`+challenge.Challenge+`","state":"`+options.State+
+		`","credential":`+assertion.Json()+`}`, fiber.StatusCreated, &session)
+	assert.NotEmpty(session.AccessToken)
+
+	request("DELETE", "/passkeys/"+registered.Id, "", fiber.StatusNoContent, nil)
+	request("DELETE", "/passkeys/"+registered.Id, "", fiber.StatusNotFound, nil)
+	request("GET", "/passkeys", "", fiber.StatusOK, &listed)
+	assert.Empty(listed)
+
+	logs, err := activityStore.ByUserId(ctx, user.Id, buzza.ActivityQuery{Limit: 100})
+	if assert.NoError(err) {
+		names := map[string]bool{}
+		for _, log := range logs {
+			names[log.Name] = true
+		}
+		assert.True(names[buzza.ActivityPasskeyAdded])
+		assert.True(names[buzza.ActivityPasskeyRemoved])
+	}
+}
@@ -0,0 +1,97 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReferralController struct {
+	Store buzza.ReferralStore
+}
+
+func (c *ReferralController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	app.Get("/referrals", combineHandlers(requestAuthorizer, c.serveReferrals))
+	app.Post("/admin/referrals/purchases", combineHandlers(requestAuthorizer,
+		requirePermissions(buzza.PermissionAdminDashboard), c.serveRegisterPurchase))
+}
+
+func (c *ReferralController) serveReferrals(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+
+	code, err := c.Store.CodeByUserId(ctx.Context(), user.Id)
+	if err != nil {
+		return fmt.Errorf("get referral code: %w", err)
+	}
+	referrals, err := c.Store.ByReferrerId(ctx.Context(), user.Id)
+	if err != nil {
+		return fmt.Errorf("get referrals: %w", err)
+	}
+	rewards, err := c.Store.RewardsByUserId(ctx.Context(), user.Id)
+	if err != nil {
+		return fmt.Errorf("get referral rewards: %w", err)
+	}
+
+	// referee ids are not exposed, referrer should only know how many people they invited.
+	type Referral struct {
+		CreatedAt int64 `json:"createdAt"`
+		Purchased bool  `json:"purchased"`
+	}
+	type Reward struct {
+		Role      buzza.RoleId `json:"role"`
+		ExpiresAt int64        `json:"expiresAt"`
+	}
+	type Response struct {
+		Code      buzza.ReferralCode `json:"code"`
+		Referrals []Referral         `json:"referrals"`
+		Rewards   []Reward           `json:"rewards"`
+	}
+	response := Response{
+		Code:      code,
+		Referrals: make([]Referral, len(referrals)),
+		Rewards:   make([]Reward, len(rewards)),
+	}
+	for i, r := range referrals {
+		response.Referrals[i] = Referral{CreatedAt: r.CreatedAt.Unix(), Purchased: !r.PurchasedAt.IsZero()}
+	}
+	for i, r := range rewards {
+		response.Rewards[i] = Reward{Role: r.RoleId, ExpiresAt: r.ExpiresAt.Unix()}
+	}
+	return ctx.JSON(response)
+}
+
+// Called by the payment integration after a successful purchase.
+func (c *ReferralController) serveRegisterPurchase(ctx *fiber.Ctx) error {
+	body := struct {
+		UserId buzza.UserId `json:"userId"`
+	}{}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	if body.UserId <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid user id")
+	}
+
+	reward, err := c.Store.RegisterPurchase(ctx.Context(), body.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, buzza.ErrReferralNotFound):
+			return fiber.NewError(fiber.StatusNotFound, "referral not found")
+		case errors.Is(err, buzza.ErrReferralAlreadyRewarded):
+			return fiber.NewError(fiber.StatusConflict, "referral already rewarded")
+		default:
+			return fmt.Errorf("register purchase: %w", err)
+		}
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(map[string]interface{}{
+		"userId":    reward.UserId,
+		"role":      reward.RoleId,
+		"expiresAt": reward.ExpiresAt.Unix(),
+	})
+}
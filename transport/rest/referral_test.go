@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferralController(t *testing.T) {
+	assert := assert.New(t)
+
+	store := mock.ReferralStore{
+		CodeByUserIdFn: func(ctx context.Context, userId buzza.UserId) (buzza.ReferralCode, error) {
+			return "ABCD2345", nil
+		},
+		ByReferrerIdFn: func(ctx context.Context, referrerId buzza.UserId) ([]buzza.Referral, error) {
+			return []buzza.Referral{
+				{Id: 2, ReferrerId: referrerId, RefereeId: 31,
+					CreatedAt:   time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC),
+					PurchasedAt: time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC)},
+				{Id: 1, ReferrerId: referrerId, RefereeId: 30,
+					CreatedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+			}, nil
+		},
+		RewardsByUserIdFn: func(ctx context.Context, userId buzza.UserId) ([]buzza.ReferralReward, error) {
+			return []buzza.ReferralReward{
+				{Id: 1, UserId: userId, ReferralId: 2, RoleId: buzza.RoleIdPro,
+					ExpiresAt: time.Date(2022, 1, 10, 0, 0, 0, 0, time.UTC)},
+			}, nil
+		},
+		RegisterPurchaseFn: func(ctx context.Context, refereeId buzza.UserId) (buzza.ReferralReward, error) {
+			if refereeId == 31 {
+				return buzza.ReferralReward{}, buzza.ErrReferralAlreadyRewarded
+			}
+			if refereeId != 30 {
+				return buzza.ReferralReward{}, buzza.ErrReferralNotFound
+			}
+			return buzza.ReferralReward{Id: 2, UserId: 22, ReferralId: 1, RoleId: buzza.RoleIdPro,
+				ExpiresAt: time.Date(2022, 1, 17, 0, 0, 0, 0, time.UTC)}, nil
+		},
+	}
+
+	var currentUser buzza.User
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := ReferralController{Store: store}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, currentUser)
+		return nil
+	}, app)
+
+	admin := buzza.User{Id: 1, Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}}
+	cases := []struct {
+		user       buzza.User
+		method     string
+		url        string
+		body       string
+		statusCode int
+		response   string
+	}{
+		{user: buzza.User{Id: 22}, method: "GET", url: "/referrals", statusCode: fiber.StatusOK,
+			response: `{"code":"ABCD2345",` +
+				`"referrals":[{"createdAt":1641081600,"purchased":true},{"createdAt":1640995200,"purchased":false}],` +
+				`"rewards":[{"role":"pro","expiresAt":1641772800}]}`},
+		{user: buzza.User{Id: 22}, method: "POST", url: "/admin/referrals/purchases", body: `{"userId":30}`,
+			statusCode: fiber.StatusUnauthorized, response: JsonErrorMessageResponse(fiber.ErrUnauthorized.Message)},
+		{user: admin, method: "POST", url: "/admin/referrals/purchases", body: `{"userId":30}`,
+			statusCode: fiber.StatusCreated, response: `{"expiresAt":1642377600,"role":"pro","userId":22}`},
+		{user: admin, method: "POST", url: "/admin/referrals/purchases", body: `{"userId":31}`,
+			statusCode: fiber.StatusConflict, response: JsonErrorMessageResponse("referral already rewarded")},
+		{user: admin, method: "POST", url: "/admin/referrals/purchases", body: `{"userId":32}`,
+			statusCode: fiber.StatusNotFound, response: JsonErrorMessageResponse("referral not found")},
+		{user: admin, method: "POST", url: "/admin/referrals/purchases", body: `{}`,
+			statusCode: fiber.StatusBadRequest, response: JsonErrorMessageResponse("invalid user id")},
+	}
+	for _, tc := range cases {
+		currentUser = tc.user
+		req := httptest.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, tc.url)
+		assert.Equal(tc.response, string(body), tc.url)
+	}
+}
@@ -2,6 +2,7 @@ package rest
 
 import (
 	"encoding/json"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/sirupsen/logrus"
@@ -57,3 +58,24 @@ func JsonErrorMessageResponse(message interface{}) string {
 	}
 	return string(bytes)
 }
+
+// Parse "page" (starting from 1) and "limit" query params into offset and limit.
+func paginationQuery(ctx *fiber.Ctx, defaultLimit int, maxLimit int) (int, int, error) {
+	page := 1
+	if raw := ctx.Query("page"); raw != "" {
+		var err error
+		page, err = strconv.Atoi(raw)
+		if err != nil || page < 1 {
+			return 0, 0, fiber.NewError(fiber.StatusBadRequest, "invalid page")
+		}
+	}
+	limit := defaultLimit
+	if raw := ctx.Query("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return 0, 0, fiber.NewError(fiber.StatusBadRequest, "invalid limit")
+		}
+	}
+	return (page - 1) * limit, limit, nil
+}
@@ -1,8 +1,11 @@
 package rest
 
 import (
+	"encoding/json"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
@@ -45,3 +48,38 @@ func TestNotFoundHandler(t *testing.T) {
 		assert.Equal(useCase.returnBody, string(body), assertMsg)
 	}
 }
+
+// Sends request with optional JSON body to the app, response body is read and closed.
+func testRequest(t *testing.T, app *fiber.App, method string, url string, body string,
+	header map[string]string) (*http.Response, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, respBody
+}
+
+// Asserts status code of the response and decodes its JSON body into response, if not nil.
+func testJsonRequest(t *testing.T, app *fiber.App, method string, url string, body string,
+	header map[string]string, statusCode int, response interface{}) {
+	t.Helper()
+	resp, respBody := testRequest(t, app, method, url, body, header)
+	assert.Equal(t, statusCode, resp.StatusCode, method+" "+url+": "+string(respBody))
+	isJson := strings.HasPrefix(resp.Header.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON)
+	if response != nil && isJson {
+		assert.NoError(t, json.Unmarshal(respBody, response), method+" "+url)
+	}
+}
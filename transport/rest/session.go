@@ -4,14 +4,20 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/buzkaaclicker/buzza"
 	"github.com/gofiber/fiber/v2"
-	"github.com/tidwall/buntdb"
 )
 
-const sessionLocalsKey = "session"
+const (
+	sessionLocalsKey = "session"
+	// Set if the user is required to use two-factor authentication, but the session did not pass it.
+	secondFactorMissingLocalsKey = "secondFactorMissing"
+)
 
 type SessionController struct {
 	Store buzza.SessionStore
@@ -19,6 +25,7 @@ type SessionController struct {
 
 func (c *SessionController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
 	app.Get("/session", combineHandlers(requestAuthorizer, c.serveCurrentSession))
+	app.Patch("/session/:session_id", combineHandlers(requestAuthorizer, c.serveUpdateSession))
 	app.Delete("/session/:session_id", combineHandlers(requestAuthorizer, c.serveDeleteSession))
 	app.Get("/sessions", combineHandlers(requestAuthorizer, c.serveSessions))
 	app.Delete("/sessions/other", combineHandlers(requestAuthorizer, c.serveDeleteOtherSessions))
@@ -29,7 +36,8 @@ func (c *SessionController) serveCurrentSession(ctx *fiber.Ctx) error {
 	if !ok {
 		return fiber.ErrUnauthorized
 	}
-	return ctx.JSON(session)
+	// token is known to the client already and must not leak through responses
+	return ctx.JSON(newSessionMeta(session, true))
 }
 
 func (c *SessionController) serveSessions(ctx *fiber.Ctx) error {
@@ -47,26 +55,144 @@ func (c *SessionController) serveSessions(ctx *fiber.Ctx) error {
 		}
 	}
 
-	// return information about session without providing access
-	// to the authorization token.
-	type SessionMeta struct {
-		Id             string `json:"id"`
-		Ip             string `json:"ip"`
-		UserAgent      string `json:"userAgent"`
-		LastAccessedAt int64  `json:"lastAccessedAt"`
-	}
+	// pinned sessions first
+	sort.SliceStable(activeSessions, func(i, j int) bool {
+		return activeSessions[i].Pinned && !activeSessions[j].Pinned
+	})
 	publicInfos := make([]SessionMeta, len(activeSessions))
-	for i, session := range activeSessions {
-		publicInfos[i] = SessionMeta{
-			Id:             session.Id,
-			Ip:             session.Ip,
-			UserAgent:      session.UserAgent,
-			LastAccessedAt: session.LastAccessedAt.Unix(),
-		}
+	for i, activeSession := range activeSessions {
+		publicInfos[i] = newSessionMeta(activeSession, activeSession.Id == session.Id)
 	}
 	return ctx.JSON(publicInfos)
 }
 
+// Information about session without providing access to the authorization token.
+type SessionMeta struct {
+	Id             string           `json:"id"`
+	Name           string           `json:"name,omitempty"`
+	Pinned         bool             `json:"pinned"`
+	Trusted        bool             `json:"trusted"`
+	Current        bool             `json:"current"`
+	Ip             string           `json:"ip"`
+	UserAgent      string           `json:"userAgent"`
+	Agent          sessionAgent     `json:"agent"`
+	Location       *sessionLocation `json:"location,omitempty"`
+	CreatedAt      int64            `json:"createdAt"`
+	LastAccessedAt int64            `json:"lastAccessedAt"`
+	ExpiresAt      int64            `json:"expiresAt"`
+}
+
+func newSessionMeta(session buzza.Session, current bool) SessionMeta {
+	var createdAt int64
+	// unknown for sessions created before it was stored
+	if !session.CreatedAt.IsZero() {
+		createdAt = session.CreatedAt.Unix()
+	}
+	return SessionMeta{
+		Id:             session.Id,
+		Name:           session.Name,
+		Pinned:         session.Pinned,
+		Trusted:        session.Trusted,
+		Current:        current,
+		Ip:             session.Ip,
+		UserAgent:      session.UserAgent,
+		Agent:          newSessionAgent(session.Agent),
+		Location:       newSessionLocation(session.Location),
+		CreatedAt:      createdAt,
+		LastAccessedAt: session.LastAccessedAt.Unix(),
+		ExpiresAt:      session.ExpiresAt.Unix(),
+	}
+}
+
+type sessionAgent struct {
+	Client        string `json:"client,omitempty"`
+	ClientVersion string `json:"clientVersion,omitempty"`
+	Branch        string `json:"branch,omitempty"`
+	OS            string `json:"os,omitempty"`
+	OSVersion     string `json:"osVersion,omitempty"`
+	Device        string `json:"device,omitempty"`
+	// e.g. "Chrome on Windows"
+	Description string `json:"description,omitempty"`
+}
+
+func newSessionAgent(agent buzza.UserAgentInfo) sessionAgent {
+	return sessionAgent{
+		Client:        agent.Client,
+		ClientVersion: agent.ClientVersion,
+		Branch:        agent.Branch,
+		OS:            agent.OS,
+		OSVersion:     agent.OSVersion,
+		Device:        string(agent.Device),
+		Description:   agent.String(),
+	}
+}
+
+type sessionLocation struct {
+	CountryCode     string `json:"countryCode,omitempty"`
+	Country         string `json:"country,omitempty"`
+	City            string `json:"city,omitempty"`
+	Asn             uint32 `json:"asn,omitempty"`
+	AsnOrganization string `json:"asnOrganization,omitempty"`
+}
+
+func newSessionLocation(location buzza.GeoLocation) *sessionLocation {
+	if location.IsZero() {
+		return nil
+	}
+	return &sessionLocation{
+		CountryCode:     location.CountryCode,
+		Country:         location.Country,
+		City:            location.City,
+		Asn:             location.Asn,
+		AsnOrganization: location.AsnOrganization,
+	}
+}
+
+// Rename, pin or trust the session. Omitted fields are left unchanged, empty name removes it.
+// Sessions can not trust themselves, otherwise a stolen token could extend its own lifetime and silence login alerts.
+func (c *SessionController) serveUpdateSession(ctx *fiber.Ctx) error {
+	session, ok := ctx.Locals(sessionLocalsKey).(buzza.Session)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	sessionId, err := url.PathUnescape(ctx.Params("session_id"))
+	if err != nil || sessionId == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid session id")
+	}
+	body := struct {
+		Name    *string `json:"name"`
+		Pinned  *bool   `json:"pinned"`
+		Trusted *bool   `json:"trusted"`
+	}{}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	if body.Name != nil {
+		name := strings.TrimSpace(*body.Name)
+		if utf8.RuneCountInString(name) > buzza.MaxSessionNameLength || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid name")
+		}
+		body.Name = &name
+	}
+	if body.Trusted != nil && *body.Trusted && sessionId == session.Id {
+		return fiber.NewError(fiber.StatusForbidden, "session can not trust itself")
+	}
+
+	updated, err := c.Store.UpdateById(ctx.Context(), session.UserId, sessionId, buzza.SessionUpdate{
+		Name:    body.Name,
+		Pinned:  body.Pinned,
+		Trusted: body.Trusted,
+	})
+	if err != nil {
+		if errors.Is(err, buzza.ErrSessionNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "session not found")
+		} else {
+			return fmt.Errorf("update session: %w", err)
+		}
+	}
+	return ctx.JSON(newSessionMeta(updated, updated.Id == session.Id))
+}
+
 func (c *SessionController) serveDeleteSession(ctx *fiber.Ctx) error {
 	encodedSessionId := ctx.Params("session_id")
 	if encodedSessionId == "" {
@@ -88,7 +214,7 @@ func (c *SessionController) serveDeleteSession(ctx *fiber.Ctx) error {
 		err = c.Store.InvalidateById(session.UserId, decodedSessionId)
 	}
 	if err != nil {
-		if errors.Is(err, buntdb.ErrNotFound) {
+		if errors.Is(err, buzza.ErrSessionNotFound) {
 			return fiber.ErrForbidden
 		} else {
 			return fmt.Errorf("session invalidate: %s", err)
@@ -104,7 +230,11 @@ func (c *SessionController) serveDeleteOtherSessions(ctx *fiber.Ctx) error {
 	}
 	return c.Store.InvalidateAllExpect(session.Token)
 }
-func RequestAuthorizer(sessionStore buzza.SessionStore, userStore buzza.UserStore) fiber.Handler {
+
+// Second factor of sessions of users required to use it is checked with optional secondFactors,
+// sessions that did not pass it are denied admin permissions.
+func RequestAuthorizer(sessionStore buzza.SessionStore, userStore buzza.UserStore,
+	secondFactors *buzza.SecondFactorVerifier) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		auth := ctx.Get(fiber.HeaderAuthorization)
 		if auth == "" {
@@ -128,6 +258,13 @@ func RequestAuthorizer(sessionStore buzza.SessionStore, userStore buzza.UserStor
 		if err != nil {
 			return fmt.Errorf("retrieve user by id: %s", err)
 		}
+		if secondFactors != nil && buzza.TwoFactorRequired(user.Roles) {
+			verified, err := secondFactors.SessionVerified(ctx.Context(), session)
+			if err != nil {
+				return fmt.Errorf("verify session second factor: %w", err)
+			}
+			ctx.Locals(secondFactorMissingLocalsKey, !verified)
+		}
 
 		requestLog(ctx).
 			WithField("user_id", user.Id).
@@ -138,3 +275,14 @@ func RequestAuthorizer(sessionStore buzza.SessionStore, userStore buzza.UserStor
 		return nil
 	}
 }
+
+// Authorize request only if it carries the authorization header, so the endpoint
+// stays available for anonymous clients.
+func optionalAuthorization(requestAuthorizer fiber.Handler) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if ctx.Get(fiber.HeaderAuthorization) == "" {
+			return nil
+		}
+		return requestAuthorizer(ctx)
+	}
+}
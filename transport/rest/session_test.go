@@ -0,0 +1,118 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionControllerUpdate(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	activityStore := inmem.NewActivityStore()
+	activityStore.Catalogue = buzza.DefaultActivityCatalogue
+	sessionStore := inmem.NewSessionStore(&activityStore)
+	current, err := sessionStore.RegisterNew(ctx, 5, "1.1.1.1", "BuzkaaClicker/1.4.2 (stable; windows; amd64)")
+	if !assert.NoError(err) {
+		return
+	}
+	other, err := sessionStore.RegisterNew(ctx, 5, "2.2.2.2", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := SessionController{Store: &sessionStore}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(sessionLocalsKey, current)
+		return nil
+	}, app)
+
+	request := func(method string, url string, body string) (int, []byte) {
+		resp, respBody := testRequest(t, app, method, url, body, nil)
+		return resp.StatusCode, respBody
+	}
+
+	status, body := request("GET", "/session", "")
+	if assert.Equal(fiber.StatusOK, status, string(body)) {
+		assert.NotContains(string(body), current.Token, "token should not be returned")
+		var meta SessionMeta
+		if assert.NoError(json.Unmarshal(body, &meta)) {
+			assert.Equal(current.Id, meta.Id)
+			assert.True(meta.Current)
+		}
+	}
+
+	status, body = request("PATCH", "/session/"+other.Id, `{"name":"  Laptop  ","pinned":true,"trusted":true}`)
+	if assert.Equal(fiber.StatusOK, status, string(body)) {
+		var meta SessionMeta
+		if assert.NoError(json.Unmarshal(body, &meta)) {
+			assert.Equal("Laptop", meta.Name)
+			assert.True(meta.Pinned)
+			assert.True(meta.Trusted)
+			assert.False(meta.Current)
+			assert.True(time.Unix(meta.ExpiresAt, 0).After(time.Now().Add(60*24*time.Hour)),
+				"trusted session should live longer")
+		}
+	}
+	// omitted fields are kept
+	status, body = request("PATCH", "/session/"+other.Id, `{"pinned":false}`)
+	if assert.Equal(fiber.StatusOK, status, string(body)) {
+		var meta SessionMeta
+		if assert.NoError(json.Unmarshal(body, &meta)) {
+			assert.Equal("Laptop", meta.Name)
+			assert.False(meta.Pinned)
+			assert.True(meta.Trusted)
+		}
+	}
+	status, _ = request("PATCH", "/session/"+current.Id, `{"name":"Home PC","pinned":true}`)
+	assert.Equal(fiber.StatusOK, status)
+	status, _ = request("PATCH", "/session/"+current.Id, `{"trusted":true}`)
+	assert.Equal(fiber.StatusForbidden, status, "session can not trust itself")
+
+	status, body = request("GET", "/sessions", "")
+	if assert.Equal(fiber.StatusOK, status) {
+		var sessions []SessionMeta
+		if assert.NoError(json.Unmarshal(body, &sessions)) && assert.Len(sessions, 2) {
+			assert.Equal(current.Id, sessions[0].Id, "pinned session should be listed first")
+			assert.True(sessions[0].Current)
+			assert.Equal("Home PC", sessions[0].Name)
+			assert.Equal("BuzkaaClicker 1.4.2 (stable) on Windows", sessions[0].Agent.Description)
+			assert.Equal(current.CreatedAt.Unix(), sessions[0].CreatedAt)
+		}
+	}
+
+	invalid := []string{
+		`{"name":"` + strings.Repeat("a", buzza.MaxSessionNameLength+1) + `"}`,
+		`{"name":"new\nline"}`,
+		`{"pinned":"yes"}`,
+	}
+	for _, body := range invalid {
+		status, _ := request("PATCH", "/session/"+other.Id, body)
+		assert.Equal(fiber.StatusBadRequest, status, body)
+	}
+
+	foreign, err := sessionStore.RegisterNew(ctx, 6, "3.3.3.3", "Chrome")
+	if !assert.NoError(err) {
+		return
+	}
+	status, _ = request("PATCH", "/session/"+foreign.Id, `{"name":"mine"}`)
+	assert.Equal(fiber.StatusNotFound, status)
+	status, _ = request("PATCH", "/session/unknown", `{"name":"mine"}`)
+	assert.Equal(fiber.StatusNotFound, status)
+
+	logs, err := activityStore.ByUserId(ctx, 5, buzza.ActivityQuery{
+		Names: []string{buzza.ActivitySessionTrusted, buzza.ActivitySessionUntrusted}, Limit: 10})
+	if assert.NoError(err) && assert.Len(logs, 1) {
+		assert.Equal(buzza.ActivitySessionTrusted, logs[0].Name)
+		assert.Equal(other.Id, logs[0].Data["session_id"])
+	}
+}
@@ -0,0 +1,279 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Community configs published from the user synchronized configs.
+type SharedConfigController struct {
+	Store              buzza.SharedConfigStore
+	ClickerConfigStore buzza.ClickerConfigStore
+}
+
+func (c *SharedConfigController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	app.Get("/shared-configs", c.serveSearch)
+	app.Post("/shared-configs", combineHandlers(requestAuthorizer, c.servePublish))
+	app.Get("/shared-configs/:id", c.serveSharedConfig)
+	app.Get("/shared-configs/:id/download", c.serveDownload)
+	app.Put("/shared-configs/:id/rating", combineHandlers(requestAuthorizer, c.serveRate))
+	app.Post("/shared-configs/:id/reports", combineHandlers(requestAuthorizer, c.serveReport))
+
+	requireAdmin := requirePermissions(buzza.PermissionAdminDashboard)
+	app.Get("/admin/shared-configs/reports", combineHandlers(requestAuthorizer, requireAdmin, c.serveReports))
+	app.Delete("/admin/shared-configs/:id", combineHandlers(requestAuthorizer, requireAdmin, c.serveTakeDown))
+}
+
+type sharedConfigAuthor struct {
+	UserId    buzza.UserId `json:"userId"`
+	Name      string       `json:"name"`
+	AvatarUrl string       `json:"avatarUrl"`
+}
+
+type sharedConfigResponse struct {
+	Id            int64              `json:"id"`
+	Author        sharedConfigAuthor `json:"author"`
+	Title         string             `json:"title"`
+	Description   string             `json:"description"`
+	Tags          []string           `json:"tags"`
+	Downloads     int64              `json:"downloads"`
+	RatingCount   int64              `json:"ratingCount"`
+	RatingAverage float64            `json:"ratingAverage"`
+	CreatedAt     int64              `json:"createdAt"`
+	Data          json.RawMessage    `json:"data,omitempty"`
+}
+
+func newSharedConfigResponse(config buzza.SharedConfig, withData bool) sharedConfigResponse {
+	response := sharedConfigResponse{
+		Id: config.Id,
+		Author: sharedConfigAuthor{
+			UserId:    config.Author.User.Id,
+			Name:      config.Author.Name,
+			AvatarUrl: config.Author.AvatarUrl,
+		},
+		Title:         config.Title,
+		Description:   config.Description,
+		Tags:          config.Tags,
+		Downloads:     config.Downloads,
+		RatingCount:   config.RatingCount,
+		RatingAverage: config.RatingAverage,
+		CreatedAt:     config.CreatedAt.Unix(),
+	}
+	if withData {
+		response.Data = config.Data
+	}
+	return response
+}
+
+func (c *SharedConfigController) serveSearch(ctx *fiber.Ctx) error {
+	offset, limit, err := paginationQuery(ctx, 20, 50)
+	if err != nil {
+		return err
+	}
+	// query values are backed by the fasthttp buffer, so copy them
+	query := buzza.SharedConfigQuery{
+		Search: utils.CopyString(strings.TrimSpace(ctx.Query("q"))),
+		Sort:   buzza.SharedConfigSort(utils.CopyString(ctx.Query("sort", string(buzza.SharedConfigSortNewest)))),
+		Offset: offset,
+		Limit:  limit,
+	}
+	if !query.Sort.Valid() {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid sort")
+	}
+	if tags := ctx.Query("tags"); tags != "" {
+		query.Tags = strings.Split(utils.CopyString(tags), ",")
+	}
+
+	configs, total, err := c.Store.Search(ctx.Context(), query)
+	if err != nil {
+		return fmt.Errorf("search shared configs: %w", err)
+	}
+
+	mapped := make([]sharedConfigResponse, len(configs))
+	for i, config := range configs {
+		mapped[i] = newSharedConfigResponse(config, false)
+	}
+	return ctx.JSON(map[string]interface{}{
+		"total":   total,
+		"configs": mapped,
+	})
+}
+
+func (c *SharedConfigController) servePublish(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	body := struct {
+		ConfigName  string   `json:"configName"`
+		Title       string   `json:"title"`
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+	}{}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	meta := buzza.SharedConfigMeta{Title: body.Title, Description: body.Description, Tags: body.Tags}
+	if err := meta.Validate(); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	config, err := c.ClickerConfigStore.ByName(ctx.Context(), user.Id, body.ConfigName)
+	if err != nil {
+		if errors.Is(err, buzza.ErrClickerConfigNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "config not found")
+		} else {
+			return fmt.Errorf("get config by name: %w", err)
+		}
+	}
+
+	shared, err := c.Store.Publish(ctx.Context(), user.Id, meta, config.Data)
+	if err != nil {
+		return fmt.Errorf("publish config: %w", err)
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(newSharedConfigResponse(shared, false))
+}
+
+func (c *SharedConfigController) serveSharedConfig(ctx *fiber.Ctx) error {
+	id, err := sharedConfigIdParam(ctx)
+	if err != nil {
+		return err
+	}
+	config, err := c.Store.ById(ctx.Context(), id)
+	if err != nil {
+		return sharedConfigError(err)
+	}
+	return ctx.JSON(newSharedConfigResponse(config, false))
+}
+
+func (c *SharedConfigController) serveDownload(ctx *fiber.Ctx) error {
+	id, err := sharedConfigIdParam(ctx)
+	if err != nil {
+		return err
+	}
+	config, err := c.Store.Download(ctx.Context(), id)
+	if err != nil {
+		return sharedConfigError(err)
+	}
+	return ctx.JSON(newSharedConfigResponse(config, true))
+}
+
+func (c *SharedConfigController) serveRate(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	id, err := sharedConfigIdParam(ctx)
+	if err != nil {
+		return err
+	}
+	body := struct {
+		Rating int `json:"rating"`
+	}{}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	if body.Rating < buzza.MinSharedConfigRating || body.Rating > buzza.MaxSharedConfigRating {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid rating")
+	}
+
+	if err := c.Store.Rate(ctx.Context(), id, user.Id, body.Rating); err != nil {
+		return sharedConfigError(err)
+	}
+	return nil
+}
+
+func (c *SharedConfigController) serveReport(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	id, err := sharedConfigIdParam(ctx)
+	if err != nil {
+		return err
+	}
+	body := struct {
+		Reason string `json:"reason"`
+	}{}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	if body.Reason == "" || len(body.Reason) > 1000 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid reason")
+	}
+
+	if err := c.Store.Report(ctx.Context(), id, user.Id, body.Reason); err != nil {
+		return sharedConfigError(err)
+	}
+	return ctx.SendStatus(fiber.StatusCreated)
+}
+
+func (c *SharedConfigController) serveReports(ctx *fiber.Ctx) error {
+	offset, limit, err := paginationQuery(ctx, 50, 100)
+	if err != nil {
+		return err
+	}
+	reports, err := c.Store.Reports(ctx.Context(), offset, limit)
+	if err != nil {
+		return fmt.Errorf("get reports: %w", err)
+	}
+
+	type Report struct {
+		Id             int64        `json:"id"`
+		SharedConfigId int64        `json:"sharedConfigId"`
+		ReporterId     buzza.UserId `json:"reporterId"`
+		Reason         string       `json:"reason"`
+		CreatedAt      int64        `json:"createdAt"`
+	}
+	mapped := make([]Report, len(reports))
+	for i, r := range reports {
+		mapped[i] = Report{Id: r.Id, SharedConfigId: r.SharedConfigId, ReporterId: r.ReporterId,
+			Reason: r.Reason, CreatedAt: r.CreatedAt.Unix()}
+	}
+	return ctx.JSON(mapped)
+}
+
+func (c *SharedConfigController) serveTakeDown(ctx *fiber.Ctx) error {
+	id, err := sharedConfigIdParam(ctx)
+	if err != nil {
+		return err
+	}
+	body := struct {
+		Reason string `json:"reason"`
+	}{}
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+
+	if err := c.Store.TakeDown(ctx.Context(), id, body.Reason); err != nil {
+		return sharedConfigError(err)
+	}
+	requestLog(ctx).WithField("shared_config_id", id).Infoln("Shared config taken down.")
+	return nil
+}
+
+func sharedConfigIdParam(ctx *fiber.Ctx) (int64, error) {
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "invalid shared config id")
+	}
+	return id, nil
+}
+
+func sharedConfigError(err error) error {
+	switch {
+	case errors.Is(err, buzza.ErrSharedConfigNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "shared config not found")
+	case errors.Is(err, buzza.ErrSelfRating):
+		return fiber.NewError(fiber.StatusForbidden, "can not rate own config")
+	default:
+		return fmt.Errorf("shared config store: %w", err)
+	}
+}
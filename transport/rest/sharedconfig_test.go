@@ -0,0 +1,150 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/mock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedConfigController(t *testing.T) {
+	assert := assert.New(t)
+
+	shared := buzza.SharedConfig{
+		Id:            7,
+		Author:        buzza.Profile{User: buzza.User{Id: 3}, Name: "ww_makin_c", AvatarUrl: "https://buzkaaclicker.pl/avatar/3"},
+		Title:         "Butterfly",
+		Description:   "20 cps",
+		Tags:          []string{"pvp"},
+		Data:          json.RawMessage(`{"cps":20}`),
+		Downloads:     10,
+		RatingCount:   2,
+		RatingAverage: 4.5,
+		CreatedAt:     time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	var searchQuery buzza.SharedConfigQuery
+	var publishedMeta buzza.SharedConfigMeta
+	var takenDown int64
+	store := mock.SharedConfigStore{
+		SearchFn: func(ctx context.Context, query buzza.SharedConfigQuery) ([]buzza.SharedConfig, int, error) {
+			searchQuery = query
+			return []buzza.SharedConfig{shared}, 21, nil
+		},
+		ByIdFn: func(ctx context.Context, id int64) (buzza.SharedConfig, error) {
+			if id != shared.Id {
+				return buzza.SharedConfig{}, buzza.ErrSharedConfigNotFound
+			}
+			return shared, nil
+		},
+		DownloadFn: func(ctx context.Context, id int64) (buzza.SharedConfig, error) {
+			return shared, nil
+		},
+		PublishFn: func(ctx context.Context, authorId buzza.UserId, meta buzza.SharedConfigMeta,
+			data json.RawMessage) (buzza.SharedConfig, error) {
+			publishedMeta = meta
+			return buzza.SharedConfig{Id: 8, Author: buzza.Profile{User: buzza.User{Id: authorId}},
+				Title: meta.Title, Tags: meta.Tags, Data: data, CreatedAt: shared.CreatedAt}, nil
+		},
+		RateFn: func(ctx context.Context, id int64, userId buzza.UserId, rating int) error {
+			if userId == shared.Author.User.Id {
+				return buzza.ErrSelfRating
+			}
+			return nil
+		},
+		TakeDownFn: func(ctx context.Context, id int64, reason string) error {
+			takenDown = id
+			return nil
+		},
+	}
+	configStore := mock.ClickerConfigStore{
+		ByNameFn: func(ctx context.Context, userId buzza.UserId, name string) (buzza.ClickerConfig, error) {
+			if name != "pvp" {
+				return buzza.ClickerConfig{}, buzza.ErrClickerConfigNotFound
+			}
+			return buzza.ClickerConfig{UserId: userId, Name: name, Data: json.RawMessage(`{"cps":3}`)}, nil
+		},
+	}
+
+	var currentUser buzza.User
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := SharedConfigController{Store: store, ClickerConfigStore: configStore}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, currentUser)
+		return nil
+	}, app)
+
+	sharedJson := `{"id":7,"author":{"userId":3,"name":"ww_makin_c","avatarUrl":"https://buzkaaclicker.pl/avatar/3"},` +
+		`"title":"Butterfly","description":"20 cps","tags":["pvp"],"downloads":10,"ratingCount":2,` +
+		`"ratingAverage":4.5,"createdAt":1646092800`
+	admin := buzza.User{Id: 1, Roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}}
+	cases := []struct {
+		user       buzza.User
+		method     string
+		url        string
+		body       string
+		statusCode int
+		response   string
+	}{
+		{method: "GET", url: "/shared-configs?q=butter&tags=pvp,jitter&sort=rating&page=3&limit=10",
+			statusCode: fiber.StatusOK, response: `{"configs":[` + sharedJson + `}],"total":21}`},
+		{method: "GET", url: "/shared-configs?sort=oldest", statusCode: fiber.StatusBadRequest,
+			response: JsonErrorMessageResponse("invalid sort")},
+		{method: "GET", url: "/shared-configs?limit=1000", statusCode: fiber.StatusBadRequest,
+			response: JsonErrorMessageResponse("invalid limit")},
+		{method: "GET", url: "/shared-configs/7", statusCode: fiber.StatusOK, response: sharedJson + `}`},
+		{method: "GET", url: "/shared-configs/8", statusCode: fiber.StatusNotFound,
+			response: JsonErrorMessageResponse("shared config not found")},
+		{method: "GET", url: "/shared-configs/7/download", statusCode: fiber.StatusOK,
+			response: sharedJson + `,"data":{"cps":20}}`},
+		{user: buzza.User{Id: 5}, method: "POST", url: "/shared-configs",
+			body:       `{"configName":"pvp","title":"My config","tags":["pvp"]}`,
+			statusCode: fiber.StatusCreated,
+			response: `{"id":8,"author":{"userId":5,"name":"","avatarUrl":""},"title":"My config","description":"",` +
+				`"tags":["pvp"],"downloads":0,"ratingCount":0,"ratingAverage":0,"createdAt":1646092800}`},
+		{user: buzza.User{Id: 5}, method: "POST", url: "/shared-configs",
+			body: `{"configName":"other","title":"My config"}`, statusCode: fiber.StatusNotFound,
+			response: JsonErrorMessageResponse("config not found")},
+		{user: buzza.User{Id: 5}, method: "POST", url: "/shared-configs",
+			body: `{"configName":"pvp","title":"My config","tags":["P V P"]}`, statusCode: fiber.StatusBadRequest,
+			response: JsonErrorMessageResponse("invalid tag")},
+		{user: buzza.User{Id: 5}, method: "PUT", url: "/shared-configs/7/rating", body: `{"rating":5}`,
+			statusCode: fiber.StatusOK},
+		{user: buzza.User{Id: 5}, method: "PUT", url: "/shared-configs/7/rating", body: `{"rating":6}`,
+			statusCode: fiber.StatusBadRequest, response: JsonErrorMessageResponse("invalid rating")},
+		{user: buzza.User{Id: 3}, method: "PUT", url: "/shared-configs/7/rating", body: `{"rating":5}`,
+			statusCode: fiber.StatusForbidden, response: JsonErrorMessageResponse("can not rate own config")},
+		{user: buzza.User{Id: 5}, method: "DELETE", url: "/admin/shared-configs/7", body: `{"reason":"malware"}`,
+			statusCode: fiber.StatusUnauthorized, response: JsonErrorMessageResponse(fiber.ErrUnauthorized.Message)},
+		{user: admin, method: "DELETE", url: "/admin/shared-configs/7", body: `{"reason":"malware"}`,
+			statusCode: fiber.StatusOK},
+	}
+	for _, tc := range cases {
+		currentUser = tc.user
+		req := httptest.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if !assert.NoError(err) {
+			return
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err) {
+			return
+		}
+		assert.Equal(tc.statusCode, resp.StatusCode, tc.url)
+		assert.Equal(tc.response, string(body), tc.url)
+	}
+
+	assert.Equal(buzza.SharedConfigQuery{Search: "butter", Tags: []string{"pvp", "jitter"},
+		Sort: buzza.SharedConfigSortRating, Offset: 20, Limit: 10}, searchQuery)
+	assert.Equal(buzza.SharedConfigMeta{Title: "My config", Tags: []string{"pvp"}}, publishedMeta)
+	assert.Equal(int64(7), takenDown)
+}
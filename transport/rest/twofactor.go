@@ -0,0 +1,136 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Second factor management of the logged in user.
+// Login step-up endpoints are installed by AuthController.
+type TwoFactorController struct {
+	Authenticator *buzza.TwoFactorAuthenticator
+}
+
+func (c *TwoFactorController) InstallTo(requestAuthorizer fiber.Handler, app *fiber.App) {
+	app.Get("/2fa", combineHandlers(requestAuthorizer, c.serveStatus))
+	app.Post("/2fa/enrol", combineHandlers(requestAuthorizer, c.serveBeginEnrolment))
+	app.Post("/2fa/enrol/confirm", combineHandlers(requestAuthorizer, c.serveConfirmEnrolment))
+	app.Delete("/2fa", combineHandlers(requestAuthorizer, c.serveDisable))
+	app.Post("/2fa/recovery-codes", combineHandlers(requestAuthorizer, c.serveRegenerateRecoveryCodes))
+}
+
+type twoFactorCodeBody struct {
+	Code string `json:"code"`
+}
+
+func (c *TwoFactorController) serveStatus(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	twoFactor, err := c.Authenticator.Store.ByUserId(ctx.Context(), user.Id)
+	if err != nil && !errors.Is(err, buzza.ErrTwoFactorNotFound) {
+		return fmt.Errorf("get two factor: %w", err)
+	}
+	response := map[string]interface{}{
+		"enabled":  twoFactor.Enabled,
+		"required": buzza.TwoFactorRequired(user.Roles),
+	}
+	if twoFactor.Enabled {
+		response["recoveryCodesLeft"] = len(twoFactor.RecoveryCodeHashes)
+		response["enabledAt"] = twoFactor.EnabledAt.Unix()
+	}
+	return ctx.JSON(response)
+}
+
+func (c *TwoFactorController) serveBeginEnrolment(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	enrolment, err := c.Authenticator.BeginEnrolment(ctx.Context(), user)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	return ctx.JSON(newTotpEnrolmentBody(enrolment))
+}
+
+func (c *TwoFactorController) serveConfirmEnrolment(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	var body twoFactorCodeBody
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	codes, err := c.Authenticator.ConfirmEnrolment(ctx.Context(), user.Id, body.Code)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	requestLog(ctx).WithField("user_id", user.Id).Infoln("Two-factor authentication enabled.")
+	return ctx.JSON(map[string]interface{}{"recoveryCodes": codes})
+}
+
+func (c *TwoFactorController) serveDisable(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	var body twoFactorCodeBody
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	if err := c.Authenticator.Disable(ctx.Context(), user, body.Code); err != nil {
+		return twoFactorError(err)
+	}
+	requestLog(ctx).WithField("user_id", user.Id).Infoln("Two-factor authentication disabled.")
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (c *TwoFactorController) serveRegenerateRecoveryCodes(ctx *fiber.Ctx) error {
+	user, ok := ctx.Locals(userLocalsKey).(buzza.User)
+	if !ok {
+		return fiber.ErrUnauthorized
+	}
+	var body twoFactorCodeBody
+	if err := ctx.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid body")
+	}
+	codes, err := c.Authenticator.RegenerateRecoveryCodes(ctx.Context(), user.Id, body.Code)
+	if err != nil {
+		return twoFactorError(err)
+	}
+	return ctx.JSON(map[string]interface{}{"recoveryCodes": codes})
+}
+
+func newTotpEnrolmentBody(enrolment buzza.TotpEnrolment) map[string]string {
+	return map[string]string{
+		"secret": enrolment.Secret,
+		"uri":    enrolment.Uri,
+	}
+}
+
+func twoFactorError(err error) error {
+	switch {
+	case errors.Is(err, buzza.ErrInvalidTwoFactorCode):
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid code")
+	case errors.Is(err, buzza.ErrInvalidTwoFactorChallenge):
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid challenge")
+	case errors.Is(err, buzza.ErrTwoFactorLocked):
+		return fiber.NewError(fiber.StatusTooManyRequests, "too many invalid codes")
+	case errors.Is(err, buzza.ErrTwoFactorNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "two-factor authentication not enrolled")
+	case errors.Is(err, buzza.ErrTwoFactorAlreadyEnabled):
+		return fiber.NewError(fiber.StatusConflict, "two-factor authentication already enabled")
+	case errors.Is(err, buzza.ErrTwoFactorEnrolmentNotAllowed):
+		return fiber.NewError(fiber.StatusForbidden, "enrolment not allowed, use the enabled second factor")
+	case errors.Is(err, buzza.ErrTwoFactorRequired):
+		return fiber.NewError(fiber.StatusForbidden, "two-factor authentication required")
+	default:
+		return err
+	}
+}
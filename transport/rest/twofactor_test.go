@@ -0,0 +1,278 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzkaaclicker/buzza"
+	"github.com/buzkaaclicker/buzza/discord"
+	"github.com/buzkaaclicker/buzza/inmem"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	userStore := inmem.NewUserStore()
+	activityStore := inmem.NewActivityStore()
+	sessionStore := inmem.NewSessionStore(&activityStore)
+	twoFactorStore := inmem.NewTwoFactorStore()
+	authenticator := &buzza.TwoFactorAuthenticator{
+		Store:         &twoFactorStore,
+		ActivityStore: &activityStore,
+		Issuer:        "BuzkaaClicker",
+	}
+
+	discordUser := discord.User{Id: "2137", Username: "admin", Email: "admin@buzkaaclicker.pl"}
+	admin, _, err := userStore.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderDiscord, Subject: discordUser.Id, Email: buzza.Email(discordUser.Email)})
+	if !assert.NoError(err) {
+		return
+	}
+	admin.Roles = buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}
+	if !assert.NoError(userStore.Update(ctx, admin)) {
+		return
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	authController := AuthController{
+		Providers:                testDiscordProviders(discordUser),
+		SessionStore:             &sessionStore,
+		UserStore:                &userStore,
+		TwoFactor:                authenticator,
+		TwoFactorChallengeSecret: []byte("0123456789abcdef0123456789abcdef"),
+	}
+	authController.InstallTo(app)
+
+	request := func(url string, body string, statusCode int) map[string]interface{} {
+		response := map[string]interface{}{}
+		testJsonRequest(t, app, "POST", url, body, nil, statusCode, &response)
+		return response
+	}
+	login := func(enrolmentRequired bool) string {
+		response := request("/auth/discord", `{"code":"21"}`, fiber.StatusOK)
+		assert.NotContains(response, "accessToken", "session must not be issued before the second factor")
+		assert.Equal(enrolmentRequired, response["enrolmentRequired"])
+		challenge, _ := response["challenge"].(string)
+		return challenge
+	}
+
+	// admin has no second factor yet, so enrolment is required to log in
+	challenge := login(true)
+	request("/auth/2fa", `{"challenge":"`+challenge+`","code":"000000"}`, fiber.StatusNotFound)
+	request("/auth/2fa/enrol", `{"challenge":"forged.challenge"}`, fiber.StatusUnauthorized)
+	enrolment := request("/auth/2fa/enrol", `{"challenge":"`+challenge+`"}`, fiber.StatusOK)
+	secret, _ := enrolment["secret"].(string)
+	assert.Contains(enrolment["uri"], "otpauth://totp/BuzkaaClicker:admin@buzkaaclicker.pl?")
+	code, err := buzza.TotpCode(secret, time.Now().Unix()/int64(buzza.TotpPeriod/time.Second))
+	if !assert.NoError(err) {
+		return
+	}
+	request("/auth/2fa/enrol/confirm", `{"challenge":"`+challenge+`","code":"000000"}`, fiber.StatusUnauthorized)
+	confirmed := request("/auth/2fa/enrol/confirm", `{"challenge":"`+challenge+`","code":"`+code+`"}`,
+		fiber.StatusCreated)
+	assert.NotEmpty(confirmed["accessToken"])
+	recoveryCodes, _ := confirmed["recoveryCodes"].([]interface{})
+	if !assert.Len(recoveryCodes, buzza.RecoveryCodeCount) {
+		return
+	}
+
+	challenge = login(false)
+	request("/auth/2fa/enrol", `{"challenge":"`+challenge+`"}`, fiber.StatusForbidden)
+	request("/auth/2fa/enrol/confirm", `{"challenge":"`+challenge+`","code":"`+code+`"}`, fiber.StatusForbidden)
+	request("/auth/2fa", `{"challenge":"`+challenge+`","code":"`+code+`"}`, fiber.StatusUnauthorized)
+	session := request("/auth/2fa", `{"challenge":"`+challenge+`","code":"`+recoveryCodes[0].(string)+`"}`,
+		fiber.StatusCreated)
+	accessToken, _ := session["accessToken"].(string)
+	exists, err := sessionStore.Exists(accessToken)
+	if assert.NoError(err) {
+		assert.True(exists)
+	}
+
+	logs, err := activityStore.ByUserId(ctx, admin.Id, buzza.ActivityQuery{Limit: 100})
+	if assert.NoError(err) {
+		names := map[string]bool{}
+		for _, log := range logs {
+			names[log.Name] = true
+		}
+		assert.True(names[buzza.ActivityTwoFactorEnabled])
+		assert.True(names[buzza.ActivityRecoveryCodeUsed])
+	}
+}
+
+func TestTwoFactorController(t *testing.T) {
+	assert := assert.New(t)
+
+	activityStore := inmem.NewActivityStore()
+	twoFactorStore := inmem.NewTwoFactorStore()
+	authenticator := &buzza.TwoFactorAuthenticator{
+		Store:         &twoFactorStore,
+		ActivityStore: &activityStore,
+		Issuer:        "BuzkaaClicker",
+	}
+
+	user := buzza.User{Id: 8, Email: "user@buzkaaclicker.pl"}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	controller := TwoFactorController{Authenticator: authenticator}
+	controller.InstallTo(func(ctx *fiber.Ctx) error {
+		ctx.Locals(userLocalsKey, user)
+		return nil
+	}, app)
+
+	var secret string
+	var recoveryCodes []string
+	totp := func() string {
+		code, err := buzza.TotpCode(secret, time.Now().Unix()/int64(buzza.TotpPeriod/time.Second))
+		assert.NoError(err)
+		return code
+	}
+	cases := []struct {
+		method     string
+		url        string
+		body       func() string
+		statusCode int
+		validate   func(response map[string]interface{})
+		roles      buzza.Roles
+	}{
+		{method: "GET", url: "/2fa", statusCode: fiber.StatusOK,
+			validate: func(response map[string]interface{}) {
+				assert.Equal(map[string]interface{}{"enabled": false, "required": false}, response)
+			}},
+		{method: "DELETE", url: "/2fa", body: func() string { return `{"code":"000000"}` },
+			statusCode: fiber.StatusNotFound},
+		{method: "POST", url: "/2fa/enrol", statusCode: fiber.StatusOK,
+			validate: func(response map[string]interface{}) {
+				secret, _ = response["secret"].(string)
+			}},
+		{method: "POST", url: "/2fa/enrol/confirm", body: func() string { return `{"code":"` + totp() + `"}` },
+			statusCode: fiber.StatusOK,
+			validate: func(response map[string]interface{}) {
+				codes, _ := response["recoveryCodes"].([]interface{})
+				for _, code := range codes {
+					recoveryCodes = append(recoveryCodes, code.(string))
+				}
+				assert.Len(recoveryCodes, buzza.RecoveryCodeCount)
+			}},
+		{method: "GET", url: "/2fa", statusCode: fiber.StatusOK,
+			validate: func(response map[string]interface{}) {
+				assert.Equal(true, response["enabled"])
+				assert.Equal(float64(buzza.RecoveryCodeCount), response["recoveryCodesLeft"])
+			}},
+		{method: "POST", url: "/2fa/recovery-codes", body: func() string { return `{"code":"` + recoveryCodes[0] + `"}` },
+			statusCode: fiber.StatusOK,
+			validate: func(response map[string]interface{}) {
+				codes, _ := response["recoveryCodes"].([]interface{})
+				assert.Len(codes, buzza.RecoveryCodeCount)
+				recoveryCodes = recoveryCodes[:0]
+				for _, code := range codes {
+					recoveryCodes = append(recoveryCodes, code.(string))
+				}
+			}},
+		{method: "DELETE", url: "/2fa", body: func() string { return `{"code":"` + recoveryCodes[0] + `"}` },
+			roles: buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}, statusCode: fiber.StatusForbidden},
+		{method: "DELETE", url: "/2fa", body: func() string { return `{"code":"000000"}` },
+			statusCode: fiber.StatusUnauthorized},
+		{method: "DELETE", url: "/2fa", body: func() string { return `{"code":"` + recoveryCodes[0] + `"}` },
+			statusCode: fiber.StatusNoContent},
+		{method: "GET", url: "/2fa", statusCode: fiber.StatusOK,
+			validate: func(response map[string]interface{}) {
+				assert.Equal(false, response["enabled"])
+			}},
+	}
+	for i, tc := range cases {
+		user.Roles = tc.roles
+		var reqBody io.Reader
+		if tc.body != nil {
+			reqBody = strings.NewReader(tc.body())
+		}
+		req := httptest.NewRequest(tc.method, tc.url, reqBody)
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if !assert.NoError(err, i) {
+			return
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !assert.NoError(err, i) {
+			return
+		}
+		if !assert.Equal(tc.statusCode, resp.StatusCode, "%d: %s", i, respBody) {
+			return
+		}
+		if tc.validate != nil {
+			response := map[string]interface{}{}
+			if assert.NoError(json.Unmarshal(respBody, &response), i) {
+				tc.validate(response)
+			}
+		}
+	}
+
+	names := map[string]bool{}
+	logs, err := activityStore.ByUserId(context.Background(), user.Id, buzza.ActivityQuery{Limit: 100})
+	if assert.NoError(err) {
+		for _, log := range logs {
+			names[log.Name] = true
+		}
+	}
+	assert.True(names[buzza.ActivityTwoFactorEnabled])
+	assert.True(names[buzza.ActivityTwoFactorDisabled])
+}
+
+func TestAdminSessionSecondFactor(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	userStore := inmem.NewUserStore()
+	activityStore := inmem.NewActivityStore()
+	sessionStore := inmem.NewSessionStore(&activityStore)
+	twoFactorStore := inmem.NewTwoFactorStore()
+
+	user, _, err := userStore.RegisterExternalUser(ctx, buzza.ExternalIdentity{
+		Provider: buzza.ProviderDiscord, Subject: "2137", Email: "admin@buzkaaclicker.pl"})
+	if !assert.NoError(err) {
+		return
+	}
+	oldSession, err := sessionStore.RegisterNew(ctx, user.Id, "10.0.0.1", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	// admin role granted to the already logged in user
+	user.Roles = buzza.Roles{buzza.AllRoles[buzza.RoleIdAdmin]}
+	if !assert.NoError(userStore.Update(ctx, user)) {
+		return
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	requestAuthorizer := RequestAuthorizer(&sessionStore, &userStore,
+		&buzza.SecondFactorVerifier{TwoFactor: &twoFactorStore})
+	app.Get("/dashboard", combineHandlers(requestAuthorizer, requirePermissions(buzza.PermissionAdminDashboard),
+		func(ctx *fiber.Ctx) error { return ctx.SendStatus(fiber.StatusNoContent) }))
+	request := func(session buzza.Session) int {
+		resp, _ := testRequest(t, app, "GET", "/dashboard", "",
+			map[string]string{fiber.HeaderAuthorization: "Bearer " + session.Token})
+		return resp.StatusCode
+	}
+
+	assert.Equal(fiber.StatusForbidden, request(oldSession))
+
+	err = twoFactorStore.Save(ctx, buzza.TwoFactor{UserId: user.Id, Secret: "JBSWY3DPEHPK3PXP", Enabled: true,
+		EnabledAt: time.Now()})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(fiber.StatusForbidden, request(oldSession), "session older than the second factor did not pass it")
+
+	newSession, err := sessionStore.RegisterNew(ctx, user.Id, "10.0.0.1", "Firefox")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(fiber.StatusNoContent, request(newSession))
+}
@@ -0,0 +1,432 @@
+package buzza
+
+import (
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTwoFactorNotFound         = errors.New("two-factor authentication not enrolled")
+	ErrTwoFactorAlreadyEnabled   = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorRequired         = errors.New("two-factor authentication required by user roles")
+	ErrInvalidTwoFactorCode      = errors.New("invalid two-factor code")
+	ErrTwoFactorLocked           = errors.New("too many invalid two-factor codes")
+	ErrInvalidTwoFactorChallenge = errors.New("invalid two-factor challenge")
+	// Challenge of the user who already has a second factor, it has to be used instead.
+	ErrTwoFactorEnrolmentNotAllowed = errors.New("two-factor enrolment not allowed by the challenge")
+)
+
+const (
+	TotpDigits = 6
+	TotpPeriod = 30 * time.Second
+	// Codes of the neighbouring periods are accepted too, so small clock drift does not matter.
+	totpSkew = 1
+
+	RecoveryCodeCount = 10
+
+	// Invalid codes in a row after which verification is locked for TwoFactorLockout.
+	MaxTwoFactorFailures = 5
+	TwoFactorLockout     = 15 * time.Minute
+
+	DefaultTwoFactorChallengeTTL = 5 * time.Minute
+)
+
+// Second factor of the user. Enrolment is pending until the first code is confirmed.
+type TwoFactor struct {
+	UserId UserId
+	// Base32 encoded TOTP secret.
+	Secret  string
+	Enabled bool
+	// Last accepted TOTP time step, codes can not be reused.
+	LastStep int64
+	// Hashes of unused recovery codes.
+	RecoveryCodeHashes []string
+	FailedAttempts     int
+	LastFailureAt      time.Time
+	EnabledAt          time.Time
+}
+
+// Verification is locked after MaxTwoFactorFailures failures in a row, until TwoFactorLockout passes.
+func (t TwoFactor) Locked(at time.Time) bool {
+	return t.FailedAttempts >= MaxTwoFactorFailures && at.Before(t.LastFailureAt.Add(TwoFactorLockout))
+}
+
+type TwoFactorStore interface {
+	// ErrTwoFactorNotFound if user has not started enrolment.
+	ByUserId(ctx context.Context, userId UserId) (TwoFactor, error)
+
+	// Insert or replace second factor of the user.
+	Save(ctx context.Context, twoFactor TwoFactor) error
+
+	// ErrTwoFactorNotFound if user has not started enrolment.
+	Delete(ctx context.Context, userId UserId) error
+
+	// Accept TOTP step and reset failures, ErrInvalidTwoFactorCode if the step is not newer than the last accepted one.
+	UseStep(ctx context.Context, userId UserId, step int64) error
+
+	// Remove recovery code and reset failures, ErrInvalidTwoFactorCode if user has no such code.
+	// Returns number of codes left.
+	UseRecoveryCode(ctx context.Context, userId UserId, codeHash string) (int, error)
+
+	// Count the attempt as a failure until a code is accepted. ErrTwoFactorLocked if there have been
+	// MaxTwoFactorFailures failures in a row and the last one is less than TwoFactorLockout before the time.
+	ReserveAttempt(ctx context.Context, userId UserId, at time.Time) error
+}
+
+// Two-factor authentication of users holding admin permissions is mandatory.
+func TwoFactorRequired(roles Roles) bool {
+	for _, role := range roles {
+		for permission, allowed := range role.Permissions {
+			if allowed && PermissionRequiresTwoFactor(permission) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func PermissionRequiresTwoFactor(permission PermissionName) bool {
+	return strings.HasPrefix(string(permission), "admin.")
+}
+
+// Tells whether sessions passed the second factor. Logins of users with an enabled second factor always
+// require it, so only sessions created before all factors of the user did not, e.g. sessions of users
+// granted an admin role later.
+type SecondFactorVerifier struct {
+	TwoFactor TwoFactorStore
+	// Optional.
+	Passkeys PasskeyStore
+}
+
+func (v *SecondFactorVerifier) SessionVerified(ctx context.Context, session Session) (bool, error) {
+	twoFactor, err := v.TwoFactor.ByUserId(ctx, session.UserId)
+	if err != nil && !errors.Is(err, ErrTwoFactorNotFound) {
+		return false, fmt.Errorf("get two factor: %w", err)
+	}
+	if err == nil && twoFactor.Enabled && !twoFactor.EnabledAt.After(session.CreatedAt) {
+		return true, nil
+	}
+	if v.Passkeys == nil {
+		return false, nil
+	}
+	passkeys, err := v.Passkeys.ByUserId(ctx, session.UserId)
+	if err != nil {
+		return false, fmt.Errorf("get passkeys: %w", err)
+	}
+	for _, passkey := range passkeys {
+		if !passkey.CreatedAt.After(session.CreatedAt) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type TotpEnrolment struct {
+	Secret string
+	// otpauth:// URI, encoded in a QR code for authenticator apps.
+	Uri string
+}
+
+// Enrols, verifies and disables second factors of users.
+type TwoFactorAuthenticator struct {
+	Store         TwoFactorStore
+	ActivityStore ActivityStore
+	// Account issuer shown in authenticator apps.
+	Issuer string
+	// Current time, time.Now if nil.
+	Now func() time.Time
+}
+
+func (a *TwoFactorAuthenticator) now() time.Time {
+	if a.Now == nil {
+		return time.Now()
+	}
+	return a.Now()
+}
+
+func (a *TwoFactorAuthenticator) Enabled(ctx context.Context, userId UserId) (bool, error) {
+	twoFactor, err := a.Store.ByUserId(ctx, userId)
+	switch {
+	case errors.Is(err, ErrTwoFactorNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return twoFactor.Enabled, nil
+	}
+}
+
+// Start enrolment with a new secret, replacing unconfirmed one.
+func (a *TwoFactorAuthenticator) BeginEnrolment(ctx context.Context, user User) (TotpEnrolment, error) {
+	enabled, err := a.Enabled(ctx, user.Id)
+	if err != nil {
+		return TotpEnrolment{}, fmt.Errorf("get two factor: %w", err)
+	}
+	if enabled {
+		return TotpEnrolment{}, ErrTwoFactorAlreadyEnabled
+	}
+	secret, err := GenerateTotpSecret()
+	if err != nil {
+		return TotpEnrolment{}, err
+	}
+	if err := a.Store.Save(ctx, TwoFactor{UserId: user.Id, Secret: secret}); err != nil {
+		return TotpEnrolment{}, fmt.Errorf("save two factor: %w", err)
+	}
+	account := string(user.Email)
+	if account == "" {
+		account = strconv.FormatInt(int64(user.Id), 10)
+	}
+	return TotpEnrolment{Secret: secret, Uri: TotpProvisioningUri(a.Issuer, account, secret)}, nil
+}
+
+// Enable second factor after the user proved the authenticator app works.
+// Returns recovery codes, which are shown to the user only once.
+func (a *TwoFactorAuthenticator) ConfirmEnrolment(ctx context.Context, userId UserId, code string) ([]string, error) {
+	twoFactor, err := a.Store.ByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	step, ok := MatchTotp(twoFactor.Secret, code, a.now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	codes, hashes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	twoFactor.Enabled = true
+	twoFactor.LastStep = step
+	twoFactor.RecoveryCodeHashes = hashes
+	twoFactor.FailedAttempts = 0
+	twoFactor.EnabledAt = a.now().UTC()
+	if err := a.Store.Save(ctx, twoFactor); err != nil {
+		return nil, fmt.Errorf("save two factor: %w", err)
+	}
+	if err := a.ActivityStore.AddLog(ctx, userId, TwoFactorEnabledActivity()); err != nil {
+		return nil, fmt.Errorf("log two factor enabled: %w", err)
+	}
+	return codes, nil
+}
+
+// Verify TOTP or recovery code of the user with enabled second factor.
+func (a *TwoFactorAuthenticator) Verify(ctx context.Context, userId UserId, code string) error {
+	twoFactor, err := a.Store.ByUserId(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !twoFactor.Enabled {
+		return ErrTwoFactorNotFound
+	}
+	now := a.now()
+	// attempt is counted before the code is matched, so concurrent guesses can not exceed the limit
+	if err := a.Store.ReserveAttempt(ctx, userId, now.UTC()); err != nil {
+		return err
+	}
+
+	if step, ok := MatchTotp(twoFactor.Secret, code, now); ok {
+		err = a.Store.UseStep(ctx, userId, step)
+	} else {
+		var left int
+		left, err = a.Store.UseRecoveryCode(ctx, userId, HashRecoveryCode(code))
+		if err == nil {
+			if err := a.ActivityStore.AddLog(ctx, userId, TwoFactorRecoveryCodeUsedActivity(left)); err != nil {
+				return fmt.Errorf("log recovery code use: %w", err)
+			}
+		}
+	}
+	return err
+}
+
+// Disable second factor, ErrTwoFactorRequired if user roles require it.
+func (a *TwoFactorAuthenticator) Disable(ctx context.Context, user User, code string) error {
+	if TwoFactorRequired(user.Roles) {
+		return ErrTwoFactorRequired
+	}
+	if err := a.Verify(ctx, user.Id, code); err != nil {
+		return err
+	}
+	if err := a.Store.Delete(ctx, user.Id); err != nil {
+		return fmt.Errorf("delete two factor: %w", err)
+	}
+	if err := a.ActivityStore.AddLog(ctx, user.Id, TwoFactorDisabledActivity()); err != nil {
+		return fmt.Errorf("log two factor disabled: %w", err)
+	}
+	return nil
+}
+
+// Replace recovery codes with new ones.
+func (a *TwoFactorAuthenticator) RegenerateRecoveryCodes(ctx context.Context, userId UserId, code string) ([]string, error) {
+	if err := a.Verify(ctx, userId, code); err != nil {
+		return nil, err
+	}
+	twoFactor, err := a.Store.ByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	twoFactor.RecoveryCodeHashes = hashes
+	if err := a.Store.Save(ctx, twoFactor); err != nil {
+		return nil, fmt.Errorf("save two factor: %w", err)
+	}
+	return codes, nil
+}
+
+func GenerateTotpSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := crand.Read(secret); err != nil {
+		return "", fmt.Errorf("generate totp secret: %w", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+func TotpProvisioningUri(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(TotpDigits))
+	query.Set("period", strconv.Itoa(int(TotpPeriod/time.Second)))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(TotpPeriod/time.Second)
+}
+
+// RFC 6238 code of the time step.
+func TotpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("decode totp secret: %w", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	code := strconv.FormatUint(uint64(value%1_000_000), 10)
+	return strings.Repeat("0", TotpDigits-len(code)) + code, nil
+}
+
+// Time step of the matching code, false if code is invalid at the time.
+func MatchTotp(secret string, code string, at time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TotpDigits {
+		return 0, false
+	}
+	current := totpStep(at)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := TotpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Codes formatted for the user and their hashes to store.
+func GenerateRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := crand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// Hash of the recovery code, insensitive to case, spaces and dashes.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// Proof that the user passed the first factor, exchanged for the session after the second one.
+type TwoFactorChallenge struct {
+	UserId UserId
+	// Set only for users required to have second factor before they enrolled any,
+	// otherwise the challenge could be passed by enrolling a new factor instead of using the existing one.
+	EnrolmentAllowed bool
+	ExpiresAt        time.Time
+}
+
+func SignTwoFactorChallenge(secret []byte, challenge TwoFactorChallenge) string {
+	enrolment := "0"
+	if challenge.EnrolmentAllowed {
+		enrolment = "1"
+	}
+	payload := strconv.FormatInt(int64(challenge.UserId), 10) + "." + enrolment + "." +
+		strconv.FormatInt(challenge.ExpiresAt.Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(twoFactorChallengeMac(secret, encoded))
+}
+
+func VerifyTwoFactorChallenge(secret []byte, token string, now time.Time) (TwoFactorChallenge, error) {
+	encoded, encodedMac, ok := cut(token, ".")
+	if !ok {
+		return TwoFactorChallenge{}, ErrInvalidTwoFactorChallenge
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil || !hmac.Equal(mac, twoFactorChallengeMac(secret, encoded)) {
+		return TwoFactorChallenge{}, ErrInvalidTwoFactorChallenge
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return TwoFactorChallenge{}, ErrInvalidTwoFactorChallenge
+	}
+	parts := strings.Split(string(payload), ".")
+	if len(parts) != 3 || (parts[1] != "0" && parts[1] != "1") {
+		return TwoFactorChallenge{}, ErrInvalidTwoFactorChallenge
+	}
+	userId, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return TwoFactorChallenge{}, ErrInvalidTwoFactorChallenge
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresAt, 0)) {
+		return TwoFactorChallenge{}, ErrInvalidTwoFactorChallenge
+	}
+	return TwoFactorChallenge{
+		UserId:           UserId(userId),
+		EnrolmentAllowed: parts[1] == "1",
+		ExpiresAt:        time.Unix(expiresAt, 0),
+	}, nil
+}
+
+func twoFactorChallengeMac(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("two-factor-challenge:" + payload))
+	return mac.Sum(nil)
+}
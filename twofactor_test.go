@@ -0,0 +1,371 @@
+package buzza
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 test secret "12345678901234567890".
+const rfcTotpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode(t *testing.T) {
+	assert := assert.New(t)
+
+	// last 6 digits of the RFC 6238 SHA1 test vectors
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tc := range cases {
+		code, err := TotpCode(rfcTotpSecret, totpStep(time.Unix(tc.unix, 0)))
+		if assert.NoError(err, tc.unix) {
+			assert.Equal(tc.code, code, tc.unix)
+		}
+	}
+	_, err := TotpCode("not base32!", 1)
+	assert.Error(err)
+}
+
+func TestMatchTotp(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1111111109, 0)
+
+	step, ok := MatchTotp(rfcTotpSecret, "081804", now)
+	assert.True(ok)
+	assert.Equal(totpStep(now), step)
+
+	// neighbouring periods are accepted
+	previous, _ := TotpCode(rfcTotpSecret, totpStep(now)-1)
+	step, ok = MatchTotp(rfcTotpSecret, previous, now)
+	assert.True(ok)
+	assert.Equal(totpStep(now)-1, step)
+	old, _ := TotpCode(rfcTotpSecret, totpStep(now)-2)
+	_, ok = MatchTotp(rfcTotpSecret, old, now)
+	assert.False(ok)
+
+	for _, invalid := range []string{"", "08180", "0818040", "abcdef"} {
+		_, ok = MatchTotp(rfcTotpSecret, invalid, now)
+		assert.False(ok, invalid)
+	}
+}
+
+func TestTotpProvisioningUri(t *testing.T) {
+	assert := assert.New(t)
+
+	uri, err := url.Parse(TotpProvisioningUri("BuzkaaClicker", "user@example.com", rfcTotpSecret))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("otpauth", uri.Scheme)
+	assert.Equal("totp", uri.Host)
+	assert.Equal("/BuzkaaClicker:user@example.com", uri.Path)
+	assert.Equal(rfcTotpSecret, uri.Query().Get("secret"))
+	assert.Equal("BuzkaaClicker", uri.Query().Get("issuer"))
+	assert.Equal("6", uri.Query().Get("digits"))
+	assert.Equal("30", uri.Query().Get("period"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	assert := assert.New(t)
+
+	codes, hashes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(codes, RecoveryCodeCount)
+	assert.Len(hashes, RecoveryCodeCount)
+	seen := map[string]bool{}
+	for i, code := range codes {
+		assert.Len(code, 9, code)
+		assert.False(seen[code], "duplicated code")
+		seen[code] = true
+		assert.Equal(hashes[i], HashRecoveryCode(code))
+		assert.Equal(hashes[i], HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(code, "-", ""))),
+			"hash should ignore case, spaces and dashes")
+	}
+}
+
+func TestTwoFactorChallenge(t *testing.T) {
+	assert := assert.New(t)
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Unix(1641049200, 0)
+	challenge := SignTwoFactorChallenge(secret, TwoFactorChallenge{UserId: 42, ExpiresAt: now.Add(time.Minute)})
+
+	verified, err := VerifyTwoFactorChallenge(secret, challenge, now)
+	if assert.NoError(err) {
+		assert.Equal(UserId(42), verified.UserId)
+		assert.False(verified.EnrolmentAllowed)
+	}
+	enrolment := SignTwoFactorChallenge(secret, TwoFactorChallenge{UserId: 42, EnrolmentAllowed: true,
+		ExpiresAt: now.Add(time.Minute)})
+	verified, err = VerifyTwoFactorChallenge(secret, enrolment, now)
+	if assert.NoError(err) {
+		assert.True(verified.EnrolmentAllowed)
+	}
+	_, err = VerifyTwoFactorChallenge(secret, challenge, now.Add(time.Minute))
+	assert.ErrorIs(err, ErrInvalidTwoFactorChallenge, "expired challenge")
+	_, err = VerifyTwoFactorChallenge([]byte("other secret"), challenge, now)
+	assert.ErrorIs(err, ErrInvalidTwoFactorChallenge, "other secret")
+	// revoke tokens are signed with the same shape, but must not pass as challenges
+	revokeToken := SignRevokeToken(secret, 42, "session", now.Add(time.Minute))
+	_, err = VerifyTwoFactorChallenge(secret, revokeToken, now)
+	assert.ErrorIs(err, ErrInvalidTwoFactorChallenge, "revoke token")
+	for _, invalid := range []string{"", ".", "abc", "abc.def"} {
+		_, err = VerifyTwoFactorChallenge(secret, invalid, now)
+		assert.ErrorIs(err, ErrInvalidTwoFactorChallenge, invalid)
+	}
+}
+
+func TestTwoFactorRequired(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(TwoFactorRequired(nil))
+	assert.False(TwoFactorRequired(Roles{{Id: "user", Permissions: map[PermissionName]bool{"activity.read": true}}}))
+	assert.False(TwoFactorRequired(Roles{{Id: "user", Permissions: map[PermissionName]bool{"admin.audit": false}}}))
+	assert.True(TwoFactorRequired(Roles{{Id: "admin", Permissions: map[PermissionName]bool{"admin.audit": true}}}))
+}
+
+func TestSecondFactorVerifier(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	enabledAt := time.Unix(1641049200, 0)
+	verifier := SecondFactorVerifier{TwoFactor: &testTwoFactorStore{twoFactors: map[UserId]TwoFactor{
+		1: {UserId: 1, Enabled: true, EnabledAt: enabledAt},
+		2: {UserId: 2, Enabled: false},
+	}}}
+
+	verified, err := verifier.SessionVerified(ctx, Session{UserId: 1, CreatedAt: enabledAt.Add(time.Second)})
+	if assert.NoError(err) {
+		assert.True(verified)
+	}
+	verified, err = verifier.SessionVerified(ctx, Session{UserId: 1, CreatedAt: enabledAt.Add(-time.Second)})
+	if assert.NoError(err) {
+		assert.False(verified, "session created before the second factor did not pass it")
+	}
+	verified, err = verifier.SessionVerified(ctx, Session{UserId: 2, CreatedAt: enabledAt})
+	if assert.NoError(err) {
+		assert.False(verified, "pending enrolment is not a second factor")
+	}
+	verified, err = verifier.SessionVerified(ctx, Session{UserId: 3, CreatedAt: enabledAt})
+	if assert.NoError(err) {
+		assert.False(verified)
+	}
+}
+
+type testTwoFactorStore struct {
+	twoFactors map[UserId]TwoFactor
+	mutex      sync.Mutex
+}
+
+func (s *testTwoFactorStore) ByUserId(ctx context.Context, userId UserId) (TwoFactor, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	twoFactor, ok := s.twoFactors[userId]
+	if !ok {
+		return TwoFactor{}, ErrTwoFactorNotFound
+	}
+	return twoFactor, nil
+}
+
+func (s *testTwoFactorStore) Save(ctx context.Context, twoFactor TwoFactor) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.twoFactors[twoFactor.UserId] = twoFactor
+	return nil
+}
+
+func (s *testTwoFactorStore) Delete(ctx context.Context, userId UserId) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.twoFactors[userId]; !ok {
+		return ErrTwoFactorNotFound
+	}
+	delete(s.twoFactors, userId)
+	return nil
+}
+
+func (s *testTwoFactorStore) UseStep(ctx context.Context, userId UserId, step int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	twoFactor := s.twoFactors[userId]
+	if twoFactor.LastStep >= step {
+		return ErrInvalidTwoFactorCode
+	}
+	twoFactor.LastStep = step
+	twoFactor.FailedAttempts = 0
+	s.twoFactors[userId] = twoFactor
+	return nil
+}
+
+func (s *testTwoFactorStore) UseRecoveryCode(ctx context.Context, userId UserId, codeHash string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	twoFactor := s.twoFactors[userId]
+	for i, hash := range twoFactor.RecoveryCodeHashes {
+		if hash == codeHash {
+			twoFactor.RecoveryCodeHashes = append(twoFactor.RecoveryCodeHashes[:i:i], twoFactor.RecoveryCodeHashes[i+1:]...)
+			twoFactor.FailedAttempts = 0
+			s.twoFactors[userId] = twoFactor
+			return len(twoFactor.RecoveryCodeHashes), nil
+		}
+	}
+	return 0, ErrInvalidTwoFactorCode
+}
+
+func (s *testTwoFactorStore) ReserveAttempt(ctx context.Context, userId UserId, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	twoFactor := s.twoFactors[userId]
+	if twoFactor.Locked(at) {
+		return ErrTwoFactorLocked
+	}
+	twoFactor.FailedAttempts++
+	twoFactor.LastFailureAt = at
+	s.twoFactors[userId] = twoFactor
+	return nil
+}
+
+type testActivityStore struct {
+	ActivityStore
+	logs []Activity
+}
+
+func (s *testActivityStore) AddLog(ctx context.Context, userId UserId, activity Activity) error {
+	s.logs = append(s.logs, activity)
+	return nil
+}
+
+func TestTwoFactorAuthenticator(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	now := time.Unix(1641049200, 0)
+	activities := &testActivityStore{}
+	authenticator := TwoFactorAuthenticator{
+		Store:         &testTwoFactorStore{twoFactors: map[UserId]TwoFactor{}},
+		ActivityStore: activities,
+		Issuer:        "BuzkaaClicker",
+		Now:           func() time.Time { return now },
+	}
+	user := User{Id: 7, Email: "user@example.com"}
+	codeAt := func(at time.Time) string {
+		code, err := TotpCode(authenticator.Store.(*testTwoFactorStore).twoFactors[user.Id].Secret, totpStep(at))
+		assert.NoError(err)
+		return code
+	}
+
+	err := authenticator.Verify(ctx, user.Id, "000000")
+	assert.ErrorIs(err, ErrTwoFactorNotFound)
+
+	enrolment, err := authenticator.BeginEnrolment(ctx, user)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Contains(enrolment.Uri, "otpauth://totp/BuzkaaClicker:user@example.com?")
+	assert.Contains(enrolment.Uri, "secret="+enrolment.Secret)
+	enabled, err := authenticator.Enabled(ctx, user.Id)
+	if assert.NoError(err) {
+		assert.False(enabled, "enrolment is pending until confirmed")
+	}
+	_, err = authenticator.ConfirmEnrolment(ctx, user.Id, "not a code")
+	assert.ErrorIs(err, ErrInvalidTwoFactorCode)
+	codes, err := authenticator.ConfirmEnrolment(ctx, user.Id, codeAt(now))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(codes, RecoveryCodeCount)
+	_, err = authenticator.BeginEnrolment(ctx, user)
+	assert.ErrorIs(err, ErrTwoFactorAlreadyEnabled)
+
+	err = authenticator.Verify(ctx, user.Id, codeAt(now))
+	assert.ErrorIs(err, ErrInvalidTwoFactorCode, "code used for confirmation must not be replayed")
+	now = now.Add(TotpPeriod)
+	assert.NoError(authenticator.Verify(ctx, user.Id, codeAt(now)))
+
+	assert.NoError(authenticator.Verify(ctx, user.Id, strings.ToUpper(codes[0])))
+	err = authenticator.Verify(ctx, user.Id, codes[0])
+	assert.ErrorIs(err, ErrInvalidTwoFactorCode, "recovery codes are single use")
+
+	for i := 1; i < MaxTwoFactorFailures; i++ {
+		assert.ErrorIs(authenticator.Verify(ctx, user.Id, "000000"), ErrInvalidTwoFactorCode)
+	}
+	assert.ErrorIs(authenticator.Verify(ctx, user.Id, codes[1]), ErrTwoFactorLocked)
+	now = now.Add(TwoFactorLockout)
+	assert.NoError(authenticator.Verify(ctx, user.Id, codes[1]))
+
+	err = authenticator.Disable(ctx, User{Id: user.Id, Roles: Roles{{Id: "admin",
+		Permissions: map[PermissionName]bool{"admin.audit": true}}}}, codes[2])
+	assert.ErrorIs(err, ErrTwoFactorRequired)
+	assert.NoError(authenticator.Disable(ctx, user, codes[2]))
+	enabled, err = authenticator.Enabled(ctx, user.Id)
+	if assert.NoError(err) {
+		assert.False(enabled)
+	}
+
+	names := make([]string, len(activities.logs))
+	for i, activity := range activities.logs {
+		names[i] = activity.Name
+	}
+	assert.Equal([]string{ActivityTwoFactorEnabled, ActivityRecoveryCodeUsed, ActivityRecoveryCodeUsed,
+		ActivityRecoveryCodeUsed, ActivityTwoFactorDisabled}, names)
+	assert.Equal(RecoveryCodeCount-2, activities.logs[2].Data["codes_left"])
+}
+
+func TestTwoFactorConcurrentVerify(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	secret, err := GenerateTotpSecret()
+	if !assert.NoError(err) {
+		return
+	}
+	_, hashes, err := GenerateRecoveryCodes(1)
+	if !assert.NoError(err) {
+		return
+	}
+	store := &testTwoFactorStore{twoFactors: map[UserId]TwoFactor{
+		7: {UserId: 7, Secret: secret, Enabled: true, RecoveryCodeHashes: hashes},
+	}}
+	authenticator := TwoFactorAuthenticator{Store: store, ActivityStore: &testActivityStore{}}
+
+	const guesses = 4 * MaxTwoFactorFailures
+	errs := make(chan error, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- authenticator.Verify(ctx, 7, "000000")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	invalid, locked := 0, 0
+	for err := range errs {
+		switch {
+		case errors.Is(err, ErrInvalidTwoFactorCode):
+			invalid++
+		case errors.Is(err, ErrTwoFactorLocked):
+			locked++
+		default:
+			assert.Fail("unexpected error", "%v", err)
+		}
+	}
+	assert.Equal(MaxTwoFactorFailures, invalid, "only allowed attempts are matched")
+	assert.Equal(guesses-MaxTwoFactorFailures, locked)
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"errors"
 	"time"
-
-	"github.com/buzkaaclicker/buzza/discord"
 )
 
-var ErrUserNotFound = errors.New("user not found")
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrEmailInUse   = errors.New("verified email is used by another user")
+)
 
 type UserId int64
 
@@ -18,8 +19,9 @@ type User struct {
 	Id        UserId
 	CreatedAt time.Time
 	Roles     Roles
-	Discord   UserDiscord
-	Email     Email
+	// Filled from the discord identity, empty if the user has not linked discord account.
+	Discord UserDiscord
+	Email   Email
 }
 
 // Represents info about linked discord account to our account.
@@ -29,9 +31,32 @@ type UserDiscord struct {
 }
 
 type UserStore interface {
-	RegisterDiscordUser(ctx context.Context, u discord.User, refreshToken string) (User, error)
+	// Register new user or update existing one linked with the external account.
+	// Profile and refresh token are refreshed from the identity on every login. Email of the user is replaced
+	// only if the user has none, or by the verified email of the primary identity, the first linked one.
+	// "created" is true only if a new user has been registered. ErrEmailInUse if the verified email
+	// belongs to another user, the identity has to be linked to that user instead.
+	RegisterExternalUser(ctx context.Context, identity ExternalIdentity) (user User, created bool, err error)
 
 	ById(ctx context.Context, userId UserId) (User, error)
 
+	// User whose email has been verified by the identity provider on the last login.
+	// ErrUserNotFound if there is no such user.
+	ByVerifiedEmail(ctx context.Context, email Email) (User, error)
+
+	// Update roles and email, changed email is no longer verified.
+	// Identities are changed only by LinkIdentity and UnlinkIdentity.
 	Update(ctx context.Context, user User) error
+
+	// Identities of the user, oldest first.
+	Identities(ctx context.Context, userId UserId) ([]UserIdentity, error)
+
+	// Link external account to the user, linking already linked one refreshes its tokens.
+	// ErrIdentityLinked if the account belongs to another user,
+	// ErrProviderLinked if the user has other account of the provider.
+	LinkIdentity(ctx context.Context, userId UserId, identity ExternalIdentity) (UserIdentity, error)
+
+	// ErrIdentityNotFound if the user has no identity of the provider,
+	// ErrLastIdentity if it is the only one left.
+	UnlinkIdentity(ctx context.Context, userId UserId, provider string) (UserIdentity, error)
 }
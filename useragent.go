@@ -0,0 +1,233 @@
+package buzza
+
+import (
+	"strings"
+)
+
+// Name of our desktop clicker in its user agent.
+const ClickerUserAgentName = "BuzkaaClicker"
+
+type DeviceType string
+
+const (
+	DeviceUnknown DeviceType = ""
+	DeviceDesktop DeviceType = "desktop"
+	DeviceMobile  DeviceType = "mobile"
+	DeviceTablet  DeviceType = "tablet"
+	DeviceBot     DeviceType = "bot"
+)
+
+// Client information parsed from the User-Agent header. Unrecognized parts are empty.
+type UserAgentInfo struct {
+	// Browser or application name e.g. Chrome, Firefox, BuzkaaClicker.
+	Client        string
+	ClientVersion string
+	// Release branch of our clicker, empty for other clients.
+	Branch    string
+	OS        string
+	OSVersion string
+	Device    DeviceType
+}
+
+func (i UserAgentInfo) IsZero() bool {
+	return i == UserAgentInfo{}
+}
+
+// Short human-readable description e.g. "Chrome on Windows", "BuzkaaClicker 1.4.2 (beta) on Windows".
+// Empty if neither client nor OS is known.
+func (i UserAgentInfo) String() string {
+	client := i.Client
+	if i.Client == ClickerUserAgentName && i.ClientVersion != "" {
+		client += " " + i.ClientVersion
+		if i.Branch != "" {
+			client += " (" + i.Branch + ")"
+		}
+	}
+	switch {
+	case client != "" && i.OS != "":
+		return client + " on " + i.OS
+	case client != "":
+		return client
+	default:
+		return i.OS
+	}
+}
+
+// Parse the User-Agent header of browsers, our clicker and common tools.
+//
+// Clicker sends "BuzkaaClicker/<version> (<branch>; <os>; <arch>)" e.g.
+// "BuzkaaClicker/1.4.2 (beta; windows; amd64)".
+func ParseUserAgent(userAgent string) UserAgentInfo {
+	userAgent = strings.TrimSpace(userAgent)
+	if userAgent == "" {
+		return UserAgentInfo{}
+	}
+	if strings.HasPrefix(userAgent, ClickerUserAgentName+"/") {
+		return parseClickerUserAgent(userAgent)
+	}
+
+	var info UserAgentInfo
+	products, comments := splitUserAgent(userAgent)
+	info.OS, info.OSVersion = userAgentOS(comments)
+	info.Client, info.ClientVersion = userAgentClient(products)
+	info.Device = userAgentDevice(userAgent, info.OS)
+	return info
+}
+
+func parseClickerUserAgent(userAgent string) UserAgentInfo {
+	info := UserAgentInfo{Client: ClickerUserAgentName, Device: DeviceDesktop}
+	products, comments := splitUserAgent(userAgent)
+	info.ClientVersion = products[0].version
+	if len(comments) == 0 {
+		return info
+	}
+	parts := strings.Split(comments[0], ";")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	info.Branch = parts[0]
+	if len(parts) > 1 {
+		info.OS = clickerOSNames[strings.ToLower(parts[1])]
+		if info.OS == "" {
+			info.OS = parts[1]
+		}
+	}
+	return info
+}
+
+var clickerOSNames = map[string]string{
+	"windows": "Windows",
+	"linux":   "Linux",
+	"darwin":  "macOS",
+	"macos":   "macOS",
+}
+
+type userAgentProduct struct {
+	name    string
+	version string
+}
+
+// Split User-Agent into "name/version" products and parenthesized comments.
+func splitUserAgent(userAgent string) ([]userAgentProduct, []string) {
+	var products []userAgentProduct
+	var comments []string
+	for len(userAgent) > 0 {
+		userAgent = strings.TrimLeft(userAgent, " ")
+		if strings.HasPrefix(userAgent, "(") {
+			end := strings.Index(userAgent, ")")
+			if end < 0 {
+				end = len(userAgent)
+				comments = append(comments, userAgent[1:end])
+				break
+			}
+			comments = append(comments, userAgent[1:end])
+			userAgent = userAgent[end+1:]
+			continue
+		}
+		end := strings.IndexAny(userAgent, " (")
+		if end < 0 {
+			end = len(userAgent)
+		}
+		name, version, _ := cut(userAgent[:end], "/")
+		if name != "" {
+			products = append(products, userAgentProduct{name: name, version: version})
+		}
+		userAgent = userAgent[end:]
+	}
+	return products, comments
+}
+
+// Browsers add products of the engines they are based on, so more specific ones come first.
+var userAgentClients = []struct {
+	product string
+	name    string
+}{
+	{"Edg", "Edge"},
+	{"EdgA", "Edge"},
+	{"EdgiOS", "Edge"},
+	{"OPR", "Opera"},
+	{"YaBrowser", "Yandex Browser"},
+	{"SamsungBrowser", "Samsung Internet"},
+	{"Vivaldi", "Vivaldi"},
+	{"Brave", "Brave"},
+	{"FxiOS", "Firefox"},
+	{"Firefox", "Firefox"},
+	{"CriOS", "Chrome"},
+	{"Chrome", "Chrome"},
+	{"Chromium", "Chromium"},
+}
+
+func userAgentClient(products []userAgentProduct) (string, string) {
+	byName := make(map[string]string, len(products))
+	for _, product := range products {
+		byName[product.name] = product.version
+	}
+	for _, client := range userAgentClients {
+		if version, ok := byName[client.product]; ok {
+			return client.name, version
+		}
+	}
+	if _, ok := byName["Safari"]; ok {
+		// Safari keeps its version in the "Version" product
+		return "Safari", byName["Version"]
+	}
+	if len(products) > 0 && products[0].name != "Mozilla" {
+		// tools e.g. curl/7.79.1, okhttp/4.9.0
+		return products[0].name, products[0].version
+	}
+	return "", ""
+}
+
+func userAgentOS(comments []string) (string, string) {
+	for _, comment := range comments {
+		for _, token := range strings.Split(comment, ";") {
+			token = strings.TrimSpace(token)
+			switch {
+			case strings.HasPrefix(token, "Windows NT "):
+				return "Windows", windowsVersions[strings.TrimPrefix(token, "Windows NT ")]
+			case strings.HasPrefix(token, "Android"):
+				return "Android", strings.TrimSpace(strings.TrimPrefix(token, "Android"))
+			case strings.HasPrefix(token, "CPU iPhone OS "), strings.HasPrefix(token, "CPU OS "):
+				version := strings.TrimPrefix(strings.TrimPrefix(token, "CPU iPhone OS "), "CPU OS ")
+				version, _, _ = cut(version, " ")
+				return "iOS", strings.Replace(version, "_", ".", -1)
+			case strings.HasPrefix(token, "Intel Mac OS X"):
+				version := strings.TrimSpace(strings.TrimPrefix(token, "Intel Mac OS X"))
+				return "macOS", strings.Replace(version, "_", ".", -1)
+			case strings.HasPrefix(token, "CrOS"):
+				return "ChromeOS", ""
+			}
+		}
+	}
+	// Linux is often the only token of Android forks, so it is checked last
+	for _, comment := range comments {
+		if strings.Contains(comment, "Linux") {
+			return "Linux", ""
+		}
+	}
+	return "", ""
+}
+
+var windowsVersions = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+}
+
+func userAgentDevice(userAgent string, os string) DeviceType {
+	lower := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(lower, "bot") || strings.Contains(lower, "spider") || strings.Contains(lower, "crawl"):
+		return DeviceBot
+	case strings.Contains(userAgent, "iPad") || strings.Contains(userAgent, "Tablet") ||
+		(os == "Android" && !strings.Contains(userAgent, "Mobile")):
+		return DeviceTablet
+	case strings.Contains(userAgent, "Mobi") || strings.Contains(userAgent, "iPhone"):
+		return DeviceMobile
+	case os == "Windows" || os == "macOS" || os == "Linux" || os == "ChromeOS":
+		return DeviceDesktop
+	default:
+		return DeviceUnknown
+	}
+}
@@ -0,0 +1,86 @@
+package buzza
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		userAgent   string
+		info        UserAgentInfo
+		description string
+	}{
+		{
+			userAgent: "BuzkaaClicker/1.4.2 (beta; windows; amd64)",
+			info: UserAgentInfo{Client: "BuzkaaClicker", ClientVersion: "1.4.2", Branch: "beta",
+				OS: "Windows", Device: DeviceDesktop},
+			description: "BuzkaaClicker 1.4.2 (beta) on Windows",
+		},
+		{
+			userAgent:   "BuzkaaClicker/2.0.0",
+			info:        UserAgentInfo{Client: "BuzkaaClicker", ClientVersion: "2.0.0", Device: DeviceDesktop},
+			description: "BuzkaaClicker 2.0.0",
+		},
+		{
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			info: UserAgentInfo{Client: "Chrome", ClientVersion: "120.0.0.0", OS: "Windows", OSVersion: "10",
+				Device: DeviceDesktop},
+			description: "Chrome on Windows",
+		},
+		{
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			info: UserAgentInfo{Client: "Edge", ClientVersion: "120.0.2210.91", OS: "Windows", OSVersion: "10",
+				Device: DeviceDesktop},
+			description: "Edge on Windows",
+		},
+		{
+			userAgent: "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			info:      UserAgentInfo{Client: "Firefox", ClientVersion: "121.0", OS: "Linux", Device: DeviceDesktop},
+		},
+		{
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			info: UserAgentInfo{Client: "Safari", ClientVersion: "17.1", OS: "macOS", OSVersion: "10.15.7",
+				Device: DeviceDesktop},
+		},
+		{
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			info: UserAgentInfo{Client: "Safari", ClientVersion: "17.1", OS: "iOS", OSVersion: "17.1.2",
+				Device: DeviceMobile},
+			description: "Safari on iOS",
+		},
+		{
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/119.0.6045.169 Mobile/15E148 Safari/604.1",
+			info: UserAgentInfo{Client: "Chrome", ClientVersion: "119.0.6045.169", OS: "iOS", OSVersion: "16.6",
+				Device: DeviceTablet},
+		},
+		{
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-S908B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			info: UserAgentInfo{Client: "Samsung Internet", ClientVersion: "23.0", OS: "Android", OSVersion: "13",
+				Device: DeviceMobile},
+		},
+		{
+			userAgent: "Mozilla/5.0 (Linux; Android 12; SM-X200) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			info: UserAgentInfo{Client: "Chrome", ClientVersion: "120.0.0.0", OS: "Android", OSVersion: "12",
+				Device: DeviceTablet},
+		},
+		{
+			userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			info:      UserAgentInfo{Device: DeviceBot},
+		},
+		{
+			userAgent:   "curl/8.4.0",
+			info:        UserAgentInfo{Client: "curl", ClientVersion: "8.4.0"},
+			description: "curl",
+		},
+		{userAgent: "", info: UserAgentInfo{}},
+	}
+	for _, c := range cases {
+		info := ParseUserAgent(c.userAgent)
+		assert.Equal(t, c.info, info, c.userAgent)
+		if c.description != "" {
+			assert.Equal(t, c.description, info.String(), c.userAgent)
+		}
+	}
+}
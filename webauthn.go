@@ -0,0 +1,306 @@
+package buzza
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var ErrInvalidWebAuthnResponse = errors.New("invalid webauthn response")
+
+// COSE algorithms of the supported credential public keys, in order of preference.
+const (
+	CoseAlgorithmES256 = -7
+	CoseAlgorithmEdDSA = -8
+	CoseAlgorithmRS256 = -257
+)
+
+var SupportedCoseAlgorithms = []int{CoseAlgorithmES256, CoseAlgorithmEdDSA, CoseAlgorithmRS256}
+
+// Relying party passkeys are scoped to.
+type WebAuthnRelyingParty struct {
+	// Domain of the site, e.g. "buzkaaclicker.pl". Passkeys work on the domain and its subdomains.
+	Id   string
+	Name string
+	// Exact origins (scheme, host and port) the ceremonies are accepted from.
+	Origins []string
+}
+
+// Response of navigator.credentials.create() with decoded binary fields.
+type CredentialCreation struct {
+	ClientDataJson    []byte
+	AttestationObject []byte
+}
+
+// Response of navigator.credentials.get() with decoded binary fields.
+type CredentialAssertion struct {
+	CredentialId      []byte
+	ClientDataJson    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	// User handle stored by discoverable credentials, may be empty otherwise.
+	UserHandle []byte
+}
+
+const (
+	authDataUserPresent        = 0x01
+	authDataUserVerified       = 0x04
+	authDataAttestedCredential = 0x40
+	authDataExtensions         = 0x80
+)
+
+type authenticatorData struct {
+	rpIdHash  []byte
+	flags     byte
+	signCount uint32
+	// Set only with authDataAttestedCredential flag.
+	credentialId []byte
+	// COSE encoded credential public key.
+	publicKey []byte
+}
+
+func parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, errors.New("authenticator data too short")
+	}
+	parsed := authenticatorData{
+		rpIdHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+	if parsed.flags&authDataAttestedCredential != 0 {
+		// AAGUID identifies authenticator model, it is not used without attestation
+		if len(rest) < 18 {
+			return authenticatorData{}, errors.New("attested credential data too short")
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLength {
+			return authenticatorData{}, errors.New("credential id too short")
+		}
+		parsed.credentialId, rest = rest[:idLength], rest[idLength:]
+		_, afterKey, err := decodeCbor(rest)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("decode credential public key: %w", err)
+		}
+		parsed.publicKey, rest = rest[:len(rest)-len(afterKey)], afterKey
+	}
+	if parsed.flags&authDataExtensions != 0 {
+		if _, afterExtensions, err := decodeCbor(rest); err != nil {
+			return authenticatorData{}, fmt.Errorf("decode extensions: %w", err)
+		} else {
+			rest = afterExtensions
+		}
+	}
+	if len(rest) != 0 {
+		return authenticatorData{}, errors.New("trailing authenticator data")
+	}
+	return parsed, nil
+}
+
+// Verify response of the registration ceremony and return the new credential.
+// Attestation statements are not verified, any authenticator model is accepted.
+func (rp WebAuthnRelyingParty) verifyCreation(creation CredentialCreation, challenge []byte,
+	userVerification bool) (authenticatorData, error) {
+	if err := rp.verifyClientData(creation.ClientDataJson, "webauthn.create", challenge); err != nil {
+		return authenticatorData{}, err
+	}
+	decoded, _, err := decodeCbor(creation.AttestationObject)
+	if err != nil {
+		return authenticatorData{}, fmt.Errorf("%w: decode attestation object: %v", ErrInvalidWebAuthnResponse, err)
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return authenticatorData{}, fmt.Errorf("%w: attestation object is not a map", ErrInvalidWebAuthnResponse)
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return authenticatorData{}, fmt.Errorf("%w: missing authenticator data", ErrInvalidWebAuthnResponse)
+	}
+	authData, err := rp.verifyAuthenticatorData(rawAuthData, userVerification)
+	if err != nil {
+		return authenticatorData{}, err
+	}
+	if authData.flags&authDataAttestedCredential == 0 {
+		return authenticatorData{}, fmt.Errorf("%w: missing attested credential", ErrInvalidWebAuthnResponse)
+	}
+	if len(authData.credentialId) == 0 || len(authData.credentialId) > 1023 {
+		return authenticatorData{}, fmt.Errorf("%w: invalid credential id length", ErrInvalidWebAuthnResponse)
+	}
+	if _, err := parseCosePublicKey(authData.publicKey); err != nil {
+		return authenticatorData{}, fmt.Errorf("%w: %v", ErrInvalidWebAuthnResponse, err)
+	}
+	return authData, nil
+}
+
+// Verify response of the authentication ceremony signed with the credential public key.
+func (rp WebAuthnRelyingParty) verifyAssertion(assertion CredentialAssertion, challenge []byte,
+	publicKey []byte, userVerification bool) (authenticatorData, error) {
+	if err := rp.verifyClientData(assertion.ClientDataJson, "webauthn.get", challenge); err != nil {
+		return authenticatorData{}, err
+	}
+	authData, err := rp.verifyAuthenticatorData(assertion.AuthenticatorData, userVerification)
+	if err != nil {
+		return authenticatorData{}, err
+	}
+	key, err := parseCosePublicKey(publicKey)
+	if err != nil {
+		return authenticatorData{}, fmt.Errorf("parse stored public key: %w", err)
+	}
+	clientDataHash := sha256.Sum256(assertion.ClientDataJson)
+	signed := append(append([]byte{}, assertion.AuthenticatorData...), clientDataHash[:]...)
+	if !key.verify(signed, assertion.Signature) {
+		return authenticatorData{}, fmt.Errorf("%w: invalid signature", ErrInvalidWebAuthnResponse)
+	}
+	return authData, nil
+}
+
+func (rp WebAuthnRelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return fmt.Errorf("%w: decode client data: %v", ErrInvalidWebAuthnResponse, err)
+	}
+	if clientData.Type != ceremony {
+		return fmt.Errorf("%w: unexpected ceremony type '%s'", ErrInvalidWebAuthnResponse, clientData.Type)
+	}
+	receivedChallenge, err := DecodeWebAuthnBase64(clientData.Challenge)
+	if err != nil || !bytes.Equal(receivedChallenge, challenge) {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidWebAuthnResponse)
+	}
+	if clientData.CrossOrigin || !rp.allowedOrigin(clientData.Origin) {
+		return fmt.Errorf("%w: origin '%s' not allowed", ErrInvalidWebAuthnResponse, clientData.Origin)
+	}
+	return nil
+}
+
+func (rp WebAuthnRelyingParty) allowedOrigin(origin string) bool {
+	for _, allowed := range rp.Origins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (rp WebAuthnRelyingParty) verifyAuthenticatorData(raw []byte, userVerification bool) (authenticatorData, error) {
+	authData, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return authenticatorData{}, fmt.Errorf("%w: %v", ErrInvalidWebAuthnResponse, err)
+	}
+	rpIdHash := sha256.Sum256([]byte(rp.Id))
+	if !bytes.Equal(authData.rpIdHash, rpIdHash[:]) {
+		return authenticatorData{}, fmt.Errorf("%w: relying party id mismatch", ErrInvalidWebAuthnResponse)
+	}
+	if authData.flags&authDataUserPresent == 0 {
+		return authenticatorData{}, fmt.Errorf("%w: user not present", ErrInvalidWebAuthnResponse)
+	}
+	if userVerification && authData.flags&authDataUserVerified == 0 {
+		return authenticatorData{}, fmt.Errorf("%w: user not verified", ErrInvalidWebAuthnResponse)
+	}
+	return authData, nil
+}
+
+type cosePublicKey struct {
+	algorithm int64
+	ecdsa     *ecdsa.PublicKey
+	ed25519   ed25519.PublicKey
+	rsa       *rsa.PublicKey
+}
+
+func (k cosePublicKey) verify(data []byte, signature []byte) bool {
+	switch k.algorithm {
+	case CoseAlgorithmES256:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(k.ecdsa, digest[:], signature)
+	case CoseAlgorithmEdDSA:
+		return ed25519.Verify(k.ed25519, data, signature)
+	case CoseAlgorithmRS256:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// COSE key labels (RFC 8152 and RFC 8230).
+const (
+	coseKeyType      = 1
+	coseKeyAlgorithm = 3
+	coseKeyCurve     = -1 // modulus n for RSA
+	coseKeyX         = -2 // exponent e for RSA
+	coseKeyY         = -3
+)
+
+func parseCosePublicKey(raw []byte) (cosePublicKey, error) {
+	decoded, rest, err := decodeCbor(raw)
+	if err != nil {
+		return cosePublicKey{}, fmt.Errorf("decode public key: %w", err)
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return cosePublicKey{}, errors.New("public key is not a COSE key")
+	}
+	keyType, _ := key[int64(coseKeyType)].(int64)
+	algorithm, _ := key[int64(coseKeyAlgorithm)].(int64)
+
+	switch {
+	case keyType == 2 && algorithm == CoseAlgorithmES256:
+		curve, _ := key[int64(coseKeyCurve)].(int64)
+		x, _ := key[int64(coseKeyX)].([]byte)
+		y, _ := key[int64(coseKeyY)].([]byte)
+		if curve != 1 || len(x) != 32 || len(y) != 32 {
+			return cosePublicKey{}, errors.New("invalid P-256 key")
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return cosePublicKey{}, errors.New("P-256 point is not on the curve")
+		}
+		return cosePublicKey{algorithm: algorithm, ecdsa: publicKey}, nil
+	case keyType == 1 && algorithm == CoseAlgorithmEdDSA:
+		curve, _ := key[int64(coseKeyCurve)].(int64)
+		x, _ := key[int64(coseKeyX)].([]byte)
+		if curve != 6 || len(x) != ed25519.PublicKeySize {
+			return cosePublicKey{}, errors.New("invalid Ed25519 key")
+		}
+		return cosePublicKey{algorithm: algorithm, ed25519: ed25519.PublicKey(x)}, nil
+	case keyType == 3 && algorithm == CoseAlgorithmRS256:
+		n, _ := key[int64(coseKeyCurve)].([]byte)
+		e, _ := key[int64(coseKeyX)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return cosePublicKey{}, errors.New("invalid RSA key")
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		if exponent < 3 || exponent%2 == 0 {
+			return cosePublicKey{}, errors.New("invalid RSA exponent")
+		}
+		return cosePublicKey{algorithm: algorithm, rsa: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	default:
+		return cosePublicKey{}, fmt.Errorf("unsupported key type %d with algorithm %d", keyType, algorithm)
+	}
+}
+
+// Decode base64url value sent by browsers, padding is optional.
+func DecodeWebAuthnBase64(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func EncodeWebAuthnBase64(value []byte) string {
+	return base64.RawURLEncoding.EncodeToString(value)
+}
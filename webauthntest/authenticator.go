@@ -0,0 +1,205 @@
+// Package webauthntest provides a software WebAuthn authenticator, so passkey flows
+// can be tested without hardware keys or browsers.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	flagUserPresent        = 0x01
+	flagUserVerified       = 0x04
+	flagAttestedCredential = 0x40
+)
+
+// ES256 credential stored by the authenticator.
+type Credential struct {
+	Id         []byte
+	RpId       string
+	UserHandle []byte
+	// Incremented on every assertion, unless the authenticator does not count signatures.
+	SignCount uint32
+
+	key *ecdsa.PrivateKey
+}
+
+type Authenticator struct {
+	// Origin reported in client data, e.g. "https://buzkaaclicker.pl".
+	Origin string
+	// Report user verification (PIN, biometrics) in authenticator data.
+	UserVerification bool
+	// Keep signature counters at zero, as many passkey providers do.
+	NoSignCount bool
+
+	Credentials []*Credential
+}
+
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, UserVerification: true}
+}
+
+// Result of navigator.credentials.create().
+type Attestation struct {
+	CredentialId      []byte
+	ClientDataJson    []byte
+	AttestationObject []byte
+}
+
+// Response serialized like PublicKeyCredential.toJSON() of browsers.
+func (a Attestation) Json() string {
+	return marshalCredential(a.CredentialId, map[string]string{
+		"clientDataJSON":    encode(a.ClientDataJson),
+		"attestationObject": encode(a.AttestationObject),
+	})
+}
+
+// Result of navigator.credentials.get().
+type Assertion struct {
+	CredentialId      []byte
+	ClientDataJson    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte
+}
+
+// Response serialized like PublicKeyCredential.toJSON() of browsers.
+func (a Assertion) Json() string {
+	return marshalCredential(a.CredentialId, map[string]string{
+		"clientDataJSON":    encode(a.ClientDataJson),
+		"authenticatorData": encode(a.AuthenticatorData),
+		"signature":         encode(a.Signature),
+		"userHandle":        encode(a.UserHandle),
+	})
+}
+
+// Create a new credential with "none" attestation.
+func (a *Authenticator) Create(rpId string, challenge []byte, userHandle []byte) (Attestation, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return Attestation{}, fmt.Errorf("generate key: %w", err)
+	}
+	id := make([]byte, 16)
+	if _, err := crand.Read(id); err != nil {
+		return Attestation{}, fmt.Errorf("generate credential id: %w", err)
+	}
+	credential := &Credential{Id: id, RpId: rpId, UserHandle: userHandle, key: key}
+	a.Credentials = append(a.Credentials, credential)
+
+	publicKey := encodeCbor(map[interface{}]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: padded(key.X.Bytes()),
+		-3: padded(key.Y.Bytes()),
+	})
+	attested := make([]byte, 16, 18+len(id)+len(publicKey))
+	attested = append(attested, byte(len(id)>>8), byte(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, publicKey...)
+
+	authData := a.authenticatorData(credential, flagAttestedCredential)
+	authData = append(authData, attested...)
+	return Attestation{
+		CredentialId:   id,
+		ClientDataJson: a.clientData("webauthn.create", challenge),
+		AttestationObject: encodeCbor(map[interface{}]interface{}{
+			"fmt":      "none",
+			"attStmt":  map[interface{}]interface{}{},
+			"authData": authData,
+		}),
+	}, nil
+}
+
+// Sign the challenge with the credential for the relying party. If allowed credential ids are empty,
+// the first discoverable credential of the relying party is used.
+func (a *Authenticator) Get(rpId string, challenge []byte, allowCredentialIds [][]byte) (Assertion, error) {
+	credential := a.find(rpId, allowCredentialIds)
+	if credential == nil {
+		return Assertion{}, errors.New("no credential")
+	}
+	if !a.NoSignCount {
+		credential.SignCount++
+	}
+	authData := a.authenticatorData(credential, 0)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(crand.Reader, credential.key, digest[:])
+	if err != nil {
+		return Assertion{}, fmt.Errorf("sign: %w", err)
+	}
+	return Assertion{
+		CredentialId:      credential.Id,
+		ClientDataJson:    clientData,
+		AuthenticatorData: authData,
+		Signature:         signature,
+		UserHandle:        credential.UserHandle,
+	}, nil
+}
+
+func (a *Authenticator) find(rpId string, allowCredentialIds [][]byte) *Credential {
+	for _, credential := range a.Credentials {
+		if credential.RpId != rpId {
+			continue
+		}
+		if len(allowCredentialIds) == 0 {
+			return credential
+		}
+		for _, id := range allowCredentialIds {
+			if string(id) == string(credential.Id) {
+				return credential
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) authenticatorData(credential *Credential, flags byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(credential.RpId))
+	flags |= flagUserPresent
+	if a.UserVerification {
+		flags |= flagUserVerified
+	}
+	data := make([]byte, 37)
+	copy(data, rpIdHash[:])
+	data[32] = flags
+	binary.BigEndian.PutUint32(data[33:], credential.SignCount)
+	return data
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   encode(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func marshalCredential(id []byte, response map[string]string) string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":       encode(id),
+		"rawId":    encode(id),
+		"type":     "public-key",
+		"response": response,
+	})
+	return string(data)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// P-256 coordinate left padded to 32 bytes.
+func padded(coordinate []byte) []byte {
+	return append(make([]byte, 32-len(coordinate)), coordinate...)
+}
@@ -0,0 +1,52 @@
+package webauthntest
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Encode integers, byte and text strings and maps. Enough for attestation objects and COSE keys.
+func encodeCbor(value interface{}) []byte {
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return cborHeader(1, uint64(-1-v))
+		}
+		return cborHeader(0, uint64(v))
+	case []byte:
+		return append(cborHeader(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHeader(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		data := cborHeader(5, uint64(len(v)))
+		for key, item := range v {
+			data = append(data, encodeCbor(key)...)
+			data = append(data, encodeCbor(item)...)
+		}
+		return data
+	default:
+		panic(fmt.Sprintf("cbor: unsupported type %T", value))
+	}
+}
+
+func cborHeader(major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return []byte{major | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major | 24, byte(arg)}
+	case arg <= 0xffff:
+		data := []byte{major | 25, 0, 0}
+		binary.BigEndian.PutUint16(data[1:], uint16(arg))
+		return data
+	case arg <= 0xffffffff:
+		data := []byte{major | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(data[1:], uint32(arg))
+		return data
+	default:
+		data := []byte{major | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(data[1:], arg)
+		return data
+	}
+}